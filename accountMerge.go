@@ -0,0 +1,182 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//AccountMerge describes the duplicates of an account merged into it
+type AccountMerge struct {
+	Key    string `json:"key"`
+	KeptID int64  `json:"kept_id"`
+	//RemovedIDs are the duplicates deleted, their widgets now displaying the kept account
+	RemovedIDs []int64 `json:"removed_ids"`
+	Widgets    int     `json:"widgets"`
+}
+
+//findAccount returns the first account of the user with the given key
+func (app App) findAccount(ctx context.Context, userID string, key string) (api.ExternalAccount, bool, error) {
+
+	accounts, err := app.repository.GetAccounts(ctx, userID)
+	if err != nil {
+		return api.ExternalAccount{}, false, errors.Wrap(err, "retrieving accounts from datastore failed")
+	}
+
+	for _, a := range accounts {
+		if a.Key() == key {
+			return a, true, nil
+		}
+	}
+
+	return api.ExternalAccount{}, false, nil
+}
+
+//MergeDuplicateAccounts merges the accounts of the user linked several times to the same provider and address.
+//The oldest account is kept with the token of the most recent usable one, the widgets of the duplicates
+//display the kept account and the duplicates are deleted.
+func (app App) MergeDuplicateAccounts(ctx context.Context, userID string) ([]AccountMerge, error) {
+	ctx, span := tracing.Start(ctx, "App.MergeDuplicateAccounts")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "MergeDuplicateAccounts")
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := app.repository.GetAccounts(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving accounts from datastore failed")
+	}
+
+	var keys []string
+	groups := make(map[string][]api.ExternalAccount)
+	for _, a := range accounts {
+		if _, ok := groups[a.Key()]; !ok {
+			keys = append(keys, a.Key())
+		}
+		groups[a.Key()] = append(groups[a.Key()], a)
+	}
+
+	merges := []AccountMerge{}
+	for _, key := range keys {
+		if len(groups[key]) < 2 {
+			continue
+		}
+
+		merge, err := app.mergeAccounts(ctx, userID, groups[key])
+		if err != nil {
+			return merges, errors.Wrap(err, "merging accounts "+key+" failed")
+		}
+		merges = append(merges, merge)
+	}
+
+	return merges, nil
+}
+
+//mergeAccounts merges accounts with the same key into the oldest one
+func (app App) mergeAccounts(ctx context.Context, userID string, duplicates []api.ExternalAccount) (AccountMerge, error) {
+
+	kept := duplicates[0]
+	fresh := kept
+	for _, a := range duplicates {
+		if a.ID < kept.ID {
+			kept = a
+		}
+		//The most recent accounts have the highest IDs
+		if (fresh.NeedsReauth && !a.NeedsReauth) || (a.NeedsReauth == fresh.NeedsReauth && a.ID > fresh.ID) {
+			fresh = a
+		}
+	}
+
+	merge := AccountMerge{Key: kept.Key(), KeptID: kept.ID, RemovedIDs: []int64{}}
+
+	if fresh.ID != kept.ID {
+		refreshToken := ""
+		if kept.Token != nil {
+			refreshToken = kept.Token.RefreshToken
+		}
+		kept.Token = fresh.Token
+		if kept.Token != nil && len(kept.Token.RefreshToken) == 0 {
+			kept.Token.RefreshToken = refreshToken
+		}
+		kept.Scopes = fresh.Scopes
+		kept.NeedsReauth = fresh.NeedsReauth
+	}
+
+	for _, a := range duplicates {
+		if a.ID == kept.ID {
+			continue
+		}
+		if len(kept.Label) == 0 {
+			kept.Label = a.Label
+		}
+		merge.RemovedIDs = append(merge.RemovedIDs, a.ID)
+	}
+
+	//The usage is read in the transaction, so that no widget added meanwhile keeps displaying a removed account.
+	//The tokens of the duplicates are not revoked on provider side, as it may revoke the kept one too.
+	var usage []WidgetUsage
+	err := app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+		usage = nil
+		for _, id := range merge.RemovedIDs {
+			u, err := accountUsage(ctx, repo, userID, id)
+			if err != nil {
+				return errors.Wrap(err, "computing account usage failed")
+			}
+			usage = append(usage, u...)
+		}
+
+		for _, u := range usage {
+			widget, err := repo.GetWidget(ctx, u.TabID, u.WidgetID)
+			if err != nil {
+				return errors.Wrap(err, "retrieving widget failed")
+			}
+			cfg, ok := widget.Config.(api.ConfigEmail)
+			if !ok {
+				return errors.New("Invalid widget config type")
+			}
+			cfg.AccountID = kept.ID
+			widget.Config = cfg
+
+			if err := repo.StoreWidget(ctx, u.TabID, &widget); err != nil {
+				return errors.Wrap(err, "saving widget failed")
+			}
+		}
+
+		for _, id := range merge.RemovedIDs {
+			if err := repo.DeleteAccount(ctx, userID, id); err != nil {
+				return errors.Wrap(err, "removing account from datastore failed")
+			}
+		}
+
+		return repo.StoreAccount(ctx, userID, &kept)
+	})
+	if err != nil {
+		return merge, err
+	}
+	merge.Widgets = len(usage)
+
+	for _, id := range merge.RemovedIDs {
+		app.audit(ctx, userID, api.AuditAccountRevoked, fmt.Sprintf("account:%d", id))
+	}
+	app.audit(ctx, userID, api.AuditAccountUpdated, fmt.Sprintf("account:%d", kept.ID))
+
+	published := make(map[int64]bool)
+	for _, u := range usage {
+		if !published[u.TabID] {
+			app.publishLayout(userID, u.TabID)
+			published[u.TabID] = true
+		}
+	}
+
+	return merge, nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//ActivitiesCount is the number of change reports returned by the activity API
+const ActivitiesCount = 50
+
+//RunSnapshotDiff reports the configuration changes of the users at the given interval, until the context is done
+func (app App) RunSnapshotDiff(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := app.DiffUserSnapshots(ctx); err != nil {
+			app.Error(ctx, errors.Wrap(err, "snapshot diff failed"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//DiffUserSnapshots compares, for all the users, the current configuration with the one seen by the previous run
+//and stores a change report when they differ
+func (app App) DiffUserSnapshots(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "App.DiffUserSnapshots")
+	defer span.End()
+
+	var reports int
+
+	page := api.PageRequest{}
+	for {
+		users, next, err := app.repository.GetUsersPage(ctx, page)
+		if err != nil {
+			return errors.Wrap(err, "retrieving users from datastore failed")
+		}
+
+		for _, u := range users {
+			reported, err := app.diffUserSnapshot(ctx, u.UserID)
+			if err != nil {
+				return errors.Wrap(err, "diffing snapshots of user "+u.UserID+" failed")
+			}
+			if reported {
+				reports++
+			}
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		page.Cursor = next
+	}
+
+	if reports > 0 {
+		app.Infof(ctx, "Snapshot diff reported changes for %d user(s)", reports)
+	}
+
+	return nil
+}
+
+//diffUserSnapshot stores a change report if the configuration of the user changed since the last snapshot
+func (app App) diffUserSnapshot(ctx context.Context, userID string) (bool, error) {
+
+	current, err := app.snapshot(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	reported := false
+	previous, err := app.repository.GetLastSnapshot(ctx, userID)
+	if err == nil {
+		changes := api.DiffSnapshots(previous.Snapshot, current)
+		if len(changes) > 0 {
+			activity := api.Activity{
+				UserID:  userID,
+				Created: time.Now(),
+				Changes: changes,
+			}
+			err = app.repository.StoreActivity(ctx, &activity)
+			if err != nil {
+				return false, errors.Wrap(err, "storing activity in datastore failed")
+			}
+			reported = true
+
+			//Only the reports returned by the activity API are kept
+			_, err = app.repository.DeleteOldActivities(ctx, userID, ActivitiesCount)
+			if err != nil {
+				return false, errors.Wrap(err, "removing old activities from datastore failed")
+			}
+		}
+	} else if !app.repository.IsNotFound(err) {
+		return false, errors.Wrap(err, "retrieving last snapshot from datastore failed")
+	}
+
+	err = app.repository.StoreLastSnapshot(ctx, api.StoredSnapshot{
+		UserID:   userID,
+		Created:  time.Now(),
+		Snapshot: current,
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "storing last snapshot in datastore failed")
+	}
+
+	return reported, nil
+}
+
+//Activity returns the latest change reports of the configuration of the given user
+func (app App) Activity(ctx context.Context, userID string) ([]api.Activity, error) {
+	ctx, span := tracing.Start(ctx, "App.Activity")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "Activity")
+	if err != nil {
+		return nil, err
+	}
+
+	activities, err := app.repository.GetActivities(ctx, userID, ActivitiesCount)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving activities from datastore failed")
+	}
+
+	for i, a := range activities {
+		for _, c := range a.Changes {
+			activities[i].Summary = append(activities[i].Summary, c.String())
+		}
+	}
+
+	return activities, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//MostReadFeedsCount is the number of feeds listed in the feed statistics
+const MostReadFeedsCount = 10
+
+//checkAdmin returns an error if the current user is not an administrator
+func (app App) checkAdmin(ctx context.Context) error {
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+		return errors.Wrap(notAuthorized("admin access required"), "access by "+loggedInUserID)
+	}
+
+	return nil
+}
+
+//checkUserAccess returns an error if the current user is neither the given user nor an admin
+func (app App) checkUserAccess(ctx context.Context, userID string, target string) error {
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+			return errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+		}
+		app.audit(ctx, userID, api.AuditAdminAccess, target)
+	}
+
+	return nil
+}
+
+//Users returns a page of the users of the instance with their statistics. Admin only.
+func (app App) Users(ctx context.Context, page api.PageRequest) (api.UserStatsPage, error) {
+	ctx, span := tracing.Start(ctx, "App.Users")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return api.UserStatsPage{}, err
+	}
+
+	users, next, err := app.repository.GetUsersPage(ctx, page)
+	if err != nil {
+		return api.UserStatsPage{}, errors.Wrap(err, "retrieving users from datastore failed")
+	}
+
+	result := api.UserStatsPage{
+		Users: make([]api.UserStats, len(users)),
+		Next:  next,
+	}
+	for i, u := range users {
+		stats, err := app.repository.GetUserStats(ctx, u.UserID)
+		if err != nil {
+			return api.UserStatsPage{}, errors.Wrap(err, "retrieving statistics of user "+u.UserID+" failed")
+		}
+		stats.User = u
+		result.Users[i] = stats
+	}
+
+	return result, nil
+}
+
+//UserStats returns the statistics of the given user. Admin only.
+func (app App) UserStats(ctx context.Context, userID string) (api.UserStats, error) {
+	ctx, span := tracing.Start(ctx, "App.UserStats")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return api.UserStats{}, err
+	}
+
+	user, err := app.repository.GetUser(ctx, userID)
+	if err != nil {
+		return api.UserStats{}, errors.Wrap(err, "retrieving user from datastore failed")
+	}
+
+	stats, err := app.repository.GetUserStats(ctx, userID)
+	if err != nil {
+		return api.UserStats{}, errors.Wrap(err, "retrieving user statistics from datastore failed")
+	}
+	stats.User = user
+
+	return stats, nil
+}
+
+//FeedStats returns the global statistics of the feeds. Admin only.
+func (app App) FeedStats(ctx context.Context) (api.FeedStats, error) {
+	ctx, span := tracing.Start(ctx, "App.FeedStats")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return api.FeedStats{}, err
+	}
+
+	stats, err := app.repository.GetFeedStats(ctx)
+	if err != nil {
+		return api.FeedStats{}, errors.Wrap(err, "retrieving feed statistics from datastore failed")
+	}
+
+	feedIDs, err := app.repository.GetMostReadFeedIDs(ctx, "", MostReadFeedsCount)
+	if err != nil {
+		return api.FeedStats{}, errors.Wrap(err, "retrieving most read feeds from datastore failed")
+	}
+	for _, feedID := range feedIDs {
+		feed, err := app.repository.GetFeed(ctx, feedID)
+		if err != nil {
+			return api.FeedStats{}, errors.Wrap(err, "retrieving feed from datastore failed")
+		}
+		stats.MostRead = append(stats.MostRead, feed)
+	}
+
+	return stats, nil
+}
+
+//LockStats returns the contention on the lock of the repository, empty if the repository has no lock. Admin only.
+func (app App) LockStats(ctx context.Context) (api.LockStats, error) {
+	ctx, span := tracing.Start(ctx, "App.LockStats")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return api.LockStats{}, err
+	}
+
+	inspector, ok := app.repository.(api.LockInspector)
+	if !ok {
+		return api.LockStats{Held: []api.LockHolder{}, Waiting: []api.LockHolder{}, Waits: []api.LockWait{}}, nil
+	}
+
+	stats, err := inspector.LockStats()
+	if err != nil {
+		return api.LockStats{}, errors.Wrap(err, "retrieving lock statistics failed")
+	}
+
+	return stats, nil
+}
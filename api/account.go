@@ -7,6 +7,9 @@ package api
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
 	"golang.org/x/oauth2"
 )
@@ -27,12 +30,54 @@ type ProviderDescription struct {
 	Title             string    `json:"title"`
 	Link              string    `json:"link"`
 	AvailableServices []Service `json:"services"`
+
+	//ComposeLink, ReplyLink and ForwardLink are the URL templates of the provider UI for email actions.
+	//"{guid}" is replaced by the GUID of the email.
+	ComposeLink string `json:"compose_link,omitempty"`
+	ReplyLink   string `json:"reply_link,omitempty"`
+	ForwardLink string `json:"forward_link,omitempty"`
+}
+
+//EmailLinks returns the action links of the email with the given GUID
+func (d ProviderDescription) EmailLinks(guid string) EmailLinks {
+
+	expand := func(template string) string {
+		return strings.Replace(template, "{guid}", url.QueryEscape(guid), -1)
+	}
+
+	return EmailLinks{
+		Compose: expand(d.ComposeLink),
+		Reply:   expand(d.ReplyLink),
+		Forward: expand(d.ForwardLink),
+	}
 }
 
+//Feature is a capability of a service that may require specific authorizations
+type Feature string
+
+const (
+	//FeatureRead allows reading data from the service
+	FeatureRead Feature = "read"
+	//FeatureModify allows updating data on the service (such as archiving an email)
+	FeatureModify Feature = "modify"
+)
+
 //Provider is the interface to be implemented by service provider libraries
 type Provider interface {
 	Description() ProviderDescription
+	//Config returns the OAuth2 configuration requesting the scopes for FeatureRead
 	Config() *oauth2.Config
+	//Scopes returns the OAuth2 scopes required to use the given feature
+	Scopes(feature Feature) []string
+	//Revoke invalidates the token of the account on the provider side.
+	//The providers without revocation endpoint do nothing.
+	Revoke(ctx context.Context, account ExternalAccount) error
+}
+
+//A CodeExchanger is a provider exchanging itself the OAuth2 codes for tokens,
+//such as when its calls are recorded or replayed
+type CodeExchanger interface {
+	Exchange(ctx context.Context, code string) (*oauth2.Token, error)
 }
 
 //Category represents a group of related emails (it can be a folder or a tag based on the provider)
@@ -50,8 +95,45 @@ type EmailProvider interface {
 	//GetAvailableCategories(ctx context.Context, account ExternalAccount) ([]Category, error)
 
 	GetItems(ctx context.Context, account ExternalAccount, q EmailQuery, pageToken *string) (*EmailPage, error)
+
+	MarkRead(ctx context.Context, account ExternalAccount, guid string, read bool) error
+	Archive(ctx context.Context, account ExternalAccount, guid string) error
+	Trash(ctx context.Context, account ExternalAccount, guid string) error
 }
 
+//A PushProvider is an email provider able to notify changes in an account
+type PushProvider interface {
+	EmailProvider
+
+	//Watch starts (or renews) the change notifications for the given account, until the returned expiration.
+	//It does nothing if push notifications are not configured.
+	Watch(ctx context.Context, account ExternalAccount) (time.Time, error)
+	//ParsePush authenticates and decodes a notification received on the push endpoint
+	ParsePush(ctx context.Context, token string, body []byte) (PushNotification, error)
+}
+
+//PushNotification tells that the email account changed
+type PushNotification struct {
+	//AccountID is the address of the email account
+	AccountID string
+	//Version is the version of the account after the change
+	Version uint64
+}
+
+//EmailAction is an operation to be applied on an email or conversation
+type EmailAction string
+
+const (
+	//EmailActionMarkRead marks the email as read
+	EmailActionMarkRead EmailAction = "mark_read"
+	//EmailActionMarkUnread marks the email as unread
+	EmailActionMarkUnread EmailAction = "mark_unread"
+	//EmailActionArchive removes the email from the inbox without deleting it
+	EmailActionArchive EmailAction = "archive"
+	//EmailActionTrash moves the email to the trash
+	EmailActionTrash EmailAction = "trash"
+)
+
 //A SocialFeedProvider is provider related to social feeds service
 type SocialFeedProvider interface {
 	Provider
@@ -65,11 +147,44 @@ type EmailItem struct {
 
 	From    string `json:"from" db:"sender"`
 	Snippet string `json:"snippet" db:"snippet"`
+	//Version increases each time the item changes on the provider side
+	Version uint64 `json:"-" db:"version"`
+
+	HasAttachments bool         `json:"has_attachments" db:"has_attachments"`
+	Attachments    []Attachment `json:"attachments,omitempty" db:"-"`
+
+	Links EmailLinks `json:"links" db:"-"`
+}
+
+//EmailLinks are the links opening the provider UI to act on an email
+type EmailLinks struct {
+	Compose string `json:"compose,omitempty"`
+	Reply   string `json:"reply,omitempty"`
+	Forward string `json:"forward,omitempty"`
+}
+
+//Attachment describes a file attached to an email
+type Attachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+//EmailSync is the result of the last background synchronization of an inbox.
+//The items themselves are cached as EmailItem.
+type EmailSync struct {
+	AccountID          int64     `json:"account_id" db:"account_id"`
+	Synced             time.Time `json:"synced" db:"synced"`
+	GUIDs              []string  `json:"guids"`
+	NextPageToken      string    `json:"nextpage,omitempty" db:"next_page"`
+	ResultSizeEstimate int64     `json:"result_size_estimate" db:"result_size_estimate"`
 }
 
 //EmailQuery contains the request parameter when retrieving data from a provider
 type EmailQuery struct {
 	Category string `json:"category"`
+	//Query is a free-text search using the provider syntax (such as "from:boss is:unread")
+	Query string `json:"query,omitempty"`
 }
 
 //EmailPage is a batch of results for a query
@@ -77,6 +192,8 @@ type EmailPage struct {
 	Items              []EmailItem `json:"items"`
 	NextPageToken      string      `json:"nextpage,omitempty"`
 	ResultSizeEstimate int64       `json:"result_size_estimate"`
+	//Counts are the result size estimates of each category, when several categories are merged
+	Counts map[string]int64 `json:"counts,omitempty"`
 }
 
 //ExternalAccount is the basic information required to access an account on external service
@@ -85,9 +202,51 @@ type ExternalAccount struct {
 	ProviderName string        `json:"provider_name" db:"provider"`
 	AccountID    string        `json:"account_id" db:"account_id"`
 	Token        *oauth2.Token `json:"-" db:"token"`
+	Scopes       []string      `json:"scopes"`
+	//NeedsReauth is set when the provider revoked the token, until the user authorizes the account again
+	NeedsReauth bool `json:"needs_reauth" db:"needs_reauth"`
+	//Label is the name given by the user to the account (such as "Work Gmail")
+	Label string `json:"label,omitempty" db:"label"`
 }
 
 //Key returns a unique key for the account
 func (a ExternalAccount) Key() string {
 	return fmt.Sprintf("%s-%s", a.ProviderName, a.AccountID)
 }
+
+//DisplayName returns the label of the account, or its email address if it has no label
+func (a ExternalAccount) DisplayName() string {
+	if len(a.Label) > 0 {
+		return a.Label
+	}
+	return a.AccountID
+}
+
+//HasScopes returns true if all the given scopes have been granted for the account
+func (a ExternalAccount) HasScopes(scopes []string) bool {
+	for _, scope := range scopes {
+		granted := false
+		for _, s := range a.Scopes {
+			if strings.EqualFold(s, scope) {
+				granted = true
+				break
+			}
+		}
+		if !granted {
+			return false
+		}
+	}
+	return true
+}
+
+//TemporaryCode is the state shared with a provider during an OAuth2 flow
+type TemporaryCode struct {
+	Code         string `db:"code"`
+	UserID       string `db:"user_id"`
+	ProviderName string `db:"provider"`
+	//AccountID is the existing account being authorized again, or 0 for a new account
+	AccountID int64 `db:"account_id"`
+	//WidgetTabID is the tab on which an email widget of the new account is created, or 0 for none
+	WidgetTabID int64     `db:"widget_tab_id"`
+	Created     time.Time `db:"created"`
+}
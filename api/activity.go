@@ -0,0 +1,186 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+//A StoredSnapshot is the last snapshot of a user taken by the change report job
+type StoredSnapshot struct {
+	UserID   string
+	Created  time.Time
+	Snapshot Snapshot
+}
+
+//ChangeKind tells how an object changed between two snapshots
+type ChangeKind string
+
+//Kinds of changes
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	ChangeUpdated ChangeKind = "updated"
+)
+
+//A SnapshotChange is the change of a tab, widget, feed or account between two snapshots
+type SnapshotChange struct {
+	Kind ChangeKind `json:"kind"`
+	//Object is the kind of the changed object: "tab", "widget", "feed", "account" or "starred item"
+	Object string `json:"object"`
+	Name   string `json:"name"`
+	//Tab is the title of the tab holding a changed widget
+	Tab     string `json:"tab,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+//String returns a human-readable description of the change
+func (c SnapshotChange) String() string {
+	s := fmt.Sprintf("%s %q %s", c.Object, c.Name, c.Kind)
+	if len(c.Tab) > 0 {
+		s += fmt.Sprintf(" in tab %q", c.Tab)
+	}
+	if len(c.Details) > 0 {
+		s += " (" + c.Details + ")"
+	}
+	return s
+}
+
+//An Activity is a change report of the configuration of a user
+type Activity struct {
+	ID      int64            `json:"id"`
+	UserID  string           `json:"user_id"`
+	Created time.Time        `json:"created"`
+	Changes []SnapshotChange `json:"changes"`
+	//Summary is the human-readable version of the changes
+	Summary []string `json:"summary,omitempty"`
+}
+
+//WidgetTitle returns the title of a widget, or a name based on its ID if untitled
+func WidgetTitle(w Widget) string {
+	var title string
+	switch cfg := w.Config.(type) {
+	case ConfigFeed:
+		title = cfg.Title
+	case ConfigEmail:
+		title = cfg.Title
+	}
+	if len(title) == 0 {
+		return fmt.Sprintf("%s #%d", w.Type, w.ID)
+	}
+	return title
+}
+
+//DiffSnapshots lists the changes of the tabs, widgets, feeds and accounts between two snapshots of a user.
+//Objects are matched by ID.
+func DiffSnapshots(previous, current Snapshot) []SnapshotChange {
+
+	var changes []SnapshotChange
+
+	//Tabs
+	previousTabs := make(map[int64]Tab)
+	for _, t := range previous.Tabs {
+		previousTabs[t.ID] = t
+	}
+	currentTabs := make(map[int64]Tab)
+	for _, t := range current.Tabs {
+		currentTabs[t.ID] = t
+	}
+	for _, t := range current.Tabs {
+		old, ok := previousTabs[t.ID]
+		if !ok {
+			changes = append(changes, SnapshotChange{Kind: ChangeAdded, Object: "tab", Name: t.Title})
+			continue
+		}
+		if old.Title != t.Title {
+			changes = append(changes, SnapshotChange{Kind: ChangeUpdated, Object: "tab", Name: t.Title, Details: fmt.Sprintf("renamed from %q", old.Title)})
+		}
+	}
+	for _, t := range previous.Tabs {
+		if _, ok := currentTabs[t.ID]; !ok {
+			changes = append(changes, SnapshotChange{Kind: ChangeRemoved, Object: "tab", Name: t.Title})
+		}
+	}
+
+	//Widgets
+	widgets := func(s Snapshot) map[int64]Widget {
+		m := make(map[int64]Widget)
+		for _, t := range s.Tabs {
+			for _, col := range t.Widgets {
+				for _, w := range col {
+					m[w.ID] = w
+				}
+			}
+		}
+		return m
+	}
+	previousWidgets := widgets(previous)
+	currentWidgets := widgets(current)
+	for _, t := range current.Tabs {
+		for _, col := range t.Widgets {
+			for _, w := range col {
+				old, ok := previousWidgets[w.ID]
+				if !ok {
+					changes = append(changes, SnapshotChange{Kind: ChangeAdded, Object: "widget", Name: WidgetTitle(w), Tab: t.Title})
+					continue
+				}
+				oldConfig, _ := json.Marshal(old.Config)
+				newConfig, _ := json.Marshal(w.Config)
+				if string(oldConfig) != string(newConfig) {
+					changes = append(changes, SnapshotChange{Kind: ChangeUpdated, Object: "widget", Name: WidgetTitle(w), Tab: t.Title, Details: "configuration changed"})
+				}
+			}
+		}
+	}
+	for _, t := range previous.Tabs {
+		for _, col := range t.Widgets {
+			for _, w := range col {
+				if _, ok := currentWidgets[w.ID]; !ok {
+					changes = append(changes, SnapshotChange{Kind: ChangeRemoved, Object: "widget", Name: WidgetTitle(w), Tab: t.Title})
+				}
+			}
+		}
+	}
+
+	//Feeds
+	previousFeeds := make(map[int64]Feed)
+	for _, f := range previous.Feeds {
+		previousFeeds[f.ID] = f
+	}
+	currentFeeds := make(map[int64]Feed)
+	for _, f := range current.Feeds {
+		currentFeeds[f.ID] = f
+		if _, ok := previousFeeds[f.ID]; !ok {
+			changes = append(changes, SnapshotChange{Kind: ChangeAdded, Object: "feed", Name: f.URL})
+		}
+	}
+	for _, f := range previous.Feeds {
+		if _, ok := currentFeeds[f.ID]; !ok {
+			changes = append(changes, SnapshotChange{Kind: ChangeRemoved, Object: "feed", Name: f.URL})
+		}
+	}
+
+	//Accounts
+	previousAccounts := make(map[string]bool)
+	for _, a := range previous.Accounts {
+		previousAccounts[a.Key()] = true
+	}
+	currentAccounts := make(map[string]bool)
+	for _, a := range current.Accounts {
+		currentAccounts[a.Key()] = true
+		if !previousAccounts[a.Key()] {
+			changes = append(changes, SnapshotChange{Kind: ChangeAdded, Object: "account", Name: a.DisplayName()})
+		}
+	}
+	for _, a := range previous.Accounts {
+		if !currentAccounts[a.Key()] {
+			changes = append(changes, SnapshotChange{Kind: ChangeRemoved, Object: "account", Name: a.DisplayName()})
+		}
+	}
+
+	return changes
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"time"
+)
+
+//UserStats gives the amount of data owned by a user
+type UserStats struct {
+	User
+	Tabs     int64 `json:"tabs" db:"tabs"`
+	Widgets  int64 `json:"widgets" db:"widgets"`
+	Accounts int64 `json:"accounts" db:"accounts"`
+}
+
+//A UserStatsPage is a page of the user listing.
+//Next is the cursor of the following page, empty on the last page.
+type UserStatsPage struct {
+	Users []UserStats `json:"users"`
+	Next  string      `json:"next,omitempty"`
+}
+
+//FeedStats gives global statistics on the feeds of the instance
+type FeedStats struct {
+	Feeds     int64 `json:"feeds" db:"feeds"`
+	Items     int64 `json:"items" db:"items"`
+	Readers   int64 `json:"readers" db:"readers"`
+	ReadItems int64 `json:"read_items" db:"read_items"`
+	//MostRead lists the feeds with the most readers first
+	MostRead []Feed `json:"most_read,omitempty" db:"-"`
+}
+
+//LockWaitBuckets are the upper bounds of the buckets of the lock wait time histograms
+var LockWaitBuckets = []time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond, time.Second, 10 * time.Second}
+
+//LockWait is the histogram of the time spent waiting for the lock by a repository method
+type LockWait struct {
+	Method string `json:"method"`
+	Count  int64  `json:"count"`
+	//Total is the sum of the wait times
+	Total time.Duration `json:"total_ns"`
+	Max   time.Duration `json:"max_ns"`
+	//Buckets counts the waits per bucket of LockWaitBuckets, the last one counting the longer waits
+	Buckets []int64 `json:"buckets"`
+	//Timeouts counts the calls which gave up waiting for the lock, not counted in the waits
+	Timeouts int64 `json:"timeouts"`
+}
+
+//LockHolder is a call of a repository method holding or waiting for the lock
+type LockHolder struct {
+	//Call is the method and its arguments
+	Call  string    `json:"call"`
+	Write bool      `json:"write"`
+	Since time.Time `json:"since"`
+}
+
+//LockStats describes the contention on the lock of a repository
+type LockStats struct {
+	Held    []LockHolder `json:"held"`
+	Waiting []LockHolder `json:"waiting"`
+	Waits   []LockWait   `json:"waits"`
+}
+
+//LockInspector is implemented by the repositories serializing their calls with a lock
+type LockInspector interface {
+	LockStats() (LockStats, error)
+}
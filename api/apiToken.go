@@ -0,0 +1,31 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"time"
+)
+
+//APITokenPrefix starts all the personal API tokens, to distinguish them from other bearer tokens
+const APITokenPrefix = "oki_"
+
+//An APIToken is a personal token allowing programmatic access to the API on behalf of a user.
+//Only a hash of the token is stored.
+type APIToken struct {
+	ID     int64  `json:"id" db:"id"`
+	UserID string `json:"user_id" db:"user_id"`
+	Name   string `json:"name" db:"name"`
+	//Hint is the beginning of the token, to help the user recognize it
+	Hint     string    `json:"hint" db:"hint"`
+	Hash     string    `json:"-" db:"hash"`
+	Created  time.Time `json:"created" db:"created"`
+	LastUsed time.Time `json:"last_used,omitempty" db:"last_used"`
+}
+
+//A NewAPIToken is a newly created API token, with its secret value only available at creation
+type NewAPIToken struct {
+	APIToken
+	Token string `json:"token"`
+}
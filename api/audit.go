@@ -0,0 +1,99 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+//AuditAction identifies the kind of an audited event
+type AuditAction string
+
+//Audited actions
+const (
+	AuditLogin                AuditAction = "login"
+	AuditTokenLogin           AuditAction = "token_login"
+	AuditTabCreated           AuditAction = "tab_created"
+	AuditTabUpdated           AuditAction = "tab_updated"
+	AuditTabDeleted           AuditAction = "tab_deleted"
+	AuditWidgetCreated        AuditAction = "widget_created"
+	AuditWidgetUpdated        AuditAction = "widget_updated"
+	AuditWidgetDeleted        AuditAction = "widget_deleted"
+	AuditLayoutUpdated        AuditAction = "layout_updated"
+	AuditAccountLinked        AuditAction = "account_linked"
+	AuditAccountUpdated       AuditAction = "account_updated"
+	AuditAccountRevoked       AuditAction = "account_revoked"
+	AuditTokenCreated         AuditAction = "token_created"
+	AuditTokenRevoked         AuditAction = "token_revoked"
+	AuditPolicyUpdated        AuditAction = "policy_updated"
+	AuditRetentionUpdated     AuditAction = "retention_updated"
+	AuditKeysRotated          AuditAction = "keys_rotated"
+	AuditUserDeleted          AuditAction = "user_deleted"
+	AuditWebhookChanged       AuditAction = "webhook_changed"
+	AuditNotificationsChanged AuditAction = "notifications_changed"
+	AuditTabShared            AuditAction = "tab_shared"
+	AuditUserBackedUp         AuditAction = "user_backed_up"
+	AuditUserRestored         AuditAction = "user_restored"
+	AuditAdminAccess          AuditAction = "admin_access"
+)
+
+//An AuditEvent records a login, a configuration change, a backup, an export of a tab to share it,
+//or the access of an admin to the data of another user
+type AuditEvent struct {
+	//ID is set once the event is stored in the audit log
+	ID   int64     `json:"id,omitempty"`
+	Time time.Time `json:"time"`
+	//ActorID is the user doing the action
+	ActorID string `json:"actor_id"`
+	//UserID is the user owning the changed data, if any
+	UserID string      `json:"user_id,omitempty"`
+	Action AuditAction `json:"action"`
+	//Target identifies the changed object, such as "tab:12".
+	//The target of an admin access is the method called, with the tab if its owner is not known.
+	Target string `json:"target,omitempty"`
+}
+
+//AuditFilter selects the events of the audit log, the empty fields selecting all the events
+type AuditFilter struct {
+	UserID  string
+	ActorID string
+	Action  AuditAction
+	//Since and Until bound the time of the events, Until being excluded
+	Since time.Time
+	Until time.Time
+}
+
+//An AuditEventPage is a page of the audit log, most recent first.
+//Next is the cursor of the following page, empty on the last page.
+type AuditEventPage struct {
+	Events []AuditEvent `json:"events"`
+	Next   string       `json:"next,omitempty"`
+}
+
+//An AuditSink forwards the audit events outside of the application, such as to a SIEM
+type AuditSink interface {
+	//Send forwards a batch of events
+	Send(ctx context.Context, events []AuditEvent) error
+}
+
+//SignAuditBatch returns the JSON encoding of the events
+//and its HMAC-SHA256 signature with the given secret, hex encoded.
+func SignAuditBatch(events []AuditEvent, secret string) ([]byte, string, error) {
+
+	payload, err := json.Marshal(events)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return payload, hex.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,27 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+)
+
+//A Blob is a binary asset, such as a favicon or a thumbnail
+type Blob struct {
+	ContentType string
+	Data        []byte
+}
+
+//BlobStore is the interface allowing usage of any storage for cached assets and backups.
+//Keys are slash separated paths, such as "favicons/42".
+type BlobStore interface {
+	Get(ctx context.Context, key string) (Blob, error)
+	Put(ctx context.Context, key string, blob Blob) error
+	Delete(ctx context.Context, key string) error
+	//List returns the keys starting with the given prefix, in lexical order
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	IsNotFound(err error) bool
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+//EventType identifies the kind of a dashboard update
+type EventType string
+
+//Dashboard updates
+const (
+	//EventFeedItems is sent when new items of a feed were retrieved
+	EventFeedItems EventType = "feed_items"
+	//EventEmails is sent when the cached emails of an account changed
+	EventEmails EventType = "emails"
+	//EventLayout is sent when tabs or widgets of the user changed
+	EventLayout EventType = "layout"
+	//EventError is sent on a WebSocket when a command of the client failed
+	EventError EventType = "error"
+)
+
+//An Event notifies an open dashboard that some of its content should be reloaded
+type Event struct {
+	Type      EventType `json:"type"`
+	TabID     int64     `json:"tab_id,omitempty"`
+	FeedID    int64     `json:"feed_id,omitempty"`
+	AccountID int64     `json:"account_id,omitempty"`
+	//Error is the reason of the failure of a command
+	Error string `json:"error,omitempty"`
+}
+
+//CommandType identifies the kind of a command sent by a client on a WebSocket
+type CommandType string
+
+//Client commands
+const (
+	//CommandMarkRead marks the given items of a feed as read
+	CommandMarkRead CommandType = "mark_read"
+	//CommandRefreshWidget retrieves the latest content of a widget, the update being notified by an event
+	CommandRefreshWidget CommandType = "refresh_widget"
+)
+
+//A Command is a request sent by a client on a WebSocket
+type Command struct {
+	Type     CommandType `json:"type"`
+	FeedID   int64       `json:"feed_id,omitempty"`
+	GUIDs    []string    `json:"guids,omitempty"`
+	TabID    int64       `json:"tab_id,omitempty"`
+	WidgetID int64       `json:"widget_id,omitempty"`
+}
@@ -5,6 +5,7 @@
 package api
 
 import (
+	"strings"
 	"time"
 )
 
@@ -23,6 +24,37 @@ type FeedItem struct {
 	Title     string    `json:"title" db:"title"`
 	Published time.Time `json:"published" db:"published"`
 	Link      string    `json:"link" db:"link"`
+	//ImageURL is the image illustrating the item in the feed, if any, served as a thumbnail by okihome
+	ImageURL string `json:"-" db:"image_url"`
+	//Thumbnail is the okihome page serving the cached image of the item
+	Thumbnail string `json:"thumbnail,omitempty" db:"-"`
+	//ArchiveLink points to the archived copies of the linked article
+	ArchiveLink string `json:"archive_link,omitempty" db:"-"`
+	//Abstract is a short summary of the linked article, for widgets with summaries enabled
+	Abstract string `json:"abstract,omitempty" db:"-"`
+}
+
+//ArchiveURLPrefix is the prefix of the web.archive.org links,
+//the archive redirecting to the most recent snapshot of the appended URL
+const ArchiveURLPrefix = "https://web.archive.org/web/"
+
+//ArchiveLink returns the link to the archived copies of the given URL, if it can be archived
+func ArchiveLink(link string) string {
+	if !strings.HasPrefix(link, "http://") && !strings.HasPrefix(link, "https://") {
+		return ""
+	}
+	if strings.HasPrefix(link, ArchiveURLPrefix) {
+		return link
+	}
+	return ArchiveURLPrefix + link
+}
+
+//A FeedDigest groups the recent items of a feed in a single entry
+type FeedDigest struct {
+	Since     time.Time     `json:"since"`
+	Count     int           `json:"count"`
+	Unread    int           `json:"unread"`
+	Headlines []ItemForUser `json:"headlines"`
 }
 
 //An ItemForUser is a feed item with reading status for a given user added
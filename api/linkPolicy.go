@@ -0,0 +1,73 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"net/url"
+	"strings"
+)
+
+//LinkAction is what is done with the links matching a policy
+type LinkAction string
+
+const (
+	//LinkAllow keeps the link as is
+	LinkAllow LinkAction = "allow"
+	//LinkDeny removes the link
+	LinkDeny LinkAction = "deny"
+	//LinkRewrite replaces the link using the rewrite template
+	LinkRewrite LinkAction = "rewrite"
+)
+
+//A LinkPolicy applies an action on the outbound links to a domain (and its subdomains).
+//The domain "*" matches any link.
+type LinkPolicy struct {
+	Domain string     `json:"domain"`
+	Action LinkAction `json:"action"`
+	//Rewrite is the template of the new link, "{url}" being replaced by the escaped original link
+	//(such as "https://web.archive.org/web/{url}")
+	Rewrite string `json:"rewrite,omitempty"`
+}
+
+//Matches returns true if the policy applies to the given host
+func (p LinkPolicy) Matches(host string) bool {
+	if p.Domain == "*" {
+		return true
+	}
+	domain := strings.ToLower(strings.TrimPrefix(p.Domain, "."))
+	host = strings.ToLower(host)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+//ApplyLinkPolicies returns the link transformed by the first matching policy.
+//An empty string is returned if the link is denied, and links matching no policy are kept.
+func ApplyLinkPolicies(policies []LinkPolicy, link string) string {
+
+	if len(policies) == 0 || len(link) == 0 {
+		return link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || len(u.Host) == 0 {
+		return link
+	}
+
+	for _, p := range policies {
+		if !p.Matches(u.Hostname()) {
+			continue
+		}
+
+		switch p.Action {
+		case LinkDeny:
+			return ""
+		case LinkRewrite:
+			return strings.Replace(p.Rewrite, "{url}", url.QueryEscape(link), -1)
+		default:
+			return link
+		}
+	}
+
+	return link
+}
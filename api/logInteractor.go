@@ -6,14 +6,70 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"strings"
 )
 
-//LogInteractor allows logging of application messages
+type requestIDKey struct{}
+
+//ContextWithRequestID returns a context carrying the identifier of the request being handled,
+//for the log lines to be correlated
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+//RequestIDFromContext returns the identifier set by ContextWithRequestID, if any
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
+//LogInteractor allows logging of application messages.
+//The lines logged within a request include the identifier of the request.
 type LogInteractor interface {
+	// Debugf is like Infof, but at Debug level, for the details only useful when investigating a problem.
+	Debugf(ctx context.Context, format string, args ...interface{})
+
 	// Infof formats its arguments according to the format, analogous to fmt.Printf,
 	// and records the text as a log message at Info level.
 	Infof(ctx context.Context, format string, args ...interface{})
 
+	// Warnf is like Infof, but at Warning level.
+	Warnf(ctx context.Context, format string, args ...interface{})
+
 	// Errorf is like Infof, but at Error level.
 	Errorf(ctx context.Context, format string, args ...interface{})
 }
+
+//LogLevel is the severity of a log message
+type LogLevel int
+
+//Log levels, by increasing severity
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var logLevelNames = []string{"debug", "info", "warning", "error"}
+
+func (l LogLevel) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+	return logLevelNames[l]
+}
+
+//ParseLogLevel returns the level of its name (debug, info, warning or error), Info level if empty
+func ParseLogLevel(s string) (LogLevel, error) {
+	if len(s) == 0 {
+		return LevelInfo, nil
+	}
+	for i, name := range logLevelNames {
+		if strings.EqualFold(s, name) || (name == "warning" && strings.EqualFold(s, "warn")) {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"time"
+)
+
+//A ManagedPolicy restricts the feeds and providers a managed (child) account can use.
+//The policy is curated by a manager (parent) account.
+type ManagedPolicy struct {
+	UserID           string   `json:"user_id" db:"user_id"`
+	ManagerID        string   `json:"manager_id" db:"manager_id"`
+	AllowedFeeds     []string `json:"allowed_feeds"`
+	AllowedProviders []string `json:"allowed_providers"`
+}
+
+//IsFeedAllowed returns true if the feed at the given URL can be used
+func (p ManagedPolicy) IsFeedAllowed(URL string) bool {
+	for _, u := range p.AllowedFeeds {
+		if u == URL {
+			return true
+		}
+	}
+	return false
+}
+
+//IsProviderAllowed returns true if the provider with the given name can be used
+func (p ManagedPolicy) IsProviderAllowed(providerName string) bool {
+	for _, n := range p.AllowedProviders {
+		if n == providerName {
+			return true
+		}
+	}
+	return false
+}
+
+//ApprovalKind is the kind of resource a managed account asks access for
+type ApprovalKind string
+
+const (
+	//ApprovalFeed is used when requesting access to a feed URL
+	ApprovalFeed ApprovalKind = "feed"
+	//ApprovalProvider is used when requesting access to a service provider
+	ApprovalProvider ApprovalKind = "provider"
+)
+
+//ApprovalStatus is the state of an approval request
+type ApprovalStatus string
+
+const (
+	//ApprovalPending is the status of a request not reviewed yet
+	ApprovalPending ApprovalStatus = "pending"
+	//ApprovalGranted is the status of an accepted request
+	ApprovalGranted ApprovalStatus = "approved"
+	//ApprovalRejected is the status of a refused request
+	ApprovalRejected ApprovalStatus = "rejected"
+)
+
+//An ApprovalRequest is a request from a managed account to add a feed or a provider to its policy
+type ApprovalRequest struct {
+	ID        int64          `json:"id" db:"id"`
+	UserID    string         `json:"user_id" db:"user_id"`
+	Kind      ApprovalKind   `json:"kind" db:"kind"`
+	Value     string         `json:"value" db:"value"`
+	Status    ApprovalStatus `json:"status" db:"status"`
+	Requested time.Time      `json:"requested" db:"requested"`
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"time"
+)
+
+//NotificationChannel is a way of notifying a user
+type NotificationChannel string
+
+//Notification channels
+const (
+	NotificationEmail   NotificationChannel = "email"
+	NotificationPush    NotificationChannel = "push"
+	NotificationWebhook NotificationChannel = "webhook"
+)
+
+//NotificationChannels are the known notification channels
+var NotificationChannels = []NotificationChannel{NotificationEmail, NotificationPush, NotificationWebhook}
+
+//A NotificationSource is a widget, or a feed in any widget, whose new items notify the user
+type NotificationSource struct {
+	TabID    int64 `json:"tab_id,omitempty"`
+	WidgetID int64 `json:"widget_id,omitempty"`
+	FeedID   int64 `json:"feed_id,omitempty"`
+}
+
+//NotificationSettings are the notification preferences of a user.
+//The user is not notified if no channel or no source is given.
+type NotificationSettings struct {
+	UserID   string                `json:"user_id" db:"user_id"`
+	Channels []NotificationChannel `json:"channels"`
+	Sources  []NotificationSource  `json:"sources"`
+
+	//QuietStart and QuietEnd are the hours ("22:00") during which no notification is sent, in the time zone
+	//of the user. The quiet hours span midnight if the end is before the start. No quiet hours if empty.
+	QuietStart string `json:"quiet_start,omitempty"`
+	QuietEnd   string `json:"quiet_end,omitempty"`
+}
+
+//QuietHoursLayout is the format of the quiet hours
+const QuietHoursLayout = "15:04"
+
+//IsQuiet returns true if the given time, in the time zone of the user, is within the quiet hours
+func (s NotificationSettings) IsQuiet(t time.Time) bool {
+
+	start, err := time.Parse(QuietHoursLayout, s.QuietStart)
+	if err != nil {
+		return false
+	}
+	end, err := time.Parse(QuietHoursLayout, s.QuietEnd)
+	if err != nil {
+		return false
+	}
+
+	minutes := func(h, m int) int { return h*60 + m }
+	now := minutes(t.Hour(), t.Minute())
+	from := minutes(start.Hour(), start.Minute())
+	to := minutes(end.Hour(), end.Minute())
+
+	if from <= to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
+
+//Notifies returns true if the items of the feed displayed in the widget notify the user
+func (s NotificationSettings) Notifies(tabID int64, widgetID int64, feedID int64) bool {
+	for _, src := range s.Sources {
+		if src.WidgetID != 0 && src.TabID == tabID && src.WidgetID == widgetID {
+			return true
+		}
+		if src.FeedID != 0 && src.FeedID == feedID {
+			return true
+		}
+	}
+	return false
+}
+
+//HasChannel returns true if the user is notified through the channel
+func (s NotificationSettings) HasChannel(channel NotificationChannel) bool {
+	for _, c := range s.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
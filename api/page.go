@@ -0,0 +1,90 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	//DefaultPageSize is the number of elements of a page when no limit is given
+	DefaultPageSize = 50
+	//MaxPageSize is the maximum number of elements of a page
+	MaxPageSize = 500
+)
+
+//PageRequest selects a page of a listing.
+//Cursor is the cursor returned with the previous page, empty to start from the beginning.
+type PageRequest struct {
+	Cursor string `json:"cursor,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+//Size returns the number of elements to be returned, within the allowed range
+func (p PageRequest) Size() int {
+	if p.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if p.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return p.Limit
+}
+
+//IDCursor returns the cursor of a listing ordered by numerical IDs (0 for the first page)
+func (p PageRequest) IDCursor() (int64, error) {
+	if len(p.Cursor) == 0 {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(p.Cursor, 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "Invalid cursor")
+	}
+	return id, nil
+}
+
+//NextIDCursor returns the cursor of the page following the one ending with the given ID
+func NextIDCursor(lastID int64) string {
+	return strconv.FormatInt(lastID, 10)
+}
+
+//ItemCursor is the position of a feed item in a listing ordered by decreasing publication times,
+//the items published at the same time being ordered by decreasing GUIDs
+type ItemCursor struct {
+	Published time.Time
+	GUID      string
+}
+
+//IsZero reports whether the cursor is the one of the first page
+func (c ItemCursor) IsZero() bool {
+	return c.Published.IsZero()
+}
+
+//ItemCursor returns the cursor of a listing of feed items (the zero cursor for the first page).
+//A cursor given with only a time selects the items published before it.
+func (p PageRequest) ItemCursor() (ItemCursor, error) {
+	if len(p.Cursor) == 0 {
+		return ItemCursor{}, nil
+	}
+	published, guid := p.Cursor, ""
+	if i := strings.IndexByte(p.Cursor, ' '); i >= 0 {
+		published, guid = p.Cursor[:i], p.Cursor[i+1:]
+	}
+	t, err := time.Parse(time.RFC3339Nano, published)
+	if err != nil {
+		return ItemCursor{}, errors.Wrap(err, "Invalid cursor")
+	}
+	return ItemCursor{Published: t, GUID: guid}, nil
+}
+
+//NextItemCursor returns the cursor of the page following the one ending with the given item:
+//its publication time and its GUID, separated by a space
+func NextItemCursor(last FeedItem) string {
+	return last.Published.UTC().Format(time.RFC3339Nano) + " " + last.GUID
+}
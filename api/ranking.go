@@ -0,0 +1,54 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"math"
+	"time"
+)
+
+//MaxRankingFeatures is the maximum number of weights of a ranking model,
+//new features being ignored once reached
+const MaxRankingFeatures = 5000
+
+//A RankingModel is a logistic model scoring the probability that a user reads an item, based on the item features
+type RankingModel struct {
+	UserID  string             `json:"user_id" db:"user_id"`
+	Bias    float64            `json:"bias" db:"bias"`
+	Weights map[string]float64 `json:"weights" db:"-"`
+	Updated time.Time          `json:"updated" db:"updated"`
+}
+
+//Score returns the probability of an item with the given features to be read
+func (m RankingModel) Score(features []string) float64 {
+	z := m.Bias
+	for _, f := range features {
+		z += m.Weights[f]
+	}
+	return 1 / (1 + math.Exp(-z))
+}
+
+//Train updates the model with a single example, using a stochastic gradient descent step
+func (m *RankingModel) Train(features []string, read bool, learningRate float64) {
+
+	label := 0.0
+	if read {
+		label = 1
+	}
+	gradient := learningRate * (label - m.Score(features))
+
+	if m.Weights == nil {
+		m.Weights = make(map[string]float64)
+	}
+
+	m.Bias += gradient
+	for _, f := range features {
+		if _, ok := m.Weights[f]; !ok && len(m.Weights) >= MaxRankingFeatures {
+			continue
+		}
+		m.Weights[f] += gradient
+	}
+	m.Updated = time.Now()
+}
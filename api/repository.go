@@ -6,21 +6,48 @@ package api
 
 import (
 	"context"
+	"time"
 )
 
 //Repository is the interface allowing usage of any data store for tabs, widgets, read flags and all other data.
 type Repository interface {
-	//RunInTransaction(ctx context.Context, f func(repo Repository) error) error
+	RunInTransaction(ctx context.Context, f func(repo Repository) error) error
 
 	IsNotFound(err error) bool
 
+	//Close releases the connections of the repository, which cannot be used anymore
+	Close() error
+	//Optimize reclaims the space of the deleted data and refreshes the statistics of the query planner
+	Optimize(ctx context.Context) error
+
 	GetUser(ctx context.Context, userID string) (User, error)
 	StoreUser(ctx context.Context, user *User) error
-	//DeleteUser(ctx context.Context, userID string) error
+	SetUserTimeZone(ctx context.Context, userID string, timeZone string) error
+	SetUserLocale(ctx context.Context, userID string, locale string) error
+	SetUserAdmin(ctx context.Context, userID string, admin bool) error
+	//DeleteUser removes the user and all its data: the tabs it is the only one to access, their widgets,
+	//its accounts with their cached emails, its read flags and its settings.
+	DeleteUser(ctx context.Context, userID string) error
+	GetUsersPage(ctx context.Context, page PageRequest) ([]User, string, error)
+	//GetUserStats returns the amount of tabs, widgets and accounts of a user, the user itself is not filled
+	GetUserStats(ctx context.Context, userID string) (UserStats, error)
+	GetFeedStats(ctx context.Context) (FeedStats, error)
 
 	GetTabs(ctx context.Context, userID string) ([]TabSummary, error)
+	//GetOwnedTabIDs returns the tabs only the user can access, leaving out the tabs shared with other users
+	GetOwnedTabIDs(ctx context.Context, userID string) ([]int64, error)
+	GetTabsPage(ctx context.Context, userID string, page PageRequest) ([]TabSummary, string, error)
+	//UpdateTabPositions orders the tabs of the user as in the given list, without changing the order of the other users
+	UpdateTabPositions(ctx context.Context, userID string, tabIDs []int64) error
+	GetTabSlug(ctx context.Context, userID string, slug string) (TabSlug, error)
+	GetCurrentTabSlug(ctx context.Context, userID string, tabID int64) (string, error)
+	//StoreTabSlug stores the slug, the former current slug of the tab being kept as not current.
+	//A SlugTaken error is returned if the slug is the current one of another tab of the user.
+	StoreTabSlug(ctx context.Context, slug TabSlug) error
 	IsTabAccessAllowed(ctx context.Context, userID string, tabID int64) error
 	AllowTabAccess(ctx context.Context, userID string, tabID int64) error
+	//SetDefaultTab makes the tab the default one of the user, the user having no default tab if tabID is zero
+	SetDefaultTab(ctx context.Context, userID string, tabID int64) error
 
 	GetTab(ctx context.Context, tabID int64) (Tab, error)
 	StoreTab(ctx context.Context, tab *Tab) error
@@ -34,24 +61,138 @@ type Repository interface {
 	DeleteWidgetFromTab(ctx context.Context, tabID int64, widgetID int64) error
 
 	GetOrCreateFeedID(ctx context.Context, URL string) (int64, error)
+	//GetFeedID returns the ID of the feed with the given URL, or a not found error if it is not stored
+	GetFeedID(ctx context.Context, URL string) (int64, error)
 	GetFeed(ctx context.Context, feedID int64) (Feed, error)
+	GetFeedsPage(ctx context.Context, page PageRequest) ([]Feed, string, error)
 	GetFeedItems(ctx context.Context, feedID int64) ([]FeedItem, error)
+	//GetFeedItemsBefore returns at most limit items of the feed following the cursor, most recent first
+	GetFeedItemsBefore(ctx context.Context, feedID int64, before ItemCursor, limit int) ([]FeedItem, error)
+	//GetFeedItemsForUser returns at most limit items of the feed following the cursor, most recent first,
+	//with their read status for the user. The most recent items are returned if before is zero.
+	GetFeedItemsForUser(ctx context.Context, userID string, feedID int64, before ItemCursor, limit int) ([]ItemForUser, error)
+	//GetMostReadFeedIDs returns the feeds with the most items read by the user,
+	//or with the most readers if userID is empty, only the feeds with at least PopularFeedMinReaders readers being returned
+	GetMostReadFeedIDs(ctx context.Context, userID string, limit int) ([]int64, error)
+	//GetFeedUserIDs returns the users having access to a tab displaying the feed
+	GetFeedUserIDs(ctx context.Context, feedID int64) ([]string, error)
 	StoreFeed(ctx context.Context, feed *Feed, feedItems []FeedItem) error
-	//DeleteFeed(ctx context.Context, feedID int64) error
+	//DeleteFeed removes the feed with its items, the read status and the starred items of its items
+	DeleteFeed(ctx context.Context, feedID int64) error
+	//DeleteOrphanFeeds removes, with their items and read status, the feeds no widget displays, without starred items,
+	//and whose next retrieval is before the given time. It returns the number of feeds removed.
+	DeleteOrphanFeeds(ctx context.Context, retrievedBefore time.Time) (int64, error)
 
-	AreItemsRead(ctx context.Context, userID string, feedID int64, guids []string) ([]bool, error)
+	//GetReadItems returns the GUIDs of the items of the feed read by the user
+	GetReadItems(ctx context.Context, userID string, feedID int64) ([]string, error)
 	SetItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) error
-	SetItemsRead(ctx context.Context, userID string, feedID int64, guid []string, read bool) error
+	GetRankingModel(ctx context.Context, userID string) (RankingModel, error)
+	StoreRankingModel(ctx context.Context, model RankingModel) error
+
+	GetItemAbstracts(ctx context.Context, feedID int64, guids []string) ([]string, error)
+	StoreItemAbstract(ctx context.Context, feedID int64, guid string, abstract string) error
+
+	//SetItemsRead changes the read status of the items, and returns the number of items whose status changed
+	SetItemsRead(ctx context.Context, userID string, feedID int64, guid []string, read bool) (int64, error)
 
 	GetAccount(ctx context.Context, userID string, accountID int64) (ExternalAccount, error)
 	GetAccounts(ctx context.Context, userID string) ([]ExternalAccount, error)
+	//GetAccountsPage lists the accounts of the given user, or of all users if userID is empty
+	GetAccountsPage(ctx context.Context, userID string, page PageRequest) ([]ExternalAccount, string, error)
 	DeleteAccount(ctx context.Context, userID string, accountID int64) error
+	MarkAccountNeedsReauth(ctx context.Context, accountID int64) error
 	StoreAccount(ctx context.Context, userID string, account *ExternalAccount) error
+	//ReencryptTokens encrypts the tokens of a page of accounts with the newest key, returning the number of updated tokens
+	ReencryptTokens(ctx context.Context, page PageRequest) (int, string, error)
+	//UpgradeWidgetConfigs migrates the configs of a page of widgets to their current version, returning the number of updated configs
+	UpgradeWidgetConfigs(ctx context.Context, page PageRequest) (int, string, error)
 
-	GetUserFromTemporaryCode(ctx context.Context, serviceName string, code string) (string, error)
-	StoreTemporaryCode(ctx context.Context, userID string, serviceName string, code string) error
+	GetTemporaryCode(ctx context.Context, serviceName string, code string) (TemporaryCode, error)
+	StoreTemporaryCode(ctx context.Context, code TemporaryCode) error
 	DeleteTemporaryCode(ctx context.Context, userID string, serviceName string) error
+	DeleteTemporaryCodesBefore(ctx context.Context, before time.Time) (int64, error)
 
 	GetEmailItem(ctx context.Context, account ExternalAccount, guid string, minVersion uint64) (EmailItem, error)
 	StoreEmailItem(ctx context.Context, account ExternalAccount, version uint64, item EmailItem) error
+	InvalidateEmailItems(ctx context.Context, providerName string, accountID string, version uint64) error
+	GetEmailSync(ctx context.Context, account ExternalAccount) (EmailSync, error)
+	StoreEmailSync(ctx context.Context, sync EmailSync) error
+
+	GetManagedPolicy(ctx context.Context, userID string) (ManagedPolicy, error)
+	StoreManagedPolicy(ctx context.Context, policy ManagedPolicy) error
+	DeleteManagedPolicy(ctx context.Context, userID string) error
+
+	GetLinkPolicies(ctx context.Context, userID string) ([]LinkPolicy, error)
+	StoreLinkPolicies(ctx context.Context, userID string, policies []LinkPolicy) error
+
+	GetAPITokens(ctx context.Context, userID string) ([]APIToken, error)
+	GetAPITokenByHash(ctx context.Context, hash string) (APIToken, error)
+	StoreAPIToken(ctx context.Context, token *APIToken) error
+	StoreAPITokenUse(ctx context.Context, tokenID int64, used time.Time) error
+	DeleteAPIToken(ctx context.Context, userID string, tokenID int64) error
+
+	GetRetentionPolicy(ctx context.Context, userID string) (RetentionPolicy, error)
+	StoreRetentionPolicy(ctx context.Context, policy RetentionPolicy) error
+	//CountReadItemsBefore returns the number of read markers of the user set before the given time,
+	//on items no longer in their feed. The markers of the items still in a feed are kept, for them not to come back as unread.
+	CountReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error)
+	//DeleteReadItemsBefore removes the read markers counted by CountReadItemsBefore
+	DeleteReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error)
+	//CountClickHistoryBefore returns the number of read markers of the user whose read date is before the given time
+	CountClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error)
+	//DeleteClickHistoryBefore forgets the read dates of the user before the given time, the items staying read
+	DeleteClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error)
+	//CountEmailItemsBefore returns the number of cached emails of the user published before the given time
+	CountEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error)
+	DeleteEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error)
+
+	//GetLastSnapshot returns the last snapshot of the user taken by the change report job
+	GetLastSnapshot(ctx context.Context, userID string) (StoredSnapshot, error)
+	StoreLastSnapshot(ctx context.Context, snapshot StoredSnapshot) error
+	//GetActivities returns the latest change reports of the user, most recent first
+	GetActivities(ctx context.Context, userID string, limit int) ([]Activity, error)
+	StoreActivity(ctx context.Context, activity *Activity) error
+	//DeleteOldActivities removes the change reports of the user but the keep most recent ones, returning the number removed
+	DeleteOldActivities(ctx context.Context, userID string, keep int) (int64, error)
+
+	GetApprovalRequests(ctx context.Context, userID string) ([]ApprovalRequest, error)
+	StoreApprovalRequest(ctx context.Context, request *ApprovalRequest) error
+
+	//GetStarredItems returns the starred items of the user, most recent first
+	GetStarredItems(ctx context.Context, userID string) ([]StarredItem, error)
+	StoreStarredItem(ctx context.Context, item StarredItem) error
+	DeleteStarredItem(ctx context.Context, userID string, feedID int64, guid string) error
+
+	GetUserWebhook(ctx context.Context, userID string) (UserWebhook, error)
+	StoreUserWebhook(ctx context.Context, webhook UserWebhook) error
+	DeleteUserWebhook(ctx context.Context, userID string) error
+
+	GetNotificationSettings(ctx context.Context, userID string) (NotificationSettings, error)
+	StoreNotificationSettings(ctx context.Context, settings NotificationSettings) error
+	DeleteNotificationSettings(ctx context.Context, userID string) error
+
+	//GetTags returns the tags of the user, ordered by name
+	GetTags(ctx context.Context, userID string) ([]Tag, error)
+	StoreTag(ctx context.Context, tag Tag) error
+	DeleteTag(ctx context.Context, userID string, name string) error
+
+	//StoreAuditEvent appends the event to the audit log. The events are never changed nor removed, even with their user.
+	StoreAuditEvent(ctx context.Context, event *AuditEvent) error
+	//GetAuditEventsPage returns the events of the audit log matching the filter, most recent first
+	GetAuditEventsPage(ctx context.Context, filter AuditFilter, page PageRequest) ([]AuditEvent, string, error)
+
+	//AddUsage adds the counts to the usage of the user on the day of the given time, in UTC
+	AddUsage(ctx context.Context, userID string, day time.Time, usage Usage) error
+	//GetUsage returns the usage of the user by day since the day of the given time, oldest first.
+	//The days without activity are omitted. The usage of all the users is returned if userID is empty.
+	GetUsage(ctx context.Context, userID string, since time.Time) ([]DailyUsage, error)
+	//DeleteUsageBefore removes the usage of all the users on the days before the day of the given time, in UTC
+	DeleteUsageBefore(ctx context.Context, before time.Time) (int64, error)
+
+	//GetWidgetViews returns the views of the widgets displayed by the user
+	GetWidgetViews(ctx context.Context, userID string) ([]WidgetView, error)
+	//RecordWidgetView adds the renders of the view to the ones of the widget, and updates its last view
+	RecordWidgetView(ctx context.Context, view WidgetView) error
+	//GetViewedWidgets returns the widgets viewed by a user since the given time, and the ones never viewed
+	GetViewedWidgets(ctx context.Context, since time.Time) ([]Widget, error)
 }
@@ -0,0 +1,61 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+//A RetentionPolicy gives, in days, how long the data of a user is kept.
+//Zero keeps the data forever.
+type RetentionPolicy struct {
+	UserID         string `json:"user_id" db:"user_id"`
+	ReadItemsDays  int    `json:"read_items_days" db:"read_items_days"`
+	EmailCacheDays int    `json:"email_cache_days" db:"email_cache_days"`
+	//ClickHistoryDays is how long the dates at which the user opened the items are kept
+	ClickHistoryDays int `json:"click_history_days" db:"click_history_days"`
+}
+
+//RetentionBounds are the retention limits, in days, set for the whole instance
+type RetentionBounds struct {
+	//MinDays is the smallest retention a user can choose
+	MinDays int `json:"min_days"`
+	//MaxDays is the longest retention a user can choose, zero for no limit
+	MaxDays int `json:"max_days"`
+	//DefaultDays is the retention of the users without policy
+	DefaultDays int `json:"default_days"`
+}
+
+//Allows returns true if the given retention is within the bounds
+func (b RetentionBounds) Allows(days int) bool {
+	if days < 0 {
+		return false
+	}
+	if days == 0 {
+		return b.MaxDays == 0
+	}
+	return days >= b.MinDays && (b.MaxDays == 0 || days <= b.MaxDays)
+}
+
+//Clamp returns the closest retention within the bounds
+func (b RetentionBounds) Clamp(days int) int {
+	if days <= 0 {
+		return b.MaxDays
+	}
+	if days < b.MinDays {
+		return b.MinDays
+	}
+	if b.MaxDays > 0 && days > b.MaxDays {
+		return b.MaxDays
+	}
+	return days
+}
+
+//A RetentionReport gives the retention policy of a user
+//and the amount of data to be deleted by the next cleanup.
+type RetentionReport struct {
+	Policy     RetentionPolicy `json:"policy"`
+	Bounds     RetentionBounds `json:"bounds"`
+	ReadItems  int64           `json:"read_items"`
+	EmailItems int64           `json:"email_items"`
+	//ClickHistory is the number of read dates to be forgotten
+	ClickHistory int64 `json:"click_history"`
+}
@@ -4,11 +4,199 @@
 
 package api
 
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
 //Snapshot represents the configuration of a given user (used for backup and restore)
-//Widget items (including read status) are not part of this
+//Widget items are not part of this, only the read status of the feed items and the starred items.
 type Snapshot struct {
 	User     User
 	Tabs     []Tab
 	Feeds    []Feed
 	Accounts []ExternalAccount
+
+	//ReadStatus are the GUIDs of the items read by the user, by ID of feed within the snapshot
+	ReadStatus map[int64][]string `json:",omitempty"`
+	Starred    []StarredItem      `json:",omitempty"`
+
+	//Tokens are the tokens of the accounts, by ID of account within the snapshot.
+	//They are only included by the administration tools, to move a user to another repository.
+	Tokens map[int64]*oauth2.Token `json:",omitempty"`
+}
+
+//Validate checks that the snapshot is consistent: the widgets have the config of their type,
+//and the feeds and accounts they display are part of the snapshot.
+func (s Snapshot) Validate() error {
+
+	if len(s.User.UserID) == 0 {
+		return errors.New("missing user ID")
+	}
+
+	feeds, err := feedIDs(s.Feeds)
+	if err != nil {
+		return err
+	}
+
+	accounts := make(map[int64]bool)
+	for _, a := range s.Accounts {
+		if accounts[a.ID] {
+			return fmt.Errorf("duplicate account %d", a.ID)
+		}
+		accounts[a.ID] = true
+	}
+
+	for _, t := range s.Tabs {
+		if err := validateWidgets(t, feeds, accounts); err != nil {
+			return err
+		}
+	}
+
+	for feedID := range s.ReadStatus {
+		if !feeds[feedID] {
+			return fmt.Errorf("unknown feed %d in read status", feedID)
+		}
+	}
+	for _, item := range s.Starred {
+		if !feeds[item.FeedID] {
+			return fmt.Errorf("unknown feed %d in starred items", item.FeedID)
+		}
+	}
+	for accountID := range s.Tokens {
+		if !accounts[accountID] {
+			return fmt.Errorf("unknown account %d in tokens", accountID)
+		}
+	}
+
+	return nil
+}
+
+//TabExport is a single tab with the feeds of its widgets, used to move a tab between accounts or to share it.
+//The email widgets refer to the accounts by key, the tokens are not exported.
+type TabExport struct {
+	Tab   Tab
+	Feeds []Feed
+	//Accounts are the keys of the accounts displayed by the email widgets, by account ID within the export
+	Accounts map[int64]string `json:",omitempty"`
+}
+
+//Validate checks that the widgets of the exported tab have the config of their type,
+//and that the feeds and accounts they display are part of the export.
+func (e TabExport) Validate() error {
+
+	feeds, err := feedIDs(e.Feeds)
+	if err != nil {
+		return err
+	}
+
+	accounts := make(map[int64]bool)
+	for id := range e.Accounts {
+		accounts[id] = true
+	}
+
+	return validateWidgets(e.Tab, feeds, accounts)
+}
+
+//feedIDs returns the IDs of the feeds, checking that they are unique and have a URL
+func feedIDs(list []Feed) (map[int64]bool, error) {
+
+	feeds := make(map[int64]bool)
+	for _, f := range list {
+		if len(f.URL) == 0 {
+			return nil, fmt.Errorf("missing URL of feed %d", f.ID)
+		}
+		if feeds[f.ID] {
+			return nil, fmt.Errorf("duplicate feed %d", f.ID)
+		}
+		feeds[f.ID] = true
+	}
+
+	return feeds, nil
+}
+
+//validateWidgets checks that the widgets of the tab have the config of their type and display known feeds and accounts
+func validateWidgets(t Tab, feeds map[int64]bool, accounts map[int64]bool) error {
+
+	for _, col := range t.Widgets {
+		for _, w := range col {
+			switch w.Type {
+			case WidgetFeedType:
+				cfg, ok := w.Config.(ConfigFeed)
+				if !ok {
+					return fmt.Errorf("invalid config of widget %d in tab %d", w.ID, t.ID)
+				}
+				if !feeds[cfg.FeedID] {
+					return fmt.Errorf("unknown feed %d in widget %d of tab %d", cfg.FeedID, w.ID, t.ID)
+				}
+			case WidgetEmailType:
+				cfg, ok := w.Config.(ConfigEmail)
+				if !ok {
+					return fmt.Errorf("invalid config of widget %d in tab %d", w.ID, t.ID)
+				}
+				if !accounts[cfg.AccountID] {
+					return fmt.Errorf("unknown account %d in widget %d of tab %d", cfg.AccountID, w.ID, t.ID)
+				}
+			default:
+				if w.Config == nil {
+					return fmt.Errorf("missing config of widget %d in tab %d", w.ID, t.ID)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+//RestorePreview lists the changes a restore would make, without applying them
+type RestorePreview struct {
+	Changes []SnapshotChange `json:"changes"`
+	//Summary is the human-readable version of the changes
+	Summary []string `json:"summary,omitempty"`
+	//Conflicts are the reasons preventing the restore, the restore being possible if empty
+	Conflicts []string `json:"conflicts,omitempty"`
+}
+
+//RestoreSelection selects the tabs of a snapshot to restore, by ID within the snapshot or by title.
+//An empty selection restores the whole snapshot.
+type RestoreSelection struct {
+	TabIDs []int64  `json:"tab_ids,omitempty"`
+	Titles []string `json:"titles,omitempty"`
+	//Items restores the read status of the feed items and the starred items, kept as is if false
+	Items bool `json:"items,omitempty"`
+}
+
+//IsEmpty returns true if no tab is selected
+func (s RestoreSelection) IsEmpty() bool {
+	return len(s.TabIDs) == 0 && len(s.Titles) == 0
+}
+
+//Includes returns true if the given tab is selected
+func (s RestoreSelection) Includes(tab TabSummary) bool {
+	if s.IsEmpty() {
+		return true
+	}
+	for _, id := range s.TabIDs {
+		if id == tab.ID {
+			return true
+		}
+	}
+	for _, title := range s.Titles {
+		if title == tab.Title {
+			return true
+		}
+	}
+	return false
+}
+
+//EncryptedSnapshot is a snapshot encrypted with a passphrase, to be stored on untrusted storage.
+//The AES-256-GCM key is derived from the passphrase with PBKDF2-HMAC-SHA256.
+type EncryptedSnapshot struct {
+	Version    int    `json:"encrypted_snapshot"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Data       []byte `json:"data"`
 }
@@ -0,0 +1,48 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+//A StarredItem is a feed item kept by a user.
+//Its title and link are copied, the feed items being eventually removed from the feeds.
+type StarredItem struct {
+	UserID  string    `json:"-" db:"user_id"`
+	FeedID  int64     `json:"feed_id" db:"feed_id"`
+	GUID    string    `json:"guid" db:"guid"`
+	Title   string    `json:"title" db:"title"`
+	Link    string    `json:"link" db:"link"`
+	Tags    []string  `json:"tags" db:"-"`
+	Starred time.Time `json:"starred" db:"-"`
+}
+
+//A UserWebhook is an endpoint of a user, called each time the user stars an item
+type UserWebhook struct {
+	UserID string `json:"-" db:"user_id"`
+	URL    string `json:"url" db:"url"`
+	//Secret is the key of the payload signatures, the payloads are not signed if empty
+	Secret string `json:"secret,omitempty" db:"secret"`
+}
+
+//StarredEvent is the type of the payloads sent to the user webhooks when an item is starred
+const StarredEvent = "item.starred"
+
+//A UserWebhookPayload is the body of the POST request sent to a user webhook
+type UserWebhookPayload struct {
+	Event string      `json:"event"`
+	Item  StarredItem `json:"item"`
+}
+
+//SignUserWebhookPayload computes the HMAC-SHA256 signature of a payload, hex encoded
+func SignUserWebhookPayload(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
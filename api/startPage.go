@@ -0,0 +1,20 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+//Formats of the exports of the other start pages
+const (
+	//StartPageNetvibes is the OPML export of Netvibes, each tab being an outline of feeds
+	StartPageNetvibes = "netvibes"
+	//StartPageIGoogle is the GadgetTabML export of iGoogle, each section of a tab being a column
+	StartPageIGoogle = "igoogle"
+)
+
+//StartPageImport is the result of the import of the export of another start page
+type StartPageImport struct {
+	Tabs []Tab `json:"tabs"`
+	//Skipped are the titles of the modules not translated into widgets, such as notes or games
+	Skipped []string `json:"skipped,omitempty"`
+}
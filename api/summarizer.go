@@ -0,0 +1,18 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"context"
+)
+
+//AbstractSentences is the number of sentences of the generated item abstracts
+const AbstractSentences = 2
+
+//A Summarizer generates short abstracts of articles
+type Summarizer interface {
+	//Summarize returns an abstract of at most AbstractSentences sentences of the given article text
+	Summarize(ctx context.Context, title string, text string) (string, error)
+}
@@ -4,10 +4,78 @@
 
 package api
 
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
 //A TabSummary is thebasci configuration for a tab
 type TabSummary struct {
 	ID    int64  `json:"id"  db:"id"`
 	Title string `json:"title"  db:"title"`
+	//Slug is a user-scoped name of the tab, usable in links instead of its ID once percent-encoded
+	Slug string `json:"slug,omitempty"  db:"slug"`
+	//Default is set on the tab opened first by the user, at most one tab of the user being the default one
+	Default bool `json:"default,omitempty"  db:"isdefault"`
+}
+
+//A TabSlug links a slug of a user to a tab.
+//The former slugs of a tab are kept, not current, to redirect to the current one.
+type TabSlug struct {
+	UserID  string `json:"user_id" db:"user_id"`
+	Slug    string `json:"slug" db:"slug"`
+	TabID   int64  `json:"tab_id" db:"tab_id"`
+	Current bool   `json:"current" db:"current"`
+}
+
+//SlugTaken is returned when storing a slug which is the current one of another tab of the user
+type SlugTaken string
+
+func (err SlugTaken) Error() string {
+	return "slug already used by another tab: " + string(err)
+}
+
+//IsConflict flags the error as a conflict with the stored data
+func (err SlugTaken) IsConflict() bool {
+	return true
+}
+
+//A TabBulkRequest lists the tab operations applied in a single transaction:
+//tabs are created, then deleted, and finally ordered.
+type TabBulkRequest struct {
+	Create []TabSummary `json:"create,omitempty"`
+	Delete []int64      `json:"delete,omitempty"`
+	//Order is the new order of the tabs, the created tabs and the tabs not listed are put after
+	Order []int64 `json:"order,omitempty"`
+}
+
+//A TabBulkResult is the outcome of a TabBulkRequest
+type TabBulkResult struct {
+	Created []Tab        `json:"created"`
+	Deleted []int64      `json:"deleted"`
+	Tabs    []TabSummary `json:"tabs"`
+}
+
+//A WidgetBulkRequest lists the feeds for which widgets are created in a single transaction,
+//such as the subscriptions pasted by a user
+type WidgetBulkRequest struct {
+	URLs []string `json:"urls"`
+	//Columns is the number of columns the widgets are spread over, the columns of the tab if zero
+	Columns int `json:"columns,omitempty"`
+}
+
+//WidgetBulkResult is the result of a WidgetBulkRequest
+type WidgetBulkResult struct {
+	Widgets []Widget `json:"widgets"`
+	//Failed are the feeds for which no widget was created
+	Failed []WidgetBulkFailure `json:"failed,omitempty"`
+}
+
+//A WidgetBulkFailure is a feed of a WidgetBulkRequest that could not be retrieved or is not allowed
+type WidgetBulkFailure struct {
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
 }
 
 //A Tab is a collection of widgets to be displayed together
@@ -23,24 +91,65 @@ type Widget struct {
 	Config interface{} `json:"config"`
 }
 
+//FeedID returns the feed displayed by the widget, 0 if it is not a feed widget
+func (w Widget) FeedID() int64 {
+	if cfg, ok := w.Config.(ConfigFeed); ok {
+		return cfg.FeedID
+	}
+	return 0
+}
+
+//A WidgetPosition is the place of a new widget in the layout of its tab
+type WidgetPosition struct {
+	//Column is the index of the column, a column is added if it is the number of columns
+	Column int `json:"column"`
+	//Position is the index of the widget in the column, the widget is appended if it is negative or past the end
+	Position int `json:"position"`
+}
+
 //WidgetFeedType is the widget type for feed widgets
 const WidgetFeedType = "feed"
 
 //WidgetEmailType is the widget type for email widgets
 const WidgetEmailType = "email"
 
+//DefaultDisplayCount is the number of items displayed by a widget created without display count
+const DefaultDisplayCount = 5
+
 //WidgetConfig is the basic configuration for a widget
 type WidgetConfig struct {
 	Title        string `json:"title" db:"title"`
 	DisplayCount int    `json:"display_count,omitempty"`
 	Link         string `json:"link,omitempty"`
+	//LinkPolicies are applied on the links of the widget items, before the policies of the user
+	LinkPolicies []LinkPolicy `json:"link_policies,omitempty"`
+	//Version is the schema version of the config, see WidgetConfigMigration
+	Version int `json:"config_version,omitempty"`
+	//Tags are the names of the tags of the user applied on the widget
+	Tags []string `json:"tags,omitempty"`
 }
 
+//EditedWidgetConfig is the new configuration of an existing widget
+type EditedWidgetConfig struct {
+	WidgetConfig
+	//Mode is the new rendering mode of a feed widget, kept as is if nil
+	Mode *string `json:"mode,omitempty"`
+}
+
+//FeedModeDigest is the feed widget mode grouping the recent items in a single digest entry
+const FeedModeDigest = "digest"
+
 //ConfigFeed is the configuration for a feed widget
 type ConfigFeed struct {
 	WidgetConfig
 	FeedID int64  `json:"feed_id"`
 	URL    string `json:"url"`
+	//Mode is the rendering mode of the widget, items being listed if empty
+	Mode string `json:"mode,omitempty"`
+	//Summarize adds a generated abstract to the displayed items
+	Summarize bool `json:"summarize,omitempty"`
+	//Rank orders the items by the probability of the user reading them, instead of by date
+	Rank bool `json:"rank,omitempty"`
 }
 
 //NewWidgetFeed creates a new feed widget witn the given configuration
@@ -56,6 +165,10 @@ func NewWidgetFeed(id int64, cfg ConfigFeed) Widget {
 type ConfigEmail struct {
 	WidgetConfig
 	AccountID int64 `json:"account_id"`
+	//Categories are the folders or labels displayed together, the inbox is displayed if empty
+	Categories []string `json:"categories,omitempty"`
+	//Query restricts the displayed emails with a search using the provider syntax
+	Query string `json:"query,omitempty"`
 }
 
 //NewWidgetEmail creates a new email widget witn the given configuration
@@ -67,54 +180,109 @@ func NewWidgetEmail(id int64, cfg ConfigEmail) Widget {
 	}
 }
 
-//SetupTypedConfig recreate the typed config from a map[string]interface{}
-func (w *Widget) SetupTypedConfig() {
+//WidgetCollectionType is the widget type for the widgets listing the starred items with a given tag
+const WidgetCollectionType = "collection"
 
-	if cfg, ok := w.Config.(map[string]interface{}); ok {
+//ConfigCollection is the widget configuration for a collection widget
+type ConfigCollection struct {
+	WidgetConfig
+	//Tag is the name of the tag of the listed items
+	Tag string `json:"tag"`
+}
 
-		widgetConfig := WidgetConfig{}
-		if v, ok := cfg["title"]; ok {
-			if s, ok := v.(string); ok {
-				widgetConfig.Title = s
-			}
-		}
-		if v, ok := cfg["display_count"]; ok {
-			if f, ok := v.(float64); ok {
-				widgetConfig.DisplayCount = int(f)
-			}
+//NewWidgetCollection creates a new collection widget with the given configuration
+func NewWidgetCollection(id int64, cfg ConfigCollection) Widget {
+	return Widget{
+		ID:     id,
+		Type:   WidgetCollectionType,
+		Config: cfg,
+	}
+}
+
+//SuggestionReason explains why a widget is suggested
+type SuggestionReason string
+
+const (
+	//SuggestionMostRead is a feed often read by the user
+	SuggestionMostRead SuggestionReason = "most_read"
+	//SuggestionUnusedAccount is an associated account not displayed in any widget
+	SuggestionUnusedAccount SuggestionReason = "unused_account"
+	//SuggestionPopular is a feed read by many users of the instance
+	SuggestionPopular SuggestionReason = "popular"
+)
+
+//PopularFeedMinReaders is the number of distinct users who must have read a feed for it to be suggested as popular,
+//so that the suggestions do not disclose the feeds read by a single user
+const PopularFeedMinReaders = 3
+
+//A WidgetSuggestion is a widget that can be added as is to a tab
+type WidgetSuggestion struct {
+	Reason SuggestionReason `json:"reason"`
+	Widget Widget           `json:"widget"`
+}
+
+//widgetTypes is the registry of the widget types, decoding their configs from JSON
+var widgetTypes = map[string]func(raw []byte) (interface{}, error){
+	WidgetFeedType: func(raw []byte) (interface{}, error) {
+		cfg := ConfigFeed{}
+		err := json.Unmarshal(raw, &cfg)
+		return cfg, err
+	},
+	WidgetEmailType: func(raw []byte) (interface{}, error) {
+		//The clients may send the account ID as a string
+		aux := struct {
+			ConfigEmail
+			AccountID json.Number `json:"account_id"`
+		}{}
+		if err := json.Unmarshal(raw, &aux); err != nil {
+			return nil, err
 		}
-		if v, ok := cfg["link"]; ok {
-			if s, ok := v.(string); ok {
-				widgetConfig.Link = s
+		cfg := aux.ConfigEmail
+		if len(aux.AccountID) > 0 {
+			id, err := aux.AccountID.Int64()
+			if err != nil {
+				return nil, err
 			}
+			cfg.AccountID = id
 		}
+		return cfg, nil
+	},
+	WidgetCollectionType: func(raw []byte) (interface{}, error) {
+		cfg := ConfigCollection{}
+		err := json.Unmarshal(raw, &cfg)
+		return cfg, err
+	},
+}
 
-		switch w.Type {
-		case WidgetEmailType:
-			newCfg := ConfigEmail{
-				WidgetConfig: widgetConfig,
-			}
-			if v, ok := cfg["account_id"]; ok {
-				if f, ok := v.(float64); ok {
-					newCfg.AccountID = int64(f)
-				}
-			}
-			w.Config = newCfg
-		case WidgetFeedType:
-			newCfg := ConfigFeed{
-				WidgetConfig: widgetConfig,
-			}
-			if v, ok := cfg["url"]; ok {
-				if s, ok := v.(string); ok {
-					newCfg.URL = s
-				}
-			}
-			if v, ok := cfg["feed_id"]; ok {
-				if f, ok := v.(float64); ok {
-					newCfg.FeedID = int64(f)
-				}
-			}
-			w.Config = newCfg
-		}
+//IsWidgetType checks if the widget type is known
+func IsWidgetType(widgetType string) bool {
+	_, ok := widgetTypes[widgetType]
+	return ok
+}
+
+//UnmarshalJSON decodes the widget with the typed config of its widget type,
+//upgraded to the current version
+func (w *Widget) UnmarshalJSON(b []byte) error {
+
+	var raw struct {
+		ID     int64           `json:"id"`
+		Type   string          `json:"widgetType"`
+		Config json.RawMessage `json:"config"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if !IsWidgetType(raw.Type) {
+		return errors.New("Unknown widget type: " + raw.Type)
 	}
+
+	config, _, err := DecodeWidgetConfig(raw.Type, raw.Config)
+	if err != nil {
+		return err
+	}
+
+	w.ID = raw.ID
+	w.Type = raw.Type
+	w.Config = config
+	return nil
 }
@@ -0,0 +1,28 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+//A Tag is a label defined by a user, applied on its starred items and widgets.
+//Tags are identified by their name, unique for a user.
+type Tag struct {
+	UserID string `json:"-" db:"user_id"`
+	Name   string `json:"name" db:"name"`
+	//Color is the CSS color of the tag, such as "#ff8800"
+	Color string `json:"color,omitempty" db:"color"`
+}
+
+//A TaggedWidget is a widget with a given tag, with its tab
+type TaggedWidget struct {
+	TabID    int64  `json:"tab_id"`
+	TabTitle string `json:"tab_title"`
+	Widget   Widget `json:"widget"`
+}
+
+//A TagCollection lists the starred items and the widgets with a given tag
+type TagCollection struct {
+	Tag     Tag            `json:"tag"`
+	Items   []StarredItem  `json:"items"`
+	Widgets []TaggedWidget `json:"widgets"`
+}
@@ -0,0 +1,56 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+//TabTemplate is a tab without personal data, published for other users to create their own copy.
+//The email widgets display account slots, replaced by the accounts chosen by the user importing the template.
+type TabTemplate struct {
+	Title   string     `json:"title"`
+	Widgets [][]Widget `json:"widgets"`
+	Feeds   []Feed     `json:"feeds"`
+	//Accounts are the provider names of the account slots of the email widgets, by slot
+	Accounts map[int64]string `json:"accounts,omitempty"`
+}
+
+//Validate checks that the widgets of the template have the config of their type,
+//and that the feeds and account slots they display are part of the template.
+func (t TabTemplate) Validate() error {
+
+	feeds, err := feedIDs(t.Feeds)
+	if err != nil {
+		return err
+	}
+
+	slots := make(map[int64]bool)
+	for slot := range t.Accounts {
+		slots[slot] = true
+	}
+
+	return validateWidgets(Tab{TabSummary: TabSummary{Title: t.Title}, Widgets: t.Widgets}, feeds, slots)
+}
+
+//TemplateImport instantiates a template for a user
+type TemplateImport struct {
+	Template TabTemplate `json:"template"`
+	//Title is the title of the created tab, the one of the template if empty
+	Title string `json:"title,omitempty"`
+	//Accounts are the accounts of the user displayed by the email widgets, by slot.
+	//The email widgets of a slot given the account 0 are not created.
+	Accounts map[int64]int64 `json:"accounts,omitempty"`
+}
+
+//TemplateAccountSlot is an account slot of a template, to be given an account by the user importing it
+type TemplateAccountSlot struct {
+	Slot         int64  `json:"slot"`
+	ProviderName string `json:"provider_name"`
+	//Candidates are the accounts of the user on the provider of the slot
+	Candidates []ExternalAccount `json:"candidates"`
+}
+
+//TemplateImportResult is the tab created from a template, or the account slots to fill before the tab can be created
+type TemplateImportResult struct {
+	Tab   *Tab                  `json:"tab,omitempty"`
+	Slots []TemplateAccountSlot `json:"slots,omitempty"`
+}
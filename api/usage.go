@@ -0,0 +1,41 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import "time"
+
+//Usage counts the reading activity of a user
+type Usage struct {
+	//WidgetViews is the number of times the widgets were displayed
+	WidgetViews int64 `json:"widget_views" db:"widget_views"`
+	//ItemsRead is the number of feed items marked as read
+	ItemsRead int64 `json:"items_read" db:"items_read"`
+	//FeedsRefreshed is the number of feeds downloaded again when displayed
+	FeedsRefreshed int64 `json:"feeds_refreshed" db:"feeds_refreshed"`
+}
+
+//Add returns the sum of the usages
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		WidgetViews:    u.WidgetViews + o.WidgetViews,
+		ItemsRead:      u.ItemsRead + o.ItemsRead,
+		FeedsRefreshed: u.FeedsRefreshed + o.FeedsRefreshed,
+	}
+}
+
+//DailyUsage is the usage of a day, in UTC
+type DailyUsage struct {
+	Day time.Time `json:"day"`
+	Usage
+	//Users is the number of active users of the day, in the usage of all the users
+	Users int64 `json:"users,omitempty"`
+}
+
+//UsageStats is the usage over a period, by day
+type UsageStats struct {
+	Since time.Time    `json:"since"`
+	Total Usage        `json:"total"`
+	Days  []DailyUsage `json:"days"`
+}
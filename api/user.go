@@ -11,6 +11,11 @@ type User struct {
 	Email       string `json:"email" db:"email"`
 
 	IsAdmin bool `json:"is_admin,omitempty" db:"isadmin"`
+
+	//TimeZone is the IANA name of the zone the dates are displayed in (such as "Europe/Paris"), UTC if empty
+	TimeZone string `json:"time_zone,omitempty" db:"timezone"`
+	//Locale is the language of the messages returned to the user (such as "fr"), taken from the request if empty
+	Locale string `json:"locale,omitempty" db:"locale"`
 }
 
 //AnonymousUserID is the ID to be used when dealin with anonymous acces to the application
@@ -15,6 +15,20 @@ type UserInfo interface {
 	Email() string
 }
 
+type userInfoKey struct{}
+
+//ContextWithUser returns a context authenticated as the given user.
+//It is used by authentication mechanisms managed outside of the server, such as API tokens.
+func ContextWithUser(ctx context.Context, user UserInfo) context.Context {
+	return context.WithValue(ctx, userInfoKey{}, user)
+}
+
+//UserFromContext returns the user set by ContextWithUser, if any
+func UserFromContext(ctx context.Context) (UserInfo, bool) {
+	user, ok := ctx.Value(userInfoKey{}).(UserInfo)
+	return user, ok
+}
+
 //UserInteractor allows interactions with the User connected to the application
 type UserInteractor interface {
 	CurrentUserIsAdmin(ctx context.Context) bool
@@ -0,0 +1,128 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+//WidgetConfigVersionKey is the key of the schema version in the raw widget configs
+const WidgetConfigVersionKey = "config_version"
+
+//A WidgetConfigMigration upgrades the raw configs of a widget type to the next schema version.
+//The configs without version are at version 0, which includes the configs sent by the clients:
+//Up must therefore leave unchanged a config already following the new schema.
+type WidgetConfigMigration struct {
+	Type string
+	//Version is the schema version of the configs upgraded by Up
+	Version     int
+	Description string
+	Up          func(cfg map[string]interface{}) error
+}
+
+//widgetConfigMigrations are the changes of the widget configs, in increasing version for each type.
+//New migrations are added at the end.
+var widgetConfigMigrations = []WidgetConfigMigration{
+	{
+		Type:        WidgetFeedType,
+		Version:     1,
+		Description: "explicit display count",
+		Up:          explicitDisplayCount,
+	},
+	{
+		Type:        WidgetEmailType,
+		Version:     1,
+		Description: "explicit display count",
+		Up:          explicitDisplayCount,
+	},
+}
+
+//explicitDisplayCount stores the default display count in the configs without one,
+//the older clients leaving it empty
+func explicitDisplayCount(cfg map[string]interface{}) error {
+	if count, ok := cfg["display_count"].(float64); !ok || count <= 0 {
+		cfg["display_count"] = float64(DefaultDisplayCount)
+	}
+	return nil
+}
+
+//WidgetConfigVersion returns the current schema version of the configs of a widget type
+func WidgetConfigVersion(widgetType string) int {
+	version := 0
+	for _, m := range widgetConfigMigrations {
+		if m.Type == widgetType && m.Version > version {
+			version = m.Version
+		}
+	}
+	return version
+}
+
+//MigrateWidgetConfig upgrades a raw config to the current schema version of its widget type.
+//It returns false if the config was already up to date.
+func MigrateWidgetConfig(widgetType string, cfg map[string]interface{}) (bool, error) {
+
+	version := 0
+	if v, ok := cfg[WidgetConfigVersionKey].(float64); ok {
+		version = int(v)
+	}
+
+	migrated := false
+	for _, m := range widgetConfigMigrations {
+		if m.Type != widgetType || m.Version <= version {
+			continue
+		}
+		if err := m.Up(cfg); err != nil {
+			return false, errors.Wrapf(err, "Migration of %s widget config to version %d failed", widgetType, m.Version)
+		}
+		version = m.Version
+		cfg[WidgetConfigVersionKey] = float64(version)
+		migrated = true
+	}
+
+	return migrated, nil
+}
+
+//DecodeWidgetConfig decodes a stored widget config into the typed config of its widget type,
+//after upgrading it to the current schema version.
+//It returns true if the config was migrated and should be stored again.
+func DecodeWidgetConfig(widgetType string, raw []byte) (interface{}, bool, error) {
+
+	//Unknown widget types have no config
+	decode, ok := widgetTypes[widgetType]
+	if !ok {
+		return nil, false, nil
+	}
+
+	var cfg map[string]interface{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, false, errors.Wrap(err, "Unmarshaling widget config failed")
+		}
+	}
+	if cfg == nil {
+		cfg = make(map[string]interface{})
+		raw = []byte("{}")
+	}
+
+	migrated, err := MigrateWidgetConfig(widgetType, cfg)
+	if err != nil {
+		return nil, false, err
+	}
+	if migrated {
+		raw, err = json.Marshal(cfg)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "Marshaling migrated widget config failed")
+		}
+	}
+
+	config, err := decode(raw)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Unmarshaling widget config failed")
+	}
+
+	return config, migrated, nil
+}
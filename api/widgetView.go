@@ -0,0 +1,27 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import "time"
+
+//A WidgetView records how many times a user displayed a widget, and when it was last displayed
+type WidgetView struct {
+	UserID   string `json:"-" db:"user_id"`
+	TabID    int64  `json:"tab_id" db:"tab_id"`
+	WidgetID int64  `json:"widget_id" db:"widget_id"`
+	//Renders is the number of times the widget was displayed
+	Renders    int64     `json:"renders" db:"renders"`
+	LastViewed time.Time `json:"last_viewed" db:"last_viewed"`
+}
+
+//A StaleWidget is a widget not viewed for a long time, suggested for removal
+type StaleWidget struct {
+	TabID    int64  `json:"tab_id"`
+	TabTitle string `json:"tab_title"`
+	Widget   Widget `json:"widget"`
+	Renders  int64  `json:"renders"`
+	//LastViewed is the last time the widget was displayed, or the time the tracking started if it never was
+	LastViewed time.Time `json:"last_viewed"`
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/oki-apps/okihome/metrics"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//SetAPIMetrics records the calls to the feed hosts in the given registry, under "feed:<host>".
+//The providers record their own calls when given the same registry.
+//By default, nothing is recorded.
+func (app *App) SetAPIMetrics(registry *metrics.Registry) {
+	app.apiMetrics = registry
+}
+
+//APIMetrics returns the call counts, latencies and error rates of the external APIs, by name.
+//Only an admin can retrieve them.
+func (app App) APIMetrics(ctx context.Context) (map[string]metrics.Series, error) {
+	ctx, span := tracing.Start(ctx, "App.APIMetrics")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.apiMetrics == nil {
+		return map[string]metrics.Series{}, nil
+	}
+	return app.apiMetrics.Snapshot(), nil
+}
+
+//SetRequestMetrics records the slow API requests in the given registry, by route.
+//By default, nothing is recorded.
+func (app *App) SetRequestMetrics(registry *metrics.Registry) {
+	app.requestMetrics = registry
+}
+
+//RecordSlowRequest adds a request of the given route that lasted more than the slow request duration
+func (app App) RecordSlowRequest(route string, latency time.Duration) {
+	if app.requestMetrics == nil {
+		return
+	}
+	app.requestMetrics.Record(route, latency, false, false)
+}
+
+//SlowRequestMetrics returns the counts and latencies of the slow API requests, by route.
+//Only an admin can retrieve them.
+func (app App) SlowRequestMetrics(ctx context.Context) (map[string]metrics.Series, error) {
+	ctx, span := tracing.Start(ctx, "App.SlowRequestMetrics")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.requestMetrics == nil {
+		return map[string]metrics.Series{}, nil
+	}
+	return app.requestMetrics.Snapshot(), nil
+}
+
+//SetSlowQueryMetrics records the slow repository calls in the given registry, by method.
+//The registry must be the one given to the slow query log of the repository.
+func (app *App) SetSlowQueryMetrics(registry *metrics.Registry) {
+	app.slowQueryMetrics = registry
+}
+
+//SlowQueryMetrics returns the counts and latencies of the slow repository calls, by method.
+//Only an admin can retrieve them.
+func (app App) SlowQueryMetrics(ctx context.Context) (map[string]metrics.Series, error) {
+	ctx, span := tracing.Start(ctx, "App.SlowQueryMetrics")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.slowQueryMetrics == nil {
+		return map[string]metrics.Series{}, nil
+	}
+	return app.slowQueryMetrics.Snapshot(), nil
+}
+
+//feedClient returns the HTTP client retrieving the feed at the given URL, whose calls are traced and recorded
+func (app App) feedClient(feedURL string) *http.Client {
+	name := "feed"
+	if u, err := url.Parse(feedURL); err == nil && len(u.Host) > 0 {
+		name = "feed:" + u.Hostname()
+	}
+	return app.apiMetrics.Client(name, tracing.Client(app.feedHTTP))
+}
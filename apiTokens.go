@@ -0,0 +1,165 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//apiTokenHintLength is the number of characters of a token kept to help recognize it
+const apiTokenHintLength = 8
+
+//APITokenUseResolution is the precision of the last use date of the API tokens,
+//the date not being stored again for the requests closer than it
+const APITokenUseResolution = time.Minute
+
+//tokenUser exposes a stored user as the user authenticated by an API token
+type tokenUser struct {
+	user api.User
+}
+
+func (u tokenUser) ID() string          { return u.user.UserID }
+func (u tokenUser) DisplayName() string { return u.user.DisplayName }
+func (u tokenUser) Email() string       { return u.user.Email }
+
+func hashAPIToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+//CreateAPIToken creates a new personal API token for the given user, who must be the current user:
+//an admin can not create a token acting as another user.
+//The secret value is only returned by this call.
+func (app App) CreateAPIToken(ctx context.Context, userID string, name string) (api.NewAPIToken, error) {
+	ctx, span := tracing.Start(ctx, "App.CreateAPIToken")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.NewAPIToken{}, errors.Wrap(err, "retrieving current user failed")
+	}
+	if userID != loggedInUserID {
+		return api.NewAPIToken{}, errors.Wrap(notAuthorized("API tokens can only be created by their user: "+userID), "access by "+loggedInUserID)
+	}
+
+	//A token must not be able to create other tokens
+	if _, ok := api.UserFromContext(ctx); ok {
+		return api.NewAPIToken{}, notAuthorized("API tokens can not be created using an API token")
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return api.NewAPIToken{}, errors.Wrap(err, "generating API token failed")
+	}
+	secret := api.APITokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	token := api.NewAPIToken{
+		APIToken: api.APIToken{
+			UserID:  userID,
+			Name:    name,
+			Hint:    secret[:len(api.APITokenPrefix)+apiTokenHintLength],
+			Hash:    hashAPIToken(secret),
+			Created: time.Now().UTC(),
+		},
+		Token: secret,
+	}
+
+	err = app.repository.StoreAPIToken(ctx, &token.APIToken)
+	if err != nil {
+		return api.NewAPIToken{}, errors.Wrap(err, "storing API token in datastore failed")
+	}
+
+	app.audit(ctx, userID, api.AuditTokenCreated, fmt.Sprintf("token:%d", token.ID))
+
+	return token, nil
+}
+
+//APITokens returns the API tokens of the given user, without their secret value
+func (app App) APITokens(ctx context.Context, userID string) ([]api.APIToken, error) {
+	ctx, span := tracing.Start(ctx, "App.APITokens")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "APITokens")
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := app.repository.GetAPITokens(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving API tokens from datastore failed")
+	}
+
+	return tokens, nil
+}
+
+//RevokeAPIToken deletes an API token of the given user
+func (app App) RevokeAPIToken(ctx context.Context, userID string, tokenID int64) error {
+	ctx, span := tracing.Start(ctx, "App.RevokeAPIToken")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, fmt.Sprintf("RevokeAPIToken token:%d", tokenID))
+	if err != nil {
+		return err
+	}
+
+	err = app.repository.DeleteAPIToken(ctx, userID, tokenID)
+	if err != nil {
+		return errors.Wrap(err, "deleting API token from datastore failed")
+	}
+
+	app.audit(ctx, userID, api.AuditTokenRevoked, fmt.Sprintf("token:%d", tokenID))
+
+	return nil
+}
+
+//AuthenticateAPIToken returns the user owning the given API token
+func (app App) AuthenticateAPIToken(ctx context.Context, token string) (api.UserInfo, error) {
+	ctx, span := tracing.Start(ctx, "App.AuthenticateAPIToken")
+	defer span.End()
+
+	if !strings.HasPrefix(token, api.APITokenPrefix) {
+		return nil, notAuthorized("invalid API token")
+	}
+
+	t, err := app.repository.GetAPITokenByHash(ctx, hashAPIToken(token))
+	if err != nil {
+		if app.repository.IsNotFound(err) {
+			return nil, notAuthorized("invalid API token")
+		}
+		return nil, errors.Wrap(err, "retrieving API token from datastore failed")
+	}
+
+	user, err := app.repository.GetUser(ctx, t.UserID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving user from datastore failed")
+	}
+
+	//Best effort, the usage date is only informative
+	now := time.Now().UTC()
+	if now.Sub(t.LastUsed) >= APITokenUseResolution {
+		err = app.repository.StoreAPITokenUse(ctx, t.ID, now)
+		if err != nil {
+			app.Error(ctx, errors.Wrap(err, "storing API token usage failed"))
+		}
+	}
+
+	u := tokenUser{user: user}
+	app.audit(api.ContextWithUser(ctx, u), user.UserID, api.AuditTokenLogin, fmt.Sprintf("token:%d", t.ID))
+
+	return u, nil
+}
@@ -7,32 +7,77 @@ package okihome
 import (
 	"context"
 	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/mmcdole/gofeed"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/oauth2"
 
 	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/i18n"
+	"github.com/oki-apps/okihome/metrics"
+	"github.com/oki-apps/okihome/tracing"
 )
 
 //App is the main application.
 //
 //Usually, a single app is created and runned.
 type App struct {
-	repository     api.Repository
-	userInteractor api.UserInteractor
-	logInteractor  api.LogInteractor
-	providers      map[string]api.Provider
+	repository       api.Repository
+	blobStore        api.BlobStore
+	backupStore      api.BlobStore
+	backupsKept      int
+	backupPassphrase string
+	summarizer       api.Summarizer
+	userInteractor   api.UserInteractor
+	logInteractor    api.LogInteractor
+	providers        map[string]api.Provider
+	retention        api.RetentionBounds
+	auditSink        api.AuditSink
+	auditQueue       chan api.AuditEvent
+	events           *eventHub
+	previews         *previewCache
+	refreshes        *refreshLimiter
+	summaries        *summaryQueue
+	tasks            *backgroundTasks
+	syncIdleAfter    time.Duration
+	feedIdleAfter    time.Duration
+	apiMetrics       *metrics.Registry
+	requestMetrics   *metrics.Registry
+	slowQueryMetrics *metrics.Registry
+	feedHTTP         *http.Client
+	feedLimiter      *feedLimiter
+	webhookHTTP      *http.Client
+	demo             bool
+	starter          Dashboard
 }
 
-//NewApp creates a new App using the given services
-func NewApp(r api.Repository, u api.UserInteractor, l api.LogInteractor, p []api.Provider) *App {
+//NewApp creates a new App using the given services.
+//The blob store is optional, cached assets are not stored if nil.
+//The summarizer is optional, item abstracts are extracted locally if nil.
+func NewApp(r api.Repository, b api.BlobStore, s api.Summarizer, u api.UserInteractor, l api.LogInteractor, p []api.Provider) *App {
 	app := &App{
 		repository:     r,
+		blobStore:      b,
+		summarizer:     s,
 		userInteractor: u,
 		logInteractor:  l,
 		providers:      make(map[string]api.Provider),
+		events:         newEventHub(),
+		previews:       newPreviewCache(),
+		refreshes:      newRefreshLimiter(),
+		summaries:      newSummaryQueue(),
+		tasks:          &backgroundTasks{},
+		feedHTTP:       newFeedHTTPClient(DefaultFeedClientOptions),
+		feedLimiter:    newFeedDownloadLimiter(DefaultFeedClientOptions),
+		webhookHTTP:    newUserWebhookClient(),
 	}
 
 	for _, provider := range p {
@@ -42,12 +87,22 @@ func NewApp(r api.Repository, u api.UserInteractor, l api.LogInteractor, p []api
 	return app
 }
 
+// Debugf is like Infof, but at Debug level.
+func (app *App) Debugf(ctx context.Context, format string, args ...interface{}) {
+	app.logInteractor.Debugf(ctx, format, args...)
+}
+
 // Infof formats its arguments according to the format, analogous to fmt.Printf,
 // and records the text as a log message at Info level.
 func (app *App) Infof(ctx context.Context, format string, args ...interface{}) {
 	app.logInteractor.Infof(ctx, format, args...)
 }
 
+// Warnf is like Infof, but at Warning level.
+func (app *App) Warnf(ctx context.Context, format string, args ...interface{}) {
+	app.logInteractor.Warnf(ctx, format, args...)
+}
+
 // Errorf is like Infof, but at Error level.
 func (app *App) Errorf(ctx context.Context, format string, args ...interface{}) {
 	app.logInteractor.Errorf(ctx, format, args...)
@@ -66,14 +121,41 @@ func (err notAuthorized) Error() string {
 	return string(err)
 }
 
+//invalidArgument is returned when the request of the user cannot be applied as is
+type invalidArgument string
+
+func (err invalidArgument) IsInvalid() bool {
+	return true
+}
+func (err invalidArgument) Error() string {
+	return string(err)
+}
+
+//IsNotFound returns true if the error is caused by a missing entry
+func (app App) IsNotFound(err error) bool {
+	return app.repository.IsNotFound(err)
+}
+
 //UserData contains the basic user information
 type UserData struct {
 	User api.User         `json:"user"`
 	Tabs []api.TabSummary `json:"tabs"`
+	//DefaultTabID is the tab to open first: the default tab of the user, or the first tab if none
+	DefaultTabID int64 `json:"default_tab_id,omitempty"`
+}
+
+//CurrentUserID returns the ID of the logged in user
+func (app App) CurrentUserID(ctx context.Context) (string, error) {
+	ctx, span := tracing.Start(ctx, "App.CurrentUserID")
+	defer span.End()
+
+	return app.userInteractor.CurrentUserID(ctx)
 }
 
 //User returns the basic user information for the user with the given id
 func (app App) User(ctx context.Context, userID string) (UserData, error) {
+	ctx, span := tracing.Start(ctx, "App.User")
+	defer span.End()
 
 	//Check that a user is logged
 	loggedInUser, err := app.userInteractor.CurrentUser(ctx)
@@ -86,6 +168,7 @@ func (app App) User(ctx context.Context, userID string) (UserData, error) {
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return UserData{}, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUser.ID())
 		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "User")
 	}
 
 	data := UserData{}
@@ -100,6 +183,14 @@ func (app App) User(ctx context.Context, userID string) (UserData, error) {
 			data.User.IsAdmin = false
 
 			err = app.repository.StoreUser(ctx, &data.User)
+			if err == nil && userID == loggedInUser.ID() {
+				//First login, the user is created even if its starter dashboard is not.
+				//The sessions are opened by the server, the app only sees this first one.
+				app.audit(ctx, userID, api.AuditLogin, "")
+				if err := app.createDashboard(ctx, app.starter); err != nil {
+					app.Error(ctx, errors.Wrap(err, "creating starter dashboard failed"))
+				}
+			}
 		}
 
 		if err != nil {
@@ -111,12 +202,23 @@ func (app App) User(ctx context.Context, userID string) (UserData, error) {
 	if err != nil {
 		return UserData{}, errors.Wrap(err, "retrieving tab ids from datastore failed")
 	}
+	for _, t := range data.Tabs {
+		if t.Default {
+			data.DefaultTabID = t.ID
+			break
+		}
+	}
+	if data.DefaultTabID == 0 && len(data.Tabs) > 0 {
+		data.DefaultTabID = data.Tabs[0].ID
+	}
 
 	return data, nil
 }
 
 //BackupUser returns the configuration of a given user (used for backup and restore)
 func (app App) BackupUser(ctx context.Context, userID string) (api.Snapshot, error) {
+	ctx, span := tracing.Start(ctx, "App.BackupUser")
+	defer span.End()
 
 	//Check that a user is logged
 	loggedInUser, err := app.userInteractor.CurrentUser(ctx)
@@ -129,8 +231,68 @@ func (app App) BackupUser(ctx context.Context, userID string) (api.Snapshot, err
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return api.Snapshot{}, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUser.ID())
 		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "BackupUser")
+	}
+
+	data, err := app.snapshot(ctx, userID)
+	if err != nil {
+		return api.Snapshot{}, err
+	}
+
+	err = app.snapshotItems(ctx, userID, &data)
+	if err != nil {
+		return api.Snapshot{}, err
+	}
+
+	app.audit(ctx, userID, api.AuditUserBackedUp, "")
+
+	return data, nil
+}
+
+//snapshotItems adds the read status of the feeds and the starred items of the user to a snapshot,
+//along with the feeds of the starred items
+func (app App) snapshotItems(ctx context.Context, userID string, data *api.Snapshot) error {
+
+	var err error
+	data.Starred, err = app.repository.GetStarredItems(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "retrieving starred items failed")
+	}
+
+	known := make(map[int64]bool)
+	for _, f := range data.Feeds {
+		known[f.ID] = true
+	}
+	for _, item := range data.Starred {
+		if known[item.FeedID] {
+			continue
+		}
+		feed, err := app.repository.GetFeed(ctx, item.FeedID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving feed from datastore failed")
+		}
+		data.Feeds = append(data.Feeds, feed)
+		known[item.FeedID] = true
+	}
+
+	data.ReadStatus = make(map[int64][]string)
+	for _, f := range data.Feeds {
+		guids, err := app.repository.GetReadItems(ctx, userID, f.ID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving read items failed")
+		}
+		if len(guids) > 0 {
+			data.ReadStatus[f.ID] = guids
+		}
 	}
 
+	return nil
+}
+
+//snapshot returns the configuration of a given user, without any access check
+func (app App) snapshot(ctx context.Context, userID string) (api.Snapshot, error) {
+
+	var err error
 	data := api.Snapshot{}
 
 	//Get the user in datastore
@@ -183,8 +345,13 @@ func (app App) BackupUser(ctx context.Context, userID string) (api.Snapshot, err
 	return data, nil
 }
 
-//RestoreUser restores the configuration of a given user (used for backup and restore)
-func (app App) RestoreUser(ctx context.Context, userID string, s api.Snapshot) error {
+//RestoreUser restores the configuration of a given user (used for backup and restore).
+//A whole snapshot can only be restored for a user without tabs,
+//whereas the selected tabs of a partial restore are added to the existing ones.
+//The read status and the starred items are restored if selected, for all the feeds of the snapshot.
+func (app App) RestoreUser(ctx context.Context, userID string, s api.Snapshot, selection api.RestoreSelection) error {
+	ctx, span := tracing.Start(ctx, "App.RestoreUser")
+	defer span.End()
 
 	//Check that a user is logged
 	loggedInUser, err := app.userInteractor.CurrentUser(ctx)
@@ -197,6 +364,7 @@ func (app App) RestoreUser(ctx context.Context, userID string, s api.Snapshot) e
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUser.ID())
 		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "RestoreUser")
 	}
 
 	//UserID should match
@@ -204,13 +372,38 @@ func (app App) RestoreUser(ctx context.Context, userID string, s api.Snapshot) e
 		return errors.New(fmt.Sprintf("User IDs do not match: '%s' '%s'", userID, s.User.UserID))
 	}
 
-	//No tabs defined for user to update
-	tabs, err := app.repository.GetTabs(ctx, userID)
-	if err != nil {
-		return errors.Wrap(err, "retrieving tab ids from datastore failed")
+	//Nothing is restored from an inconsistent snapshot
+	if err := s.Validate(); err != nil {
+		return invalidArgument("invalid snapshot: " + err.Error())
+	}
+
+	//Select the tabs to restore
+	var selectedTabs []api.Tab
+	for _, t := range s.Tabs {
+		if selection.Includes(t.TabSummary) {
+			selectedTabs = append(selectedTabs, t)
+		}
+	}
+	for _, id := range selection.TabIDs {
+		if !snapshotHasTab(s, func(t api.Tab) bool { return t.ID == id }) {
+			return errors.New(fmt.Sprintf("Tab %d not found in snapshot", id))
+		}
 	}
-	if len(tabs) > 0 {
-		return errors.New(fmt.Sprintf("Restore not possible due %d to existing tabs", len(tabs)))
+	for _, title := range selection.Titles {
+		if !snapshotHasTab(s, func(t api.Tab) bool { return t.Title == title }) {
+			return errors.New("Tab not found in snapshot: " + title)
+		}
+	}
+
+	//No tabs defined for user to update
+	if selection.IsEmpty() {
+		tabs, err := app.repository.GetTabs(ctx, userID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving tab ids from datastore failed")
+		}
+		if len(tabs) > 0 {
+			return errors.New(fmt.Sprintf("Restore not possible due %d to existing tabs", len(tabs)))
+		}
 	}
 
 	//Get account matching
@@ -226,7 +419,11 @@ func (app App) RestoreUser(ctx context.Context, userID string, s api.Snapshot) e
 	for _, a := range s.Accounts {
 		existingID, ok := existingAccounts[a.Key()]
 		if !ok {
-			return errors.New("Restore not possible due to missing account: " + a.Key())
+			//Only the accounts used by the selected tabs are required for a partial restore
+			if selection.IsEmpty() {
+				return errors.New("Restore not possible due to missing account: " + a.Key())
+			}
+			continue
 		}
 		allAccounts[a.ID] = existingID
 	}
@@ -242,12 +439,14 @@ func (app App) RestoreUser(ctx context.Context, userID string, s api.Snapshot) e
 	}
 
 	//Create all tabs and add widgets
-	for _, t := range s.Tabs {
+	for _, t := range selectedTabs {
 
-		newTab, err := app.NewTab(ctx, t.TabSummary)
+		//The tab is created for the restored user, who may not be the current one
+		newTab, err := createTab(ctx, app.repository, userID, t.Title)
 		if err != nil {
 			return errors.Wrap(err, "creating tab failed")
 		}
+		app.audit(ctx, userID, api.AuditTabCreated, fmt.Sprintf("tab:%d", newTab.ID))
 
 		for i, c := range t.Widgets {
 			newTab.Widgets = append(newTab.Widgets, nil)
@@ -256,7 +455,6 @@ func (app App) RestoreUser(ctx context.Context, userID string, s api.Snapshot) e
 
 				newWidget := w
 				newWidget.ID = 0
-				newWidget.SetupTypedConfig()
 
 				//Map account id/feed id in widget configs
 				switch newWidget.Type {
@@ -274,7 +472,7 @@ func (app App) RestoreUser(ctx context.Context, userID string, s api.Snapshot) e
 					var ok bool
 					cfg.AccountID, ok = allAccounts[cfg.AccountID]
 					if !ok {
-						return errors.New("Unknown account ID")
+						return errors.New("Unknown or missing account ID in tab: " + t.Title)
 					}
 					newWidget.Config = cfg
 				}
@@ -293,18 +491,122 @@ func (app App) RestoreUser(ctx context.Context, userID string, s api.Snapshot) e
 		if err != nil {
 			return errors.Wrap(err, "creating tab layout failed")
 		}
+		app.publishLayout(userID, newTab.ID)
+	}
+
+	if selection.Items {
+		err = app.restoreItems(ctx, userID, s, allFeeds)
+		if err != nil {
+			return err
+		}
+	}
+
+	app.audit(ctx, userID, api.AuditUserRestored, "")
+
+	return nil
+}
+
+//restoreItems marks the items of the snapshot as read and stars its starred items, the feeds being mapped
+//from their ID in the snapshot to their ID in datastore
+func (app App) restoreItems(ctx context.Context, userID string, s api.Snapshot, allFeeds map[int64]int64) error {
+
+	for feedID, guids := range s.ReadStatus {
+		id, ok := allFeeds[feedID]
+		if !ok {
+			return errors.New(fmt.Sprintf("Unknown feed ID in read status: %d", feedID))
+		}
+		_, err := app.repository.SetItemsRead(ctx, userID, id, guids, true)
+		if err != nil {
+			return errors.Wrap(err, "restoring read status failed")
+		}
+	}
+
+	for _, item := range s.Starred {
+		id, ok := allFeeds[item.FeedID]
+		if !ok {
+			return errors.New(fmt.Sprintf("Unknown feed ID in starred items: %d", item.FeedID))
+		}
+		item.UserID = userID
+		item.FeedID = id
+
+		var err error
+		item.Tags, err = applyTags(ctx, app.repository, userID, item.Tags)
+		if err != nil {
+			return err
+		}
+		err = app.repository.StoreStarredItem(ctx, item)
+		if err != nil {
+			return errors.Wrap(err, "restoring starred item failed")
+		}
 	}
 
 	return nil
 }
 
+//snapshotHasTab returns true if a tab of the snapshot matches
+func snapshotHasTab(s api.Snapshot, match func(t api.Tab) bool) bool {
+	for _, t := range s.Tabs {
+		if match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+//DeleteUser permanently removes the given user and all its data.
+//The tokens of its accounts are revoked on provider side.
+func (app App) DeleteUser(ctx context.Context, userID string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "App.DeleteUser")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "DeleteUser")
+	if err != nil {
+		return false, err
+	}
+
+	accounts, err := app.repository.GetAccounts(ctx, userID)
+	if err != nil {
+		return false, errors.Wrap(err, "retrieving accounts from datastore failed")
+	}
+
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+		return repo.DeleteUser(ctx, userID)
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "removing user from datastore failed")
+	}
+
+	//Revoke the tokens on provider side, failures do not prevent the removal
+	for _, account := range accounts {
+		if provider, ok := app.providers[account.ProviderName]; ok {
+			if err := provider.Revoke(ctx, account); err != nil {
+				app.Error(ctx, errors.Wrap(err, "revoking token of account "+account.Key()+" failed"))
+			}
+		}
+	}
+
+	//The scheduled backups hold the data of the user as well
+	if err := app.deleteBackups(ctx, userID); err != nil {
+		app.Error(ctx, errors.Wrap(err, "removing backups of user "+userID+" failed"))
+	}
+
+	app.audit(ctx, userID, api.AuditUserDeleted, "user:"+userID)
+
+	return true, nil
+}
+
 //Services returns the list of all available providers
 func (app App) Services(ctx context.Context) ([]api.ProviderDescription, error) {
+	ctx, span := tracing.Start(ctx, "App.Services")
+	defer span.End()
 
 	services := make([]api.ProviderDescription, 0, len(app.providers))
 
+	locale := app.Locale(ctx)
 	for _, provider := range app.providers {
-		services = append(services, provider.Description())
+		description := provider.Description()
+		description.Title = i18n.Translate(locale, description.Title)
+		services = append(services, description)
 	}
 
 	return services, nil
@@ -312,6 +614,8 @@ func (app App) Services(ctx context.Context) ([]api.ProviderDescription, error)
 
 //AssociatedAccount returns the information related to the given account, including the authentication tokens
 func (app App) AssociatedAccount(ctx context.Context, userID string, accountID int64) (api.ExternalAccount, error) {
+	ctx, span := tracing.Start(ctx, "App.AssociatedAccount")
+	defer span.End()
 
 	//Check that a user is logged
 	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
@@ -324,6 +628,7 @@ func (app App) AssociatedAccount(ctx context.Context, userID string, accountID i
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return api.ExternalAccount{}, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
 		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "AssociatedAccount")
 	}
 
 	data, err := app.repository.GetAccount(ctx, userID, accountID)
@@ -334,8 +639,47 @@ func (app App) AssociatedAccount(ctx context.Context, userID string, accountID i
 	return data, nil
 }
 
+//RelabelAccount changes the label displayed for an associated account.
+//An empty label displays the email address of the account again.
+func (app App) RelabelAccount(ctx context.Context, userID string, accountID int64, label string) (api.ExternalAccount, error) {
+	ctx, span := tracing.Start(ctx, "App.RelabelAccount")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.ExternalAccount{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+			return api.ExternalAccount{}, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "RelabelAccount")
+	}
+
+	account, err := app.repository.GetAccount(ctx, userID, accountID)
+	if err != nil {
+		return api.ExternalAccount{}, errors.Wrap(err, "retrieving account from datastore failed")
+	}
+
+	account.Label = strings.TrimSpace(label)
+
+	err = app.repository.StoreAccount(ctx, userID, &account)
+	if err != nil {
+		return api.ExternalAccount{}, errors.Wrap(err, "saving account in datastore failed")
+	}
+
+	app.audit(ctx, userID, api.AuditAccountUpdated, fmt.Sprintf("account:%d", accountID))
+
+	return account, nil
+}
+
 //AssociatedAccounts returns the list of accounts available for the given user
 func (app App) AssociatedAccounts(ctx context.Context, userID string) ([]api.ExternalAccount, error) {
+	ctx, span := tracing.Start(ctx, "App.AssociatedAccounts")
+	defer span.End()
 
 	//Check that a user is logged
 	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
@@ -348,6 +692,7 @@ func (app App) AssociatedAccounts(ctx context.Context, userID string) ([]api.Ext
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return nil, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
 		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "AssociatedAccounts")
 	}
 
 	data, err := app.repository.GetAccounts(ctx, userID)
@@ -360,6 +705,8 @@ func (app App) AssociatedAccounts(ctx context.Context, userID string) ([]api.Ext
 
 //AssociatedServiceAccounts returns the list of accounts available for the given user for a specific provider
 func (app App) AssociatedServiceAccounts(ctx context.Context, userID string, serviceName string) ([]api.ExternalAccount, error) {
+	ctx, span := tracing.Start(ctx, "App.AssociatedServiceAccounts")
+	defer span.End()
 
 	accounts, err := app.AssociatedAccounts(ctx, userID)
 	if err != nil {
@@ -376,8 +723,102 @@ func (app App) AssociatedServiceAccounts(ctx context.Context, userID string, ser
 	return serviceAccounts, nil
 }
 
-//RevokeAccount permanently removes access to the given account
-func (app App) RevokeAccount(ctx context.Context, userID string, accountID int64) (bool, error) {
+//WidgetUsage identifies a widget relying on an account
+type WidgetUsage struct {
+	TabID    int64  `json:"tab_id"`
+	TabTitle string `json:"tab_title"`
+	WidgetID int64  `json:"widget_id"`
+	Title    string `json:"title"`
+}
+
+type accountInUse []WidgetUsage
+
+func (err accountInUse) Error() string {
+	widgets := make([]string, len(err))
+	for i, w := range err {
+		widgets[i] = fmt.Sprintf("%q in tab %q", w.Title, w.TabTitle)
+	}
+	return fmt.Sprintf("account used by %d widget(s): %s", len(err), strings.Join(widgets, ", "))
+}
+func (err accountInUse) IsConflict() bool {
+	return true
+}
+
+//ErrorDetails lists the widgets using the account
+func (err accountInUse) ErrorDetails() interface{} {
+	return []WidgetUsage(err)
+}
+
+//AccountUsage returns the widgets that would break if the given account was revoked
+func (app App) AccountUsage(ctx context.Context, userID string, accountID int64) ([]WidgetUsage, error) {
+	ctx, span := tracing.Start(ctx, "App.AccountUsage")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+			return nil, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "AccountUsage")
+	}
+
+	return app.accountUsage(ctx, userID, accountID)
+}
+
+func (app App) accountUsage(ctx context.Context, userID string, accountID int64) ([]WidgetUsage, error) {
+	return accountUsage(ctx, app.repository, userID, accountID)
+}
+
+//accountUsage lists the widgets displaying the account, using the given repository so that it can be read
+//in the transaction changing the widgets
+func accountUsage(ctx context.Context, repo api.Repository, userID string, accountID int64) ([]WidgetUsage, error) {
+
+	//The tabs shared with the user are not changed by the removal of its accounts
+	tabIDs, err := repo.GetOwnedTabIDs(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving tab ids from datastore failed")
+	}
+
+	usage := []WidgetUsage{}
+	for _, tabID := range tabIDs {
+		tab, err := repo.GetTab(ctx, tabID)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving tab from datastore failed")
+		}
+
+		for _, col := range tab.Widgets {
+			for _, w := range col {
+				if w.Type != api.WidgetEmailType {
+					continue
+				}
+				cfg, ok := w.Config.(api.ConfigEmail)
+				if ok && cfg.AccountID == accountID {
+					usage = append(usage, WidgetUsage{
+						TabID:    tab.ID,
+						TabTitle: tab.Title,
+						WidgetID: w.ID,
+						Title:    cfg.Title,
+					})
+				}
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+//RevokeAccount permanently removes access to the given account.
+//If the account is used by some widgets, the removal fails unless force is set:
+//in that case, the widgets are removed too.
+func (app App) RevokeAccount(ctx context.Context, userID string, accountID int64, force bool) (bool, error) {
+	ctx, span := tracing.Start(ctx, "App.RevokeAccount")
+	defer span.End()
 
 	//Check that a user is logged
 	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
@@ -390,19 +831,55 @@ func (app App) RevokeAccount(ctx context.Context, userID string, accountID int64
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return false, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
 		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "RevokeAccount")
+	}
+
+	account, err := app.repository.GetAccount(ctx, userID, accountID)
+	if err != nil {
+		return false, errors.Wrap(err, "retrieving account from datastore failed")
+	}
+
+	usage, err := app.accountUsage(ctx, userID, accountID)
+	if err != nil {
+		return false, errors.Wrap(err, "computing account usage failed")
+	}
+	if len(usage) > 0 && !force {
+		return false, accountInUse(usage)
 	}
 
-	//Delete the account and associated token
-	err = app.repository.DeleteAccount(ctx, userID, accountID)
+	//Delete the widgets, the account and associated token
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+		for _, u := range usage {
+			if err := repo.DeleteWidgetFromTab(ctx, u.TabID, u.WidgetID); err != nil {
+				return errors.Wrap(err, "removing widget from tab failed")
+			}
+			if err := repo.DeleteWidget(ctx, u.TabID, u.WidgetID); err != nil {
+				return errors.Wrap(err, "removing widget from datastore failed")
+			}
+		}
+
+		return repo.DeleteAccount(ctx, userID, accountID)
+	})
 	if err != nil {
 		return false, errors.Wrap(err, "removing account from datastore failed")
 	}
 
+	//Revoke the token on provider side, failures do not prevent the removal
+	if provider, ok := app.providers[account.ProviderName]; ok {
+		if err := provider.Revoke(ctx, account); err != nil {
+			app.Error(ctx, errors.Wrap(err, "revoking token of account "+account.Key()+" failed"))
+		}
+	}
+
+	app.audit(ctx, userID, api.AuditAccountRevoked, fmt.Sprintf("account:%d", accountID))
+
 	return true, nil
 }
 
 //Tab returns the configuration and layout for the given tab
 func (app App) Tab(ctx context.Context, tabID int64) (api.Tab, error) {
+	ctx, span := tracing.Start(ctx, "App.Tab")
+	defer span.End()
 
 	//Check that a user is logged
 	userID, err := app.userInteractor.CurrentUserID(ctx)
@@ -411,11 +888,14 @@ func (app App) Tab(ctx context.Context, tabID int64) (api.Tab, error) {
 	}
 
 	//Check authorization
+	allowed := true
 	err = app.repository.IsTabAccessAllowed(ctx, userID, tabID)
 	if err != nil {
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return api.Tab{}, errors.Wrap(err, "access by "+userID)
 		}
+		allowed = false
+		app.audit(ctx, "", api.AuditAdminAccess, fmt.Sprintf("Tab tab:%d", tabID))
 	}
 
 	//Get the tab in datastore
@@ -424,11 +904,21 @@ func (app App) Tab(ctx context.Context, tabID int64) (api.Tab, error) {
 		return tab, errors.Wrap(err, "retrieving tab from datastore failed")
 	}
 
+	//Slugs are only given to the tabs of the user
+	if allowed {
+		tab.Slug, err = app.tabSlug(ctx, userID, tab.ID)
+		if err != nil {
+			return tab, err
+		}
+	}
+
 	return tab, nil
 }
 
 //EditTab updates the tab with the given configuration
 func (app App) EditTab(ctx context.Context, tabID int64, newSummary api.TabSummary) (api.Tab, error) {
+	ctx, span := tracing.Start(ctx, "App.EditTab")
+	defer span.End()
 
 	//Check that a user is logged
 	userID, err := app.userInteractor.CurrentUserID(ctx)
@@ -442,6 +932,7 @@ func (app App) EditTab(ctx context.Context, tabID int64, newSummary api.TabSumma
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return api.Tab{}, errors.Wrap(err, "access by "+userID)
 		}
+		app.audit(ctx, "", api.AuditAdminAccess, fmt.Sprintf("EditTab tab:%d", tabID))
 	}
 
 	//Get the tab from datastore
@@ -450,7 +941,14 @@ func (app App) EditTab(ctx context.Context, tabID int64, newSummary api.TabSumma
 		return tab, errors.Wrap(err, "retrieving tab from datastore failed")
 	}
 
+	//A new slug is generated when the title changes, unless explicitly given
+	slugName := newSummary.Slug
+	if len(slugName) == 0 && newSummary.Title != tab.Title {
+		slugName = newSummary.Title
+	}
+
 	newSummary.ID = tabID
+	newSummary.Slug = ""
 	tab.TabSummary = newSummary
 
 	err = app.repository.StoreTab(ctx, &tab)
@@ -458,11 +956,93 @@ func (app App) EditTab(ctx context.Context, tabID int64, newSummary api.TabSumma
 		return tab, errors.Wrap(err, "storing tab into datastore failed")
 	}
 
+	if len(slugName) == 0 {
+		tab.Slug, err = app.tabSlug(ctx, userID, tabID)
+		if err != nil {
+			return tab, err
+		}
+		//The tabs created before the slugs get one
+		if len(tab.Slug) == 0 {
+			slugName = tab.Title
+		}
+	}
+	if len(slugName) > 0 {
+		tab.Slug, err = assignTabSlug(ctx, app.repository, userID, tabID, slugName)
+		if err != nil {
+			return tab, err
+		}
+	}
+
+	app.audit(ctx, userID, api.AuditTabUpdated, fmt.Sprintf("tab:%d", tabID))
+	app.publishLayout(userID, tabID)
+
+	return tab, nil
+}
+
+//SetDefaultTab makes the tab the one opened first by the current user, replacing the previous default tab.
+//Unsetting the default tab makes the first tab opened first. The default tab is unchanged if isDefault is nil.
+func (app App) SetDefaultTab(ctx context.Context, tabID int64, isDefault *bool) (api.Tab, error) {
+	ctx, span := tracing.Start(ctx, "App.SetDefaultTab")
+	defer span.End()
+
+	//Check that a user is logged
+	userID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.Tab{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization, the default tab being one of the tabs of the user
+	err = app.repository.IsTabAccessAllowed(ctx, userID, tabID)
+	if err != nil {
+		return api.Tab{}, errors.Wrap(err, "access by "+userID)
+	}
+
+	wasDefault := false
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+
+		tabs, err := repo.GetTabs(ctx, userID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving tabs from datastore failed")
+		}
+		for _, t := range tabs {
+			if t.ID == tabID {
+				wasDefault = t.Default
+			}
+		}
+
+		switch {
+		case isDefault == nil || *isDefault == wasDefault:
+			return nil
+		case *isDefault:
+			return repo.SetDefaultTab(ctx, userID, tabID)
+		default:
+			return repo.SetDefaultTab(ctx, userID, 0)
+		}
+	})
+	if err != nil {
+		return api.Tab{}, errors.Wrap(err, "saving default tab failed")
+	}
+
+	tab, err := app.Tab(ctx, tabID)
+	if err != nil {
+		return api.Tab{}, err
+	}
+	tab.Default = wasDefault
+	if isDefault == nil || *isDefault == wasDefault {
+		return tab, nil
+	}
+	tab.Default = *isDefault
+
+	app.audit(ctx, userID, api.AuditTabUpdated, fmt.Sprintf("tab:%d", tabID))
+	app.publishLayout(userID, 0)
+
 	return tab, nil
 }
 
 //DeleteTab permanently removes the given tab
 func (app App) DeleteTab(ctx context.Context, tabID int64) (bool, error) {
+	ctx, span := tracing.Start(ctx, "App.DeleteTab")
+	defer span.End()
 
 	//Check that a user is logged
 	userID, err := app.userInteractor.CurrentUserID(ctx)
@@ -476,6 +1056,7 @@ func (app App) DeleteTab(ctx context.Context, tabID int64) (bool, error) {
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return false, errors.Wrap(err, "access by "+userID)
 		}
+		app.audit(ctx, "", api.AuditAdminAccess, fmt.Sprintf("DeleteTab tab:%d", tabID))
 	}
 
 	//Remove the tab from datastore
@@ -484,11 +1065,16 @@ func (app App) DeleteTab(ctx context.Context, tabID int64) (bool, error) {
 		return false, errors.Wrap(err, "removing tab from datastore failed")
 	}
 
+	app.audit(ctx, userID, api.AuditTabDeleted, fmt.Sprintf("tab:%d", tabID))
+	app.publishLayout(userID, tabID)
+
 	return true, nil
 }
 
 //NewTab creates a new tab
 func (app App) NewTab(ctx context.Context, tabDesc api.TabSummary) (api.Tab, error) {
+	ctx, span := tracing.Start(ctx, "App.NewTab")
+	defer span.End()
 
 	//Check that a user is logged
 	userID, err := app.userInteractor.CurrentUserID(ctx)
@@ -496,30 +1082,294 @@ func (app App) NewTab(ctx context.Context, tabDesc api.TabSummary) (api.Tab, err
 		return api.Tab{}, errors.Wrap(err, "retrieving current user failed")
 	}
 
-	var tab api.Tab
-	tab.Title = tabDesc.Title
-	tab.Widgets = [][]api.Widget{
-		[]api.Widget{},
-		[]api.Widget{},
+	tab, err := createTab(ctx, app.repository, userID, tabDesc.Title)
+	if err != nil {
+		return tab, err
+	}
+
+	app.audit(ctx, userID, api.AuditTabCreated, fmt.Sprintf("tab:%d", tab.ID))
+	app.publishLayout(userID, tab.ID)
+
+	return tab, nil
+}
+
+//DuplicateTab copies a tab readable by the current user into a new tab owned by the user, with the same layout
+//and widget configs. The email widgets of accounts of other users are not copied. The title of the copy
+//is the one of the tab followed by "(copy)" if empty.
+func (app App) DuplicateTab(ctx context.Context, tabID int64, title string) (api.Tab, error) {
+	ctx, span := tracing.Start(ctx, "App.DuplicateTab")
+	defer span.End()
+
+	source, err := app.Tab(ctx, tabID)
+	if err != nil {
+		return api.Tab{}, err
+	}
+
+	userID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.Tab{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	title = strings.TrimSpace(title)
+	if len(title) == 0 {
+		title = source.Title + " (copy)"
+	}
+
+	var tab api.Tab
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+
+		tab, err = createTab(ctx, repo, userID, title)
+		if err != nil {
+			return err
+		}
+
+		tab.Widgets = make([][]api.Widget, 0, len(source.Widgets))
+		for _, col := range source.Widgets {
+			copied := []api.Widget{}
+			for _, w := range col {
+				if cfg, ok := w.Config.(api.ConfigEmail); ok {
+					_, err := repo.GetAccount(ctx, userID, cfg.AccountID)
+					if repo.IsNotFound(err) {
+						continue
+					}
+					if err != nil {
+						return errors.Wrap(err, "retrieving account from datastore failed")
+					}
+				}
+
+				//The tags are the ones of the user owning the copy
+				if common, ok := widgetConfig(w); ok {
+					common.Tags, err = applyTags(ctx, repo, userID, common.Tags)
+					if err != nil {
+						return err
+					}
+					setWidgetConfig(&w, common)
+				}
+				if cfg, ok := w.Config.(api.ConfigCollection); ok {
+					if _, err := applyTags(ctx, repo, userID, []string{cfg.Tag}); err != nil {
+						return err
+					}
+				}
+
+				w.ID = 0
+				err = repo.StoreWidget(ctx, tab.ID, &w)
+				if err != nil {
+					return errors.Wrap(err, "saving widget in datastore failed")
+				}
+				copied = append(copied, w)
+			}
+			tab.Widgets = append(tab.Widgets, copied)
+		}
+
+		err = repo.StoreTab(ctx, &tab)
+		if err != nil {
+			return errors.Wrap(err, "saving tab in datastore failed")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return api.Tab{}, err
+	}
+
+	now := time.Now()
+	for _, col := range tab.Widgets {
+		for _, w := range col {
+			err = app.startWidgetTracking(ctx, userID, tab.ID, w.ID, now)
+			if err != nil {
+				app.Error(ctx, errors.Wrap(err, "recording widget view failed"))
+			}
+		}
+	}
+
+	app.audit(ctx, userID, api.AuditTabCreated, fmt.Sprintf("tab:%d", tab.ID))
+	app.publishLayout(userID, tab.ID)
+
+	return tab, nil
+}
+
+//createTab stores a new empty tab owned by the given user
+func createTab(ctx context.Context, repo api.Repository, userID string, title string) (api.Tab, error) {
+
+	var tab api.Tab
+	tab.Title = title
+	tab.Widgets = [][]api.Widget{
+		[]api.Widget{},
+		[]api.Widget{},
 		[]api.Widget{},
 		[]api.Widget{},
 	}
 
-	err = app.repository.StoreTab(ctx, &tab)
+	err := repo.StoreTab(ctx, &tab)
 	if err != nil {
 		return api.Tab{}, errors.Wrap(err, "saving tab in datastore failed")
 	}
 
-	err = app.repository.AllowTabAccess(ctx, userID, tab.ID)
+	err = repo.AllowTabAccess(ctx, userID, tab.ID)
 	if err != nil {
 		return api.Tab{}, errors.Wrap(err, "saving tab access rules in datastore failed")
 	}
 
+	tab.Slug, err = assignTabSlug(ctx, repo, userID, tab.ID, title)
+	if err != nil {
+		return api.Tab{}, err
+	}
+
 	return tab, nil
 }
 
-//NewWidget adds a widget to the current tab
-func (app App) NewWidget(ctx context.Context, tabID int64, widget api.Widget) (api.Widget, error) {
+//ReorderTabs orders the tabs of the given user, the tabs not listed being put after.
+//The order is specific to the user, the other users of shared tabs keeping theirs.
+func (app App) ReorderTabs(ctx context.Context, userID string, order []int64) ([]api.TabSummary, error) {
+	ctx, span := tracing.Start(ctx, "App.ReorderTabs")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "ReorderTabs")
+	if err != nil {
+		return nil, err
+	}
+
+	var tabs []api.TabSummary
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+
+		tabs, err = repo.GetTabs(ctx, userID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving tabs from datastore failed")
+		}
+
+		tabs, err = orderTabs(ctx, repo, userID, tabs, order)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	app.audit(ctx, userID, api.AuditLayoutUpdated, "tabs")
+	app.publishLayout(userID, 0)
+
+	return tabs, nil
+}
+
+//orderTabs stores the order of the tabs of the user, the tabs not listed being put after, and returns the ordered tabs
+func orderTabs(ctx context.Context, repo api.Repository, userID string, tabs []api.TabSummary, requested []int64) ([]api.TabSummary, error) {
+
+	existing := make(map[int64]bool, len(tabs))
+	for _, t := range tabs {
+		existing[t.ID] = true
+	}
+
+	ordered := make(map[int64]bool, len(requested))
+	var order []int64
+	for _, tabID := range requested {
+		if !existing[tabID] {
+			return nil, invalidArgument(fmt.Sprintf("tab %d is not a tab of user %s", tabID, userID))
+		}
+		if ordered[tabID] {
+			continue
+		}
+		ordered[tabID] = true
+		order = append(order, tabID)
+	}
+	for _, t := range tabs {
+		if !ordered[t.ID] {
+			order = append(order, t.ID)
+		}
+	}
+
+	if err := repo.UpdateTabPositions(ctx, userID, order); err != nil {
+		return nil, errors.Wrap(err, "saving tab order in datastore failed")
+	}
+
+	tabs, err := repo.GetTabs(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving tabs from datastore failed")
+	}
+
+	return tabs, nil
+}
+
+//BulkTabs creates, deletes and reorders several tabs of the given user in a single transaction.
+//Nothing is changed if any operation fails.
+func (app App) BulkTabs(ctx context.Context, userID string, request api.TabBulkRequest) (api.TabBulkResult, error) {
+	ctx, span := tracing.Start(ctx, "App.BulkTabs")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.TabBulkResult{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+			return api.TabBulkResult{}, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "BulkTabs")
+	}
+
+	result := api.TabBulkResult{
+		Created: []api.Tab{},
+		Deleted: []int64{},
+	}
+
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+
+		for _, desc := range request.Create {
+			tab, err := createTab(ctx, repo, userID, desc.Title)
+			if err != nil {
+				return err
+			}
+			result.Created = append(result.Created, tab)
+		}
+
+		for _, tabID := range request.Delete {
+			if err := repo.IsTabAccessAllowed(ctx, userID, tabID); err != nil {
+				return errors.Wrapf(err, "access to tab %d denied", tabID)
+			}
+			if err := repo.DeleteTab(ctx, tabID); err != nil {
+				return errors.Wrapf(err, "removing tab %d from datastore failed", tabID)
+			}
+			result.Deleted = append(result.Deleted, tabID)
+		}
+
+		tabs, err := repo.GetTabs(ctx, userID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving tabs from datastore failed")
+		}
+
+		if len(request.Order) > 0 {
+			tabs, err = orderTabs(ctx, repo, userID, tabs, request.Order)
+			if err != nil {
+				return err
+			}
+		}
+
+		result.Tabs = tabs
+		return nil
+	})
+	if err != nil {
+		return api.TabBulkResult{}, err
+	}
+
+	for _, t := range result.Created {
+		app.audit(ctx, userID, api.AuditTabCreated, fmt.Sprintf("tab:%d", t.ID))
+	}
+	for _, tabID := range request.Delete {
+		app.audit(ctx, userID, api.AuditTabDeleted, fmt.Sprintf("tab:%d", tabID))
+	}
+	if len(request.Order) > 0 {
+		app.audit(ctx, userID, api.AuditLayoutUpdated, "tabs")
+	}
+	app.publishLayout(userID, 0)
+
+	return result, nil
+}
+
+//NewWidget adds a widget to the current tab, at the given position or at the end of the first column if nil
+func (app App) NewWidget(ctx context.Context, tabID int64, widget api.Widget, position *api.WidgetPosition) (api.Widget, error) {
+	ctx, span := tracing.Start(ctx, "App.NewWidget")
+	defer span.End()
 
 	//Check that a user is logged
 	userID, err := app.userInteractor.CurrentUserID(ctx)
@@ -533,14 +1383,35 @@ func (app App) NewWidget(ctx context.Context, tabID int64, widget api.Widget) (a
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return api.Widget{}, errors.Wrap(err, "access by "+userID)
 		}
+		app.audit(ctx, "", api.AuditAdminAccess, fmt.Sprintf("NewWidget tab:%d", tabID))
+	}
+
+	if common, ok := widgetConfig(widget); ok {
+		err = validateLinkPolicies(common.LinkPolicies)
+		if err != nil {
+			return api.Widget{}, err
+		}
 	}
 
 	switch widget.Type {
 	case api.WidgetFeedType:
 		cfg := widget.Config.(api.ConfigFeed)
 		cfg.FeedID = 0
+		if len(cfg.URL) == 0 {
+			return api.Widget{}, errors.New("Feed URL is missing")
+		}
+		if len(cfg.Mode) > 0 && cfg.Mode != api.FeedModeDigest {
+			return api.Widget{}, errors.New("Unknown feed widget mode: " + cfg.Mode)
+		}
 		if cfg.DisplayCount <= 0 {
-			cfg.DisplayCount = 5 //TODO use configurable constante
+			cfg.DisplayCount = api.DefaultDisplayCount
+		}
+		cfg.Version = api.WidgetConfigVersion(widget.Type)
+
+		//Check managed policy
+		err = app.checkManagedAccess(ctx, userID, api.ApprovalFeed, cfg.URL)
+		if err != nil {
+			return api.Widget{}, errors.Wrap(err, "feed not allowed")
 		}
 
 		//Get or create the feed
@@ -565,8 +1436,9 @@ func (app App) NewWidget(ctx context.Context, tabID int64, widget api.Widget) (a
 	case api.WidgetEmailType:
 		cfg := widget.Config.(api.ConfigEmail)
 		if cfg.DisplayCount <= 0 {
-			cfg.DisplayCount = 5 //TODO use configurable constante
+			cfg.DisplayCount = api.DefaultDisplayCount
 		}
+		cfg.Version = api.WidgetConfigVersion(widget.Type)
 
 		account, err := app.repository.GetAccount(ctx, userID, cfg.AccountID)
 		if err != nil {
@@ -578,42 +1450,285 @@ func (app App) NewWidget(ctx context.Context, tabID int64, widget api.Widget) (a
 			return api.Widget{}, errors.New("Unknown service: " + account.ProviderName)
 		}
 
+		//Check managed policy
+		err = app.checkManagedAccess(ctx, userID, api.ApprovalProvider, account.ProviderName)
+		if err != nil {
+			return api.Widget{}, errors.Wrap(err, "service not allowed")
+		}
+
 		if len(cfg.Title) == 0 {
-			cfg.Title = provider.Description().Title
+			cfg.Title = app.translate(ctx, provider.Description().Title)
+			if len(account.Label) > 0 {
+				cfg.Title = account.Label
+			}
 		}
 		if len(cfg.Link) == 0 {
 			cfg.Link = provider.Description().Link
 		}
 
+		widget.Config = cfg
+
+	case api.WidgetCollectionType:
+		cfg := widget.Config.(api.ConfigCollection)
+		if cfg.DisplayCount <= 0 {
+			cfg.DisplayCount = api.DefaultDisplayCount
+		}
+		cfg.Version = api.WidgetConfigVersion(widget.Type)
+
+		tag, err := findTag(ctx, app.repository, userID, cfg.Tag)
+		if err != nil {
+			return api.Widget{}, errors.Wrap(err, "tag retrieval failed")
+		}
+		if len(cfg.Title) == 0 {
+			cfg.Title = tag.Name
+		}
+
 		widget.Config = cfg
 	}
 
-	//Store the new widget within the tab
-	tab, err := app.repository.GetTab(ctx, tabID)
+	//Create the unknown tags applied on the widget
+	if common, ok := widgetConfig(widget); ok {
+		common.Tags, err = applyTags(ctx, app.repository, userID, common.Tags)
+		if err != nil {
+			return api.Widget{}, err
+		}
+		setWidgetConfig(&widget, common)
+	}
+
+	if position == nil {
+		position = &api.WidgetPosition{Position: -1}
+	}
+	if position.Column < 0 {
+		return api.Widget{}, invalidArgument(fmt.Sprintf("invalid widget column: %d", position.Column))
+	}
+
+	//Store the new widget within the tab, the layout being read and written in the same transaction
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+
+		tab, err := repo.GetTab(ctx, tabID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving tab from datastore failed")
+		}
+		if len(tab.Widgets) == 0 {
+			tab.Widgets = [][]api.Widget{[]api.Widget{}}
+		}
+		if position.Column > len(tab.Widgets) {
+			return invalidArgument(fmt.Sprintf("invalid widget column: %d", position.Column))
+		}
+
+		err = repo.StoreWidget(ctx, tabID, &widget)
+		if err != nil {
+			return errors.Wrap(err, "saving widget in datastore failed")
+		}
+
+		tab.Widgets = insertWidget(tab.Widgets, widget, *position)
+
+		err = repo.StoreTab(ctx, &tab)
+		if err != nil {
+			return errors.Wrap(err, "saving tab in datastore failed")
+		}
+
+		return nil
+	})
 	if err != nil {
-		return api.Widget{}, errors.Wrap(err, "retrieving tab from datastore failed")
+		return api.Widget{}, err
 	}
 
-	err = app.repository.StoreWidget(ctx, tabID, &widget)
+	//The widget is not stale until it was left unviewed for a while
+	err = app.startWidgetTracking(ctx, userID, tabID, widget.ID, time.Now())
 	if err != nil {
-		return api.Widget{}, errors.Wrap(err, "saving widget in datastore failed")
+		app.Error(ctx, errors.Wrap(err, "recording widget view failed"))
 	}
 
-	if len(tab.Widgets) == 0 {
-		tab.Widgets = [][]api.Widget{[]api.Widget{}}
+	app.audit(ctx, userID, api.AuditWidgetCreated, fmt.Sprintf("widget:%d/%d", tabID, widget.ID))
+	app.publishLayout(userID, tabID)
+
+	return widget, nil
+}
+
+//insertWidget inserts a widget in the layout at the given position, adding a column if needed
+func insertWidget(layout [][]api.Widget, widget api.Widget, position api.WidgetPosition) [][]api.Widget {
+
+	if position.Column == len(layout) {
+		layout = append(layout, []api.Widget{})
 	}
-	tab.Widgets[0] = append(tab.Widgets[0], widget)
 
-	err = app.repository.StoreTab(ctx, &tab)
+	col := layout[position.Column]
+	if position.Position < 0 || position.Position >= len(col) {
+		layout[position.Column] = append(col, widget)
+		return layout
+	}
+
+	inserted := make([]api.Widget, 0, len(col)+1)
+	inserted = append(inserted, col[:position.Position]...)
+	inserted = append(inserted, widget)
+	inserted = append(inserted, col[position.Position:]...)
+	layout[position.Column] = inserted
+
+	return layout
+}
+
+//MaxBulkWidgets is the maximum number of widgets created at once
+const MaxBulkWidgets = 100
+
+//NewFeedWidgets creates the feeds and their widgets in a single transaction, nothing being created if any fails.
+//The feeds are retrieved concurrently beforehand, the ones failing to be retrieved or not allowed being reported
+//instead of being added. Each widget is appended to the shortest column, columns being added up to the requested number.
+func (app App) NewFeedWidgets(ctx context.Context, tabID int64, request api.WidgetBulkRequest) (api.WidgetBulkResult, error) {
+	ctx, span := tracing.Start(ctx, "App.NewFeedWidgets")
+	defer span.End()
+
+	//Check that a user is logged
+	userID, err := app.userInteractor.CurrentUserID(ctx)
 	if err != nil {
-		return api.Widget{}, errors.Wrap(err, "saving tab in datastore failed")
+		return api.WidgetBulkResult{}, errors.Wrap(err, "retrieving current user failed")
 	}
 
-	return widget, nil
+	//Check authorization
+	err = app.repository.IsTabAccessAllowed(ctx, userID, tabID)
+	if err != nil {
+		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+			return api.WidgetBulkResult{}, errors.Wrap(err, "access by "+userID)
+		}
+		app.audit(ctx, "", api.AuditAdminAccess, fmt.Sprintf("NewFeedWidgets tab:%d", tabID))
+	}
+
+	var urls []string
+	seen := make(map[string]bool)
+	for _, u := range request.URLs {
+		u = strings.TrimSpace(u)
+		if len(u) == 0 || seen[u] {
+			continue
+		}
+		seen[u] = true
+		urls = append(urls, u)
+	}
+	if len(urls) == 0 {
+		return api.WidgetBulkResult{}, invalidArgument("no feed URL")
+	}
+	if len(urls) > MaxBulkWidgets {
+		return api.WidgetBulkResult{}, invalidArgument(fmt.Sprintf("too many feeds: %d, at most %d", len(urls), MaxBulkWidgets))
+	}
+	if request.Columns < 0 {
+		return api.WidgetBulkResult{}, invalidArgument(fmt.Sprintf("invalid number of columns: %d", request.Columns))
+	}
+
+	//The feeds are checked and their titles retrieved before the transaction, as it may take a while.
+	//The downloads are bounded by the feed limiter.
+	previews := make([]PreviewResult, len(urls))
+	failed := make([]string, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			if err := app.checkManagedAccess(ctx, userID, api.ApprovalFeed, u); err != nil {
+				app.Error(ctx, errors.Wrap(err, "feed not allowed: "+u))
+				failed[i] = "feed not allowed"
+				return
+			}
+			preview, err := app.Preview(ctx, u)
+			if err != nil {
+				app.Error(ctx, errors.Wrap(err, "invalid feed: "+u))
+				failed[i] = "feed not retrieved"
+				return
+			}
+			previews[i] = preview
+		}(i, u)
+	}
+	wg.Wait()
+
+	locale := app.Locale(ctx)
+	result := api.WidgetBulkResult{Widgets: []api.Widget{}}
+	widgets := make([]api.Widget, 0, len(urls))
+	for i, u := range urls {
+		if len(failed[i]) > 0 {
+			result.Failed = append(result.Failed, api.WidgetBulkFailure{URL: u, Reason: i18n.Translate(locale, failed[i])})
+			continue
+		}
+
+		widgets = append(widgets, api.NewWidgetFeed(0, api.ConfigFeed{
+			WidgetConfig: api.WidgetConfig{
+				Title:        previews[i].Title,
+				DisplayCount: api.DefaultDisplayCount,
+				Version:      api.WidgetConfigVersion(api.WidgetFeedType),
+			},
+			URL: u,
+		}))
+	}
+	if len(widgets) == 0 {
+		return result, nil
+	}
+
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+
+		tab, err := repo.GetTab(ctx, tabID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving tab from datastore failed")
+		}
+		if len(tab.Widgets) == 0 {
+			tab.Widgets = [][]api.Widget{[]api.Widget{}}
+		}
+		for len(tab.Widgets) < request.Columns {
+			tab.Widgets = append(tab.Widgets, []api.Widget{})
+		}
+		columns := len(tab.Widgets)
+		if request.Columns > 0 {
+			columns = request.Columns
+		}
+
+		for i := range widgets {
+			cfg := widgets[i].Config.(api.ConfigFeed)
+			cfg.FeedID, err = repo.GetOrCreateFeedID(ctx, cfg.URL)
+			if err != nil {
+				return errors.Wrap(err, "unable to create feed "+cfg.URL)
+			}
+			widgets[i].Config = cfg
+
+			err = repo.StoreWidget(ctx, tabID, &widgets[i])
+			if err != nil {
+				return errors.Wrap(err, "saving widget in datastore failed")
+			}
+
+			shortest := 0
+			for c := 1; c < columns; c++ {
+				if len(tab.Widgets[c]) < len(tab.Widgets[shortest]) {
+					shortest = c
+				}
+			}
+			tab.Widgets[shortest] = append(tab.Widgets[shortest], widgets[i])
+		}
+
+		err = repo.StoreTab(ctx, &tab)
+		if err != nil {
+			return errors.Wrap(err, "saving tab in datastore failed")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return api.WidgetBulkResult{}, err
+	}
+
+	now := time.Now()
+	for _, w := range widgets {
+		err = app.startWidgetTracking(ctx, userID, tabID, w.ID, now)
+		if err != nil {
+			app.Error(ctx, errors.Wrap(err, "recording widget view failed"))
+		}
+		app.audit(ctx, userID, api.AuditWidgetCreated, fmt.Sprintf("widget:%d/%d", tabID, w.ID))
+	}
+	app.publishLayout(userID, tabID)
+
+	result.Widgets = widgets
+	return result, nil
 }
 
 //DeleteWidget permanently removes a widget
 func (app App) DeleteWidget(ctx context.Context, tabID int64, widgetID int64) (bool, error) {
+	ctx, span := tracing.Start(ctx, "App.DeleteWidget")
+	defer span.End()
+
 	//Check that a user is logged
 	userID, err := app.userInteractor.CurrentUserID(ctx)
 	if err != nil {
@@ -626,6 +1741,7 @@ func (app App) DeleteWidget(ctx context.Context, tabID int64, widgetID int64) (b
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return false, errors.Wrap(err, "access by "+userID)
 		}
+		app.audit(ctx, "", api.AuditAdminAccess, fmt.Sprintf("DeleteWidget tab:%d", tabID))
 	}
 
 	app.Infof(ctx, "Removing widget %d %d", tabID, widgetID)
@@ -641,12 +1757,17 @@ func (app App) DeleteWidget(ctx context.Context, tabID int64, widgetID int64) (b
 		return false, errors.Wrap(err, "removing widget from datastore failed")
 	}
 
+	app.audit(ctx, userID, api.AuditWidgetDeleted, fmt.Sprintf("widget:%d/%d", tabID, widgetID))
+	app.publishLayout(userID, tabID)
+
 	return true, nil
 
 }
 
 //EditWidget updates the widget configuration
-func (app App) EditWidget(ctx context.Context, tabID int64, widgetID int64, newConfig api.WidgetConfig) (api.Widget, error) {
+func (app App) EditWidget(ctx context.Context, tabID int64, widgetID int64, newConfig api.EditedWidgetConfig) (api.Widget, error) {
+	ctx, span := tracing.Start(ctx, "App.EditWidget")
+	defer span.End()
 
 	//Check that a user is logged
 	userID, err := app.userInteractor.CurrentUserID(ctx)
@@ -660,16 +1781,31 @@ func (app App) EditWidget(ctx context.Context, tabID int64, widgetID int64, newC
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return api.Widget{}, errors.Wrap(err, "access by "+userID)
 		}
+		app.audit(ctx, "", api.AuditAdminAccess, fmt.Sprintf("EditWidget tab:%d", tabID))
 	}
 
 	app.Infof(ctx, "Editing widget %d %d", tabID, widgetID)
 
+	err = validateLinkPolicies(newConfig.LinkPolicies)
+	if err != nil {
+		return api.Widget{}, err
+	}
+	if newConfig.Mode != nil && len(*newConfig.Mode) > 0 && *newConfig.Mode != api.FeedModeDigest {
+		return api.Widget{}, invalidArgument("unknown feed widget mode: " + *newConfig.Mode)
+	}
+
 	//Get current version
 	widget, err := app.repository.GetWidget(ctx, tabID, widgetID)
 	if err != nil {
 		return api.Widget{}, errors.Wrap(err, "retrieving widget from datastore failed")
 	}
 
+	//Create the unknown tags applied on the widget
+	tags, err := applyTags(ctx, app.repository, userID, newConfig.Tags)
+	if err != nil {
+		return api.Widget{}, err
+	}
+
 	switch widget.Type {
 	case api.WidgetFeedType:
 		cfg, ok := widget.Config.(api.ConfigFeed)
@@ -679,6 +1815,11 @@ func (app App) EditWidget(ctx context.Context, tabID int64, widgetID int64, newC
 
 		cfg.Title = newConfig.Title
 		cfg.DisplayCount = newConfig.DisplayCount
+		cfg.LinkPolicies = newConfig.LinkPolicies
+		cfg.Tags = tags
+		if newConfig.Mode != nil {
+			cfg.Mode = *newConfig.Mode
+		}
 
 		widget.Config = cfg
 	case api.WidgetEmailType:
@@ -689,6 +1830,20 @@ func (app App) EditWidget(ctx context.Context, tabID int64, widgetID int64, newC
 
 		cfg.Title = newConfig.Title
 		cfg.DisplayCount = newConfig.DisplayCount
+		cfg.LinkPolicies = newConfig.LinkPolicies
+		cfg.Tags = tags
+
+		widget.Config = cfg
+	case api.WidgetCollectionType:
+		cfg, ok := widget.Config.(api.ConfigCollection)
+		if !ok {
+			return api.Widget{}, errors.New("Invalid widget config type")
+		}
+
+		cfg.Title = newConfig.Title
+		cfg.DisplayCount = newConfig.DisplayCount
+		cfg.LinkPolicies = newConfig.LinkPolicies
+		cfg.Tags = tags
 
 		widget.Config = cfg
 	}
@@ -698,12 +1853,17 @@ func (app App) EditWidget(ctx context.Context, tabID int64, widgetID int64, newC
 		return api.Widget{}, errors.Wrap(err, "updating widget in datastore failed")
 	}
 
+	app.audit(ctx, userID, api.AuditWidgetUpdated, fmt.Sprintf("widget:%d/%d", tabID, widgetID))
+	app.publishLayout(userID, tabID)
+
 	return widget, nil
 
 }
 
 //UpdateLayout reorganises the content of a tab, based on the given widget id lists
 func (app App) UpdateLayout(ctx context.Context, tabID int64, layout [][]int64) ([][]int64, error) {
+	ctx, span := tracing.Start(ctx, "App.UpdateLayout")
+	defer span.End()
 
 	//Check that a user is logged
 	userID, err := app.userInteractor.CurrentUserID(ctx)
@@ -717,6 +1877,7 @@ func (app App) UpdateLayout(ctx context.Context, tabID int64, layout [][]int64)
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return nil, errors.Wrap(err, "access by "+userID)
 		}
+		app.audit(ctx, "", api.AuditAdminAccess, fmt.Sprintf("UpdateLayout tab:%d", tabID))
 	}
 
 	//Update the tab layout
@@ -725,6 +1886,9 @@ func (app App) UpdateLayout(ctx context.Context, tabID int64, layout [][]int64)
 		return nil, errors.Wrap(err, "saving tab in datastore failed")
 	}
 
+	app.audit(ctx, userID, api.AuditLayoutUpdated, fmt.Sprintf("tab:%d", tabID))
+	app.publishLayout(userID, tabID)
+
 	return layout, nil
 }
 
@@ -741,8 +1905,11 @@ type PreviewResult struct {
 	Items []PreviewItem `json:"items"`
 }
 
-//Preview returns the content of the feed at the given URL
+//Preview returns the content of the feed at the given URL.
+//Recent previews and stored feeds are reused instead of fetching the URL again.
 func (app App) Preview(ctx context.Context, URL string) (PreviewResult, error) {
+	ctx, span := tracing.Start(ctx, "App.Preview")
+	defer span.End()
 
 	//Check that a user is logged
 	_, err := app.userInteractor.CurrentUserID(ctx)
@@ -750,9 +1917,53 @@ func (app App) Preview(ctx context.Context, URL string) (PreviewResult, error) {
 		return PreviewResult{}, errors.Wrap(err, "retrieving current user failed")
 	}
 
+	if res, ok := app.previews.get(URL); ok {
+		return res, nil
+	}
+
+	res, err := app.preview(ctx, URL)
+	if err != nil {
+		return PreviewResult{}, err
+	}
+
+	app.previews.put(URL, res)
+	return res, nil
+}
+
+func (app App) preview(ctx context.Context, URL string) (PreviewResult, error) {
+
+	//Known feeds are retrieved only if their stored items are outdated
+	feedID, err := app.repository.GetFeedID(ctx, URL)
+	if err == nil {
+		feed, items, err := app.feed(ctx, feedID, true)
+		if err != nil {
+			return PreviewResult{}, err
+		}
+
+		res := PreviewResult{Title: feed.Title}
+		for _, item := range items {
+			res.Items = append(res.Items, PreviewItem{
+				Title:     item.Title,
+				Published: item.Published,
+				Link:      item.Link,
+			})
+		}
+		return res, nil
+	}
+	if !app.repository.IsNotFound(err) {
+		return PreviewResult{}, errors.Wrap(err, "retrieving feed from datastore failed")
+	}
+
 	//Get external feed
+	release, err := app.feedLimiter.acquire(ctx, URL)
+	if err != nil {
+		return PreviewResult{}, err
+	}
+	defer release()
+
 	fp := gofeed.NewParser()
-	extFeed, err := fp.ParseURL(URL)
+	fp.Client = app.feedClient(URL)
+	extFeed, err := fp.ParseURLWithContext(URL, ctx)
 	if err != nil {
 		return PreviewResult{}, errors.Wrap(err, "retrieving feed failed")
 	}
@@ -786,52 +1997,117 @@ func (app App) feed(ctx context.Context, feedID int64, loadItems bool) (api.Feed
 	}
 
 	//Retrieve latest version
-	tNow := time.Now()
-
-	if tNow.After(feed.NextRetrieval) {
+	if time.Now().After(feed.NextRetrieval) {
+		return app.retrieveFeed(ctx, feed)
+	}
 
-		fp := gofeed.NewParser()
-		extFeed, err := fp.ParseURL(feed.URL)
+	var feedItems []api.FeedItem
+	if loadItems {
+		feedItems, err = app.repository.GetFeedItems(ctx, feedID)
 		if err != nil {
-			return feed, nil, errors.Wrap(err, "retrieving feed failed")
+			return feed, nil, errors.Wrap(err, "retrieving feed items from datastore failed")
 		}
+	}
 
-		feed.NextRetrieval = tNow.Add(time.Duration(15) * time.Minute) //TODO get this from http client
-		feed.Title = extFeed.Title
+	return feed, feedItems, nil
+}
 
-		feedItems := make([]api.FeedItem, 0, len(extFeed.Items))
-		for _, extItem := range extFeed.Items {
+//retrieveFeed downloads the latest version of the feed and stores it in background
+func (app App) retrieveFeed(ctx context.Context, feed api.Feed) (api.Feed, []api.FeedItem, error) {
 
-			if extItem.PublishedParsed == nil {
-				tNow := time.Now()
-				extItem.PublishedParsed = &tNow
-			}
+	feed, feedItems, err := app.downloadFeed(ctx, feed)
+	if err != nil {
+		return feed, nil, err
+	}
+	//The feeds retrieved in the background, without user, are not counted
+	if userID, err := app.userInteractor.CurrentUserID(ctx); err == nil {
+		app.recordUsage(ctx, userID, api.Usage{FeedsRefreshed: 1})
+	}
 
-			feedItems = append(feedItems, api.FeedItem{
-				GUID:      extItem.GUID,
-				Title:     extItem.Title,
-				Published: *extItem.PublishedParsed,
-				Link:      extItem.Link,
-			})
+	//Store in datastore, the shutdown waiting for it
+	app.goBackground(func() {
+		err := app.storeFeed(context.Background(), feed, feedItems)
+		if err != nil {
+			app.Error(ctx, err)
 		}
+	})
 
-		//Store in datastore
-		go func() {
-			err := app.repository.StoreFeed(context.Background(), &feed, feedItems)
-			if err != nil {
-				app.Error(ctx, errors.Wrap(err, "storage of feed failed"))
-			}
-		}()
+	return feed, feedItems, nil
+}
+
+//refreshDueFeed downloads the latest version of the feed if due, and stores it before returning,
+//so that its items are read from datastore with the status of the user
+func (app App) refreshDueFeed(ctx context.Context, feedID int64) (api.Feed, error) {
+
+	feed, err := app.repository.GetFeed(ctx, feedID)
+	if err != nil {
+		return feed, errors.Wrap(err, "retrieving feed from datastore failed")
+	}
+	if !time.Now().After(feed.NextRetrieval) {
+		return feed, nil
+	}
 
-		return feed, feedItems, nil
+	feed, feedItems, err := app.downloadFeed(ctx, feed)
+	if err != nil {
+		return feed, err
+	}
+	if userID, err := app.userInteractor.CurrentUserID(ctx); err == nil {
+		app.recordUsage(ctx, userID, api.Usage{FeedsRefreshed: 1})
 	}
 
-	var feedItems []api.FeedItem
-	if loadItems {
-		feedItems, err = app.repository.GetFeedItems(ctx, feedID)
-		if err != nil {
-			return feed, nil, errors.Wrap(err, "retrieving feed items from datastore failed")
+	return feed, app.storeFeed(ctx, feed, feedItems)
+}
+
+//storeFeed stores the downloaded version of the feed and notifies the users displaying it
+func (app App) storeFeed(ctx context.Context, feed api.Feed, feedItems []api.FeedItem) error {
+
+	err := app.repository.StoreFeed(ctx, &feed, feedItems)
+	if err != nil {
+		return errors.Wrap(err, "storage of feed failed")
+	}
+	app.publishFeedItems(ctx, feed.ID)
+
+	return nil
+}
+
+//downloadFeed downloads the latest version of the feed
+func (app App) downloadFeed(ctx context.Context, feed api.Feed) (api.Feed, []api.FeedItem, error) {
+
+	tNow := time.Now()
+
+	release, err := app.feedLimiter.acquire(ctx, feed.URL)
+	if err != nil {
+		return feed, nil, err
+	}
+	defer release()
+
+	ctx, span := tracing.Start(ctx, "Feed.Download", attribute.Int64("feed.id", feed.ID))
+	fp := gofeed.NewParser()
+	fp.Client = app.feedClient(feed.URL)
+	extFeed, err := fp.ParseURLWithContext(feed.URL, ctx)
+	tracing.End(span, err)
+	if err != nil {
+		return feed, nil, errors.Wrap(err, "retrieving feed failed")
+	}
+
+	feed.NextRetrieval = tNow.Add(time.Duration(15) * time.Minute) //TODO get this from http client
+	feed.Title = extFeed.Title
+
+	feedItems := make([]api.FeedItem, 0, len(extFeed.Items))
+	for _, extItem := range extFeed.Items {
+
+		if extItem.PublishedParsed == nil {
+			tNow := time.Now()
+			extItem.PublishedParsed = &tNow
 		}
+
+		feedItems = append(feedItems, api.FeedItem{
+			GUID:      extItem.GUID,
+			Title:     extItem.Title,
+			Published: *extItem.PublishedParsed,
+			Link:      extItem.Link,
+			ImageURL:  itemImage(extItem),
+		})
 	}
 
 	return feed, feedItems, nil
@@ -839,6 +2115,8 @@ func (app App) feed(ctx context.Context, feedID int64, loadItems bool) (api.Feed
 
 //Widget returns the widget configuration
 func (app App) Widget(ctx context.Context, tabID int64, widgetID int64) (api.Widget, error) {
+	ctx, span := tracing.Start(ctx, "App.Widget")
+	defer span.End()
 
 	tab, err := app.Tab(ctx, tabID)
 	if err != nil {
@@ -856,8 +2134,37 @@ func (app App) Widget(ctx context.Context, tabID int64, widgetID int64) (api.Wid
 	return api.Widget{}, errors.Wrap(errors.New("widget not found"), "invalid widget id") //TODO: manage in datastore or send a NotFound error
 }
 
-//FeedItems returns the items of a feed and the reading status for the given user
-func (app App) FeedItems(ctx context.Context, userID string, feedID int64) ([]api.ItemForUser, error) {
+//optionalWidget returns the widget displaying the requested items, or an empty widget if not given
+func (app App) optionalWidget(ctx context.Context, tabID int64, widgetID int64) (api.Widget, error) {
+
+	if tabID == 0 || widgetID == 0 {
+		return api.Widget{}, nil
+	}
+
+	widget, err := app.Widget(ctx, tabID, widgetID)
+	if err != nil {
+		return api.Widget{}, errors.Wrap(err, "retrieving widget failed")
+	}
+
+	return widget, nil
+}
+
+//FeedItemsPageSize is the number of feed items returned when no limit is given
+const FeedItemsPageSize = 100
+
+//FeedItems returns the items of a feed and the reading status for the given user.
+//The link policies of the given widget (if not zero) and of the user are applied on the item links.
+//The first page is the current content of the feed, the cursor of the next ones being the publication time
+//and the GUID of the oldest item received (see api.NextItemCursor): the older items are read from the datastore.
+func (app App) FeedItems(ctx context.Context, userID string, feedID int64, tabID int64, widgetID int64, page api.PageRequest) ([]api.ItemForUser, error) {
+	return app.feedItems(ctx, userID, feedID, tabID, widgetID, page, nil)
+}
+
+//feedItems returns the items of a feed as FeedItems, their dates being given in loc,
+//or in the time zone of the user if nil
+func (app App) feedItems(ctx context.Context, userID string, feedID int64, tabID int64, widgetID int64, page api.PageRequest, loc *time.Location) ([]api.ItemForUser, error) {
+	ctx, span := tracing.Start(ctx, "App.FeedItems")
+	defer span.End()
 
 	app.Infof(ctx, "Getting items for %s feed %d", userID, feedID)
 
@@ -872,44 +2179,93 @@ func (app App) FeedItems(ctx context.Context, userID string, feedID int64) ([]ap
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return nil, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
 		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "FeedItems")
 	}
 
-	//Get the feed from datastore and/or URL
-	feed, feeditems, err := app.feed(ctx, feedID, true)
+	//Check managed policy
+	_, managed, err := app.managedPolicy(ctx, userID)
 	if err != nil {
-		return nil, errors.Wrap(err, "retrieving feed items failed")
+		return nil, err
+	}
+	if managed {
+		feed, err := app.repository.GetFeed(ctx, feedID)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving feed from datastore failed")
+		}
+		err = app.checkManagedAccess(ctx, userID, api.ApprovalFeed, feed.URL)
+		if err != nil {
+			return nil, errors.Wrap(err, "feed not allowed")
+		}
 	}
 
-	//Get the read status
-	count := len(feeditems)
-	if count == 0 {
-		return nil, errors.New("No items in feed " + feed.URL)
+	before, err := page.ItemCursor()
+	if err != nil {
+		return nil, invalidArgument(err.Error())
 	}
-	if count > 100 { //Arbritary limitation to avoid memory bump
-		count = 100
+	limit := FeedItemsPageSize //Arbritary limitation to avoid memory bump
+	if page.Limit > 0 {
+		limit = page.Size()
 	}
-	guids := make([]string, count)
-	for itemIdx := 0; itemIdx < count; itemIdx++ {
-		guids[itemIdx] = feeditems[itemIdx].GUID
+
+	var items []api.ItemForUser
+	if before.IsZero() {
+		//Get the feed from URL if due, then the items with their read status from datastore
+		feed, err := app.refreshDueFeed(ctx, feedID)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving feed items failed")
+		}
+		items, err = app.repository.GetFeedItemsForUser(ctx, userID, feedID, api.ItemCursor{}, limit)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving feed items failed")
+		}
+		if len(items) == 0 {
+			return nil, errors.New("No items in feed " + feed.URL)
+		}
+	} else {
+		//The history is only kept in datastore
+		items, err = app.repository.GetFeedItemsForUser(ctx, userID, feedID, before, limit)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving older feed items failed")
+		}
+		if len(items) == 0 {
+			return []api.ItemForUser{}, nil
+		}
 	}
-	readStatus, err := app.repository.AreItemsRead(ctx, userID, feedID, guids)
+
+	widget, err := app.optionalWidget(ctx, tabID, widgetID)
 	if err != nil {
-		return nil, errors.Wrap(err, "retrieving reading status failed")
+		return nil, err
+	}
+	policies, err := app.linkPolicies(ctx, userID, widget)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving link policies failed")
 	}
 
-	var items []api.ItemForUser
-
-	for itemIdx := 0; itemIdx < count; itemIdx++ {
+	//The dates are given in the time zone of the user, as they may be stored without it
+	if loc == nil {
+		loc = app.userLocation(ctx, userID)
+	}
 
-		read := false
-		if itemIdx < len(readStatus) {
-			read = readStatus[itemIdx]
+	for i := range items {
+		item := &items[i].FeedItem
+		item.Published = item.Published.In(loc)
+		if len(item.Link) > 0 {
+			item.ArchiveLink = api.ApplyLinkPolicies(policies, api.ArchiveLink(item.Link))
+		}
+		item.Link = api.ApplyLinkPolicies(policies, item.Link)
+		if len(item.ImageURL) > 0 {
+			item.Thumbnail = fmt.Sprintf("/pages/feeds/%d/thumbnail?guid=%s", feedID, url.QueryEscape(item.GUID))
 		}
+	}
 
-		items = append(items, api.ItemForUser{
-			FeedItem: feeditems[itemIdx],
-			Read:     read,
-		})
+	err = app.rankItems(ctx, userID, feedID, widget, items)
+	if err != nil {
+		return nil, errors.Wrap(err, "ranking items failed")
+	}
+
+	err = app.addAbstracts(ctx, feedID, widget, items)
+	if err != nil {
+		return nil, errors.Wrap(err, "adding abstracts failed")
 	}
 
 	app.Infof(ctx, "Done with %d items", len(items))
@@ -918,6 +2274,8 @@ func (app App) FeedItems(ctx context.Context, userID string, feedID int64) ([]ap
 
 //MarkAsRead marks one or multiple feed items as read for the given user
 func (app App) MarkAsRead(ctx context.Context, userID string, feedID int64, guids []string) error {
+	ctx, span := tracing.Start(ctx, "App.MarkAsRead")
+	defer span.End()
 
 	//Check that a user is logged
 	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
@@ -930,19 +2288,38 @@ func (app App) MarkAsRead(ctx context.Context, userID string, feedID int64, guid
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
 		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "MarkAsRead")
 	}
 
 	//Store the new status in datastore
-	err = app.repository.SetItemsRead(ctx, userID, feedID, guids, true)
+	//The items already read are not counted again
+	count, err := app.repository.SetItemsRead(ctx, userID, feedID, guids, true)
 	if err != nil {
 		return errors.Wrap(err, "saving read status failed")
 	}
+	app.recordUsage(ctx, userID, api.Usage{ItemsRead: count})
+
+	//Learn from the reading behavior, the status is saved even if it fails
+	if err := app.trainRanking(ctx, userID, feedID, guids); err != nil {
+		app.Error(ctx, errors.Wrap(err, "training ranking model failed"))
+	}
 
 	return nil
 }
 
-//GetEmails returns the list of email in a given account
-func (app App) GetEmails(ctx context.Context, userID string, accountID int64) (*api.EmailPage, error) {
+//GetEmails returns the list of email in a given account.
+//When several categories are given, the results are merged and sorted by date.
+//The query is a free-text search using the provider syntax.
+//The link policies of the given widget (if not zero) and of the user are applied on the item links.
+func (app App) GetEmails(ctx context.Context, userID string, accountID int64, categories []string, query string, tabID int64, widgetID int64) (*api.EmailPage, error) {
+	return app.getEmails(ctx, userID, accountID, categories, query, tabID, widgetID, nil)
+}
+
+//getEmails returns the emails of an account as GetEmails, their dates being given in loc,
+//or in the time zone of the user if nil
+func (app App) getEmails(ctx context.Context, userID string, accountID int64, categories []string, query string, tabID int64, widgetID int64, loc *time.Location) (*api.EmailPage, error) {
+	ctx, span := tracing.Start(ctx, "App.GetEmails")
+	defer span.End()
 
 	app.Infof(ctx, "Getting items for %s feed %d", userID, accountID)
 
@@ -957,6 +2334,7 @@ func (app App) GetEmails(ctx context.Context, userID string, accountID int64) (*
 		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
 			return nil, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
 		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "GetEmails")
 	}
 
 	//Get the account from datastore
@@ -965,15 +2343,177 @@ func (app App) GetEmails(ctx context.Context, userID string, accountID int64) (*
 		return nil, errors.Wrap(err, "retrieving account failed")
 	}
 
+	//Check managed policy
+	err = app.checkManagedAccess(ctx, userID, api.ApprovalProvider, account.ProviderName)
+	if err != nil {
+		return nil, errors.Wrap(err, "service not allowed")
+	}
+
 	//Get the provider
 	emailProvider, err := app.getEmailProvider(account.ProviderName)
 	if err != nil {
 		return nil, errors.Wrap(err, "Email provider not found")
 	}
 
+	widget, err := app.optionalWidget(ctx, tabID, widgetID)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := app.linkPolicies(ctx, userID, widget)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving link policies failed")
+	}
+
+	if account.NeedsReauth {
+		return nil, reauthRequired(account.ID)
+	}
+
+	page, err := app.emails(ctx, emailProvider, account, categories, query)
+	if err != nil {
+		return nil, app.checkReauth(ctx, account, err)
+	}
+
+	//The dates are given in the time zone of the user
+	if loc == nil {
+		loc = app.userLocation(ctx, userID)
+	}
+	for i := range page.Items {
+		page.Items[i].Link = api.ApplyLinkPolicies(policies, page.Items[i].Link)
+		page.Items[i].Published = page.Items[i].Published.In(loc)
+	}
+
+	return page, nil
+}
+
+//emails returns the emails of the given categories, from the cache when possible
+func (app App) emails(ctx context.Context, emailProvider api.EmailProvider, account api.ExternalAccount, categories []string, query string) (*api.EmailPage, error) {
+
+	if len(categories) == 1 {
+		return emailProvider.GetItems(ctx, account, api.EmailQuery{Category: categories[0], Query: query}, nil)
+	}
+	if len(categories) > 1 {
+		return app.mergeEmails(ctx, emailProvider, account, categories, query)
+	}
+	if len(query) > 0 {
+		return emailProvider.GetItems(ctx, account, api.EmailQuery{Query: query}, nil)
+	}
+
+	//Serve the inbox prefetched by the sync worker when it is fresh enough
+	page, fresh, err := app.cachedEmails(ctx, account)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving cached emails failed")
+	}
+	if fresh {
+		return page, nil
+	}
+
 	return emailProvider.GetItems(ctx, account, api.EmailQuery{}, nil)
 }
 
+//mergeEmails retrieves the first page of each category, and merges them sorted by date.
+//Items present in several categories are listed once.
+func (app App) mergeEmails(ctx context.Context, emailProvider api.EmailProvider, account api.ExternalAccount, categories []string, query string) (*api.EmailPage, error) {
+
+	res := api.EmailPage{
+		Counts: make(map[string]int64),
+	}
+	seen := make(map[string]bool)
+
+	for _, category := range categories {
+		page, err := emailProvider.GetItems(ctx, account, api.EmailQuery{Category: category, Query: query}, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving emails of category "+category+" failed")
+		}
+
+		res.Counts[category] = page.ResultSizeEstimate
+		res.ResultSizeEstimate += page.ResultSizeEstimate
+
+		for _, item := range page.Items {
+			if seen[item.GUID] {
+				continue
+			}
+			seen[item.GUID] = true
+			res.Items = append(res.Items, item)
+		}
+	}
+
+	sort.SliceStable(res.Items, func(i, j int) bool {
+		return res.Items[i].Published.After(res.Items[j].Published)
+	})
+
+	return &res, nil
+}
+
+//EmailAction applies the given action on an email of a given account
+func (app App) EmailAction(ctx context.Context, userID string, accountID int64, guid string, action api.EmailAction) error {
+	ctx, span := tracing.Start(ctx, "App.EmailAction")
+	defer span.End()
+
+	app.Infof(ctx, "Applying %s on email %s for %s account %d", action, guid, userID, accountID)
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+			return errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "EmailAction")
+	}
+
+	//Get the account from datastore
+	account, err := app.repository.GetAccount(ctx, userID, accountID)
+	if err != nil {
+		return errors.Wrap(err, "retrieving account failed")
+	}
+
+	//Check managed policy
+	err = app.checkManagedAccess(ctx, userID, api.ApprovalProvider, account.ProviderName)
+	if err != nil {
+		return errors.Wrap(err, "service not allowed")
+	}
+
+	//Get the provider
+	emailProvider, err := app.getEmailProvider(account.ProviderName)
+	if err != nil {
+		return errors.Wrap(err, "Email provider not found")
+	}
+
+	//Check the account is authorized to modify emails
+	err = app.requireFeature(ctx, userID, account, api.FeatureModify)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case api.EmailActionMarkRead:
+		err = emailProvider.MarkRead(ctx, account, guid, true)
+	case api.EmailActionMarkUnread:
+		err = emailProvider.MarkRead(ctx, account, guid, false)
+	case api.EmailActionArchive:
+		err = emailProvider.Archive(ctx, account, guid)
+	case api.EmailActionTrash:
+		err = emailProvider.Trash(ctx, account, guid)
+	default:
+		return errors.New("Unknown email action: " + string(action))
+	}
+	if err != nil {
+		return errors.Wrap(app.checkReauth(ctx, account, err), "applying email action failed")
+	}
+
+	//The prefetched inbox no longer reflects the mailbox
+	err = app.repository.InvalidateEmailItems(ctx, account.ProviderName, account.AccountID, math.MaxInt64)
+	if err != nil {
+		return errors.Wrap(err, "invalidating email items failed")
+	}
+
+	return nil
+}
+
 func (app App) getEmailProvider(serviceName string) (api.EmailProvider, error) {
 
 	provider, ok := app.providers[serviceName]
@@ -990,19 +2530,92 @@ func (app App) getEmailProvider(serviceName string) (api.EmailProvider, error) {
 	return emailProvider, nil
 }
 
-func (app App) getServiceConfig(serviceName string) (*oauth2.Config, error) {
+func (app App) getPushProvider(serviceName string) (api.PushProvider, error) {
 
-	provider, ok := app.providers[serviceName]
+	emailProvider, err := app.getEmailProvider(serviceName)
+	if err != nil {
+		return nil, err
+	}
 
+	pushProvider, ok := emailProvider.(api.PushProvider)
 	if !ok {
-		return nil, errors.New("Unknown service: " + serviceName)
+		return nil, errors.New("Push notifications not available: " + serviceName)
+	}
+
+	return pushProvider, nil
+}
+
+//WatchAccount starts (or renews) the change notifications of the given account.
+//It returns the expiration of the notifications, zero if they are not configured.
+func (app App) WatchAccount(ctx context.Context, userID string, accountID int64) (time.Time, error) {
+	ctx, span := tracing.Start(ctx, "App.WatchAccount")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+			return time.Time{}, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "WatchAccount")
 	}
 
-	return provider.Config(), nil
+	//Get the account from datastore
+	account, err := app.repository.GetAccount(ctx, userID, accountID)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "retrieving account failed")
+	}
+
+	//Get the provider
+	pushProvider, err := app.getPushProvider(account.ProviderName)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "Push provider not found")
+	}
+
+	return pushProvider.Watch(ctx, account)
 }
 
-//ServiceRegister computes the AuthCodeURL for the given service
-func (app App) ServiceRegister(ctx context.Context, serviceName string) (string, error) {
+//HandlePush processes a change notification sent by a service.
+//The cached emails of the changed account are invalidated, so they are refreshed on next retrieval.
+func (app App) HandlePush(ctx context.Context, serviceName string, token string, body []byte) error {
+	ctx, span := tracing.Start(ctx, "App.HandlePush")
+	defer span.End()
+
+	//Get the provider
+	pushProvider, err := app.getPushProvider(serviceName)
+	if err != nil {
+		return errors.Wrap(err, "Push provider not found")
+	}
+
+	//The notification is authenticated by the provider
+	notification, err := pushProvider.ParsePush(ctx, token, body)
+	if err != nil {
+		return errors.Wrap(notAuthorized(err.Error()), "invalid push notification")
+	}
+
+	app.Infof(ctx, "Push notification on %s for %s (version %d)", serviceName, notification.AccountID, notification.Version)
+
+	err = app.repository.InvalidateEmailItems(ctx, serviceName, notification.AccountID, notification.Version)
+	if err != nil {
+		return errors.Wrap(err, "invalidating email items failed")
+	}
+
+	account := api.ExternalAccount{ProviderName: serviceName, AccountID: notification.AccountID}
+	app.events.publish("", account.Key(), api.Event{Type: api.EventEmails})
+
+	return nil
+}
+
+//ServiceRegister computes the AuthCodeURL for the given service.
+//When widgetTabID is not 0, an email widget of the new account is added to this tab by the callback.
+func (app App) ServiceRegister(ctx context.Context, serviceName string, widgetTabID int64) (string, error) {
+	ctx, span := tracing.Start(ctx, "App.ServiceRegister")
+	defer span.End()
 
 	//Check that a user is logged
 	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
@@ -1010,60 +2623,201 @@ func (app App) ServiceRegister(ctx context.Context, serviceName string) (string,
 		return "", errors.Wrap(err, "retrieving current user failed")
 	}
 
+	//Check managed policy
+	err = app.checkManagedAccess(ctx, loggedInUserID, api.ApprovalProvider, serviceName)
+	if err != nil {
+		return "", errors.Wrap(err, "service not allowed")
+	}
+
+	if widgetTabID > 0 {
+		err = app.repository.IsTabAccessAllowed(ctx, loggedInUserID, widgetTabID)
+		if err != nil {
+			return "", errors.Wrap(err, "access by "+loggedInUserID)
+		}
+	}
+
+	return app.authorizationURL(ctx, loggedInUserID, serviceName, 0, widgetTabID, nil)
+}
+
+//authorizationURL stores a new OAuth2 state and computes the AuthCodeURL requesting,
+//in addition to the default ones, the scopes needed by the given features.
+//When accountID is not 0, the callback updates this existing account.
+//When widgetTabID is not 0, the callback adds an email widget of the new account to this tab.
+func (app App) authorizationURL(ctx context.Context, userID string, serviceName string, accountID int64, widgetTabID int64, features []api.Feature) (string, error) {
+
+	provider, ok := app.providers[serviceName]
+	if !ok {
+		return "", errors.New("Unknown service: " + serviceName)
+	}
+
 	//Generate code
-	randState := fmt.Sprintf("oki%d", time.Now().UnixNano())
+	randState, err := newTemporaryCode()
+	if err != nil {
+		return "", errors.Wrap(err, "generating temporary code failed")
+	}
 
 	//Store it
-	err = app.repository.StoreTemporaryCode(ctx, loggedInUserID, serviceName, randState)
+	err = app.repository.StoreTemporaryCode(ctx, api.TemporaryCode{
+		Code:         randState,
+		UserID:       userID,
+		ProviderName: serviceName,
+		AccountID:    accountID,
+		WidgetTabID:  widgetTabID,
+		Created:      time.Now(),
+	})
 	if err != nil {
 		return "", errors.Wrap(err, "saving temporary code failed")
 	}
 
 	//Get the URL
-	config, err := app.getServiceConfig(serviceName)
-	if err != nil {
-		return "", errors.Wrap(err, "Unable to retrieve service configuration")
+	config := *provider.Config()
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOffline}
+	if len(features) > 0 {
+		config.Scopes = append([]string{}, config.Scopes...)
+		for _, f := range features {
+			config.Scopes = mergeScopes(config.Scopes, provider.Scopes(f))
+		}
+		opts = append(opts, oauth2.SetAuthURLParam("include_granted_scopes", "true"))
 	}
-	authURL := config.AuthCodeURL(randState, oauth2.AccessTypeOffline)
-	fmt.Println("AuthCodeURL", authURL)
+	authURL := config.AuthCodeURL(randState, opts...)
+	app.Debugf(ctx, "Authorization URL of %s for %s: %s", serviceName, userID, authURL)
 
 	return authURL, nil
 }
 
+//mergeScopes returns the union of the given scope lists
+func mergeScopes(scopes []string, others []string) []string {
+	for _, o := range others {
+		if !(api.ExternalAccount{Scopes: scopes}).HasScopes([]string{o}) {
+			scopes = append(scopes, o)
+		}
+	}
+	return scopes
+}
+
+type authorizationRequired struct {
+	feature   api.Feature
+	userID    string
+	accountID int64
+}
+
+//AuthorizationURL is the page starting the OAuth2 flow granting the missing scopes.
+//The flow, and its temporary code, are only created once the user opens it.
+func (err authorizationRequired) AuthorizationURL() string {
+	return fmt.Sprintf("/pages/users/%s/accounts/%d/authorize?feature=%s", url.PathEscape(err.userID), err.accountID, url.QueryEscape(string(err.feature)))
+}
+func (err authorizationRequired) Error() string {
+	return fmt.Sprintf("additional authorization required for feature %s", err.feature)
+}
+
+//requireFeature returns an authorizationRequired error if the scopes needed by the feature were not granted for the account
+func (app App) requireFeature(ctx context.Context, userID string, account api.ExternalAccount, feature api.Feature) error {
+
+	provider, ok := app.providers[account.ProviderName]
+	if !ok {
+		return errors.New("Unknown service: " + account.ProviderName)
+	}
+
+	//Accounts associated before scopes were tracked are assumed to have the default scopes
+	if len(account.Scopes) == 0 {
+		account.Scopes = provider.Config().Scopes
+	}
+
+	if account.HasScopes(provider.Scopes(feature)) {
+		return nil
+	}
+
+	return authorizationRequired{feature: feature, userID: userID, accountID: account.ID}
+}
+
+//AuthorizeFeature computes the AuthCodeURL granting the scopes needed by the feature to an existing account.
+//An empty URL is returned if the scopes are already granted.
+func (app App) AuthorizeFeature(ctx context.Context, userID string, accountID int64, feature api.Feature) (string, error) {
+	ctx, span := tracing.Start(ctx, "App.AuthorizeFeature")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		return "", errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+	}
+
+	account, err := app.repository.GetAccount(ctx, userID, accountID)
+	if err != nil {
+		return "", errors.Wrap(err, "retrieving account failed")
+	}
+
+	err = app.requireFeature(ctx, userID, account, feature)
+	if _, ok := err.(authorizationRequired); ok {
+		return app.authorizationURL(ctx, userID, account.ProviderName, account.ID, 0, []api.Feature{feature})
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return "", nil
+}
+
+//CallbackResult is the outcome of an Oauth2 flow
+type CallbackResult struct {
+	AccountID int64
+	//TabID and WidgetID identify the email widget created for the account, 0 if none was asked
+	TabID    int64
+	WidgetID int64
+}
+
 //HandleOauth2Callback manages the Oauth2 flow and creates a new account for the user who started the flow.
-func (app App) HandleOauth2Callback(ctx context.Context, serviceName string, state, code string) error {
+//The account of the user with the same provider and address, if any, is updated instead.
+//An email widget of the new account is added to the tab chosen when the flow started, if any.
+func (app App) HandleOauth2Callback(ctx context.Context, serviceName string, state, code string) (CallbackResult, error) {
+	ctx, span := tracing.Start(ctx, "App.HandleOauth2Callback")
+	defer span.End()
 
 	//Check state
-	userID, err := app.repository.GetUserFromTemporaryCode(ctx, serviceName, state)
+	tc, err := app.repository.GetTemporaryCode(ctx, serviceName, state)
 	if err != nil {
-		return errors.Wrap(err, "retrieving user failed")
+		return CallbackResult{}, errors.Wrap(err, "retrieving user failed")
 	}
+	userID := tc.UserID
 
 	if len(userID) == 0 {
-		return errors.Wrap(notAuthorized("access denied"), "invalid oauth2 state")
+		return CallbackResult{}, errors.Wrap(notAuthorized("access denied"), "invalid oauth2 state")
+	}
+	if time.Since(tc.Created) > TemporaryCodeMaxAge {
+		return CallbackResult{}, errors.Wrap(notAuthorized("access denied"), "expired oauth2 state")
 	}
 
 	if code == "" {
-		return errors.New("Empty code received")
+		return CallbackResult{}, errors.New("Empty code received")
 	}
 
 	//Get the provider
 	emailProvider, err := app.getEmailProvider(serviceName)
 	if err != nil {
-		return errors.Wrap(err, "Email provider not found")
+		return CallbackResult{}, errors.Wrap(err, "Email provider not found")
 	}
 
-	token, err := emailProvider.Config().Exchange(ctx, code)
+	var token *oauth2.Token
+	if exchanger, ok := emailProvider.(api.CodeExchanger); ok {
+		token, err = exchanger.Exchange(ctx, code)
+	} else {
+		token, err = emailProvider.Config().Exchange(ctx, code)
+	}
 	if err != nil {
-		return errors.Wrap(err, "Exchange failed")
+		return CallbackResult{}, errors.Wrap(err, "Exchange failed")
 	}
 
 	err = app.repository.DeleteTemporaryCode(ctx, userID, serviceName)
 	if err != nil {
-		return errors.Wrap(err, "erasing temporary code failed")
+		return CallbackResult{}, errors.Wrap(err, "erasing temporary code failed")
 	}
 
-	app.logInteractor.Infof(ctx, "New account on %s for %s: %v", serviceName, userID, *token)
+	app.logInteractor.Infof(ctx, "New account on %s for %s", serviceName, userID)
 
 	account := api.ExternalAccount{
 		ProviderName: serviceName,
@@ -1072,15 +2826,89 @@ func (app App) HandleOauth2Callback(ctx context.Context, serviceName string, sta
 
 	email, err := emailProvider.GetCurrentEmailAddress(ctx, account)
 	if err != nil {
-		return errors.Wrap(err, "retrieving email failed")
+		return CallbackResult{}, errors.Wrap(err, "retrieving email failed")
+	}
+
+	//Update the existing account being authorized again, if it is still the same one
+	if tc.AccountID > 0 {
+		existing, err := app.repository.GetAccount(ctx, userID, tc.AccountID)
+		if err != nil {
+			return CallbackResult{}, errors.Wrap(err, "retrieving existing account failed")
+		}
+		if existing.ProviderName == serviceName && existing.AccountID == email {
+			account = existing
+		}
+	}
+
+	//Update the account already linked to the same address instead of storing a duplicate
+	if account.ID == 0 {
+		existing, found, err := app.findAccount(ctx, userID, api.ExternalAccount{ProviderName: serviceName, AccountID: email}.Key())
+		if err != nil {
+			return CallbackResult{}, errors.Wrap(err, "retrieving existing account failed")
+		}
+		if found {
+			app.Infof(ctx, "Account %s of %s authorized again, updating account %d", existing.Key(), userID, existing.ID)
+			account = existing
+		}
+	}
+	if account.ID > 0 {
+		//The providers may only give a refresh token on the first authorization
+		if len(token.RefreshToken) == 0 && account.Token != nil {
+			token.RefreshToken = account.Token.RefreshToken
+		}
+		account.Token = token
+		account.NeedsReauth = false
 	}
 
 	account.AccountID = email
+	account.Scopes = grantedScopes(token, account.Scopes, emailProvider.Config().Scopes)
 
+	linked := account.ID == 0
 	err = app.repository.StoreAccount(ctx, userID, &account)
 	if err != nil {
-		return errors.Wrap(err, "saving token failed")
+		return CallbackResult{}, errors.Wrap(err, "saving token failed")
 	}
 
-	return nil
+	//Subscribe to change notifications, the emails are still polled if it fails
+	if pushProvider, ok := emailProvider.(api.PushProvider); ok {
+		if _, err := pushProvider.Watch(ctx, account); err != nil {
+			app.Error(ctx, errors.Wrap(err, "watching account "+account.Key()+" failed"))
+		}
+	}
+
+	if linked {
+		app.audit(ctx, userID, api.AuditAccountLinked, fmt.Sprintf("account:%d", account.ID))
+	} else {
+		app.audit(ctx, userID, api.AuditAccountUpdated, fmt.Sprintf("account:%d", account.ID))
+	}
+
+	result := CallbackResult{AccountID: account.ID}
+
+	//The account is kept even if the widget cannot be created, it can still be added by hand
+	if tc.WidgetTabID > 0 && tc.AccountID == 0 {
+		widget, err := app.NewWidget(ctx, tc.WidgetTabID, api.Widget{
+			Type:   api.WidgetEmailType,
+			Config: api.ConfigEmail{AccountID: account.ID},
+		}, nil)
+		if err != nil {
+			app.Error(ctx, errors.Wrap(err, "creating email widget for account "+account.Key()+" failed"))
+		} else {
+			result.TabID = tc.WidgetTabID
+			result.WidgetID = widget.ID
+		}
+	}
+
+	return result, nil
+}
+
+//grantedScopes returns the scopes granted with the token, merged with the previously granted ones.
+//The requested scopes are used if the provider does not tell which scopes were granted.
+func grantedScopes(token *oauth2.Token, previous []string, requested []string) []string {
+
+	scopes := requested
+	if s, ok := token.Extra("scope").(string); ok && len(s) > 0 {
+		scopes = strings.Fields(s)
+	}
+
+	return mergeScopes(append([]string{}, scopes...), previous)
 }
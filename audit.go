@@ -0,0 +1,116 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+const (
+	//AuditBatchSize is the maximum number of events forwarded at once
+	AuditBatchSize = 100
+	//auditQueueSize is the number of events waiting to be forwarded above which new events are dropped
+	auditQueueSize = 1000
+)
+
+//SetAuditSink sets where the audit events are forwarded.
+//RunAuditForwarding must be running for the events to be sent.
+func (app *App) SetAuditSink(sink api.AuditSink) {
+	app.auditSink = sink
+	app.auditQueue = make(chan api.AuditEvent, auditQueueSize)
+}
+
+//audit records an event done by the current user on the data of the given user in the audit log,
+//and forwards it to the audit sink if any
+func (app App) audit(ctx context.Context, userID string, action api.AuditAction, target string) {
+
+	//The actor is unknown for unauthenticated events
+	actorID, _ := app.userInteractor.CurrentUserID(ctx)
+
+	event := api.AuditEvent{
+		Time:    time.Now().UTC(),
+		ActorID: actorID,
+		UserID:  userID,
+		Action:  action,
+		Target:  target,
+	}
+
+	if err := app.repository.StoreAuditEvent(ctx, &event); err != nil {
+		app.Error(ctx, errors.Wrapf(err, "storing audit event %s %s by %s failed", action, target, actorID))
+	}
+
+	if app.auditQueue == nil {
+		return
+	}
+
+	select {
+	case app.auditQueue <- event:
+	default:
+		app.Errorf(ctx, "Audit queue full, event dropped: %s %s by %s", action, target, actorID)
+	}
+}
+
+//RunAuditForwarding sends the audit events to the audit sink by batches,
+//at the given interval or as soon as a batch is full, until the context is done.
+func (app App) RunAuditForwarding(ctx context.Context, interval time.Duration) {
+
+	if app.auditQueue == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]api.AuditEvent, 0, AuditBatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := app.auditSink.Send(ctx, batch); err != nil {
+			app.Error(ctx, errors.Wrapf(err, "forwarding %d audit event(s) failed", len(batch)))
+		}
+		batch = make([]api.AuditEvent, 0, AuditBatchSize)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			//The remaining events are sent even though the context is done
+			flush(context.Background())
+			return
+		case event := <-app.auditQueue:
+			batch = append(batch, event)
+			if len(batch) >= AuditBatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+//AuditEvents returns a page of the audit log matching the filter, most recent first. Admin only.
+func (app App) AuditEvents(ctx context.Context, filter api.AuditFilter, page api.PageRequest) (api.AuditEventPage, error) {
+	ctx, span := tracing.Start(ctx, "App.AuditEvents")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return api.AuditEventPage{}, err
+	}
+
+	events, next, err := app.repository.GetAuditEventsPage(ctx, filter, page)
+	if err != nil {
+		return api.AuditEventPage{}, errors.Wrap(err, "retrieving audit events from datastore failed")
+	}
+
+	return api.AuditEventPage{Events: events, Next: next}, nil
+}
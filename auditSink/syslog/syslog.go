@@ -0,0 +1,82 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package syslog
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//Config is the configuration of a syslog server receiving the audit events.
+//
+//Each batch is sent as a single message holding a JSON object {"events": [...], "signature": "<hex>"},
+//where signature is the HMAC-SHA256 of the events array as written in the message.
+type Config struct {
+	//Network is "udp" or "tcp", empty for the local syslog
+	Network string
+	Address string
+	//Tag is the syslog tag of the messages, "okihome" if empty
+	Tag string
+	//Secret is the key of the batch signatures
+	Secret string
+}
+
+type sink struct {
+	writer *syslog.Writer
+	secret string
+}
+
+type batch struct {
+	Events    json.RawMessage `json:"events"`
+	Signature string          `json:"signature"`
+}
+
+//New creates an AuditSink writing the events to a syslog server
+func New(cfg Config) (api.AuditSink, error) {
+
+	if len(cfg.Secret) == 0 {
+		return nil, errors.New("Audit signature secret is missing")
+	}
+
+	tag := cfg.Tag
+	if len(tag) == 0 {
+		tag = "okihome"
+	}
+
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, errors.Wrap(err, "connecting to syslog failed")
+	}
+
+	return sink{
+		writer: w,
+		secret: cfg.Secret,
+	}, nil
+}
+
+func (s sink) Send(ctx context.Context, events []api.AuditEvent) error {
+
+	payload, signature, err := api.SignAuditBatch(events, s.secret)
+	if err != nil {
+		return errors.Wrap(err, "signing audit batch failed")
+	}
+
+	msg, err := json.Marshal(batch{Events: payload, Signature: signature})
+	if err != nil {
+		return errors.Wrap(err, "encoding audit batch failed")
+	}
+
+	err = s.writer.Info(string(msg))
+	if err != nil {
+		return errors.Wrap(err, "writing to syslog failed")
+	}
+
+	return nil
+}
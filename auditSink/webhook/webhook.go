@@ -0,0 +1,82 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//SignatureHeader is the HTTP header holding the signature of a batch
+const SignatureHeader = "X-Okihome-Signature"
+
+//sendTimeout bounds the sending of a batch
+const sendTimeout = 10 * time.Second
+
+//Config is the configuration of an HTTP endpoint receiving the audit events.
+//
+//Each batch is sent as a POST request with a JSON array of events as body,
+//signed with HMAC-SHA256 in the header X-Okihome-Signature as "sha256=<hex>".
+type Config struct {
+	URL string
+	//Secret is the key of the batch signatures
+	Secret string
+}
+
+type sink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+//New creates an AuditSink posting the events to an HTTP endpoint
+func New(cfg Config) (api.AuditSink, error) {
+
+	if len(cfg.URL) == 0 {
+		return nil, errors.New("Audit endpoint URL is missing")
+	}
+	if len(cfg.Secret) == 0 {
+		return nil, errors.New("Audit signature secret is missing")
+	}
+
+	return sink{
+		url:    cfg.URL,
+		secret: cfg.Secret,
+		client: &http.Client{Timeout: sendTimeout},
+	}, nil
+}
+
+func (s sink) Send(ctx context.Context, events []api.AuditEvent) error {
+
+	payload, signature, err := api.SignAuditBatch(events, s.secret)
+	if err != nil {
+		return errors.Wrap(err, "signing audit batch failed")
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "creating request failed")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+signature)
+
+	r, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "call to audit endpoint failed")
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return errors.Errorf("audit endpoint returned status %d", r.StatusCode)
+	}
+
+	return nil
+}
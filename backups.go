@@ -0,0 +1,171 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//DefaultBackupsKept is the number of scheduled backups kept by user if none is configured
+const DefaultBackupsKept = 7
+
+//backupTimeLayout names the backups so that their keys are sorted by date
+const backupTimeLayout = "20060102T150405Z"
+
+//SetBackupStore sets the storage of the scheduled backups, keeping the given number of backups by user.
+//The snapshots include the email addresses and the read items of the users, the storage must be kept private.
+func (app *App) SetBackupStore(store api.BlobStore, kept int) {
+	if kept <= 0 {
+		kept = DefaultBackupsKept
+	}
+	app.backupStore = store
+	app.backupsKept = kept
+}
+
+//SetBackupPassphrase encrypts the scheduled backups with the given passphrase, for untrusted storages
+func (app *App) SetBackupPassphrase(passphrase string) {
+	app.backupPassphrase = passphrase
+}
+
+//backupPrefix returns the prefix of the keys of the backups of the user
+func backupPrefix(userID string) string {
+	return "backups/" + url.PathEscape(userID) + "/"
+}
+
+//deleteBackups removes all the backups of the user
+func (app App) deleteBackups(ctx context.Context, userID string) error {
+
+	if app.backupStore == nil {
+		return nil
+	}
+
+	keys, err := app.backupStore.List(ctx, backupPrefix(userID))
+	if err != nil {
+		return errors.Wrap(err, "listing backups failed")
+	}
+	for _, key := range keys {
+		if err := app.backupStore.Delete(ctx, key); err != nil {
+			return errors.Wrap(err, "removing backup failed")
+		}
+	}
+
+	return nil
+}
+
+//RunScheduledBackups stores the snapshots of the users at the given interval, until the context is done
+func (app App) RunScheduledBackups(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := app.BackupAllUsers(ctx); err != nil {
+			app.Error(ctx, errors.Wrap(err, "scheduled backups failed"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//BackupAllUsers stores the snapshot of each user in the backup store, with the read and starred items,
+//and removes the oldest backups past the number kept.
+//A failing user does not prevent the backup of the others.
+func (app App) BackupAllUsers(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "App.BackupAllUsers")
+	defer span.End()
+
+	if app.backupStore == nil {
+		return errors.New("no backup store configured")
+	}
+
+	now := time.Now()
+	var backups, failures int
+
+	page := api.PageRequest{}
+	for {
+		users, next, err := app.repository.GetUsersPage(ctx, page)
+		if err != nil {
+			return errors.Wrap(err, "retrieving users from datastore failed")
+		}
+
+		for _, u := range users {
+			if err := app.backupUser(ctx, u.UserID, now); err != nil {
+				app.Error(ctx, errors.Wrap(err, "backup of user "+u.UserID+" failed"))
+				failures++
+				continue
+			}
+			backups++
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		page.Cursor = next
+	}
+
+	app.Infof(ctx, "Scheduled backups stored for %d user(s)", backups)
+	if failures > 0 {
+		return errors.Errorf("%d user(s) not backed up", failures)
+	}
+
+	return nil
+}
+
+//backupUser stores the snapshot of the user, then removes the oldest backups
+func (app App) backupUser(ctx context.Context, userID string, now time.Time) error {
+
+	data, err := app.snapshot(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = app.snapshotItems(ctx, userID, &data)
+	if err != nil {
+		return err
+	}
+
+	var b []byte
+	if len(app.backupPassphrase) > 0 {
+		encrypted, err := EncryptSnapshot(data, app.backupPassphrase)
+		if err != nil {
+			return err
+		}
+		b, err = json.Marshal(encrypted)
+	} else {
+		b, err = json.Marshal(data)
+	}
+	if err != nil {
+		return errors.Wrap(err, "encoding snapshot failed")
+	}
+
+	key := backupPrefix(userID) + now.UTC().Format(backupTimeLayout) + ".json"
+	err = app.backupStore.Put(ctx, key, api.Blob{ContentType: "application/json", Data: b})
+	if err != nil {
+		return errors.Wrap(err, "saving backup in store failed")
+	}
+
+	keys, err := app.backupStore.List(ctx, backupPrefix(userID))
+	if err != nil {
+		return errors.Wrap(err, "listing backups failed")
+	}
+	for i := 0; i < len(keys)-app.backupsKept; i++ {
+		if err := app.backupStore.Delete(ctx, keys[i]); err != nil {
+			return errors.Wrap(err, "removing backup failed")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+const storageScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+//Config is the configuration of a blob store in a Google Cloud Storage bucket.
+//Credentials are the application default credentials.
+type Config struct {
+	Bucket string
+	//Prefix is prepended to all the object names
+	Prefix string
+}
+
+type store struct {
+	client *http.Client
+	bucket string
+	prefix string
+}
+
+type notFound string
+
+func (err notFound) Error() string {
+	return "Blob not found: " + string(err)
+}
+
+//New creates a new BlobStore that stores the blobs as objects in a Google Cloud Storage bucket
+func New(cfg Config) (api.BlobStore, error) {
+
+	if len(cfg.Bucket) == 0 {
+		return nil, errors.New("Bucket name is missing")
+	}
+
+	client, err := google.DefaultClient(context.Background(), storageScope)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create storage client")
+	}
+
+	return &store{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: cfg.Prefix,
+	}, nil
+}
+
+func (s *store) url(key string) string {
+
+	segments := strings.Split(s.prefix+key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+
+	return "https://storage.googleapis.com/" + url.PathEscape(s.bucket) + "/" + strings.Join(segments, "/")
+}
+
+func (s *store) do(ctx context.Context, method string, key string, blob *api.Blob) (*http.Response, error) {
+
+	var body []byte
+	if blob != nil {
+		body = blob.Data
+	}
+
+	req, err := http.NewRequest(method, s.url(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+	if blob != nil && len(blob.ContentType) > 0 {
+		req.Header.Set("Content-Type", blob.ContentType)
+	}
+
+	r, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "Call to storage failed")
+	}
+
+	if r.StatusCode == http.StatusNotFound {
+		r.Body.Close()
+		return nil, notFound(key)
+	}
+	if r.StatusCode >= 400 {
+		r.Body.Close()
+		return nil, errors.Errorf("Storage returned %s", r.Status)
+	}
+
+	return r, nil
+}
+
+func (s *store) Get(ctx context.Context, key string) (api.Blob, error) {
+
+	r, err := s.do(ctx, "GET", key, nil)
+	if err != nil {
+		return api.Blob{}, err
+	}
+	defer r.Body.Close()
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "Reading blob failed")
+	}
+
+	return api.Blob{
+		ContentType: r.Header.Get("Content-Type"),
+		Data:        data,
+	}, nil
+}
+
+func (s *store) Put(ctx context.Context, key string, blob api.Blob) error {
+
+	r, err := s.do(ctx, "PUT", key, &blob)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	return nil
+}
+
+func (s *store) Delete(ctx context.Context, key string) error {
+
+	r, err := s.do(ctx, "DELETE", key, nil)
+	if err != nil {
+		if s.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	r.Body.Close()
+
+	return nil
+}
+
+//objectList is a page of the response of the objects listing call
+type objectList struct {
+	Items []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (s *store) List(ctx context.Context, prefix string) ([]string, error) {
+
+	query := url.Values{
+		"prefix": {s.prefix + prefix},
+		"fields": {"items(name),nextPageToken"},
+	}
+
+	keys := []string{}
+	for {
+		u := "https://storage.googleapis.com/storage/v1/b/" + url.PathEscape(s.bucket) + "/o?" + query.Encode()
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to create request")
+		}
+
+		r, err := s.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, errors.Wrap(err, "Call to storage failed")
+		}
+		if r.StatusCode >= 400 {
+			r.Body.Close()
+			return nil, errors.Errorf("Storage returned %s", r.Status)
+		}
+
+		var list objectList
+		err = json.NewDecoder(r.Body).Decode(&list)
+		r.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to decode object list")
+		}
+
+		for _, o := range list.Items {
+			keys = append(keys, strings.TrimPrefix(o.Name, s.prefix))
+		}
+
+		if len(list.NextPageToken) == 0 {
+			break
+		}
+		query.Set("pageToken", list.NextPageToken)
+	}
+
+	return keys, nil
+}
+
+func (s *store) IsNotFound(err error) bool {
+	_, ok := errors.Cause(err).(notFound)
+	return ok
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//Config is the configuration of a blob store on the local filesystem
+type Config struct {
+	Directory string
+}
+
+type store struct {
+	dir string
+}
+
+//New creates a new BlobStore that stores the blobs as files in the given directory
+func New(cfg Config) (api.BlobStore, error) {
+
+	if len(cfg.Directory) == 0 {
+		return nil, errors.New("Blob directory is missing")
+	}
+
+	if err := os.MkdirAll(cfg.Directory, 0700); err != nil {
+		return nil, errors.Wrap(err, "Unable to create blob directory")
+	}
+
+	return &store{dir: cfg.Directory}, nil
+}
+
+func (s *store) filename(key string) (string, error) {
+
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "..") {
+		return "", errors.New("Invalid blob key: " + key)
+	}
+
+	return filepath.Join(s.dir, filepath.FromSlash(cleaned)), nil
+}
+
+func (s *store) Get(ctx context.Context, key string) (api.Blob, error) {
+
+	filename, err := s.filename(key)
+	if err != nil {
+		return api.Blob{}, err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "Reading blob failed")
+	}
+
+	//The content type is not stored, it is detected from the data
+	return api.Blob{
+		ContentType: http.DetectContentType(data),
+		Data:        data,
+	}, nil
+}
+
+func (s *store) Put(ctx context.Context, key string, blob api.Blob) error {
+
+	filename, err := s.filename(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return errors.Wrap(err, "Creating blob directory failed")
+	}
+
+	//Write then rename, so readers never see a partial blob
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob.Data, 0600); err != nil {
+		return errors.Wrap(err, "Writing blob failed")
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return errors.Wrap(err, "Renaming blob failed")
+	}
+
+	return nil
+}
+
+func (s *store) Delete(ctx context.Context, key string) error {
+
+	filename, err := s.filename(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Removing blob failed")
+	}
+
+	return nil
+}
+
+func (s *store) List(ctx context.Context, prefix string) ([]string, error) {
+
+	keys := []string{}
+	err := filepath.Walk(s.dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(filename, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.dir, filename)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Listing blobs failed")
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *store) IsNotFound(err error) bool {
+	return os.IsNotExist(errors.Cause(err))
+}
@@ -0,0 +1,299 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//Config is the configuration of a blob store in an Amazon S3 (or compatible) bucket
+type Config struct {
+	Bucket          string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	//Endpoint is the URL of the service, the AWS endpoint of the region is used if empty
+	Endpoint string
+	//Prefix is prepended to all the object names
+	Prefix string
+}
+
+type store struct {
+	cfg    Config
+	client *http.Client
+}
+
+type notFound string
+
+func (err notFound) Error() string {
+	return "Blob not found: " + string(err)
+}
+
+//New creates a new BlobStore that stores the blobs as objects in an S3 bucket
+func New(cfg Config) (api.BlobStore, error) {
+
+	if len(cfg.Bucket) == 0 {
+		return nil, errors.New("Bucket name is missing")
+	}
+	if len(cfg.Region) == 0 {
+		return nil, errors.New("Region is missing")
+	}
+	if len(cfg.AccessKeyID) == 0 || len(cfg.SecretAccessKey) == 0 {
+		return nil, errors.New("Credentials are missing")
+	}
+	if len(cfg.Endpoint) == 0 {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+
+	return &store{
+		cfg:    cfg,
+		client: http.DefaultClient,
+	}, nil
+}
+
+//path returns the escaped path of the object (path-style addressing)
+func (s *store) path(key string) string {
+
+	segments := strings.Split(s.cfg.Prefix+key, "/")
+	for i := range segments {
+		segments[i] = uriEncode(segments[i])
+	}
+
+	return "/" + uriEncode(s.cfg.Bucket) + "/" + strings.Join(segments, "/")
+}
+
+//uriEncode escapes everything but the unreserved characters, as required by the signature
+func uriEncode(segment string) string {
+
+	var b strings.Builder
+	for _, c := range []byte(segment) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+//canonicalQuery encodes the query parameters sorted by name, as required by the signature
+func canonicalQuery(query url.Values) string {
+
+	var params []string
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, uriEncode(name)+"="+uriEncode(v))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+//sign adds the AWS Signature Version 4 headers to the request
+func (s *store) sign(req *http.Request, path string, query url.Values, payload []byte, now time.Time) {
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	day := now.UTC().Format("20060102")
+	payloadHash := sha256Hex(payload)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(query),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func (s *store) do(ctx context.Context, method string, key string, blob *api.Blob) (*http.Response, error) {
+
+	var body []byte
+	if blob != nil {
+		body = blob.Data
+	}
+
+	path := s.path(key)
+	req, err := http.NewRequest(method, s.cfg.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create request")
+	}
+	if blob != nil && len(blob.ContentType) > 0 {
+		req.Header.Set("Content-Type", blob.ContentType)
+	}
+	s.sign(req, path, nil, body, time.Now())
+
+	r, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "Call to storage failed")
+	}
+
+	if r.StatusCode == http.StatusNotFound {
+		r.Body.Close()
+		return nil, notFound(key)
+	}
+	if r.StatusCode >= 400 {
+		r.Body.Close()
+		return nil, errors.Errorf("Storage returned %s", r.Status)
+	}
+
+	return r, nil
+}
+
+func (s *store) Get(ctx context.Context, key string) (api.Blob, error) {
+
+	r, err := s.do(ctx, "GET", key, nil)
+	if err != nil {
+		return api.Blob{}, err
+	}
+	defer r.Body.Close()
+
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "Reading blob failed")
+	}
+
+	return api.Blob{
+		ContentType: r.Header.Get("Content-Type"),
+		Data:        data,
+	}, nil
+}
+
+func (s *store) Put(ctx context.Context, key string, blob api.Blob) error {
+
+	r, err := s.do(ctx, "PUT", key, &blob)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	return nil
+}
+
+func (s *store) Delete(ctx context.Context, key string) error {
+
+	//Deleting a missing object is not an error on S3
+	r, err := s.do(ctx, "DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	return nil
+}
+
+//listBucketResult is the response of the ListObjectsV2 call
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *store) List(ctx context.Context, prefix string) ([]string, error) {
+
+	path := "/" + uriEncode(s.cfg.Bucket)
+	query := url.Values{
+		"list-type": {"2"},
+		"prefix":    {s.cfg.Prefix + prefix},
+	}
+
+	keys := []string{}
+	for {
+		req, err := http.NewRequest("GET", s.cfg.Endpoint+path+"?"+canonicalQuery(query), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to create request")
+		}
+		s.sign(req, path, query, nil, time.Now())
+
+		r, err := s.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, errors.Wrap(err, "Call to storage failed")
+		}
+		if r.StatusCode >= 400 {
+			r.Body.Close()
+			return nil, errors.Errorf("Storage returned %s", r.Status)
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(r.Body).Decode(&result)
+		r.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to decode object list")
+		}
+
+		for _, c := range result.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, s.cfg.Prefix))
+		}
+
+		if !result.IsTruncated || len(result.NextContinuationToken) == 0 {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+
+	return keys, nil
+}
+
+func (s *store) IsNotFound(err error) bool {
+	_, ok := errors.Cause(err).(notFound)
+	return ok
+}
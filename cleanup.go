@@ -0,0 +1,96 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//DefaultEmailCacheAge is the age of the cached email items removed by the cleanup, if not given
+const DefaultEmailCacheAge = 90 * 24 * time.Hour
+
+//orphanFeedGrace is how long a feed must not have been retrieved before being removed by the cleanup,
+//so the feeds being added to a widget are kept. The feeds are created with the date of the day as next retrieval.
+const orphanFeedGrace = 48 * time.Hour
+
+//CleanupOptions describes what the cleanup removes
+type CleanupOptions struct {
+	//EmailCacheAge is the age of the cached email items removed, DefaultEmailCacheAge if zero.
+	//The email items are only a cache of the provider, and are retrieved again if needed.
+	EmailCacheAge time.Duration
+	//Optimize reclaims the space of the removed data and refreshes the statistics of the repository
+	Optimize bool
+}
+
+//CleanupReport is the amount of data removed by the cleanup
+type CleanupReport struct {
+	TemporaryCodes int64
+	EmailItems     int64
+	Feeds          int64
+}
+
+//Cleanup removes the expired temporary codes, the old cached email items and the feeds no widget displays,
+//then optimizes the repository if requested. The feeds with starred items are kept.
+func (app App) Cleanup(ctx context.Context, opts CleanupOptions) (CleanupReport, error) {
+	ctx, span := tracing.Start(ctx, "App.Cleanup")
+	defer span.End()
+
+	var report CleanupReport
+	if opts.EmailCacheAge <= 0 {
+		opts.EmailCacheAge = DefaultEmailCacheAge
+	}
+	now := time.Now()
+
+	var err error
+	report.TemporaryCodes, err = app.CleanupTemporaryCodes(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	page := api.PageRequest{}
+	for {
+		users, next, err := app.repository.GetUsersPage(ctx, page)
+		if err != nil {
+			return report, errors.Wrap(err, "retrieving users from datastore failed")
+		}
+
+		for _, u := range users {
+			count, err := app.repository.DeleteEmailItemsBefore(ctx, u.UserID, now.Add(-opts.EmailCacheAge))
+			if err != nil {
+				return report, errors.Wrap(err, "removing cached email items of user "+u.UserID+" failed")
+			}
+			report.EmailItems += count
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		page.Cursor = next
+	}
+
+	//The repository checks the feeds are not displayed while removing them,
+	//as a widget may be added meanwhile to an orphan feed
+	report.Feeds, err = app.repository.DeleteOrphanFeeds(ctx, now.Add(-orphanFeedGrace))
+	if err != nil {
+		return report, errors.Wrap(err, "removing orphan feeds failed")
+	}
+
+	app.Infof(ctx, "Cleanup removed %d temporary codes, %d cached email items and %d feeds",
+		report.TemporaryCodes, report.EmailItems, report.Feeds)
+
+	if opts.Optimize {
+		if err := app.repository.Optimize(ctx); err != nil {
+			return report, errors.Wrap(err, "optimizing datastore failed")
+		}
+	}
+
+	return report, nil
+}
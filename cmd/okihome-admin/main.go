@@ -0,0 +1,331 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//okihome-admin manages the users of an instance directly in its repository, using the configuration of the server
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"golang.org/x/oauth2"
+
+	"github.com/oki-apps/okihome"
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/logInteractor/console"
+	"github.com/oki-apps/okihome/providers/gmail"
+	"github.com/oki-apps/okihome/providers/outlook"
+	"github.com/oki-apps/okihome/repository/postgresql"
+	"github.com/oki-apps/okihome/repository/sqlite"
+	"github.com/oki-apps/okihome/userInteractor/contextUser"
+)
+
+//config is the part of the server configuration used by the commands
+type config struct {
+	Postgresql *postgresql.Config
+	SQLite     *sqlite.Config
+	Gmail      *gmail.Config
+	Outlook    *outlook.Config
+}
+
+//adminID is the administrator the commands are run as
+const adminID = "okihome-admin"
+
+type adminUser struct{}
+
+func (adminUser) ID() string          { return adminID }
+func (adminUser) DisplayName() string { return "okihome-admin" }
+func (adminUser) Email() string       { return "" }
+
+//passphraseVariable is the environment variable holding the passphrase of the snapshots
+const passphraseVariable = "OKIHOME_PASSPHRASE"
+
+const usage = `Usage: okihome-admin [-config okihome.json] command
+
+Commands:
+  users                              list the users
+  promote user                       make the user an administrator
+  demote user                        remove the administrator rights of the user
+  delete-user user                   delete the user and all its data
+  revoke-account user account        revoke the account of the user, removing its widgets
+  backup user [file]                 write the snapshot of the user to the file, or to the standard output
+  restore user file                  restore the snapshot of the user, creating the user and its accounts if needed
+  rotate-token-keys                  encrypt the stored tokens with the newest of the configured token keys
+  upgrade-widgets                    migrate the stored widget configs to their current version
+
+The snapshots include the tokens of the accounts, so a user can be moved to another repository
+(such as from SQLite to PostgreSQL) without authorizing the accounts again. They are encrypted and
+decrypted with the passphrase of the OKIHOME_PASSPHRASE environment variable, if set.
+`
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func readConfig(path string) config {
+	var cfg config
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		fail(err)
+	}
+
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		fail(err)
+	}
+
+	return cfg
+}
+
+func newApp(cfg config) (*okihome.App, api.Repository) {
+
+	var repo api.Repository
+	var err error
+	if cfg.Postgresql != nil {
+		repo, err = postgresql.New(*cfg.Postgresql)
+	} else if cfg.SQLite != nil {
+		repo, err = sqlite.New(*cfg.SQLite)
+	} else {
+		fail(fmt.Errorf("Missing datastore configuration"))
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	//The providers revoke the tokens of the deleted accounts
+	var providers []api.Provider
+	if cfg.Gmail != nil {
+		gmailProvider, err := gmail.New(*cfg.Gmail, repo)
+		if err != nil {
+			fail(err)
+		}
+		providers = append(providers, gmailProvider)
+	}
+	if cfg.Outlook != nil {
+		outlookProvider, err := outlook.New(*cfg.Outlook, repo)
+		if err != nil {
+			fail(err)
+		}
+		providers = append(providers, outlookProvider)
+	}
+
+	userInteractor := contextUser.New(contextUser.Config{Admins: []string{adminID}})
+
+	return okihome.NewApp(repo, nil, nil, userInteractor, console.New(), providers), repo
+}
+
+func main() {
+
+	configPath := flag.String("config", "okihome.json", "configuration file of the server")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	command, args := args[0], args[1:]
+
+	app, repo := newApp(readConfig(*configPath))
+	defer app.Shutdown(context.Background())
+
+	ctx := api.ContextWithUser(context.Background(), adminUser{})
+
+	var err error
+	switch {
+	case command == "users" && len(args) == 0:
+		err = listUsers(ctx, repo)
+	case command == "promote" && len(args) == 1:
+		err = setAdmin(ctx, repo, args[0], true)
+	case command == "demote" && len(args) == 1:
+		err = setAdmin(ctx, repo, args[0], false)
+	case command == "delete-user" && len(args) == 1:
+		_, err = app.DeleteUser(ctx, args[0])
+	case command == "revoke-account" && len(args) == 2:
+		var accountID int64
+		accountID, err = strconv.ParseInt(args[1], 10, 64)
+		if err == nil {
+			_, err = app.RevokeAccount(ctx, args[0], accountID, true)
+		}
+	case command == "backup" && (len(args) == 1 || len(args) == 2):
+		err = backup(ctx, app, args)
+	case command == "restore" && len(args) == 2:
+		err = restore(ctx, app, repo, args)
+	case command == "rotate-token-keys" && len(args) == 0:
+		var report okihome.TokenRotationReport
+		report, err = app.RotateTokenKeys(ctx)
+		fmt.Printf("%d token(s) re-encrypted\n", report.Reencrypted)
+	case command == "upgrade-widgets" && len(args) == 0:
+		var report okihome.WidgetConfigUpgradeReport
+		report, err = app.UpgradeWidgetConfigs(ctx)
+		fmt.Printf("%d widget config(s) upgraded\n", report.Upgraded)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func listUsers(ctx context.Context, repo api.Repository) error {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tEMAIL\tADMIN")
+
+	page := api.PageRequest{}
+	for {
+		users, next, err := repo.GetUsersPage(ctx, page)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\n", u.UserID, u.DisplayName, u.Email, u.IsAdmin)
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		page.Cursor = next
+	}
+
+	return w.Flush()
+}
+
+func setAdmin(ctx context.Context, repo api.Repository, userID string, admin bool) error {
+
+	if _, err := repo.GetUser(ctx, userID); err != nil {
+		return err
+	}
+
+	return repo.SetUserAdmin(ctx, userID, admin)
+}
+
+func backup(ctx context.Context, app *okihome.App, args []string) error {
+
+	s, err := app.BackupUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	s.Tokens = make(map[int64]*oauth2.Token)
+	for _, a := range s.Accounts {
+		if a.Token != nil {
+			s.Tokens[a.ID] = a.Token
+		}
+	}
+
+	var data interface{} = s
+	if passphrase := os.Getenv(passphraseVariable); len(passphrase) > 0 {
+		data, err = okihome.EncryptSnapshot(s, passphrase)
+		if err != nil {
+			return err
+		}
+	} else if len(s.Tokens) > 0 {
+		fmt.Fprintf(os.Stderr, "The snapshot holds the tokens of %d account(s) unencrypted, %s is not set\n", len(s.Tokens), passphraseVariable)
+	}
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(args[1], b, 0600)
+}
+
+func restore(ctx context.Context, app *okihome.App, repo api.Repository, args []string) error {
+
+	b, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+
+	var s api.Snapshot
+	var encrypted api.EncryptedSnapshot
+	if err := json.Unmarshal(b, &encrypted); err != nil {
+		return err
+	}
+	if encrypted.Version != 0 {
+		passphrase := os.Getenv(passphraseVariable)
+		if len(passphrase) == 0 {
+			return fmt.Errorf("The snapshot is encrypted, %s is missing", passphraseVariable)
+		}
+		s, err = okihome.DecryptSnapshot(encrypted, passphrase)
+	} else {
+		err = json.Unmarshal(b, &s)
+	}
+	if err != nil {
+		return err
+	}
+	if s.User.UserID != args[0] {
+		return fmt.Errorf("The snapshot is the one of %s", s.User.UserID)
+	}
+	if err := s.Validate(); err != nil {
+		return fmt.Errorf("Invalid snapshot: %s", err)
+	}
+
+	if err := restoreAccounts(ctx, repo, s); err != nil {
+		return err
+	}
+
+	return app.RestoreUser(ctx, args[0], s, api.RestoreSelection{Items: true})
+}
+
+//restoreAccounts creates the user and its accounts missing from the repository, with the tokens of the snapshot.
+//The admin flag of the snapshot is ignored: an existing user keeps its own, and a created user is made
+//an administrator by the promote command only.
+func restoreAccounts(ctx context.Context, repo api.Repository, s api.Snapshot) error {
+
+	userID := s.User.UserID
+	if _, err := repo.GetUser(ctx, userID); repo.IsNotFound(err) {
+		user := s.User
+		user.IsAdmin = false
+		if err := repo.StoreUser(ctx, &user); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "User created:", userID)
+	} else if err != nil {
+		return err
+	}
+
+	accounts, err := repo.GetAccounts(ctx, userID)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for _, a := range accounts {
+		existing[a.Key()] = true
+	}
+
+	for _, a := range s.Accounts {
+		if existing[a.Key()] {
+			continue
+		}
+		a.Token = s.Tokens[a.ID]
+		if a.Token == nil {
+			return fmt.Errorf("No token for account %s in the snapshot, it must be associated before restoring", a.Key())
+		}
+		a.ID = 0
+		if err := repo.StoreAccount(ctx, userID, &a); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "Account created:", a.Key())
+	}
+
+	return nil
+}
@@ -5,30 +5,46 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"google.golang.org/appengine"
 
 	_ "github.com/lib/pq"
 	"github.com/oki-apps/okihome"
 	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/blobStore/gcs"
 	"github.com/oki-apps/okihome/logInteractor/console"
+	"github.com/oki-apps/okihome/providers/fixtures"
 	"github.com/oki-apps/okihome/providers/gmail"
 	"github.com/oki-apps/okihome/providers/outlook"
 	"github.com/oki-apps/okihome/repository/datastore"
 	okihomeServer "github.com/oki-apps/okihome/server"
+	"github.com/oki-apps/okihome/summarizer/remote"
 	"github.com/oki-apps/okihome/userInteractor/contextUser"
 	"github.com/oki-apps/server"
 )
 
 type config struct {
-	Server  server.Config
-	Gmail   *gmail.Config
-	Outlook *outlook.Config
+	Server     server.Config
+	Users      contextUser.Config
+	Timeouts   okihomeServer.Timeouts
+	Responses  okihomeServer.Responses
+	CORS       okihomeServer.CORS
+	GCS        *gcs.Config
+	Summarizer *remote.Config
+	Gmail      *gmail.Config
+	Outlook    *outlook.Config
+	//Fixtures records or replays the calls of the providers having no fixtures of their own.
+	//In replay mode, the providers serve the recorded inboxes without being called, such as for a demo.
+	Fixtures *fixtures.Config
 }
 
 func readConfig() config {
@@ -71,33 +87,102 @@ func main() {
 		os.Exit(1)
 	}
 
+	//Blob store
+	var blobStore api.BlobStore
+	if cfg.GCS != nil {
+		blobStore, err = gcs.New(*cfg.GCS)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	//Summarizer
+	var summarizer api.Summarizer
+	if cfg.Summarizer != nil {
+		summarizer, err = remote.New(*cfg.Summarizer)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
 	//Log
 	logInteractor := console.New()
 
 	//User
-	userInteractor := contextUser.New()
+	userInteractor := contextUser.WithStoredAdmins(contextUser.New(cfg.Users), repo)
 
 	//Services provider
 	var providers []api.Provider
 	if cfg.Gmail != nil {
-		gmailProvider := gmail.New(*cfg.Gmail, repo)
+		if cfg.Gmail.Fixtures == nil {
+			cfg.Gmail.Fixtures = cfg.Fixtures
+		}
+		gmailProvider, err := gmail.New(*cfg.Gmail, repo)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		providers = append(providers, gmailProvider)
 	}
 	if cfg.Outlook != nil {
-		outlookProvider := outlook.New(*cfg.Outlook, repo)
+		if cfg.Outlook.Fixtures == nil {
+			cfg.Outlook.Fixtures = cfg.Fixtures
+		}
+		outlookProvider, err := outlook.New(*cfg.Outlook, repo)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		providers = append(providers, outlookProvider)
 	}
 
-	app := okihome.NewApp(repo, userInteractor, logInteractor, providers)
+	app := okihome.NewApp(repo, blobStore, summarizer, userInteractor, logInteractor, providers)
 
 	//Server
-	s, err := okihomeServer.New(app, cfg.Server)
+	drain := okihomeServer.NewDrain()
+	s, err := okihomeServer.New(app, cfg.Server, okihomeServer.Options{
+		Timeouts:  cfg.Timeouts,
+		Responses: cfg.Responses,
+		CORS:      cfg.CORS,
+		Drain:     drain,
+	})
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
+	//App Engine asks the instances to stop with a SIGTERM
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM)
+		<-signals
+		os.Exit(shutdown(drain, app))
+	}()
+
 	//Start web app
 	http.Handle("/", s.Handler())
 	appengine.Main()
 }
+
+//shutdownTimeout bounds the wait for the requests and the background writes once the instance is stopping
+const shutdownTimeout = 10 * time.Second
+
+//shutdown rejects the new requests, then waits for the requests and the background writes,
+//and returns the exit code of the process
+func shutdown(drain *okihomeServer.Drain, app *okihome.App) int {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	code := 0
+	if err := drain.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+		code = 1
+	}
+	if err := app.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+		code = 1
+	}
+	return code
+}
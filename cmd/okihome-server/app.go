@@ -5,115 +5,704 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/oki-apps/okihome"
 	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/auditSink/syslog"
+	"github.com/oki-apps/okihome/auditSink/webhook"
+	"github.com/oki-apps/okihome/blobStore/gcs"
+	"github.com/oki-apps/okihome/blobStore/local"
+	"github.com/oki-apps/okihome/blobStore/s3"
 	"github.com/oki-apps/okihome/logInteractor/console"
+	"github.com/oki-apps/okihome/logInteractor/sentry"
+	"github.com/oki-apps/okihome/metrics"
+	"github.com/oki-apps/okihome/providers/fixtures"
 	"github.com/oki-apps/okihome/providers/gmail"
 	"github.com/oki-apps/okihome/providers/outlook"
+	"github.com/oki-apps/okihome/repository"
 	"github.com/oki-apps/okihome/repository/postgresql"
 	"github.com/oki-apps/okihome/repository/sqlite"
 	okihomeServer "github.com/oki-apps/okihome/server"
+	"github.com/oki-apps/okihome/summarizer/remote"
+	"github.com/oki-apps/okihome/tracing"
 	"github.com/oki-apps/okihome/userInteractor/contextUser"
 	"github.com/oki-apps/server"
 )
 
+//backupsConfig is the configuration of the scheduled backups, stored in one of the blob stores
+type backupsConfig struct {
+	//Interval is the period of the backups (such as "24h")
+	Interval string
+	//Kept is the number of backups kept by user, okihome.DefaultBackupsKept if zero
+	Kept int
+	//Passphrase encrypts the backups if not empty, at least okihome.MinPassphraseLength long
+	Passphrase string
+	Local      *local.Config
+	GCS        *gcs.Config
+	S3         *s3.Config
+}
+
+//repositoryCacheConfig is the configuration of the in-memory cache of the tabs, widgets and feeds read
+type repositoryCacheConfig struct {
+	//Size is the maximum number of cached entries
+	Size int
+	//TTL is the duration after which an entry is read again (such as "1m").
+	//It bounds the staleness of the changes made by the other instances sharing the database.
+	TTL string
+}
+
+//feedClientConfig is the configuration of the HTTP client retrieving the feeds, the empty fields keeping their default value
+type feedClientConfig struct {
+	//Timeout bounds a whole retrieval (such as "30s")
+	Timeout string
+	//MaxRedirects is the number of redirections followed
+	MaxRedirects int
+	//MaxSize is the number of bytes of a feed above which its retrieval fails
+	MaxSize int64
+	//UserAgent is sent to the feed hosts
+	UserAgent string
+	//Proxy is the URL of the proxy the requests go through, the one of the environment being used if empty
+	Proxy string
+	//MaxConcurrent is the number of feeds downloaded at the same time
+	MaxConcurrent int
+	//MaxPerHost is the number of feeds downloaded at the same time from a same host
+	MaxPerHost int
+	//HostInterval is the minimum duration between two requests to a same host (such as "250ms")
+	HostInterval string
+}
+
 type config struct {
 	Server     server.Config
+	Users      contextUser.Config
+	Timeouts   okihomeServer.Timeouts
+	Responses  okihomeServer.Responses
+	CORS       okihomeServer.CORS
 	Postgresql *postgresql.Config
 	SQLite     *sqlite.Config
+	LocalBlobs *local.Config
+	GCS        *gcs.Config
+	S3         *s3.Config
+	Summarizer *remote.Config
 	Gmail      *gmail.Config
 	Outlook    *outlook.Config
+	//Fixtures records or replays the calls of the providers having no fixtures of their own.
+	//In replay mode, the providers serve the recorded inboxes without being called, such as for a demo.
+	Fixtures  *fixtures.Config
+	Retention *api.RetentionBounds
+	//AuditSyslog and AuditWebhook forward the audit events to a SIEM
+	AuditSyslog  *syslog.Config
+	AuditWebhook *webhook.Config
+	//Backups stores the snapshots of the users periodically, disabled if nil
+	Backups *backupsConfig
+
+	//Demo is the read-only dashboard shown to the visitors who are not logged in, disabled if nil
+	Demo *okihome.Dashboard
+	//Starter is the dashboard created for the users on their first login, the users start with no tabs if nil
+	Starter *okihome.Dashboard
+
+	//Listen replaces the TCP port of Server by a Unix domain socket or a systemd socket, if not nil
+	Listen *listenConfig
+
+	//AccessLog logs each request with its status, duration and user, the secrets of the query strings being redacted
+	AccessLog bool
+
+	//FeedClient configures the retrieval of the feeds, the defaults being used if nil
+	FeedClient *feedClientConfig
+
+	//RepositoryCache keeps the tabs, widgets and feeds read in memory, disabled if nil
+	RepositoryCache *repositoryCacheConfig
+
+	//SlowQueryThreshold logs the repository calls lasting more than this duration (such as "500ms"), with their arguments,
+	//and counts them by method in the admin metrics. Nothing is logged if empty.
+	SlowQueryThreshold string
+
+	//LoadShedding degrades the service while the repository is under pressure, disabled if nil
+	LoadShedding *okihomeServer.LoadShedding
+
+	//EmailSyncInterval is the period of the background inbox synchronization (such as "5m").
+	//The synchronization is disabled if empty.
+	EmailSyncInterval string
+	//EmailSyncIdleAfter pauses the synchronization of the accounts whose widgets were not viewed for this duration (such as "720h").
+	//All the accounts are synchronized if empty.
+	EmailSyncIdleAfter string
+	//FeedRefreshIdleAfter pauses the refresh-feeds subcommand for the feeds whose widgets were not viewed for this duration (such as "720h").
+	//All the due feeds are refreshed if empty.
+	FeedRefreshIdleAfter string
+
+	//VerifyConnectivity checks on startup the OAuth2 client of each provider and the token of each stored account
+	VerifyConnectivity bool
+
+	//SnapshotDiffInterval is the period of the configuration change reports (such as "24h").
+	//The reports are disabled if empty.
+	SnapshotDiffInterval string
+
+	//ShutdownTimeout is how long the shutdown waits for the requests and the background writes (such as "30s")
+	ShutdownTimeout string
+
+	//LogLevel is the minimum level of the logged messages (debug, info, warning or error), info if empty
+	LogLevel string
+
+	//Sentry reports the logged errors to Sentry, or to a compatible service, disabled if nil
+	Sentry *sentry.Config
+
+	//Tracing exports the spans of the requests, the repository calls and the outgoing calls, disabled if nil
+	Tracing *tracing.Config
 }
 
-func readConfig() config {
+//defaultShutdownTimeout is used if no shutdown timeout is configured
+const defaultShutdownTimeout = 30 * time.Second
+
+//readHeaderTimeout bounds the reception of the headers of a request, so that slow clients do not hold the connections
+const readHeaderTimeout = 10 * time.Second
+
+//idleTimeout closes the keep-alive connections without request for this duration
+const idleTimeout = 2 * time.Minute
+
+//defaultConfigPath is the configuration file read if none is given
+const defaultConfigPath = "okihome.json"
+
+func readConfig(path string) config {
 	var cfg config
 
-	path := "okihome.json"
-	if len(os.Args) >= 2 {
-		path = os.Args[1]
+	//The configuration file is optional if the environment holds the whole configuration
+	if readEnvOnly(path) {
+		path = "environment"
+	} else {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		//Unknown keys are rejected, as a typo would silently disable a feature
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cfg)
+		if err != nil {
+			fmt.Println("Invalid configuration", path+":", err)
+			os.Exit(1)
+		}
+	}
+
+	//The environment variables override the file, such as for the secrets
+	applied, err := applyEnv(&cfg, os.Environ())
+	if err != nil {
+		fmt.Println("Invalid configuration", path+":", err)
+		os.Exit(1)
+	}
+	if unknown := unknownEnv(os.Environ(), applied); len(unknown) > 0 {
+		fmt.Println("Invalid configuration", path+": unknown environment variables", strings.Join(unknown, ", "))
+		os.Exit(1)
+	}
+
+	if problems := cfg.validate(); len(problems) > 0 {
+		fmt.Println("Invalid configuration", path+":")
+		for _, p := range problems {
+			fmt.Println("  -", p)
+		}
+		os.Exit(1)
 	}
 
-	b, err := ioutil.ReadFile(path)
+	fmt.Println("Configuration read from ", path)
+	if len(applied) > 0 {
+		fmt.Println("Configuration overridden by ", strings.Join(applied, ", "))
+	}
+
+	return cfg
+}
+
+//newLogInteractor creates the logger printing the messages of the configured level, and reporting the errors if configured.
+//It is shared by the whole process, the returned function sending the reported errors before it exits.
+func newLogInteractor(cfg config) (api.LogInteractor, func(context.Context) error) {
+
+	level, err := api.ParseLogLevel(cfg.LogLevel)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	err = json.Unmarshal(b, &cfg)
+	logInteractor := console.NewWithLevel(level)
+	if cfg.Sentry == nil {
+		return logInteractor, func(context.Context) error { return nil }
+	}
+
+	reporter, err := sentry.New(*cfg.Sentry, logInteractor)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	return reporter, reporter.Flush
+}
 
-	fmt.Println("Configuration read from ", path)
+//newRepository connects to the configured datastore.
+//The slow calls are recorded in slowQueries, if not nil, when a slow query threshold is configured.
+func newRepository(cfg config, logInteractor api.LogInteractor, slowQueries *metrics.Registry) api.Repository {
 
-	return cfg
+	//The slow calls are timed under the lock of SQLite, the wait for the lock not being a slow query
+	slowLog := func(repo api.Repository) api.Repository { return repo }
+	if len(cfg.SlowQueryThreshold) > 0 {
+		threshold, err := time.ParseDuration(cfg.SlowQueryThreshold)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		slowLog = func(repo api.Repository) api.Repository {
+			return repository.WithSlowLog(repo, threshold, logInteractor, slowQueries)
+		}
+	}
+
+	var repo api.Repository
+	var err error
+	if cfg.Postgresql != nil {
+		repo, err = postgresql.New(*cfg.Postgresql)
+	} else if cfg.SQLite != nil {
+		sqliteCfg := *cfg.SQLite
+		sqliteCfg.Log = logInteractor
+		sqliteCfg.Wrap = slowLog
+		repo, err = sqlite.New(sqliteCfg)
+	} else {
+		err = errors.New("Missing datastore configuration")
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cfg.Postgresql != nil {
+		repo = slowLog(repo)
+	}
+
+	return repo
 }
 
 func main() {
 
-	cfg := readConfig()
+	if len(os.Args) >= 2 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "refresh-feeds":
+			runRefreshFeeds(os.Args[2:])
+			return
+		case "check":
+			runCheck(os.Args[2:])
+			return
+		case "seed":
+			runSeed(os.Args[2:])
+			return
+		case "cleanup":
+			runCleanup(os.Args[2:])
+			return
+		}
+	}
+
+	path := defaultConfigPath
+	if len(os.Args) >= 2 {
+		path = os.Args[1]
+	}
+	cfg := readConfig(path)
 
 	//Instantiate all components
 
-	//DatabaseConnector
-	var repo api.Repository
-	if cfg.Postgresql != nil {
+	//Tracing
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.Tracing != nil {
 		var err error
-		repo, err = postgresql.New(*cfg.Postgresql)
+		shutdownTracing, err = tracing.Setup(context.Background(), *cfg.Tracing)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-	} else if cfg.SQLite != nil {
+	}
+
+	//Log
+	logInteractor, flushLog := newLogInteractor(cfg)
+
+	//DatabaseConnector, the slow calls being measured over the last hour
+	slowQueries := metrics.NewRegistry(time.Hour, 1000)
+	repo := newRepository(cfg, logInteractor, slowQueries)
+	if cfg.Tracing != nil {
+		repo = repository.WithTracing(repo)
+	}
+
+	//Repository metrics, feeding the load shedding
+	var repoMetrics *metrics.Window
+	if cfg.LoadShedding != nil {
+		repoMetrics = metrics.NewWindow(time.Minute, 1000)
+		repo = repository.WithMetrics(repo, repoMetrics)
+	}
+
+	//Repository cache, the hits not being measured
+	if cfg.RepositoryCache != nil {
+		ttl, err := time.ParseDuration(cfg.RepositoryCache.TTL)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		repo = repository.WithCache(repo, cfg.RepositoryCache.Size, ttl)
+	}
+
+	//Blob store
+	var blobStore api.BlobStore
+	if cfg.LocalBlobs != nil {
 		var err error
-		repo, err = sqlite.New(*cfg.SQLite)
+		blobStore, err = local.New(*cfg.LocalBlobs)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if cfg.GCS != nil {
+		var err error
+		blobStore, err = gcs.New(*cfg.GCS)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if cfg.S3 != nil {
+		var err error
+		blobStore, err = s3.New(*cfg.S3)
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
 		}
-	} else {
-		fmt.Println("Missing datastore configuration")
-		os.Exit(1)
 	}
 
-	//Log
-	logInteractor := console.New()
+	//Summarizer
+	var summarizer api.Summarizer
+	if cfg.Summarizer != nil {
+		var err error
+		summarizer, err = remote.New(*cfg.Summarizer)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	//User
-	userInteractor := contextUser.New()
+	userInteractor := contextUser.WithStoredAdmins(contextUser.New(cfg.Users), repo)
+
+	//External API metrics, over the last hour
+	apiMetrics := metrics.NewRegistry(time.Hour, 10000)
 
 	//Services provider
 	var providers []api.Provider
 	if cfg.Gmail != nil {
-		gmailProvider := gmail.New(*cfg.Gmail, repo)
+		gmailCfg := *cfg.Gmail
+		gmailCfg.Log = logInteractor
+		gmailCfg.Metrics = apiMetrics
+		if gmailCfg.Fixtures == nil {
+			gmailCfg.Fixtures = cfg.Fixtures
+		}
+		gmailProvider, err := gmail.New(gmailCfg, repo)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		providers = append(providers, gmailProvider)
 	}
 	if cfg.Outlook != nil {
-		outlookProvider := outlook.New(*cfg.Outlook, repo)
+		outlookCfg := *cfg.Outlook
+		outlookCfg.Metrics = apiMetrics
+		if outlookCfg.Fixtures == nil {
+			outlookCfg.Fixtures = cfg.Fixtures
+		}
+		outlookProvider, err := outlook.New(outlookCfg, repo)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 		providers = append(providers, outlookProvider)
 	}
 
-	app := okihome.NewApp(repo, userInteractor, logInteractor, providers)
+	app := okihome.NewApp(repo, blobStore, summarizer, userInteractor, logInteractor, providers)
+	app.SetAPIMetrics(apiMetrics)
+	app.SetRequestMetrics(metrics.NewRegistry(time.Hour, 1000))
+	app.SetSlowQueryMetrics(slowQueries)
+	if cfg.FeedClient != nil {
+		opts := okihome.FeedClientOptions{
+			MaxRedirects:  cfg.FeedClient.MaxRedirects,
+			MaxSize:       cfg.FeedClient.MaxSize,
+			UserAgent:     cfg.FeedClient.UserAgent,
+			MaxConcurrent: cfg.FeedClient.MaxConcurrent,
+			MaxPerHost:    cfg.FeedClient.MaxPerHost,
+		}
+		if len(cfg.FeedClient.Timeout) > 0 {
+			timeout, err := time.ParseDuration(cfg.FeedClient.Timeout)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			opts.Timeout = timeout
+		}
+		if len(cfg.FeedClient.HostInterval) > 0 {
+			interval, err := time.ParseDuration(cfg.FeedClient.HostInterval)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			opts.HostInterval = interval
+		}
+		if len(cfg.FeedClient.Proxy) > 0 {
+			proxy, err := url.Parse(cfg.FeedClient.Proxy)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			opts.Proxy = proxy
+		}
+		app.SetFeedClient(opts)
+	}
+
+	//Audit
+	var auditSink api.AuditSink
+	if cfg.AuditSyslog != nil {
+		var err error
+		auditSink, err = syslog.New(*cfg.AuditSyslog)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else if cfg.AuditWebhook != nil {
+		var err error
+		auditSink, err = webhook.New(*cfg.AuditWebhook)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	//Background workers, stopped on shutdown
+	ctx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	runWorker := func(f func(ctx context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			f(ctx)
+		}()
+	}
+
+	if auditSink != nil {
+		app.SetAuditSink(auditSink)
+		runWorker(func(ctx context.Context) { app.RunAuditForwarding(ctx, time.Minute) })
+	}
+
+	//Dashboards
+	if cfg.Starter != nil {
+		app.SetStarterDashboard(*cfg.Starter)
+	}
+	if cfg.Demo != nil {
+		err := app.SetupDemo(context.Background(), *cfg.Demo)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	//Retention
+	if cfg.Retention != nil {
+		app.SetRetentionBounds(*cfg.Retention)
+	}
+	runWorker(func(ctx context.Context) { app.RunRetentionCleanup(ctx, okihome.RetentionCleanupInterval) })
+
+	//Background synchronization
+	if len(cfg.EmailSyncInterval) > 0 {
+		interval, err := time.ParseDuration(cfg.EmailSyncInterval)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if len(cfg.EmailSyncIdleAfter) > 0 {
+			idleAfter, err := time.ParseDuration(cfg.EmailSyncIdleAfter)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			app.SetSyncIdleAfter(idleAfter)
+		}
+		runWorker(func(ctx context.Context) { app.RunEmailSync(ctx, interval) })
+	}
+	runWorker(func(ctx context.Context) { app.RunTemporaryCodeCleanup(ctx, time.Hour) })
+	runWorker(func(ctx context.Context) { app.RunWatchRenewal(ctx, okihome.WatchRenewalInterval) })
+	if cfg.VerifyConnectivity {
+		runWorker(func(ctx context.Context) {
+			if _, err := app.VerifyConnectivity(ctx); err != nil {
+				app.Error(ctx, err)
+			}
+		})
+	}
+	if cfg.Backups != nil {
+		interval, err := time.ParseDuration(cfg.Backups.Interval)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if interval <= 0 {
+			fmt.Println("Backup interval must be positive")
+			os.Exit(1)
+		}
+
+		var backupStore api.BlobStore
+		if cfg.Backups.Local != nil {
+			backupStore, err = local.New(*cfg.Backups.Local)
+		} else if cfg.Backups.GCS != nil {
+			backupStore, err = gcs.New(*cfg.Backups.GCS)
+		} else if cfg.Backups.S3 != nil {
+			backupStore, err = s3.New(*cfg.Backups.S3)
+		} else {
+			err = errors.New("Missing backup storage configuration")
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if len(cfg.Backups.Passphrase) > 0 {
+			if len(cfg.Backups.Passphrase) < okihome.MinPassphraseLength {
+				fmt.Println("Backup passphrase is too short")
+				os.Exit(1)
+			}
+			app.SetBackupPassphrase(cfg.Backups.Passphrase)
+		}
+		app.SetBackupStore(backupStore, cfg.Backups.Kept)
+		runWorker(func(ctx context.Context) { app.RunScheduledBackups(ctx, interval) })
+	}
+	if len(cfg.SnapshotDiffInterval) > 0 {
+		interval, err := time.ParseDuration(cfg.SnapshotDiffInterval)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		runWorker(func(ctx context.Context) { app.RunSnapshotDiff(ctx, interval) })
+	}
+
+	shutdownTimeout := defaultShutdownTimeout
+	if len(cfg.ShutdownTimeout) > 0 {
+		var err error
+		shutdownTimeout, err = time.ParseDuration(cfg.ShutdownTimeout)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
 	//Server
-	s, err := okihomeServer.New(app, cfg.Server)
+	drain := okihomeServer.NewDrain()
+	s, err := okihomeServer.New(app, cfg.Server, okihomeServer.Options{
+		Timeouts:          cfg.Timeouts,
+		LoadShedding:      cfg.LoadShedding,
+		RepositoryMetrics: repoMetrics,
+		Responses:         cfg.Responses,
+		CORS:              cfg.CORS,
+		Drain:             drain,
+		Tracing:           cfg.Tracing != nil,
+		AccessLog:         cfg.AccessLog,
+	})
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
 	//Start web app
-	if err := s.Run(); err != nil {
+	//On the TCP port, the server is run as configured (address, TLS and server settings) and stops with the process,
+	//the drain rejecting the new requests and waiting for the ones in progress.
+	//On a configured socket, the HTTP server is also shut down, which closes the listener.
+	var httpServer *http.Server
+	if cfg.Listen != nil {
+		listener, err := cfg.Listen.listener()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Println("Listening on", listener.Addr())
+
+		httpServer = &http.Server{
+			Handler:           s.Handler(),
+			ReadHeaderTimeout: readHeaderTimeout,
+			IdleTimeout:       idleTimeout,
+		}
+		go func() {
+			if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+	} else {
+		go func() {
+			if err := s.Run(); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	fmt.Println("Shutting down on", <-signals)
+
+	//Stop accepting requests, then wait for the requests, the workers and the background writes
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	//The event streams are closed first, the HTTP server waiting for all the requests in progress.
+	//Closing the listener also removes the Unix domain socket.
+	failed := false
+	drain.Stop()
+	if httpServer != nil {
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			fmt.Println(err)
+			failed = true
+		}
+	}
+	if err := drain.Shutdown(shutdownCtx); err != nil {
+		fmt.Println(err)
+		failed = true
+	}
+
+	stopWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		fmt.Println("Background workers still running")
+		failed = true
+	}
+
+	if err := app.Shutdown(shutdownCtx); err != nil {
 		fmt.Println(err)
+		failed = true
+	}
+	if err := flushLog(shutdownCtx); err != nil {
+		fmt.Println(err)
+		failed = true
+	}
+	//The last spans are sent once everything is stopped
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		fmt.Println(err)
+		failed = true
+	}
+
+	if failed {
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,216 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/oki-apps/okihome"
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/providers/gmail"
+	"github.com/oki-apps/okihome/providers/outlook"
+	"github.com/oki-apps/okihome/repository"
+	"github.com/oki-apps/okihome/repository/postgresql"
+	"github.com/oki-apps/okihome/repository/sqlite"
+)
+
+//validate returns the problems of the configuration, found without connecting to any service
+func (cfg config) validate() []string {
+	var problems []string
+
+	if cfg.Postgresql == nil && cfg.SQLite == nil {
+		problems = append(problems, "missing datastore configuration (Postgresql or SQLite)")
+	}
+	if cfg.Postgresql != nil && cfg.SQLite != nil {
+		problems = append(problems, "both Postgresql and SQLite are configured")
+	}
+
+	if cfg.Listen != nil {
+		problems = append(problems, cfg.Listen.validate()...)
+	}
+
+	if cfg.Sentry != nil && len(cfg.Sentry.DSN) == 0 {
+		problems = append(problems, "missing Sentry.DSN")
+	}
+	if cfg.Tracing != nil {
+		if len(cfg.Tracing.Endpoint) == 0 {
+			problems = append(problems, "missing Tracing.Endpoint")
+		}
+		if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+			problems = append(problems, fmt.Sprintf("Tracing.SampleRatio must be between 0 and 1: %v", cfg.Tracing.SampleRatio))
+		}
+	}
+
+	if cfg.RepositoryCache != nil {
+		if cfg.RepositoryCache.Size <= 0 {
+			problems = append(problems, fmt.Sprintf("RepositoryCache.Size must be positive: %d", cfg.RepositoryCache.Size))
+		}
+		if ttl, err := time.ParseDuration(cfg.RepositoryCache.TTL); err != nil || ttl <= 0 {
+			problems = append(problems, fmt.Sprintf("RepositoryCache.TTL is not a valid duration: %q", cfg.RepositoryCache.TTL))
+		}
+	}
+
+	if cfg.FeedClient != nil {
+		if cfg.FeedClient.MaxRedirects < 0 {
+			problems = append(problems, fmt.Sprintf("FeedClient.MaxRedirects must not be negative: %d", cfg.FeedClient.MaxRedirects))
+		}
+		if cfg.FeedClient.MaxConcurrent < 0 || cfg.FeedClient.MaxPerHost < 0 {
+			problems = append(problems, fmt.Sprintf("FeedClient.MaxConcurrent and FeedClient.MaxPerHost must not be negative: %d, %d", cfg.FeedClient.MaxConcurrent, cfg.FeedClient.MaxPerHost))
+		}
+		if cfg.FeedClient.MaxSize < 0 {
+			problems = append(problems, fmt.Sprintf("FeedClient.MaxSize must not be negative: %d", cfg.FeedClient.MaxSize))
+		}
+		if len(cfg.FeedClient.Proxy) > 0 {
+			if u, err := url.Parse(cfg.FeedClient.Proxy); err != nil || len(u.Host) == 0 {
+				problems = append(problems, fmt.Sprintf("FeedClient.Proxy is not a valid URL: %q", cfg.FeedClient.Proxy))
+			}
+		}
+	}
+
+	if _, err := api.ParseLogLevel(cfg.LogLevel); err != nil {
+		problems = append(problems, "invalid LogLevel: "+err.Error())
+	}
+
+	if cfg.Gmail != nil {
+		problems = append(problems, validateOAuthClient("Gmail", cfg.Gmail.ClientID, cfg.Gmail.ClientSecret, cfg.Gmail.RedirectURL)...)
+	}
+	if cfg.Outlook != nil {
+		problems = append(problems, validateOAuthClient("Outlook", cfg.Outlook.ClientID, cfg.Outlook.ClientSecret, cfg.Outlook.RedirectURL)...)
+	}
+
+	//The empty durations disable the optional features
+	type duration struct {
+		name, value string
+		//positive requires a duration greater than zero, such as the period of a worker
+		positive bool
+	}
+	durations := []duration{
+		{"EmailSyncInterval", cfg.EmailSyncInterval, true},
+		{"EmailSyncIdleAfter", cfg.EmailSyncIdleAfter, false},
+		{"FeedRefreshIdleAfter", cfg.FeedRefreshIdleAfter, false},
+		{"SnapshotDiffInterval", cfg.SnapshotDiffInterval, true},
+		{"ShutdownTimeout", cfg.ShutdownTimeout, false},
+		{"SlowQueryThreshold", cfg.SlowQueryThreshold, false},
+	}
+	if cfg.FeedClient != nil {
+		durations = append(durations,
+			duration{"FeedClient.Timeout", cfg.FeedClient.Timeout, false},
+			duration{"FeedClient.HostInterval", cfg.FeedClient.HostInterval, false})
+	}
+	if cfg.Backups != nil {
+		durations = append(durations, duration{"Backups.Interval", cfg.Backups.Interval, true})
+	}
+	for _, d := range durations {
+		if len(d.value) == 0 {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s is not a valid duration: %q", d.name, d.value))
+		} else if d.positive && v <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive: %q", d.name, d.value))
+		}
+	}
+
+	if cfg.Backups != nil {
+		if len(cfg.Backups.Interval) == 0 {
+			problems = append(problems, "Backups.Interval is missing")
+		}
+		if len(cfg.Backups.Passphrase) > 0 && len(cfg.Backups.Passphrase) < okihome.MinPassphraseLength {
+			problems = append(problems, fmt.Sprintf("Backups.Passphrase is too short, at least %d characters are required", okihome.MinPassphraseLength))
+		}
+		if cfg.Backups.Local == nil && cfg.Backups.GCS == nil && cfg.Backups.S3 == nil {
+			problems = append(problems, "missing backup storage configuration (Backups.Local, Backups.GCS or Backups.S3)")
+		}
+	}
+
+	return problems
+}
+
+//validateOAuthClient checks the OAuth2 client registered on a provider
+func validateOAuthClient(provider string, clientID string, clientSecret string, redirectURL string) []string {
+	var problems []string
+
+	if len(clientID) == 0 {
+		problems = append(problems, provider+".ClientID is missing")
+	}
+	if len(clientSecret) == 0 {
+		problems = append(problems, provider+".ClientSecret is missing")
+	}
+	if u, err := url.Parse(redirectURL); err != nil || !u.IsAbs() || len(u.Host) == 0 {
+		problems = append(problems, fmt.Sprintf("%s.RedirectURL is not an absolute URL: %q", provider, redirectURL))
+	}
+
+	return problems
+}
+
+//runCheck runs the check subcommand: the configuration is validated, then the connections
+//to the datastore and to the providers are tested.
+func runCheck(args []string) {
+
+	path := defaultConfigPath
+	if len(args) >= 1 {
+		path = args[0]
+	}
+	cfg := readConfig(path)
+
+	failed := false
+	report := func(name string, detail string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("%-10s FAILED: %s\n", name, err)
+			return
+		}
+		fmt.Printf("%-10s ok%s\n", name, detail)
+	}
+
+	pending, err := checkDatastore(cfg)
+	if pending > 0 {
+		report("Datastore", fmt.Sprintf(" (%d migrations pending, applied on startup or with okihome-server migrate up)", pending), err)
+	} else {
+		report("Datastore", "", err)
+	}
+
+	if cfg.Gmail != nil {
+		p, err := gmail.New(*cfg.Gmail, nil)
+		if err == nil {
+			err = okihome.CheckOAuthClient(context.Background(), p)
+		}
+		report("Gmail", "", err)
+	}
+	if cfg.Outlook != nil {
+		p, err := outlook.New(*cfg.Outlook, nil)
+		if err == nil {
+			err = okihome.CheckOAuthClient(context.Background(), p)
+		}
+		report("Outlook", "", err)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+//checkDatastore connects to the SQL datastore and returns the number of migrations pending, without migrating it
+func checkDatastore(cfg config) (int, error) {
+
+	var migrator *repository.Migrator
+	var err error
+	if cfg.Postgresql != nil {
+		migrator, err = postgresql.NewMigrator(*cfg.Postgresql)
+	} else {
+		migrator, err = sqlite.NewMigrator(*cfg.SQLite)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer migrator.Close()
+
+	return migrator.Pending()
+}
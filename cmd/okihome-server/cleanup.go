@@ -0,0 +1,67 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oki-apps/okihome"
+	"github.com/oki-apps/okihome/userInteractor/contextUser"
+)
+
+const cleanupUsage = `Usage: okihome-server cleanup [options] [config]
+
+Removes the expired temporary codes, the old cached email items and the feeds no widget displays,
+then reclaims the space of the removed data (VACUUM and ANALYZE on SQLite).
+
+Options:
+`
+
+//runCleanup runs the cleanup subcommand on the configured repository
+func runCleanup(args []string) {
+
+	var opts okihome.CleanupOptions
+	var skipOptimize bool
+	flags := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, cleanupUsage)
+		flags.PrintDefaults()
+	}
+	flags.DurationVar(&opts.EmailCacheAge, "email-age", okihome.DefaultEmailCacheAge, "age of the cached email items removed")
+	flags.BoolVar(&skipOptimize, "no-optimize", false, "do not optimize the repository")
+	flags.Parse(args)
+	opts.Optimize = !skipOptimize
+
+	path := defaultConfigPath
+	if flags.NArg() >= 1 {
+		path = flags.Arg(0)
+	}
+	cfg := readConfig(path)
+
+	logInteractor, flushLog := newLogInteractor(cfg)
+	repo := newRepository(cfg, logInteractor, nil)
+	app := okihome.NewApp(repo, nil, nil, contextUser.New(cfg.Users), logInteractor, nil)
+
+	ctx := context.Background()
+	_, cleanupErr := app.Cleanup(ctx, opts)
+
+	if err := app.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	flushCtx, cancel := context.WithTimeout(ctx, defaultShutdownTimeout)
+	defer cancel()
+	if err := flushLog(flushCtx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if cleanupErr != nil {
+		fmt.Println(cleanupErr)
+		os.Exit(1)
+	}
+}
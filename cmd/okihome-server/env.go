@@ -0,0 +1,168 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//envPrefix starts the names of the environment variables overriding the configuration
+const envPrefix = "OKIHOME"
+
+//applyEnv overrides the configuration with the environment variables.
+//The name of a variable is the path of the field in upper case, such as OKIHOME_POSTGRESQL_CONNECTIONSTRING
+//or OKIHOME_GMAIL_CLIENTSECRET. The strings, numbers and booleans are given as is, the other values in JSON.
+//A section missing from the configuration file is created when one of its fields is set.
+//It returns the names of the variables applied.
+func applyEnv(cfg *config, environ []string) ([]string, error) {
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv, envPrefix+"_") {
+			continue
+		}
+		env[kv[:i]] = kv[i+1:]
+	}
+	if len(env) == 0 {
+		return nil, nil
+	}
+
+	var applied []string
+	err := applyEnvValue(reflect.ValueOf(cfg).Elem(), envPrefix, env, &applied)
+	return applied, err
+}
+
+//applyEnvValue sets the value, or its fields, from the variables starting with name
+func applyEnvValue(v reflect.Value, name string, env map[string]string, applied *[]string) error {
+
+	if s, ok := env[name]; ok {
+		if err := setEnvValue(v, s); err != nil {
+			return fmt.Errorf("invalid value of %s: %s", name, err)
+		}
+		*applied = append(*applied, name)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.Type().Elem().Kind() != reflect.Struct || !hasEnvPrefix(env, name+"_") {
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return applyEnvValue(v.Elem(), name, env, applied)
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if len(f.PkgPath) > 0 {
+				continue
+			}
+			fieldName := f.Name
+			if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if len(tag) > 0 {
+				fieldName = tag
+			}
+
+			if err := applyEnvValue(v.Field(i), name+"_"+strings.ToUpper(fieldName), env, applied); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//setEnvValue parses the value of a variable
+func setEnvValue(v reflect.Value, s string) error {
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		p := reflect.New(v.Type())
+		if err := json.Unmarshal([]byte(s), p.Interface()); err != nil {
+			return err
+		}
+		v.Set(p.Elem())
+	}
+
+	return nil
+}
+
+//hasEnvPrefix returns true if a variable starts with the prefix
+func hasEnvPrefix(env map[string]string, prefix string) bool {
+	for name := range env {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//unknownEnv returns the variables with the prefix not matching any field of the configuration
+func unknownEnv(environ []string, applied []string) []string {
+
+	known := make(map[string]bool)
+	for _, name := range applied {
+		known[name] = true
+	}
+
+	var unknown []string
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		if strings.HasPrefix(name, envPrefix+"_") && !known[name] && !isReservedEnv(name) {
+			unknown = append(unknown, name)
+		}
+	}
+
+	return unknown
+}
+
+//isReservedEnv returns true for the variables of the commands, not related to the configuration
+func isReservedEnv(name string) bool {
+	return name == "OKIHOME_PASSPHRASE"
+}
+
+//readEnvOnly is true if the default configuration file is missing, the configuration coming only from the environment
+func readEnvOnly(path string) bool {
+	if path != defaultConfigPath {
+		return false
+	}
+	_, err := os.Stat(path)
+	return os.IsNotExist(err)
+}
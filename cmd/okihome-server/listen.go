@@ -0,0 +1,104 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+//listenConfig selects the socket the server listens on, instead of the TCP port of the server configuration.
+//It lets a reverse proxy on the same host reach the server without a TCP port.
+type listenConfig struct {
+	//Systemd uses the socket passed by the systemd socket activation
+	Systemd bool
+	//UnixSocket is the path of the Unix domain socket created by the server
+	UnixSocket string
+	//SocketMode is the permission of the Unix domain socket in octal (such as "0660"), following the umask if empty
+	SocketMode string
+}
+
+//systemdFirstFD is the first file descriptor passed by systemd
+const systemdFirstFD = 3
+
+//validate returns the problems of the listener configuration
+func (cfg listenConfig) validate() []string {
+	var problems []string
+
+	if cfg.Systemd && len(cfg.UnixSocket) > 0 {
+		problems = append(problems, "both Listen.Systemd and Listen.UnixSocket are configured")
+	}
+	if !cfg.Systemd && len(cfg.UnixSocket) == 0 {
+		problems = append(problems, "missing listener configuration (Listen.Systemd or Listen.UnixSocket)")
+	}
+	if len(cfg.SocketMode) > 0 {
+		if _, err := strconv.ParseUint(cfg.SocketMode, 8, 32); err != nil {
+			problems = append(problems, fmt.Sprintf("Listen.SocketMode is not an octal permission: %q", cfg.SocketMode))
+		}
+	}
+
+	return problems
+}
+
+//listener opens the configured socket
+func (cfg listenConfig) listener() (net.Listener, error) {
+
+	if cfg.Systemd {
+		return systemdListener()
+	}
+
+	//A socket left by a previous process would prevent listening
+	if fi, err := os.Stat(cfg.UnixSocket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(cfg.UnixSocket); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", cfg.UnixSocket)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.SocketMode) > 0 {
+		mode, _ := strconv.ParseUint(cfg.SocketMode, 8, 32)
+		if err := os.Chmod(cfg.UnixSocket, os.FileMode(mode)); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+//systemdListener returns the first socket passed by systemd, as described in sd_listen_fds(3)
+func systemdListener() (net.Listener, error) {
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, errors.New("No socket passed by systemd")
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, errors.New("No socket passed by systemd")
+	}
+
+	//The sockets are not passed to the child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(uintptr(systemdFirstFD), "systemd socket")
+	defer f.Close()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid socket passed by systemd: %s", err)
+	}
+
+	return l, nil
+}
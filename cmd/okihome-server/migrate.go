@@ -0,0 +1,96 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oki-apps/okihome/repository"
+	"github.com/oki-apps/okihome/repository/postgresql"
+	"github.com/oki-apps/okihome/repository/sqlite"
+)
+
+const migrateUsage = `Usage: okihome-server migrate <command> [-steps n] [config]
+
+Commands:
+  up      apply the pending migrations
+  down    revert the last applied migrations (-steps, 1 by default)
+  status  list the migrations and whether they are applied
+`
+
+//runMigrate runs the migrate subcommand on the schema of the configured SQL repository
+func runMigrate(args []string) {
+
+	if len(args) == 0 {
+		fmt.Print(migrateUsage)
+		os.Exit(2)
+	}
+	command := args[0]
+	if command != "up" && command != "down" && command != "status" {
+		fmt.Print(migrateUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("migrate "+command, flag.ExitOnError)
+	flags.Usage = func() { fmt.Print(migrateUsage) }
+	steps := flags.Int("steps", 1, "number of migrations to revert")
+	flags.Parse(args[1:])
+
+	path := defaultConfigPath
+	if flags.NArg() >= 1 {
+		path = flags.Arg(0)
+	}
+	cfg := readConfig(path)
+
+	var migrator *repository.Migrator
+	var err error
+	if cfg.Postgresql != nil {
+		migrator, err = postgresql.NewMigrator(*cfg.Postgresql)
+	} else if cfg.SQLite != nil {
+		migrator, err = sqlite.NewMigrator(*cfg.SQLite)
+	} else {
+		fmt.Println("Missing SQL datastore configuration")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer migrator.Close()
+
+	switch command {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		if *steps < 1 {
+			fmt.Println("Invalid number of steps:", *steps)
+			os.Exit(2)
+		}
+		err = migrator.Down(*steps)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, s := range statuses {
+		applied := "pending"
+		if len(s.Applied) > 0 {
+			applied = "applied " + s.Applied
+		}
+		reversible := ""
+		if !s.Reversible {
+			reversible = " (irreversible)"
+		}
+		fmt.Printf("%3d  %-30s  %s%s\n", s.Version, applied, s.Description, reversible)
+	}
+}
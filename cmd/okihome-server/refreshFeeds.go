@@ -0,0 +1,56 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/oki-apps/okihome"
+	"github.com/oki-apps/okihome/userInteractor/contextUser"
+)
+
+//runRefreshFeeds runs the refresh-feeds subcommand: the feeds due for retrieval are downloaded and stored,
+//then the process exits. It lets a cron job or a systemd timer keep the feeds fresh.
+func runRefreshFeeds(args []string) {
+
+	path := defaultConfigPath
+	if len(args) >= 1 {
+		path = args[0]
+	}
+	cfg := readConfig(path)
+
+	logInteractor, flushLog := newLogInteractor(cfg)
+	repo := newRepository(cfg, logInteractor, nil)
+	app := okihome.NewApp(repo, nil, nil, contextUser.New(cfg.Users), logInteractor, nil)
+	if len(cfg.FeedRefreshIdleAfter) > 0 {
+		idleAfter, err := time.ParseDuration(cfg.FeedRefreshIdleAfter)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		app.SetFeedRefreshIdleAfter(idleAfter)
+	}
+
+	ctx := context.Background()
+	refreshErr := app.RefreshDueFeeds(ctx)
+
+	if err := app.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	flushCtx, cancel := context.WithTimeout(ctx, defaultShutdownTimeout)
+	defer cancel()
+	if err := flushLog(flushCtx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if refreshErr != nil {
+		fmt.Println(refreshErr)
+		os.Exit(1)
+	}
+}
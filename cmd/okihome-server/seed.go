@@ -0,0 +1,72 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/oki-apps/okihome"
+	"github.com/oki-apps/okihome/userInteractor/contextUser"
+)
+
+const seedUsage = `Usage: okihome-server seed [options] [config]
+
+Creates a demo user with tabs of feed widgets holding synthetic items, for development,
+screenshots and load testing. The tabs are added to the existing ones of the user.
+
+Options:
+`
+
+//runSeed runs the seed subcommand, creating demo data in the configured repository
+func runSeed(args []string) {
+
+	var opts okihome.SeedOptions
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, seedUsage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.UserID, "user", "demo", "user owning the demo tabs, created if unknown")
+	flags.IntVar(&opts.Tabs, "tabs", 3, "number of tabs")
+	flags.IntVar(&opts.Widgets, "widgets", 6, "number of feed widgets by tab")
+	flags.IntVar(&opts.Items, "items", 20, "number of items by feed")
+	flags.IntVar(&opts.Columns, "columns", 3, "number of columns of the tabs")
+	flags.Int64Var(&opts.Seed, "seed", 0, "seed of the generated data, random if 0")
+	flags.Parse(args)
+
+	path := defaultConfigPath
+	if flags.NArg() >= 1 {
+		path = flags.Arg(0)
+	}
+	cfg := readConfig(path)
+
+	logInteractor, flushLog := newLogInteractor(cfg)
+	repo := newRepository(cfg, logInteractor, nil)
+	app := okihome.NewApp(repo, nil, nil, contextUser.New(cfg.Users), logInteractor, nil)
+
+	ctx := context.Background()
+	tabs, seedErr := app.Seed(ctx, opts)
+	for _, t := range tabs {
+		fmt.Printf("Tab %d created for %s: %s\n", t.ID, opts.UserID, t.Title)
+	}
+
+	if err := app.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	flushCtx, cancel := context.WithTimeout(ctx, defaultShutdownTimeout)
+	defer cancel()
+	if err := flushLog(flushCtx); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if seedErr != nil {
+		fmt.Println(seedErr)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//ConnectivityCheckTimeout bounds each call made to verify a provider or an account
+const ConnectivityCheckTimeout = 10 * time.Second
+
+//ConnectivityReport is the result of the verification of the providers and of the stored accounts
+type ConnectivityReport struct {
+	//Providers holds the problem of each provider, empty if its client is accepted
+	Providers map[string]string
+	//Valid is the number of accounts whose token is accepted by the provider
+	Valid int
+	//NeedsReauth is the number of accounts to be authorized again, including the ones already flagged
+	NeedsReauth int
+	//Failed is the number of accounts that could not be verified
+	Failed int
+}
+
+//CheckOAuthClient exchanges an invalid code at the token endpoint of the provider.
+//The provider rejects the code itself only if it accepts the client credentials.
+func CheckOAuthClient(ctx context.Context, p api.Provider) error {
+
+	ctx, cancel := context.WithTimeout(ctx, ConnectivityCheckTimeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Timeout: ConnectivityCheckTimeout})
+
+	_, err := p.Config().Exchange(ctx, "okihome-check")
+	if err == nil {
+		return errors.New("unexpected token issued for an invalid code")
+	}
+	rErr, ok := err.(*oauth2.RetrieveError)
+	if !ok {
+		return err
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(rErr.Body, &body)
+	switch body.Error {
+	case "invalid_grant":
+		return nil
+	case "invalid_client", "unauthorized_client":
+		return errors.Errorf("client credentials rejected (%s)", body.Error)
+	}
+
+	return errors.Errorf("unexpected answer of the token endpoint: %s", rErr.Response.Status)
+}
+
+//VerifyConnectivity checks the OAuth2 client of each provider and the token of each stored account, without user traffic.
+//The problems are logged and summarized in the report, the accounts whose token is revoked are flagged for a new authorization.
+func (app App) VerifyConnectivity(ctx context.Context) (ConnectivityReport, error) {
+	ctx, span := tracing.Start(ctx, "App.VerifyConnectivity")
+	defer span.End()
+
+	report := ConnectivityReport{Providers: make(map[string]string)}
+
+	names := make([]string, 0, len(app.providers))
+	for name := range app.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		report.Providers[name] = ""
+		if err := CheckOAuthClient(ctx, app.providers[name]); err != nil {
+			app.Error(ctx, errors.Wrap(err, "verification of provider "+name+" failed"))
+			report.Providers[name] = err.Error()
+		}
+	}
+
+	page := api.PageRequest{}
+	for {
+		accounts, next, err := app.repository.GetAccountsPage(ctx, "", page)
+		if err != nil {
+			return report, errors.Wrap(err, "retrieving accounts from datastore failed")
+		}
+
+		for _, account := range accounts {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+
+			err := app.verifyAccount(ctx, account)
+			if _, ok := errors.Cause(err).(reauthRequired); ok {
+				report.NeedsReauth++
+			} else if err != nil {
+				app.Error(ctx, errors.Wrap(err, "verification of account "+account.Key()+" failed"))
+				report.Failed++
+			} else {
+				report.Valid++
+			}
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		page.Cursor = next
+	}
+
+	app.Infof(ctx, "Connectivity verified: %d provider(s), %d valid account(s), %d to authorize again, %d failed",
+		len(names), report.Valid, report.NeedsReauth, report.Failed)
+
+	return report, nil
+}
+
+//verifyAccount calls the provider with the token of the account, refreshing it if needed
+func (app App) verifyAccount(ctx context.Context, account api.ExternalAccount) error {
+
+	if account.NeedsReauth {
+		return reauthRequired(account.ID)
+	}
+
+	emailProvider, err := app.getEmailProvider(account.ProviderName)
+	if err != nil {
+		return errors.Wrap(err, "Email provider not found")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ConnectivityCheckTimeout)
+	defer cancel()
+
+	_, err = emailProvider.GetCurrentEmailAddress(ctx, account)
+	if err != nil {
+		return app.checkReauth(ctx, account, err)
+	}
+
+	return nil
+}
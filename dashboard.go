@@ -0,0 +1,53 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//DashboardTab is a tab of a dashboard given by the configuration, displaying the given feeds
+type DashboardTab struct {
+	Title string
+	Feeds []string
+}
+
+//Dashboard is a set of tabs given by the configuration, such as the demo dashboard
+type Dashboard struct {
+	Tabs []DashboardTab
+	//Columns is the number of columns of the tabs, the feeds being spread over them. 1 if not set.
+	Columns int
+}
+
+//SetStarterDashboard sets the tabs created for the users on their first login.
+//The users start with no tabs if the dashboard is empty.
+func (app *App) SetStarterDashboard(starter Dashboard) {
+	app.starter = starter
+}
+
+//createDashboard creates the tabs of the dashboard for the logged in user.
+//The feeds that cannot be retrieved are skipped.
+func (app App) createDashboard(ctx context.Context, dashboard Dashboard) error {
+
+	for _, t := range dashboard.Tabs {
+		tab, err := app.NewTab(ctx, api.TabSummary{Title: t.Title})
+		if err != nil {
+			return errors.Wrap(err, "creating tab failed")
+		}
+
+		for _, u := range t.Feeds {
+			_, err = app.NewFeedWidgets(ctx, tab.ID, api.WidgetBulkRequest{URLs: []string{u}, Columns: dashboard.Columns})
+			if err != nil {
+				app.Error(ctx, errors.Wrap(err, "adding feed of tab "+t.Title+" failed"))
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,194 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//demoDisabled is returned by the demo requests when no demo dashboard is configured
+type demoDisabled struct{}
+
+func (err demoDisabled) IsNotFound() bool {
+	return true
+}
+func (err demoDisabled) Error() string {
+	return "demo is disabled"
+}
+
+//demoContext returns a context authenticated as the anonymous user, owning the demo dashboard
+func demoContext(ctx context.Context) context.Context {
+	user := api.AnonymousUser
+	user.DisplayName = "Demo"
+	return api.ContextWithUser(ctx, tokenUser{user: user})
+}
+
+//SetupDemo enables the demo dashboard, owned by the anonymous user. Its tabs are created again when the
+//configuration changed since the last start. The feeds that cannot be retrieved are skipped.
+func (app *App) SetupDemo(ctx context.Context, cfg Dashboard) error {
+	ctx, span := tracing.Start(ctx, "App.SetupDemo")
+	defer span.End()
+
+	ctx = demoContext(ctx)
+
+	_, err := app.repository.GetUser(ctx, api.AnonymousUserID)
+	if err != nil {
+		if !app.repository.IsNotFound(err) {
+			return errors.Wrap(err, "retrieving demo user from datastore failed")
+		}
+		user := api.AnonymousUser
+		user.DisplayName = "Demo"
+		err = app.repository.StoreUser(ctx, &user)
+		if err != nil {
+			return errors.Wrap(err, "saving demo user failed")
+		}
+	}
+
+	upToDate, err := app.demoUpToDate(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	app.demo = true
+	if upToDate {
+		return nil
+	}
+
+	tabs, err := app.repository.GetTabs(ctx, api.AnonymousUserID)
+	if err != nil {
+		return errors.Wrap(err, "retrieving demo tabs failed")
+	}
+	for _, t := range tabs {
+		_, err = app.DeleteTab(ctx, t.ID)
+		if err != nil {
+			return errors.Wrap(err, "removing demo tab failed")
+		}
+	}
+
+	return app.createDashboard(ctx, cfg)
+}
+
+//demoUpToDate returns true if the demo tabs have the configured titles and feeds
+func (app App) demoUpToDate(ctx context.Context, cfg Dashboard) (bool, error) {
+
+	tabs, err := app.repository.GetTabs(ctx, api.AnonymousUserID)
+	if err != nil {
+		return false, errors.Wrap(err, "retrieving demo tabs failed")
+	}
+	if len(tabs) != len(cfg.Tabs) {
+		return false, nil
+	}
+
+	for i, t := range tabs {
+		tab, err := app.repository.GetTab(ctx, t.ID)
+		if err != nil {
+			return false, errors.Wrap(err, "retrieving demo tab failed")
+		}
+		if tab.Title != cfg.Tabs[i].Title {
+			return false, nil
+		}
+
+		var urls []string
+		for _, col := range tab.Widgets {
+			for _, w := range col {
+				if feed, ok := w.Config.(api.ConfigFeed); ok {
+					urls = append(urls, feed.URL)
+				}
+			}
+		}
+		expected := make([]string, 0, len(cfg.Tabs[i].Feeds))
+		for _, u := range cfg.Tabs[i].Feeds {
+			expected = append(expected, strings.TrimSpace(u))
+		}
+		sort.Strings(urls)
+		sort.Strings(expected)
+		if strings.Join(urls, "\n") != strings.Join(expected, "\n") {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+//Demo returns the demo dashboard, to be shown to the visitors who are not logged in
+func (app App) Demo(ctx context.Context) (UserData, error) {
+	ctx, span := tracing.Start(ctx, "App.Demo")
+	defer span.End()
+
+	if !app.demo {
+		return UserData{}, demoDisabled{}
+	}
+
+	data := UserData{}
+
+	var err error
+	data.User, err = app.repository.GetUser(ctx, api.AnonymousUserID)
+	if err != nil {
+		return UserData{}, errors.Wrap(err, "retrieving demo user from datastore failed")
+	}
+
+	data.Tabs, err = app.repository.GetTabs(ctx, api.AnonymousUserID)
+	if err != nil {
+		return UserData{}, errors.Wrap(err, "retrieving demo tabs failed")
+	}
+	if len(data.Tabs) > 0 {
+		data.DefaultTabID = data.Tabs[0].ID
+	}
+
+	return data, nil
+}
+
+//DemoTab returns a tab of the demo dashboard
+func (app App) DemoTab(ctx context.Context, tabID int64) (api.Tab, error) {
+	ctx, span := tracing.Start(ctx, "App.DemoTab")
+	defer span.End()
+
+	if !app.demo {
+		return api.Tab{}, demoDisabled{}
+	}
+
+	return app.Tab(demoContext(ctx), tabID)
+}
+
+//DemoFeedItems returns the items of a feed displayed on the demo dashboard
+func (app App) DemoFeedItems(ctx context.Context, feedID int64, tabID int64, widgetID int64, page api.PageRequest) ([]api.ItemForUser, error) {
+	ctx, span := tracing.Start(ctx, "App.DemoFeedItems")
+	defer span.End()
+
+	if !app.demo {
+		return nil, demoDisabled{}
+	}
+
+	if tabID == 0 {
+		return nil, invalidArgument("no demo widget given")
+	}
+
+	ctx = demoContext(ctx)
+
+	//Only the feeds of the demo widgets are given, the other ones may be private
+	tab, err := app.Tab(ctx, tabID)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, col := range tab.Widgets {
+		for _, w := range col {
+			if cfg, ok := w.Config.(api.ConfigFeed); ok && cfg.FeedID == feedID && (widgetID == 0 || w.ID == widgetID) {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil, errors.Wrapf(notAuthorized("access denied to feed"), "feed %d is not in demo tab %d", feedID, tabID)
+	}
+
+	return app.FeedItems(ctx, api.AnonymousUserID, feedID, tabID, widgetID, page)
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//DigestPeriod is the period covered by a feed digest
+const DigestPeriod = 24 * time.Hour
+
+//defaultDigestHeadlines is the number of headlines of a digest when no widget is given
+const defaultDigestHeadlines = 5
+
+//FeedDigest groups the items published during the last DigestPeriod in a single entry,
+//with the count of items and the most recent headlines.
+//The number of headlines is the display count of the given widget (if not zero).
+func (app App) FeedDigest(ctx context.Context, userID string, feedID int64, tabID int64, widgetID int64) (api.FeedDigest, error) {
+	return app.feedDigest(ctx, userID, feedID, tabID, widgetID, nil)
+}
+
+//feedDigest returns the digest of a feed as FeedDigest, its dates being given in loc,
+//or in the time zone of the user if nil
+func (app App) feedDigest(ctx context.Context, userID string, feedID int64, tabID int64, widgetID int64, loc *time.Location) (api.FeedDigest, error) {
+	ctx, span := tracing.Start(ctx, "App.FeedDigest")
+	defer span.End()
+
+	headlines := defaultDigestHeadlines
+	if tabID != 0 && widgetID != 0 {
+		widget, err := app.Widget(ctx, tabID, widgetID)
+		if err != nil {
+			return api.FeedDigest{}, errors.Wrap(err, "retrieving widget failed")
+		}
+		if cfg, ok := widget.Config.(api.ConfigFeed); ok && cfg.DisplayCount > 0 {
+			headlines = cfg.DisplayCount
+		}
+	}
+
+	items, err := app.feedItems(ctx, userID, feedID, tabID, widgetID, api.PageRequest{}, loc)
+	if err != nil {
+		return api.FeedDigest{}, errors.Wrap(err, "retrieving feed items failed")
+	}
+
+	digest := api.FeedDigest{
+		Since:     time.Now().Add(-DigestPeriod),
+		Headlines: []api.ItemForUser{},
+	}
+
+	var recent []api.ItemForUser
+	for _, item := range items {
+		if item.Published.Before(digest.Since) {
+			continue
+		}
+		recent = append(recent, item)
+		digest.Count++
+		if !item.Read {
+			digest.Unread++
+		}
+	}
+
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].Published.After(recent[j].Published)
+	})
+	if len(recent) > headlines {
+		recent = recent[:headlines]
+	}
+	digest.Headlines = append(digest.Headlines, recent...)
+
+	return digest, nil
+}
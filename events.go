@@ -0,0 +1,214 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//WidgetRefreshInterval is the minimum duration between two forced refreshes of a same widget
+const WidgetRefreshInterval = time.Minute
+
+//eventBufferSize is the number of events waiting to be sent to a subscriber above which new events are dropped
+const eventBufferSize = 16
+
+type eventSubscriber struct {
+	userID string
+	//accounts maps the keys of the accounts of the user to their IDs
+	accounts map[string]int64
+	events   chan api.Event
+}
+
+//eventHub dispatches the dashboard updates to the open dashboards of this process
+type eventHub struct {
+	mu          sync.RWMutex
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+func (h *eventHub) subscribe(s *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[s] = struct{}{}
+}
+
+func (h *eventHub) unsubscribe(s *eventSubscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, s)
+}
+
+//publish sends the event to the subscribers of the given user, or owning the given account.
+//The event is sent to all the subscribers if both are empty.
+func (h *eventHub) publish(userID string, accountKey string, event api.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for s := range h.subscribers {
+		if len(userID) > 0 && s.userID != userID {
+			continue
+		}
+		e := event
+		if len(accountKey) > 0 {
+			accountID, ok := s.accounts[accountKey]
+			if !ok {
+				continue
+			}
+			e.AccountID = accountID
+		}
+
+		select {
+		case s.events <- e:
+		default:
+			//The dashboard is too slow, it will reload on the next events
+		}
+	}
+}
+
+//hasSubscribers tells whether a dashboard is open in this process
+func (h *eventHub) hasSubscribers() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers) > 0
+}
+
+//publishLayout notifies the dashboards of the user that its tabs changed
+func (app App) publishLayout(userID string, tabID int64) {
+	app.events.publish(userID, "", api.Event{Type: api.EventLayout, TabID: tabID})
+}
+
+//publishFeedItems notifies the dashboards of the users displaying the feed that it has new items
+func (app App) publishFeedItems(ctx context.Context, feedID int64) {
+	if !app.events.hasSubscribers() {
+		return
+	}
+
+	userIDs, err := app.repository.GetFeedUserIDs(ctx, feedID)
+	if err != nil {
+		app.Error(ctx, errors.Wrap(err, "retrieving users of the feed failed"))
+		return
+	}
+	for _, userID := range userIDs {
+		app.events.publish(userID, "", api.Event{Type: api.EventFeedItems, FeedID: feedID})
+	}
+}
+
+//SubscribeEvents returns the updates of the dashboard of the given user,
+//and a function to be called once the updates are not listened anymore.
+func (app App) SubscribeEvents(ctx context.Context, userID string) (<-chan api.Event, func(), error) {
+	ctx, span := tracing.Start(ctx, "App.SubscribeEvents")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "SubscribeEvents")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accounts, err := app.repository.GetAccounts(ctx, userID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "retrieving accounts from datastore failed")
+	}
+
+	s := &eventSubscriber{
+		userID:   userID,
+		accounts: make(map[string]int64),
+		events:   make(chan api.Event, eventBufferSize),
+	}
+	for _, a := range accounts {
+		s.accounts[a.Key()] = a.ID
+	}
+
+	app.events.subscribe(s)
+
+	return s.events, func() { app.events.unsubscribe(s) }, nil
+}
+
+//refreshLimiter spaces out the forced refreshes of each widget, not to hammer the upstream services
+type refreshLimiter struct {
+	mu   sync.Mutex
+	last map[int64]time.Time
+}
+
+func newRefreshLimiter() *refreshLimiter {
+	return &refreshLimiter{
+		last: make(map[int64]time.Time),
+	}
+}
+
+//allow records a refresh of the widget, or returns false if the previous one is too recent
+func (l *refreshLimiter) allow(widgetID int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for id, t := range l.last {
+		if now.Sub(t) >= WidgetRefreshInterval {
+			delete(l.last, id)
+		}
+	}
+	if _, ok := l.last[widgetID]; ok {
+		return false
+	}
+	l.last[widgetID] = now
+	return true
+}
+
+//RefreshWidget retrieves the latest content of a widget of the given user, without waiting for its cache to expire.
+//The open dashboards are notified once the content is updated.
+//A widget is refreshed at most once per WidgetRefreshInterval.
+func (app App) RefreshWidget(ctx context.Context, userID string, tabID int64, widgetID int64) error {
+	ctx, span := tracing.Start(ctx, "App.RefreshWidget")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, fmt.Sprintf("RefreshWidget tab:%d widget:%d", tabID, widgetID))
+	if err != nil {
+		return err
+	}
+
+	widget, err := app.Widget(ctx, tabID, widgetID)
+	if err != nil {
+		return errors.Wrap(err, "retrieving widget failed")
+	}
+	if !app.refreshes.allow(widgetID) {
+		return errors.Errorf("Widget refreshed less than %s ago", WidgetRefreshInterval)
+	}
+
+	switch cfg := widget.Config.(type) {
+	case api.ConfigFeed:
+		feed, err := app.repository.GetFeed(ctx, cfg.FeedID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving feed from datastore failed")
+		}
+		_, _, err = app.retrieveFeed(ctx, feed)
+		return err
+
+	case api.ConfigEmail:
+		account, err := app.repository.GetAccount(ctx, userID, cfg.AccountID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving account from datastore failed")
+		}
+		if err := app.syncAccount(ctx, account); err != nil {
+			return errors.Wrap(err, "synchronizing account failed")
+		}
+		//The dashboards of the user reload the widget even if the inbox did not change
+		app.events.publish(userID, account.Key(), api.Event{Type: api.EventEmails, TabID: tabID})
+		return nil
+	}
+
+	return errors.Errorf("Widget type cannot be refreshed: %s", widget.Type)
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//maxFaviconSize is the maximum size of a downloaded favicon
+const maxFaviconSize = 1 << 20
+
+//FeedFavicon returns the favicon of the website publishing the given feed.
+//Favicons are cached in the blob store, if any.
+func (app App) FeedFavicon(ctx context.Context, feedID int64) (api.Blob, error) {
+	ctx, span := tracing.Start(ctx, "App.FeedFavicon")
+	defer span.End()
+
+	//Check that a user is logged
+	_, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	key := fmt.Sprintf("favicons/%d", feedID)
+
+	if app.blobStore != nil {
+		blob, err := app.blobStore.Get(ctx, key)
+		if err == nil {
+			return blob, nil
+		}
+		if !app.blobStore.IsNotFound(err) {
+			return api.Blob{}, errors.Wrap(err, "retrieving favicon from blob store failed")
+		}
+	}
+
+	feed, err := app.repository.GetFeed(ctx, feedID)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "retrieving feed from datastore failed")
+	}
+
+	blob, err := app.fetchFavicon(ctx, feed.URL)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "fetching favicon failed")
+	}
+
+	if app.blobStore != nil {
+		if err := app.blobStore.Put(ctx, key, blob); err != nil {
+			app.Error(ctx, errors.Wrap(err, "saving favicon in blob store failed"))
+		}
+	}
+
+	return blob, nil
+}
+
+//fetchFavicon downloads the favicon at the root of the website hosting the given URL
+func (app App) fetchFavicon(ctx context.Context, pageURL string) (api.Blob, error) {
+
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "invalid feed URL")
+	}
+	faviconURL := url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/favicon.ico"}
+
+	return app.fetchImage(ctx, faviconURL.String(), maxFaviconSize)
+}
+
+//fetchImage downloads the image at the given URL with the feed client, failing if it is larger than maxSize
+func (app App) fetchImage(ctx context.Context, imageURL string, maxSize int64) (api.Blob, error) {
+
+	req, err := http.NewRequest("GET", imageURL, nil)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "creating request failed")
+	}
+
+	r, err := app.feedClient(imageURL).Do(req.WithContext(ctx))
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "call to website failed")
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return api.Blob{}, errors.Errorf("website returned %s", r.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "reading image failed")
+	}
+	if int64(len(data)) > maxSize {
+		return api.Blob{}, errors.Errorf("image larger than %d bytes", maxSize)
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return api.Blob{}, errors.New("not an image: " + contentType)
+	}
+
+	return api.Blob{
+		ContentType: contentType,
+		Data:        data,
+	}, nil
+}
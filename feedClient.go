@@ -0,0 +1,166 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//FeedClientOptions configures the HTTP client retrieving the feeds
+type FeedClientOptions struct {
+	//Timeout bounds a whole retrieval, including the redirections and the reading of the body
+	Timeout time.Duration
+	//MaxRedirects is the number of redirections followed
+	MaxRedirects int
+	//MaxSize is the number of bytes of a feed above which its retrieval fails
+	MaxSize int64
+	//UserAgent is sent to the feed hosts
+	UserAgent string
+	//Proxy is the proxy the requests go through, the one of the environment (HTTP_PROXY, HTTPS_PROXY and NO_PROXY) if nil
+	Proxy *url.URL
+	//MaxConcurrent is the number of feeds downloaded at the same time
+	MaxConcurrent int
+	//MaxPerHost is the number of feeds downloaded at the same time from a same host
+	MaxPerHost int
+	//HostInterval is the minimum duration between the starts of two requests to a same host
+	HostInterval time.Duration
+}
+
+//DefaultFeedClientOptions are used until SetFeedClient is called
+var DefaultFeedClientOptions = FeedClientOptions{
+	Timeout:       30 * time.Second,
+	MaxRedirects:  5,
+	MaxSize:       10 * 1024 * 1024,
+	UserAgent:     "Okihome/1.0",
+	MaxConcurrent: 16,
+	MaxPerHost:    2,
+	HostInterval:  250 * time.Millisecond,
+}
+
+//SetFeedClient replaces the HTTP client retrieving the feeds, for the previews and the refreshes, and the limits of their downloads.
+//The zero options keep their default value.
+func (app *App) SetFeedClient(opts FeedClientOptions) {
+	app.feedHTTP = newFeedHTTPClient(opts)
+	app.feedLimiter = newFeedDownloadLimiter(opts)
+}
+
+//newFeedDownloadLimiter creates the limiter of the feed downloads
+func newFeedDownloadLimiter(opts FeedClientOptions) *feedLimiter {
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultFeedClientOptions.MaxConcurrent
+	}
+	if opts.MaxPerHost <= 0 {
+		opts.MaxPerHost = DefaultFeedClientOptions.MaxPerHost
+	}
+	if opts.HostInterval <= 0 {
+		opts.HostInterval = DefaultFeedClientOptions.HostInterval
+	}
+	return newFeedLimiter(opts.MaxConcurrent, opts.MaxPerHost, opts.HostInterval)
+}
+
+//newFeedHTTPClient creates the HTTP client retrieving the feeds
+func newFeedHTTPClient(opts FeedClientOptions) *http.Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultFeedClientOptions.Timeout
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = DefaultFeedClientOptions.MaxRedirects
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultFeedClientOptions.MaxSize
+	}
+	if len(opts.UserAgent) == 0 {
+		opts.UserAgent = DefaultFeedClientOptions.UserAgent
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != nil {
+		proxy = http.ProxyURL(opts.Proxy)
+	}
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: opts.Timeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	maxRedirects := opts.MaxRedirects
+	return &http.Client{
+		Timeout: opts.Timeout,
+		Transport: feedTransport{
+			base:      transport,
+			userAgent: opts.UserAgent,
+			maxSize:   opts.MaxSize,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return errors.Errorf("stopped after %d redirections", maxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+//feedTransport sets the user agent of the requests and limits the size of the responses
+type feedTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	maxSize   int64
+}
+
+func (t feedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	//A round tripper must not modify the request
+	r := *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("User-Agent", t.userAgent)
+
+	resp, err := t.base.RoundTrip(&r)
+	if err != nil {
+		return resp, err
+	}
+	if resp.ContentLength > t.maxSize {
+		resp.Body.Close()
+		return nil, errors.Errorf("feed too large: %d bytes, the limit is %d", resp.ContentLength, t.maxSize)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: t.maxSize}
+	return resp, nil
+}
+
+//limitedBody fails the reading of a body longer than the limit, instead of truncating it
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errors.New("feed too large")
+	}
+	//Reading one byte more than the limit detects the bodies exceeding it
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return 0, errors.New("feed too large")
+	}
+	return n, err
+}
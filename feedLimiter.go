@@ -0,0 +1,121 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//feedLimiter bounds the number of feeds downloaded at the same time, overall and by host,
+//and spaces out the requests sent to a same host
+type feedLimiter struct {
+	slots    chan struct{}
+	perHost  int
+	interval time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostLimit
+}
+
+//hostLimit is the state of the downloads from a host
+type hostLimit struct {
+	slots chan struct{}
+	//next is the earliest start of the next request
+	next time.Time
+	//users is the number of downloads waiting or running, the host being forgotten once it drops to zero
+	//and the interval is over
+	users int
+}
+
+func newFeedLimiter(maxConcurrent int, perHost int, interval time.Duration) *feedLimiter {
+	return &feedLimiter{
+		slots:    make(chan struct{}, maxConcurrent),
+		perHost:  perHost,
+		interval: interval,
+		hosts:    make(map[string]*hostLimit),
+	}
+}
+
+//acquire waits until a feed of the given URL can be downloaded, or until the context is done.
+//The returned function must be called once the download is over.
+func (l *feedLimiter) acquire(ctx context.Context, feedURL string) (func(), error) {
+	host := feedURL
+	if u, err := url.Parse(feedURL); err == nil && len(u.Host) > 0 {
+		host = u.Hostname()
+	}
+
+	l.mu.Lock()
+	l.forgetIdleHosts(time.Now())
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostLimit{slots: make(chan struct{}, l.perHost)}
+		l.hosts[host] = h
+	}
+	h.users++
+	l.mu.Unlock()
+
+	leave := func() {
+		l.mu.Lock()
+		h.users--
+		l.mu.Unlock()
+	}
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		leave()
+		return nil, errors.Wrap(ctx.Err(), "waiting for a download slot for "+host+" failed")
+	}
+
+	//The requests to the host are spaced out by the interval
+	l.mu.Lock()
+	now := time.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait := start.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			<-h.slots
+			leave()
+			return nil, errors.Wrap(ctx.Err(), "waiting for the rate limit of "+host+" failed")
+		}
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		<-h.slots
+		leave()
+		return nil, errors.Wrap(ctx.Err(), "waiting for a download slot failed")
+	}
+
+	return func() {
+		<-l.slots
+		<-h.slots
+		leave()
+	}, nil
+}
+
+//forgetIdleHosts removes the hosts without download whose interval is over, the lock being held
+func (l *feedLimiter) forgetIdleHosts(now time.Time) {
+	for host, h := range l.hosts {
+		if h.users == 0 && !now.Before(h.next) {
+			delete(l.hosts, host)
+		}
+	}
+}
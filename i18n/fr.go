@@ -0,0 +1,210 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package i18n
+
+//catalogs are the translations of the messages, by language
+var catalogs = map[string]map[string]string{
+	"fr": fr,
+}
+
+var fr = map[string]string{
+	//Server
+	"Internal server error":                "Erreur interne du serveur",
+	"Request timeout":                      "Délai de la requête dépassé",
+	"Server shutting down":                 "Arrêt du serveur en cours",
+	"Service temporarily degraded":         "Service temporairement dégradé",
+	"Service temporarily unavailable":      "Service temporairement indisponible",
+	"Invalid API token":                    "Jeton d'API invalide",
+	"Authentication required":              "Authentification requise",
+	"Access denied":                        "Accès refusé",
+	"Not found":                            "Introuvable",
+	"Provider unavailable":                 "Fournisseur indisponible",
+	"Not supported by the storage backend": "Non pris en charge par le stockage",
+
+	//Requests
+	"Unable to add tab":                        "Impossible d'ajouter l'onglet",
+	"Unable to add widget":                     "Impossible d'ajouter le widget",
+	"Unable to add widgets":                    "Impossible d'ajouter les widgets",
+	"Unable to apply email action":             "Impossible d'appliquer l'action sur l'email",
+	"Unable to apply tab operations":           "Impossible de modifier les onglets",
+	"Unable to create API token":               "Impossible de créer le jeton d'API",
+	"Unable to delete tab":                     "Impossible de supprimer l'onglet",
+	"Unable to delete tag":                     "Impossible de supprimer l'étiquette",
+	"Unable to delete user":                    "Impossible de supprimer l'utilisateur",
+	"Unable to delete widget":                  "Impossible de supprimer le widget",
+	"Unable to duplicate tab":                  "Impossible de dupliquer l'onglet",
+	"Unable to edit tab":                       "Impossible de modifier l'onglet",
+	"Unable to edit widget":                    "Impossible de modifier le widget",
+	"Unable to handle callback":                "Impossible de traiter le retour d'autorisation",
+	"Unable to handle push notification":       "Impossible de traiter la notification",
+	"Unable to merge duplicate accounts":       "Impossible de fusionner les comptes en double",
+	"Unable to order tabs":                     "Impossible d'ordonner les onglets",
+	"Unable to record widget views":            "Impossible d'enregistrer l'affichage des widgets",
+	"Unable to relabel account":                "Impossible de renommer le compte",
+	"Unable to remove managed policy":          "Impossible de supprimer la politique de gestion",
+	"Unable to remove notification settings":   "Impossible de supprimer les préférences de notification",
+	"Unable to retrieve notification settings": "Impossible de récupérer les préférences de notification",
+	"Unable to retrieve slow request metrics":  "Impossible de récupérer les mesures des requêtes lentes",
+	"Unable to retrieve slow query metrics":    "Impossible de récupérer les mesures des appels lents au stockage",
+	"Unable to set notification settings":      "Impossible d'enregistrer les préférences de notification",
+	"Unable to remove webhook":                 "Impossible de supprimer le webhook",
+	"Unable to request approval":               "Impossible de demander l'approbation",
+	"Unable to restore user":                   "Impossible de restaurer l'utilisateur",
+	"Unable to retrieve API tokens":            "Impossible de récupérer les jetons d'API",
+	"Unable to retrieve account usage":         "Impossible de récupérer l'utilisation du compte",
+	"Unable to retrieve activity":              "Impossible de récupérer l'activité",
+	"Unable to retrieve approval requests":     "Impossible de récupérer les demandes d'approbation",
+	"Unable to retrieve associated accounts":   "Impossible de récupérer les comptes associés",
+	"Unable to retrieve demo":                  "Impossible de récupérer la démonstration",
+	"Unable to retrieve digest":                "Impossible de récupérer le résumé",
+	"Unable to retrieve feed statistics":       "Impossible de récupérer les statistiques des flux",
+	"Unable to retrieve items for preview":     "Impossible de récupérer les éléments de l'aperçu",
+	"Unable to retrieve items":                 "Impossible de récupérer les éléments",
+	"Unable to retrieve link policies":         "Impossible de récupérer les politiques de liens",
+	"Unable to retrieve lock statistics":       "Impossible de récupérer les statistiques des verrous",
+	"Unable to retrieve managed policy":        "Impossible de récupérer la politique de gestion",
+	"Unable to retrieve retention policy":      "Impossible de récupérer la politique de rétention",
+	"Unable to retrieve services":              "Impossible de récupérer les services",
+	"Unable to retrieve stale widgets":         "Impossible de récupérer les widgets inutilisés",
+	"Unable to retrieve starred items":         "Impossible de récupérer les favoris",
+	"Unable to retrieve suggestions":           "Impossible de récupérer les suggestions",
+	"Unable to retrieve tab":                   "Impossible de récupérer l'onglet",
+	"Unable to retrieve tag collection":        "Impossible de récupérer la collection de l'étiquette",
+	"Unable to retrieve tags":                  "Impossible de récupérer les étiquettes",
+	"Unable to retrieve usage statistics":      "Impossible de récupérer les statistiques d'utilisation",
+	"Unable to retrieve user backup":           "Impossible de récupérer la sauvegarde de l'utilisateur",
+	"Unable to retrieve user statistics":       "Impossible de récupérer les statistiques de l'utilisateur",
+	"Unable to retrieve user":                  "Impossible de récupérer l'utilisateur",
+	"Unable to retrieve userID":                "Impossible de récupérer l'identifiant de l'utilisateur",
+	"Unable to retrieve users":                 "Impossible de récupérer les utilisateurs",
+	"Unable to retrieve webhook":               "Impossible de récupérer le webhook",
+	"Unable to review approval request":        "Impossible de traiter la demande d'approbation",
+	"Unable to revoke API token":               "Impossible de révoquer le jeton d'API",
+	"Unable to revoke account":                 "Impossible de révoquer le compte",
+	"Unable to rotate token keys":              "Impossible de renouveler les clés des jetons",
+	"Unable to set default tab":                "Impossible de définir l'onglet par défaut",
+	"Unable to set retention policy":           "Impossible de définir la politique de rétention",
+	"Unable to set tag":                        "Impossible d'enregistrer l'étiquette",
+	"Unable to set time zone":                  "Impossible de définir le fuseau horaire",
+	"Unable to set webhook":                    "Impossible d'enregistrer le webhook",
+	"Unable to star item":                      "Impossible d'ajouter l'élément aux favoris",
+	"Unable to unstar item":                    "Impossible de retirer l'élément des favoris",
+	"Unable to subscribe to events":            "Impossible de s'abonner aux événements",
+	"Unable to update layout":                  "Impossible de modifier la disposition",
+	"Unable to update link policies":           "Impossible de modifier les politiques de liens",
+	"Unable to update managed policy":          "Impossible de modifier la politique de gestion",
+	"Unable to set locale":                     "Impossible de définir la langue",
+	"Unable to update tag":                     "Impossible de modifier l'étiquette",
+	"Unable to upgrade widget configs":         "Impossible de mettre à jour la configuration des widgets",
+	"Unable to watch account":                  "Impossible de surveiller le compte",
+
+	//Entries
+	"API token ID error":                    "Identifiant de jeton d'API invalide",
+	"API token description decoding failed": "Description du jeton d'API illisible",
+	"API token description is missing":      "Description du jeton d'API manquante",
+	"Account ID error":                      "Identifiant de compte invalide",
+	"Account label decoding failed":         "Nom du compte illisible",
+	"Account label is missing":              "Nom du compte manquant",
+	"Approval decision is invalid":          "Décision d'approbation invalide",
+	"Approval decision is missing":          "Décision d'approbation manquante",
+	"Approval request ID error":             "Identifiant de demande d'approbation invalide",
+	"Approval request is invalid":           "Demande d'approbation invalide",
+	"Approval request is missing":           "Demande d'approbation manquante",
+	"Days error":                            "Nombre de jours invalide",
+	"Default tab decoding failed":           "Onglet par défaut illisible",
+	"Default tab is missing":                "Onglet par défaut manquant",
+	"Email action decoding failed":          "Action sur l'email illisible",
+	"Email action is missing":               "Action sur l'email manquante",
+	"Feed ID error":                         "Identifiant de flux invalide",
+	"Feed list is invalid":                  "Liste de flux invalide",
+	"Feed list is missing":                  "Liste de flux manquante",
+	"GUIDs decoding failed":                 "Identifiants d'éléments illisibles",
+	"GUIDs error":                           "Identifiants d'éléments invalides",
+	"Link policies are invalid":             "Politiques de liens invalides",
+	"Link policies are missing":             "Politiques de liens manquantes",
+	"Managed policy is invalid":             "Politique de gestion invalide",
+	"Managed policy is missing":             "Politique de gestion manquante",
+	"Notification is missing":               "Notification manquante",
+	"Notification settings are missing":     "Préférences de notification manquantes",
+	"Notification settings decoding failed": "Préférences de notification illisibles",
+	"Page error":                            "Page invalide",
+	"Retention policy decoding failed":      "Politique de rétention illisible",
+	"Retention policy is missing":           "Politique de rétention manquante",
+	"Settings decoding failed":              "Préférences illisibles",
+	"Settings are missing":                  "Préférences manquantes",
+	"Snapshot is invalid":                   "Sauvegarde invalide",
+	"Snapshot is missing":                   "Sauvegarde manquante",
+	"Tab export is missing":                 "Export d'onglet manquant",
+	"Tab export is too large":               "Export d'onglet trop volumineux",
+	"Tab export is invalid":                 "Export d'onglet invalide",
+	"Unable to export tab":                  "Impossible d'exporter l'onglet",
+	"Unable to import tab":                  "Impossible d'importer l'onglet",
+	"Template is missing":                   "Modèle manquant",
+	"Template is too large":                 "Modèle trop volumineux",
+	"Template is invalid":                   "Modèle invalide",
+	"Unable to export tab template":         "Impossible d'exporter le modèle d'onglet",
+	"Unable to import template":             "Impossible d'importer le modèle",
+	"Snapshot passphrase is missing":        "Phrase de passe de la sauvegarde manquante",
+	"Unable to decrypt snapshot":            "Impossible de déchiffrer la sauvegarde",
+	"Unable to preview restore":             "Impossible de prévisualiser la restauration",
+	"Start page export is missing":          "Export de page d'accueil manquant",
+	"Start page export is too large":        "Export de page d'accueil trop volumineux",
+	"Unable to import start page":           "Impossible d'importer la page d'accueil",
+	"Snapshot is too large":                 "Sauvegarde trop volumineuse",
+	"Starred item decoding failed":          "Favori illisible",
+	"Starred item error":                    "Favori invalide",
+	"Tab ID error":                          "Identifiant d'onglet invalide",
+	"Tab copy error":                        "Copie d'onglet invalide",
+	"Tab copy is invalid":                   "Copie d'onglet invalide",
+	"Tab description is invalid":            "Description de l'onglet invalide",
+	"Tab description is missing":            "Description de l'onglet manquante",
+	"Tab edited items are invalid":          "Modifications de l'onglet invalides",
+	"Tab edited items are missing":          "Modifications de l'onglet manquantes",
+	"Tab operations are invalid":            "Opérations sur les onglets invalides",
+	"Tab operations are missing":            "Opérations sur les onglets manquantes",
+	"Tab order is invalid":                  "Ordre des onglets invalide",
+	"Tab order is missing":                  "Ordre des onglets manquant",
+	"Tag decoding failed":                   "Étiquette illisible",
+	"Tag is missing":                        "Étiquette manquante",
+	"Webhook decoding failed":               "Webhook illisible",
+	"Webhook is missing":                    "Webhook manquant",
+	"Widget ID error":                       "Identifiant de widget invalide",
+	"Widget config is invalid":              "Configuration du widget invalide",
+	"Widget config is missing":              "Configuration du widget manquante",
+	"Widget description is invalid":         "Description du widget invalide",
+	"Widget description is missing":         "Description du widget manquante",
+	"Widget position is invalid":            "Position du widget invalide",
+	"Widget reference error":                "Référence de widget invalide",
+	"Widget views decoding failed":          "Affichages des widgets illisibles",
+	"Widget views error":                    "Affichages des widgets invalides",
+	"Widgets layout is invalid":             "Disposition des widgets invalide",
+	"Widgets layout is missing":             "Disposition des widgets manquante",
+
+	//Arguments
+	"empty tag name":               "nom d'étiquette vide",
+	"invalid tag name":             "nom d'étiquette invalide",
+	"tag name too long":            "nom d'étiquette trop long",
+	"invalid webhook URL":          "URL de webhook invalide",
+	"no feed URL":                  "aucune URL de flux",
+	"unknown item":                 "élément inconnu",
+	"unknown time zone":            "fuseau horaire inconnu",
+	"no demo widget given":         "aucun widget de démonstration donné",
+	"demo is disabled":             "démonstration désactivée",
+	"unknown notification channel": "canal de notification inconnu",
+	"unknown widget":               "widget inconnu",
+	"unknown feed":                 "flux inconnu",
+	"a notification source is either a widget or a feed": "une source de notification est soit un widget soit un flux",
+	"invalid quiet hour":         "heure de silence invalide",
+	"unknown locale":             "langue inconnue",
+	"widget not found":           "widget introuvable",
+	"feed not allowed":           "flux non autorisé",
+	"feed not retrieved":         "flux non récupéré",
+	"content not retrieved":      "contenu non récupéré",
+	"account to authorize again": "compte à autoriser de nouveau",
+
+	//Providers
+	"Outlook.com":   "Outlook.com",
+	"RSS/Atom feed": "Flux RSS/Atom",
+}
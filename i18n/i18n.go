@@ -0,0 +1,102 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package i18n translates the messages returned by the API in the language of the user.
+//The messages are identified by their English text, returned as is when no translation is known.
+package i18n
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//DefaultLocale is the language of the messages when the user did not choose any
+const DefaultLocale = "en"
+
+type localeKey struct{}
+
+//WithLocale returns a context carrying the language of the request being handled
+func WithLocale(ctx context.Context, locale string) context.Context {
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+//FromContext returns the language set by WithLocale, or DefaultLocale if none
+func FromContext(ctx context.Context) string {
+	if locale, ok := ctx.Value(localeKey{}).(string); ok && len(locale) > 0 {
+		return locale
+	}
+	return DefaultLocale
+}
+
+//Locales returns the supported languages, sorted
+func Locales() []string {
+	locales := []string{DefaultLocale}
+	for l := range catalogs {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+//Supported returns true if the messages can be given in the language
+func Supported(locale string) bool {
+	_, ok := catalogs[locale]
+	return ok || locale == DefaultLocale
+}
+
+//Match returns the supported language preferred in an Accept-Language header, or DefaultLocale if none
+func Match(acceptLanguage string) string {
+
+	best := DefaultLocale
+	bestQ := -1.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(tag) == 0 {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		//Only the language is used, without the region ("fr-CA" is "fr")
+		locale := strings.SplitN(tag, "-", 2)[0]
+		if q > bestQ && q > 0 && Supported(locale) {
+			best, bestQ = locale, q
+		}
+	}
+
+	return best
+}
+
+//Translate returns the message in the given language, or the message itself if no translation is known
+func Translate(locale string, message string) string {
+	if t, ok := catalogs[locale][message]; ok {
+		return t
+	}
+	return message
+}
+
+//TranslateError translates the known parts of an error message, made of the messages of the wrapped errors
+//separated by ": "
+func TranslateError(locale string, message string) string {
+	if _, ok := catalogs[locale]; !ok {
+		return message
+	}
+
+	parts := strings.Split(message, ": ")
+	for i, p := range parts {
+		parts[i] = Translate(locale, p)
+	}
+	return strings.Join(parts, ": ")
+}
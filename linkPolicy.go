@@ -0,0 +1,99 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//LinkPolicies returns the link policies applied on all the widgets of the given user
+func (app App) LinkPolicies(ctx context.Context, userID string) ([]api.LinkPolicy, error) {
+	ctx, span := tracing.Start(ctx, "App.LinkPolicies")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "LinkPolicies")
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := app.repository.GetLinkPolicies(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving link policies from datastore failed")
+	}
+
+	return policies, nil
+}
+
+//SetLinkPolicies replaces the link policies of the given user
+func (app App) SetLinkPolicies(ctx context.Context, userID string, policies []api.LinkPolicy) ([]api.LinkPolicy, error) {
+	ctx, span := tracing.Start(ctx, "App.SetLinkPolicies")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "SetLinkPolicies")
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateLinkPolicies(policies)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.repository.StoreLinkPolicies(ctx, userID, policies)
+	if err != nil {
+		return nil, errors.Wrap(err, "saving link policies in datastore failed")
+	}
+
+	app.audit(ctx, userID, api.AuditPolicyUpdated, "linkpolicies")
+
+	return policies, nil
+}
+
+//validateLinkPolicies returns an error if a policy has no domain, an unknown action or a rewrite without template
+func validateLinkPolicies(policies []api.LinkPolicy) error {
+	for _, p := range policies {
+		if len(p.Domain) == 0 {
+			return invalidArgument("link policy domain is missing")
+		}
+		switch p.Action {
+		case api.LinkAllow, api.LinkDeny:
+		case api.LinkRewrite:
+			if len(p.Rewrite) == 0 {
+				return invalidArgument("rewrite template is missing for domain " + p.Domain)
+			}
+		default:
+			return invalidArgument("invalid link policy action: " + string(p.Action))
+		}
+	}
+	return nil
+}
+
+//linkPolicies returns the policies to apply on the links displayed by a widget:
+//the policies of the widget, if any, followed by the ones of the user.
+func (app App) linkPolicies(ctx context.Context, userID string, widget api.Widget) ([]api.LinkPolicy, error) {
+
+	var policies []api.LinkPolicy
+
+	switch cfg := widget.Config.(type) {
+	case api.ConfigFeed:
+		policies = append(policies, cfg.LinkPolicies...)
+	case api.ConfigEmail:
+		policies = append(policies, cfg.LinkPolicies...)
+	case api.ConfigCollection:
+		policies = append(policies, cfg.LinkPolicies...)
+	}
+
+	userPolicies, err := app.repository.GetLinkPolicies(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving link policies from datastore failed")
+	}
+
+	return append(policies, userPolicies...), nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/i18n"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//SetLocale sets the language of the messages returned to the user.
+//The language of the requests is used if the locale is empty.
+func (app App) SetLocale(ctx context.Context, userID string, locale string) (api.User, error) {
+	ctx, span := tracing.Start(ctx, "App.SetLocale")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "SetLocale")
+	if err != nil {
+		return api.User{}, err
+	}
+
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if len(locale) > 0 && !i18n.Supported(locale) {
+		return api.User{}, invalidArgument("unknown locale: " + locale)
+	}
+
+	err = app.repository.SetUserLocale(ctx, userID, locale)
+	if err != nil {
+		return api.User{}, errors.Wrap(err, "saving locale failed")
+	}
+
+	user, err := app.repository.GetUser(ctx, userID)
+	if err != nil {
+		return api.User{}, errors.Wrap(err, "retrieving user from datastore failed")
+	}
+
+	return user, nil
+}
+
+//Locale returns the language of the messages returned to the logged in user: its preference if set,
+//else the language of the request
+func (app App) Locale(ctx context.Context) string {
+	ctx, span := tracing.Start(ctx, "App.Locale")
+	defer span.End()
+
+	userID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil || len(userID) == 0 {
+		return i18n.FromContext(ctx)
+	}
+
+	user, err := app.repository.GetUser(ctx, userID)
+	if err != nil || !i18n.Supported(user.Locale) {
+		return i18n.FromContext(ctx)
+	}
+
+	return user.Locale
+}
+
+//translate returns the message in the language of the logged in user
+func (app App) translate(ctx context.Context, message string) string {
+	return i18n.Translate(app.Locale(ctx), message)
+}
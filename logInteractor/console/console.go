@@ -7,24 +7,58 @@ package console
 import (
 	"context"
 	"log"
+	"strings"
 
 	"github.com/oki-apps/okihome/api"
 )
 
-type console struct{}
+type console struct {
+	level api.LogLevel
+}
 
-//New creates a new LogInteractor which prints everything in the standard output
+//New creates a new LogInteractor which prints the messages from Info level in the standard output
 func New() api.LogInteractor {
-	return &console{}
+	return NewWithLevel(api.LevelInfo)
+}
+
+//NewWithLevel creates a new LogInteractor which prints the messages of at least the given level in the standard output
+func NewWithLevel(level api.LogLevel) api.LogInteractor {
+	return &console{level: level}
+}
+
+// Debugf is like Infof, but at Debug level.
+func (c *console) Debugf(ctx context.Context, format string, args ...interface{}) {
+	c.printf(ctx, api.LevelDebug, "DBG ", format, args)
 }
 
 // Infof formats its arguments according to the format, analogous to fmt.Printf,
 // and records the text as a log message at Info level.
 func (c *console) Infof(ctx context.Context, format string, args ...interface{}) {
-	log.Printf("INF "+format, args...)
+	c.printf(ctx, api.LevelInfo, "INF ", format, args)
+}
+
+// Warnf is like Infof, but at Warning level.
+func (c *console) Warnf(ctx context.Context, format string, args ...interface{}) {
+	c.printf(ctx, api.LevelWarning, "WRN ", format, args)
 }
 
 // Errorf is like Infof, but at Error level.
 func (c *console) Errorf(ctx context.Context, format string, args ...interface{}) {
-	log.Printf("ERR "+format, args...)
+	c.printf(ctx, api.LevelError, "ERR ", format, args)
+}
+
+//printf prints the message if its level is not below the minimum level
+func (c *console) printf(ctx context.Context, level api.LogLevel, prefix string, format string, args []interface{}) {
+	if level < c.level {
+		return
+	}
+	log.Printf(prefix+requestPrefix(ctx)+format, args...)
+}
+
+//requestPrefix returns the identifier of the request to log, if any
+func requestPrefix(ctx context.Context) string {
+	if id, ok := api.RequestIDFromContext(ctx); ok {
+		return "[" + strings.Replace(id, "%", "%%", -1) + "] "
+	}
+	return ""
 }
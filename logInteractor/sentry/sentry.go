@@ -0,0 +1,280 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package sentry reports the errors to Sentry, or to any service accepting its protocol
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//sendTimeout bounds the sending of an event
+const sendTimeout = 10 * time.Second
+
+//queueSize is the number of events waiting to be sent, the next ones being dropped
+const queueSize = 100
+
+//Config is the configuration of the project receiving the errors
+type Config struct {
+	//DSN is the client key of the project, such as "https://<key>@sentry.example.com/<project>"
+	DSN string
+	//Environment and Release are attached to the events, if set
+	Environment string
+	Release     string
+}
+
+//Reporter is a LogInteractor sending the errors to the project
+type Reporter struct {
+	next     api.LogInteractor
+	endpoint string
+	auth     string
+	cfg      Config
+	server   string
+	queue    chan event
+	client   *http.Client
+}
+
+//New creates a Reporter forwarding everything to next, and sending the Errorf calls to the project.
+//The events are sent in the background, the stack trace of the innermost error of the arguments being attached.
+//A single Reporter should be shared by the whole process, and flushed before it exits.
+func New(cfg Config, next api.LogInteractor) (*Reporter, error) {
+
+	u, err := url.Parse(cfg.DSN)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid Sentry DSN")
+	}
+	if u.User == nil || len(u.User.Username()) == 0 {
+		return nil, errors.New("Sentry DSN has no public key")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i < 0 || i == len(u.Path)-1 {
+		return nil, errors.New("Sentry DSN has no project")
+	}
+
+	auth := "Sentry sentry_version=7, sentry_client=okihome/1.0, sentry_key=" + u.User.Username()
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+
+	server, _ := os.Hostname()
+	r := &Reporter{
+		next:     next,
+		endpoint: u.Scheme + "://" + u.Host + u.Path[:i] + "/api/" + u.Path[i+1:] + "/store/",
+		auth:     auth,
+		cfg:      cfg,
+		server:   server,
+		queue:    make(chan event, queueSize),
+		client:   &http.Client{Timeout: sendTimeout},
+	}
+	go r.run()
+
+	return r, nil
+}
+
+func (r *Reporter) Debugf(ctx context.Context, format string, args ...interface{}) {
+	r.next.Debugf(ctx, format, args...)
+}
+
+func (r *Reporter) Infof(ctx context.Context, format string, args ...interface{}) {
+	r.next.Infof(ctx, format, args...)
+}
+
+func (r *Reporter) Warnf(ctx context.Context, format string, args ...interface{}) {
+	r.next.Warnf(ctx, format, args...)
+}
+
+func (r *Reporter) Errorf(ctx context.Context, format string, args ...interface{}) {
+	r.next.Errorf(ctx, format, args...)
+
+	select {
+	case r.queue <- r.newEvent(ctx, fmt.Sprintf(format, args...), args):
+	default:
+		r.next.Warnf(ctx, "Sentry queue full, error not reported")
+	}
+}
+
+//Flush waits until the events queued before are sent, or until the context is done
+func (r *Reporter) Flush(ctx context.Context) error {
+	//The events are sent in order, so the marker is reached once the previous ones are sent
+	marker := event{flushed: make(chan struct{})}
+	select {
+	case r.queue <- marker:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "sending the errors to Sentry failed")
+	}
+
+	select {
+	case <-marker.flushed:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "sending the errors to Sentry failed")
+	}
+}
+
+//run sends the queued events
+func (r *Reporter) run() {
+	for e := range r.queue {
+		if e.flushed != nil {
+			close(e.flushed)
+			continue
+		}
+		if err := r.send(e); err != nil {
+			r.next.Warnf(context.Background(), "Reporting error %s to Sentry failed: %s", e.EventID, err)
+		}
+	}
+}
+
+//event is an event of the Sentry protocol
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     string            `json:"message"`
+	Exception   []exception       `json:"exception,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	//flushed, if set, marks a flush instead of an event, and is closed once the previous events are sent
+	flushed chan struct{}
+}
+
+type exception struct {
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`
+	Stacktrace *stacktrace `json:"stacktrace,omitempty"`
+}
+
+type stacktrace struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+type stackTracer interface {
+	StackTrace() errors.StackTrace
+}
+
+type causer interface {
+	Cause() error
+}
+
+//newEvent creates the event of an error message, with an exception for the first error of the arguments
+func (r *Reporter) newEvent(ctx context.Context, msg string, args []interface{}) event {
+
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	e := event{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "okihome",
+		ServerName:  r.server,
+		Environment: r.cfg.Environment,
+		Release:     r.cfg.Release,
+		Message:     msg,
+	}
+	if requestID, ok := api.RequestIDFromContext(ctx); ok {
+		e.Tags = map[string]string{"request_id": requestID}
+	}
+
+	for _, arg := range args {
+		if err, ok := arg.(error); ok {
+			e.Exception = []exception{newException(err)}
+			break
+		}
+	}
+
+	return e
+}
+
+//newException describes the cause of the error, with the stack trace of the innermost error holding one
+func newException(err error) exception {
+
+	var st stackTracer
+	for cause := err; cause != nil; {
+		if s, ok := cause.(stackTracer); ok {
+			st = s
+		}
+		c, ok := cause.(causer)
+		if !ok {
+			break
+		}
+		cause = c.Cause()
+	}
+
+	ex := exception{
+		Type:  fmt.Sprintf("%T", errors.Cause(err)),
+		Value: err.Error(),
+	}
+	if st == nil {
+		return ex
+	}
+
+	//Sentry expects the oldest call first
+	trace := st.StackTrace()
+	ex.Stacktrace = &stacktrace{Frames: make([]frame, 0, len(trace))}
+	for i := len(trace) - 1; i >= 0; i-- {
+		pc := uintptr(trace[i]) - 1
+		fn := runtime.FuncForPC(pc)
+		if fn == nil {
+			continue
+		}
+		file, line := fn.FileLine(pc)
+		ex.Stacktrace.Frames = append(ex.Stacktrace.Frames, frame{Function: fn.Name(), Filename: file, Lineno: line})
+	}
+
+	return ex
+}
+
+//send posts the event to the project
+func (r *Reporter) send(e event) error {
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "encoding event failed")
+	}
+
+	req, err := http.NewRequest("POST", r.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "creating request failed")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "call to Sentry failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("Sentry returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,316 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//checkManagerAccess returns an error if the current user is neither an admin nor the manager of the given user.
+//The policy of the managed user is returned if any.
+func (app App) checkManagerAccess(ctx context.Context, userID string, target string) (api.ManagedPolicy, bool, error) {
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.ManagedPolicy{}, false, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	policy, managed, err := app.managedPolicy(ctx, userID)
+	if err != nil {
+		return api.ManagedPolicy{}, false, err
+	}
+
+	//Check authorization
+	if !managed || policy.ManagerID != loggedInUserID {
+		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+			return api.ManagedPolicy{}, false, errors.Wrap(notAuthorized("manager access denied to user: "+userID), "access by "+loggedInUserID)
+		}
+		app.audit(ctx, userID, api.AuditAdminAccess, target)
+	}
+
+	return policy, managed, nil
+}
+
+//managedPolicy returns the policy of the given user, and false if the user is not managed
+func (app App) managedPolicy(ctx context.Context, userID string) (api.ManagedPolicy, bool, error) {
+
+	policy, err := app.repository.GetManagedPolicy(ctx, userID)
+	if err != nil {
+		if app.repository.IsNotFound(err) {
+			return api.ManagedPolicy{}, false, nil
+		}
+		return api.ManagedPolicy{}, false, errors.Wrap(err, "retrieving managed policy from datastore failed")
+	}
+
+	return policy, true, nil
+}
+
+//checkManagedAccess returns an error if the given user is managed and not allowed to use the resource.
+//In that case, an approval request is queued for the manager.
+func (app App) checkManagedAccess(ctx context.Context, userID string, kind api.ApprovalKind, value string) error {
+
+	policy, managed, err := app.managedPolicy(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !managed {
+		return nil
+	}
+
+	allowed := false
+	switch kind {
+	case api.ApprovalFeed:
+		allowed = policy.IsFeedAllowed(value)
+	case api.ApprovalProvider:
+		allowed = policy.IsProviderAllowed(value)
+	}
+	if allowed {
+		return nil
+	}
+
+	_, err = app.queueApprovalRequest(ctx, userID, kind, value)
+	if err != nil {
+		return errors.Wrap(err, "queuing approval request failed")
+	}
+
+	return notAuthorized(fmt.Sprintf("%s not allowed by managed policy, approval requested: %s", kind, value))
+}
+
+//queueApprovalRequest stores a pending approval request, unless an identical one is already pending
+func (app App) queueApprovalRequest(ctx context.Context, userID string, kind api.ApprovalKind, value string) (api.ApprovalRequest, error) {
+
+	requests, err := app.repository.GetApprovalRequests(ctx, userID)
+	if err != nil {
+		return api.ApprovalRequest{}, errors.Wrap(err, "retrieving approval requests from datastore failed")
+	}
+	for _, r := range requests {
+		if r.Kind == kind && r.Value == value && r.Status == api.ApprovalPending {
+			return r, nil
+		}
+	}
+
+	request := api.ApprovalRequest{
+		UserID:    userID,
+		Kind:      kind,
+		Value:     value,
+		Status:    api.ApprovalPending,
+		Requested: time.Now(),
+	}
+	err = app.repository.StoreApprovalRequest(ctx, &request)
+	if err != nil {
+		return api.ApprovalRequest{}, errors.Wrap(err, "saving approval request in datastore failed")
+	}
+
+	return request, nil
+}
+
+//ManagedPolicy returns the policy applied to the given managed user
+func (app App) ManagedPolicy(ctx context.Context, userID string) (api.ManagedPolicy, error) {
+	ctx, span := tracing.Start(ctx, "App.ManagedPolicy")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.ManagedPolicy{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	policy, err := app.repository.GetManagedPolicy(ctx, userID)
+	if err != nil {
+		return api.ManagedPolicy{}, errors.Wrap(err, "retrieving managed policy from datastore failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID && policy.ManagerID != loggedInUserID {
+		if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+			return api.ManagedPolicy{}, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+		}
+		app.audit(ctx, userID, api.AuditAdminAccess, "ManagedPolicy")
+	}
+
+	return policy, nil
+}
+
+//SetManagedPolicy creates or updates the policy of a managed user.
+//Only an admin can turn a user into a managed user or change its manager.
+func (app App) SetManagedPolicy(ctx context.Context, userID string, policy api.ManagedPolicy) (api.ManagedPolicy, error) {
+	ctx, span := tracing.Start(ctx, "App.SetManagedPolicy")
+	defer span.End()
+
+	existing, managed, err := app.checkManagerAccess(ctx, userID, "SetManagedPolicy")
+	if err != nil {
+		return api.ManagedPolicy{}, err
+	}
+
+	policy.UserID = userID
+	if managed && !app.userInteractor.CurrentUserIsAdmin(ctx) {
+		policy.ManagerID = existing.ManagerID
+	}
+	if len(policy.ManagerID) == 0 {
+		return api.ManagedPolicy{}, errors.New("Manager ID is missing")
+	}
+	if policy.ManagerID == userID {
+		return api.ManagedPolicy{}, errors.New("A user can not manage itself")
+	}
+
+	err = app.repository.StoreManagedPolicy(ctx, policy)
+	if err != nil {
+		return api.ManagedPolicy{}, errors.Wrap(err, "saving managed policy in datastore failed")
+	}
+
+	app.audit(ctx, userID, api.AuditPolicyUpdated, "managed")
+
+	return policy, nil
+}
+
+//RemoveManagedPolicy turns a managed user back into a regular user
+func (app App) RemoveManagedPolicy(ctx context.Context, userID string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "App.RemoveManagedPolicy")
+	defer span.End()
+
+	_, _, err := app.checkManagerAccess(ctx, userID, "RemoveManagedPolicy")
+	if err != nil {
+		return false, err
+	}
+
+	err = app.repository.DeleteManagedPolicy(ctx, userID)
+	if err != nil {
+		return false, errors.Wrap(err, "removing managed policy from datastore failed")
+	}
+
+	app.audit(ctx, userID, api.AuditPolicyUpdated, "managed")
+
+	return true, nil
+}
+
+//ApprovalRequests returns the approval requests queued for the given managed user
+func (app App) ApprovalRequests(ctx context.Context, userID string) ([]api.ApprovalRequest, error) {
+	ctx, span := tracing.Start(ctx, "App.ApprovalRequests")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		if _, _, err := app.checkManagerAccess(ctx, userID, "ApprovalRequests"); err != nil {
+			return nil, err
+		}
+	}
+
+	requests, err := app.repository.GetApprovalRequests(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving approval requests from datastore failed")
+	}
+
+	return requests, nil
+}
+
+//RequestApproval asks the manager of the given user to allow a feed or a provider
+func (app App) RequestApproval(ctx context.Context, userID string, kind api.ApprovalKind, value string) (api.ApprovalRequest, error) {
+	ctx, span := tracing.Start(ctx, "App.RequestApproval")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.ApprovalRequest{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		return api.ApprovalRequest{}, errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+	}
+
+	if kind != api.ApprovalFeed && kind != api.ApprovalProvider {
+		return api.ApprovalRequest{}, errors.New("Unknown approval kind: " + string(kind))
+	}
+	if len(value) == 0 {
+		return api.ApprovalRequest{}, errors.New("Approval value is missing")
+	}
+
+	_, managed, err := app.managedPolicy(ctx, userID)
+	if err != nil {
+		return api.ApprovalRequest{}, err
+	}
+	if !managed {
+		return api.ApprovalRequest{}, errors.New("User is not managed: " + userID)
+	}
+
+	return app.queueApprovalRequest(ctx, userID, kind, value)
+}
+
+//ReviewApprovalRequest approves or rejects a pending approval request.
+//Approved feeds and providers are added to the policy of the managed user.
+func (app App) ReviewApprovalRequest(ctx context.Context, userID string, requestID int64, approved bool) (api.ApprovalRequest, error) {
+	ctx, span := tracing.Start(ctx, "App.ReviewApprovalRequest")
+	defer span.End()
+
+	policy, managed, err := app.checkManagerAccess(ctx, userID, fmt.Sprintf("ReviewApprovalRequest request:%d", requestID))
+	if err != nil {
+		return api.ApprovalRequest{}, err
+	}
+	if !managed {
+		return api.ApprovalRequest{}, errors.New("User is not managed: " + userID)
+	}
+
+	requests, err := app.repository.GetApprovalRequests(ctx, userID)
+	if err != nil {
+		return api.ApprovalRequest{}, errors.Wrap(err, "retrieving approval requests from datastore failed")
+	}
+
+	var request *api.ApprovalRequest
+	for i := range requests {
+		if requests[i].ID == requestID {
+			request = &requests[i]
+		}
+	}
+	if request == nil {
+		return api.ApprovalRequest{}, errors.New("Approval request not found")
+	}
+	if request.Status != api.ApprovalPending {
+		return api.ApprovalRequest{}, errors.New("Approval request already reviewed")
+	}
+
+	if approved {
+		request.Status = api.ApprovalGranted
+
+		switch request.Kind {
+		case api.ApprovalFeed:
+			if !policy.IsFeedAllowed(request.Value) {
+				policy.AllowedFeeds = append(policy.AllowedFeeds, request.Value)
+			}
+		case api.ApprovalProvider:
+			if !policy.IsProviderAllowed(request.Value) {
+				policy.AllowedProviders = append(policy.AllowedProviders, request.Value)
+			}
+		}
+
+		err = app.repository.StoreManagedPolicy(ctx, policy)
+		if err != nil {
+			return api.ApprovalRequest{}, errors.Wrap(err, "saving managed policy in datastore failed")
+		}
+	} else {
+		request.Status = api.ApprovalRejected
+	}
+
+	err = app.repository.StoreApprovalRequest(ctx, request)
+	if err != nil {
+		return api.ApprovalRequest{}, errors.Wrap(err, "saving approval request in datastore failed")
+	}
+
+	return *request, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+//Transport records the requests sent by base under the given name.
+//The responses with a 5xx status are failures, the ones with a 429 status,
+//or with a 403 status whose body reports an exceeded rate limit as Google APIs do, are throttled.
+//The base transport is returned as is if the registry is nil.
+func (r *Registry) Transport(name string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if r == nil {
+		return base
+	}
+	return transport{registry: r, name: name, base: base}
+}
+
+type transport struct {
+	registry *Registry
+	name     string
+	base     http.RoundTripper
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.registry.Record(t.name, time.Since(start), true, false)
+		return resp, err
+	}
+	t.registry.Record(t.name, time.Since(start),
+		resp.StatusCode >= http.StatusInternalServerError,
+		isThrottled(resp))
+	return resp, nil
+}
+
+//maxPeekedBody is the number of bytes of a 403 response read to find the rate limit errors
+const maxPeekedBody = 64 * 1024
+
+//isThrottled reports whether the response rejects the request because of a rate limit.
+//The body of a 403 response is read, then given back to the caller.
+func isThrottled(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+	default:
+		return false
+	}
+
+	peeked, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxPeekedBody))
+	resp.Body = peekedBody{Reader: io.MultiReader(bytes.NewReader(peeked), resp.Body), Closer: resp.Body}
+
+	//The reasons are rateLimitExceeded and userRateLimitExceeded
+	return bytes.Contains(peeked, []byte("ateLimitExceeded"))
+}
+
+//peekedBody is a body whose beginning was already read
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+//Client returns a copy of the client whose requests are recorded under the given name,
+//the default client being used if c is nil
+func (r *Registry) Client(name string, c *http.Client) *http.Client {
+	if c == nil {
+		c = http.DefaultClient
+	}
+	recorded := *c
+	recorded.Transport = r.Transport(name, c.Transport)
+	return &recorded
+}
+
+//OAuth2Context returns a context whose OAuth2 calls are recorded under the given name, including the token exchanges and refreshes.
+//The HTTP client already given to the OAuth2 calls by the context, if any, is kept.
+func (r *Registry) OAuth2Context(ctx context.Context, name string) context.Context {
+	if r == nil {
+		return ctx
+	}
+	c, _ := ctx.Value(oauth2.HTTPClient).(*http.Client)
+	return context.WithValue(ctx, oauth2.HTTPClient, r.Client(name, c))
+}
@@ -0,0 +1,99 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+//maxNames is the number of names measured separately, the operations of the next names being measured under OtherName
+const maxNames = 200
+
+//OtherName is the name the operations are measured under once maxNames names are measured
+const OtherName = "other"
+
+//A Registry measures the operations by name, such as the calls to each external API.
+//It keeps a window of the recent operations and the totals since its creation.
+type Registry struct {
+	mu      sync.Mutex
+	period  time.Duration
+	size    int
+	entries map[string]*entry
+}
+
+type entry struct {
+	window    *Window
+	count     int64
+	failures  int64
+	throttled int64
+}
+
+//Series summarizes the operations of a name
+type Series struct {
+	//Recent summarizes the operations of the last period
+	Recent Stats `json:"recent"`
+	//ErrorRate is the ratio of failed operations of the last period
+	ErrorRate float64 `json:"error_rate"`
+	//Count, Failures and Throttled are the totals since the creation of the registry
+	Count     int64 `json:"count"`
+	Failures  int64 `json:"failures"`
+	Throttled int64 `json:"throttled"`
+}
+
+//NewRegistry creates a registry whose windows are over the given period, keeping at most size operations by name
+func NewRegistry(period time.Duration, size int) *Registry {
+	return &Registry{
+		period:  period,
+		size:    size,
+		entries: make(map[string]*entry),
+	}
+}
+
+//Record adds the outcome of an operation of the given name.
+//A throttled operation, rejected because of a rate limit, is also a failed one.
+func (r *Registry) Record(name string, latency time.Duration, failed bool, throttled bool) {
+	r.mu.Lock()
+	e, ok := r.entries[name]
+	if !ok && len(r.entries) >= maxNames {
+		//The names may come from the users, such as the hosts of the feeds, so their number is bounded
+		name = OtherName
+		e, ok = r.entries[name]
+	}
+	if !ok {
+		e = &entry{window: NewWindow(r.period, r.size)}
+		r.entries[name] = e
+	}
+	e.count++
+	if failed || throttled {
+		e.failures++
+	}
+	if throttled {
+		e.throttled++
+	}
+	r.mu.Unlock()
+
+	e.window.Record(latency, failed || throttled)
+}
+
+//Snapshot returns the series of all the names
+func (r *Registry) Snapshot() map[string]Series {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := make(map[string]Series, len(r.entries))
+	for name, e := range r.entries {
+		recent := e.window.Stats()
+		res[name] = Series{
+			Recent:    recent,
+			ErrorRate: recent.ErrorRate(),
+			Count:     e.count,
+			Failures:  e.failures,
+			Throttled: e.throttled,
+		}
+	}
+
+	return res
+}
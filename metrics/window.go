@@ -0,0 +1,89 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package metrics measures the latency and the failures of recent operations
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+type sample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+//A Window keeps the outcome of the operations of the last period, up to a given number of operations
+type Window struct {
+	mu      sync.Mutex
+	period  time.Duration
+	size    int
+	samples []sample
+	next    int
+}
+
+//NewWindow creates a window over the given period, keeping at most size operations.
+//The samples are allocated as the operations are recorded.
+func NewWindow(period time.Duration, size int) *Window {
+	return &Window{
+		period: period,
+		size:   size,
+	}
+}
+
+//Record adds the outcome of an operation
+func (w *Window) Record(latency time.Duration, failed bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := sample{at: time.Now(), latency: latency, failed: failed}
+	if len(w.samples) < w.size {
+		w.samples = append(w.samples, s)
+		return
+	}
+	w.samples[w.next] = s
+	w.next = (w.next + 1) % len(w.samples)
+}
+
+//Stats summarizes the operations of a window
+type Stats struct {
+	Count       int           `json:"count"`
+	Failures    int           `json:"failures"`
+	MeanLatency time.Duration `json:"mean_latency"`
+}
+
+//ErrorRate returns the ratio of failed operations
+func (s Stats) ErrorRate() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Failures) / float64(s.Count)
+}
+
+//Stats summarizes the operations of the last period
+func (w *Window) Stats() Stats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var stats Stats
+	var total time.Duration
+	since := time.Now().Add(-w.period)
+	for _, s := range w.samples {
+		if s.at.Before(since) {
+			continue
+		}
+		stats.Count++
+		total += s.latency
+		if s.failed {
+			stats.Failures++
+		}
+	}
+	if stats.Count > 0 {
+		stats.MeanLatency = total / time.Duration(stats.Count)
+	}
+
+	return stats
+}
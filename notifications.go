@@ -0,0 +1,166 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//notificationSettings returns the notification settings of the user, none if not set
+func (app App) notificationSettings(ctx context.Context, userID string) (api.NotificationSettings, error) {
+
+	settings, err := app.repository.GetNotificationSettings(ctx, userID)
+	if err != nil {
+		if !app.repository.IsNotFound(err) {
+			return api.NotificationSettings{}, errors.Wrap(err, "retrieving notification settings from datastore failed")
+		}
+		settings = api.NotificationSettings{UserID: userID}
+	}
+	if settings.Channels == nil {
+		settings.Channels = []api.NotificationChannel{}
+	}
+	if settings.Sources == nil {
+		settings.Sources = []api.NotificationSource{}
+	}
+
+	return settings, nil
+}
+
+//NotificationSettings returns the notification settings of the given user
+func (app App) NotificationSettings(ctx context.Context, userID string) (api.NotificationSettings, error) {
+	ctx, span := tracing.Start(ctx, "App.NotificationSettings")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "NotificationSettings")
+	if err != nil {
+		return api.NotificationSettings{}, err
+	}
+
+	return app.notificationSettings(ctx, userID)
+}
+
+//SetNotificationSettings replaces the notification settings of the given user.
+//The widgets notifying must be on the tabs of the user, the feeds on any of its widgets.
+func (app App) SetNotificationSettings(ctx context.Context, userID string, settings api.NotificationSettings) (api.NotificationSettings, error) {
+	ctx, span := tracing.Start(ctx, "App.SetNotificationSettings")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "SetNotificationSettings")
+	if err != nil {
+		return api.NotificationSettings{}, err
+	}
+
+	settings.UserID = userID
+
+	known := api.NotificationSettings{Channels: api.NotificationChannels}
+	channels := []api.NotificationChannel{}
+	seen := make(map[api.NotificationChannel]bool)
+	for _, c := range settings.Channels {
+		if !known.HasChannel(c) {
+			return api.NotificationSettings{}, invalidArgument("unknown notification channel: " + string(c))
+		}
+		if !seen[c] {
+			seen[c] = true
+			channels = append(channels, c)
+		}
+	}
+	settings.Channels = channels
+
+	if len(settings.QuietStart) > 0 || len(settings.QuietEnd) > 0 {
+		for _, h := range []string{settings.QuietStart, settings.QuietEnd} {
+			if _, err := time.Parse(api.QuietHoursLayout, h); err != nil {
+				return api.NotificationSettings{}, invalidArgument("invalid quiet hour: " + h)
+			}
+		}
+	}
+
+	err = app.checkNotificationSources(ctx, userID, settings.Sources)
+	if err != nil {
+		return api.NotificationSettings{}, err
+	}
+
+	err = app.repository.StoreNotificationSettings(ctx, settings)
+	if err != nil {
+		return api.NotificationSettings{}, errors.Wrap(err, "storing notification settings in datastore failed")
+	}
+
+	app.audit(ctx, userID, api.AuditNotificationsChanged, "")
+
+	return app.notificationSettings(ctx, userID)
+}
+
+//checkNotificationSources returns an error if a source is not displayed on the tabs of the user
+func (app App) checkNotificationSources(ctx context.Context, userID string, sources []api.NotificationSource) error {
+
+	if len(sources) == 0 {
+		return nil
+	}
+
+	tabs, err := app.repository.GetTabs(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "retrieving tab ids from datastore failed")
+	}
+
+	widgets := make(map[api.NotificationSource]bool)
+	feeds := make(map[int64]bool)
+	for _, t := range tabs {
+		tab, err := app.repository.GetTab(ctx, t.ID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving tab from datastore failed")
+		}
+		for _, col := range tab.Widgets {
+			for _, w := range col {
+				widgets[api.NotificationSource{TabID: tab.ID, WidgetID: w.ID}] = true
+				if cfg, ok := w.Config.(api.ConfigFeed); ok {
+					feeds[cfg.FeedID] = true
+				}
+			}
+		}
+	}
+
+	for _, src := range sources {
+		switch {
+		case src.WidgetID != 0 && src.FeedID == 0:
+			if !widgets[api.NotificationSource{TabID: src.TabID, WidgetID: src.WidgetID}] {
+				return invalidArgument(fmt.Sprintf("unknown widget: %d/%d", src.TabID, src.WidgetID))
+			}
+		case src.FeedID != 0 && src.WidgetID == 0 && src.TabID == 0:
+			if !feeds[src.FeedID] {
+				return invalidArgument(fmt.Sprintf("unknown feed: %d", src.FeedID))
+			}
+		default:
+			return invalidArgument("a notification source is either a widget or a feed")
+		}
+	}
+
+	return nil
+}
+
+//DeleteNotificationSettings removes the notification settings of the given user, no longer notified
+func (app App) DeleteNotificationSettings(ctx context.Context, userID string) error {
+	ctx, span := tracing.Start(ctx, "App.DeleteNotificationSettings")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "DeleteNotificationSettings")
+	if err != nil {
+		return err
+	}
+
+	err = app.repository.DeleteNotificationSettings(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "removing notification settings from datastore failed")
+	}
+
+	app.audit(ctx, userID, api.AuditNotificationsChanged, "")
+
+	return nil
+}
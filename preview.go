@@ -0,0 +1,70 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"sync"
+	"time"
+)
+
+//PreviewCacheDuration is how long a preview is reused for the same URL
+const PreviewCacheDuration = 5 * time.Minute
+
+//previewCacheSize is the number of previews above which the expired ones are removed
+const previewCacheSize = 256
+
+type cachedPreview struct {
+	result  PreviewResult
+	expires time.Time
+}
+
+//previewCache keeps the recent previews, shared by all the users as feeds are public
+type previewCache struct {
+	mu       sync.Mutex
+	previews map[string]cachedPreview
+}
+
+func newPreviewCache() *previewCache {
+	return &previewCache{
+		previews: make(map[string]cachedPreview),
+	}
+}
+
+func (c *previewCache) get(URL string) (PreviewResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.previews[URL]
+	if !ok || time.Now().After(p.expires) {
+		return PreviewResult{}, false
+	}
+	return p.result, true
+}
+
+func (c *previewCache) put(URL string, result PreviewResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.previews) >= previewCacheSize {
+		for u, p := range c.previews {
+			if now.After(p.expires) {
+				delete(c.previews, u)
+			}
+		}
+	}
+	if len(c.previews) >= previewCacheSize {
+		//Too many recent previews, a random one is evicted as the map iteration order is random
+		for u := range c.previews {
+			delete(c.previews, u)
+			break
+		}
+	}
+
+	c.previews[URL] = cachedPreview{
+		result:  result,
+		expires: now.Add(PreviewCacheDuration),
+	}
+}
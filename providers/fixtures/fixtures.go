@@ -0,0 +1,233 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package fixtures
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+//Modes of the fixtures
+const (
+	//ModeRecord calls the provider and stores its responses
+	ModeRecord = "record"
+	//ModeReplay answers with the stored responses, without calling the provider
+	ModeReplay = "replay"
+)
+
+//Config is the configuration of the recorded calls to a provider.
+//The recorded responses include the tokens given by the provider, the fixtures must be kept private.
+//The demo directory of this package holds the calls reading a sample Outlook inbox. The authorization of
+//the account, which depends on the client configuration, is to be recorded once before replaying them.
+type Config struct {
+	//Directory holds a file for each recorded call
+	Directory string
+	//Mode is either ModeRecord or ModeReplay
+	Mode string
+}
+
+//volatileParams are the form parameters changing with each OAuth2 flow, ignored to identify the calls.
+//It allows a recorded authorization to be replayed with any code.
+var volatileParams = []string{"code", "refresh_token", "client_secret", "code_verifier", "token"}
+
+//secretHeaders are not stored in the fixtures
+var secretHeaders = []string{"Authorization", "Set-Cookie"}
+
+//A fixture is a recorded call
+type fixture struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+}
+
+//Transport records or replays the HTTP calls to a provider
+type Transport struct {
+	dir    string
+	replay bool
+	base   http.RoundTripper
+	mu     sync.Mutex
+}
+
+//New creates a transport recording or replaying the calls in the given directory
+func New(cfg Config) (*Transport, error) {
+
+	if len(cfg.Directory) == 0 {
+		return nil, errors.New("Fixtures directory is missing")
+	}
+	if cfg.Mode != ModeRecord && cfg.Mode != ModeReplay {
+		return nil, errors.New("Unknown fixtures mode: " + cfg.Mode)
+	}
+	if err := os.MkdirAll(cfg.Directory, 0755); err != nil {
+		return nil, errors.Wrap(err, "Unable to create fixtures directory")
+	}
+
+	return &Transport{
+		dir:    cfg.Directory,
+		replay: cfg.Mode == ModeReplay,
+		base:   http.DefaultTransport,
+	}, nil
+}
+
+//Client returns an HTTP client using the transport, or the default client if the transport is nil
+func (t *Transport) Client() *http.Client {
+	if t == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{Transport: t}
+}
+
+//Context returns a context whose OAuth2 calls use the transport, including the token exchanges and refreshes.
+//The context is returned as is if the transport is nil.
+func (t *Transport) Context(ctx context.Context) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, t.Client())
+}
+
+//RoundTrip answers with the recorded response of the request, or calls the provider and records its response
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to read request body")
+		}
+	}
+
+	path := filepath.Join(t.dir, req.URL.Host, key(req, body)+".json")
+
+	if t.replay {
+		f, err := t.load(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "No fixture for %s %s", req.Method, req.URL)
+		}
+		return f.response(req), nil
+	}
+
+	//The request is sent with the original body
+	out := req.WithContext(req.Context())
+	out.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r, err := t.base.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	respBody, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to read response body")
+	}
+
+	f := fixture{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Status: r.StatusCode,
+		Header: make(http.Header),
+		Body:   string(respBody),
+	}
+	for h, values := range r.Header {
+		f.Header[h] = values
+	}
+	for _, h := range secretHeaders {
+		f.Header.Del(h)
+	}
+	if err := t.store(path, f); err != nil {
+		return nil, err
+	}
+
+	return f.response(req), nil
+}
+
+func (t *Transport) load(path string) (fixture, error) {
+	var f fixture
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return f, errors.Wrap(err, "Unable to decode fixture")
+	}
+
+	return f, nil
+}
+
+func (t *Transport) store(path string, f fixture) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Unable to encode fixture")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "Unable to create fixtures directory")
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return errors.Wrap(err, "Unable to write fixture")
+	}
+
+	return nil
+}
+
+func (f fixture) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        f.Header,
+		Body:          ioutil.NopCloser(strings.NewReader(f.Body)),
+		ContentLength: int64(len(f.Body)),
+		Request:       req,
+	}
+}
+
+//key identifies a call by its method, URL and body, without the volatile parameters
+func key(req *http.Request, body []byte) string {
+
+	u := *req.URL
+	u.RawQuery = stableQuery(u.Query())
+
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			body = []byte(stableQuery(form))
+		}
+	}
+
+	h := sha256.New()
+	h.Write([]byte(req.Method + " " + u.String() + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+//stableQuery encodes the values sorted by name, without the volatile parameters
+func stableQuery(values url.Values) string {
+	for _, p := range volatileParams {
+		values.Del(p)
+	}
+	return values.Encode()
+}
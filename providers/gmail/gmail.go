@@ -6,7 +6,12 @@ package gmail
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,12 +22,20 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/metrics"
+	"github.com/oki-apps/okihome/providers/fixtures"
+	"github.com/oki-apps/okihome/tracing"
 )
 
 type provider struct {
-	desc api.ProviderDescription
-	cfg  *oauth2.Config
-	r    api.Repository
+	desc      api.ProviderDescription
+	cfg       *oauth2.Config
+	pushTopic string
+	pushToken string
+	r         api.Repository
+	fixtures  *fixtures.Transport
+	log       api.LogInteractor
+	metrics   *metrics.Registry
 }
 
 //Config is the configuration of the app that will access Gmail API
@@ -30,6 +43,22 @@ type Config struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
+
+	//PushTopic is the Cloud Pub/Sub topic receiving the mailbox changes (projects/<project>/topics/<topic>).
+	//Push notifications are disabled if empty.
+	PushTopic string
+	//PushToken is the secret expected in the token parameter of the push subscription endpoint
+	PushToken string
+
+	//Fixtures records the calls to Google, or replays them without calling Google.
+	//Google is called if nil.
+	Fixtures *fixtures.Config
+
+	//Log receives the debug logs of the provider, nothing is logged if nil
+	Log api.LogInteractor `json:"-"`
+
+	//Metrics records the calls to Google, nothing is recorded if nil
+	Metrics *metrics.Registry `json:"-"`
 }
 
 var description = api.ProviderDescription{
@@ -37,24 +66,57 @@ var description = api.ProviderDescription{
 	Title:             "Gmail",
 	Link:              "https://gmail.com",
 	AvailableServices: []api.Service{api.ServiceEmail},
+
+	//Gmail has no dedicated reply and forward links, the conversation is opened instead
+	ComposeLink: "https://mail.google.com/mail/?view=cm&fs=1",
+	ReplyLink:   "https://mail.google.com/mail/#inbox/{guid}",
+	ForwardLink: "https://mail.google.com/mail/#inbox/{guid}",
+}
+
+var scopes = map[api.Feature][]string{
+	api.FeatureRead:   []string{gmail.GmailReadonlyScope},
+	api.FeatureModify: []string{gmail.GmailModifyScope},
 }
 
 //New creates a new email provider that is able to access the Gmail API
-func New(cfg Config, r api.Repository) api.EmailProvider {
+func New(cfg Config, r api.Repository) (api.PushProvider, error) {
 	p := provider{
 		desc: description,
 		cfg: &oauth2.Config{
 			ClientID:     cfg.ClientID,
 			ClientSecret: cfg.ClientSecret,
-			Scopes: []string{
-				gmail.GmailReadonlyScope,
-			},
-			RedirectURL: cfg.RedirectURL,
-			Endpoint:    google.Endpoint,
+			Scopes:       scopes[api.FeatureRead],
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     google.Endpoint,
 		},
-		r: r,
+		pushTopic: cfg.PushTopic,
+		pushToken: cfg.PushToken,
+		r:         r,
+		log:       cfg.Log,
+		metrics:   cfg.Metrics,
+	}
+
+	if cfg.Fixtures != nil {
+		var err error
+		p.fixtures, err = fixtures.New(*cfg.Fixtures)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to load Gmail fixtures")
+		}
+	}
+
+	return p, nil
+}
+
+//oauth2Context returns the context of the OAuth2 calls to Google, which are traced and recorded
+func (p provider) oauth2Context(ctx context.Context) context.Context {
+	return p.metrics.OAuth2Context(tracing.OAuth2Context(p.fixtures.Context(ctx)), p.desc.Name)
+}
+
+//debugf logs at Debug level, if a LogInteractor is configured
+func (p provider) debugf(ctx context.Context, format string, args ...interface{}) {
+	if p.log != nil {
+		p.log.Debugf(ctx, format, args...)
 	}
-	return p
 }
 
 func (p provider) Description() api.ProviderDescription {
@@ -65,8 +127,108 @@ func (p provider) Config() *oauth2.Config {
 	return p.cfg
 }
 
+func (p provider) Scopes(feature api.Feature) []string {
+	return scopes[feature]
+}
+
+func (p provider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	return p.cfg.Exchange(p.oauth2Context(ctx), code)
+}
+
+func (p provider) Revoke(ctx context.Context, account api.ExternalAccount) error {
+
+	if account.Token == nil {
+		return errors.New("No token to revoke")
+	}
+
+	//Revoking the refresh token also revokes the associated access tokens
+	token := account.Token.RefreshToken
+	if len(token) == 0 {
+		token = account.Token.AccessToken
+	}
+
+	req, err := http.NewRequest("POST", "https://oauth2.googleapis.com/revoke", strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return errors.Wrap(err, "Unable to create revocation request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	r, err := p.metrics.Client(p.desc.Name, tracing.Client(p.fixtures.Client())).Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "Call to revocation endpoint failed")
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return errors.Errorf("Revocation endpoint returned %s", r.Status)
+	}
+
+	return nil
+}
+
+func (p provider) Watch(ctx context.Context, account api.ExternalAccount) (time.Time, error) {
+
+	if len(p.pushTopic) == 0 {
+		return time.Time{}, nil
+	}
+
+	srv, err := p.getService(ctx, account)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "Unable to connect to the Gmail service")
+	}
+	user := "me"
+
+	r, err := srv.Users.Watch(user, &gmail.WatchRequest{
+		TopicName: p.pushTopic,
+		LabelIds:  []string{"INBOX"},
+	}).Context(ctx).Do()
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "Unable to watch mailbox")
+	}
+
+	return time.Unix(0, r.Expiration*int64(time.Millisecond)), nil
+}
+
+func (p provider) ParsePush(ctx context.Context, token string, body []byte) (api.PushNotification, error) {
+
+	if len(p.pushTopic) == 0 || len(p.pushToken) == 0 {
+		return api.PushNotification{}, errors.New("Push notifications are not configured")
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(p.pushToken)) != 1 {
+		return api.PushNotification{}, errors.New("Invalid push token")
+	}
+
+	//Pub/Sub push message, wrapping the Gmail notification
+	var push struct {
+		Message struct {
+			Data []byte `json:"data"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(body, &push); err != nil {
+		return api.PushNotification{}, errors.Wrap(err, "Unable to decode Pub/Sub message")
+	}
+
+	var notification struct {
+		EmailAddress string      `json:"emailAddress"`
+		HistoryID    json.Number `json:"historyId"`
+	}
+	if err := json.Unmarshal(push.Message.Data, &notification); err != nil {
+		return api.PushNotification{}, errors.Wrap(err, "Unable to decode Gmail notification")
+	}
+
+	version, err := strconv.ParseUint(notification.HistoryID.String(), 10, 64)
+	if err != nil {
+		return api.PushNotification{}, errors.Wrap(err, "Invalid history ID")
+	}
+
+	return api.PushNotification{
+		AccountID: notification.EmailAddress,
+		Version:   version,
+	}, nil
+}
+
 func (p provider) getService(ctx context.Context, account api.ExternalAccount) (*gmail.Service, error) {
-	client := p.cfg.Client(ctx, account.Token)
+	client := p.cfg.Client(p.oauth2Context(ctx), account.Token)
 
 	srv, err := gmail.New(client)
 	if err != nil {
@@ -84,7 +246,7 @@ func (p provider) GetCurrentEmailAddress(ctx context.Context, account api.Extern
 	}
 	user := "me"
 
-	profile, err := srv.Users.GetProfile(user).Do()
+	profile, err := srv.Users.GetProfile(user).Context(ctx).Do()
 	if err != nil {
 		return "", errors.Wrap(err, "Unable to retrieve profile")
 	}
@@ -99,7 +261,7 @@ func (p provider) GetAvailableCategories(ctx context.Context, account api.Extern
 		return nil, errors.Wrap(err, "Unable to connect to the Gmail service")
 	}
 	user := "me"
-	r, err := srv.Users.Labels.List(user).Do()
+	r, err := srv.Users.Labels.List(user).Context(ctx).Do()
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to retrieve label list")
 	}
@@ -130,8 +292,11 @@ func (p provider) GetItems(ctx context.Context, account api.ExternalAccount, q a
 	if len(q.Category) > 0 {
 		req = req.LabelIds(q.Category)
 	}
+	if len(q.Query) > 0 {
+		req = req.Q(q.Query)
+	}
 
-	r, err := req.Do()
+	r, err := req.Context(ctx).Do()
 	if err != nil {
 		return nil, errors.Wrap(err, "Unable to retrieve threads list")
 	}
@@ -141,7 +306,7 @@ func (p provider) GetItems(ctx context.Context, account api.ExternalAccount, q a
 		ResultSizeEstimate: r.ResultSizeEstimate,
 	}
 
-	fmt.Println("Got ", len(r.Threads), " threads")
+	p.debugf(ctx, "Got %d threads", len(r.Threads))
 
 	for _, thread := range r.Threads {
 
@@ -152,12 +317,13 @@ func (p provider) GetItems(ctx context.Context, account api.ExternalAccount, q a
 		if emailItem.GUID == "" {
 			emailItem, err = p.createEmailItem(ctx, srv, user, account, *thread)
 			if err != nil {
-				fmt.Println("Thread ", *thread)
+				p.debugf(ctx, "Thread %+v", *thread)
 				return nil, errors.Wrap(err, "Unable to create and cache thread "+thread.Id)
 			}
 		}
 
 		if emailItem.GUID != "" {
+			emailItem.Links = p.desc.EmailLinks(emailItem.GUID)
 			res.Items = append(res.Items, emailItem)
 		}
 	}
@@ -176,6 +342,31 @@ func getHeader(msg *gmail.Message, key string) (string, error) {
 	return "", errors.New("Header " + key + " not found on message " + msg.Id)
 }
 
+//appendAttachments walks the parts of a message and appends the ones being attached files
+func appendAttachments(attachments []api.Attachment, part *gmail.MessagePart) []api.Attachment {
+
+	if part == nil {
+		return attachments
+	}
+
+	if len(part.Filename) > 0 {
+		attachment := api.Attachment{
+			Name:        part.Filename,
+			ContentType: part.MimeType,
+		}
+		if part.Body != nil {
+			attachment.Size = part.Body.Size
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	for _, p := range part.Parts {
+		attachments = appendAttachments(attachments, p)
+	}
+
+	return attachments
+}
+
 func (p provider) createEmailItem(ctx context.Context, srv *gmail.Service, user string, account api.ExternalAccount, thread gmail.Thread) (api.EmailItem, error) {
 
 	var res api.EmailItem
@@ -183,7 +374,7 @@ func (p provider) createEmailItem(ctx context.Context, srv *gmail.Service, user
 	res.Link = "https://mail.google.com/mail/#inbox/" + thread.Id
 
 	if len(thread.Messages) == 0 {
-		r, err := srv.Users.Threads.Get(user, thread.Id).Do()
+		r, err := srv.Users.Threads.Get(user, thread.Id).Context(ctx).Do()
 		if err != nil {
 			//TODO:: notify app
 			return api.EmailItem{}, nil
@@ -254,11 +445,66 @@ func (p provider) createEmailItem(ctx context.Context, srv *gmail.Service, user
 		res.From = fmt.Sprintf("%s (%d)", res.From, len(froms))
 	}
 
+	//Attachments
+	for _, m := range thread.Messages {
+		res.Attachments = appendAttachments(res.Attachments, m.Payload)
+	}
+	res.HasAttachments = len(res.Attachments) > 0
+
 	//Saveit with historyId
+	res.Version = thread.HistoryId
 	err = p.r.StoreEmailItem(ctx, account, thread.HistoryId, res)
 	if err != nil {
-		fmt.Println(err)
+		p.debugf(ctx, "Caching thread %s failed: %s", thread.Id, err)
 	}
 
 	return res, nil
 }
+
+func (p provider) modifyLabels(ctx context.Context, account api.ExternalAccount, guid string, add []string, remove []string) error {
+
+	srv, err := p.getService(ctx, account)
+	if err != nil {
+		return errors.Wrap(err, "Unable to connect to the Gmail service")
+	}
+	user := "me"
+
+	_, err = srv.Users.Threads.Modify(user, guid, &gmail.ModifyThreadRequest{
+		AddLabelIds:    add,
+		RemoveLabelIds: remove,
+	}).Context(ctx).Do()
+	if err != nil {
+		return errors.Wrap(err, "Unable to modify thread "+guid)
+	}
+
+	return nil
+}
+
+func (p provider) MarkRead(ctx context.Context, account api.ExternalAccount, guid string, read bool) error {
+
+	if read {
+		return p.modifyLabels(ctx, account, guid, nil, []string{"UNREAD"})
+	}
+	return p.modifyLabels(ctx, account, guid, []string{"UNREAD"}, nil)
+}
+
+func (p provider) Archive(ctx context.Context, account api.ExternalAccount, guid string) error {
+
+	return p.modifyLabels(ctx, account, guid, nil, []string{"INBOX"})
+}
+
+func (p provider) Trash(ctx context.Context, account api.ExternalAccount, guid string) error {
+
+	srv, err := p.getService(ctx, account)
+	if err != nil {
+		return errors.Wrap(err, "Unable to connect to the Gmail service")
+	}
+	user := "me"
+
+	_, err = srv.Users.Threads.Trash(user, guid).Context(ctx).Do()
+	if err != nil {
+		return errors.Wrap(err, "Unable to trash thread "+guid)
+	}
+
+	return nil
+}
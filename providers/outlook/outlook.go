@@ -5,10 +5,14 @@
 package outlook
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -16,12 +20,17 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/metrics"
+	"github.com/oki-apps/okihome/providers/fixtures"
+	"github.com/oki-apps/okihome/tracing"
 )
 
 type provider struct {
-	desc api.ProviderDescription
-	cfg  *oauth2.Config
-	r    api.Repository
+	desc     api.ProviderDescription
+	cfg      *oauth2.Config
+	r        api.Repository
+	fixtures *fixtures.Transport
+	metrics  *metrics.Registry
 }
 
 //Config is the configuration of the app that will access Outlook API
@@ -29,6 +38,13 @@ type Config struct {
 	ClientID     string
 	ClientSecret string
 	RedirectURL  string
+
+	//Fixtures records the calls to Microsoft, or replays them without calling Microsoft.
+	//Microsoft is called if nil.
+	Fixtures *fixtures.Config
+
+	//Metrics records the calls to Microsoft, nothing is recorded if nil
+	Metrics *metrics.Registry `json:"-"`
 }
 
 var description = api.ProviderDescription{
@@ -36,28 +52,50 @@ var description = api.ProviderDescription{
 	Title:             "Outlook.com",
 	Link:              "http://outlook.live.com",
 	AvailableServices: []api.Service{api.ServiceEmail},
+
+	//Outlook has no dedicated reply and forward links, the message is opened instead
+	ComposeLink: "https://outlook.live.com/mail/0/deeplink/compose",
+	ReplyLink:   "https://outlook.live.com/owa/?ItemID={guid}&exvsurl=1&viewmodel=ReadMessageItem",
+	ForwardLink: "https://outlook.live.com/owa/?ItemID={guid}&exvsurl=1&viewmodel=ReadMessageItem",
+}
+
+var scopes = map[api.Feature][]string{
+	api.FeatureRead:   []string{"https://outlook.office.com/mail.read"},
+	api.FeatureModify: []string{"https://outlook.office.com/mail.readwrite"},
 }
 
 //New creates a new email provider that is able to access the Outlook API
-func New(cfg Config, r api.Repository) api.EmailProvider {
+func New(cfg Config, r api.Repository) (api.EmailProvider, error) {
 	p := provider{
 		desc: description,
 		cfg: &oauth2.Config{
 			ClientID:     cfg.ClientID,
 			ClientSecret: cfg.ClientSecret,
-			Scopes: []string{
-				"offline_access",
-				"https://outlook.office.com/mail.read",
-			},
-			RedirectURL: cfg.RedirectURL,
+			Scopes:       append([]string{"offline_access"}, scopes[api.FeatureRead]...),
+			RedirectURL:  cfg.RedirectURL,
 			Endpoint: oauth2.Endpoint{
 				AuthURL:  "https://login.microsoftonline.com/common/oauth2/v2.0/authorize",
 				TokenURL: "https://login.microsoftonline.com/common/oauth2/v2.0/token",
 			},
 		},
-		r: r,
+		r:       r,
+		metrics: cfg.Metrics,
+	}
+
+	if cfg.Fixtures != nil {
+		var err error
+		p.fixtures, err = fixtures.New(*cfg.Fixtures)
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to load Outlook fixtures")
+		}
 	}
-	return p
+
+	return p, nil
+}
+
+//oauth2Context returns the context of the OAuth2 calls to Microsoft, which are traced and recorded
+func (p provider) oauth2Context(ctx context.Context) context.Context {
+	return p.metrics.OAuth2Context(tracing.OAuth2Context(p.fixtures.Context(ctx)), p.desc.Name)
 }
 
 func (p provider) Description() api.ProviderDescription {
@@ -68,10 +106,45 @@ func (p provider) Config() *oauth2.Config {
 	return p.cfg
 }
 
+func (p provider) Scopes(feature api.Feature) []string {
+	return scopes[feature]
+}
+
+func (p provider) Exchange(ctx context.Context, code string) (*oauth2.Token, error) {
+	return p.cfg.Exchange(p.oauth2Context(ctx), code)
+}
+
+func (p provider) Revoke(ctx context.Context, account api.ExternalAccount) error {
+	//The Microsoft identity platform has no endpoint to revoke a single token: the removal of the account
+	//is enough, the user removing the consent in its account settings (https://account.live.com/consent/Manage) if wanted.
+	return nil
+}
+
 func (p provider) get(ctx context.Context, account api.ExternalAccount, url string, jsonData interface{}) error {
-	client := p.cfg.Client(ctx, account.Token)
+	return p.do(ctx, account, "GET", url, nil, jsonData)
+}
+
+func (p provider) do(ctx context.Context, account api.ExternalAccount, method string, url string, reqData interface{}, jsonData interface{}) error {
+	client := p.cfg.Client(p.oauth2Context(ctx), account.Token)
+
+	var reqBody io.Reader
+	if reqData != nil {
+		b, err := json.Marshal(reqData)
+		if err != nil {
+			return errors.Wrap(err, "Unable to encode JSON")
+		}
+		reqBody = bytes.NewReader(b)
+	}
 
-	r, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return errors.Wrap(err, "Unable to create request")
+	}
+	if reqData != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	r, err := client.Do(req)
 	if err != nil {
 		return errors.Wrap(err, "Call to Outlook api failed")
 	}
@@ -82,7 +155,13 @@ func (p provider) get(ctx context.Context, account api.ExternalAccount, url stri
 		return errors.Wrap(err, "Unable to read response body")
 	}
 
-	//TODO check err code
+	if r.StatusCode >= 400 {
+		return errors.Errorf("Outlook api returned %s: %s", r.Status, body)
+	}
+
+	if jsonData == nil {
+		return nil
+	}
 
 	if err = json.Unmarshal(body, jsonData); err != nil {
 		return errors.Wrap(err, "Unable to connect to decode JSON")
@@ -118,7 +197,12 @@ func (p provider) GetItems(ctx context.Context, account api.ExternalAccount, q a
 	}
 
 	url := "https://outlook.office.com/api/v2.0/me/mailfolders/" + q.Category + "/messages?" +
-		"$count=true&$top=30&$select=Subject,Sender,ReceivedDateTime,BodyPreview,IsRead,Weblink"
+		"$count=true&$top=30&$select=Subject,Sender,ReceivedDateTime,BodyPreview,IsRead,Weblink,LastModifiedDateTime,HasAttachments" +
+		"&$expand=Attachments($select=Name,ContentType,Size)"
+
+	if len(q.Query) > 0 {
+		url += "&$search=" + neturl.QueryEscape(`"`+strings.Replace(q.Query, `"`, `\"`, -1)+`"`)
+	}
 
 	if pageToken != nil {
 		url = *pageToken
@@ -128,18 +212,25 @@ func (p provider) GetItems(ctx context.Context, account api.ExternalAccount, q a
 		Count int64  `json:"@odata.count"`
 		Next  string `json:"@odata.nextLink"`
 		Value []struct {
-			ID               string `json:"Id"`
-			ReceivedDateTime time.Time
-			Subject          string
-			BodyPreview      string
-			Sender           struct {
+			ID                   string `json:"Id"`
+			ReceivedDateTime     time.Time
+			LastModifiedDateTime time.Time
+			Subject              string
+			BodyPreview          string
+			Sender               struct {
 				EmailAddress struct {
 					Name    string
 					Address string
 				}
 			}
-			IsRead  bool
-			WebLink string
+			IsRead         bool
+			WebLink        string
+			HasAttachments bool
+			Attachments    []struct {
+				Name        string
+				ContentType string
+				Size        int64
+			}
 		} `json:"value"`
 	}
 
@@ -156,6 +247,15 @@ func (p provider) GetItems(ctx context.Context, account api.ExternalAccount, q a
 
 	for _, item := range responseJSON.Value {
 
+		var attachments []api.Attachment
+		for _, a := range item.Attachments {
+			attachments = append(attachments, api.Attachment{
+				Name:        a.Name,
+				ContentType: a.ContentType,
+				Size:        a.Size,
+			})
+		}
+
 		res.Items = append(res.Items, api.EmailItem{
 			ItemForUser: api.ItemForUser{
 				FeedItem: api.FeedItem{
@@ -168,8 +268,54 @@ func (p provider) GetItems(ctx context.Context, account api.ExternalAccount, q a
 			},
 			From:    item.Sender.EmailAddress.Name,
 			Snippet: item.BodyPreview,
+			Version: uint64(item.LastModifiedDateTime.UnixNano()),
+
+			HasAttachments: item.HasAttachments,
+			Attachments:    attachments,
+
+			Links: p.desc.EmailLinks(item.ID),
 		})
 	}
 
 	return &res, nil
 }
+
+func (p provider) MarkRead(ctx context.Context, account api.ExternalAccount, guid string, read bool) error {
+
+	url := "https://outlook.office.com/api/v2.0/me/messages/" + guid
+
+	reqJSON := struct {
+		IsRead bool
+	}{IsRead: read}
+
+	err := p.do(ctx, account, "PATCH", url, reqJSON, nil)
+	if err != nil {
+		return errors.Wrap(err, "Unable to update message "+guid)
+	}
+
+	return nil
+}
+
+func (p provider) move(ctx context.Context, account api.ExternalAccount, guid string, destinationID string) error {
+
+	url := "https://outlook.office.com/api/v2.0/me/messages/" + guid + "/move"
+
+	reqJSON := struct {
+		DestinationID string `json:"DestinationId"`
+	}{DestinationID: destinationID}
+
+	err := p.do(ctx, account, "POST", url, reqJSON, nil)
+	if err != nil {
+		return errors.Wrap(err, "Unable to move message "+guid)
+	}
+
+	return nil
+}
+
+func (p provider) Archive(ctx context.Context, account api.ExternalAccount, guid string) error {
+	return p.move(ctx, account, guid, "archive")
+}
+
+func (p provider) Trash(ctx context.Context, account api.ExternalAccount, guid string) error {
+	return p.move(ctx, account, guid, "deleteditems")
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//rankingLearningRate is the step of the ranking model updates
+const rankingLearningRate = 0.1
+
+//maxRankingNegatives is the maximum number of skipped items learnt as not read on each read
+const maxRankingNegatives = 20
+
+//minRankingWordLength is the length under which title words are not used as features
+const minRankingWordLength = 4
+
+//rankingFeatures returns the features of an item used by the ranking model:
+//its feed, the domain of its link and the significant words of its title.
+func rankingFeatures(feedID int64, item api.FeedItem) []string {
+
+	features := []string{fmt.Sprintf("feed:%d", feedID)}
+
+	if u, err := url.Parse(item.Link); err == nil && len(u.Host) > 0 {
+		features = append(features, "domain:"+strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."))
+	}
+
+	seen := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(item.Title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for _, w := range words {
+		if len([]rune(w)) < minRankingWordLength || seen[w] {
+			continue
+		}
+		seen[w] = true
+		features = append(features, "word:"+w)
+	}
+
+	return features
+}
+
+//rankItems orders the items by decreasing probability of being read by the user, if the widget has ranking enabled
+func (app App) rankItems(ctx context.Context, userID string, feedID int64, widget api.Widget, items []api.ItemForUser) error {
+
+	cfg, ok := widget.Config.(api.ConfigFeed)
+	if !ok || !cfg.Rank || len(items) < 2 {
+		return nil
+	}
+
+	model, err := app.repository.GetRankingModel(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "retrieving ranking model from datastore failed")
+	}
+
+	scores := make(map[string]float64, len(items))
+	for _, item := range items {
+		scores[item.GUID] = model.Score(rankingFeatures(feedID, item.FeedItem))
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return scores[items[i].GUID] > scores[items[j].GUID]
+	})
+
+	return nil
+}
+
+//trainRanking updates the ranking model of the user with the items just read.
+//The unread items more recent than the read ones are considered as skipped, and learnt as not read.
+func (app App) trainRanking(ctx context.Context, userID string, feedID int64, guids []string) error {
+
+	//The read status of the items, including the ones just read, is retrieved with them
+	feedItems, err := app.repository.GetFeedItemsForUser(ctx, userID, feedID, api.ItemCursor{}, api.MaxPageSize)
+	if err != nil {
+		return errors.Wrap(err, "retrieving feed items from datastore failed")
+	}
+
+	justRead := make(map[string]bool, len(guids))
+	for _, guid := range guids {
+		justRead[guid] = true
+	}
+
+	var read, others []api.FeedItem
+	for _, item := range feedItems {
+		if justRead[item.GUID] {
+			read = append(read, item.FeedItem)
+		} else if !item.Read {
+			others = append(others, item.FeedItem)
+		}
+	}
+	if len(read) == 0 {
+		return nil
+	}
+
+	oldest := read[0].Published
+	for _, item := range read {
+		if item.Published.Before(oldest) {
+			oldest = item.Published
+		}
+	}
+
+	var skipped []api.FeedItem
+	for _, item := range others {
+		if item.Published.After(oldest) {
+			skipped = append(skipped, item)
+		}
+		if len(skipped) == maxRankingNegatives {
+			break
+		}
+	}
+
+	model, err := app.repository.GetRankingModel(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "retrieving ranking model from datastore failed")
+	}
+	model.UserID = userID
+
+	for _, item := range read {
+		model.Train(rankingFeatures(feedID, item), true, rankingLearningRate)
+	}
+	for _, item := range skipped {
+		model.Train(rankingFeatures(feedID, item), false, rankingLearningRate)
+	}
+
+	err = app.repository.StoreRankingModel(ctx, model)
+	if err != nil {
+		return errors.Wrap(err, "saving ranking model in datastore failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//reauthRequired is returned when the token of an account has been revoked or has expired
+type reauthRequired int64
+
+//ReauthAccountID returns the ID of the account to authorize again
+func (err reauthRequired) ReauthAccountID() int64 {
+	return int64(err)
+}
+func (err reauthRequired) Error() string {
+	return fmt.Sprintf("account %d must be authorized again", int64(err))
+}
+
+//isInvalidGrant returns true if the error is due to a refresh token rejected by the provider
+func isInvalidGrant(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *oauth2.RetrieveError:
+			return strings.Contains(string(e.Body), "invalid_grant")
+		case *url.Error:
+			err = e.Err
+		default:
+			cause := errors.Cause(err)
+			if cause == err {
+				return strings.Contains(err.Error(), "invalid_grant")
+			}
+			err = cause
+		}
+	}
+	return false
+}
+
+//checkReauth flags the account as needing a new authorization if the provider call failed with invalid_grant,
+//and returns a reauthRequired error in that case. Other errors are returned unchanged.
+func (app App) checkReauth(ctx context.Context, account api.ExternalAccount, err error) error {
+
+	if !isInvalidGrant(err) {
+		return err
+	}
+
+	app.Error(ctx, errors.Wrap(err, "token of account "+account.Key()+" rejected"))
+
+	if ferr := app.repository.MarkAccountNeedsReauth(ctx, account.ID); ferr != nil {
+		return errors.Wrap(ferr, "flagging account failed")
+	}
+
+	return reauthRequired(account.ID)
+}
+
+//ReauthorizeAccount restarts the OAuth2 flow for an existing account, and returns the AuthCodeURL.
+//The account is updated once authorized again, instead of associating a new one.
+func (app App) ReauthorizeAccount(ctx context.Context, userID string, accountID int64) (string, error) {
+	ctx, span := tracing.Start(ctx, "App.ReauthorizeAccount")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if userID != loggedInUserID {
+		return "", errors.Wrap(notAuthorized("access denied to user: "+userID), "access by "+loggedInUserID)
+	}
+
+	account, err := app.repository.GetAccount(ctx, userID, accountID)
+	if err != nil {
+		return "", errors.Wrap(err, "retrieving account failed")
+	}
+
+	//Check managed policy
+	err = app.checkManagedAccess(ctx, userID, api.ApprovalProvider, account.ProviderName)
+	if err != nil {
+		return "", errors.Wrap(err, "service not allowed")
+	}
+
+	return app.authorizationURL(ctx, userID, account.ProviderName, account.ID, 0, nil)
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//RefreshFeedsWorkers is the number of feeds refreshed at the same time by RefreshDueFeeds,
+//the downloads from a same host being further limited
+const RefreshFeedsWorkers = 8
+
+//SetFeedRefreshIdleAfter pauses the refresh by RefreshDueFeeds of the feeds whose widgets were not viewed
+//since the given duration. A paused feed is still retrieved when displayed, which resumes its refresh.
+//By default, all the due feeds are refreshed.
+func (app *App) SetFeedRefreshIdleAfter(idleAfter time.Duration) {
+	app.feedIdleAfter = idleAfter
+}
+
+//RefreshDueFeeds downloads and stores the feeds due for retrieval, so they are fresh when displayed.
+//It is meant to be run periodically by a scheduler such as cron. A feed failing to be retrieved
+//is logged and does not stop the others.
+func (app App) RefreshDueFeeds(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "App.RefreshDueFeeds")
+	defer span.End()
+
+	active, err := app.activeFeeds(ctx)
+	if err != nil {
+		return errors.Wrap(err, "retrieving active feeds failed")
+	}
+
+	now := time.Now()
+	var mu sync.Mutex
+	var refreshed, failures int
+
+	due := make(chan api.Feed)
+	var workers sync.WaitGroup
+	for i := 0; i < RefreshFeedsWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for feed := range due {
+				err := app.refreshFeed(ctx, feed)
+				if err != nil {
+					app.Error(ctx, errors.Wrap(err, "refresh of feed "+feed.URL+" failed"))
+				}
+				mu.Lock()
+				if err != nil {
+					failures++
+				} else {
+					refreshed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	paused, err := app.queueDueFeeds(ctx, now, active, due)
+	close(due)
+	workers.Wait()
+	if err != nil {
+		return err
+	}
+
+	if paused > 0 {
+		app.Infof(ctx, "Refresh paused for %d idle feeds", paused)
+	}
+	app.Infof(ctx, "%d feed(s) refreshed", refreshed)
+	if failures > 0 {
+		return errors.Errorf("%d feed(s) not refreshed", failures)
+	}
+
+	return nil
+}
+
+//activeFeeds returns the IDs of the feeds with a widget viewed during the idle duration,
+//or nil if all the due feeds are refreshed
+func (app App) activeFeeds(ctx context.Context) (map[int64]bool, error) {
+
+	if app.feedIdleAfter <= 0 {
+		return nil, nil
+	}
+
+	feeds, _, err := app.viewedSources(ctx, app.feedIdleAfter)
+	return feeds, err
+}
+
+//queueDueFeeds sends the feeds due for retrieval at the given time to the workers, except the ones not active
+//if active is not nil, and returns the number of these paused feeds
+func (app App) queueDueFeeds(ctx context.Context, now time.Time, active map[int64]bool, due chan<- api.Feed) (int, error) {
+
+	paused := 0
+	page := api.PageRequest{}
+	for {
+		feeds, next, err := app.repository.GetFeedsPage(ctx, page)
+		if err != nil {
+			return paused, errors.Wrap(err, "retrieving feeds from datastore failed")
+		}
+
+		for _, feed := range feeds {
+			if !now.After(feed.NextRetrieval) {
+				continue
+			}
+			if active != nil && !active[feed.ID] {
+				paused++
+				continue
+			}
+			select {
+			case due <- feed:
+			case <-ctx.Done():
+				return paused, ctx.Err()
+			}
+		}
+
+		if len(next) == 0 {
+			return paused, nil
+		}
+		page.Cursor = next
+	}
+}
+
+//refreshFeed downloads the latest version of the feed and stores it before returning
+func (app App) refreshFeed(ctx context.Context, feed api.Feed) error {
+
+	feed, feedItems, err := app.downloadFeed(ctx, feed)
+	if err != nil {
+		return err
+	}
+
+	return app.storeFeed(ctx, feed, feedItems)
+}
@@ -2,12 +2,28 @@ package datastore
 
 import (
 	"context"
+	"sort"
+	"time"
 
 	"cloud.google.com/go/datastore"
 	"github.com/oki-apps/okihome/api"
 	"github.com/pkg/errors"
 )
 
+//notImplemented is returned by the methods the datastore backend does not support yet
+type notImplemented struct{}
+
+func (notImplemented) Error() string {
+	return "Not implemented"
+}
+
+//IsUnsupported flags the error as a feature missing from the backend, not as a failure
+func (notImplemented) IsUnsupported() bool {
+	return true
+}
+
+var errNotImplemented = notImplemented{}
+
 type repo struct {
 	datastoreClient *datastore.Client
 	tx              *datastore.Transaction
@@ -72,6 +88,18 @@ func New(projectID string) (api.Repository, error) {
 	return r, nil
 }
 
+func (r *repo) RunInTransaction(ctx context.Context, f func(repo api.Repository) error) error {
+	return errNotImplemented
+}
+
+func (r *repo) Close() error {
+	return nil
+}
+
+func (r *repo) Optimize(ctx context.Context) error {
+	return errNotImplemented
+}
+
 func (r *repo) IsNotFound(err error) bool {
 	return err == datastore.ErrNoSuchEntity
 }
@@ -90,6 +118,64 @@ func (r *repo) GetUser(ctx context.Context, userID string) (api.User, error) {
 	return result, err
 }
 
+func (r *repo) GetUsersPage(ctx context.Context, page api.PageRequest) ([]api.User, string, error) {
+
+	q := datastore.NewQuery("User").Order("__key__").Limit(page.Size() + 1)
+	if len(page.Cursor) > 0 {
+		q = q.Filter("__key__ >", userKey(page.Cursor))
+	}
+
+	var users []api.User
+	_, err := r.datastoreClient.GetAll(ctx, q, &users)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Fetching users failed")
+	}
+
+	next := ""
+	if len(users) > page.Size() {
+		users = users[:page.Size()]
+		next = users[len(users)-1].UserID
+	}
+
+	return users, next, nil
+}
+
+//DeleteUser removes the user along with its API tokens, its tags and the audit events about its data
+func (r *repo) DeleteUser(ctx context.Context, userID string) error {
+
+	for _, kind := range []string{apiTokenKind, tagKind, auditEventKind} {
+		q := datastore.NewQuery(kind).Filter("UserID =", userID).KeysOnly()
+		keys, err := r.datastoreClient.GetAll(ctx, q, nil)
+		if err != nil {
+			return errors.Wrap(err, "Fetching "+kind+" keys failed")
+		}
+		err = r.datastoreClient.DeleteMulti(ctx, keys)
+		if err != nil {
+			return errors.Wrap(err, "Deleting "+kind+" entities failed")
+		}
+	}
+
+	return r.Delete(ctx, userKey(userID))
+}
+
+func (r *repo) GetUserStats(ctx context.Context, userID string) (api.UserStats, error) {
+	return api.UserStats{}, errNotImplemented
+}
+func (r *repo) GetFeedStats(ctx context.Context) (api.FeedStats, error) {
+	return api.FeedStats{}, errNotImplemented
+}
+
+func (r *repo) SetUserTimeZone(ctx context.Context, userID string, timeZone string) error {
+	return errNotImplemented
+}
+
+func (r *repo) SetUserLocale(ctx context.Context, userID string, locale string) error {
+	return errNotImplemented
+}
+
+func (r *repo) SetUserAdmin(ctx context.Context, userID string, admin bool) error {
+	return errNotImplemented
+}
 func (r *repo) StoreUser(ctx context.Context, user *api.User) error {
 
 	key := datastore.NameKey("User", user.UserID, nil)
@@ -98,92 +184,396 @@ func (r *repo) StoreUser(ctx context.Context, user *api.User) error {
 }
 
 func (r *repo) GetTabs(ctx context.Context, userID string) ([]api.TabSummary, error) {
-	return nil, errors.New("Not implemented")
+	return nil, errNotImplemented
+}
+func (r *repo) GetOwnedTabIDs(ctx context.Context, userID string) ([]int64, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) GetTabsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.TabSummary, string, error) {
+	return nil, "", errNotImplemented
+}
+func (r *repo) UpdateTabPositions(ctx context.Context, userID string, tabIDs []int64) error {
+	return errNotImplemented
+}
+func (r *repo) GetTabSlug(ctx context.Context, userID string, slug string) (api.TabSlug, error) {
+	return api.TabSlug{}, errNotImplemented
+}
+func (r *repo) GetCurrentTabSlug(ctx context.Context, userID string, tabID int64) (string, error) {
+	return "", errNotImplemented
+}
+func (r *repo) StoreTabSlug(ctx context.Context, slug api.TabSlug) error {
+	return errNotImplemented
 }
 func (r *repo) IsTabAccessAllowed(ctx context.Context, userID string, tabID int64) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
 }
 func (r *repo) AllowTabAccess(ctx context.Context, userID string, tabID int64) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
+}
+func (r *repo) SetDefaultTab(ctx context.Context, userID string, tabID int64) error {
+	return errNotImplemented
 }
 
 func (r *repo) GetTab(ctx context.Context, tabID int64) (api.Tab, error) {
-	return api.Tab{}, errors.New("Not implemented")
+	return api.Tab{}, errNotImplemented
 }
 func (r *repo) StoreTab(ctx context.Context, tab *api.Tab) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
 }
 func (r *repo) DeleteTab(ctx context.Context, tabID int64) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
 }
 
 func (r *repo) GetWidget(ctx context.Context, tabID int64, widgetID int64) (api.Widget, error) {
-	return api.Widget{}, errors.New("Not implemented")
+	return api.Widget{}, errNotImplemented
 }
 func (r *repo) StoreWidget(ctx context.Context, tabID int64, widget *api.Widget) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
 }
 func (r *repo) DeleteWidget(ctx context.Context, tabID int64, widgetID int64) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
 }
 
 func (r *repo) UpdateTabLayout(ctx context.Context, tabID int64, layout [][]int64) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
 }
 
 func (r *repo) DeleteWidgetFromTab(ctx context.Context, tabID int64, widgetID int64) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
 }
 
 func (r *repo) GetOrCreateFeedID(ctx context.Context, URL string) (int64, error) {
-	return 0, errors.New("Not implemented")
+	return 0, errNotImplemented
+}
+
+//GetFeedID returns a not found error, the feeds not being stored in the datastore
+func (r *repo) GetFeedID(ctx context.Context, URL string) (int64, error) {
+	return 0, datastore.ErrNoSuchEntity
 }
 func (r *repo) GetFeed(ctx context.Context, feedID int64) (api.Feed, error) {
-	return api.Feed{}, errors.New("Not implemented")
+	return api.Feed{}, errNotImplemented
+}
+func (r *repo) GetFeedsPage(ctx context.Context, page api.PageRequest) ([]api.Feed, string, error) {
+	return nil, "", errNotImplemented
 }
 func (r *repo) GetFeedItems(ctx context.Context, feedID int64) ([]api.FeedItem, error) {
-	return nil, errors.New("Not implemented")
+	return nil, errNotImplemented
+}
+func (r *repo) GetFeedItemsBefore(ctx context.Context, feedID int64, before api.ItemCursor, limit int) ([]api.FeedItem, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) GetMostReadFeedIDs(ctx context.Context, userID string, limit int) ([]int64, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) GetFeedUserIDs(ctx context.Context, feedID int64) ([]string, error) {
+	return nil, errNotImplemented
 }
 func (r *repo) StoreFeed(ctx context.Context, feed *api.Feed, feedItems []api.FeedItem) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
+}
+func (r *repo) DeleteFeed(ctx context.Context, feedID int64) error {
+	return errNotImplemented
+}
+func (r *repo) DeleteOrphanFeeds(ctx context.Context, retrievedBefore time.Time) (int64, error) {
+	return 0, errNotImplemented
 }
 
-func (r *repo) AreItemsRead(ctx context.Context, userID string, feedID int64, guids []string) ([]bool, error) {
-	return nil, errors.New("Not implemented")
+func (r *repo) GetFeedItemsForUser(ctx context.Context, userID string, feedID int64, before api.ItemCursor, limit int) ([]api.ItemForUser, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) GetReadItems(ctx context.Context, userID string, feedID int64) ([]string, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) GetRankingModel(ctx context.Context, userID string) (api.RankingModel, error) {
+	return api.RankingModel{}, errNotImplemented
+}
+func (r *repo) StoreRankingModel(ctx context.Context, model api.RankingModel) error {
+	return errNotImplemented
+}
+func (r *repo) GetItemAbstracts(ctx context.Context, feedID int64, guids []string) ([]string, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) StoreItemAbstract(ctx context.Context, feedID int64, guid string, abstract string) error {
+	return errNotImplemented
 }
 func (r *repo) SetItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
 }
-func (r *repo) SetItemsRead(ctx context.Context, userID string, feedID int64, guids []string, read bool) error {
-	return errors.New("Not implemented")
+func (r *repo) SetItemsRead(ctx context.Context, userID string, feedID int64, guids []string, read bool) (int64, error) {
+	return 0, errNotImplemented
 }
 
 func (r *repo) GetAccount(ctx context.Context, userID string, accountID int64) (api.ExternalAccount, error) {
-	return api.ExternalAccount{}, errors.New("Not implemented")
+	return api.ExternalAccount{}, errNotImplemented
 }
 func (r *repo) GetAccounts(ctx context.Context, userID string) ([]api.ExternalAccount, error) {
-	return nil, errors.New("Not implemented")
+	return nil, errNotImplemented
+}
+func (r *repo) GetAccountsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.ExternalAccount, string, error) {
+	return nil, "", errNotImplemented
 }
 func (r *repo) DeleteAccount(ctx context.Context, userID string, accountID int64) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
+}
+func (r *repo) UpgradeWidgetConfigs(ctx context.Context, page api.PageRequest) (int, string, error) {
+	return 0, "", errNotImplemented
+}
+func (r *repo) ReencryptTokens(ctx context.Context, page api.PageRequest) (int, string, error) {
+	return 0, "", errNotImplemented
+}
+func (r *repo) MarkAccountNeedsReauth(ctx context.Context, accountID int64) error {
+	return errNotImplemented
 }
 func (r *repo) StoreAccount(ctx context.Context, userID string, account *api.ExternalAccount) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
 }
 
-func (r *repo) GetUserFromTemporaryCode(ctx context.Context, serviceName string, code string) (string, error) {
-	return "", errors.New("Not implemented")
+func (r *repo) GetTemporaryCode(ctx context.Context, serviceName string, code string) (api.TemporaryCode, error) {
+	return api.TemporaryCode{}, errNotImplemented
 }
-func (r *repo) StoreTemporaryCode(ctx context.Context, userID string, serviceName string, code string) error {
-	return errors.New("Not implemented")
+func (r *repo) StoreTemporaryCode(ctx context.Context, code api.TemporaryCode) error {
+	return errNotImplemented
 }
 func (r *repo) DeleteTemporaryCode(ctx context.Context, userID string, serviceName string) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
+}
+func (r *repo) DeleteTemporaryCodesBefore(ctx context.Context, before time.Time) (int64, error) {
+	return 0, errNotImplemented
 }
 
 func (r *repo) GetEmailItem(ctx context.Context, account api.ExternalAccount, guid string, minVersion uint64) (api.EmailItem, error) {
-	return api.EmailItem{}, errors.New("Not implemented")
+	return api.EmailItem{}, errNotImplemented
 }
 func (r *repo) StoreEmailItem(ctx context.Context, account api.ExternalAccount, version uint64, item api.EmailItem) error {
-	return errors.New("Not implemented")
+	return errNotImplemented
+}
+func (r *repo) InvalidateEmailItems(ctx context.Context, providerName string, accountID string, version uint64) error {
+	return errNotImplemented
+}
+func (r *repo) GetEmailSync(ctx context.Context, account api.ExternalAccount) (api.EmailSync, error) {
+	return api.EmailSync{}, errNotImplemented
+}
+func (r *repo) StoreEmailSync(ctx context.Context, sync api.EmailSync) error {
+	return errNotImplemented
+}
+
+func (r *repo) GetManagedPolicy(ctx context.Context, userID string) (api.ManagedPolicy, error) {
+	return api.ManagedPolicy{}, errNotImplemented
+}
+func (r *repo) StoreManagedPolicy(ctx context.Context, policy api.ManagedPolicy) error {
+	return errNotImplemented
+}
+func (r *repo) DeleteManagedPolicy(ctx context.Context, userID string) error {
+	return errNotImplemented
+}
+
+func (r *repo) GetLinkPolicies(ctx context.Context, userID string) ([]api.LinkPolicy, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) StoreLinkPolicies(ctx context.Context, userID string, policies []api.LinkPolicy) error {
+	return errNotImplemented
+}
+
+const apiTokenKind = "APIToken"
+
+func (r *repo) GetAPITokens(ctx context.Context, userID string) ([]api.APIToken, error) {
+
+	var tokens []api.APIToken
+	keys, err := r.datastoreClient.GetAll(ctx, datastore.NewQuery(apiTokenKind).Filter("UserID =", userID), &tokens)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching API tokens failed")
+	}
+	for i, k := range keys {
+		tokens[i].ID = k.ID
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+
+	return tokens, nil
+}
+func (r *repo) GetAPITokenByHash(ctx context.Context, hash string) (api.APIToken, error) {
+
+	var tokens []api.APIToken
+	keys, err := r.datastoreClient.GetAll(ctx, datastore.NewQuery(apiTokenKind).Filter("Hash =", hash).Limit(1), &tokens)
+	if err != nil {
+		return api.APIToken{}, errors.Wrap(err, "Fetching API token failed")
+	}
+	if len(tokens) == 0 {
+		return api.APIToken{}, datastore.ErrNoSuchEntity
+	}
+	tokens[0].ID = keys[0].ID
+
+	return tokens[0], nil
+}
+func (r *repo) StoreAPIToken(ctx context.Context, token *api.APIToken) error {
+
+	key := datastore.IncompleteKey(apiTokenKind, nil)
+	if token.ID != 0 {
+		key = datastore.IDKey(apiTokenKind, token.ID, nil)
+	}
+
+	return r.Put(ctx, key, token, func(k *datastore.Key) {
+		token.ID = k.ID
+	})
+}
+func (r *repo) StoreAPITokenUse(ctx context.Context, tokenID int64, used time.Time) error {
+
+	key := datastore.IDKey(apiTokenKind, tokenID, nil)
+
+	var token api.APIToken
+	err := r.Get(ctx, key, &token)
+	if err != nil {
+		return err
+	}
+	token.LastUsed = used
+
+	return r.Put(ctx, key, &token, nil)
+}
+func (r *repo) DeleteAPIToken(ctx context.Context, userID string, tokenID int64) error {
+
+	key := datastore.IDKey(apiTokenKind, tokenID, nil)
+
+	var token api.APIToken
+	err := r.Get(ctx, key, &token)
+	if err == datastore.ErrNoSuchEntity || (err == nil && token.UserID != userID) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return r.Delete(ctx, key)
+}
+
+func (r *repo) GetRetentionPolicy(ctx context.Context, userID string) (api.RetentionPolicy, error) {
+	return api.RetentionPolicy{}, errNotImplemented
+}
+func (r *repo) StoreRetentionPolicy(ctx context.Context, policy api.RetentionPolicy) error {
+	return errNotImplemented
+}
+func (r *repo) CountReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return 0, errNotImplemented
+}
+func (r *repo) DeleteReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return 0, errNotImplemented
+}
+func (r *repo) CountClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return 0, errNotImplemented
+}
+func (r *repo) DeleteClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return 0, errNotImplemented
+}
+func (r *repo) CountEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return 0, errNotImplemented
+}
+func (r *repo) DeleteEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return 0, errNotImplemented
+}
+
+func (r *repo) GetLastSnapshot(ctx context.Context, userID string) (api.StoredSnapshot, error) {
+	return api.StoredSnapshot{}, errNotImplemented
+}
+func (r *repo) StoreLastSnapshot(ctx context.Context, snapshot api.StoredSnapshot) error {
+	return errNotImplemented
+}
+func (r *repo) GetActivities(ctx context.Context, userID string, limit int) ([]api.Activity, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) StoreActivity(ctx context.Context, activity *api.Activity) error {
+	return errNotImplemented
+}
+func (r *repo) DeleteOldActivities(ctx context.Context, userID string, keep int) (int64, error) {
+	return 0, errNotImplemented
+}
+
+func (r *repo) GetApprovalRequests(ctx context.Context, userID string) ([]api.ApprovalRequest, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) StoreApprovalRequest(ctx context.Context, request *api.ApprovalRequest) error {
+	return errNotImplemented
+}
+
+func (r *repo) GetStarredItems(ctx context.Context, userID string) ([]api.StarredItem, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) StoreStarredItem(ctx context.Context, item api.StarredItem) error {
+	return errNotImplemented
+}
+func (r *repo) DeleteStarredItem(ctx context.Context, userID string, feedID int64, guid string) error {
+	return errNotImplemented
+}
+func (r *repo) GetUserWebhook(ctx context.Context, userID string) (api.UserWebhook, error) {
+	return api.UserWebhook{}, errNotImplemented
+}
+func (r *repo) StoreUserWebhook(ctx context.Context, webhook api.UserWebhook) error {
+	return errNotImplemented
+}
+func (r *repo) DeleteUserWebhook(ctx context.Context, userID string) error {
+	return errNotImplemented
+}
+
+func (r *repo) GetNotificationSettings(ctx context.Context, userID string) (api.NotificationSettings, error) {
+	return api.NotificationSettings{}, errNotImplemented
+}
+func (r *repo) StoreNotificationSettings(ctx context.Context, settings api.NotificationSettings) error {
+	return errNotImplemented
+}
+func (r *repo) DeleteNotificationSettings(ctx context.Context, userID string) error {
+	return errNotImplemented
+}
+
+const tagKind = "Tag"
+
+//tagKey identifies a tag by its user and its name, the names of the tags having no slash
+func tagKey(userID string, name string) *datastore.Key {
+	return datastore.NameKey(tagKind, userID+"/"+name, nil)
+}
+
+func (r *repo) GetTags(ctx context.Context, userID string) ([]api.Tag, error) {
+
+	tags := []api.Tag{}
+	_, err := r.datastoreClient.GetAll(ctx, datastore.NewQuery(tagKind).Filter("UserID =", userID), &tags)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching tags failed")
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+	return tags, nil
+}
+func (r *repo) StoreTag(ctx context.Context, tag api.Tag) error {
+	return r.Put(ctx, tagKey(tag.UserID, tag.Name), &tag, nil)
+}
+func (r *repo) DeleteTag(ctx context.Context, userID string, name string) error {
+	return r.Delete(ctx, tagKey(userID, name))
+}
+
+func (r *repo) GetWidgetViews(ctx context.Context, userID string) ([]api.WidgetView, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) RecordWidgetView(ctx context.Context, view api.WidgetView) error {
+	return errNotImplemented
+}
+func (r *repo) GetViewedWidgets(ctx context.Context, since time.Time) ([]api.Widget, error) {
+	return nil, errNotImplemented
+}
+
+const auditEventKind = "AuditEvent"
+
+func (r *repo) StoreAuditEvent(ctx context.Context, event *api.AuditEvent) error {
+
+	return r.Put(ctx, datastore.IncompleteKey(auditEventKind, nil), event, func(k *datastore.Key) {
+		event.ID = k.ID
+	})
+}
+func (r *repo) GetAuditEventsPage(ctx context.Context, filter api.AuditFilter, page api.PageRequest) ([]api.AuditEvent, string, error) {
+	return nil, "", errNotImplemented
+}
+
+func (r *repo) AddUsage(ctx context.Context, userID string, day time.Time, usage api.Usage) error {
+	return errNotImplemented
+}
+func (r *repo) GetUsage(ctx context.Context, userID string, since time.Time) ([]api.DailyUsage, error) {
+	return nil, errNotImplemented
+}
+func (r *repo) DeleteUsageBefore(ctx context.Context, before time.Time) (int64, error) {
+	return 0, errNotImplemented
 }
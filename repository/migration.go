@@ -0,0 +1,252 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+//A Migration is a versioned change of a SQL schema.
+//The version 0 is the schema created by the setup script of the repository.
+type Migration struct {
+	Version     int
+	Description string
+	Up          string
+	//Down reverts the migration, an empty Down means the migration can not be reverted
+	Down string
+}
+
+//MigrationStatus tells whether a migration is applied
+type MigrationStatus struct {
+	Version     int
+	Description string
+	//Applied is the date the migration was applied (RFC 3339), empty if pending
+	Applied string
+	//Reversible is true if the migration can be reverted
+	Reversible bool
+}
+
+//Migrator manages the migrations of the schema of a database, separately from the repository
+type Migrator struct {
+	db         *sqlx.DB
+	table      string
+	migrations []Migration
+}
+
+//NewMigrator creates a migrator of the given database, the applied versions being recorded in the given table
+func NewMigrator(db *sqlx.DB, table string, migrations []Migration) *Migrator {
+	return &Migrator{
+		db:         db,
+		table:      table,
+		migrations: migrations,
+	}
+}
+
+//Up applies the pending migrations
+func (m *Migrator) Up() error {
+	return Migrate(m.db, m.table, m.migrations)
+}
+
+//Down reverts the given number of migrations, the most recent first.
+//No migration is reverted if one of them can not be.
+func (m *Migrator) Down(steps int) error {
+
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	var reverted []Migration
+	for i := len(statuses) - 1; i >= 0 && len(reverted) < steps; i-- {
+		if len(statuses[i].Applied) == 0 {
+			continue
+		}
+		migration, ok := m.migration(statuses[i].Version)
+		if !ok || len(migration.Down) == 0 {
+			return errors.Errorf("Migration %d (%s) can not be reverted", statuses[i].Version, statuses[i].Description)
+		}
+		reverted = append(reverted, migration)
+	}
+
+	for _, migration := range reverted {
+		if err := revertMigration(m.db, m.table, migration); err != nil {
+			return errors.Wrapf(err, "Reverting migration %d (%s) failed", migration.Version, migration.Description)
+		}
+	}
+
+	return nil
+}
+
+//Status lists the known and applied migrations, in version order
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+
+	if _, err := SchemaVersion(m.db, m.table); err != nil {
+		return nil, err
+	}
+
+	var applied []MigrationStatus
+	err := sqlx.Select(m.db, &applied, fmt.Sprintf("SELECT version, description, applied FROM %s", m.table))
+	if err != nil {
+		return nil, errors.Wrap(err, "Retrieving applied migrations failed")
+	}
+
+	byVersion := make(map[int]MigrationStatus)
+	for _, a := range applied {
+		byVersion[a.Version] = a
+	}
+	for _, migration := range m.migrations {
+		status := byVersion[migration.Version]
+		status.Version = migration.Version
+		status.Description = migration.Description
+		status.Reversible = len(migration.Down) > 0
+		byVersion[migration.Version] = status
+	}
+
+	statuses := make([]MigrationStatus, 0, len(byVersion))
+	for _, s := range byVersion {
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+//Pending returns the number of migrations not applied yet
+func (m *Migrator) Pending() (int, error) {
+
+	current, err := SchemaVersion(m.db, m.table)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, migration := range m.migrations {
+		if migration.Version > current {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
+
+//Close closes the connection to the database
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+func (m *Migrator) migration(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+//Migrate applies the pending migrations, in version order.
+//The applied versions are recorded in the given table, created if needed.
+func Migrate(db *sqlx.DB, table string, migrations []Migration) error {
+
+	current, err := SchemaVersion(db, table)
+	if err != nil {
+		return err
+	}
+
+	pending := make([]Migration, 0, len(migrations))
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Version < pending[j].Version
+	})
+
+	for _, m := range pending {
+		if err := applyMigration(db, table, m); err != nil {
+			return errors.Wrapf(err, "Migration %d (%s) failed", m.Version, m.Description)
+		}
+	}
+
+	return nil
+}
+
+//SchemaVersion returns the version of the last applied migration, 0 if none
+func SchemaVersion(db *sqlx.DB, table string) (int, error) {
+
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+    version integer PRIMARY KEY,
+    description text DEFAULT '' NOT NULL,
+    applied text DEFAULT '' NOT NULL
+)`, table))
+	if err != nil {
+		return 0, errors.Wrap(err, "Creating schema version table failed")
+	}
+
+	var version int
+	err = sqlx.Get(db, &version, fmt.Sprintf("SELECT COALESCE(MAX(version), 0) FROM %s", table))
+	if err != nil {
+		return 0, errors.Wrap(err, "Retrieving schema version failed")
+	}
+
+	return version, nil
+}
+
+func revertMigration(db *sqlx.DB, table string, m Migration) error {
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "Unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.Down); err != nil {
+		return errors.Wrap(err, "Reverting migration failed")
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE version=$1", table), m.Version)
+	if err != nil {
+		return errors.Wrap(err, "Recording migration failed")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "Commit failed")
+	}
+
+	return nil
+}
+
+func applyMigration(db *sqlx.DB, table string, m Migration) error {
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return errors.Wrap(err, "Unable to start transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.Up); err != nil {
+		return errors.Wrap(err, "Applying migration failed")
+	}
+
+	_, err = tx.Exec(
+		fmt.Sprintf("INSERT INTO %s(version, description, applied) VALUES ($1,$2,$3)", table),
+		m.Version, m.Description, time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return errors.Wrap(err, "Recording migration failed")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "Commit failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,385 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package postgresql
+
+import (
+	"github.com/oki-apps/okihome/repository"
+)
+
+//migrations are applied on top of setup.sql
+var migrations = []repository.Migration{
+	{
+		Version:     1,
+		Description: "email item attachments",
+		Up: `ALTER TABLE okihome.t_emailitem ADD COLUMN has_attachments boolean DEFAULT false NOT NULL;
+ALTER TABLE okihome.t_emailitem ADD COLUMN attachments jsonb DEFAULT '[]'::jsonb NOT NULL;`,
+		Down: `ALTER TABLE okihome.t_emailitem DROP COLUMN has_attachments;
+ALTER TABLE okihome.t_emailitem DROP COLUMN attachments;`,
+	},
+	{
+		Version:     2,
+		Description: "link policies",
+		Up: `CREATE TABLE okihome.t_linkpolicy (
+    user_id text NOT NULL,
+    policies jsonb DEFAULT '[]'::jsonb NOT NULL,
+    CONSTRAINT c_pk_linkpolicy PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_linkpolicy_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_linkpolicy;`,
+	},
+	{
+		Version:     3,
+		Description: "temporary code creation date",
+		Up:          `ALTER TABLE okihome.t_temporarycode ADD COLUMN created timestamp with time zone DEFAULT now() NOT NULL;`,
+		Down:        `ALTER TABLE okihome.t_temporarycode DROP COLUMN created;`,
+	},
+	{
+		Version:     4,
+		Description: "item abstracts",
+		Up: `CREATE TABLE okihome.t_itemabstract (
+    feed_id bigint NOT NULL,
+    guid text NOT NULL,
+    abstract text DEFAULT ''::text NOT NULL,
+    CONSTRAINT c_pk_itemabstract PRIMARY KEY (feed_id, guid),
+    CONSTRAINT c_fk_itemabstract_feed FOREIGN KEY (feed_id)
+        REFERENCES okihome.t_feed (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_itemabstract;`,
+	},
+	{
+		Version:     5,
+		Description: "account re-authorization flag",
+		Up:          `ALTER TABLE okihome.t_account ADD COLUMN needs_reauth boolean DEFAULT false NOT NULL;`,
+		Down:        `ALTER TABLE okihome.t_account DROP COLUMN needs_reauth;`,
+	},
+	{
+		Version:     6,
+		Description: "ranking models",
+		Up: `CREATE TABLE okihome.t_rankingmodel (
+    user_id text NOT NULL,
+    bias double precision DEFAULT 0 NOT NULL,
+    weights jsonb DEFAULT '{}'::jsonb NOT NULL,
+    updated timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT c_pk_rankingmodel PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_rankingmodel_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_rankingmodel;`,
+	},
+	{
+		Version:     7,
+		Description: "account labels",
+		Up:          `ALTER TABLE okihome.t_account ADD COLUMN label text DEFAULT ''::text NOT NULL;`,
+		Down:        `ALTER TABLE okihome.t_account DROP COLUMN label;`,
+	},
+	{
+		Version:     8,
+		Description: "API tokens",
+		Up: `CREATE TABLE okihome.t_apitoken (
+    id bigserial NOT NULL,
+    user_id text NOT NULL,
+    name text DEFAULT ''::text NOT NULL,
+    hint text DEFAULT ''::text NOT NULL,
+    hash text NOT NULL,
+    created timestamp with time zone DEFAULT now() NOT NULL,
+    last_used timestamp with time zone,
+    CONSTRAINT c_pk_apitoken PRIMARY KEY (id),
+    CONSTRAINT c_uq_apitoken_hash UNIQUE (hash),
+    CONSTRAINT c_fk_apitoken_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_apitoken;`,
+	},
+	{
+		Version:     9,
+		Description: "tab slugs",
+		Up: `CREATE TABLE okihome.t_tabslug (
+    user_id text NOT NULL,
+    slug text NOT NULL,
+    tab_id bigint NOT NULL,
+    current boolean DEFAULT true NOT NULL,
+    CONSTRAINT c_pk_tabslug PRIMARY KEY (user_id, slug),
+    CONSTRAINT c_fk_tabslug_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    CONSTRAINT c_fk_tabslug_tab FOREIGN KEY (tab_id)
+        REFERENCES okihome.t_tab (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_tabslug;`,
+	},
+	{
+		Version:     10,
+		Description: "retention policies",
+		Up: `CREATE TABLE okihome.t_retention (
+    user_id text NOT NULL,
+    read_items_days integer DEFAULT 0 NOT NULL,
+    email_cache_days integer DEFAULT 0 NOT NULL,
+    CONSTRAINT c_pk_retention PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_retention_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_retention;`,
+	},
+	{
+		Version:     11,
+		Description: "last snapshots",
+		Up: `CREATE TABLE okihome.t_lastsnapshot (
+    user_id text NOT NULL,
+    created timestamp with time zone DEFAULT now() NOT NULL,
+    data jsonb NOT NULL,
+    CONSTRAINT c_pk_lastsnapshot PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_lastsnapshot_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_lastsnapshot;`,
+	},
+	{
+		Version:     12,
+		Description: "activities",
+		Up: `CREATE TABLE okihome.t_activity (
+    id bigserial NOT NULL,
+    user_id text NOT NULL,
+    created timestamp with time zone DEFAULT now() NOT NULL,
+    changes jsonb DEFAULT '[]'::jsonb NOT NULL,
+    CONSTRAINT c_pk_activity PRIMARY KEY (id),
+    CONSTRAINT c_fk_activity_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_activity;`,
+	},
+	{
+		Version:     13,
+		Description: "temporary code widget tab",
+		Up:          `ALTER TABLE okihome.t_temporarycode ADD COLUMN widget_tab_id bigint DEFAULT 0 NOT NULL;`,
+		Down:        `ALTER TABLE okihome.t_temporarycode DROP COLUMN widget_tab_id;`,
+	},
+	{
+		Version:     14,
+		Description: "starred items and user webhooks",
+		Up: `CREATE TABLE okihome.t_starreditem (
+    user_id text NOT NULL,
+    feed_id bigint NOT NULL,
+    guid text NOT NULL,
+    title text DEFAULT '' NOT NULL,
+    link text DEFAULT '' NOT NULL,
+    tags jsonb DEFAULT '[]'::jsonb NOT NULL,
+    starred timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT c_pk_starreditem PRIMARY KEY (user_id, feed_id, guid),
+    CONSTRAINT c_fk_starreditem_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE okihome.t_userwebhook (
+    user_id text NOT NULL,
+    url text NOT NULL,
+    secret text DEFAULT '' NOT NULL,
+    CONSTRAINT c_pk_userwebhook PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_userwebhook_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_starreditem;
+DROP TABLE okihome.t_userwebhook;`,
+	},
+	{
+		Version:     15,
+		Description: "user tags",
+		Up: `CREATE TABLE okihome.t_tag (
+    user_id text NOT NULL,
+    name text NOT NULL,
+    color text DEFAULT '' NOT NULL,
+    CONSTRAINT c_pk_tag PRIMARY KEY (user_id, name),
+    CONSTRAINT c_fk_tag_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_tag;`,
+	},
+	{
+		Version:     16,
+		Description: "widget views",
+		Up: `CREATE TABLE okihome.t_widgetview (
+    user_id text NOT NULL,
+    tab_id bigint NOT NULL,
+    widget_id bigint NOT NULL,
+    renders bigint DEFAULT 0 NOT NULL,
+    last_viewed timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT c_pk_widgetview PRIMARY KEY (user_id, tab_id, widget_id),
+    CONSTRAINT c_fk_widgetview_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+INSERT INTO okihome.t_widgetview(user_id, tab_id, widget_id, renders, last_viewed)
+    SELECT a.user_id, w.tab_id, w.id, 0, now() FROM okihome.t_widget w
+    JOIN okihome.tj_tabaccess a ON a.tab_id=w.tab_id;`,
+		Down: `DROP TABLE okihome.t_widgetview;`,
+	},
+	{
+		Version:     17,
+		Description: "tab positions by user",
+		Up: `ALTER TABLE okihome.tj_tabaccess ADD COLUMN pos integer;
+UPDATE okihome.tj_tabaccess SET pos=(SELECT pos FROM okihome.t_tab WHERE okihome.t_tab.id=okihome.tj_tabaccess.tab_id);`,
+		Down: `ALTER TABLE okihome.tj_tabaccess DROP COLUMN pos;`,
+	},
+	{
+		Version:     18,
+		Description: "default tab",
+		Up:          `ALTER TABLE okihome.tj_tabaccess ADD COLUMN isdefault boolean DEFAULT false NOT NULL;`,
+		Down:        `ALTER TABLE okihome.tj_tabaccess DROP COLUMN isdefault;`,
+	},
+	{
+		Version:     19,
+		Description: "user time zone",
+		Up:          `ALTER TABLE okihome.t_user ADD COLUMN timezone text DEFAULT '' NOT NULL;`,
+		Down:        `ALTER TABLE okihome.t_user DROP COLUMN timezone;`,
+	},
+	{
+		Version:     20,
+		Description: "user locale",
+		Up:          `ALTER TABLE okihome.t_user ADD COLUMN locale text DEFAULT '' NOT NULL;`,
+		Down:        `ALTER TABLE okihome.t_user DROP COLUMN locale;`,
+	},
+	{
+		Version:     21,
+		Description: "notification settings",
+		Up: `CREATE TABLE okihome.t_notification (
+    user_id text NOT NULL,
+    channels jsonb DEFAULT '[]'::jsonb NOT NULL,
+    sources jsonb DEFAULT '[]'::jsonb NOT NULL,
+    quiet_start text DEFAULT '' NOT NULL,
+    quiet_end text DEFAULT '' NOT NULL,
+    CONSTRAINT c_pk_notification PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_notification_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_notification;`,
+	},
+	{
+		Version:     22,
+		Description: "audit log",
+		Up: `CREATE TABLE okihome.t_auditevent (
+    id bigserial NOT NULL,
+    time timestamp with time zone NOT NULL,
+    actor_id text DEFAULT ''::text NOT NULL,
+    user_id text DEFAULT ''::text NOT NULL,
+    action text NOT NULL,
+    target text DEFAULT ''::text NOT NULL,
+    CONSTRAINT c_pk_auditevent PRIMARY KEY (id)
+);`,
+		Down: `DROP TABLE okihome.t_auditevent;`,
+	},
+	{
+		Version:     23,
+		Description: "usage statistics",
+		Up: `CREATE TABLE okihome.t_usage (
+    user_id text NOT NULL,
+    day date NOT NULL,
+    widget_views bigint DEFAULT 0 NOT NULL,
+    items_read bigint DEFAULT 0 NOT NULL,
+    feeds_refreshed bigint DEFAULT 0 NOT NULL,
+    CONSTRAINT c_pk_usage PRIMARY KEY (user_id, day),
+    CONSTRAINT c_fk_usage_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_usage;`,
+	},
+	{
+		Version:     24,
+		Description: "managed policies and approval requests",
+		Up: `CREATE TABLE IF NOT EXISTS okihome.t_managedpolicy (
+    user_id text NOT NULL,
+    manager_id text NOT NULL,
+    allowed_feeds jsonb DEFAULT '[]'::jsonb NOT NULL,
+    allowed_providers jsonb DEFAULT '[]'::jsonb NOT NULL,
+    CONSTRAINT c_pk_managedpolicy PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_managedpolicy_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS okihome.t_approvalrequest (
+    id bigserial NOT NULL,
+    user_id text NOT NULL,
+    kind text NOT NULL,
+    value text NOT NULL,
+    status text DEFAULT 'pending'::text NOT NULL,
+    requested timestamp with time zone DEFAULT now() NOT NULL,
+    CONSTRAINT c_pk_approvalrequest PRIMARY KEY (id),
+    CONSTRAINT c_fk_approvalrequest_user FOREIGN KEY (user_id)
+        REFERENCES okihome.t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_approvalrequest;
+DROP TABLE okihome.t_managedpolicy;`,
+	},
+	{
+		Version:     25,
+		Description: "granted scopes",
+		Up: `ALTER TABLE okihome.t_account ADD COLUMN IF NOT EXISTS scopes jsonb DEFAULT '[]'::jsonb NOT NULL;
+ALTER TABLE okihome.t_temporarycode ADD COLUMN IF NOT EXISTS account_id bigint DEFAULT 0 NOT NULL;`,
+		Down: `ALTER TABLE okihome.t_account DROP COLUMN scopes;
+ALTER TABLE okihome.t_temporarycode DROP COLUMN account_id;`,
+	},
+	{
+		Version:     26,
+		Description: "email synchronization cache",
+		Up: `CREATE TABLE IF NOT EXISTS okihome.t_emailsync (
+    account_id bigint NOT NULL,
+    synced timestamp with time zone DEFAULT now() NOT NULL,
+    guids jsonb DEFAULT '[]'::jsonb NOT NULL,
+    next_page text DEFAULT ''::text NOT NULL,
+    result_size_estimate bigint DEFAULT 0 NOT NULL,
+    CONSTRAINT c_pk_emailsync PRIMARY KEY (account_id),
+    CONSTRAINT c_fk_emailsync_account FOREIGN KEY (account_id)
+        REFERENCES okihome.t_account (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE okihome.t_emailsync;`,
+	},
+	{
+		Version:     27,
+		Description: "feed item images",
+		Up:          `ALTER TABLE okihome.t_feeditem ADD COLUMN image_url text DEFAULT ''::text NOT NULL;`,
+		Down:        `ALTER TABLE okihome.t_feeditem DROP COLUMN image_url;`,
+	},
+	{
+		Version:     28,
+		Description: "click history retention",
+		Up: `ALTER TABLE okihome.t_retention ADD COLUMN click_history_days integer DEFAULT 0 NOT NULL;
+ALTER TABLE okihome.tj_feeditem_user ADD COLUMN read_at timestamp with time zone;`,
+		Down: `ALTER TABLE okihome.tj_feeditem_user DROP COLUMN read_at;
+ALTER TABLE okihome.t_retention DROP COLUMN click_history_days;`,
+	},
+	{
+		Version:     29,
+		Description: "widget feeds",
+		Up: `ALTER TABLE okihome.t_widget ADD COLUMN feed_id bigint;
+UPDATE okihome.t_widget SET feed_id=(config->>'feed_id')::bigint WHERE type='feed';`,
+		Down: `ALTER TABLE okihome.t_widget DROP COLUMN feed_id;`,
+	},
+	{
+		Version:     30,
+		Description: "widget views of the deleted widgets",
+		Up: `DELETE FROM okihome.t_widgetview v WHERE NOT EXISTS (SELECT 1 FROM okihome.t_widget w WHERE w.id=v.widget_id AND w.tab_id=v.tab_id);
+ALTER TABLE okihome.t_widgetview ADD CONSTRAINT c_fk_widgetview_tab FOREIGN KEY (tab_id)
+    REFERENCES okihome.t_tab (id) MATCH SIMPLE
+    ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE okihome.t_widgetview ADD CONSTRAINT c_fk_widgetview_widget FOREIGN KEY (widget_id)
+    REFERENCES okihome.t_widget (id) MATCH SIMPLE
+    ON UPDATE CASCADE ON DELETE CASCADE;`,
+		Down: `ALTER TABLE okihome.t_widgetview DROP CONSTRAINT c_fk_widgetview_widget;
+ALTER TABLE okihome.t_widgetview DROP CONSTRAINT c_fk_widgetview_tab;`,
+	},
+}
@@ -10,6 +10,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -18,12 +19,29 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/repository"
 )
 
 //Config is the configuration to access the PostgreSQL database
 type Config struct {
 	DriverName       string
 	ConnectionString string
+	//TokenKeys are the keys used to encrypt the account tokens, tokens are stored in plain JSON if empty
+	TokenKeys []repository.TokenKey
+	//SkipMigrations prevents the migrations from being applied by New, they are applied with a Migrator.
+	//New fails if migrations are pending.
+	SkipMigrations bool
+}
+
+//NewMigrator creates a migrator of the schema of the PostgreSQL database
+func NewMigrator(cfg Config) (*repository.Migrator, error) {
+
+	db, err := sqlx.Connect(cfg.DriverName, cfg.ConnectionString)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to connect to database")
+	}
+
+	return repository.NewMigrator(db, "okihome.t_schemaversion", migrations), nil
 }
 
 //New creates a new repository that stores data in a PostgreSQL database
@@ -34,22 +52,47 @@ func New(cfg Config) (api.Repository, error) {
 		return nil, errors.Wrap(err, "Unable to connect to database")
 	}
 
+	migrator := repository.NewMigrator(db, "okihome.t_schemaversion", migrations)
+	if cfg.SkipMigrations {
+		pending, err := migrator.Pending()
+		if err != nil {
+			return nil, errors.Wrap(err, "Unable to check database schema")
+		}
+		if pending > 0 {
+			return nil, errors.Errorf("%d database migrations are pending, run okihome-server migrate up", pending)
+		}
+	} else if err := migrator.Up(); err != nil {
+		return nil, errors.Wrap(err, "Unable to migrate database")
+	}
+
+	tokens, err := repository.NewTokenCipher(cfg.TokenKeys)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create token cipher")
+	}
+
 	r := &repo{
-		DB: db,
-		Tx: nil,
+		DB:     db,
+		Tx:     nil,
+		tokens: tokens,
 	}
 	return r, nil
 }
 
 type repo struct {
-	DB *sqlx.DB
-	Tx *sqlx.Tx
+	DB     *sqlx.DB
+	Tx     *sqlx.Tx
+	tokens *repository.TokenCipher
+}
+
+func (r *repo) RunInTransaction(ctx context.Context, f func(repo api.Repository) error) error {
+	return r.runInTransaction(ctx, f)
 }
 
 func (r *repo) runInTransaction(ctx context.Context, f func(repo api.Repository) error) error {
 
+	//Nested transactions are joining the current one
 	if r.Tx != nil {
-		return errors.New("Nested transactions are prohibited")
+		return f(r)
 	}
 
 	tx, err := r.DB.Beginx()
@@ -77,6 +120,26 @@ func (r *repo) runInTransaction(ctx context.Context, f func(repo api.Repository)
 	return nil
 }
 
+//Close closes the database, the transactions cannot be closed
+func (r *repo) Close() error {
+	if r.Tx != nil {
+		return errors.New("Closing a transaction")
+	}
+	return r.DB.Close()
+}
+
+//Optimize reclaims the space of the deleted data and refreshes the statistics of the query planner
+func (r *repo) Optimize(ctx context.Context) error {
+
+	//The space is reclaimed by autovacuum, only the statistics are refreshed
+	_, err := r.Execer().Exec("ANALYZE")
+	if err != nil {
+		return errors.Wrap(err, "ANALYZE failed")
+	}
+
+	return nil
+}
+
 func (r *repo) IsNotFound(err error) bool {
 
 	return errors.Cause(err) == sql.ErrNoRows
@@ -103,7 +166,7 @@ func (r *repo) GetUser(ctx context.Context, userID string) (api.User, error) {
 	var u api.User
 	err := sqlx.Get(
 		r.Queryer(), &u,
-		"SELECT id, display_name, email, isadmin FROM okihome.t_user WHERE id=$1",
+		"SELECT id, display_name, email, isadmin, timezone, locale FROM okihome.t_user WHERE id=$1",
 		userID)
 
 	if err != nil {
@@ -114,6 +177,105 @@ func (r *repo) GetUser(ctx context.Context, userID string) (api.User, error) {
 	return u, nil
 }
 
+func (r *repo) GetUsersPage(ctx context.Context, page api.PageRequest) ([]api.User, string, error) {
+
+	var users []api.User
+	err := sqlx.Select(
+		r.Queryer(), &users,
+		"SELECT id, display_name, email, isadmin, timezone, locale FROM okihome.t_user WHERE id>$1 ORDER BY id LIMIT $2",
+		page.Cursor, page.Size()+1)
+
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Fetching users failed")
+	}
+
+	next := ""
+	if len(users) > page.Size() {
+		users = users[:page.Size()]
+		next = users[len(users)-1].UserID
+	}
+
+	return users, next, nil
+}
+
+func (r *repo) DeleteUser(ctx context.Context, userID string) error {
+
+	//Foreign keys are not relied on, the data are removed explicitly.
+	//The tabs shared with other users are kept for them.
+	ownedTabs := `SELECT tab_id FROM okihome.tj_tabaccess WHERE user_id=$1
+AND tab_id NOT IN (SELECT tab_id FROM okihome.tj_tabaccess WHERE user_id<>$1)`
+	accounts := `SELECT id FROM okihome.t_account WHERE user_id=$1`
+
+	queries := []string{
+		"DELETE FROM okihome.t_widget WHERE tab_id IN (" + ownedTabs + ")",
+		"DELETE FROM okihome.t_tab WHERE id IN (" + ownedTabs + ")",
+		"DELETE FROM okihome.tj_tabaccess WHERE user_id=$1",
+		"DELETE FROM okihome.t_tabslug WHERE user_id=$1",
+		"DELETE FROM okihome.t_emailitem WHERE account_id IN (" + accounts + ")",
+		"DELETE FROM okihome.t_emailsync WHERE account_id IN (" + accounts + ")",
+		"DELETE FROM okihome.t_account WHERE user_id=$1",
+		"DELETE FROM okihome.tj_feeditem_user WHERE user_id=$1",
+		"DELETE FROM okihome.t_temporarycode WHERE user_id=$1",
+		"DELETE FROM okihome.t_managedpolicy WHERE user_id=$1 OR manager_id=$1",
+		"DELETE FROM okihome.t_approvalrequest WHERE user_id=$1",
+		"DELETE FROM okihome.t_linkpolicy WHERE user_id=$1",
+		"DELETE FROM okihome.t_rankingmodel WHERE user_id=$1",
+		"DELETE FROM okihome.t_apitoken WHERE user_id=$1",
+		"DELETE FROM okihome.t_retention WHERE user_id=$1",
+		"DELETE FROM okihome.t_lastsnapshot WHERE user_id=$1",
+		"DELETE FROM okihome.t_activity WHERE user_id=$1",
+		"DELETE FROM okihome.t_starreditem WHERE user_id=$1",
+		"DELETE FROM okihome.t_userwebhook WHERE user_id=$1",
+		"DELETE FROM okihome.t_notification WHERE user_id=$1",
+		"DELETE FROM okihome.t_tag WHERE user_id=$1",
+		"DELETE FROM okihome.t_widgetview WHERE user_id=$1",
+		"DELETE FROM okihome.t_usage WHERE user_id=$1",
+		"DELETE FROM okihome.t_user WHERE id=$1",
+	}
+
+	for _, q := range queries {
+		_, err := r.Execer().Exec(q, userID)
+		if err != nil {
+			return errors.Wrap(err, "Deleting user data failed")
+		}
+	}
+
+	return nil
+}
+
+func (r *repo) GetUserStats(ctx context.Context, userID string) (api.UserStats, error) {
+
+	var stats api.UserStats
+	err := sqlx.Get(
+		r.Queryer(), &stats,
+		`SELECT
+(SELECT count(*) FROM okihome.tj_tabaccess WHERE user_id=$1) as tabs,
+(SELECT count(*) FROM okihome.t_widget JOIN okihome.tj_tabaccess ON t_widget.tab_id = tj_tabaccess.tab_id WHERE tj_tabaccess.user_id=$1) as widgets,
+(SELECT count(*) FROM okihome.t_account WHERE user_id=$1) as accounts`,
+		userID)
+	if err != nil {
+		return api.UserStats{}, errors.Wrap(err, "Computing user statistics failed")
+	}
+
+	return stats, nil
+}
+func (r *repo) GetFeedStats(ctx context.Context) (api.FeedStats, error) {
+
+	var stats api.FeedStats
+	err := sqlx.Get(
+		r.Queryer(), &stats,
+		`SELECT
+(SELECT count(*) FROM okihome.t_feed) as feeds,
+(SELECT count(*) FROM okihome.t_feeditem) as items,
+(SELECT count(DISTINCT user_id) FROM okihome.tj_feeditem_user) as readers,
+(SELECT count(*) FROM okihome.tj_feeditem_user WHERE read) as read_items`)
+	if err != nil {
+		return api.FeedStats{}, errors.Wrap(err, "Computing feed statistics failed")
+	}
+
+	return stats, nil
+}
+
 func (r *repo) StoreUser(ctx context.Context, user *api.User) error {
 
 	_, err := r.Execer().Exec(
@@ -125,6 +287,39 @@ func (r *repo) StoreUser(ctx context.Context, user *api.User) error {
 
 	return nil
 }
+func (r *repo) SetUserTimeZone(ctx context.Context, userID string, timeZone string) error {
+
+	_, err := r.Execer().Exec(
+		"UPDATE okihome.t_user SET timezone=$1 WHERE id=$2",
+		timeZone, userID)
+	if err != nil {
+		return errors.Wrap(err, "Updating user time zone failed")
+	}
+
+	return nil
+}
+func (r *repo) SetUserAdmin(ctx context.Context, userID string, admin bool) error {
+
+	_, err := r.Execer().Exec(
+		"UPDATE okihome.t_user SET isadmin=$1 WHERE id=$2",
+		admin, userID)
+	if err != nil {
+		return errors.Wrap(err, "Updating user administrator flag failed")
+	}
+
+	return nil
+}
+func (r *repo) SetUserLocale(ctx context.Context, userID string, locale string) error {
+
+	_, err := r.Execer().Exec(
+		"UPDATE okihome.t_user SET locale=$1 WHERE id=$2",
+		locale, userID)
+	if err != nil {
+		return errors.Wrap(err, "Updating user locale failed")
+	}
+
+	return nil
+}
 
 func (r *repo) GetTabs(ctx context.Context, userID string) ([]api.TabSummary, error) {
 
@@ -132,10 +327,12 @@ func (r *repo) GetTabs(ctx context.Context, userID string) ([]api.TabSummary, er
 
 	err := sqlx.Select(
 		r.Queryer(), &tabs,
-		`SELECT t_tab.id, t_tab.title 
+		`SELECT t_tab.id, t_tab.title, COALESCE(t_tabslug.slug, '') as slug, tj_tabaccess.isdefault
 FROM okihome.t_tab 
 JOIN okihome.tj_tabaccess ON t_tab.id = tj_tabaccess.tab_id 
-WHERE tj_tabaccess.user_id=$1`,
+LEFT JOIN okihome.t_tabslug ON t_tab.id = t_tabslug.tab_id AND t_tabslug.user_id = tj_tabaccess.user_id AND t_tabslug.current
+WHERE tj_tabaccess.user_id=$1
+ORDER BY COALESCE(tj_tabaccess.pos, 2147483647), t_tab.id`,
 		userID)
 
 	if err != nil {
@@ -144,6 +341,122 @@ WHERE tj_tabaccess.user_id=$1`,
 
 	return tabs, nil
 }
+func (r *repo) GetOwnedTabIDs(ctx context.Context, userID string) ([]int64, error) {
+
+	tabIDs := []int64{}
+	err := sqlx.Select(
+		r.Queryer(), &tabIDs,
+		`SELECT tab_id FROM okihome.tj_tabaccess WHERE user_id=$1
+AND tab_id NOT IN (SELECT tab_id FROM okihome.tj_tabaccess WHERE user_id<>$1)
+ORDER BY tab_id`,
+		userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching owned tabs failed")
+	}
+
+	return tabIDs, nil
+}
+func (r *repo) GetTabsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.TabSummary, string, error) {
+
+	cursor, err := page.IDCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var tabs []api.TabSummary
+
+	err = sqlx.Select(
+		r.Queryer(), &tabs,
+		`SELECT t_tab.id, t_tab.title, COALESCE(t_tabslug.slug, '') as slug
+FROM okihome.t_tab 
+JOIN okihome.tj_tabaccess ON t_tab.id = tj_tabaccess.tab_id 
+LEFT JOIN okihome.t_tabslug ON t_tab.id = t_tabslug.tab_id AND t_tabslug.user_id = tj_tabaccess.user_id AND t_tabslug.current
+WHERE tj_tabaccess.user_id=$1 AND t_tab.id>$2
+ORDER BY t_tab.id LIMIT $3`,
+		userID, cursor, page.Size()+1)
+
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Fetching tabs failed")
+	}
+
+	next := ""
+	if len(tabs) > page.Size() {
+		tabs = tabs[:page.Size()]
+		next = api.NextIDCursor(tabs[len(tabs)-1].ID)
+	}
+
+	return tabs, next, nil
+}
+func (r *repo) UpdateTabPositions(ctx context.Context, userID string, tabIDs []int64) error {
+
+	for pos, tabID := range tabIDs {
+		_, err := r.Execer().Exec(
+			"UPDATE okihome.tj_tabaccess SET pos=$1 WHERE user_id=$2 AND tab_id=$3",
+			pos, userID, tabID)
+		if err != nil {
+			return errors.Wrap(err, "Updating tab position failed")
+		}
+	}
+
+	return nil
+}
+func (r *repo) GetTabSlug(ctx context.Context, userID string, slug string) (api.TabSlug, error) {
+
+	var s api.TabSlug
+	err := sqlx.Get(
+		r.Queryer(), &s,
+		`SELECT user_id, slug, tab_id, current FROM okihome.t_tabslug WHERE user_id=$1 AND slug=$2`,
+		userID, slug)
+	if err != nil {
+		return api.TabSlug{}, errors.Wrap(err, "Retrieving tab slug failed")
+	}
+
+	return s, nil
+}
+func (r *repo) GetCurrentTabSlug(ctx context.Context, userID string, tabID int64) (string, error) {
+
+	var slug string
+	err := sqlx.Get(
+		r.Queryer(), &slug,
+		`SELECT slug FROM okihome.t_tabslug WHERE user_id=$1 AND tab_id=$2 AND current`,
+		userID, tabID)
+	if err != nil {
+		return "", errors.Wrap(err, "Retrieving current tab slug failed")
+	}
+
+	return slug, nil
+}
+func (r *repo) StoreTabSlug(ctx context.Context, slug api.TabSlug) error {
+
+	//The slug is only taken over if it is not the current one of another tab
+	res, err := r.Execer().Exec(
+		`INSERT INTO okihome.t_tabslug(user_id, slug, tab_id, current) VALUES ($1,$2,$3,$4)
+ON CONFLICT (user_id, slug) DO UPDATE SET tab_id=$3, current=$4
+WHERE NOT t_tabslug.current OR t_tabslug.tab_id=$3`,
+		slug.UserID, slug.Slug, slug.TabID, slug.Current)
+	if err != nil {
+		return errors.Wrap(err, "Storing tab slug failed")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "Counting stored tab slugs failed")
+	}
+	if n == 0 {
+		return api.SlugTaken(slug.Slug)
+	}
+
+	if slug.Current {
+		//Only one current slug per tab and user
+		_, err := r.Execer().Exec(
+			"UPDATE okihome.t_tabslug SET current=false WHERE user_id=$1 AND tab_id=$2 AND slug<>$3",
+			slug.UserID, slug.TabID, slug.Slug)
+		if err != nil {
+			return errors.Wrap(err, "Updating former tab slugs failed")
+		}
+	}
+
+	return nil
+}
 func (r *repo) IsTabAccessAllowed(ctx context.Context, userID string, tabID int64) error {
 
 	var count int64
@@ -175,6 +488,17 @@ func (r *repo) AllowTabAccess(ctx context.Context, userID string, tabID int64) e
 
 	return nil
 }
+func (r *repo) SetDefaultTab(ctx context.Context, userID string, tabID int64) error {
+
+	_, err := r.Execer().Exec(
+		"UPDATE okihome.tj_tabaccess SET isdefault=(tab_id=$1) WHERE user_id=$2",
+		tabID, userID)
+	if err != nil {
+		return errors.Wrap(err, "Updating default tab failed")
+	}
+
+	return nil
+}
 
 func (r *repo) GetTab(ctx context.Context, tabID int64) (api.Tab, error) {
 
@@ -283,31 +607,39 @@ func (r *repo) GetWidget(ctx context.Context, tabID int64, widgetID int64) (api.
 		`SELECT id, type, config as cfg FROM okihome.t_widget WHERE id=$1 and tab_id=$2`,
 		widgetID, tabID)
 
-	//Create empty config based on type
-	switch w.Widget.Type {
-	case api.WidgetFeedType:
-		config := api.ConfigFeed{}
-
-		err = json.Unmarshal(w.Cfg, &config)
+	//Create the typed config based on type, upgrading it to the current version
+	var migrated bool
+	w.Widget.Config, migrated, err = api.DecodeWidgetConfig(w.Widget.Type, w.Cfg)
+	if err != nil {
+		return api.Widget{}, err
+	}
+	if migrated {
+		err = r.storeUpgradedConfig(w.ID, w.Cfg, w.Widget.Config)
 		if err != nil {
-			return api.Widget{}, errors.Wrap(err, "Unmarshaling widget config failed")
+			return api.Widget{}, err
 		}
+	}
 
-		w.Widget.Config = config
-
-	case api.WidgetEmailType:
-		config := api.ConfigEmail{}
+	return w.Widget, nil
+}
 
-		err = json.Unmarshal(w.Cfg, &config)
-		if err != nil {
-			return api.Widget{}, errors.Wrap(err, "Unmarshaling widget config failed")
-		}
+//storeUpgradedConfig replaces the stored config of a widget by its upgraded version.
+//The reads may not hold the write lock: the config is only replaced if it did not change since it was read.
+func (r *repo) storeUpgradedConfig(widgetID int64, stored []byte, config interface{}) error {
 
-		w.Widget.Config = config
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling widget config failed")
+	}
 
+	_, err = r.Execer().Exec(
+		"UPDATE okihome.t_widget SET config=$1 WHERE id=$2 AND config::text=$3",
+		configJSON, widgetID, string(stored))
+	if err != nil {
+		return errors.Wrapf(err, "Updating config of widget %d failed", widgetID)
 	}
 
-	return w.Widget, nil
+	return nil
 }
 
 func (r *repo) StoreWidget(ctx context.Context, tabID int64, widget *api.Widget) error {
@@ -316,12 +648,14 @@ func (r *repo) StoreWidget(ctx context.Context, tabID int64, widget *api.Widget)
 	if err != nil {
 		return errors.Wrap(err, "Marshaling widget config failed")
 	}
+	//The displayed feed is stored apart from the config to be queried
+	feedID := sql.NullInt64{Int64: widget.FeedID(), Valid: widget.FeedID() > 0}
 
 	if widget.ID > 0 {
 		//Update
 		_, err := r.Execer().Exec(
-			"UPDATE okihome.t_widget SET type=$1,config=$2 WHERE id=$3 AND tab_id=$4",
-			widget.Type, configJSON, widget.ID, tabID)
+			"UPDATE okihome.t_widget SET type=$1,config=$2,feed_id=$3 WHERE id=$4 AND tab_id=$5",
+			widget.Type, configJSON, feedID, widget.ID, tabID)
 		if err != nil {
 			return errors.Wrap(err, "Updating widget failed")
 		}
@@ -329,8 +663,8 @@ func (r *repo) StoreWidget(ctx context.Context, tabID int64, widget *api.Widget)
 		//Insert
 		err := sqlx.Get(
 			r.Queryer(), &widget.ID,
-			"INSERT INTO okihome.t_widget(type,config,tab_id) VALUES ($1,$2,$3) RETURNING id",
-			widget.Type, configJSON, tabID)
+			"INSERT INTO okihome.t_widget(type,config,feed_id,tab_id) VALUES ($1,$2,$3,$4) RETURNING id",
+			widget.Type, configJSON, feedID, tabID)
 		if err != nil {
 			return errors.Wrap(err, "Inserting widget failed")
 		}
@@ -461,6 +795,20 @@ func (r *repo) GetOrCreateFeedID(ctx context.Context, URL string) (int64, error)
 
 }
 
+func (r *repo) GetFeedID(ctx context.Context, URL string) (int64, error) {
+
+	var feedID int64
+	err := sqlx.Get(
+		r.Queryer(), &feedID,
+		`SELECT id FROM okihome.t_feed WHERE url=$1`,
+		URL)
+	if err != nil {
+		return 0, errors.Wrap(err, "Getting feed failed")
+	}
+
+	return feedID, nil
+}
+
 func (r *repo) GetFeed(ctx context.Context, feedID int64) (api.Feed, error) {
 
 	var feed struct {
@@ -493,6 +841,50 @@ func (r *repo) GetFeed(ctx context.Context, feedID int64) (api.Feed, error) {
 	return f, nil
 }
 
+func (r *repo) GetFeedsPage(ctx context.Context, page api.PageRequest) ([]api.Feed, string, error) {
+
+	cursor, err := page.IDCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var feeds []struct {
+		ID            int64      `db:"id"`
+		URL           string     `db:"url"`
+		NextRetrieval *time.Time `db:"next_retrieval"`
+		Title         *string    `db:"title"`
+	}
+
+	err = sqlx.Select(
+		r.Queryer(), &feeds,
+		`SELECT id, url, next_retrieval, title FROM okihome.t_feed WHERE id>$1 ORDER BY id LIMIT $2`,
+		cursor, page.Size()+1)
+
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Fetching feeds failed")
+	}
+
+	next := ""
+	if len(feeds) > page.Size() {
+		feeds = feeds[:page.Size()]
+		next = api.NextIDCursor(feeds[len(feeds)-1].ID)
+	}
+
+	res := make([]api.Feed, len(feeds))
+	for i, feed := range feeds {
+		res[i].ID = feed.ID
+		res[i].URL = feed.URL
+		if feed.NextRetrieval != nil {
+			res[i].NextRetrieval = *feed.NextRetrieval
+		}
+		if feed.Title != nil {
+			res[i].Title = *feed.Title
+		}
+	}
+
+	return res, next, nil
+}
+
 func (r *repo) GetFeedItems(ctx context.Context, feedID int64) ([]api.FeedItem, error) {
 
 	var items []api.FeedItem
@@ -500,7 +892,7 @@ func (r *repo) GetFeedItems(ctx context.Context, feedID int64) ([]api.FeedItem,
 	//Get the feed
 	err := sqlx.Select(
 		r.Queryer(), &items,
-		`SELECT guid, title, published, link FROM okihome.t_feeditem WHERE feed_id=$1 ORDER BY published DESC`,
+		`SELECT guid, title, published, link, image_url FROM okihome.t_feeditem WHERE feed_id=$1 ORDER BY published DESC`,
 		feedID)
 
 	if err != nil {
@@ -509,6 +901,84 @@ func (r *repo) GetFeedItems(ctx context.Context, feedID int64) ([]api.FeedItem,
 
 	return items, nil
 }
+func (r *repo) GetFeedItemsBefore(ctx context.Context, feedID int64, before api.ItemCursor, limit int) ([]api.FeedItem, error) {
+
+	//The items published at the same time are ordered by GUID, so that none is skipped between two pages
+	items := []api.FeedItem{}
+	err := sqlx.Select(
+		r.Queryer(), &items,
+		`SELECT guid, title, published, link, image_url FROM okihome.t_feeditem
+WHERE feed_id=$1 AND (published, guid)<($2, $3)
+ORDER BY published DESC, guid DESC LIMIT $4`,
+		feedID, before.Published, before.GUID, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "Retrieving feed items failed")
+	}
+
+	return items, nil
+}
+func (r *repo) GetFeedItemsForUser(ctx context.Context, userID string, feedID int64, before api.ItemCursor, limit int) ([]api.ItemForUser, error) {
+
+	//The items never read by the user have no read status
+	query := `SELECT i.guid, i.title, i.published, i.link, i.image_url, COALESCE(u.read, false) AS read
+FROM okihome.t_feeditem i
+LEFT JOIN okihome.tj_feeditem_user u ON u.user_id=$1 AND u.feed_id=i.feed_id AND u.guid=i.guid
+WHERE i.feed_id=$2`
+	args := []interface{}{userID, feedID}
+	//The items published at the same time are ordered by GUID, so that none is skipped between two pages
+	if !before.IsZero() {
+		query += ` AND (i.published, i.guid)<($3, $4) ORDER BY i.published DESC, i.guid DESC LIMIT $5`
+		args = append(args, before.Published, before.GUID, limit)
+	} else {
+		query += ` ORDER BY i.published DESC, i.guid DESC LIMIT $3`
+		args = append(args, limit)
+	}
+
+	items := []api.ItemForUser{}
+	err := sqlx.Select(r.Queryer(), &items, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Retrieving feed items failed")
+	}
+
+	return items, nil
+}
+func (r *repo) GetMostReadFeedIDs(ctx context.Context, userID string, limit int) ([]int64, error) {
+
+	var feedIDs []int64
+	var err error
+	if len(userID) > 0 {
+		err = sqlx.Select(
+			r.Queryer(), &feedIDs,
+			`SELECT feed_id FROM okihome.tj_feeditem_user WHERE user_id=$1 AND read
+GROUP BY feed_id ORDER BY COUNT(*) DESC, feed_id LIMIT $2`,
+			userID, limit)
+	} else {
+		err = sqlx.Select(
+			r.Queryer(), &feedIDs,
+			`SELECT feed_id FROM okihome.tj_feeditem_user WHERE read
+GROUP BY feed_id HAVING COUNT(DISTINCT user_id)>=$1 ORDER BY COUNT(DISTINCT user_id) DESC, feed_id LIMIT $2`,
+			api.PopularFeedMinReaders, limit)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching most read feeds failed")
+	}
+
+	return feedIDs, nil
+}
+func (r *repo) GetFeedUserIDs(ctx context.Context, feedID int64) ([]string, error) {
+
+	var userIDs []string
+	err := sqlx.Select(
+		r.Queryer(), &userIDs,
+		`SELECT DISTINCT a.user_id FROM okihome.t_widget w
+JOIN okihome.tj_tabaccess a ON a.tab_id=w.tab_id WHERE w.feed_id=$1`,
+		feedID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching feed users failed")
+	}
+
+	return userIDs, nil
+}
 func (r *repo) StoreFeed(ctx context.Context, feed *api.Feed, feedItems []api.FeedItem) error {
 
 	if feed.ID > 0 {
@@ -543,8 +1013,8 @@ func (r *repo) StoreFeed(ctx context.Context, feed *api.Feed, feedItems []api.Fe
 	for _, item := range feedItems {
 
 		_, err := r.Execer().Exec(
-			"INSERT INTO okihome.t_feeditem (feed_id, guid, title, published, link) VALUES ($1,$2,$3,$4,$5)",
-			feed.ID, item.GUID, item.Title, item.Published, item.Link)
+			"INSERT INTO okihome.t_feeditem (feed_id, guid, title, published, link, image_url) VALUES ($1,$2,$3,$4,$5,$6)",
+			feed.ID, item.GUID, item.Title, item.Published, item.Link, item.ImageURL)
 		if err != nil {
 			return errors.Wrap(err, "Cleaning existing feed items failed")
 		}
@@ -554,74 +1024,237 @@ func (r *repo) StoreFeed(ctx context.Context, feed *api.Feed, feedItems []api.Fe
 	return nil
 }
 
-func (r *repo) AreItemsRead(ctx context.Context, userID string, feedID int64, guids []string) ([]bool, error) {
+func (r *repo) DeleteFeed(ctx context.Context, feedID int64) error {
 
-	res := make([]bool, len(guids))
+	//Foreign keys are not relied on, the data are removed explicitly
+	queries := []string{
+		"DELETE FROM okihome.t_itemabstract WHERE feed_id=$1",
+		"DELETE FROM okihome.t_starreditem WHERE feed_id=$1",
+		"DELETE FROM okihome.tj_feeditem_user WHERE feed_id=$1",
+		"DELETE FROM okihome.t_feeditem WHERE feed_id=$1",
+		"DELETE FROM okihome.t_feed WHERE id=$1",
+	}
 
-	for i, guid := range guids {
-		read := false
-		err := sqlx.Get(
-			r.Queryer(), &read,
-			"SELECT read FROM okihome.tj_feeditem_user WHERE user_id=$1 AND feed_id=$2 AND guid=$3",
-			userID, feedID, guid)
-		if err != nil && err != sql.ErrNoRows {
-			return nil, errors.Wrap(err, "Getting read status failed")
+	for _, q := range queries {
+		_, err := r.Execer().Exec(q, feedID)
+		if err != nil {
+			return errors.Wrap(err, "Deleting feed failed")
 		}
-
-		res[i] = read
 	}
 
-	return res, nil
+	return nil
 }
-func (r *repo) SetItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) error {
 
-	err := sqlx.Get(
-		r.Queryer(), &read,
-		"SELECT read FROM okihome.tj_feeditem_user WHERE user_id=$1 AND feed_id=$2 AND guid=$3",
-		userID, feedID, guid)
-	if err != nil && err != sql.ErrNoRows {
-		return errors.Wrap(err, "Getting read status failed")
-	}
+func (r *repo) DeleteOrphanFeeds(ctx context.Context, retrievedBefore time.Time) (int64, error) {
 
-	if err == sql.ErrNoRows {
-		_, err := r.Execer().Exec(
-			"INSERT INTO okihome.tj_feeditem_user (user_id, feed_id, guid, read) VALUES ($1,$2,$3,$4)",
-			userID, feedID, guid, read)
-		if err != nil {
-			return errors.Wrap(err, "Inserting read status failed")
-		}
-	} else {
-		_, err := r.Execer().Exec(
-			"UPDATE okihome.tj_feeditem_user SET read=$4 WHERE user_id=$1 AND feed_id=$2 AND guid=$3",
-			userID, feedID, guid, read)
-		if err != nil {
-			return errors.Wrap(err, "Updating read status failed")
-		}
+	//A single statement, so the items are only removed with the feeds passing the guard,
+	//and a feed displayed by a widget added meanwhile is kept
+	var count int64
+	err := sqlx.Get(
+		r.Queryer(), &count,
+		`WITH orphans AS (
+	DELETE FROM okihome.t_feed f WHERE f.next_retrieval<$1
+	AND NOT EXISTS (SELECT 1 FROM okihome.t_widget w WHERE w.feed_id=f.id)
+	AND NOT EXISTS (SELECT 1 FROM okihome.t_starreditem s WHERE s.feed_id=f.id)
+	RETURNING f.id
+), abstracts AS (
+	DELETE FROM okihome.t_itemabstract WHERE feed_id IN (SELECT id FROM orphans)
+), reads AS (
+	DELETE FROM okihome.tj_feeditem_user WHERE feed_id IN (SELECT id FROM orphans)
+), items AS (
+	DELETE FROM okihome.t_feeditem WHERE feed_id IN (SELECT id FROM orphans)
+)
+SELECT count(*) FROM orphans`,
+		retrievedBefore)
+	if err != nil {
+		return 0, errors.Wrap(err, "Deleting orphan feeds failed")
 	}
 
-	return nil
+	return count, nil
 }
 
-func (r *repo) SetItemsRead(ctx context.Context, userID string, feedID int64, guids []string, read bool) error {
+func (r *repo) GetReadItems(ctx context.Context, userID string, feedID int64) ([]string, error) {
 
-	for _, guid := range guids {
-		err := r.SetItemRead(ctx, userID, feedID, guid, read)
-		if err != nil {
-			return err
+	guids := []string{}
+	err := sqlx.Select(
+		r.Queryer(), &guids,
+		"SELECT guid FROM okihome.tj_feeditem_user WHERE user_id=$1 AND feed_id=$2 AND read ORDER BY guid",
+		userID, feedID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Getting read items failed")
+	}
+
+	return guids, nil
+}
+func (r *repo) GetRankingModel(ctx context.Context, userID string) (api.RankingModel, error) {
+
+	var flatModel struct {
+		api.RankingModel
+		Weightsjson []byte `db:"weightsjson"`
+	}
+	err := sqlx.Get(
+		r.Queryer(), &flatModel,
+		"SELECT user_id, bias, weights as weightsjson, updated FROM okihome.t_rankingmodel WHERE user_id=$1",
+		userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return api.RankingModel{UserID: userID, Weights: map[string]float64{}}, nil
 		}
+		return api.RankingModel{}, errors.Wrap(err, "Retrieving ranking model failed")
+	}
+
+	model := flatModel.RankingModel
+	if err := json.Unmarshal(flatModel.Weightsjson, &model.Weights); err != nil {
+		return api.RankingModel{}, errors.Wrap(err, "Unmarshaling ranking weights failed")
+	}
+
+	return model, nil
+}
+func (r *repo) StoreRankingModel(ctx context.Context, model api.RankingModel) error {
+
+	weights := model.Weights
+	if weights == nil {
+		weights = map[string]float64{}
+	}
+	weightsJSON, err := json.Marshal(weights)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling ranking weights failed")
+	}
+
+	_, err = r.Execer().Exec(
+		`INSERT INTO okihome.t_rankingmodel(user_id, bias, weights, updated) VALUES ($1,$2,$3,$4)
+ON CONFLICT (user_id) DO UPDATE SET bias=$2, weights=$3, updated=$4`,
+		model.UserID, model.Bias, weightsJSON, model.Updated)
+	if err != nil {
+		return errors.Wrap(err, "Storing ranking model failed")
+	}
+
+	return nil
+}
+
+func (r *repo) GetItemAbstracts(ctx context.Context, feedID int64, guids []string) ([]string, error) {
+
+	res := make([]string, len(guids))
+	if len(guids) == 0 {
+		return res, nil
+	}
+
+	//The abstracts of all the items are retrieved at once
+	placeholders := make([]string, len(guids))
+	args := []interface{}{feedID}
+	for i, guid := range guids {
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+		args = append(args, guid)
 	}
+
+	var abstracts []struct {
+		GUID     string `db:"guid"`
+		Abstract string `db:"abstract"`
+	}
+	err := sqlx.Select(
+		r.Queryer(), &abstracts,
+		"SELECT guid, abstract FROM okihome.t_itemabstract WHERE feed_id=$1 AND guid IN ("+strings.Join(placeholders, ",")+")",
+		args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Getting item abstracts failed")
+	}
+
+	byGUID := make(map[string]string, len(abstracts))
+	for _, a := range abstracts {
+		byGUID[a.GUID] = a.Abstract
+	}
+	for i, guid := range guids {
+		res[i] = byGUID[guid]
+	}
+
+	return res, nil
+}
+func (r *repo) StoreItemAbstract(ctx context.Context, feedID int64, guid string, abstract string) error {
+
+	_, err := r.Execer().Exec(
+		`INSERT INTO okihome.t_itemabstract(feed_id, guid, abstract) VALUES ($1,$2,$3)
+ON CONFLICT (feed_id, guid) DO UPDATE SET abstract=$3`,
+		feedID, guid, abstract)
+	if err != nil {
+		return errors.Wrap(err, "Storing item abstract failed")
+	}
+
 	return nil
 }
+func (r *repo) SetItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) error {
+	_, err := r.setItemRead(ctx, userID, feedID, guid, read)
+	return err
+}
+
+//setItemRead changes the read status of the item, and returns whether it changed
+func (r *repo) setItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) (bool, error) {
+
+	//The read date is the click history of the user
+	var readAt *time.Time
+	if read {
+		now := time.Now().UTC()
+		readAt = &now
+	}
+
+	var stored bool
+	err := sqlx.Get(
+		r.Queryer(), &stored,
+		"SELECT read FROM okihome.tj_feeditem_user WHERE user_id=$1 AND feed_id=$2 AND guid=$3",
+		userID, feedID, guid)
+	if err != nil && err != sql.ErrNoRows {
+		return false, errors.Wrap(err, "Getting read status failed")
+	}
+
+	if err == sql.ErrNoRows {
+		_, err := r.Execer().Exec(
+			"INSERT INTO okihome.tj_feeditem_user (user_id, feed_id, guid, read, read_at) VALUES ($1,$2,$3,$4,$5)",
+			userID, feedID, guid, read, readAt)
+		if err != nil {
+			return false, errors.Wrap(err, "Inserting read status failed")
+		}
+		//The items without status are unread
+		return read, nil
+	}
+
+	if stored != read {
+		_, err := r.Execer().Exec(
+			"UPDATE okihome.tj_feeditem_user SET read=$4, read_at=$5 WHERE user_id=$1 AND feed_id=$2 AND guid=$3",
+			userID, feedID, guid, read, readAt)
+		if err != nil {
+			return false, errors.Wrap(err, "Updating read status failed")
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+func (r *repo) SetItemsRead(ctx context.Context, userID string, feedID int64, guids []string, read bool) (int64, error) {
+
+	var count int64
+	for _, guid := range guids {
+		changed, err := r.setItemRead(ctx, userID, feedID, guid, read)
+		if err != nil {
+			return count, err
+		}
+		if changed {
+			count++
+		}
+	}
+
+	return count, nil
+}
 
 func (r *repo) GetAccount(ctx context.Context, userID string, accountID int64) (api.ExternalAccount, error) {
 
 	var acc struct {
-		Tokenjson []byte `db:"tokenjson"`
+		Tokenjson  []byte `db:"tokenjson"`
+		Scopesjson []byte `db:"scopesjson"`
 		api.ExternalAccount
 	}
 	err := sqlx.Get(
 		r.Queryer(), &acc,
-		`SELECT t_account.id, t_account.provider, t_account.account_id, t_account.token as tokenjson
+		`SELECT t_account.id, t_account.provider, t_account.account_id, t_account.token as tokenjson, t_account.scopes as scopesjson, t_account.needs_reauth, t_account.label
 FROM okihome.t_account 
 WHERE t_account.id=$1 AND t_account.user_id=$2`,
 		accountID, userID)
@@ -630,10 +1263,13 @@ WHERE t_account.id=$1 AND t_account.user_id=$2`,
 		return api.ExternalAccount{}, errors.Wrap(err, "Retrieving account failed")
 	}
 
-	acc.ExternalAccount.Token = &oauth2.Token{}
-	err = json.Unmarshal(acc.Tokenjson, &acc.ExternalAccount.Token)
+	acc.ExternalAccount.Token, err = r.openToken(acc.ID, acc.Tokenjson)
+	if err != nil {
+		return api.ExternalAccount{}, err
+	}
+	err = json.Unmarshal(acc.Scopesjson, &acc.ExternalAccount.Scopes)
 	if err != nil {
-		return api.ExternalAccount{}, errors.Wrap(err, "Unmarshaling account token failed")
+		return api.ExternalAccount{}, errors.Wrap(err, "Unmarshaling account scopes failed")
 	}
 
 	return acc.ExternalAccount, nil
@@ -641,13 +1277,14 @@ WHERE t_account.id=$1 AND t_account.user_id=$2`,
 func (r *repo) GetAccounts(ctx context.Context, userID string) ([]api.ExternalAccount, error) {
 
 	accounts := []struct {
-		Tokenjson []byte `db:"tokenjson"`
+		Tokenjson  []byte `db:"tokenjson"`
+		Scopesjson []byte `db:"scopesjson"`
 		api.ExternalAccount
 	}{}
 
 	err := sqlx.Select(
 		r.Queryer(), &accounts,
-		`SELECT t_account.id, t_account.provider, t_account.account_id, t_account.token as tokenjson
+		`SELECT t_account.id, t_account.provider, t_account.account_id, t_account.token as tokenjson, t_account.scopes as scopesjson, t_account.needs_reauth, t_account.label
 FROM okihome.t_account 
 WHERE t_account.user_id=$1`,
 		userID)
@@ -659,10 +1296,13 @@ WHERE t_account.user_id=$1`,
 	res := make([]api.ExternalAccount, len(accounts))
 	for i, acc := range accounts {
 
-		acc.ExternalAccount.Token = &oauth2.Token{}
-		err = json.Unmarshal(acc.Tokenjson, &acc.ExternalAccount.Token)
+		acc.ExternalAccount.Token, err = r.openToken(acc.ID, acc.Tokenjson)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal(acc.Scopesjson, &acc.ExternalAccount.Scopes)
 		if err != nil {
-			return nil, errors.Wrap(err, "Unmarshaling account token failed")
+			return nil, errors.Wrap(err, "Unmarshaling account scopes failed")
 		}
 
 		res[i] = acc.ExternalAccount
@@ -670,6 +1310,169 @@ WHERE t_account.user_id=$1`,
 
 	return res, nil
 }
+func (r *repo) GetAccountsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.ExternalAccount, string, error) {
+
+	cursor, err := page.IDCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	accounts := []struct {
+		Tokenjson  []byte `db:"tokenjson"`
+		Scopesjson []byte `db:"scopesjson"`
+		api.ExternalAccount
+	}{}
+
+	err = sqlx.Select(
+		r.Queryer(), &accounts,
+		`SELECT t_account.id, t_account.provider, t_account.account_id, t_account.token as tokenjson, t_account.scopes as scopesjson, t_account.needs_reauth, t_account.label
+FROM okihome.t_account 
+WHERE ($1='' OR t_account.user_id=$1) AND t_account.id>$2
+ORDER BY t_account.id LIMIT $3`,
+		userID, cursor, page.Size()+1)
+
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Fetching accounts failed")
+	}
+
+	next := ""
+	if len(accounts) > page.Size() {
+		accounts = accounts[:page.Size()]
+		next = api.NextIDCursor(accounts[len(accounts)-1].ID)
+	}
+
+	res := make([]api.ExternalAccount, len(accounts))
+	for i, acc := range accounts {
+
+		acc.ExternalAccount.Token, err = r.openToken(acc.ID, acc.Tokenjson)
+		if err != nil {
+			return nil, "", err
+		}
+		err = json.Unmarshal(acc.Scopesjson, &acc.ExternalAccount.Scopes)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "Unmarshaling account scopes failed")
+		}
+
+		res[i] = acc.ExternalAccount
+	}
+
+	return res, next, nil
+}
+
+//openToken decodes a stored token.
+//The tokens encrypted with an older key are not updated here, as reads may not hold the write lock:
+//they are re-encrypted by ReencryptTokens.
+func (r *repo) openToken(accountID int64, stored []byte) (*oauth2.Token, error) {
+
+	token, _, err := r.tokens.Open(stored)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Opening token of account %d failed", accountID)
+	}
+
+	return token, nil
+}
+func (r *repo) sealToken(accountID int64, token *oauth2.Token) error {
+
+	tokenJSON, err := r.tokens.Seal(token)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Execer().Exec(
+		"UPDATE okihome.t_account SET token=$1 WHERE id=$2",
+		tokenJSON, accountID)
+	if err != nil {
+		return errors.Wrap(err, "Updating account token failed")
+	}
+
+	return nil
+}
+func (r *repo) ReencryptTokens(ctx context.Context, page api.PageRequest) (int, string, error) {
+
+	cursor, err := page.IDCursor()
+	if err != nil {
+		return 0, "", err
+	}
+
+	var accounts []struct {
+		ID    int64  `db:"id"`
+		Token []byte `db:"token"`
+	}
+	err = sqlx.Select(
+		r.Queryer(), &accounts,
+		"SELECT id, token FROM okihome.t_account WHERE id>$1 ORDER BY id LIMIT $2",
+		cursor, page.Size()+1)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "Fetching account tokens failed")
+	}
+
+	next := ""
+	if len(accounts) > page.Size() {
+		accounts = accounts[:page.Size()]
+		next = api.NextIDCursor(accounts[len(accounts)-1].ID)
+	}
+
+	count := 0
+	for _, acc := range accounts {
+		token, outdated, err := r.tokens.Open(acc.Token)
+		if err != nil {
+			return count, "", errors.Wrapf(err, "Opening token of account %d failed", acc.ID)
+		}
+		if !outdated {
+			continue
+		}
+		if err := r.sealToken(acc.ID, token); err != nil {
+			return count, "", errors.Wrapf(err, "Re-encrypting token of account %d failed", acc.ID)
+		}
+		count++
+	}
+
+	return count, next, nil
+}
+func (r *repo) UpgradeWidgetConfigs(ctx context.Context, page api.PageRequest) (int, string, error) {
+
+	cursor, err := page.IDCursor()
+	if err != nil {
+		return 0, "", err
+	}
+
+	var widgets []struct {
+		ID   int64  `db:"id"`
+		Type string `db:"type"`
+		Cfg  []byte `db:"cfg"`
+	}
+	err = sqlx.Select(
+		r.Queryer(), &widgets,
+		"SELECT id, type, config as cfg FROM okihome.t_widget WHERE id>$1 ORDER BY id LIMIT $2",
+		cursor, page.Size()+1)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "Fetching widget configs failed")
+	}
+
+	next := ""
+	if len(widgets) > page.Size() {
+		widgets = widgets[:page.Size()]
+		next = api.NextIDCursor(widgets[len(widgets)-1].ID)
+	}
+
+	count := 0
+	for _, w := range widgets {
+		config, migrated, err := api.DecodeWidgetConfig(w.Type, w.Cfg)
+		if err != nil {
+			return count, "", errors.Wrapf(err, "Upgrading config of widget %d failed", w.ID)
+		}
+		if !migrated {
+			continue
+		}
+		err = r.storeUpgradedConfig(w.ID, w.Cfg, config)
+		if err != nil {
+			return count, "", err
+		}
+		count++
+	}
+
+	return count, next, nil
+}
 func (r *repo) DeleteAccount(ctx context.Context, userID string, accountID int64) error {
 
 	_, err := r.Execer().Exec(
@@ -683,18 +1486,37 @@ func (r *repo) DeleteAccount(ctx context.Context, userID string, accountID int64
 
 }
 
+func (r *repo) MarkAccountNeedsReauth(ctx context.Context, accountID int64) error {
+
+	_, err := r.Execer().Exec(
+		"UPDATE okihome.t_account SET needs_reauth=$1 WHERE id=$2",
+		true, accountID)
+	if err != nil {
+		return errors.Wrap(err, "Flagging account failed")
+	}
+
+	return nil
+}
 func (r *repo) StoreAccount(ctx context.Context, userID string, account *api.ExternalAccount) error {
 
-	tokenJSON, err := json.Marshal(account.Token)
+	tokenJSON, err := r.tokens.Seal(account.Token)
+	if err != nil {
+		return err
+	}
+	scopes := account.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	scopesJSON, err := json.Marshal(scopes)
 	if err != nil {
-		return errors.Wrap(err, "Marshaling account token failed")
+		return errors.Wrap(err, "Marshaling account scopes failed")
 	}
 
 	if account.ID > 0 {
 		//Update
 		_, err := r.Execer().Exec(
-			"UPDATE okihome.t_account SET provider=$1, account_id=$2, token=$3 WHERE id=$4 AND user_id=$5",
-			account.ProviderName, account.AccountID, tokenJSON, account.ID, userID)
+			"UPDATE okihome.t_account SET provider=$1, account_id=$2, token=$3, scopes=$4, needs_reauth=$5, label=$6 WHERE id=$7 AND user_id=$8",
+			account.ProviderName, account.AccountID, tokenJSON, scopesJSON, account.NeedsReauth, account.Label, account.ID, userID)
 		if err != nil {
 			return errors.Wrap(err, "Updating account failed")
 		}
@@ -703,8 +1525,8 @@ func (r *repo) StoreAccount(ctx context.Context, userID string, account *api.Ext
 		//Insert
 		err := sqlx.Get(
 			r.Queryer(), &account.ID,
-			"INSERT INTO okihome.t_account(provider, account_id, token, user_id) VALUES ($1,$2,$3,$4) RETURNING id",
-			account.ProviderName, account.AccountID, tokenJSON, userID)
+			"INSERT INTO okihome.t_account(provider, account_id, token, scopes, needs_reauth, label, user_id) VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING id",
+			account.ProviderName, account.AccountID, tokenJSON, scopesJSON, account.NeedsReauth, account.Label, userID)
 		if err != nil {
 			return errors.Wrap(err, "Inserting account failed")
 		}
@@ -713,25 +1535,25 @@ func (r *repo) StoreAccount(ctx context.Context, userID string, account *api.Ext
 	return nil
 }
 
-func (r *repo) GetUserFromTemporaryCode(ctx context.Context, serviceName string, code string) (string, error) {
+func (r *repo) GetTemporaryCode(ctx context.Context, serviceName string, code string) (api.TemporaryCode, error) {
 
-	var userID string
+	var tc api.TemporaryCode
 	err := sqlx.Get(
-		r.Queryer(), &userID,
-		"SELECT user_id FROM okihome.t_temporarycode WHERE provider=$1 AND code=$2",
+		r.Queryer(), &tc,
+		"SELECT code, user_id, provider, account_id, widget_tab_id, created FROM okihome.t_temporarycode WHERE provider=$1 AND code=$2",
 		serviceName, code)
 
 	if err != nil {
-		return "", errors.Wrap(err, "Retrieving user failed")
+		return api.TemporaryCode{}, errors.Wrap(err, "Retrieving temporary code failed")
 	}
 
-	return userID, nil
+	return tc, nil
 }
-func (r *repo) StoreTemporaryCode(ctx context.Context, userID string, serviceName string, code string) error {
+func (r *repo) StoreTemporaryCode(ctx context.Context, code api.TemporaryCode) error {
 
 	_, err := r.Execer().Exec(
-		"INSERT INTO okihome.t_temporarycode(user_id, provider, code) VALUES ($1,$2,$3)",
-		userID, serviceName, code)
+		"INSERT INTO okihome.t_temporarycode(user_id, provider, code, account_id, widget_tab_id, created) VALUES ($1,$2,$3,$4,$5,$6)",
+		code.UserID, code.ProviderName, code.Code, code.AccountID, code.WidgetTabID, code.Created)
 
 	if err != nil {
 		return errors.Wrap(err, "Storing temporary code failed")
@@ -751,13 +1573,32 @@ func (r *repo) DeleteTemporaryCode(ctx context.Context, userID string, serviceNa
 
 	return nil
 }
+func (r *repo) DeleteTemporaryCodesBefore(ctx context.Context, before time.Time) (int64, error) {
+
+	res, err := r.Execer().Exec(
+		"DELETE FROM okihome.t_temporarycode WHERE created<$1",
+		before)
+	if err != nil {
+		return 0, errors.Wrap(err, "Deleting temporary codes failed")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "Counting deleted temporary codes failed")
+	}
+
+	return count, nil
+}
 
 func (r *repo) GetEmailItem(ctx context.Context, account api.ExternalAccount, guid string, minVersion uint64) (api.EmailItem, error) {
 
-	var emailItem api.EmailItem
+	var emailItem struct {
+		Attachmentsjson []byte `db:"attachmentsjson"`
+		api.EmailItem
+	}
 	err := sqlx.Get(
 		r.Queryer(), &emailItem,
-		`SELECT guid, title, published, link, sender, snippet, read
+		`SELECT guid, title, published, link, sender, snippet, read, version, has_attachments, attachments as attachmentsjson
 FROM okihome.t_emailitem WHERE account_id=$1 AND guid=$2 AND version>=$3`,
 		account.ID, guid, minVersion)
 
@@ -769,12 +1610,26 @@ FROM okihome.t_emailitem WHERE account_id=$1 AND guid=$2 AND version>=$3`,
 		return api.EmailItem{}, errors.Wrap(err, "Retrieving item failed")
 	}
 
-	return emailItem, nil
+	err = json.Unmarshal(emailItem.Attachmentsjson, &emailItem.EmailItem.Attachments)
+	if err != nil {
+		return api.EmailItem{}, errors.Wrap(err, "Unmarshaling item attachments failed")
+	}
+
+	return emailItem.EmailItem, nil
 }
 func (r *repo) StoreEmailItem(ctx context.Context, account api.ExternalAccount, version uint64, item api.EmailItem) error {
 
+	attachments := item.Attachments
+	if attachments == nil {
+		attachments = []api.Attachment{}
+	}
+	attachmentsJSON, err := json.Marshal(attachments)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling item attachments failed")
+	}
+
 	var currentVersion uint64
-	err := sqlx.Get(
+	err = sqlx.Get(
 		r.Queryer(), &currentVersion,
 		`SELECT version
 FROM okihome.t_emailitem WHERE account_id=$1 AND guid=$2`,
@@ -787,9 +1642,9 @@ FROM okihome.t_emailitem WHERE account_id=$1 AND guid=$2`,
 
 		_, err := r.Execer().Exec(
 			`INSERT INTO okihome.t_emailitem(account_id, guid, title, published, link, 
-sender, snippet, read, version) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+sender, snippet, read, version, has_attachments, attachments) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)`,
 			account.ID, item.GUID, item.Title, item.Published, item.Link,
-			item.From, item.Snippet, item.Read, version)
+			item.From, item.Snippet, item.Read, version, item.HasAttachments, attachmentsJSON)
 
 		if err != nil {
 			return errors.Wrap(err, "Storing email item failed")
@@ -799,10 +1654,10 @@ sender, snippet, read, version) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
 
 		_, err := r.Execer().Exec(
 			`UPDATE okihome.t_emailitem SET title=$3, published=$4, link=$5, 
-sender=$6, snippet=$7, read=$8, version=$9
+sender=$6, snippet=$7, read=$8, version=$9, has_attachments=$10, attachments=$11
 WHERE account_id=$1 AND guid=$2`,
 			account.ID, item.GUID, item.Title, item.Published, item.Link,
-			item.From, item.Snippet, item.Read, version)
+			item.From, item.Snippet, item.Read, version, item.HasAttachments, attachmentsJSON)
 
 		if err != nil {
 			return errors.Wrap(err, "Updating email item failed")
@@ -812,3 +1667,884 @@ WHERE account_id=$1 AND guid=$2`,
 
 	return nil
 }
+func (r *repo) InvalidateEmailItems(ctx context.Context, providerName string, accountID string, version uint64) error {
+
+	_, err := r.Execer().Exec(
+		`UPDATE okihome.t_emailitem SET version=0
+WHERE version<$3 AND account_id IN (SELECT id FROM okihome.t_account WHERE provider=$1 AND account_id=$2)`,
+		providerName, accountID, version)
+
+	if err != nil {
+		return errors.Wrap(err, "Invalidating email items failed")
+	}
+
+	return nil
+}
+
+func (r *repo) GetEmailSync(ctx context.Context, account api.ExternalAccount) (api.EmailSync, error) {
+
+	var sync struct {
+		GUIDs []byte `db:"guids"`
+		api.EmailSync
+	}
+	err := sqlx.Get(
+		r.Queryer(), &sync,
+		`SELECT account_id, synced, guids, next_page, result_size_estimate
+FROM okihome.t_emailsync WHERE account_id=$1`,
+		account.ID)
+	if err != nil {
+		return api.EmailSync{}, errors.Wrap(err, "Retrieving email sync failed")
+	}
+
+	err = json.Unmarshal(sync.GUIDs, &sync.EmailSync.GUIDs)
+	if err != nil {
+		return api.EmailSync{}, errors.Wrap(err, "Unmarshaling email sync guids failed")
+	}
+
+	return sync.EmailSync, nil
+}
+func (r *repo) StoreEmailSync(ctx context.Context, sync api.EmailSync) error {
+
+	guidsJSON, err := json.Marshal(sync.GUIDs)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling email sync guids failed")
+	}
+
+	_, err = r.Execer().Exec(
+		`INSERT INTO okihome.t_emailsync(account_id, synced, guids, next_page, result_size_estimate) VALUES ($1,$2,$3,$4,$5)
+ON CONFLICT (account_id) DO UPDATE SET synced=$2, guids=$3, next_page=$4, result_size_estimate=$5`,
+		sync.AccountID, sync.Synced, guidsJSON, sync.NextPageToken, sync.ResultSizeEstimate)
+	if err != nil {
+		return errors.Wrap(err, "Storing email sync failed")
+	}
+
+	return nil
+}
+
+func (r *repo) GetManagedPolicy(ctx context.Context, userID string) (api.ManagedPolicy, error) {
+
+	var p struct {
+		UserID           string `db:"user_id"`
+		ManagerID        string `db:"manager_id"`
+		AllowedFeeds     []byte `db:"allowed_feeds"`
+		AllowedProviders []byte `db:"allowed_providers"`
+	}
+	err := sqlx.Get(
+		r.Queryer(), &p,
+		`SELECT user_id, manager_id, allowed_feeds, allowed_providers FROM okihome.t_managedpolicy WHERE user_id=$1`,
+		userID)
+
+	if err != nil {
+		return api.ManagedPolicy{}, errors.Wrap(err, "Retrieving managed policy failed")
+	}
+
+	policy := api.ManagedPolicy{
+		UserID:    p.UserID,
+		ManagerID: p.ManagerID,
+	}
+	if err := json.Unmarshal(p.AllowedFeeds, &policy.AllowedFeeds); err != nil {
+		return api.ManagedPolicy{}, errors.Wrap(err, "Unmarshaling allowed feeds failed")
+	}
+	if err := json.Unmarshal(p.AllowedProviders, &policy.AllowedProviders); err != nil {
+		return api.ManagedPolicy{}, errors.Wrap(err, "Unmarshaling allowed providers failed")
+	}
+
+	return policy, nil
+}
+func (r *repo) StoreManagedPolicy(ctx context.Context, policy api.ManagedPolicy) error {
+
+	if policy.AllowedFeeds == nil {
+		policy.AllowedFeeds = []string{}
+	}
+	if policy.AllowedProviders == nil {
+		policy.AllowedProviders = []string{}
+	}
+	feedsJSON, err := json.Marshal(policy.AllowedFeeds)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling allowed feeds failed")
+	}
+	providersJSON, err := json.Marshal(policy.AllowedProviders)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling allowed providers failed")
+	}
+
+	_, err = r.Execer().Exec(
+		`INSERT INTO okihome.t_managedpolicy(user_id, manager_id, allowed_feeds, allowed_providers) VALUES ($1,$2,$3,$4)
+ON CONFLICT (user_id) DO UPDATE SET manager_id=$2, allowed_feeds=$3, allowed_providers=$4`,
+		policy.UserID, policy.ManagerID, feedsJSON, providersJSON)
+	if err != nil {
+		return errors.Wrap(err, "Storing managed policy failed")
+	}
+
+	return nil
+}
+func (r *repo) DeleteManagedPolicy(ctx context.Context, userID string) error {
+
+	_, err := r.Execer().Exec(
+		"DELETE FROM okihome.t_managedpolicy WHERE user_id=$1",
+		userID)
+	if err != nil {
+		return errors.Wrap(err, "Removing managed policy failed")
+	}
+
+	return nil
+}
+
+func (r *repo) GetLinkPolicies(ctx context.Context, userID string) ([]api.LinkPolicy, error) {
+
+	var policiesJSON []byte
+	err := sqlx.Get(
+		r.Queryer(), &policiesJSON,
+		`SELECT policies FROM okihome.t_linkpolicy WHERE user_id=$1`,
+		userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return []api.LinkPolicy{}, nil
+		}
+		return nil, errors.Wrap(err, "Retrieving link policies failed")
+	}
+
+	policies := []api.LinkPolicy{}
+	if err := json.Unmarshal(policiesJSON, &policies); err != nil {
+		return nil, errors.Wrap(err, "Unmarshaling link policies failed")
+	}
+
+	return policies, nil
+}
+func (r *repo) StoreLinkPolicies(ctx context.Context, userID string, policies []api.LinkPolicy) error {
+
+	if policies == nil {
+		policies = []api.LinkPolicy{}
+	}
+	policiesJSON, err := json.Marshal(policies)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling link policies failed")
+	}
+
+	_, err = r.Execer().Exec(
+		`INSERT INTO okihome.t_linkpolicy(user_id, policies) VALUES ($1,$2)
+ON CONFLICT (user_id) DO UPDATE SET policies=$2`,
+		userID, policiesJSON)
+	if err != nil {
+		return errors.Wrap(err, "Storing link policies failed")
+	}
+
+	return nil
+}
+
+type apiToken struct {
+	api.APIToken
+	LastUsed *time.Time `db:"last_used"`
+}
+
+func (r *repo) GetAPITokens(ctx context.Context, userID string) ([]api.APIToken, error) {
+
+	var tokens []apiToken
+	err := sqlx.Select(
+		r.Queryer(), &tokens,
+		`SELECT id, user_id, name, hint, hash, created, last_used FROM okihome.t_apitoken WHERE user_id=$1 ORDER BY id`,
+		userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching API tokens failed")
+	}
+
+	res := make([]api.APIToken, len(tokens))
+	for i, t := range tokens {
+		res[i] = t.APIToken
+		if t.LastUsed != nil {
+			res[i].LastUsed = *t.LastUsed
+		}
+	}
+
+	return res, nil
+}
+func (r *repo) GetAPITokenByHash(ctx context.Context, hash string) (api.APIToken, error) {
+
+	var token apiToken
+	err := sqlx.Get(
+		r.Queryer(), &token,
+		`SELECT id, user_id, name, hint, hash, created, last_used FROM okihome.t_apitoken WHERE hash=$1`,
+		hash)
+	if err != nil {
+		return api.APIToken{}, errors.Wrap(err, "Retrieving API token failed")
+	}
+
+	res := token.APIToken
+	if token.LastUsed != nil {
+		res.LastUsed = *token.LastUsed
+	}
+
+	return res, nil
+}
+func (r *repo) StoreAPIToken(ctx context.Context, token *api.APIToken) error {
+
+	err := sqlx.Get(
+		r.Queryer(), &token.ID,
+		"INSERT INTO okihome.t_apitoken(user_id, name, hint, hash, created) VALUES ($1,$2,$3,$4,$5) RETURNING id",
+		token.UserID, token.Name, token.Hint, token.Hash, token.Created)
+	if err != nil {
+		return errors.Wrap(err, "Inserting API token failed")
+	}
+
+	return nil
+}
+func (r *repo) StoreAPITokenUse(ctx context.Context, tokenID int64, used time.Time) error {
+
+	_, err := r.Execer().Exec(
+		"UPDATE okihome.t_apitoken SET last_used=$1 WHERE id=$2",
+		used, tokenID)
+	if err != nil {
+		return errors.Wrap(err, "Updating API token failed")
+	}
+
+	return nil
+}
+func (r *repo) DeleteAPIToken(ctx context.Context, userID string, tokenID int64) error {
+
+	_, err := r.Execer().Exec(
+		"DELETE FROM okihome.t_apitoken WHERE id=$1 AND user_id=$2",
+		tokenID, userID)
+	if err != nil {
+		return errors.Wrap(err, "Deleting API token failed")
+	}
+
+	return nil
+}
+
+func (r *repo) GetRetentionPolicy(ctx context.Context, userID string) (api.RetentionPolicy, error) {
+
+	var policy api.RetentionPolicy
+	err := sqlx.Get(
+		r.Queryer(), &policy,
+		`SELECT user_id, read_items_days, email_cache_days, click_history_days FROM okihome.t_retention WHERE user_id=$1`,
+		userID)
+	if err != nil {
+		return api.RetentionPolicy{}, errors.Wrap(err, "Retrieving retention policy failed")
+	}
+
+	return policy, nil
+}
+func (r *repo) StoreRetentionPolicy(ctx context.Context, policy api.RetentionPolicy) error {
+
+	_, err := r.Execer().Exec(
+		`INSERT INTO okihome.t_retention(user_id, read_items_days, email_cache_days, click_history_days) VALUES ($1,$2,$3,$4)
+ON CONFLICT (user_id) DO UPDATE SET read_items_days=$2, email_cache_days=$3, click_history_days=$4`,
+		policy.UserID, policy.ReadItemsDays, policy.EmailCacheDays, policy.ClickHistoryDays)
+	if err != nil {
+		return errors.Wrap(err, "Storing retention policy failed")
+	}
+
+	return nil
+}
+func (r *repo) CountReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+
+	var count int64
+	err := sqlx.Get(
+		r.Queryer(), &count,
+		`SELECT count(*) FROM okihome.tj_feeditem_user WHERE tj_feeditem_user.user_id=$1 AND tj_feeditem_user.read
+AND (tj_feeditem_user.read_at IS NULL OR tj_feeditem_user.read_at<$2) AND NOT EXISTS (
+SELECT 1 FROM okihome.t_feeditem WHERE t_feeditem.feed_id = tj_feeditem_user.feed_id AND t_feeditem.guid = tj_feeditem_user.guid)`,
+		userID, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "Counting expired read items failed")
+	}
+
+	return count, nil
+}
+func (r *repo) DeleteReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+
+	res, err := r.Execer().Exec(
+		`DELETE FROM okihome.tj_feeditem_user WHERE tj_feeditem_user.user_id=$1 AND tj_feeditem_user.read
+AND (tj_feeditem_user.read_at IS NULL OR tj_feeditem_user.read_at<$2) AND NOT EXISTS (
+SELECT 1 FROM okihome.t_feeditem WHERE t_feeditem.feed_id = tj_feeditem_user.feed_id AND t_feeditem.guid = tj_feeditem_user.guid)`,
+		userID, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "Deleting expired read items failed")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "Counting deleted read items failed")
+	}
+
+	return count, nil
+}
+func (r *repo) CountClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+
+	var count int64
+	err := sqlx.Get(
+		r.Queryer(), &count,
+		`SELECT count(*) FROM okihome.tj_feeditem_user WHERE user_id=$1 AND read_at<$2`,
+		userID, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "Counting expired read dates failed")
+	}
+
+	return count, nil
+}
+func (r *repo) DeleteClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+
+	res, err := r.Execer().Exec(
+		`UPDATE okihome.tj_feeditem_user SET read_at=NULL WHERE user_id=$1 AND read_at<$2`,
+		userID, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "Deleting expired read dates failed")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "Counting deleted read dates failed")
+	}
+
+	return count, nil
+}
+func (r *repo) CountEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+
+	var count int64
+	err := sqlx.Get(
+		r.Queryer(), &count,
+		`SELECT count(*) FROM okihome.t_emailitem WHERE account_id IN (SELECT id FROM okihome.t_account WHERE user_id=$1) AND published<$2`,
+		userID, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "Counting expired email items failed")
+	}
+
+	return count, nil
+}
+func (r *repo) DeleteEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+
+	res, err := r.Execer().Exec(
+		`DELETE FROM okihome.t_emailitem WHERE account_id IN (SELECT id FROM okihome.t_account WHERE user_id=$1) AND published<$2`,
+		userID, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "Deleting expired email items failed")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "Counting deleted email items failed")
+	}
+
+	return count, nil
+}
+
+func (r *repo) GetLastSnapshot(ctx context.Context, userID string) (api.StoredSnapshot, error) {
+
+	var flat struct {
+		UserID  string    `db:"user_id"`
+		Created time.Time `db:"created"`
+		Data    []byte    `db:"data"`
+	}
+	err := sqlx.Get(
+		r.Queryer(), &flat,
+		"SELECT user_id, created, data FROM okihome.t_lastsnapshot WHERE user_id=$1",
+		userID)
+	if err != nil {
+		return api.StoredSnapshot{}, errors.Wrap(err, "Retrieving last snapshot failed")
+	}
+
+	snapshot := api.StoredSnapshot{UserID: flat.UserID, Created: flat.Created}
+	if err := json.Unmarshal(flat.Data, &snapshot.Snapshot); err != nil {
+		return api.StoredSnapshot{}, errors.Wrap(err, "Unmarshaling last snapshot failed")
+	}
+
+	return snapshot, nil
+}
+func (r *repo) StoreLastSnapshot(ctx context.Context, snapshot api.StoredSnapshot) error {
+
+	data, err := json.Marshal(snapshot.Snapshot)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling last snapshot failed")
+	}
+
+	_, err = r.Execer().Exec(
+		`INSERT INTO okihome.t_lastsnapshot(user_id, created, data) VALUES ($1,$2,$3)
+ON CONFLICT (user_id) DO UPDATE SET created=$2, data=$3`,
+		snapshot.UserID, snapshot.Created, data)
+	if err != nil {
+		return errors.Wrap(err, "Storing last snapshot failed")
+	}
+
+	return nil
+}
+func (r *repo) GetActivities(ctx context.Context, userID string, limit int) ([]api.Activity, error) {
+
+	var flat []struct {
+		ID      int64     `db:"id"`
+		UserID  string    `db:"user_id"`
+		Created time.Time `db:"created"`
+		Changes []byte    `db:"changes"`
+	}
+	err := sqlx.Select(
+		r.Queryer(), &flat,
+		"SELECT id, user_id, created, changes FROM okihome.t_activity WHERE user_id=$1 ORDER BY id DESC LIMIT $2",
+		userID, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching activities failed")
+	}
+
+	activities := make([]api.Activity, len(flat))
+	for i, a := range flat {
+		activities[i] = api.Activity{ID: a.ID, UserID: a.UserID, Created: a.Created}
+		if err := json.Unmarshal(a.Changes, &activities[i].Changes); err != nil {
+			return nil, errors.Wrap(err, "Unmarshaling activity changes failed")
+		}
+	}
+
+	return activities, nil
+}
+func (r *repo) StoreActivity(ctx context.Context, activity *api.Activity) error {
+
+	changes, err := json.Marshal(activity.Changes)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling activity changes failed")
+	}
+
+	err = sqlx.Get(
+		r.Queryer(), &activity.ID,
+		"INSERT INTO okihome.t_activity(user_id, created, changes) VALUES ($1,$2,$3) RETURNING id",
+		activity.UserID, activity.Created, changes)
+	if err != nil {
+		return errors.Wrap(err, "Inserting activity failed")
+	}
+
+	return nil
+}
+func (r *repo) DeleteOldActivities(ctx context.Context, userID string, keep int) (int64, error) {
+
+	res, err := r.Execer().Exec(
+		`DELETE FROM okihome.t_activity WHERE user_id=$1
+AND id NOT IN (SELECT id FROM okihome.t_activity WHERE user_id=$1 ORDER BY id DESC LIMIT $2)`,
+		userID, keep)
+	if err != nil {
+		return 0, errors.Wrap(err, "Removing old activities failed")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "Counting removed activities failed")
+	}
+
+	return count, nil
+}
+
+func (r *repo) GetApprovalRequests(ctx context.Context, userID string) ([]api.ApprovalRequest, error) {
+
+	var requests []api.ApprovalRequest
+
+	err := sqlx.Select(
+		r.Queryer(), &requests,
+		`SELECT id, user_id, kind, value, status, requested FROM okihome.t_approvalrequest WHERE user_id=$1 ORDER BY requested DESC`,
+		userID)
+
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching approval requests failed")
+	}
+
+	return requests, nil
+}
+func (r *repo) StoreApprovalRequest(ctx context.Context, request *api.ApprovalRequest) error {
+
+	if request.ID > 0 {
+		//Update
+		_, err := r.Execer().Exec(
+			"UPDATE okihome.t_approvalrequest SET kind=$1, value=$2, status=$3, requested=$4 WHERE id=$5 AND user_id=$6",
+			request.Kind, request.Value, request.Status, request.Requested, request.ID, request.UserID)
+		if err != nil {
+			return errors.Wrap(err, "Updating approval request failed")
+		}
+	} else {
+		//Insert
+		err := sqlx.Get(
+			r.Queryer(), &request.ID,
+			"INSERT INTO okihome.t_approvalrequest(user_id, kind, value, status, requested) VALUES ($1,$2,$3,$4,$5) RETURNING id",
+			request.UserID, request.Kind, request.Value, request.Status, request.Requested)
+		if err != nil {
+			return errors.Wrap(err, "Inserting approval request failed")
+		}
+	}
+
+	return nil
+}
+
+func (r *repo) GetStarredItems(ctx context.Context, userID string) ([]api.StarredItem, error) {
+
+	var flat []struct {
+		api.StarredItem
+		Tags    []byte    `db:"tags"`
+		Starred time.Time `db:"starred"`
+	}
+	err := sqlx.Select(
+		r.Queryer(), &flat,
+		"SELECT user_id, feed_id, guid, title, link, tags, starred FROM okihome.t_starreditem WHERE user_id=$1 ORDER BY starred DESC",
+		userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching starred items failed")
+	}
+
+	items := make([]api.StarredItem, len(flat))
+	for i, f := range flat {
+		items[i] = f.StarredItem
+		items[i].Starred = f.Starred
+		if err := json.Unmarshal(f.Tags, &items[i].Tags); err != nil {
+			return nil, errors.Wrap(err, "Unmarshaling starred item tags failed")
+		}
+	}
+
+	return items, nil
+}
+func (r *repo) StoreStarredItem(ctx context.Context, item api.StarredItem) error {
+
+	tags, err := json.Marshal(item.Tags)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling starred item tags failed")
+	}
+
+	_, err = r.Execer().Exec(
+		`INSERT INTO okihome.t_starreditem(user_id, feed_id, guid, title, link, tags, starred) VALUES ($1,$2,$3,$4,$5,$6,$7)
+ON CONFLICT (user_id, feed_id, guid) DO UPDATE SET title=$4, link=$5, tags=$6, starred=$7`,
+		item.UserID, item.FeedID, item.GUID, item.Title, item.Link, tags, item.Starred)
+	if err != nil {
+		return errors.Wrap(err, "Storing starred item failed")
+	}
+
+	return nil
+}
+func (r *repo) DeleteStarredItem(ctx context.Context, userID string, feedID int64, guid string) error {
+
+	_, err := r.Execer().Exec(
+		"DELETE FROM okihome.t_starreditem WHERE user_id=$1 AND feed_id=$2 AND guid=$3",
+		userID, feedID, guid)
+	if err != nil {
+		return errors.Wrap(err, "Deleting starred item failed")
+	}
+
+	return nil
+}
+
+func (r *repo) GetUserWebhook(ctx context.Context, userID string) (api.UserWebhook, error) {
+
+	var webhook api.UserWebhook
+	err := sqlx.Get(
+		r.Queryer(), &webhook,
+		"SELECT user_id, url, secret FROM okihome.t_userwebhook WHERE user_id=$1",
+		userID)
+	if err != nil {
+		return api.UserWebhook{}, errors.Wrap(err, "Retrieving user webhook failed")
+	}
+
+	webhook.Secret, err = r.tokens.OpenSecret(webhook.Secret)
+	if err != nil {
+		return api.UserWebhook{}, errors.Wrap(err, "Decrypting user webhook secret failed")
+	}
+
+	return webhook, nil
+}
+func (r *repo) StoreUserWebhook(ctx context.Context, webhook api.UserWebhook) error {
+
+	//The secret is encrypted like the account tokens
+	secret, err := r.tokens.SealSecret(webhook.Secret)
+	if err != nil {
+		return errors.Wrap(err, "Encrypting user webhook secret failed")
+	}
+
+	_, err = r.Execer().Exec(
+		`INSERT INTO okihome.t_userwebhook(user_id, url, secret) VALUES ($1,$2,$3)
+ON CONFLICT (user_id) DO UPDATE SET url=$2, secret=$3`,
+		webhook.UserID, webhook.URL, secret)
+	if err != nil {
+		return errors.Wrap(err, "Storing user webhook failed")
+	}
+
+	return nil
+}
+func (r *repo) DeleteUserWebhook(ctx context.Context, userID string) error {
+
+	_, err := r.Execer().Exec(
+		"DELETE FROM okihome.t_userwebhook WHERE user_id=$1",
+		userID)
+	if err != nil {
+		return errors.Wrap(err, "Deleting user webhook failed")
+	}
+
+	return nil
+}
+
+func (r *repo) GetNotificationSettings(ctx context.Context, userID string) (api.NotificationSettings, error) {
+
+	var s struct {
+		UserID     string `db:"user_id"`
+		Channels   []byte `db:"channels"`
+		Sources    []byte `db:"sources"`
+		QuietStart string `db:"quiet_start"`
+		QuietEnd   string `db:"quiet_end"`
+	}
+	err := sqlx.Get(
+		r.Queryer(), &s,
+		"SELECT user_id, channels, sources, quiet_start, quiet_end FROM okihome.t_notification WHERE user_id=$1",
+		userID)
+	if err != nil {
+		return api.NotificationSettings{}, errors.Wrap(err, "Retrieving notification settings failed")
+	}
+
+	settings := api.NotificationSettings{
+		UserID:     s.UserID,
+		QuietStart: s.QuietStart,
+		QuietEnd:   s.QuietEnd,
+	}
+	if err := json.Unmarshal(s.Channels, &settings.Channels); err != nil {
+		return api.NotificationSettings{}, errors.Wrap(err, "Unmarshaling notification channels failed")
+	}
+	if err := json.Unmarshal(s.Sources, &settings.Sources); err != nil {
+		return api.NotificationSettings{}, errors.Wrap(err, "Unmarshaling notification sources failed")
+	}
+
+	return settings, nil
+}
+func (r *repo) StoreNotificationSettings(ctx context.Context, settings api.NotificationSettings) error {
+
+	if settings.Channels == nil {
+		settings.Channels = []api.NotificationChannel{}
+	}
+	if settings.Sources == nil {
+		settings.Sources = []api.NotificationSource{}
+	}
+	channelsJSON, err := json.Marshal(settings.Channels)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling notification channels failed")
+	}
+	sourcesJSON, err := json.Marshal(settings.Sources)
+	if err != nil {
+		return errors.Wrap(err, "Marshaling notification sources failed")
+	}
+
+	_, err = r.Execer().Exec(
+		`INSERT INTO okihome.t_notification(user_id, channels, sources, quiet_start, quiet_end) VALUES ($1,$2,$3,$4,$5)
+ON CONFLICT (user_id) DO UPDATE SET channels=$2, sources=$3, quiet_start=$4, quiet_end=$5`,
+		settings.UserID, channelsJSON, sourcesJSON, settings.QuietStart, settings.QuietEnd)
+	if err != nil {
+		return errors.Wrap(err, "Storing notification settings failed")
+	}
+
+	return nil
+}
+func (r *repo) DeleteNotificationSettings(ctx context.Context, userID string) error {
+
+	_, err := r.Execer().Exec(
+		"DELETE FROM okihome.t_notification WHERE user_id=$1",
+		userID)
+	if err != nil {
+		return errors.Wrap(err, "Deleting notification settings failed")
+	}
+
+	return nil
+}
+
+func (r *repo) GetTags(ctx context.Context, userID string) ([]api.Tag, error) {
+
+	tags := []api.Tag{}
+	err := sqlx.Select(
+		r.Queryer(), &tags,
+		"SELECT user_id, name, color FROM okihome.t_tag WHERE user_id=$1 ORDER BY name",
+		userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching tags failed")
+	}
+
+	return tags, nil
+}
+func (r *repo) StoreTag(ctx context.Context, tag api.Tag) error {
+
+	_, err := r.Execer().Exec(
+		`INSERT INTO okihome.t_tag(user_id, name, color) VALUES ($1,$2,$3)
+ON CONFLICT (user_id, name) DO UPDATE SET color=$3`,
+		tag.UserID, tag.Name, tag.Color)
+	if err != nil {
+		return errors.Wrap(err, "Storing tag failed")
+	}
+
+	return nil
+}
+func (r *repo) DeleteTag(ctx context.Context, userID string, name string) error {
+
+	_, err := r.Execer().Exec(
+		"DELETE FROM okihome.t_tag WHERE user_id=$1 AND name=$2",
+		userID, name)
+	if err != nil {
+		return errors.Wrap(err, "Deleting tag failed")
+	}
+
+	return nil
+}
+
+func (r *repo) GetWidgetViews(ctx context.Context, userID string) ([]api.WidgetView, error) {
+
+	views := []api.WidgetView{}
+	err := sqlx.Select(
+		r.Queryer(), &views,
+		"SELECT user_id, tab_id, widget_id, renders, last_viewed FROM okihome.t_widgetview WHERE user_id=$1",
+		userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching widget views failed")
+	}
+
+	return views, nil
+}
+func (r *repo) RecordWidgetView(ctx context.Context, view api.WidgetView) error {
+
+	_, err := r.Execer().Exec(
+		`INSERT INTO okihome.t_widgetview(user_id, tab_id, widget_id, renders, last_viewed) VALUES ($1,$2,$3,$4,$5)
+ON CONFLICT (user_id, tab_id, widget_id) DO UPDATE SET renders=okihome.t_widgetview.renders+$4, last_viewed=$5`,
+		view.UserID, view.TabID, view.WidgetID, view.Renders, view.LastViewed)
+	if err != nil {
+		return errors.Wrap(err, "Storing widget view failed")
+	}
+
+	return nil
+}
+func (r *repo) GetViewedWidgets(ctx context.Context, since time.Time) ([]api.Widget, error) {
+
+	var widgets []struct {
+		ID   int64  `db:"id"`
+		Type string `db:"type"`
+		Cfg  []byte `db:"cfg"`
+	}
+	err := sqlx.Select(
+		r.Queryer(), &widgets,
+		`SELECT w.id, w.type, w.config as cfg FROM okihome.t_widget w
+WHERE NOT EXISTS (SELECT 1 FROM okihome.t_widgetview v WHERE v.widget_id=w.id)
+OR EXISTS (SELECT 1 FROM okihome.t_widgetview v WHERE v.widget_id=w.id AND v.last_viewed>=$1)`,
+		since)
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching viewed widgets failed")
+	}
+
+	viewed := make([]api.Widget, 0, len(widgets))
+	for _, w := range widgets {
+		config, _, err := api.DecodeWidgetConfig(w.Type, w.Cfg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Decoding config of widget %d failed", w.ID)
+		}
+		viewed = append(viewed, api.Widget{ID: w.ID, Type: w.Type, Config: config})
+	}
+
+	return viewed, nil
+}
+
+func (r *repo) StoreAuditEvent(ctx context.Context, event *api.AuditEvent) error {
+
+	err := sqlx.Get(
+		r.Queryer(), &event.ID,
+		"INSERT INTO okihome.t_auditevent(time, actor_id, user_id, action, target) VALUES ($1,$2,$3,$4,$5) RETURNING id",
+		event.Time, event.ActorID, event.UserID, string(event.Action), event.Target)
+	if err != nil {
+		return errors.Wrap(err, "Inserting audit event failed")
+	}
+
+	return nil
+}
+func (r *repo) GetAuditEventsPage(ctx context.Context, filter api.AuditFilter, page api.PageRequest) ([]api.AuditEvent, string, error) {
+
+	cursor, err := page.IDCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	//The zero times disable the bounds
+	bound := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+
+	var flat []struct {
+		ID      int64     `db:"id"`
+		Time    time.Time `db:"time"`
+		ActorID string    `db:"actor_id"`
+		UserID  string    `db:"user_id"`
+		Action  string    `db:"action"`
+		Target  string    `db:"target"`
+	}
+	err = sqlx.Select(
+		r.Queryer(), &flat,
+		`SELECT id, time, actor_id, user_id, action, target FROM okihome.t_auditevent
+WHERE ($1=0 OR id<$1) AND ($2='' OR user_id=$2) AND ($3='' OR actor_id=$3) AND ($4='' OR action=$4)
+AND ($5::timestamptz IS NULL OR time>=$5) AND ($6::timestamptz IS NULL OR time<$6)
+ORDER BY id DESC LIMIT $7`,
+		cursor, filter.UserID, filter.ActorID, string(filter.Action), bound(filter.Since), bound(filter.Until), page.Size()+1)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "Fetching audit events failed")
+	}
+
+	next := ""
+	if len(flat) > page.Size() {
+		flat = flat[:page.Size()]
+		next = api.NextIDCursor(flat[len(flat)-1].ID)
+	}
+
+	events := make([]api.AuditEvent, len(flat))
+	for i, e := range flat {
+		events[i] = api.AuditEvent{ID: e.ID, Time: e.Time, ActorID: e.ActorID, UserID: e.UserID, Action: api.AuditAction(e.Action), Target: e.Target}
+	}
+
+	return events, next, nil
+}
+
+func (r *repo) AddUsage(ctx context.Context, userID string, day time.Time, usage api.Usage) error {
+
+	_, err := r.Execer().Exec(
+		`INSERT INTO okihome.t_usage(user_id, day, widget_views, items_read, feeds_refreshed) VALUES ($1,$2,$3,$4,$5)
+ON CONFLICT (user_id, day) DO UPDATE SET widget_views=okihome.t_usage.widget_views+$3,
+items_read=okihome.t_usage.items_read+$4, feeds_refreshed=okihome.t_usage.feeds_refreshed+$5`,
+		userID, day.UTC().Format("2006-01-02"), usage.WidgetViews, usage.ItemsRead, usage.FeedsRefreshed)
+	if err != nil {
+		return errors.Wrap(err, "Storing usage failed")
+	}
+
+	return nil
+}
+func (r *repo) GetUsage(ctx context.Context, userID string, since time.Time) ([]api.DailyUsage, error) {
+
+	var flat []struct {
+		api.Usage
+		Day   time.Time `db:"day"`
+		Users int64     `db:"users"`
+	}
+	err := sqlx.Select(
+		r.Queryer(), &flat,
+		`SELECT day, SUM(widget_views) AS widget_views, SUM(items_read) AS items_read,
+SUM(feeds_refreshed) AS feeds_refreshed, COUNT(*) AS users FROM okihome.t_usage
+WHERE ($1='' OR user_id=$1) AND day>=$2::date GROUP BY day ORDER BY day`,
+		userID, since.UTC().Format("2006-01-02"))
+	if err != nil {
+		return nil, errors.Wrap(err, "Fetching usage failed")
+	}
+
+	days := make([]api.DailyUsage, len(flat))
+	for i, f := range flat {
+		days[i] = api.DailyUsage{Day: f.Day.UTC(), Usage: f.Usage}
+		if len(userID) == 0 {
+			days[i].Users = f.Users
+		}
+	}
+
+	return days, nil
+}
+
+func (r *repo) DeleteUsageBefore(ctx context.Context, before time.Time) (int64, error) {
+
+	res, err := r.Execer().Exec(
+		"DELETE FROM okihome.t_usage WHERE day<$1",
+		before.UTC().Format("2006-01-02"))
+	if err != nil {
+		return 0, errors.Wrap(err, "Deleting expired usage failed")
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "Counting deleted usage failed")
+	}
+
+	return count, nil
+}
@@ -0,0 +1,411 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlite
+
+import (
+	"github.com/oki-apps/okihome/repository"
+)
+
+//migrations are applied on top of setup.sql.
+//SQLite can not drop columns, so the migrations adding columns can not be reverted,
+//unless their Down rebuilds the table.
+var migrations = []repository.Migration{
+	{
+		Version:     1,
+		Description: "email item attachments",
+		Up: `ALTER TABLE t_emailitem ADD COLUMN has_attachments boolean DEFAULT false NOT NULL;
+ALTER TABLE t_emailitem ADD COLUMN attachments text DEFAULT '[]' NOT NULL;`,
+	},
+	{
+		Version:     2,
+		Description: "link policies",
+		Up: `CREATE TABLE t_linkpolicy (
+    user_id text PRIMARY KEY,
+    policies text DEFAULT '[]' NOT NULL,
+    CONSTRAINT c_fk_linkpolicy_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_linkpolicy;`,
+	},
+	{
+		Version:     3,
+		Description: "temporary code creation date",
+		Up:          `ALTER TABLE t_temporarycode ADD COLUMN created text DEFAULT '' NOT NULL;`,
+	},
+	{
+		Version:     4,
+		Description: "item abstracts",
+		Up: `CREATE TABLE t_itemabstract (
+    feed_id integer NOT NULL,
+    guid text NOT NULL,
+    abstract text DEFAULT '' NOT NULL,
+    PRIMARY KEY (feed_id, guid),
+    CONSTRAINT c_fk_itemabstract_feed FOREIGN KEY (feed_id)
+        REFERENCES t_feed (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_itemabstract;`,
+	},
+	{
+		Version:     5,
+		Description: "account re-authorization flag",
+		Up:          `ALTER TABLE t_account ADD COLUMN needs_reauth boolean DEFAULT false NOT NULL;`,
+	},
+	{
+		Version:     6,
+		Description: "ranking models",
+		Up: `CREATE TABLE t_rankingmodel (
+    user_id text PRIMARY KEY,
+    bias real DEFAULT 0 NOT NULL,
+    weights text DEFAULT '{}' NOT NULL,
+    updated text,
+    CONSTRAINT c_fk_rankingmodel_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_rankingmodel;`,
+	},
+	{
+		Version:     7,
+		Description: "account labels",
+		Up:          `ALTER TABLE t_account ADD COLUMN label text DEFAULT '' NOT NULL;`,
+	},
+	{
+		Version:     8,
+		Description: "API tokens",
+		Up: `CREATE TABLE t_apitoken (
+    id integer PRIMARY KEY,
+    user_id text NOT NULL,
+    name text DEFAULT '' NOT NULL,
+    hint text DEFAULT '' NOT NULL,
+    hash text NOT NULL UNIQUE,
+    created text,
+    last_used text,
+    CONSTRAINT c_fk_apitoken_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_apitoken;`,
+	},
+	{
+		Version:     9,
+		Description: "tab slugs",
+		Up: `CREATE TABLE t_tabslug (
+    user_id text NOT NULL,
+    slug text NOT NULL,
+    tab_id integer NOT NULL,
+    current boolean DEFAULT true NOT NULL,
+    CONSTRAINT c_pk_tabslug PRIMARY KEY (user_id, slug),
+    CONSTRAINT c_fk_tabslug_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    CONSTRAINT c_fk_tabslug_tab FOREIGN KEY (tab_id)
+        REFERENCES t_tab (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_tabslug;`,
+	},
+	{
+		Version:     10,
+		Description: "retention policies",
+		Up: `CREATE TABLE t_retention (
+    user_id text NOT NULL,
+    read_items_days integer DEFAULT 0 NOT NULL,
+    email_cache_days integer DEFAULT 0 NOT NULL,
+    CONSTRAINT c_pk_retention PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_retention_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_retention;`,
+	},
+	{
+		Version:     11,
+		Description: "last snapshots",
+		Up: `CREATE TABLE t_lastsnapshot (
+    user_id text NOT NULL,
+    created text,
+    data text NOT NULL,
+    CONSTRAINT c_pk_lastsnapshot PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_lastsnapshot_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_lastsnapshot;`,
+	},
+	{
+		Version:     12,
+		Description: "activities",
+		Up: `CREATE TABLE t_activity (
+    id integer PRIMARY KEY,
+    user_id text NOT NULL,
+    created text,
+    changes text DEFAULT '[]' NOT NULL,
+    CONSTRAINT c_fk_activity_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_activity;`,
+	},
+	{
+		Version:     13,
+		Description: "temporary code widget tab",
+		Up:          `ALTER TABLE t_temporarycode ADD COLUMN widget_tab_id integer DEFAULT 0 NOT NULL;`,
+		Down: `CREATE TABLE t_temporarycode_down (
+    code text PRIMARY KEY,
+    user_id text,
+    provider text,
+    date text,
+    created text DEFAULT '' NOT NULL,
+    CONSTRAINT c_fk_temporarycode_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+INSERT INTO t_temporarycode_down (code, user_id, provider, date, created)
+SELECT code, user_id, provider, date, created FROM t_temporarycode;
+DROP TABLE t_temporarycode;
+ALTER TABLE t_temporarycode_down RENAME TO t_temporarycode;`,
+	},
+	{
+		Version:     14,
+		Description: "starred items and user webhooks",
+		Up: `CREATE TABLE t_starreditem (
+    user_id text NOT NULL,
+    feed_id integer NOT NULL,
+    guid text NOT NULL,
+    title text DEFAULT '' NOT NULL,
+    link text DEFAULT '' NOT NULL,
+    tags text DEFAULT '[]' NOT NULL,
+    starred text,
+    CONSTRAINT c_pk_starreditem PRIMARY KEY (user_id, feed_id, guid),
+    CONSTRAINT c_fk_starreditem_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE t_userwebhook (
+    user_id text NOT NULL,
+    url text NOT NULL,
+    secret text DEFAULT '' NOT NULL,
+    CONSTRAINT c_pk_userwebhook PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_userwebhook_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_starreditem;
+DROP TABLE t_userwebhook;`,
+	},
+	{
+		Version:     15,
+		Description: "user tags",
+		Up: `CREATE TABLE t_tag (
+    user_id text NOT NULL,
+    name text NOT NULL,
+    color text DEFAULT '' NOT NULL,
+    CONSTRAINT c_pk_tag PRIMARY KEY (user_id, name),
+    CONSTRAINT c_fk_tag_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_tag;`,
+	},
+	{
+		Version:     16,
+		Description: "widget views",
+		Up: `CREATE TABLE t_widgetview (
+    user_id text NOT NULL,
+    tab_id integer NOT NULL,
+    widget_id integer NOT NULL,
+    renders integer DEFAULT 0 NOT NULL,
+    last_viewed text,
+    CONSTRAINT c_pk_widgetview PRIMARY KEY (user_id, tab_id, widget_id),
+    CONSTRAINT c_fk_widgetview_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+INSERT INTO t_widgetview(user_id, tab_id, widget_id, renders, last_viewed)
+    SELECT a.user_id, w.tab_id, w.id, 0, datetime('now') FROM t_widget w
+    JOIN tj_tabaccess a ON a.tab_id=w.tab_id;`,
+		Down: `DROP TABLE t_widgetview;`,
+	},
+	{
+		Version:     17,
+		Description: "tab positions by user",
+		Up: `ALTER TABLE tj_tabaccess ADD COLUMN pos integer;
+UPDATE tj_tabaccess SET pos=(SELECT pos FROM t_tab WHERE t_tab.id=tj_tabaccess.tab_id);`,
+	},
+	{
+		Version:     18,
+		Description: "default tab",
+		Up:          `ALTER TABLE tj_tabaccess ADD COLUMN isdefault boolean DEFAULT false NOT NULL;`,
+	},
+	{
+		Version:     19,
+		Description: "user time zone",
+		Up:          `ALTER TABLE t_user ADD COLUMN timezone text DEFAULT '' NOT NULL;`,
+	},
+	{
+		Version:     20,
+		Description: "user locale",
+		Up:          `ALTER TABLE t_user ADD COLUMN locale text DEFAULT '' NOT NULL;`,
+	},
+	{
+		Version:     21,
+		Description: "notification settings",
+		Up: `CREATE TABLE t_notification (
+    user_id text NOT NULL,
+    channels text DEFAULT '[]' NOT NULL,
+    sources text DEFAULT '[]' NOT NULL,
+    quiet_start text DEFAULT '' NOT NULL,
+    quiet_end text DEFAULT '' NOT NULL,
+    CONSTRAINT c_pk_notification PRIMARY KEY (user_id),
+    CONSTRAINT c_fk_notification_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_notification;`,
+	},
+	{
+		Version:     22,
+		Description: "audit log",
+		Up: `CREATE TABLE t_auditevent (
+    id integer PRIMARY KEY,
+    time text NOT NULL,
+    actor_id text DEFAULT '' NOT NULL,
+    user_id text DEFAULT '' NOT NULL,
+    action text NOT NULL,
+    target text DEFAULT '' NOT NULL
+);`,
+		Down: `DROP TABLE t_auditevent;`,
+	},
+	{
+		Version:     23,
+		Description: "usage statistics",
+		Up: `CREATE TABLE t_usage (
+    user_id text NOT NULL,
+    day text NOT NULL,
+    widget_views integer DEFAULT 0 NOT NULL,
+    items_read integer DEFAULT 0 NOT NULL,
+    feeds_refreshed integer DEFAULT 0 NOT NULL,
+    CONSTRAINT c_pk_usage PRIMARY KEY (user_id, day),
+    CONSTRAINT c_fk_usage_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_usage;`,
+	},
+	{
+		Version:     24,
+		Description: "managed policies and approval requests",
+		Up: `CREATE TABLE IF NOT EXISTS t_managedpolicy (
+    user_id text PRIMARY KEY,
+    manager_id text NOT NULL,
+    allowed_feeds text DEFAULT '[]' NOT NULL,
+    allowed_providers text DEFAULT '[]' NOT NULL,
+    CONSTRAINT c_fk_managedpolicy_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE TABLE IF NOT EXISTS t_approvalrequest (
+    id integer PRIMARY KEY,
+    user_id text NOT NULL,
+    kind text NOT NULL,
+    value text NOT NULL,
+    status text DEFAULT 'pending' NOT NULL,
+    requested TEXT DEFAULT (datetime('now')) NOT NULL,
+    CONSTRAINT c_fk_approvalrequest_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_approvalrequest;
+DROP TABLE t_managedpolicy;`,
+	},
+	{
+		Version:     25,
+		Description: "granted scopes",
+		Up: `ALTER TABLE t_account ADD COLUMN scopes text DEFAULT '[]' NOT NULL;
+ALTER TABLE t_temporarycode ADD COLUMN account_id integer DEFAULT 0 NOT NULL;`,
+	},
+	{
+		Version:     26,
+		Description: "email synchronization cache",
+		Up: `CREATE TABLE IF NOT EXISTS t_emailsync (
+    account_id integer PRIMARY KEY,
+    synced TEXT DEFAULT (datetime('now')) NOT NULL,
+    guids text DEFAULT '[]' NOT NULL,
+    next_page text DEFAULT '' NOT NULL,
+    result_size_estimate integer DEFAULT 0 NOT NULL,
+    CONSTRAINT c_fk_emailsync_account FOREIGN KEY (account_id)
+        REFERENCES t_account (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);`,
+		Down: `DROP TABLE t_emailsync;`,
+	},
+	{
+		Version:     27,
+		Description: "feed item images",
+		Up:          `ALTER TABLE t_feeditem ADD COLUMN image_url text DEFAULT '' NOT NULL;`,
+	},
+	{
+		Version:     28,
+		Description: "click history retention",
+		Up: `ALTER TABLE t_retention ADD COLUMN click_history_days integer DEFAULT 0 NOT NULL;
+ALTER TABLE tj_feeditem_user ADD COLUMN read_at text;`,
+	},
+	{
+		Version:     29,
+		Description: "widget feeds",
+		Up: `ALTER TABLE t_widget ADD COLUMN feed_id integer;
+UPDATE t_widget SET feed_id=CAST(substr(config, instr(config, '"feed_id":')+10) AS integer)
+WHERE type='feed' AND instr(config, '"feed_id":')>0;`,
+	},
+	{
+		Version:     30,
+		Description: "widget views of the deleted widgets",
+		Up: `CREATE TABLE t_widgetview_new (
+    user_id text NOT NULL,
+    tab_id integer NOT NULL,
+    widget_id integer NOT NULL,
+    renders integer DEFAULT 0 NOT NULL,
+    last_viewed text,
+    CONSTRAINT c_pk_widgetview PRIMARY KEY (user_id, tab_id, widget_id),
+    CONSTRAINT c_fk_widgetview_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    CONSTRAINT c_fk_widgetview_tab FOREIGN KEY (tab_id)
+        REFERENCES t_tab (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE,
+    CONSTRAINT c_fk_widgetview_widget FOREIGN KEY (widget_id)
+        REFERENCES t_widget (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+INSERT INTO t_widgetview_new(user_id, tab_id, widget_id, renders, last_viewed)
+    SELECT v.user_id, v.tab_id, v.widget_id, v.renders, v.last_viewed FROM t_widgetview v
+    JOIN t_widget w ON w.id=v.widget_id AND w.tab_id=v.tab_id;
+DROP TABLE t_widgetview;
+ALTER TABLE t_widgetview_new RENAME TO t_widgetview;`,
+		Down: `CREATE TABLE t_widgetview_down (
+    user_id text NOT NULL,
+    tab_id integer NOT NULL,
+    widget_id integer NOT NULL,
+    renders integer DEFAULT 0 NOT NULL,
+    last_viewed text,
+    CONSTRAINT c_pk_widgetview PRIMARY KEY (user_id, tab_id, widget_id),
+    CONSTRAINT c_fk_widgetview_user FOREIGN KEY (user_id)
+        REFERENCES t_user (id) MATCH SIMPLE
+        ON UPDATE CASCADE ON DELETE CASCADE
+);
+INSERT INTO t_widgetview_down(user_id, tab_id, widget_id, renders, last_viewed)
+    SELECT user_id, tab_id, widget_id, renders, last_viewed FROM t_widgetview;
+DROP TABLE t_widgetview;
+ALTER TABLE t_widgetview_down RENAME TO t_widgetview;`,
+	},
+	{
+		Version:     31,
+		Description: "feed item publication times in UTC",
+		Up: `UPDATE t_feeditem SET published=datetime(published)
+WHERE datetime(published) IS NOT NULL AND published<>datetime(published);`,
+	},
+}
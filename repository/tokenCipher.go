@@ -0,0 +1,199 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+const tokenPrefix = "okienc:"
+
+//TokenKey is a version of the key used to encrypt the account tokens at rest
+type TokenKey struct {
+	Version int
+	//Secret is the base64 encoded AES key (32 bytes for AES-256)
+	Secret string
+}
+
+//TokenCipher encrypts the account tokens with the newest key,
+//and decrypts tokens encrypted with any of the known keys.
+//A nil TokenCipher stores the tokens as plain JSON.
+type TokenCipher struct {
+	keys    map[int]cipher.AEAD
+	current int
+}
+
+//NewTokenCipher creates a TokenCipher using the given keys, the newest being the one with the highest version.
+//It returns nil if no key is given.
+func NewTokenCipher(keys []TokenKey) (*TokenCipher, error) {
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	c := &TokenCipher{
+		keys: make(map[int]cipher.AEAD),
+	}
+
+	for _, k := range keys {
+		if k.Version <= 0 {
+			return nil, errors.Errorf("Invalid token key version %d", k.Version)
+		}
+		if _, ok := c.keys[k.Version]; ok {
+			return nil, errors.Errorf("Duplicated token key version %d", k.Version)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(k.Secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid token key %d", k.Version)
+		}
+		block, err := aes.NewCipher(secret)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid token key %d", k.Version)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid token key %d", k.Version)
+		}
+
+		c.keys[k.Version] = aead
+		if k.Version > c.current {
+			c.current = k.Version
+		}
+	}
+
+	return c, nil
+}
+
+//Seal returns the JSON to be stored for the given token
+func (c *TokenCipher) Seal(token *oauth2.Token) ([]byte, error) {
+
+	tokenJSON, err := json.Marshal(token)
+	if err != nil {
+		return nil, errors.Wrap(err, "Marshaling account token failed")
+	}
+
+	if c == nil {
+		return tokenJSON, nil
+	}
+
+	sealed, err := c.seal(tokenJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	//Stored as a JSON string, so it fits in JSON columns
+	return json.Marshal(sealed)
+}
+
+//Open decodes a stored token.
+//It also returns true if the token is not encrypted with the newest key and should be sealed again.
+func (c *TokenCipher) Open(stored []byte) (*oauth2.Token, bool, error) {
+
+	var encrypted string
+	if err := json.Unmarshal(stored, &encrypted); err != nil {
+		//Plain JSON token
+		token := &oauth2.Token{}
+		if err := json.Unmarshal(stored, token); err != nil {
+			return nil, false, errors.Wrap(err, "Unmarshaling account token failed")
+		}
+		return token, c != nil, nil
+	}
+
+	if c == nil {
+		return nil, false, errors.New("Account token is encrypted but no token key is configured")
+	}
+	tokenJSON, version, err := c.open(encrypted)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Decrypting account token failed")
+	}
+
+	token := &oauth2.Token{}
+	if err := json.Unmarshal(tokenJSON, token); err != nil {
+		return nil, false, errors.Wrap(err, "Unmarshaling account token failed")
+	}
+
+	return token, version != c.current, nil
+}
+
+//SealSecret returns the value to be stored for a secret, such as the key of a user webhook.
+//A nil TokenCipher stores the secret as it is.
+func (c *TokenCipher) SealSecret(secret string) (string, error) {
+	if c == nil || len(secret) == 0 {
+		return secret, nil
+	}
+	return c.seal([]byte(secret))
+}
+
+//OpenSecret decodes a stored secret, the secrets stored before their encryption being returned as they are
+func (c *TokenCipher) OpenSecret(stored string) (string, error) {
+	if !strings.HasPrefix(stored, tokenPrefix) {
+		return stored, nil
+	}
+	if c == nil {
+		return "", errors.New("Secret is encrypted but no token key is configured")
+	}
+	secret, _, err := c.open(stored)
+	if err != nil {
+		return "", errors.Wrap(err, "Decrypting secret failed")
+	}
+	return string(secret), nil
+}
+
+//seal encrypts the data with the newest key
+func (c *TokenCipher) seal(data []byte) (string, error) {
+	aead := c.keys[c.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "Generating nonce failed")
+	}
+	sealed := aead.Seal(nonce, nonce, data, nil)
+
+	return fmt.Sprintf("%s%d:%s", tokenPrefix, c.current, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+//open decrypts the data sealed with any of the known keys and returns the version of the key
+func (c *TokenCipher) open(encrypted string) ([]byte, int, error) {
+	if !strings.HasPrefix(encrypted, tokenPrefix) {
+		return nil, 0, errors.New("Unknown encryption format")
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(encrypted, tokenPrefix), ":", 2)
+	if len(parts) != 2 {
+		return nil, 0, errors.New("Unknown encryption format")
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Invalid token key version")
+	}
+	aead, ok := c.keys[version]
+	if !ok {
+		return nil, 0, errors.Errorf("Unknown token key version %d", version)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Decoding encrypted data failed")
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, 0, errors.New("Encrypted data is too short")
+	}
+
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, version, nil
+}
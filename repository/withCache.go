@@ -0,0 +1,648 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//WithCache wraps a repository to keep in memory the tabs, the widgets, the feeds and the feed items read,
+//the least recently used ones being evicted above size entries, and all of them expiring after the ttl.
+//The entries are invalidated by the writes made through the returned repository: the ttl bounds the staleness
+//of the changes made by other instances sharing the database.
+func WithCache(r api.Repository, size int, ttl time.Duration) api.Repository {
+	return &cachedRepo{
+		repo: r,
+		cache: &repoCache{
+			size:    size,
+			ttl:     ttl,
+			entries: make(map[cacheKey]*list.Element),
+			lru:     list.New(),
+		},
+	}
+}
+
+type cachedRepo struct {
+	repo  api.Repository
+	cache *repoCache
+	//txInvalidations lists the invalidations of the transaction in progress, applied again once it ends.
+	//It is nil outside of a transaction.
+	txInvalidations *[]func(cacheKey) bool
+}
+
+type cacheKind int
+
+const (
+	cachedTab cacheKind = iota
+	cachedWidget
+	cachedFeed
+	cachedFeedItems
+)
+
+//cacheKey identifies an entry: id is the ID of the tab or of the feed, and sub the ID of the widget in the tab
+type cacheKey struct {
+	kind cacheKind
+	id   int64
+	sub  int64
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	value   interface{}
+	expires time.Time
+}
+
+//repoCache is a LRU cache whose entries expire.
+//Its generation changes on each invalidation, so that a value read before is not put in the cache.
+type repoCache struct {
+	mutex      sync.Mutex
+	size       int
+	ttl        time.Duration
+	generation uint64
+	entries    map[cacheKey]*list.Element
+	lru        *list.List
+}
+
+//get returns the value of the key if cached, and the generation to give to put otherwise
+func (c *repoCache) get(key cacheKey) (interface{}, bool, uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false, c.generation
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false, c.generation
+	}
+	c.lru.MoveToFront(elem)
+	return entry.value, true, c.generation
+}
+
+//put caches the value read at the given generation, unless an invalidation happened since
+func (c *repoCache) put(key cacheKey, value interface{}, generation uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: time.Now().Add(c.ttl)})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+//remove evicts the entries matching the key filter
+func (c *repoCache) remove(match func(cacheKey) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.generation++
+	for key, elem := range c.entries {
+		if match(key) {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+//invalidate evicts the matching entries, and again at the end of the transaction in progress, if any,
+//as the entries may be read again from the database before its commit
+func (r *cachedRepo) invalidate(match func(cacheKey) bool) {
+	r.cache.remove(match)
+	if r.txInvalidations != nil {
+		*r.txInvalidations = append(*r.txInvalidations, match)
+	}
+}
+
+func (r *cachedRepo) invalidateTab(tabID int64) {
+	r.invalidate(func(key cacheKey) bool {
+		return (key.kind == cachedTab || key.kind == cachedWidget) && key.id == tabID
+	})
+}
+
+func (r *cachedRepo) invalidateWidget(tabID int64, widgetID int64) {
+	r.invalidate(func(key cacheKey) bool {
+		return (key.kind == cachedTab && key.id == tabID) || key == cacheKey{cachedWidget, tabID, widgetID}
+	})
+}
+
+func (r *cachedRepo) invalidateAllTabs() {
+	r.invalidate(func(key cacheKey) bool {
+		return key.kind == cachedTab || key.kind == cachedWidget
+	})
+}
+
+func (r *cachedRepo) invalidateFeed(feedID int64) {
+	r.invalidate(func(key cacheKey) bool {
+		return (key.kind == cachedFeed || key.kind == cachedFeedItems) && key.id == feedID
+	})
+}
+
+func (r *cachedRepo) invalidateAllFeeds() {
+	r.invalidate(func(key cacheKey) bool {
+		return key.kind == cachedFeed || key.kind == cachedFeedItems
+	})
+}
+
+//cloneTab copies the layout of the tab, so that the callers changing it do not change the cached one
+func cloneTab(tab api.Tab) api.Tab {
+	if tab.Widgets == nil {
+		return tab
+	}
+	widgets := make([][]api.Widget, len(tab.Widgets))
+	for i, col := range tab.Widgets {
+		widgets[i] = make([]api.Widget, len(col))
+		for j, w := range col {
+			widgets[i][j] = cloneWidget(w)
+		}
+	}
+	tab.Widgets = widgets
+	return tab
+}
+
+//cloneWidget copies the slices of the config of the widget
+func cloneWidget(widget api.Widget) api.Widget {
+	switch cfg := widget.Config.(type) {
+	case api.ConfigFeed:
+		cfg.WidgetConfig = cloneWidgetConfig(cfg.WidgetConfig)
+		widget.Config = cfg
+	case api.ConfigEmail:
+		cfg.WidgetConfig = cloneWidgetConfig(cfg.WidgetConfig)
+		if cfg.Categories != nil {
+			cfg.Categories = append([]string{}, cfg.Categories...)
+		}
+		widget.Config = cfg
+	case api.ConfigCollection:
+		cfg.WidgetConfig = cloneWidgetConfig(cfg.WidgetConfig)
+		widget.Config = cfg
+	}
+	return widget
+}
+
+func cloneWidgetConfig(cfg api.WidgetConfig) api.WidgetConfig {
+	if cfg.LinkPolicies != nil {
+		cfg.LinkPolicies = append([]api.LinkPolicy{}, cfg.LinkPolicies...)
+	}
+	if cfg.Tags != nil {
+		cfg.Tags = append([]string{}, cfg.Tags...)
+	}
+	return cfg
+}
+
+func (r *cachedRepo) IsNotFound(err error) bool {
+	return r.repo.IsNotFound(err)
+}
+
+func (r *cachedRepo) Close() error {
+	return r.repo.Close()
+}
+
+func (r *cachedRepo) Optimize(ctx context.Context) error {
+	return r.repo.Optimize(ctx)
+}
+
+//LockStats gives the lock contention of the cached repository, empty if it has no lock
+func (r *cachedRepo) LockStats() (api.LockStats, error) {
+	inspector, ok := r.repo.(api.LockInspector)
+	if !ok {
+		return api.LockStats{Held: []api.LockHolder{}, Waiting: []api.LockHolder{}, Waits: []api.LockWait{}}, nil
+	}
+	return inspector.LockStats()
+}
+
+//RunInTransaction gives f a repository reading the database directly, so that the uncommitted data are not cached.
+//Its writes invalidate the entries again once the transaction ends.
+func (r *cachedRepo) RunInTransaction(ctx context.Context, f func(repo api.Repository) error) error {
+	if r.txInvalidations != nil {
+		//Nested in the transaction in progress
+		return r.repo.RunInTransaction(ctx, func(repo api.Repository) error {
+			return f(&cachedRepo{repo: repo, cache: r.cache, txInvalidations: r.txInvalidations})
+		})
+	}
+
+	invalidations := []func(cacheKey) bool{}
+	err := r.repo.RunInTransaction(ctx, func(repo api.Repository) error {
+		return f(&cachedRepo{repo: repo, cache: r.cache, txInvalidations: &invalidations})
+	})
+	for _, match := range invalidations {
+		r.cache.remove(match)
+	}
+	return err
+}
+
+func (r *cachedRepo) GetTab(ctx context.Context, tabID int64) (api.Tab, error) {
+	if r.txInvalidations != nil {
+		return r.repo.GetTab(ctx, tabID)
+	}
+
+	key := cacheKey{kind: cachedTab, id: tabID}
+	value, ok, generation := r.cache.get(key)
+	if ok {
+		return cloneTab(value.(api.Tab)), nil
+	}
+	tab, err := r.repo.GetTab(ctx, tabID)
+	if err != nil {
+		return tab, err
+	}
+	r.cache.put(key, cloneTab(tab), generation)
+	return tab, nil
+}
+func (r *cachedRepo) StoreTab(ctx context.Context, tab *api.Tab) error {
+	defer r.invalidateTab(tab.ID)
+	return r.repo.StoreTab(ctx, tab)
+}
+func (r *cachedRepo) DeleteTab(ctx context.Context, tabID int64) error {
+	defer r.invalidateTab(tabID)
+	return r.repo.DeleteTab(ctx, tabID)
+}
+
+func (r *cachedRepo) GetWidget(ctx context.Context, tabID int64, widgetID int64) (api.Widget, error) {
+	if r.txInvalidations != nil {
+		return r.repo.GetWidget(ctx, tabID, widgetID)
+	}
+
+	key := cacheKey{kind: cachedWidget, id: tabID, sub: widgetID}
+	value, ok, generation := r.cache.get(key)
+	if ok {
+		return cloneWidget(value.(api.Widget)), nil
+	}
+	widget, err := r.repo.GetWidget(ctx, tabID, widgetID)
+	if err != nil {
+		return widget, err
+	}
+	r.cache.put(key, cloneWidget(widget), generation)
+	return widget, nil
+}
+func (r *cachedRepo) StoreWidget(ctx context.Context, tabID int64, widget *api.Widget) error {
+	//The ID of a new widget is only known once stored
+	defer func() { r.invalidateWidget(tabID, widget.ID) }()
+	return r.repo.StoreWidget(ctx, tabID, widget)
+}
+func (r *cachedRepo) DeleteWidget(ctx context.Context, tabID int64, widgetID int64) error {
+	defer r.invalidateWidget(tabID, widgetID)
+	return r.repo.DeleteWidget(ctx, tabID, widgetID)
+}
+func (r *cachedRepo) UpdateTabLayout(ctx context.Context, tabID int64, layout [][]int64) error {
+	defer r.invalidateTab(tabID)
+	return r.repo.UpdateTabLayout(ctx, tabID, layout)
+}
+func (r *cachedRepo) DeleteWidgetFromTab(ctx context.Context, tabID int64, widgetID int64) error {
+	defer r.invalidateTab(tabID)
+	return r.repo.DeleteWidgetFromTab(ctx, tabID, widgetID)
+}
+
+//DeleteUser removes the tabs owned by the user, which are not known here
+func (r *cachedRepo) DeleteUser(ctx context.Context, userID string) error {
+	defer r.invalidateAllTabs()
+	return r.repo.DeleteUser(ctx, userID)
+}
+
+//UpgradeWidgetConfigs changes the configs of widgets of any tab
+func (r *cachedRepo) UpgradeWidgetConfigs(ctx context.Context, page api.PageRequest) (int, string, error) {
+	defer r.invalidateAllTabs()
+	return r.repo.UpgradeWidgetConfigs(ctx, page)
+}
+
+func (r *cachedRepo) GetFeed(ctx context.Context, feedID int64) (api.Feed, error) {
+	if r.txInvalidations != nil {
+		return r.repo.GetFeed(ctx, feedID)
+	}
+
+	key := cacheKey{kind: cachedFeed, id: feedID}
+	value, ok, generation := r.cache.get(key)
+	if ok {
+		return value.(api.Feed), nil
+	}
+	feed, err := r.repo.GetFeed(ctx, feedID)
+	if err != nil {
+		return feed, err
+	}
+	r.cache.put(key, feed, generation)
+	return feed, nil
+}
+func (r *cachedRepo) GetFeedItems(ctx context.Context, feedID int64) ([]api.FeedItem, error) {
+	if r.txInvalidations != nil {
+		return r.repo.GetFeedItems(ctx, feedID)
+	}
+
+	key := cacheKey{kind: cachedFeedItems, id: feedID}
+	value, ok, generation := r.cache.get(key)
+	if ok {
+		return append([]api.FeedItem{}, value.([]api.FeedItem)...), nil
+	}
+	items, err := r.repo.GetFeedItems(ctx, feedID)
+	if err != nil {
+		return items, err
+	}
+	r.cache.put(key, append([]api.FeedItem{}, items...), generation)
+	return items, nil
+}
+func (r *cachedRepo) StoreFeed(ctx context.Context, feed *api.Feed, feedItems []api.FeedItem) error {
+	defer func() { r.invalidateFeed(feed.ID) }()
+	return r.repo.StoreFeed(ctx, feed, feedItems)
+}
+func (r *cachedRepo) DeleteFeed(ctx context.Context, feedID int64) error {
+	defer r.invalidateFeed(feedID)
+	return r.repo.DeleteFeed(ctx, feedID)
+}
+func (r *cachedRepo) DeleteOrphanFeeds(ctx context.Context, retrievedBefore time.Time) (int64, error) {
+	defer r.invalidateAllFeeds()
+	return r.repo.DeleteOrphanFeeds(ctx, retrievedBefore)
+}
+
+func (r *cachedRepo) GetUser(ctx context.Context, userID string) (api.User, error) {
+	return r.repo.GetUser(ctx, userID)
+}
+func (r *cachedRepo) StoreUser(ctx context.Context, user *api.User) error {
+	return r.repo.StoreUser(ctx, user)
+}
+func (r *cachedRepo) SetUserTimeZone(ctx context.Context, userID string, timeZone string) error {
+	return r.repo.SetUserTimeZone(ctx, userID, timeZone)
+}
+func (r *cachedRepo) SetUserLocale(ctx context.Context, userID string, locale string) error {
+	return r.repo.SetUserLocale(ctx, userID, locale)
+}
+func (r *cachedRepo) SetUserAdmin(ctx context.Context, userID string, admin bool) error {
+	return r.repo.SetUserAdmin(ctx, userID, admin)
+}
+func (r *cachedRepo) GetUsersPage(ctx context.Context, page api.PageRequest) ([]api.User, string, error) {
+	return r.repo.GetUsersPage(ctx, page)
+}
+func (r *cachedRepo) GetUserStats(ctx context.Context, userID string) (api.UserStats, error) {
+	return r.repo.GetUserStats(ctx, userID)
+}
+func (r *cachedRepo) GetFeedStats(ctx context.Context) (api.FeedStats, error) {
+	return r.repo.GetFeedStats(ctx)
+}
+func (r *cachedRepo) GetTabs(ctx context.Context, userID string) ([]api.TabSummary, error) {
+	return r.repo.GetTabs(ctx, userID)
+}
+func (r *cachedRepo) GetOwnedTabIDs(ctx context.Context, userID string) ([]int64, error) {
+	return r.repo.GetOwnedTabIDs(ctx, userID)
+}
+func (r *cachedRepo) GetTabsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.TabSummary, string, error) {
+	return r.repo.GetTabsPage(ctx, userID, page)
+}
+func (r *cachedRepo) UpdateTabPositions(ctx context.Context, userID string, tabIDs []int64) error {
+	return r.repo.UpdateTabPositions(ctx, userID, tabIDs)
+}
+func (r *cachedRepo) GetTabSlug(ctx context.Context, userID string, slug string) (api.TabSlug, error) {
+	return r.repo.GetTabSlug(ctx, userID, slug)
+}
+func (r *cachedRepo) GetCurrentTabSlug(ctx context.Context, userID string, tabID int64) (string, error) {
+	return r.repo.GetCurrentTabSlug(ctx, userID, tabID)
+}
+func (r *cachedRepo) StoreTabSlug(ctx context.Context, slug api.TabSlug) error {
+	return r.repo.StoreTabSlug(ctx, slug)
+}
+func (r *cachedRepo) IsTabAccessAllowed(ctx context.Context, userID string, tabID int64) error {
+	return r.repo.IsTabAccessAllowed(ctx, userID, tabID)
+}
+func (r *cachedRepo) AllowTabAccess(ctx context.Context, userID string, tabID int64) error {
+	return r.repo.AllowTabAccess(ctx, userID, tabID)
+}
+func (r *cachedRepo) SetDefaultTab(ctx context.Context, userID string, tabID int64) error {
+	return r.repo.SetDefaultTab(ctx, userID, tabID)
+}
+func (r *cachedRepo) GetOrCreateFeedID(ctx context.Context, URL string) (int64, error) {
+	return r.repo.GetOrCreateFeedID(ctx, URL)
+}
+func (r *cachedRepo) GetFeedID(ctx context.Context, URL string) (int64, error) {
+	return r.repo.GetFeedID(ctx, URL)
+}
+func (r *cachedRepo) GetFeedsPage(ctx context.Context, page api.PageRequest) ([]api.Feed, string, error) {
+	return r.repo.GetFeedsPage(ctx, page)
+}
+func (r *cachedRepo) GetFeedItemsBefore(ctx context.Context, feedID int64, before api.ItemCursor, limit int) ([]api.FeedItem, error) {
+	return r.repo.GetFeedItemsBefore(ctx, feedID, before, limit)
+}
+func (r *cachedRepo) GetFeedItemsForUser(ctx context.Context, userID string, feedID int64, before api.ItemCursor, limit int) ([]api.ItemForUser, error) {
+	return r.repo.GetFeedItemsForUser(ctx, userID, feedID, before, limit)
+}
+func (r *cachedRepo) GetMostReadFeedIDs(ctx context.Context, userID string, limit int) ([]int64, error) {
+	return r.repo.GetMostReadFeedIDs(ctx, userID, limit)
+}
+func (r *cachedRepo) GetFeedUserIDs(ctx context.Context, feedID int64) ([]string, error) {
+	return r.repo.GetFeedUserIDs(ctx, feedID)
+}
+func (r *cachedRepo) GetReadItems(ctx context.Context, userID string, feedID int64) ([]string, error) {
+	return r.repo.GetReadItems(ctx, userID, feedID)
+}
+func (r *cachedRepo) SetItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) error {
+	return r.repo.SetItemRead(ctx, userID, feedID, guid, read)
+}
+func (r *cachedRepo) GetRankingModel(ctx context.Context, userID string) (api.RankingModel, error) {
+	return r.repo.GetRankingModel(ctx, userID)
+}
+func (r *cachedRepo) StoreRankingModel(ctx context.Context, model api.RankingModel) error {
+	return r.repo.StoreRankingModel(ctx, model)
+}
+func (r *cachedRepo) GetItemAbstracts(ctx context.Context, feedID int64, guids []string) ([]string, error) {
+	return r.repo.GetItemAbstracts(ctx, feedID, guids)
+}
+func (r *cachedRepo) StoreItemAbstract(ctx context.Context, feedID int64, guid string, abstract string) error {
+	return r.repo.StoreItemAbstract(ctx, feedID, guid, abstract)
+}
+func (r *cachedRepo) SetItemsRead(ctx context.Context, userID string, feedID int64, guid []string, read bool) (int64, error) {
+	return r.repo.SetItemsRead(ctx, userID, feedID, guid, read)
+}
+func (r *cachedRepo) GetAccount(ctx context.Context, userID string, accountID int64) (api.ExternalAccount, error) {
+	return r.repo.GetAccount(ctx, userID, accountID)
+}
+func (r *cachedRepo) GetAccounts(ctx context.Context, userID string) ([]api.ExternalAccount, error) {
+	return r.repo.GetAccounts(ctx, userID)
+}
+func (r *cachedRepo) GetAccountsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.ExternalAccount, string, error) {
+	return r.repo.GetAccountsPage(ctx, userID, page)
+}
+func (r *cachedRepo) DeleteAccount(ctx context.Context, userID string, accountID int64) error {
+	return r.repo.DeleteAccount(ctx, userID, accountID)
+}
+func (r *cachedRepo) MarkAccountNeedsReauth(ctx context.Context, accountID int64) error {
+	return r.repo.MarkAccountNeedsReauth(ctx, accountID)
+}
+func (r *cachedRepo) StoreAccount(ctx context.Context, userID string, account *api.ExternalAccount) error {
+	return r.repo.StoreAccount(ctx, userID, account)
+}
+func (r *cachedRepo) ReencryptTokens(ctx context.Context, page api.PageRequest) (int, string, error) {
+	return r.repo.ReencryptTokens(ctx, page)
+}
+func (r *cachedRepo) GetTemporaryCode(ctx context.Context, serviceName string, code string) (api.TemporaryCode, error) {
+	return r.repo.GetTemporaryCode(ctx, serviceName, code)
+}
+func (r *cachedRepo) StoreTemporaryCode(ctx context.Context, code api.TemporaryCode) error {
+	return r.repo.StoreTemporaryCode(ctx, code)
+}
+func (r *cachedRepo) DeleteTemporaryCode(ctx context.Context, userID string, serviceName string) error {
+	return r.repo.DeleteTemporaryCode(ctx, userID, serviceName)
+}
+func (r *cachedRepo) DeleteTemporaryCodesBefore(ctx context.Context, before time.Time) (int64, error) {
+	return r.repo.DeleteTemporaryCodesBefore(ctx, before)
+}
+func (r *cachedRepo) GetEmailItem(ctx context.Context, account api.ExternalAccount, guid string, minVersion uint64) (api.EmailItem, error) {
+	return r.repo.GetEmailItem(ctx, account, guid, minVersion)
+}
+func (r *cachedRepo) StoreEmailItem(ctx context.Context, account api.ExternalAccount, version uint64, item api.EmailItem) error {
+	return r.repo.StoreEmailItem(ctx, account, version, item)
+}
+func (r *cachedRepo) InvalidateEmailItems(ctx context.Context, providerName string, accountID string, version uint64) error {
+	return r.repo.InvalidateEmailItems(ctx, providerName, accountID, version)
+}
+func (r *cachedRepo) GetEmailSync(ctx context.Context, account api.ExternalAccount) (api.EmailSync, error) {
+	return r.repo.GetEmailSync(ctx, account)
+}
+func (r *cachedRepo) StoreEmailSync(ctx context.Context, sync api.EmailSync) error {
+	return r.repo.StoreEmailSync(ctx, sync)
+}
+func (r *cachedRepo) GetManagedPolicy(ctx context.Context, userID string) (api.ManagedPolicy, error) {
+	return r.repo.GetManagedPolicy(ctx, userID)
+}
+func (r *cachedRepo) StoreManagedPolicy(ctx context.Context, policy api.ManagedPolicy) error {
+	return r.repo.StoreManagedPolicy(ctx, policy)
+}
+func (r *cachedRepo) DeleteManagedPolicy(ctx context.Context, userID string) error {
+	return r.repo.DeleteManagedPolicy(ctx, userID)
+}
+func (r *cachedRepo) GetLinkPolicies(ctx context.Context, userID string) ([]api.LinkPolicy, error) {
+	return r.repo.GetLinkPolicies(ctx, userID)
+}
+func (r *cachedRepo) StoreLinkPolicies(ctx context.Context, userID string, policies []api.LinkPolicy) error {
+	return r.repo.StoreLinkPolicies(ctx, userID, policies)
+}
+func (r *cachedRepo) GetAPITokens(ctx context.Context, userID string) ([]api.APIToken, error) {
+	return r.repo.GetAPITokens(ctx, userID)
+}
+func (r *cachedRepo) GetAPITokenByHash(ctx context.Context, hash string) (api.APIToken, error) {
+	return r.repo.GetAPITokenByHash(ctx, hash)
+}
+func (r *cachedRepo) StoreAPIToken(ctx context.Context, token *api.APIToken) error {
+	return r.repo.StoreAPIToken(ctx, token)
+}
+func (r *cachedRepo) StoreAPITokenUse(ctx context.Context, tokenID int64, used time.Time) error {
+	return r.repo.StoreAPITokenUse(ctx, tokenID, used)
+}
+func (r *cachedRepo) DeleteAPIToken(ctx context.Context, userID string, tokenID int64) error {
+	return r.repo.DeleteAPIToken(ctx, userID, tokenID)
+}
+func (r *cachedRepo) GetRetentionPolicy(ctx context.Context, userID string) (api.RetentionPolicy, error) {
+	return r.repo.GetRetentionPolicy(ctx, userID)
+}
+func (r *cachedRepo) StoreRetentionPolicy(ctx context.Context, policy api.RetentionPolicy) error {
+	return r.repo.StoreRetentionPolicy(ctx, policy)
+}
+func (r *cachedRepo) CountReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return r.repo.CountReadItemsBefore(ctx, userID, before)
+}
+func (r *cachedRepo) DeleteReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return r.repo.DeleteReadItemsBefore(ctx, userID, before)
+}
+func (r *cachedRepo) CountClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return r.repo.CountClickHistoryBefore(ctx, userID, before)
+}
+func (r *cachedRepo) DeleteClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return r.repo.DeleteClickHistoryBefore(ctx, userID, before)
+}
+func (r *cachedRepo) CountEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return r.repo.CountEmailItemsBefore(ctx, userID, before)
+}
+func (r *cachedRepo) DeleteEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	return r.repo.DeleteEmailItemsBefore(ctx, userID, before)
+}
+func (r *cachedRepo) GetLastSnapshot(ctx context.Context, userID string) (api.StoredSnapshot, error) {
+	return r.repo.GetLastSnapshot(ctx, userID)
+}
+func (r *cachedRepo) StoreLastSnapshot(ctx context.Context, snapshot api.StoredSnapshot) error {
+	return r.repo.StoreLastSnapshot(ctx, snapshot)
+}
+func (r *cachedRepo) GetActivities(ctx context.Context, userID string, limit int) ([]api.Activity, error) {
+	return r.repo.GetActivities(ctx, userID, limit)
+}
+func (r *cachedRepo) StoreActivity(ctx context.Context, activity *api.Activity) error {
+	return r.repo.StoreActivity(ctx, activity)
+}
+func (r *cachedRepo) DeleteOldActivities(ctx context.Context, userID string, keep int) (int64, error) {
+	return r.repo.DeleteOldActivities(ctx, userID, keep)
+}
+func (r *cachedRepo) GetApprovalRequests(ctx context.Context, userID string) ([]api.ApprovalRequest, error) {
+	return r.repo.GetApprovalRequests(ctx, userID)
+}
+func (r *cachedRepo) StoreApprovalRequest(ctx context.Context, request *api.ApprovalRequest) error {
+	return r.repo.StoreApprovalRequest(ctx, request)
+}
+func (r *cachedRepo) GetStarredItems(ctx context.Context, userID string) ([]api.StarredItem, error) {
+	return r.repo.GetStarredItems(ctx, userID)
+}
+func (r *cachedRepo) StoreStarredItem(ctx context.Context, item api.StarredItem) error {
+	return r.repo.StoreStarredItem(ctx, item)
+}
+func (r *cachedRepo) DeleteStarredItem(ctx context.Context, userID string, feedID int64, guid string) error {
+	return r.repo.DeleteStarredItem(ctx, userID, feedID, guid)
+}
+func (r *cachedRepo) GetUserWebhook(ctx context.Context, userID string) (api.UserWebhook, error) {
+	return r.repo.GetUserWebhook(ctx, userID)
+}
+func (r *cachedRepo) StoreUserWebhook(ctx context.Context, webhook api.UserWebhook) error {
+	return r.repo.StoreUserWebhook(ctx, webhook)
+}
+func (r *cachedRepo) DeleteUserWebhook(ctx context.Context, userID string) error {
+	return r.repo.DeleteUserWebhook(ctx, userID)
+}
+func (r *cachedRepo) GetNotificationSettings(ctx context.Context, userID string) (api.NotificationSettings, error) {
+	return r.repo.GetNotificationSettings(ctx, userID)
+}
+func (r *cachedRepo) StoreNotificationSettings(ctx context.Context, settings api.NotificationSettings) error {
+	return r.repo.StoreNotificationSettings(ctx, settings)
+}
+func (r *cachedRepo) DeleteNotificationSettings(ctx context.Context, userID string) error {
+	return r.repo.DeleteNotificationSettings(ctx, userID)
+}
+func (r *cachedRepo) GetTags(ctx context.Context, userID string) ([]api.Tag, error) {
+	return r.repo.GetTags(ctx, userID)
+}
+func (r *cachedRepo) StoreTag(ctx context.Context, tag api.Tag) error {
+	return r.repo.StoreTag(ctx, tag)
+}
+func (r *cachedRepo) DeleteTag(ctx context.Context, userID string, name string) error {
+	return r.repo.DeleteTag(ctx, userID, name)
+}
+func (r *cachedRepo) GetWidgetViews(ctx context.Context, userID string) ([]api.WidgetView, error) {
+	return r.repo.GetWidgetViews(ctx, userID)
+}
+func (r *cachedRepo) RecordWidgetView(ctx context.Context, view api.WidgetView) error {
+	return r.repo.RecordWidgetView(ctx, view)
+}
+func (r *cachedRepo) GetViewedWidgets(ctx context.Context, since time.Time) ([]api.Widget, error) {
+	return r.repo.GetViewedWidgets(ctx, since)
+}
+func (r *cachedRepo) StoreAuditEvent(ctx context.Context, event *api.AuditEvent) error {
+	return r.repo.StoreAuditEvent(ctx, event)
+}
+func (r *cachedRepo) GetAuditEventsPage(ctx context.Context, filter api.AuditFilter, page api.PageRequest) ([]api.AuditEvent, string, error) {
+	return r.repo.GetAuditEventsPage(ctx, filter, page)
+}
+func (r *cachedRepo) AddUsage(ctx context.Context, userID string, day time.Time, usage api.Usage) error {
+	return r.repo.AddUsage(ctx, userID, day, usage)
+}
+func (r *cachedRepo) GetUsage(ctx context.Context, userID string, since time.Time) ([]api.DailyUsage, error) {
+	return r.repo.GetUsage(ctx, userID, since)
+}
+func (r *cachedRepo) DeleteUsageBefore(ctx context.Context, before time.Time) (int64, error) {
+	return r.repo.DeleteUsageBefore(ctx, before)
+}
@@ -2,198 +2,1120 @@ package repository
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/oki-apps/okihome/api"
 )
 
-//WithLock wraps a repository with read/write locking mechanism
-func WithLock(r api.Repository) api.Repository {
+//slowLockWait is the wait for the lock above which the acquisition is logged
+const slowLockWait = 100 * time.Millisecond
+
+//WithLock wraps a repository with read/write locking mechanism.
+//The calls wait for the lock until their context is done, failing with a LockTimeout error,
+//so that a slow write does not hang the other calls forever.
+//The slow acquisitions are logged at Debug level and the timeouts at Warning level, if a LogInteractor is given.
+func WithLock(r api.Repository, l api.LogInteractor) api.Repository {
 	return &lockedRepo{
-		repo: r,
+		repo:       r,
+		log:        l,
+		rwMutex:    newCtxRWMutex(),
+		contention: newLockContention(),
 	}
 }
 
+//LockTimeout is returned when the lock of the repository was not acquired before the end of the context of the call
+type LockTimeout struct {
+	//Call is the method and its arguments
+	Call   string
+	Write  bool
+	Waited time.Duration
+	//Err is the error of the context
+	Err error
+}
+
+func (err LockTimeout) Error() string {
+	return fmt.Sprintf("repository lock not acquired by %s after %s: %s", err.Call, err.Waited, err.Err)
+}
+
+//IsUnavailable flags the error as a temporary unavailability of the repository
+func (err LockTimeout) IsUnavailable() bool {
+	return true
+}
+
 type lockedRepo struct {
-	repo    api.Repository
-	rwMutex sync.RWMutex
+	repo       api.Repository
+	log        api.LogInteractor
+	rwMutex    *ctxRWMutex
+	contention *lockContention
+}
+
+//LockStats returns the calls holding or waiting for the lock and the wait times of the methods
+func (r *lockedRepo) LockStats() (api.LockStats, error) {
+	return r.contention.stats(), nil
 }
 
 func (r *lockedRepo) IsNotFound(err error) bool {
 	return r.repo.IsNotFound(err)
 }
 
-func (r *lockedRepo) rlock(args ...interface{}) {
-	log.Println("Waiting for read lock", args)
-	r.rwMutex.RLock()
-	log.Println("Read lock", args)
+//Close waits for the calls in progress before closing the repository
+func (r *lockedRepo) Close() error {
+	//The background context never ends, the lock is always acquired
+	r.lock(context.Background(), "Close")
+	defer r.unlock(context.Background(), "Close")
+	return r.repo.Close()
+}
+
+func (r *lockedRepo) Optimize(ctx context.Context) error {
+	if err := r.lock(ctx, "Optimize"); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "Optimize")
+	return r.repo.Optimize(ctx)
+}
+
+//RunInTransaction holds the write lock during the whole transaction.
+//The repository given to f is not locked, to avoid deadlocks.
+func (r *lockedRepo) RunInTransaction(ctx context.Context, f func(repo api.Repository) error) error {
+	if err := r.lock(ctx, "RunInTransaction"); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "RunInTransaction")
+	return r.repo.RunInTransaction(ctx, f)
+}
+
+func (r *lockedRepo) rlock(ctx context.Context, args ...interface{}) error {
+	return r.acquire(ctx, false, args)
+}
+func (r *lockedRepo) runlock(ctx context.Context, args ...interface{}) {
+	r.rwMutex.release(false)
+	r.contention.released(false, args)
+}
+func (r *lockedRepo) lock(ctx context.Context, args ...interface{}) error {
+	return r.acquire(ctx, true, args)
+}
+func (r *lockedRepo) unlock(ctx context.Context, args ...interface{}) {
+	r.rwMutex.release(true)
+	r.contention.released(true, args)
+}
+
+//acquire waits for the lock until the context is done
+func (r *lockedRepo) acquire(ctx context.Context, write bool, args []interface{}) error {
+	call := r.contention.startWaiting(write, args)
+	err := r.rwMutex.acquire(ctx, write)
+	waited := time.Since(call.since)
+	if err != nil {
+		r.contention.gaveUp(call)
+		timeout := LockTimeout{Call: fmt.Sprint(args), Write: write, Waited: waited, Err: err}
+		if r.log != nil {
+			r.log.Warnf(ctx, "%s", timeout)
+		}
+		return timeout
+	}
+
+	r.contention.acquired(call)
+	if waited > slowLockWait && r.log != nil {
+		r.log.Debugf(ctx, "Lock acquired by %v after %s", args, waited)
+	}
+	return nil
+}
+
+//ctxRWMutex is a reader/writer lock whose acquisition can be abandoned when a context is done.
+//A waiting writer blocks the new readers, so that the writes are not starved by the reads.
+type ctxRWMutex struct {
+	mutex          sync.Mutex
+	readers        int
+	writer         bool
+	waitingWriters int
+	//changed is closed, and replaced, when the lock is released
+	changed chan struct{}
+}
+
+func newCtxRWMutex() *ctxRWMutex {
+	return &ctxRWMutex{changed: make(chan struct{})}
+}
+
+func (m *ctxRWMutex) acquire(ctx context.Context, write bool) error {
+	m.mutex.Lock()
+	if write {
+		m.waitingWriters++
+	}
+	for {
+		if write && !m.writer && m.readers == 0 {
+			m.waitingWriters--
+			m.writer = true
+			m.mutex.Unlock()
+			return nil
+		}
+		if !write && !m.writer && m.waitingWriters == 0 {
+			m.readers++
+			m.mutex.Unlock()
+			return nil
+		}
+		changed := m.changed
+		m.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			m.mutex.Lock()
+			if write {
+				m.waitingWriters--
+				//The readers blocked by this writer may proceed
+				m.notify()
+			}
+			m.mutex.Unlock()
+			return ctx.Err()
+		}
+		m.mutex.Lock()
+	}
+}
+
+func (m *ctxRWMutex) release(write bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if write {
+		m.writer = false
+	} else {
+		m.readers--
+	}
+	m.notify()
+}
+
+//notify wakes up the waiting calls, the mutex being held
+func (m *ctxRWMutex) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+//lockContention tracks the calls holding or waiting for the lock and how long the methods waited for it
+type lockContention struct {
+	mutex sync.Mutex
+	//held and waiting list the calls by method, the oldest first
+	held    map[string][]*lockCall
+	waiting map[string][]*lockCall
+	waits   map[string]*api.LockWait
+}
+
+//lockCall is a call holding or waiting for the lock.
+//Its arguments are only formatted when the calls are listed, not on every call.
+type lockCall struct {
+	args  []interface{}
+	write bool
+	//since is the start of the wait, then the acquisition of the lock
+	since time.Time
+}
+
+//method returns the name of the method of the call, given as first argument
+func (call *lockCall) method() string {
+	method, _ := call.args[0].(string)
+	return method
 }
-func (r *lockedRepo) runlock(args ...interface{}) {
-	r.rwMutex.RUnlock()
-	log.Println("Read unlock", args)
+
+func newLockContention() *lockContention {
+	return &lockContention{
+		held:    make(map[string][]*lockCall),
+		waiting: make(map[string][]*lockCall),
+		waits:   make(map[string]*api.LockWait),
+	}
+}
+
+//startWaiting records a call starting to wait for the lock
+func (c *lockContention) startWaiting(write bool, args []interface{}) *lockCall {
+	call := &lockCall{args: args, write: write, since: time.Now()}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.waiting[call.method()] = append(c.waiting[call.method()], call)
+	return call
+}
+
+//acquired records a call getting the lock
+func (c *lockContention) acquired(call *lockCall) {
+	now := time.Now()
+	wait := now.Sub(call.since)
+	method := call.method()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	removeCall(c.waiting, method, call)
+	call.since = now
+	c.held[method] = append(c.held[method], call)
+
+	w := c.wait(method)
+	w.Count++
+	w.Total += wait
+	if wait > w.Max {
+		w.Max = wait
+	}
+	bucket := sort.Search(len(api.LockWaitBuckets), func(i int) bool { return wait <= api.LockWaitBuckets[i] })
+	w.Buckets[bucket]++
+}
+
+//gaveUp records a call abandoning the wait for the lock
+func (c *lockContention) gaveUp(call *lockCall) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	removeCall(c.waiting, call.method(), call)
+	c.wait(call.method()).Timeouts++
+}
+
+//wait returns the wait times of the method, the mutex being held
+func (c *lockContention) wait(method string) *api.LockWait {
+	w, ok := c.waits[method]
+	if !ok {
+		w = &api.LockWait{Method: method, Buckets: make([]int64, len(api.LockWaitBuckets)+1)}
+		c.waits[method] = w
+	}
+	return w
+}
+
+//released records a call releasing the lock.
+//The calls with the same arguments are not distinguished, the oldest one is considered released.
+func (c *lockContention) released(write bool, args []interface{}) {
+	released := lockCall{args: args, write: write}
+	method := released.method()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, call := range c.held[method] {
+		if call.write == write && reflect.DeepEqual(call.args, args) {
+			removeCall(c.held, method, call)
+			return
+		}
+	}
+}
+
+func (c *lockContention) stats() api.LockStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := api.LockStats{
+		Held:    lockHolders(c.held),
+		Waiting: lockHolders(c.waiting),
+		Waits:   make([]api.LockWait, 0, len(c.waits)),
+	}
+	for _, w := range c.waits {
+		wait := *w
+		wait.Buckets = append([]int64{}, w.Buckets...)
+		stats.Waits = append(stats.Waits, wait)
+	}
+	sort.Slice(stats.Waits, func(i, j int) bool { return stats.Waits[i].Total > stats.Waits[j].Total })
+
+	return stats
 }
-func (r *lockedRepo) lock(args ...interface{}) {
-	log.Println("Waiting for write lock", args)
-	r.rwMutex.Lock()
-	log.Println("Write lock", args)
+
+//lockHolders lists the calls, the oldest first
+func lockHolders(calls map[string][]*lockCall) []api.LockHolder {
+	holders := []api.LockHolder{}
+	for _, list := range calls {
+		for _, call := range list {
+			holders = append(holders, api.LockHolder{Call: fmt.Sprint(call.args), Write: call.write, Since: call.since})
+		}
+	}
+	sort.Slice(holders, func(i, j int) bool { return holders[i].Since.Before(holders[j].Since) })
+	return holders
 }
-func (r *lockedRepo) unlock(args ...interface{}) {
-	r.rwMutex.Unlock()
-	log.Println("Write unlock", args)
+
+//removeCall removes a call from the calls of its method, the mutex being held
+func removeCall(calls map[string][]*lockCall, method string, call *lockCall) {
+	list := calls[method]
+	for i, e := range list {
+		if e == call {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(calls, method)
+	} else {
+		calls[method] = list
+	}
 }
 
 func (r *lockedRepo) GetUser(ctx context.Context, userID string) (api.User, error) {
-	r.rlock("GetUser", userID)
-	defer r.runlock("GetUser", userID)
+	if err := r.rlock(ctx, "GetUser", userID); err != nil {
+		return api.User{}, err
+	}
+	defer r.runlock(ctx, "GetUser", userID)
 	return r.repo.GetUser(ctx, userID)
 }
+func (r *lockedRepo) GetUsersPage(ctx context.Context, page api.PageRequest) ([]api.User, string, error) {
+	if err := r.rlock(ctx, "GetUsersPage", page.Cursor); err != nil {
+		return nil, "", err
+	}
+	defer r.runlock(ctx, "GetUsersPage", page.Cursor)
+	return r.repo.GetUsersPage(ctx, page)
+}
+func (r *lockedRepo) GetUserStats(ctx context.Context, userID string) (api.UserStats, error) {
+	if err := r.rlock(ctx, "GetUserStats", userID); err != nil {
+		return api.UserStats{}, err
+	}
+	defer r.runlock(ctx, "GetUserStats", userID)
+	return r.repo.GetUserStats(ctx, userID)
+}
+func (r *lockedRepo) GetFeedStats(ctx context.Context) (api.FeedStats, error) {
+	if err := r.rlock(ctx, "GetFeedStats"); err != nil {
+		return api.FeedStats{}, err
+	}
+	defer r.runlock(ctx, "GetFeedStats")
+	return r.repo.GetFeedStats(ctx)
+}
 func (r *lockedRepo) StoreUser(ctx context.Context, user *api.User) error {
-	r.lock("StoreUSer")
-	defer r.unlock("StoreUSer")
+	if err := r.lock(ctx, "StoreUSer"); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreUSer")
 	return r.repo.StoreUser(ctx, user)
 }
 
+func (r *lockedRepo) SetUserTimeZone(ctx context.Context, userID string, timeZone string) error {
+	if err := r.lock(ctx, "SetUserTimeZone", userID, timeZone); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "SetUserTimeZone", userID, timeZone)
+	return r.repo.SetUserTimeZone(ctx, userID, timeZone)
+}
+func (r *lockedRepo) SetUserLocale(ctx context.Context, userID string, locale string) error {
+	if err := r.lock(ctx, "SetUserLocale", userID, locale); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "SetUserLocale", userID, locale)
+	return r.repo.SetUserLocale(ctx, userID, locale)
+}
+func (r *lockedRepo) SetUserAdmin(ctx context.Context, userID string, admin bool) error {
+	if err := r.lock(ctx, "SetUserAdmin", userID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "SetUserAdmin", userID)
+	return r.repo.SetUserAdmin(ctx, userID, admin)
+}
+
+func (r *lockedRepo) DeleteUser(ctx context.Context, userID string) error {
+	if err := r.lock(ctx, "DeleteUser", userID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteUser", userID)
+	return r.repo.DeleteUser(ctx, userID)
+}
+
 func (r *lockedRepo) GetTabs(ctx context.Context, userID string) ([]api.TabSummary, error) {
-	r.rlock("GetTabs", userID)
-	defer r.runlock("GetTabs", userID)
+	if err := r.rlock(ctx, "GetTabs", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetTabs", userID)
 	return r.repo.GetTabs(ctx, userID)
 }
+func (r *lockedRepo) GetOwnedTabIDs(ctx context.Context, userID string) ([]int64, error) {
+	if err := r.rlock(ctx, "GetOwnedTabIDs", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetOwnedTabIDs", userID)
+	return r.repo.GetOwnedTabIDs(ctx, userID)
+}
+func (r *lockedRepo) GetTabsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.TabSummary, string, error) {
+	if err := r.rlock(ctx, "GetTabsPage", userID, page.Cursor); err != nil {
+		return nil, "", err
+	}
+	defer r.runlock(ctx, "GetTabsPage", userID, page.Cursor)
+	return r.repo.GetTabsPage(ctx, userID, page)
+}
+func (r *lockedRepo) UpdateTabPositions(ctx context.Context, userID string, tabIDs []int64) error {
+	if err := r.lock(ctx, "UpdateTabPositions", userID, tabIDs); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "UpdateTabPositions", userID, tabIDs)
+	return r.repo.UpdateTabPositions(ctx, userID, tabIDs)
+}
+func (r *lockedRepo) GetTabSlug(ctx context.Context, userID string, slug string) (api.TabSlug, error) {
+	if err := r.rlock(ctx, "GetTabSlug", userID, slug); err != nil {
+		return api.TabSlug{}, err
+	}
+	defer r.runlock(ctx, "GetTabSlug", userID, slug)
+	return r.repo.GetTabSlug(ctx, userID, slug)
+}
+func (r *lockedRepo) GetCurrentTabSlug(ctx context.Context, userID string, tabID int64) (string, error) {
+	if err := r.rlock(ctx, "GetCurrentTabSlug", userID, tabID); err != nil {
+		return "", err
+	}
+	defer r.runlock(ctx, "GetCurrentTabSlug", userID, tabID)
+	return r.repo.GetCurrentTabSlug(ctx, userID, tabID)
+}
+func (r *lockedRepo) StoreTabSlug(ctx context.Context, slug api.TabSlug) error {
+	if err := r.lock(ctx, "StoreTabSlug", slug.UserID, slug.Slug); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreTabSlug", slug.UserID, slug.Slug)
+	return r.repo.StoreTabSlug(ctx, slug)
+}
 func (r *lockedRepo) IsTabAccessAllowed(ctx context.Context, userID string, tabID int64) error {
-	r.rlock("IsTabAccessAllowed", userID, tabID)
-	defer r.runlock("IsTabAccessAllowed", userID, tabID)
+	if err := r.rlock(ctx, "IsTabAccessAllowed", userID, tabID); err != nil {
+		return err
+	}
+	defer r.runlock(ctx, "IsTabAccessAllowed", userID, tabID)
 	return r.repo.IsTabAccessAllowed(ctx, userID, tabID)
 }
 func (r *lockedRepo) AllowTabAccess(ctx context.Context, userID string, tabID int64) error {
-	r.lock("AllowTabAccess", userID, tabID)
-	defer r.unlock("AllowTabAccess", userID, tabID)
+	if err := r.lock(ctx, "AllowTabAccess", userID, tabID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "AllowTabAccess", userID, tabID)
 	return r.repo.AllowTabAccess(ctx, userID, tabID)
 }
+func (r *lockedRepo) SetDefaultTab(ctx context.Context, userID string, tabID int64) error {
+	if err := r.lock(ctx, "SetDefaultTab", userID, tabID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "SetDefaultTab", userID, tabID)
+	return r.repo.SetDefaultTab(ctx, userID, tabID)
+}
 
 func (r *lockedRepo) GetTab(ctx context.Context, tabID int64) (api.Tab, error) {
-	r.rlock("GetTab", tabID)
-	defer r.runlock("GetTab", tabID)
+	if err := r.rlock(ctx, "GetTab", tabID); err != nil {
+		return api.Tab{}, err
+	}
+	defer r.runlock(ctx, "GetTab", tabID)
 	return r.repo.GetTab(ctx, tabID)
 }
 func (r *lockedRepo) StoreTab(ctx context.Context, tab *api.Tab) error {
-	r.lock("StoreTab")
-	defer r.unlock("StoreTab")
+	if err := r.lock(ctx, "StoreTab"); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreTab")
 	return r.repo.StoreTab(ctx, tab)
 }
 func (r *lockedRepo) DeleteTab(ctx context.Context, tabID int64) error {
-	r.lock("DeleteTab", tabID)
-	defer r.unlock("DeleteTab", tabID)
+	if err := r.lock(ctx, "DeleteTab", tabID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteTab", tabID)
 	return r.repo.DeleteTab(ctx, tabID)
 }
 
 func (r *lockedRepo) GetWidget(ctx context.Context, tabID int64, widgetID int64) (api.Widget, error) {
-	r.rlock("GetWidget", tabID, widgetID)
-	defer r.runlock("GetWidget", tabID, widgetID)
+	if err := r.rlock(ctx, "GetWidget", tabID, widgetID); err != nil {
+		return api.Widget{}, err
+	}
+	defer r.runlock(ctx, "GetWidget", tabID, widgetID)
 	return r.repo.GetWidget(ctx, tabID, widgetID)
 }
 func (r *lockedRepo) StoreWidget(ctx context.Context, tabID int64, widget *api.Widget) error {
-	r.lock("StoreWidget", tabID)
-	defer r.unlock("StoreWidget", tabID)
+	if err := r.lock(ctx, "StoreWidget", tabID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreWidget", tabID)
 	return r.repo.StoreWidget(ctx, tabID, widget)
 }
 func (r *lockedRepo) DeleteWidget(ctx context.Context, tabID int64, widgetID int64) error {
-	r.lock("DeleteWidget", tabID, widgetID)
-	defer r.unlock("DeleteWidget", tabID, widgetID)
+	if err := r.lock(ctx, "DeleteWidget", tabID, widgetID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteWidget", tabID, widgetID)
 	return r.repo.DeleteWidget(ctx, tabID, widgetID)
 }
 
 func (r *lockedRepo) UpdateTabLayout(ctx context.Context, tabID int64, layout [][]int64) error {
-	r.lock("UpdateTabLayout", tabID)
-	defer r.unlock("UpdateTabLayout", tabID)
+	if err := r.lock(ctx, "UpdateTabLayout", tabID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "UpdateTabLayout", tabID)
 	return r.repo.UpdateTabLayout(ctx, tabID, layout)
 }
 func (r *lockedRepo) DeleteWidgetFromTab(ctx context.Context, tabID int64, widgetID int64) error {
-	r.lock("DeleteWidgetFromTab", tabID, widgetID)
-	defer r.unlock("DeleteWidgetFromTab", tabID, widgetID)
+	if err := r.lock(ctx, "DeleteWidgetFromTab", tabID, widgetID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteWidgetFromTab", tabID, widgetID)
 	return r.repo.DeleteWidgetFromTab(ctx, tabID, widgetID)
 }
 
 func (r *lockedRepo) GetOrCreateFeedID(ctx context.Context, URL string) (int64, error) {
-	r.lock("GetOrCreateFeedID", URL)
-	defer r.unlock("GetOrCreateFeedID", URL)
+	if err := r.lock(ctx, "GetOrCreateFeedID", URL); err != nil {
+		return 0, err
+	}
+	defer r.unlock(ctx, "GetOrCreateFeedID", URL)
 	return r.repo.GetOrCreateFeedID(ctx, URL)
 }
+func (r *lockedRepo) GetFeedID(ctx context.Context, URL string) (int64, error) {
+	if err := r.rlock(ctx, "GetFeedID", URL); err != nil {
+		return 0, err
+	}
+	defer r.runlock(ctx, "GetFeedID", URL)
+	return r.repo.GetFeedID(ctx, URL)
+}
 func (r *lockedRepo) GetFeed(ctx context.Context, feedID int64) (api.Feed, error) {
-	r.rlock("GetFeed", feedID)
-	defer r.runlock("GetFeed", feedID)
+	if err := r.rlock(ctx, "GetFeed", feedID); err != nil {
+		return api.Feed{}, err
+	}
+	defer r.runlock(ctx, "GetFeed", feedID)
 	return r.repo.GetFeed(ctx, feedID)
 }
+func (r *lockedRepo) GetFeedsPage(ctx context.Context, page api.PageRequest) ([]api.Feed, string, error) {
+	if err := r.rlock(ctx, "GetFeedsPage", page.Cursor); err != nil {
+		return nil, "", err
+	}
+	defer r.runlock(ctx, "GetFeedsPage", page.Cursor)
+	return r.repo.GetFeedsPage(ctx, page)
+}
 func (r *lockedRepo) GetFeedItems(ctx context.Context, feedID int64) ([]api.FeedItem, error) {
-	r.rlock("GetFeedItems", feedID)
-	defer r.runlock("GetFeedItems", feedID)
+	if err := r.rlock(ctx, "GetFeedItems", feedID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetFeedItems", feedID)
 	return r.repo.GetFeedItems(ctx, feedID)
 }
+func (r *lockedRepo) GetFeedItemsBefore(ctx context.Context, feedID int64, before api.ItemCursor, limit int) ([]api.FeedItem, error) {
+	if err := r.rlock(ctx, "GetFeedItemsBefore", feedID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetFeedItemsBefore", feedID)
+	return r.repo.GetFeedItemsBefore(ctx, feedID, before, limit)
+}
+func (r *lockedRepo) GetFeedItemsForUser(ctx context.Context, userID string, feedID int64, before api.ItemCursor, limit int) ([]api.ItemForUser, error) {
+	if err := r.rlock(ctx, "GetFeedItemsForUser", userID, feedID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetFeedItemsForUser", userID, feedID)
+	return r.repo.GetFeedItemsForUser(ctx, userID, feedID, before, limit)
+}
+func (r *lockedRepo) GetMostReadFeedIDs(ctx context.Context, userID string, limit int) ([]int64, error) {
+	if err := r.rlock(ctx, "GetMostReadFeedIDs", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetMostReadFeedIDs", userID)
+	return r.repo.GetMostReadFeedIDs(ctx, userID, limit)
+}
+func (r *lockedRepo) GetFeedUserIDs(ctx context.Context, feedID int64) ([]string, error) {
+	if err := r.rlock(ctx, "GetFeedUserIDs"); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetFeedUserIDs")
+	return r.repo.GetFeedUserIDs(ctx, feedID)
+}
 func (r *lockedRepo) StoreFeed(ctx context.Context, feed *api.Feed, feedItems []api.FeedItem) error {
-	r.lock("StoreFeed")
-	defer r.unlock("StoreFeed")
+	if err := r.lock(ctx, "StoreFeed"); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreFeed")
 	return r.repo.StoreFeed(ctx, feed, feedItems)
 }
+func (r *lockedRepo) DeleteFeed(ctx context.Context, feedID int64) error {
+	if err := r.lock(ctx, "DeleteFeed", feedID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteFeed", feedID)
+	return r.repo.DeleteFeed(ctx, feedID)
+}
+func (r *lockedRepo) DeleteOrphanFeeds(ctx context.Context, retrievedBefore time.Time) (int64, error) {
+	if err := r.lock(ctx, "DeleteOrphanFeeds"); err != nil {
+		return 0, err
+	}
+	defer r.unlock(ctx, "DeleteOrphanFeeds")
+	return r.repo.DeleteOrphanFeeds(ctx, retrievedBefore)
+}
 
-func (r *lockedRepo) AreItemsRead(ctx context.Context, userID string, feedID int64, guids []string) ([]bool, error) {
-	r.rlock("AreItemsRead", userID, feedID)
-	defer r.runlock("AreItemsRead", userID, feedID)
-	return r.repo.AreItemsRead(ctx, userID, feedID, guids)
+func (r *lockedRepo) GetReadItems(ctx context.Context, userID string, feedID int64) ([]string, error) {
+	if err := r.rlock(ctx, "GetReadItems", userID, feedID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetReadItems", userID, feedID)
+	return r.repo.GetReadItems(ctx, userID, feedID)
+}
+func (r *lockedRepo) GetRankingModel(ctx context.Context, userID string) (api.RankingModel, error) {
+	if err := r.rlock(ctx, "GetRankingModel", userID); err != nil {
+		return api.RankingModel{}, err
+	}
+	defer r.runlock(ctx, "GetRankingModel", userID)
+	return r.repo.GetRankingModel(ctx, userID)
+}
+func (r *lockedRepo) StoreRankingModel(ctx context.Context, model api.RankingModel) error {
+	if err := r.lock(ctx, "StoreRankingModel", model.UserID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreRankingModel", model.UserID)
+	return r.repo.StoreRankingModel(ctx, model)
+}
+func (r *lockedRepo) GetItemAbstracts(ctx context.Context, feedID int64, guids []string) ([]string, error) {
+	if err := r.rlock(ctx, "GetItemAbstracts", feedID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetItemAbstracts", feedID)
+	return r.repo.GetItemAbstracts(ctx, feedID, guids)
+}
+func (r *lockedRepo) StoreItemAbstract(ctx context.Context, feedID int64, guid string, abstract string) error {
+	if err := r.lock(ctx, "StoreItemAbstract", feedID, guid); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreItemAbstract", feedID, guid)
+	return r.repo.StoreItemAbstract(ctx, feedID, guid, abstract)
 }
 func (r *lockedRepo) SetItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) error {
-	r.lock("SetItemRead", userID, feedID, guid)
-	defer r.unlock("SetItemRead", userID, feedID, guid)
+	if err := r.lock(ctx, "SetItemRead", userID, feedID, guid); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "SetItemRead", userID, feedID, guid)
 	return r.repo.SetItemRead(ctx, userID, feedID, guid, read)
 }
-func (r *lockedRepo) SetItemsRead(ctx context.Context, userID string, feedID int64, guid []string, read bool) error {
-	r.lock("SetItemsRead", userID, feedID)
-	defer r.unlock("SetItemsRead", userID, feedID)
+func (r *lockedRepo) SetItemsRead(ctx context.Context, userID string, feedID int64, guid []string, read bool) (int64, error) {
+	if err := r.lock(ctx, "SetItemsRead", userID, feedID); err != nil {
+		return 0, err
+	}
+	defer r.unlock(ctx, "SetItemsRead", userID, feedID)
 	return r.repo.SetItemsRead(ctx, userID, feedID, guid, read)
 }
 
 func (r *lockedRepo) GetAccount(ctx context.Context, userID string, accountID int64) (api.ExternalAccount, error) {
-	r.rlock("GetAccount", userID, accountID)
-	defer r.runlock("GetAccount", userID, accountID)
+	if err := r.rlock(ctx, "GetAccount", userID, accountID); err != nil {
+		return api.ExternalAccount{}, err
+	}
+	defer r.runlock(ctx, "GetAccount", userID, accountID)
 	return r.repo.GetAccount(ctx, userID, accountID)
 }
 func (r *lockedRepo) GetAccounts(ctx context.Context, userID string) ([]api.ExternalAccount, error) {
-	r.rlock("GetAccounts", userID)
-	defer r.runlock("GetAccounts", userID)
+	if err := r.rlock(ctx, "GetAccounts", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetAccounts", userID)
 	return r.repo.GetAccounts(ctx, userID)
 }
+func (r *lockedRepo) GetAccountsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.ExternalAccount, string, error) {
+	if err := r.rlock(ctx, "GetAccountsPage", userID, page.Cursor); err != nil {
+		return nil, "", err
+	}
+	defer r.runlock(ctx, "GetAccountsPage", userID, page.Cursor)
+	return r.repo.GetAccountsPage(ctx, userID, page)
+}
 func (r *lockedRepo) DeleteAccount(ctx context.Context, userID string, accountID int64) error {
-	r.lock("DeleteAccount", userID, accountID)
-	defer r.unlock("DeleteAccount", userID, accountID)
+	if err := r.lock(ctx, "DeleteAccount", userID, accountID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteAccount", userID, accountID)
 	return r.repo.DeleteAccount(ctx, userID, accountID)
 }
+func (r *lockedRepo) UpgradeWidgetConfigs(ctx context.Context, page api.PageRequest) (int, string, error) {
+	if err := r.lock(ctx, "UpgradeWidgetConfigs", page.Cursor); err != nil {
+		return 0, "", err
+	}
+	defer r.unlock(ctx, "UpgradeWidgetConfigs", page.Cursor)
+	return r.repo.UpgradeWidgetConfigs(ctx, page)
+}
+func (r *lockedRepo) ReencryptTokens(ctx context.Context, page api.PageRequest) (int, string, error) {
+	if err := r.lock(ctx, "ReencryptTokens", page.Cursor); err != nil {
+		return 0, "", err
+	}
+	defer r.unlock(ctx, "ReencryptTokens", page.Cursor)
+	return r.repo.ReencryptTokens(ctx, page)
+}
+func (r *lockedRepo) MarkAccountNeedsReauth(ctx context.Context, accountID int64) error {
+	if err := r.lock(ctx, "MarkAccountNeedsReauth", accountID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "MarkAccountNeedsReauth", accountID)
+	return r.repo.MarkAccountNeedsReauth(ctx, accountID)
+}
 func (r *lockedRepo) StoreAccount(ctx context.Context, userID string, account *api.ExternalAccount) error {
-	r.lock("StoreAccount", userID)
-	defer r.unlock("StoreAccount", userID)
+	if err := r.lock(ctx, "StoreAccount", userID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreAccount", userID)
 	return r.repo.StoreAccount(ctx, userID, account)
 }
 
-func (r *lockedRepo) GetUserFromTemporaryCode(ctx context.Context, serviceName string, code string) (string, error) {
-	r.rlock("GetUserFromTemporaryCode", serviceName)
-	defer r.runlock("GetUserFromTemporaryCode", serviceName)
-	return r.repo.GetUserFromTemporaryCode(ctx, serviceName, code)
+func (r *lockedRepo) GetTemporaryCode(ctx context.Context, serviceName string, code string) (api.TemporaryCode, error) {
+	if err := r.rlock(ctx, "GetTemporaryCode", serviceName); err != nil {
+		return api.TemporaryCode{}, err
+	}
+	defer r.runlock(ctx, "GetTemporaryCode", serviceName)
+	return r.repo.GetTemporaryCode(ctx, serviceName, code)
 }
-func (r *lockedRepo) StoreTemporaryCode(ctx context.Context, userID string, serviceName string, code string) error {
-	r.lock("StoreTemporaryCode", userID, serviceName)
-	defer r.unlock("StoreTemporaryCode", userID)
-	return r.repo.StoreTemporaryCode(ctx, userID, serviceName, code)
+func (r *lockedRepo) StoreTemporaryCode(ctx context.Context, code api.TemporaryCode) error {
+	if err := r.lock(ctx, "StoreTemporaryCode", code.UserID, code.ProviderName); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreTemporaryCode", code.UserID, code.ProviderName)
+	return r.repo.StoreTemporaryCode(ctx, code)
 }
 func (r *lockedRepo) DeleteTemporaryCode(ctx context.Context, userID string, serviceName string) error {
-	r.lock("DeleteTemporaryCode", userID, serviceName)
-	defer r.unlock("DeleteTemporaryCode", userID, serviceName)
+	if err := r.lock(ctx, "DeleteTemporaryCode", userID, serviceName); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteTemporaryCode", userID, serviceName)
 	return r.repo.DeleteTemporaryCode(ctx, userID, serviceName)
 }
+func (r *lockedRepo) DeleteTemporaryCodesBefore(ctx context.Context, before time.Time) (int64, error) {
+	if err := r.lock(ctx, "DeleteTemporaryCodesBefore", before); err != nil {
+		return 0, err
+	}
+	defer r.unlock(ctx, "DeleteTemporaryCodesBefore", before)
+	return r.repo.DeleteTemporaryCodesBefore(ctx, before)
+}
 
 func (r *lockedRepo) GetEmailItem(ctx context.Context, account api.ExternalAccount, guid string, minVersion uint64) (api.EmailItem, error) {
-	r.rlock("GetEmailItem")
-	defer r.runlock("GetEmailItem")
+	if err := r.rlock(ctx, "GetEmailItem"); err != nil {
+		return api.EmailItem{}, err
+	}
+	defer r.runlock(ctx, "GetEmailItem")
 	return r.repo.GetEmailItem(ctx, account, guid, minVersion)
 }
 func (r *lockedRepo) StoreEmailItem(ctx context.Context, account api.ExternalAccount, version uint64, item api.EmailItem) error {
-	r.lock("StoreEmailItem")
-	defer r.unlock("StoreEmailItem")
+	if err := r.lock(ctx, "StoreEmailItem"); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreEmailItem")
 	return r.repo.StoreEmailItem(ctx, account, version, item)
 }
+func (r *lockedRepo) InvalidateEmailItems(ctx context.Context, providerName string, accountID string, version uint64) error {
+	if err := r.lock(ctx, "InvalidateEmailItems", providerName, accountID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "InvalidateEmailItems", providerName, accountID)
+	return r.repo.InvalidateEmailItems(ctx, providerName, accountID, version)
+}
+func (r *lockedRepo) GetEmailSync(ctx context.Context, account api.ExternalAccount) (api.EmailSync, error) {
+	if err := r.rlock(ctx, "GetEmailSync", account.ID); err != nil {
+		return api.EmailSync{}, err
+	}
+	defer r.runlock(ctx, "GetEmailSync", account.ID)
+	return r.repo.GetEmailSync(ctx, account)
+}
+func (r *lockedRepo) StoreEmailSync(ctx context.Context, sync api.EmailSync) error {
+	if err := r.lock(ctx, "StoreEmailSync", sync.AccountID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreEmailSync", sync.AccountID)
+	return r.repo.StoreEmailSync(ctx, sync)
+}
+
+func (r *lockedRepo) GetManagedPolicy(ctx context.Context, userID string) (api.ManagedPolicy, error) {
+	if err := r.rlock(ctx, "GetManagedPolicy", userID); err != nil {
+		return api.ManagedPolicy{}, err
+	}
+	defer r.runlock(ctx, "GetManagedPolicy", userID)
+	return r.repo.GetManagedPolicy(ctx, userID)
+}
+func (r *lockedRepo) StoreManagedPolicy(ctx context.Context, policy api.ManagedPolicy) error {
+	if err := r.lock(ctx, "StoreManagedPolicy", policy.UserID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreManagedPolicy", policy.UserID)
+	return r.repo.StoreManagedPolicy(ctx, policy)
+}
+func (r *lockedRepo) DeleteManagedPolicy(ctx context.Context, userID string) error {
+	if err := r.lock(ctx, "DeleteManagedPolicy", userID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteManagedPolicy", userID)
+	return r.repo.DeleteManagedPolicy(ctx, userID)
+}
+
+func (r *lockedRepo) GetLinkPolicies(ctx context.Context, userID string) ([]api.LinkPolicy, error) {
+	if err := r.rlock(ctx, "GetLinkPolicies", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetLinkPolicies", userID)
+	return r.repo.GetLinkPolicies(ctx, userID)
+}
+func (r *lockedRepo) StoreLinkPolicies(ctx context.Context, userID string, policies []api.LinkPolicy) error {
+	if err := r.lock(ctx, "StoreLinkPolicies", userID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreLinkPolicies", userID)
+	return r.repo.StoreLinkPolicies(ctx, userID, policies)
+}
+
+func (r *lockedRepo) GetAPITokens(ctx context.Context, userID string) ([]api.APIToken, error) {
+	if err := r.rlock(ctx, "GetAPITokens", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetAPITokens", userID)
+	return r.repo.GetAPITokens(ctx, userID)
+}
+func (r *lockedRepo) GetAPITokenByHash(ctx context.Context, hash string) (api.APIToken, error) {
+	if err := r.rlock(ctx, "GetAPITokenByHash"); err != nil {
+		return api.APIToken{}, err
+	}
+	defer r.runlock(ctx, "GetAPITokenByHash")
+	return r.repo.GetAPITokenByHash(ctx, hash)
+}
+func (r *lockedRepo) StoreAPIToken(ctx context.Context, token *api.APIToken) error {
+	if err := r.lock(ctx, "StoreAPIToken", token.UserID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreAPIToken", token.UserID)
+	return r.repo.StoreAPIToken(ctx, token)
+}
+func (r *lockedRepo) StoreAPITokenUse(ctx context.Context, tokenID int64, used time.Time) error {
+	if err := r.lock(ctx, "StoreAPITokenUse", tokenID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreAPITokenUse", tokenID)
+	return r.repo.StoreAPITokenUse(ctx, tokenID, used)
+}
+func (r *lockedRepo) DeleteAPIToken(ctx context.Context, userID string, tokenID int64) error {
+	if err := r.lock(ctx, "DeleteAPIToken", userID, tokenID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteAPIToken", userID, tokenID)
+	return r.repo.DeleteAPIToken(ctx, userID, tokenID)
+}
+
+func (r *lockedRepo) GetRetentionPolicy(ctx context.Context, userID string) (api.RetentionPolicy, error) {
+	if err := r.rlock(ctx, "GetRetentionPolicy", userID); err != nil {
+		return api.RetentionPolicy{}, err
+	}
+	defer r.runlock(ctx, "GetRetentionPolicy", userID)
+	return r.repo.GetRetentionPolicy(ctx, userID)
+}
+func (r *lockedRepo) StoreRetentionPolicy(ctx context.Context, policy api.RetentionPolicy) error {
+	if err := r.lock(ctx, "StoreRetentionPolicy", policy.UserID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreRetentionPolicy", policy.UserID)
+	return r.repo.StoreRetentionPolicy(ctx, policy)
+}
+func (r *lockedRepo) CountReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	if err := r.rlock(ctx, "CountReadItemsBefore", userID, before); err != nil {
+		return 0, err
+	}
+	defer r.runlock(ctx, "CountReadItemsBefore", userID, before)
+	return r.repo.CountReadItemsBefore(ctx, userID, before)
+}
+func (r *lockedRepo) DeleteReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	if err := r.lock(ctx, "DeleteReadItemsBefore", userID, before); err != nil {
+		return 0, err
+	}
+	defer r.unlock(ctx, "DeleteReadItemsBefore", userID, before)
+	return r.repo.DeleteReadItemsBefore(ctx, userID, before)
+}
+func (r *lockedRepo) CountClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	if err := r.rlock(ctx, "CountClickHistoryBefore", userID, before); err != nil {
+		return 0, err
+	}
+	defer r.runlock(ctx, "CountClickHistoryBefore", userID, before)
+	return r.repo.CountClickHistoryBefore(ctx, userID, before)
+}
+func (r *lockedRepo) DeleteClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	if err := r.lock(ctx, "DeleteClickHistoryBefore", userID, before); err != nil {
+		return 0, err
+	}
+	defer r.unlock(ctx, "DeleteClickHistoryBefore", userID, before)
+	return r.repo.DeleteClickHistoryBefore(ctx, userID, before)
+}
+func (r *lockedRepo) CountEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	if err := r.rlock(ctx, "CountEmailItemsBefore", userID, before); err != nil {
+		return 0, err
+	}
+	defer r.runlock(ctx, "CountEmailItemsBefore", userID, before)
+	return r.repo.CountEmailItemsBefore(ctx, userID, before)
+}
+func (r *lockedRepo) DeleteEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	if err := r.lock(ctx, "DeleteEmailItemsBefore", userID, before); err != nil {
+		return 0, err
+	}
+	defer r.unlock(ctx, "DeleteEmailItemsBefore", userID, before)
+	return r.repo.DeleteEmailItemsBefore(ctx, userID, before)
+}
+
+func (r *lockedRepo) GetLastSnapshot(ctx context.Context, userID string) (api.StoredSnapshot, error) {
+	if err := r.rlock(ctx, "GetLastSnapshot", userID); err != nil {
+		return api.StoredSnapshot{}, err
+	}
+	defer r.runlock(ctx, "GetLastSnapshot", userID)
+	return r.repo.GetLastSnapshot(ctx, userID)
+}
+func (r *lockedRepo) StoreLastSnapshot(ctx context.Context, snapshot api.StoredSnapshot) error {
+	if err := r.lock(ctx, "StoreLastSnapshot", snapshot.UserID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreLastSnapshot", snapshot.UserID)
+	return r.repo.StoreLastSnapshot(ctx, snapshot)
+}
+func (r *lockedRepo) GetActivities(ctx context.Context, userID string, limit int) ([]api.Activity, error) {
+	if err := r.rlock(ctx, "GetActivities", userID, limit); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetActivities", userID, limit)
+	return r.repo.GetActivities(ctx, userID, limit)
+}
+func (r *lockedRepo) StoreActivity(ctx context.Context, activity *api.Activity) error {
+	if err := r.lock(ctx, "StoreActivity", activity.UserID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreActivity", activity.UserID)
+	return r.repo.StoreActivity(ctx, activity)
+}
+func (r *lockedRepo) DeleteOldActivities(ctx context.Context, userID string, keep int) (int64, error) {
+	if err := r.lock(ctx, "DeleteOldActivities", userID); err != nil {
+		return 0, err
+	}
+	defer r.unlock(ctx, "DeleteOldActivities", userID)
+	return r.repo.DeleteOldActivities(ctx, userID, keep)
+}
+
+func (r *lockedRepo) GetApprovalRequests(ctx context.Context, userID string) ([]api.ApprovalRequest, error) {
+	if err := r.rlock(ctx, "GetApprovalRequests", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetApprovalRequests", userID)
+	return r.repo.GetApprovalRequests(ctx, userID)
+}
+func (r *lockedRepo) StoreApprovalRequest(ctx context.Context, request *api.ApprovalRequest) error {
+	if err := r.lock(ctx, "StoreApprovalRequest", request.UserID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreApprovalRequest", request.UserID)
+	return r.repo.StoreApprovalRequest(ctx, request)
+}
+
+func (r *lockedRepo) GetStarredItems(ctx context.Context, userID string) ([]api.StarredItem, error) {
+	if err := r.rlock(ctx, "GetStarredItems", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetStarredItems", userID)
+	return r.repo.GetStarredItems(ctx, userID)
+}
+func (r *lockedRepo) StoreStarredItem(ctx context.Context, item api.StarredItem) error {
+	if err := r.lock(ctx, "StoreStarredItem", item.UserID, item.FeedID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreStarredItem", item.UserID, item.FeedID)
+	return r.repo.StoreStarredItem(ctx, item)
+}
+func (r *lockedRepo) DeleteStarredItem(ctx context.Context, userID string, feedID int64, guid string) error {
+	if err := r.lock(ctx, "DeleteStarredItem", userID, feedID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteStarredItem", userID, feedID)
+	return r.repo.DeleteStarredItem(ctx, userID, feedID, guid)
+}
+
+func (r *lockedRepo) GetUserWebhook(ctx context.Context, userID string) (api.UserWebhook, error) {
+	if err := r.rlock(ctx, "GetUserWebhook", userID); err != nil {
+		return api.UserWebhook{}, err
+	}
+	defer r.runlock(ctx, "GetUserWebhook", userID)
+	return r.repo.GetUserWebhook(ctx, userID)
+}
+func (r *lockedRepo) StoreUserWebhook(ctx context.Context, webhook api.UserWebhook) error {
+	if err := r.lock(ctx, "StoreUserWebhook", webhook.UserID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreUserWebhook", webhook.UserID)
+	return r.repo.StoreUserWebhook(ctx, webhook)
+}
+func (r *lockedRepo) DeleteUserWebhook(ctx context.Context, userID string) error {
+	if err := r.lock(ctx, "DeleteUserWebhook", userID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteUserWebhook", userID)
+	return r.repo.DeleteUserWebhook(ctx, userID)
+}
+
+func (r *lockedRepo) GetNotificationSettings(ctx context.Context, userID string) (api.NotificationSettings, error) {
+	if err := r.rlock(ctx, "GetNotificationSettings", userID); err != nil {
+		return api.NotificationSettings{}, err
+	}
+	defer r.runlock(ctx, "GetNotificationSettings", userID)
+	return r.repo.GetNotificationSettings(ctx, userID)
+}
+func (r *lockedRepo) StoreNotificationSettings(ctx context.Context, settings api.NotificationSettings) error {
+	if err := r.lock(ctx, "StoreNotificationSettings", settings.UserID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreNotificationSettings", settings.UserID)
+	return r.repo.StoreNotificationSettings(ctx, settings)
+}
+func (r *lockedRepo) DeleteNotificationSettings(ctx context.Context, userID string) error {
+	if err := r.lock(ctx, "DeleteNotificationSettings", userID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteNotificationSettings", userID)
+	return r.repo.DeleteNotificationSettings(ctx, userID)
+}
+
+func (r *lockedRepo) GetTags(ctx context.Context, userID string) ([]api.Tag, error) {
+	if err := r.rlock(ctx, "GetTags", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetTags", userID)
+	return r.repo.GetTags(ctx, userID)
+}
+func (r *lockedRepo) StoreTag(ctx context.Context, tag api.Tag) error {
+	if err := r.lock(ctx, "StoreTag", tag.UserID, tag.Name); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreTag", tag.UserID, tag.Name)
+	return r.repo.StoreTag(ctx, tag)
+}
+func (r *lockedRepo) DeleteTag(ctx context.Context, userID string, name string) error {
+	if err := r.lock(ctx, "DeleteTag", userID, name); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "DeleteTag", userID, name)
+	return r.repo.DeleteTag(ctx, userID, name)
+}
+
+func (r *lockedRepo) GetWidgetViews(ctx context.Context, userID string) ([]api.WidgetView, error) {
+	if err := r.rlock(ctx, "GetWidgetViews", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetWidgetViews", userID)
+	return r.repo.GetWidgetViews(ctx, userID)
+}
+func (r *lockedRepo) RecordWidgetView(ctx context.Context, view api.WidgetView) error {
+	if err := r.lock(ctx, "RecordWidgetView", view.UserID, view.TabID, view.WidgetID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "RecordWidgetView", view.UserID, view.TabID, view.WidgetID)
+	return r.repo.RecordWidgetView(ctx, view)
+}
+func (r *lockedRepo) GetViewedWidgets(ctx context.Context, since time.Time) ([]api.Widget, error) {
+	if err := r.rlock(ctx, "GetViewedWidgets"); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetViewedWidgets")
+	return r.repo.GetViewedWidgets(ctx, since)
+}
+func (r *lockedRepo) StoreAuditEvent(ctx context.Context, event *api.AuditEvent) error {
+	if err := r.lock(ctx, "StoreAuditEvent", event.UserID, event.Action); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "StoreAuditEvent", event.UserID, event.Action)
+	return r.repo.StoreAuditEvent(ctx, event)
+}
+func (r *lockedRepo) GetAuditEventsPage(ctx context.Context, filter api.AuditFilter, page api.PageRequest) ([]api.AuditEvent, string, error) {
+	if err := r.rlock(ctx, "GetAuditEventsPage", filter.UserID, page.Cursor); err != nil {
+		return nil, "", err
+	}
+	defer r.runlock(ctx, "GetAuditEventsPage", filter.UserID, page.Cursor)
+	return r.repo.GetAuditEventsPage(ctx, filter, page)
+}
+
+func (r *lockedRepo) AddUsage(ctx context.Context, userID string, day time.Time, usage api.Usage) error {
+	if err := r.lock(ctx, "AddUsage", userID); err != nil {
+		return err
+	}
+	defer r.unlock(ctx, "AddUsage", userID)
+	return r.repo.AddUsage(ctx, userID, day, usage)
+}
+func (r *lockedRepo) GetUsage(ctx context.Context, userID string, since time.Time) ([]api.DailyUsage, error) {
+	if err := r.rlock(ctx, "GetUsage", userID); err != nil {
+		return nil, err
+	}
+	defer r.runlock(ctx, "GetUsage", userID)
+	return r.repo.GetUsage(ctx, userID, since)
+}
+func (r *lockedRepo) DeleteUsageBefore(ctx context.Context, before time.Time) (int64, error) {
+	if err := r.lock(ctx, "DeleteUsageBefore"); err != nil {
+		return 0, err
+	}
+	defer r.unlock(ctx, "DeleteUsageBefore")
+	return r.repo.DeleteUsageBefore(ctx, before)
+}
@@ -0,0 +1,494 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/metrics"
+)
+
+//WithMetrics wraps a repository to record the latency and the failures of its calls in the given window.
+//Not found errors are not counted as failures.
+func WithMetrics(r api.Repository, w *metrics.Window) api.Repository {
+	return &measuredRepo{
+		repo:   r,
+		window: w,
+	}
+}
+
+type measuredRepo struct {
+	repo   api.Repository
+	window *metrics.Window
+}
+
+func (r *measuredRepo) observe(start time.Time, err *error) {
+	failed := *err != nil && !r.repo.IsNotFound(*err)
+	r.window.Record(time.Since(start), failed)
+}
+
+func (r *measuredRepo) IsNotFound(err error) bool {
+	return r.repo.IsNotFound(err)
+}
+
+func (r *measuredRepo) Close() error {
+	return r.repo.Close()
+}
+func (r *measuredRepo) Optimize(ctx context.Context) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.Optimize(ctx)
+}
+
+//LockStats gives the lock contention of the measured repository, empty if it has no lock
+func (r *measuredRepo) LockStats() (api.LockStats, error) {
+	inspector, ok := r.repo.(api.LockInspector)
+	if !ok {
+		return api.LockStats{Held: []api.LockHolder{}, Waiting: []api.LockHolder{}, Waits: []api.LockWait{}}, nil
+	}
+	return inspector.LockStats()
+}
+
+//RunInTransaction records the whole transaction, the repository given to f also recording its calls
+func (r *measuredRepo) RunInTransaction(ctx context.Context, f func(repo api.Repository) error) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.RunInTransaction(ctx, func(repo api.Repository) error {
+		return f(WithMetrics(repo, r.window))
+	})
+}
+
+func (r *measuredRepo) GetUser(ctx context.Context, userID string) (_ api.User, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetUser(ctx, userID)
+}
+func (r *measuredRepo) StoreUser(ctx context.Context, user *api.User) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreUser(ctx, user)
+}
+func (r *measuredRepo) SetUserTimeZone(ctx context.Context, userID string, timeZone string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.SetUserTimeZone(ctx, userID, timeZone)
+}
+func (r *measuredRepo) SetUserLocale(ctx context.Context, userID string, locale string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.SetUserLocale(ctx, userID, locale)
+}
+func (r *measuredRepo) SetUserAdmin(ctx context.Context, userID string, admin bool) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.SetUserAdmin(ctx, userID, admin)
+}
+func (r *measuredRepo) DeleteUser(ctx context.Context, userID string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteUser(ctx, userID)
+}
+func (r *measuredRepo) GetUsersPage(ctx context.Context, page api.PageRequest) (_ []api.User, _ string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetUsersPage(ctx, page)
+}
+func (r *measuredRepo) GetUserStats(ctx context.Context, userID string) (_ api.UserStats, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetUserStats(ctx, userID)
+}
+func (r *measuredRepo) GetFeedStats(ctx context.Context) (_ api.FeedStats, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetFeedStats(ctx)
+}
+func (r *measuredRepo) GetTabs(ctx context.Context, userID string) (_ []api.TabSummary, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetTabs(ctx, userID)
+}
+func (r *measuredRepo) GetOwnedTabIDs(ctx context.Context, userID string) (_ []int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetOwnedTabIDs(ctx, userID)
+}
+func (r *measuredRepo) GetTabsPage(ctx context.Context, userID string, page api.PageRequest) (_ []api.TabSummary, _ string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetTabsPage(ctx, userID, page)
+}
+func (r *measuredRepo) UpdateTabPositions(ctx context.Context, userID string, tabIDs []int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.UpdateTabPositions(ctx, userID, tabIDs)
+}
+func (r *measuredRepo) GetTabSlug(ctx context.Context, userID string, slug string) (_ api.TabSlug, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetTabSlug(ctx, userID, slug)
+}
+func (r *measuredRepo) GetCurrentTabSlug(ctx context.Context, userID string, tabID int64) (_ string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetCurrentTabSlug(ctx, userID, tabID)
+}
+func (r *measuredRepo) StoreTabSlug(ctx context.Context, slug api.TabSlug) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreTabSlug(ctx, slug)
+}
+func (r *measuredRepo) IsTabAccessAllowed(ctx context.Context, userID string, tabID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.IsTabAccessAllowed(ctx, userID, tabID)
+}
+func (r *measuredRepo) AllowTabAccess(ctx context.Context, userID string, tabID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.AllowTabAccess(ctx, userID, tabID)
+}
+func (r *measuredRepo) SetDefaultTab(ctx context.Context, userID string, tabID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.SetDefaultTab(ctx, userID, tabID)
+}
+func (r *measuredRepo) GetTab(ctx context.Context, tabID int64) (_ api.Tab, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetTab(ctx, tabID)
+}
+func (r *measuredRepo) StoreTab(ctx context.Context, tab *api.Tab) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreTab(ctx, tab)
+}
+func (r *measuredRepo) DeleteTab(ctx context.Context, tabID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteTab(ctx, tabID)
+}
+func (r *measuredRepo) GetWidget(ctx context.Context, tabID int64, widgetID int64) (_ api.Widget, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetWidget(ctx, tabID, widgetID)
+}
+func (r *measuredRepo) StoreWidget(ctx context.Context, tabID int64, widget *api.Widget) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreWidget(ctx, tabID, widget)
+}
+func (r *measuredRepo) DeleteWidget(ctx context.Context, tabID int64, widgetID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteWidget(ctx, tabID, widgetID)
+}
+func (r *measuredRepo) UpdateTabLayout(ctx context.Context, tabID int64, layout [][]int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.UpdateTabLayout(ctx, tabID, layout)
+}
+func (r *measuredRepo) DeleteWidgetFromTab(ctx context.Context, tabID int64, widgetID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteWidgetFromTab(ctx, tabID, widgetID)
+}
+func (r *measuredRepo) GetOrCreateFeedID(ctx context.Context, URL string) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetOrCreateFeedID(ctx, URL)
+}
+func (r *measuredRepo) GetFeedID(ctx context.Context, URL string) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetFeedID(ctx, URL)
+}
+func (r *measuredRepo) GetFeed(ctx context.Context, feedID int64) (_ api.Feed, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetFeed(ctx, feedID)
+}
+func (r *measuredRepo) GetFeedsPage(ctx context.Context, page api.PageRequest) (_ []api.Feed, _ string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetFeedsPage(ctx, page)
+}
+func (r *measuredRepo) GetFeedItems(ctx context.Context, feedID int64) (_ []api.FeedItem, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetFeedItems(ctx, feedID)
+}
+func (r *measuredRepo) GetFeedItemsBefore(ctx context.Context, feedID int64, before api.ItemCursor, limit int) (_ []api.FeedItem, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetFeedItemsBefore(ctx, feedID, before, limit)
+}
+func (r *measuredRepo) GetFeedItemsForUser(ctx context.Context, userID string, feedID int64, before api.ItemCursor, limit int) (_ []api.ItemForUser, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetFeedItemsForUser(ctx, userID, feedID, before, limit)
+}
+func (r *measuredRepo) GetMostReadFeedIDs(ctx context.Context, userID string, limit int) (_ []int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetMostReadFeedIDs(ctx, userID, limit)
+}
+func (r *measuredRepo) GetFeedUserIDs(ctx context.Context, feedID int64) (_ []string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetFeedUserIDs(ctx, feedID)
+}
+func (r *measuredRepo) StoreFeed(ctx context.Context, feed *api.Feed, feedItems []api.FeedItem) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreFeed(ctx, feed, feedItems)
+}
+func (r *measuredRepo) DeleteFeed(ctx context.Context, feedID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteFeed(ctx, feedID)
+}
+func (r *measuredRepo) DeleteOrphanFeeds(ctx context.Context, retrievedBefore time.Time) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteOrphanFeeds(ctx, retrievedBefore)
+}
+func (r *measuredRepo) GetReadItems(ctx context.Context, userID string, feedID int64) (_ []string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetReadItems(ctx, userID, feedID)
+}
+func (r *measuredRepo) SetItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.SetItemRead(ctx, userID, feedID, guid, read)
+}
+func (r *measuredRepo) GetRankingModel(ctx context.Context, userID string) (_ api.RankingModel, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetRankingModel(ctx, userID)
+}
+func (r *measuredRepo) StoreRankingModel(ctx context.Context, model api.RankingModel) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreRankingModel(ctx, model)
+}
+func (r *measuredRepo) GetItemAbstracts(ctx context.Context, feedID int64, guids []string) (_ []string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetItemAbstracts(ctx, feedID, guids)
+}
+func (r *measuredRepo) StoreItemAbstract(ctx context.Context, feedID int64, guid string, abstract string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreItemAbstract(ctx, feedID, guid, abstract)
+}
+func (r *measuredRepo) SetItemsRead(ctx context.Context, userID string, feedID int64, guid []string, read bool) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.SetItemsRead(ctx, userID, feedID, guid, read)
+}
+func (r *measuredRepo) GetAccount(ctx context.Context, userID string, accountID int64) (_ api.ExternalAccount, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetAccount(ctx, userID, accountID)
+}
+func (r *measuredRepo) GetAccounts(ctx context.Context, userID string) (_ []api.ExternalAccount, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetAccounts(ctx, userID)
+}
+func (r *measuredRepo) GetAccountsPage(ctx context.Context, userID string, page api.PageRequest) (_ []api.ExternalAccount, _ string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetAccountsPage(ctx, userID, page)
+}
+func (r *measuredRepo) DeleteAccount(ctx context.Context, userID string, accountID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteAccount(ctx, userID, accountID)
+}
+func (r *measuredRepo) MarkAccountNeedsReauth(ctx context.Context, accountID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.MarkAccountNeedsReauth(ctx, accountID)
+}
+func (r *measuredRepo) StoreAccount(ctx context.Context, userID string, account *api.ExternalAccount) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreAccount(ctx, userID, account)
+}
+func (r *measuredRepo) UpgradeWidgetConfigs(ctx context.Context, page api.PageRequest) (_ int, _ string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.UpgradeWidgetConfigs(ctx, page)
+}
+func (r *measuredRepo) ReencryptTokens(ctx context.Context, page api.PageRequest) (_ int, _ string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.ReencryptTokens(ctx, page)
+}
+func (r *measuredRepo) GetTemporaryCode(ctx context.Context, serviceName string, code string) (_ api.TemporaryCode, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetTemporaryCode(ctx, serviceName, code)
+}
+func (r *measuredRepo) StoreTemporaryCode(ctx context.Context, code api.TemporaryCode) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreTemporaryCode(ctx, code)
+}
+func (r *measuredRepo) DeleteTemporaryCode(ctx context.Context, userID string, serviceName string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteTemporaryCode(ctx, userID, serviceName)
+}
+func (r *measuredRepo) DeleteTemporaryCodesBefore(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteTemporaryCodesBefore(ctx, before)
+}
+func (r *measuredRepo) GetEmailItem(ctx context.Context, account api.ExternalAccount, guid string, minVersion uint64) (_ api.EmailItem, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetEmailItem(ctx, account, guid, minVersion)
+}
+func (r *measuredRepo) StoreEmailItem(ctx context.Context, account api.ExternalAccount, version uint64, item api.EmailItem) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreEmailItem(ctx, account, version, item)
+}
+func (r *measuredRepo) InvalidateEmailItems(ctx context.Context, providerName string, accountID string, version uint64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.InvalidateEmailItems(ctx, providerName, accountID, version)
+}
+func (r *measuredRepo) GetEmailSync(ctx context.Context, account api.ExternalAccount) (_ api.EmailSync, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetEmailSync(ctx, account)
+}
+func (r *measuredRepo) StoreEmailSync(ctx context.Context, sync api.EmailSync) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreEmailSync(ctx, sync)
+}
+func (r *measuredRepo) GetManagedPolicy(ctx context.Context, userID string) (_ api.ManagedPolicy, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetManagedPolicy(ctx, userID)
+}
+func (r *measuredRepo) StoreManagedPolicy(ctx context.Context, policy api.ManagedPolicy) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreManagedPolicy(ctx, policy)
+}
+func (r *measuredRepo) DeleteManagedPolicy(ctx context.Context, userID string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteManagedPolicy(ctx, userID)
+}
+func (r *measuredRepo) GetLinkPolicies(ctx context.Context, userID string) (_ []api.LinkPolicy, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetLinkPolicies(ctx, userID)
+}
+func (r *measuredRepo) StoreLinkPolicies(ctx context.Context, userID string, policies []api.LinkPolicy) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreLinkPolicies(ctx, userID, policies)
+}
+func (r *measuredRepo) GetAPITokens(ctx context.Context, userID string) (_ []api.APIToken, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetAPITokens(ctx, userID)
+}
+func (r *measuredRepo) GetAPITokenByHash(ctx context.Context, hash string) (_ api.APIToken, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetAPITokenByHash(ctx, hash)
+}
+func (r *measuredRepo) StoreAPIToken(ctx context.Context, token *api.APIToken) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreAPIToken(ctx, token)
+}
+func (r *measuredRepo) StoreAPITokenUse(ctx context.Context, tokenID int64, used time.Time) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreAPITokenUse(ctx, tokenID, used)
+}
+func (r *measuredRepo) DeleteAPIToken(ctx context.Context, userID string, tokenID int64) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteAPIToken(ctx, userID, tokenID)
+}
+func (r *measuredRepo) GetRetentionPolicy(ctx context.Context, userID string) (_ api.RetentionPolicy, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetRetentionPolicy(ctx, userID)
+}
+func (r *measuredRepo) StoreRetentionPolicy(ctx context.Context, policy api.RetentionPolicy) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreRetentionPolicy(ctx, policy)
+}
+func (r *measuredRepo) CountReadItemsBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.CountReadItemsBefore(ctx, userID, before)
+}
+func (r *measuredRepo) DeleteReadItemsBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteReadItemsBefore(ctx, userID, before)
+}
+func (r *measuredRepo) CountClickHistoryBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.CountClickHistoryBefore(ctx, userID, before)
+}
+func (r *measuredRepo) DeleteClickHistoryBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteClickHistoryBefore(ctx, userID, before)
+}
+func (r *measuredRepo) CountEmailItemsBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.CountEmailItemsBefore(ctx, userID, before)
+}
+func (r *measuredRepo) DeleteEmailItemsBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteEmailItemsBefore(ctx, userID, before)
+}
+func (r *measuredRepo) GetLastSnapshot(ctx context.Context, userID string) (_ api.StoredSnapshot, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetLastSnapshot(ctx, userID)
+}
+func (r *measuredRepo) StoreLastSnapshot(ctx context.Context, snapshot api.StoredSnapshot) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreLastSnapshot(ctx, snapshot)
+}
+func (r *measuredRepo) GetActivities(ctx context.Context, userID string, limit int) (_ []api.Activity, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetActivities(ctx, userID, limit)
+}
+func (r *measuredRepo) StoreActivity(ctx context.Context, activity *api.Activity) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreActivity(ctx, activity)
+}
+func (r *measuredRepo) DeleteOldActivities(ctx context.Context, userID string, keep int) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteOldActivities(ctx, userID, keep)
+}
+func (r *measuredRepo) GetApprovalRequests(ctx context.Context, userID string) (_ []api.ApprovalRequest, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetApprovalRequests(ctx, userID)
+}
+func (r *measuredRepo) StoreApprovalRequest(ctx context.Context, request *api.ApprovalRequest) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreApprovalRequest(ctx, request)
+}
+func (r *measuredRepo) GetStarredItems(ctx context.Context, userID string) (_ []api.StarredItem, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetStarredItems(ctx, userID)
+}
+func (r *measuredRepo) StoreStarredItem(ctx context.Context, item api.StarredItem) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreStarredItem(ctx, item)
+}
+func (r *measuredRepo) DeleteStarredItem(ctx context.Context, userID string, feedID int64, guid string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteStarredItem(ctx, userID, feedID, guid)
+}
+func (r *measuredRepo) GetUserWebhook(ctx context.Context, userID string) (_ api.UserWebhook, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetUserWebhook(ctx, userID)
+}
+func (r *measuredRepo) StoreUserWebhook(ctx context.Context, webhook api.UserWebhook) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreUserWebhook(ctx, webhook)
+}
+func (r *measuredRepo) DeleteUserWebhook(ctx context.Context, userID string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteUserWebhook(ctx, userID)
+}
+func (r *measuredRepo) GetNotificationSettings(ctx context.Context, userID string) (_ api.NotificationSettings, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetNotificationSettings(ctx, userID)
+}
+func (r *measuredRepo) StoreNotificationSettings(ctx context.Context, settings api.NotificationSettings) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreNotificationSettings(ctx, settings)
+}
+func (r *measuredRepo) DeleteNotificationSettings(ctx context.Context, userID string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteNotificationSettings(ctx, userID)
+}
+func (r *measuredRepo) GetTags(ctx context.Context, userID string) (_ []api.Tag, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetTags(ctx, userID)
+}
+func (r *measuredRepo) StoreTag(ctx context.Context, tag api.Tag) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreTag(ctx, tag)
+}
+func (r *measuredRepo) DeleteTag(ctx context.Context, userID string, name string) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteTag(ctx, userID, name)
+}
+func (r *measuredRepo) GetWidgetViews(ctx context.Context, userID string) (_ []api.WidgetView, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetWidgetViews(ctx, userID)
+}
+func (r *measuredRepo) RecordWidgetView(ctx context.Context, view api.WidgetView) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.RecordWidgetView(ctx, view)
+}
+func (r *measuredRepo) GetViewedWidgets(ctx context.Context, since time.Time) (_ []api.Widget, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetViewedWidgets(ctx, since)
+}
+func (r *measuredRepo) StoreAuditEvent(ctx context.Context, event *api.AuditEvent) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.StoreAuditEvent(ctx, event)
+}
+func (r *measuredRepo) GetAuditEventsPage(ctx context.Context, filter api.AuditFilter, page api.PageRequest) (_ []api.AuditEvent, _ string, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetAuditEventsPage(ctx, filter, page)
+}
+func (r *measuredRepo) AddUsage(ctx context.Context, userID string, day time.Time, usage api.Usage) (err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.AddUsage(ctx, userID, day, usage)
+}
+func (r *measuredRepo) GetUsage(ctx context.Context, userID string, since time.Time) (_ []api.DailyUsage, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.GetUsage(ctx, userID, since)
+}
+func (r *measuredRepo) DeleteUsageBefore(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer r.observe(time.Now(), &err)
+	return r.repo.DeleteUsageBefore(ctx, before)
+}
@@ -0,0 +1,538 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/metrics"
+)
+
+//WithSlowLog wraps a repository to log at Warning level the calls lasting more than the threshold,
+//with the name of the method and its arguments, and to record them in the registry by method, if not nil.
+//Only the identifiers and the scalar arguments are logged, the structures being replaced by their type
+//as they may hold credentials.
+//It should wrap the repository under its lock, if any, so that the wait for the lock is not measured.
+func WithSlowLog(r api.Repository, threshold time.Duration, l api.LogInteractor, registry *metrics.Registry) api.Repository {
+	return &slowLoggedRepo{
+		repo:      r,
+		threshold: threshold,
+		log:       l,
+		registry:  registry,
+	}
+}
+
+type slowLoggedRepo struct {
+	repo      api.Repository
+	threshold time.Duration
+	log       api.LogInteractor
+	registry  *metrics.Registry
+}
+
+func (r *slowLoggedRepo) observe(ctx context.Context, start time.Time, method string, args ...interface{}) {
+	elapsed := time.Since(start)
+	if elapsed < r.threshold {
+		return
+	}
+
+	formatted := make([]string, 0, len(args))
+	for _, arg := range args {
+		formatted = append(formatted, formatArg(arg))
+	}
+	r.log.Warnf(ctx, "Slow repository call %s(%s) took %s", method, strings.Join(formatted, ", "), elapsed)
+
+	if r.registry != nil {
+		r.registry.Record(method, elapsed, false, false)
+	}
+}
+
+//formatArg formats the scalar arguments, the other ones being replaced by their type
+func formatArg(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case int, int64, uint64, bool, api.Service, api.AuditAction:
+		return fmt.Sprint(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case time.Duration:
+		return v.String()
+	case []int64:
+		return fmt.Sprint(v)
+	case []string:
+		return fmt.Sprintf("[%d strings]", len(v))
+	case api.ExternalAccount:
+		return fmt.Sprintf("account %d", v.ID)
+	case api.PageRequest:
+		return fmt.Sprintf("%+v", v)
+	}
+	return fmt.Sprintf("%T", arg)
+}
+
+func (r *slowLoggedRepo) IsNotFound(err error) bool {
+	return r.repo.IsNotFound(err)
+}
+
+func (r *slowLoggedRepo) Close() error {
+	return r.repo.Close()
+}
+func (r *slowLoggedRepo) Optimize(ctx context.Context) error {
+	defer r.observe(ctx, time.Now(), "Optimize")
+	return r.repo.Optimize(ctx)
+}
+
+//LockStats gives the lock contention of the logged repository, empty if it has no lock
+func (r *slowLoggedRepo) LockStats() (api.LockStats, error) {
+	inspector, ok := r.repo.(api.LockInspector)
+	if !ok {
+		return api.LockStats{Held: []api.LockHolder{}, Waiting: []api.LockHolder{}, Waits: []api.LockWait{}}, nil
+	}
+	return inspector.LockStats()
+}
+
+//RunInTransaction logs the whole transaction if slow, the repository given to f also logging its slow calls
+func (r *slowLoggedRepo) RunInTransaction(ctx context.Context, f func(repo api.Repository) error) error {
+	defer r.observe(ctx, time.Now(), "RunInTransaction")
+	return r.repo.RunInTransaction(ctx, func(repo api.Repository) error {
+		return f(WithSlowLog(repo, r.threshold, r.log, r.registry))
+	})
+}
+
+func (r *slowLoggedRepo) GetUser(ctx context.Context, userID string) (api.User, error) {
+	defer r.observe(ctx, time.Now(), "GetUser", userID)
+	return r.repo.GetUser(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreUser(ctx context.Context, user *api.User) error {
+	defer r.observe(ctx, time.Now(), "StoreUser", user)
+	return r.repo.StoreUser(ctx, user)
+}
+func (r *slowLoggedRepo) SetUserTimeZone(ctx context.Context, userID string, timeZone string) error {
+	defer r.observe(ctx, time.Now(), "SetUserTimeZone", userID, timeZone)
+	return r.repo.SetUserTimeZone(ctx, userID, timeZone)
+}
+func (r *slowLoggedRepo) SetUserLocale(ctx context.Context, userID string, locale string) error {
+	defer r.observe(ctx, time.Now(), "SetUserLocale", userID, locale)
+	return r.repo.SetUserLocale(ctx, userID, locale)
+}
+func (r *slowLoggedRepo) SetUserAdmin(ctx context.Context, userID string, admin bool) error {
+	defer r.observe(ctx, time.Now(), "SetUserAdmin", userID, admin)
+	return r.repo.SetUserAdmin(ctx, userID, admin)
+}
+func (r *slowLoggedRepo) DeleteUser(ctx context.Context, userID string) error {
+	defer r.observe(ctx, time.Now(), "DeleteUser", userID)
+	return r.repo.DeleteUser(ctx, userID)
+}
+func (r *slowLoggedRepo) GetUsersPage(ctx context.Context, page api.PageRequest) ([]api.User, string, error) {
+	defer r.observe(ctx, time.Now(), "GetUsersPage", page)
+	return r.repo.GetUsersPage(ctx, page)
+}
+func (r *slowLoggedRepo) GetUserStats(ctx context.Context, userID string) (api.UserStats, error) {
+	defer r.observe(ctx, time.Now(), "GetUserStats", userID)
+	return r.repo.GetUserStats(ctx, userID)
+}
+func (r *slowLoggedRepo) GetFeedStats(ctx context.Context) (api.FeedStats, error) {
+	defer r.observe(ctx, time.Now(), "GetFeedStats")
+	return r.repo.GetFeedStats(ctx)
+}
+func (r *slowLoggedRepo) GetTabs(ctx context.Context, userID string) ([]api.TabSummary, error) {
+	defer r.observe(ctx, time.Now(), "GetTabs", userID)
+	return r.repo.GetTabs(ctx, userID)
+}
+func (r *slowLoggedRepo) GetOwnedTabIDs(ctx context.Context, userID string) ([]int64, error) {
+	defer r.observe(ctx, time.Now(), "GetOwnedTabIDs", userID)
+	return r.repo.GetOwnedTabIDs(ctx, userID)
+}
+func (r *slowLoggedRepo) GetTabsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.TabSummary, string, error) {
+	defer r.observe(ctx, time.Now(), "GetTabsPage", userID, page)
+	return r.repo.GetTabsPage(ctx, userID, page)
+}
+func (r *slowLoggedRepo) UpdateTabPositions(ctx context.Context, userID string, tabIDs []int64) error {
+	defer r.observe(ctx, time.Now(), "UpdateTabPositions", userID, tabIDs)
+	return r.repo.UpdateTabPositions(ctx, userID, tabIDs)
+}
+func (r *slowLoggedRepo) GetTabSlug(ctx context.Context, userID string, slug string) (api.TabSlug, error) {
+	defer r.observe(ctx, time.Now(), "GetTabSlug", userID, slug)
+	return r.repo.GetTabSlug(ctx, userID, slug)
+}
+func (r *slowLoggedRepo) GetCurrentTabSlug(ctx context.Context, userID string, tabID int64) (string, error) {
+	defer r.observe(ctx, time.Now(), "GetCurrentTabSlug", userID, tabID)
+	return r.repo.GetCurrentTabSlug(ctx, userID, tabID)
+}
+func (r *slowLoggedRepo) StoreTabSlug(ctx context.Context, slug api.TabSlug) error {
+	defer r.observe(ctx, time.Now(), "StoreTabSlug", slug)
+	return r.repo.StoreTabSlug(ctx, slug)
+}
+func (r *slowLoggedRepo) IsTabAccessAllowed(ctx context.Context, userID string, tabID int64) error {
+	defer r.observe(ctx, time.Now(), "IsTabAccessAllowed", userID, tabID)
+	return r.repo.IsTabAccessAllowed(ctx, userID, tabID)
+}
+func (r *slowLoggedRepo) AllowTabAccess(ctx context.Context, userID string, tabID int64) error {
+	defer r.observe(ctx, time.Now(), "AllowTabAccess", userID, tabID)
+	return r.repo.AllowTabAccess(ctx, userID, tabID)
+}
+func (r *slowLoggedRepo) SetDefaultTab(ctx context.Context, userID string, tabID int64) error {
+	defer r.observe(ctx, time.Now(), "SetDefaultTab", userID, tabID)
+	return r.repo.SetDefaultTab(ctx, userID, tabID)
+}
+func (r *slowLoggedRepo) GetTab(ctx context.Context, tabID int64) (api.Tab, error) {
+	defer r.observe(ctx, time.Now(), "GetTab", tabID)
+	return r.repo.GetTab(ctx, tabID)
+}
+func (r *slowLoggedRepo) StoreTab(ctx context.Context, tab *api.Tab) error {
+	defer r.observe(ctx, time.Now(), "StoreTab", tab)
+	return r.repo.StoreTab(ctx, tab)
+}
+func (r *slowLoggedRepo) DeleteTab(ctx context.Context, tabID int64) error {
+	defer r.observe(ctx, time.Now(), "DeleteTab", tabID)
+	return r.repo.DeleteTab(ctx, tabID)
+}
+func (r *slowLoggedRepo) GetWidget(ctx context.Context, tabID int64, widgetID int64) (api.Widget, error) {
+	defer r.observe(ctx, time.Now(), "GetWidget", tabID, widgetID)
+	return r.repo.GetWidget(ctx, tabID, widgetID)
+}
+func (r *slowLoggedRepo) StoreWidget(ctx context.Context, tabID int64, widget *api.Widget) error {
+	defer r.observe(ctx, time.Now(), "StoreWidget", tabID, widget)
+	return r.repo.StoreWidget(ctx, tabID, widget)
+}
+func (r *slowLoggedRepo) DeleteWidget(ctx context.Context, tabID int64, widgetID int64) error {
+	defer r.observe(ctx, time.Now(), "DeleteWidget", tabID, widgetID)
+	return r.repo.DeleteWidget(ctx, tabID, widgetID)
+}
+func (r *slowLoggedRepo) UpdateTabLayout(ctx context.Context, tabID int64, layout [][]int64) error {
+	defer r.observe(ctx, time.Now(), "UpdateTabLayout", tabID, layout)
+	return r.repo.UpdateTabLayout(ctx, tabID, layout)
+}
+func (r *slowLoggedRepo) DeleteWidgetFromTab(ctx context.Context, tabID int64, widgetID int64) error {
+	defer r.observe(ctx, time.Now(), "DeleteWidgetFromTab", tabID, widgetID)
+	return r.repo.DeleteWidgetFromTab(ctx, tabID, widgetID)
+}
+func (r *slowLoggedRepo) GetOrCreateFeedID(ctx context.Context, URL string) (int64, error) {
+	defer r.observe(ctx, time.Now(), "GetOrCreateFeedID", URL)
+	return r.repo.GetOrCreateFeedID(ctx, URL)
+}
+func (r *slowLoggedRepo) GetFeedID(ctx context.Context, URL string) (int64, error) {
+	defer r.observe(ctx, time.Now(), "GetFeedID", URL)
+	return r.repo.GetFeedID(ctx, URL)
+}
+func (r *slowLoggedRepo) GetFeed(ctx context.Context, feedID int64) (api.Feed, error) {
+	defer r.observe(ctx, time.Now(), "GetFeed", feedID)
+	return r.repo.GetFeed(ctx, feedID)
+}
+func (r *slowLoggedRepo) GetFeedsPage(ctx context.Context, page api.PageRequest) ([]api.Feed, string, error) {
+	defer r.observe(ctx, time.Now(), "GetFeedsPage", page)
+	return r.repo.GetFeedsPage(ctx, page)
+}
+func (r *slowLoggedRepo) GetFeedItems(ctx context.Context, feedID int64) ([]api.FeedItem, error) {
+	defer r.observe(ctx, time.Now(), "GetFeedItems", feedID)
+	return r.repo.GetFeedItems(ctx, feedID)
+}
+func (r *slowLoggedRepo) GetFeedItemsBefore(ctx context.Context, feedID int64, before api.ItemCursor, limit int) ([]api.FeedItem, error) {
+	defer r.observe(ctx, time.Now(), "GetFeedItemsBefore", feedID, before, limit)
+	return r.repo.GetFeedItemsBefore(ctx, feedID, before, limit)
+}
+func (r *slowLoggedRepo) GetFeedItemsForUser(ctx context.Context, userID string, feedID int64, before api.ItemCursor, limit int) ([]api.ItemForUser, error) {
+	defer r.observe(ctx, time.Now(), "GetFeedItemsForUser", userID, feedID, before, limit)
+	return r.repo.GetFeedItemsForUser(ctx, userID, feedID, before, limit)
+}
+func (r *slowLoggedRepo) GetMostReadFeedIDs(ctx context.Context, userID string, limit int) ([]int64, error) {
+	defer r.observe(ctx, time.Now(), "GetMostReadFeedIDs", userID, limit)
+	return r.repo.GetMostReadFeedIDs(ctx, userID, limit)
+}
+func (r *slowLoggedRepo) GetFeedUserIDs(ctx context.Context, feedID int64) ([]string, error) {
+	defer r.observe(ctx, time.Now(), "GetFeedUserIDs", feedID)
+	return r.repo.GetFeedUserIDs(ctx, feedID)
+}
+func (r *slowLoggedRepo) StoreFeed(ctx context.Context, feed *api.Feed, feedItems []api.FeedItem) error {
+	defer r.observe(ctx, time.Now(), "StoreFeed", feed, feedItems)
+	return r.repo.StoreFeed(ctx, feed, feedItems)
+}
+func (r *slowLoggedRepo) DeleteFeed(ctx context.Context, feedID int64) error {
+	defer r.observe(ctx, time.Now(), "DeleteFeed", feedID)
+	return r.repo.DeleteFeed(ctx, feedID)
+}
+func (r *slowLoggedRepo) DeleteOrphanFeeds(ctx context.Context, retrievedBefore time.Time) (int64, error) {
+	defer r.observe(ctx, time.Now(), "DeleteOrphanFeeds", retrievedBefore)
+	return r.repo.DeleteOrphanFeeds(ctx, retrievedBefore)
+}
+func (r *slowLoggedRepo) GetReadItems(ctx context.Context, userID string, feedID int64) ([]string, error) {
+	defer r.observe(ctx, time.Now(), "GetReadItems", userID, feedID)
+	return r.repo.GetReadItems(ctx, userID, feedID)
+}
+func (r *slowLoggedRepo) SetItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) error {
+	defer r.observe(ctx, time.Now(), "SetItemRead", userID, feedID, guid, read)
+	return r.repo.SetItemRead(ctx, userID, feedID, guid, read)
+}
+func (r *slowLoggedRepo) GetRankingModel(ctx context.Context, userID string) (api.RankingModel, error) {
+	defer r.observe(ctx, time.Now(), "GetRankingModel", userID)
+	return r.repo.GetRankingModel(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreRankingModel(ctx context.Context, model api.RankingModel) error {
+	defer r.observe(ctx, time.Now(), "StoreRankingModel", model)
+	return r.repo.StoreRankingModel(ctx, model)
+}
+func (r *slowLoggedRepo) GetItemAbstracts(ctx context.Context, feedID int64, guids []string) ([]string, error) {
+	defer r.observe(ctx, time.Now(), "GetItemAbstracts", feedID, guids)
+	return r.repo.GetItemAbstracts(ctx, feedID, guids)
+}
+func (r *slowLoggedRepo) StoreItemAbstract(ctx context.Context, feedID int64, guid string, abstract string) error {
+	defer r.observe(ctx, time.Now(), "StoreItemAbstract", feedID, guid, abstract)
+	return r.repo.StoreItemAbstract(ctx, feedID, guid, abstract)
+}
+func (r *slowLoggedRepo) SetItemsRead(ctx context.Context, userID string, feedID int64, guid []string, read bool) (int64, error) {
+	defer r.observe(ctx, time.Now(), "SetItemsRead", userID, feedID, guid, read)
+	return r.repo.SetItemsRead(ctx, userID, feedID, guid, read)
+}
+func (r *slowLoggedRepo) GetAccount(ctx context.Context, userID string, accountID int64) (api.ExternalAccount, error) {
+	defer r.observe(ctx, time.Now(), "GetAccount", userID, accountID)
+	return r.repo.GetAccount(ctx, userID, accountID)
+}
+func (r *slowLoggedRepo) GetAccounts(ctx context.Context, userID string) ([]api.ExternalAccount, error) {
+	defer r.observe(ctx, time.Now(), "GetAccounts", userID)
+	return r.repo.GetAccounts(ctx, userID)
+}
+func (r *slowLoggedRepo) GetAccountsPage(ctx context.Context, userID string, page api.PageRequest) ([]api.ExternalAccount, string, error) {
+	defer r.observe(ctx, time.Now(), "GetAccountsPage", userID, page)
+	return r.repo.GetAccountsPage(ctx, userID, page)
+}
+func (r *slowLoggedRepo) DeleteAccount(ctx context.Context, userID string, accountID int64) error {
+	defer r.observe(ctx, time.Now(), "DeleteAccount", userID, accountID)
+	return r.repo.DeleteAccount(ctx, userID, accountID)
+}
+func (r *slowLoggedRepo) MarkAccountNeedsReauth(ctx context.Context, accountID int64) error {
+	defer r.observe(ctx, time.Now(), "MarkAccountNeedsReauth", accountID)
+	return r.repo.MarkAccountNeedsReauth(ctx, accountID)
+}
+func (r *slowLoggedRepo) StoreAccount(ctx context.Context, userID string, account *api.ExternalAccount) error {
+	defer r.observe(ctx, time.Now(), "StoreAccount", userID, account)
+	return r.repo.StoreAccount(ctx, userID, account)
+}
+func (r *slowLoggedRepo) UpgradeWidgetConfigs(ctx context.Context, page api.PageRequest) (int, string, error) {
+	defer r.observe(ctx, time.Now(), "UpgradeWidgetConfigs", page)
+	return r.repo.UpgradeWidgetConfigs(ctx, page)
+}
+func (r *slowLoggedRepo) ReencryptTokens(ctx context.Context, page api.PageRequest) (int, string, error) {
+	defer r.observe(ctx, time.Now(), "ReencryptTokens", page)
+	return r.repo.ReencryptTokens(ctx, page)
+}
+func (r *slowLoggedRepo) GetTemporaryCode(ctx context.Context, serviceName string, code string) (api.TemporaryCode, error) {
+	defer r.observe(ctx, time.Now(), "GetTemporaryCode", serviceName, code)
+	return r.repo.GetTemporaryCode(ctx, serviceName, code)
+}
+func (r *slowLoggedRepo) StoreTemporaryCode(ctx context.Context, code api.TemporaryCode) error {
+	defer r.observe(ctx, time.Now(), "StoreTemporaryCode", code)
+	return r.repo.StoreTemporaryCode(ctx, code)
+}
+func (r *slowLoggedRepo) DeleteTemporaryCode(ctx context.Context, userID string, serviceName string) error {
+	defer r.observe(ctx, time.Now(), "DeleteTemporaryCode", userID, serviceName)
+	return r.repo.DeleteTemporaryCode(ctx, userID, serviceName)
+}
+func (r *slowLoggedRepo) DeleteTemporaryCodesBefore(ctx context.Context, before time.Time) (int64, error) {
+	defer r.observe(ctx, time.Now(), "DeleteTemporaryCodesBefore", before)
+	return r.repo.DeleteTemporaryCodesBefore(ctx, before)
+}
+func (r *slowLoggedRepo) GetEmailItem(ctx context.Context, account api.ExternalAccount, guid string, minVersion uint64) (api.EmailItem, error) {
+	defer r.observe(ctx, time.Now(), "GetEmailItem", account, guid, minVersion)
+	return r.repo.GetEmailItem(ctx, account, guid, minVersion)
+}
+func (r *slowLoggedRepo) StoreEmailItem(ctx context.Context, account api.ExternalAccount, version uint64, item api.EmailItem) error {
+	defer r.observe(ctx, time.Now(), "StoreEmailItem", account, version, item)
+	return r.repo.StoreEmailItem(ctx, account, version, item)
+}
+func (r *slowLoggedRepo) InvalidateEmailItems(ctx context.Context, providerName string, accountID string, version uint64) error {
+	defer r.observe(ctx, time.Now(), "InvalidateEmailItems", providerName, accountID, version)
+	return r.repo.InvalidateEmailItems(ctx, providerName, accountID, version)
+}
+func (r *slowLoggedRepo) GetEmailSync(ctx context.Context, account api.ExternalAccount) (api.EmailSync, error) {
+	defer r.observe(ctx, time.Now(), "GetEmailSync", account)
+	return r.repo.GetEmailSync(ctx, account)
+}
+func (r *slowLoggedRepo) StoreEmailSync(ctx context.Context, sync api.EmailSync) error {
+	defer r.observe(ctx, time.Now(), "StoreEmailSync", sync)
+	return r.repo.StoreEmailSync(ctx, sync)
+}
+func (r *slowLoggedRepo) GetManagedPolicy(ctx context.Context, userID string) (api.ManagedPolicy, error) {
+	defer r.observe(ctx, time.Now(), "GetManagedPolicy", userID)
+	return r.repo.GetManagedPolicy(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreManagedPolicy(ctx context.Context, policy api.ManagedPolicy) error {
+	defer r.observe(ctx, time.Now(), "StoreManagedPolicy", policy)
+	return r.repo.StoreManagedPolicy(ctx, policy)
+}
+func (r *slowLoggedRepo) DeleteManagedPolicy(ctx context.Context, userID string) error {
+	defer r.observe(ctx, time.Now(), "DeleteManagedPolicy", userID)
+	return r.repo.DeleteManagedPolicy(ctx, userID)
+}
+func (r *slowLoggedRepo) GetLinkPolicies(ctx context.Context, userID string) ([]api.LinkPolicy, error) {
+	defer r.observe(ctx, time.Now(), "GetLinkPolicies", userID)
+	return r.repo.GetLinkPolicies(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreLinkPolicies(ctx context.Context, userID string, policies []api.LinkPolicy) error {
+	defer r.observe(ctx, time.Now(), "StoreLinkPolicies", userID, policies)
+	return r.repo.StoreLinkPolicies(ctx, userID, policies)
+}
+func (r *slowLoggedRepo) GetAPITokens(ctx context.Context, userID string) ([]api.APIToken, error) {
+	defer r.observe(ctx, time.Now(), "GetAPITokens", userID)
+	return r.repo.GetAPITokens(ctx, userID)
+}
+func (r *slowLoggedRepo) GetAPITokenByHash(ctx context.Context, hash string) (api.APIToken, error) {
+	defer r.observe(ctx, time.Now(), "GetAPITokenByHash", hash)
+	return r.repo.GetAPITokenByHash(ctx, hash)
+}
+func (r *slowLoggedRepo) StoreAPIToken(ctx context.Context, token *api.APIToken) error {
+	defer r.observe(ctx, time.Now(), "StoreAPIToken", token)
+	return r.repo.StoreAPIToken(ctx, token)
+}
+func (r *slowLoggedRepo) StoreAPITokenUse(ctx context.Context, tokenID int64, used time.Time) error {
+	defer r.observe(ctx, time.Now(), "StoreAPITokenUse", tokenID, used)
+	return r.repo.StoreAPITokenUse(ctx, tokenID, used)
+}
+func (r *slowLoggedRepo) DeleteAPIToken(ctx context.Context, userID string, tokenID int64) error {
+	defer r.observe(ctx, time.Now(), "DeleteAPIToken", userID, tokenID)
+	return r.repo.DeleteAPIToken(ctx, userID, tokenID)
+}
+func (r *slowLoggedRepo) GetRetentionPolicy(ctx context.Context, userID string) (api.RetentionPolicy, error) {
+	defer r.observe(ctx, time.Now(), "GetRetentionPolicy", userID)
+	return r.repo.GetRetentionPolicy(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreRetentionPolicy(ctx context.Context, policy api.RetentionPolicy) error {
+	defer r.observe(ctx, time.Now(), "StoreRetentionPolicy", policy)
+	return r.repo.StoreRetentionPolicy(ctx, policy)
+}
+func (r *slowLoggedRepo) CountReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	defer r.observe(ctx, time.Now(), "CountReadItemsBefore", userID, before)
+	return r.repo.CountReadItemsBefore(ctx, userID, before)
+}
+func (r *slowLoggedRepo) DeleteReadItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	defer r.observe(ctx, time.Now(), "DeleteReadItemsBefore", userID, before)
+	return r.repo.DeleteReadItemsBefore(ctx, userID, before)
+}
+func (r *slowLoggedRepo) CountClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	defer r.observe(ctx, time.Now(), "CountClickHistoryBefore", userID, before)
+	return r.repo.CountClickHistoryBefore(ctx, userID, before)
+}
+func (r *slowLoggedRepo) DeleteClickHistoryBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	defer r.observe(ctx, time.Now(), "DeleteClickHistoryBefore", userID, before)
+	return r.repo.DeleteClickHistoryBefore(ctx, userID, before)
+}
+func (r *slowLoggedRepo) CountEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	defer r.observe(ctx, time.Now(), "CountEmailItemsBefore", userID, before)
+	return r.repo.CountEmailItemsBefore(ctx, userID, before)
+}
+func (r *slowLoggedRepo) DeleteEmailItemsBefore(ctx context.Context, userID string, before time.Time) (int64, error) {
+	defer r.observe(ctx, time.Now(), "DeleteEmailItemsBefore", userID, before)
+	return r.repo.DeleteEmailItemsBefore(ctx, userID, before)
+}
+func (r *slowLoggedRepo) GetLastSnapshot(ctx context.Context, userID string) (api.StoredSnapshot, error) {
+	defer r.observe(ctx, time.Now(), "GetLastSnapshot", userID)
+	return r.repo.GetLastSnapshot(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreLastSnapshot(ctx context.Context, snapshot api.StoredSnapshot) error {
+	defer r.observe(ctx, time.Now(), "StoreLastSnapshot", snapshot)
+	return r.repo.StoreLastSnapshot(ctx, snapshot)
+}
+func (r *slowLoggedRepo) GetActivities(ctx context.Context, userID string, limit int) ([]api.Activity, error) {
+	defer r.observe(ctx, time.Now(), "GetActivities", userID, limit)
+	return r.repo.GetActivities(ctx, userID, limit)
+}
+func (r *slowLoggedRepo) StoreActivity(ctx context.Context, activity *api.Activity) error {
+	defer r.observe(ctx, time.Now(), "StoreActivity", activity)
+	return r.repo.StoreActivity(ctx, activity)
+}
+func (r *slowLoggedRepo) DeleteOldActivities(ctx context.Context, userID string, keep int) (int64, error) {
+	defer r.observe(ctx, time.Now(), "DeleteOldActivities", userID)
+	return r.repo.DeleteOldActivities(ctx, userID, keep)
+}
+func (r *slowLoggedRepo) GetApprovalRequests(ctx context.Context, userID string) ([]api.ApprovalRequest, error) {
+	defer r.observe(ctx, time.Now(), "GetApprovalRequests", userID)
+	return r.repo.GetApprovalRequests(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreApprovalRequest(ctx context.Context, request *api.ApprovalRequest) error {
+	defer r.observe(ctx, time.Now(), "StoreApprovalRequest", request)
+	return r.repo.StoreApprovalRequest(ctx, request)
+}
+func (r *slowLoggedRepo) GetStarredItems(ctx context.Context, userID string) ([]api.StarredItem, error) {
+	defer r.observe(ctx, time.Now(), "GetStarredItems", userID)
+	return r.repo.GetStarredItems(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreStarredItem(ctx context.Context, item api.StarredItem) error {
+	defer r.observe(ctx, time.Now(), "StoreStarredItem", item)
+	return r.repo.StoreStarredItem(ctx, item)
+}
+func (r *slowLoggedRepo) DeleteStarredItem(ctx context.Context, userID string, feedID int64, guid string) error {
+	defer r.observe(ctx, time.Now(), "DeleteStarredItem", userID, feedID, guid)
+	return r.repo.DeleteStarredItem(ctx, userID, feedID, guid)
+}
+func (r *slowLoggedRepo) GetUserWebhook(ctx context.Context, userID string) (api.UserWebhook, error) {
+	defer r.observe(ctx, time.Now(), "GetUserWebhook", userID)
+	return r.repo.GetUserWebhook(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreUserWebhook(ctx context.Context, webhook api.UserWebhook) error {
+	defer r.observe(ctx, time.Now(), "StoreUserWebhook", webhook)
+	return r.repo.StoreUserWebhook(ctx, webhook)
+}
+func (r *slowLoggedRepo) DeleteUserWebhook(ctx context.Context, userID string) error {
+	defer r.observe(ctx, time.Now(), "DeleteUserWebhook", userID)
+	return r.repo.DeleteUserWebhook(ctx, userID)
+}
+func (r *slowLoggedRepo) GetNotificationSettings(ctx context.Context, userID string) (api.NotificationSettings, error) {
+	defer r.observe(ctx, time.Now(), "GetNotificationSettings", userID)
+	return r.repo.GetNotificationSettings(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreNotificationSettings(ctx context.Context, settings api.NotificationSettings) error {
+	defer r.observe(ctx, time.Now(), "StoreNotificationSettings", settings)
+	return r.repo.StoreNotificationSettings(ctx, settings)
+}
+func (r *slowLoggedRepo) DeleteNotificationSettings(ctx context.Context, userID string) error {
+	defer r.observe(ctx, time.Now(), "DeleteNotificationSettings", userID)
+	return r.repo.DeleteNotificationSettings(ctx, userID)
+}
+func (r *slowLoggedRepo) GetTags(ctx context.Context, userID string) ([]api.Tag, error) {
+	defer r.observe(ctx, time.Now(), "GetTags", userID)
+	return r.repo.GetTags(ctx, userID)
+}
+func (r *slowLoggedRepo) StoreTag(ctx context.Context, tag api.Tag) error {
+	defer r.observe(ctx, time.Now(), "StoreTag", tag)
+	return r.repo.StoreTag(ctx, tag)
+}
+func (r *slowLoggedRepo) DeleteTag(ctx context.Context, userID string, name string) error {
+	defer r.observe(ctx, time.Now(), "DeleteTag", userID, name)
+	return r.repo.DeleteTag(ctx, userID, name)
+}
+func (r *slowLoggedRepo) GetWidgetViews(ctx context.Context, userID string) ([]api.WidgetView, error) {
+	defer r.observe(ctx, time.Now(), "GetWidgetViews", userID)
+	return r.repo.GetWidgetViews(ctx, userID)
+}
+func (r *slowLoggedRepo) RecordWidgetView(ctx context.Context, view api.WidgetView) error {
+	defer r.observe(ctx, time.Now(), "RecordWidgetView", view)
+	return r.repo.RecordWidgetView(ctx, view)
+}
+func (r *slowLoggedRepo) GetViewedWidgets(ctx context.Context, since time.Time) ([]api.Widget, error) {
+	defer r.observe(ctx, time.Now(), "GetViewedWidgets", since)
+	return r.repo.GetViewedWidgets(ctx, since)
+}
+func (r *slowLoggedRepo) StoreAuditEvent(ctx context.Context, event *api.AuditEvent) error {
+	defer r.observe(ctx, time.Now(), "StoreAuditEvent", event)
+	return r.repo.StoreAuditEvent(ctx, event)
+}
+func (r *slowLoggedRepo) GetAuditEventsPage(ctx context.Context, filter api.AuditFilter, page api.PageRequest) ([]api.AuditEvent, string, error) {
+	defer r.observe(ctx, time.Now(), "GetAuditEventsPage", filter, page)
+	return r.repo.GetAuditEventsPage(ctx, filter, page)
+}
+func (r *slowLoggedRepo) AddUsage(ctx context.Context, userID string, day time.Time, usage api.Usage) error {
+	defer r.observe(ctx, time.Now(), "AddUsage", userID, day, usage)
+	return r.repo.AddUsage(ctx, userID, day, usage)
+}
+func (r *slowLoggedRepo) GetUsage(ctx context.Context, userID string, since time.Time) ([]api.DailyUsage, error) {
+	defer r.observe(ctx, time.Now(), "GetUsage", userID, since)
+	return r.repo.GetUsage(ctx, userID, since)
+}
+func (r *slowLoggedRepo) DeleteUsageBefore(ctx context.Context, before time.Time) (int64, error) {
+	defer r.observe(ctx, time.Now(), "DeleteUsageBefore", before)
+	return r.repo.DeleteUsageBefore(ctx, before)
+}
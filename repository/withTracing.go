@@ -0,0 +1,607 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package repository
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//WithTracing wraps a repository to record a span for each of its calls.
+//Not found errors are not recorded as failures.
+func WithTracing(r api.Repository) api.Repository {
+	return &tracedRepo{
+		repo: r,
+	}
+}
+
+type tracedRepo struct {
+	repo api.Repository
+}
+
+func (r *tracedRepo) end(span trace.Span, err *error) {
+	if *err != nil && !r.repo.IsNotFound(*err) {
+		tracing.End(span, *err)
+		return
+	}
+	span.End()
+}
+
+func (r *tracedRepo) IsNotFound(err error) bool {
+	return r.repo.IsNotFound(err)
+}
+
+func (r *tracedRepo) Close() error {
+	return r.repo.Close()
+}
+func (r *tracedRepo) Optimize(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.Optimize")
+	defer r.end(span, &err)
+	return r.repo.Optimize(ctx)
+}
+
+//LockStats gives the lock contention of the traced repository, empty if it has no lock
+func (r *tracedRepo) LockStats() (api.LockStats, error) {
+	inspector, ok := r.repo.(api.LockInspector)
+	if !ok {
+		return api.LockStats{Held: []api.LockHolder{}, Waiting: []api.LockHolder{}, Waits: []api.LockWait{}}, nil
+	}
+	return inspector.LockStats()
+}
+
+//RunInTransaction records the whole transaction, the repository given to f also recording its calls
+func (r *tracedRepo) RunInTransaction(ctx context.Context, f func(repo api.Repository) error) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.RunInTransaction")
+	defer r.end(span, &err)
+	return r.repo.RunInTransaction(ctx, func(repo api.Repository) error {
+		return f(WithTracing(repo))
+	})
+}
+
+func (r *tracedRepo) GetUser(ctx context.Context, userID string) (_ api.User, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetUser")
+	defer r.end(span, &err)
+	return r.repo.GetUser(ctx, userID)
+}
+func (r *tracedRepo) StoreUser(ctx context.Context, user *api.User) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreUser")
+	defer r.end(span, &err)
+	return r.repo.StoreUser(ctx, user)
+}
+func (r *tracedRepo) SetUserTimeZone(ctx context.Context, userID string, timeZone string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.SetUserTimeZone")
+	defer r.end(span, &err)
+	return r.repo.SetUserTimeZone(ctx, userID, timeZone)
+}
+func (r *tracedRepo) SetUserLocale(ctx context.Context, userID string, locale string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.SetUserLocale")
+	defer r.end(span, &err)
+	return r.repo.SetUserLocale(ctx, userID, locale)
+}
+func (r *tracedRepo) SetUserAdmin(ctx context.Context, userID string, admin bool) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.SetUserAdmin")
+	defer r.end(span, &err)
+	return r.repo.SetUserAdmin(ctx, userID, admin)
+}
+func (r *tracedRepo) DeleteUser(ctx context.Context, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteUser")
+	defer r.end(span, &err)
+	return r.repo.DeleteUser(ctx, userID)
+}
+func (r *tracedRepo) GetUsersPage(ctx context.Context, page api.PageRequest) (_ []api.User, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetUsersPage")
+	defer r.end(span, &err)
+	return r.repo.GetUsersPage(ctx, page)
+}
+func (r *tracedRepo) GetUserStats(ctx context.Context, userID string) (_ api.UserStats, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetUserStats")
+	defer r.end(span, &err)
+	return r.repo.GetUserStats(ctx, userID)
+}
+func (r *tracedRepo) GetFeedStats(ctx context.Context) (_ api.FeedStats, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetFeedStats")
+	defer r.end(span, &err)
+	return r.repo.GetFeedStats(ctx)
+}
+func (r *tracedRepo) GetTabs(ctx context.Context, userID string) (_ []api.TabSummary, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetTabs")
+	defer r.end(span, &err)
+	return r.repo.GetTabs(ctx, userID)
+}
+func (r *tracedRepo) GetOwnedTabIDs(ctx context.Context, userID string) (_ []int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetOwnedTabIDs")
+	defer r.end(span, &err)
+	return r.repo.GetOwnedTabIDs(ctx, userID)
+}
+func (r *tracedRepo) GetTabsPage(ctx context.Context, userID string, page api.PageRequest) (_ []api.TabSummary, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetTabsPage")
+	defer r.end(span, &err)
+	return r.repo.GetTabsPage(ctx, userID, page)
+}
+func (r *tracedRepo) UpdateTabPositions(ctx context.Context, userID string, tabIDs []int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.UpdateTabPositions")
+	defer r.end(span, &err)
+	return r.repo.UpdateTabPositions(ctx, userID, tabIDs)
+}
+func (r *tracedRepo) GetTabSlug(ctx context.Context, userID string, slug string) (_ api.TabSlug, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetTabSlug")
+	defer r.end(span, &err)
+	return r.repo.GetTabSlug(ctx, userID, slug)
+}
+func (r *tracedRepo) GetCurrentTabSlug(ctx context.Context, userID string, tabID int64) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetCurrentTabSlug")
+	defer r.end(span, &err)
+	return r.repo.GetCurrentTabSlug(ctx, userID, tabID)
+}
+func (r *tracedRepo) StoreTabSlug(ctx context.Context, slug api.TabSlug) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreTabSlug")
+	defer r.end(span, &err)
+	return r.repo.StoreTabSlug(ctx, slug)
+}
+func (r *tracedRepo) IsTabAccessAllowed(ctx context.Context, userID string, tabID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.IsTabAccessAllowed")
+	defer r.end(span, &err)
+	return r.repo.IsTabAccessAllowed(ctx, userID, tabID)
+}
+func (r *tracedRepo) AllowTabAccess(ctx context.Context, userID string, tabID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.AllowTabAccess")
+	defer r.end(span, &err)
+	return r.repo.AllowTabAccess(ctx, userID, tabID)
+}
+func (r *tracedRepo) SetDefaultTab(ctx context.Context, userID string, tabID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.SetDefaultTab")
+	defer r.end(span, &err)
+	return r.repo.SetDefaultTab(ctx, userID, tabID)
+}
+func (r *tracedRepo) GetTab(ctx context.Context, tabID int64) (_ api.Tab, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetTab")
+	defer r.end(span, &err)
+	return r.repo.GetTab(ctx, tabID)
+}
+func (r *tracedRepo) StoreTab(ctx context.Context, tab *api.Tab) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreTab")
+	defer r.end(span, &err)
+	return r.repo.StoreTab(ctx, tab)
+}
+func (r *tracedRepo) DeleteTab(ctx context.Context, tabID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteTab")
+	defer r.end(span, &err)
+	return r.repo.DeleteTab(ctx, tabID)
+}
+func (r *tracedRepo) GetWidget(ctx context.Context, tabID int64, widgetID int64) (_ api.Widget, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetWidget")
+	defer r.end(span, &err)
+	return r.repo.GetWidget(ctx, tabID, widgetID)
+}
+func (r *tracedRepo) StoreWidget(ctx context.Context, tabID int64, widget *api.Widget) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreWidget")
+	defer r.end(span, &err)
+	return r.repo.StoreWidget(ctx, tabID, widget)
+}
+func (r *tracedRepo) DeleteWidget(ctx context.Context, tabID int64, widgetID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteWidget")
+	defer r.end(span, &err)
+	return r.repo.DeleteWidget(ctx, tabID, widgetID)
+}
+func (r *tracedRepo) UpdateTabLayout(ctx context.Context, tabID int64, layout [][]int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.UpdateTabLayout")
+	defer r.end(span, &err)
+	return r.repo.UpdateTabLayout(ctx, tabID, layout)
+}
+func (r *tracedRepo) DeleteWidgetFromTab(ctx context.Context, tabID int64, widgetID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteWidgetFromTab")
+	defer r.end(span, &err)
+	return r.repo.DeleteWidgetFromTab(ctx, tabID, widgetID)
+}
+func (r *tracedRepo) GetOrCreateFeedID(ctx context.Context, URL string) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetOrCreateFeedID")
+	defer r.end(span, &err)
+	return r.repo.GetOrCreateFeedID(ctx, URL)
+}
+func (r *tracedRepo) GetFeedID(ctx context.Context, URL string) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetFeedID")
+	defer r.end(span, &err)
+	return r.repo.GetFeedID(ctx, URL)
+}
+func (r *tracedRepo) GetFeed(ctx context.Context, feedID int64) (_ api.Feed, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetFeed")
+	defer r.end(span, &err)
+	return r.repo.GetFeed(ctx, feedID)
+}
+func (r *tracedRepo) GetFeedsPage(ctx context.Context, page api.PageRequest) (_ []api.Feed, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetFeedsPage")
+	defer r.end(span, &err)
+	return r.repo.GetFeedsPage(ctx, page)
+}
+func (r *tracedRepo) GetFeedItems(ctx context.Context, feedID int64) (_ []api.FeedItem, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetFeedItems")
+	defer r.end(span, &err)
+	return r.repo.GetFeedItems(ctx, feedID)
+}
+func (r *tracedRepo) GetFeedItemsBefore(ctx context.Context, feedID int64, before api.ItemCursor, limit int) (_ []api.FeedItem, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetFeedItemsBefore")
+	defer r.end(span, &err)
+	return r.repo.GetFeedItemsBefore(ctx, feedID, before, limit)
+}
+func (r *tracedRepo) GetFeedItemsForUser(ctx context.Context, userID string, feedID int64, before api.ItemCursor, limit int) (_ []api.ItemForUser, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetFeedItemsForUser")
+	defer r.end(span, &err)
+	return r.repo.GetFeedItemsForUser(ctx, userID, feedID, before, limit)
+}
+func (r *tracedRepo) GetMostReadFeedIDs(ctx context.Context, userID string, limit int) (_ []int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetMostReadFeedIDs")
+	defer r.end(span, &err)
+	return r.repo.GetMostReadFeedIDs(ctx, userID, limit)
+}
+func (r *tracedRepo) GetFeedUserIDs(ctx context.Context, feedID int64) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetFeedUserIDs")
+	defer r.end(span, &err)
+	return r.repo.GetFeedUserIDs(ctx, feedID)
+}
+func (r *tracedRepo) StoreFeed(ctx context.Context, feed *api.Feed, feedItems []api.FeedItem) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreFeed")
+	defer r.end(span, &err)
+	return r.repo.StoreFeed(ctx, feed, feedItems)
+}
+func (r *tracedRepo) DeleteFeed(ctx context.Context, feedID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteFeed")
+	defer r.end(span, &err)
+	return r.repo.DeleteFeed(ctx, feedID)
+}
+func (r *tracedRepo) DeleteOrphanFeeds(ctx context.Context, retrievedBefore time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteOrphanFeeds")
+	defer r.end(span, &err)
+	return r.repo.DeleteOrphanFeeds(ctx, retrievedBefore)
+}
+func (r *tracedRepo) GetReadItems(ctx context.Context, userID string, feedID int64) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetReadItems")
+	defer r.end(span, &err)
+	return r.repo.GetReadItems(ctx, userID, feedID)
+}
+func (r *tracedRepo) SetItemRead(ctx context.Context, userID string, feedID int64, guid string, read bool) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.SetItemRead")
+	defer r.end(span, &err)
+	return r.repo.SetItemRead(ctx, userID, feedID, guid, read)
+}
+func (r *tracedRepo) GetRankingModel(ctx context.Context, userID string) (_ api.RankingModel, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetRankingModel")
+	defer r.end(span, &err)
+	return r.repo.GetRankingModel(ctx, userID)
+}
+func (r *tracedRepo) StoreRankingModel(ctx context.Context, model api.RankingModel) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreRankingModel")
+	defer r.end(span, &err)
+	return r.repo.StoreRankingModel(ctx, model)
+}
+func (r *tracedRepo) GetItemAbstracts(ctx context.Context, feedID int64, guids []string) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetItemAbstracts")
+	defer r.end(span, &err)
+	return r.repo.GetItemAbstracts(ctx, feedID, guids)
+}
+func (r *tracedRepo) StoreItemAbstract(ctx context.Context, feedID int64, guid string, abstract string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreItemAbstract")
+	defer r.end(span, &err)
+	return r.repo.StoreItemAbstract(ctx, feedID, guid, abstract)
+}
+func (r *tracedRepo) SetItemsRead(ctx context.Context, userID string, feedID int64, guid []string, read bool) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.SetItemsRead")
+	defer r.end(span, &err)
+	return r.repo.SetItemsRead(ctx, userID, feedID, guid, read)
+}
+func (r *tracedRepo) GetAccount(ctx context.Context, userID string, accountID int64) (_ api.ExternalAccount, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetAccount")
+	defer r.end(span, &err)
+	return r.repo.GetAccount(ctx, userID, accountID)
+}
+func (r *tracedRepo) GetAccounts(ctx context.Context, userID string) (_ []api.ExternalAccount, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetAccounts")
+	defer r.end(span, &err)
+	return r.repo.GetAccounts(ctx, userID)
+}
+func (r *tracedRepo) GetAccountsPage(ctx context.Context, userID string, page api.PageRequest) (_ []api.ExternalAccount, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetAccountsPage")
+	defer r.end(span, &err)
+	return r.repo.GetAccountsPage(ctx, userID, page)
+}
+func (r *tracedRepo) DeleteAccount(ctx context.Context, userID string, accountID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteAccount")
+	defer r.end(span, &err)
+	return r.repo.DeleteAccount(ctx, userID, accountID)
+}
+func (r *tracedRepo) MarkAccountNeedsReauth(ctx context.Context, accountID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.MarkAccountNeedsReauth")
+	defer r.end(span, &err)
+	return r.repo.MarkAccountNeedsReauth(ctx, accountID)
+}
+func (r *tracedRepo) StoreAccount(ctx context.Context, userID string, account *api.ExternalAccount) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreAccount")
+	defer r.end(span, &err)
+	return r.repo.StoreAccount(ctx, userID, account)
+}
+func (r *tracedRepo) UpgradeWidgetConfigs(ctx context.Context, page api.PageRequest) (_ int, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.UpgradeWidgetConfigs")
+	defer r.end(span, &err)
+	return r.repo.UpgradeWidgetConfigs(ctx, page)
+}
+func (r *tracedRepo) ReencryptTokens(ctx context.Context, page api.PageRequest) (_ int, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.ReencryptTokens")
+	defer r.end(span, &err)
+	return r.repo.ReencryptTokens(ctx, page)
+}
+func (r *tracedRepo) GetTemporaryCode(ctx context.Context, serviceName string, code string) (_ api.TemporaryCode, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetTemporaryCode")
+	defer r.end(span, &err)
+	return r.repo.GetTemporaryCode(ctx, serviceName, code)
+}
+func (r *tracedRepo) StoreTemporaryCode(ctx context.Context, code api.TemporaryCode) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreTemporaryCode")
+	defer r.end(span, &err)
+	return r.repo.StoreTemporaryCode(ctx, code)
+}
+func (r *tracedRepo) DeleteTemporaryCode(ctx context.Context, userID string, serviceName string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteTemporaryCode")
+	defer r.end(span, &err)
+	return r.repo.DeleteTemporaryCode(ctx, userID, serviceName)
+}
+func (r *tracedRepo) DeleteTemporaryCodesBefore(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteTemporaryCodesBefore")
+	defer r.end(span, &err)
+	return r.repo.DeleteTemporaryCodesBefore(ctx, before)
+}
+func (r *tracedRepo) GetEmailItem(ctx context.Context, account api.ExternalAccount, guid string, minVersion uint64) (_ api.EmailItem, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetEmailItem")
+	defer r.end(span, &err)
+	return r.repo.GetEmailItem(ctx, account, guid, minVersion)
+}
+func (r *tracedRepo) StoreEmailItem(ctx context.Context, account api.ExternalAccount, version uint64, item api.EmailItem) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreEmailItem")
+	defer r.end(span, &err)
+	return r.repo.StoreEmailItem(ctx, account, version, item)
+}
+func (r *tracedRepo) InvalidateEmailItems(ctx context.Context, providerName string, accountID string, version uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.InvalidateEmailItems")
+	defer r.end(span, &err)
+	return r.repo.InvalidateEmailItems(ctx, providerName, accountID, version)
+}
+func (r *tracedRepo) GetEmailSync(ctx context.Context, account api.ExternalAccount) (_ api.EmailSync, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetEmailSync")
+	defer r.end(span, &err)
+	return r.repo.GetEmailSync(ctx, account)
+}
+func (r *tracedRepo) StoreEmailSync(ctx context.Context, sync api.EmailSync) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreEmailSync")
+	defer r.end(span, &err)
+	return r.repo.StoreEmailSync(ctx, sync)
+}
+func (r *tracedRepo) GetManagedPolicy(ctx context.Context, userID string) (_ api.ManagedPolicy, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetManagedPolicy")
+	defer r.end(span, &err)
+	return r.repo.GetManagedPolicy(ctx, userID)
+}
+func (r *tracedRepo) StoreManagedPolicy(ctx context.Context, policy api.ManagedPolicy) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreManagedPolicy")
+	defer r.end(span, &err)
+	return r.repo.StoreManagedPolicy(ctx, policy)
+}
+func (r *tracedRepo) DeleteManagedPolicy(ctx context.Context, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteManagedPolicy")
+	defer r.end(span, &err)
+	return r.repo.DeleteManagedPolicy(ctx, userID)
+}
+func (r *tracedRepo) GetLinkPolicies(ctx context.Context, userID string) (_ []api.LinkPolicy, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetLinkPolicies")
+	defer r.end(span, &err)
+	return r.repo.GetLinkPolicies(ctx, userID)
+}
+func (r *tracedRepo) StoreLinkPolicies(ctx context.Context, userID string, policies []api.LinkPolicy) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreLinkPolicies")
+	defer r.end(span, &err)
+	return r.repo.StoreLinkPolicies(ctx, userID, policies)
+}
+func (r *tracedRepo) GetAPITokens(ctx context.Context, userID string) (_ []api.APIToken, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetAPITokens")
+	defer r.end(span, &err)
+	return r.repo.GetAPITokens(ctx, userID)
+}
+func (r *tracedRepo) GetAPITokenByHash(ctx context.Context, hash string) (_ api.APIToken, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetAPITokenByHash")
+	defer r.end(span, &err)
+	return r.repo.GetAPITokenByHash(ctx, hash)
+}
+func (r *tracedRepo) StoreAPIToken(ctx context.Context, token *api.APIToken) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreAPIToken")
+	defer r.end(span, &err)
+	return r.repo.StoreAPIToken(ctx, token)
+}
+func (r *tracedRepo) StoreAPITokenUse(ctx context.Context, tokenID int64, used time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreAPITokenUse")
+	defer r.end(span, &err)
+	return r.repo.StoreAPITokenUse(ctx, tokenID, used)
+}
+func (r *tracedRepo) DeleteAPIToken(ctx context.Context, userID string, tokenID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteAPIToken")
+	defer r.end(span, &err)
+	return r.repo.DeleteAPIToken(ctx, userID, tokenID)
+}
+func (r *tracedRepo) GetRetentionPolicy(ctx context.Context, userID string) (_ api.RetentionPolicy, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetRetentionPolicy")
+	defer r.end(span, &err)
+	return r.repo.GetRetentionPolicy(ctx, userID)
+}
+func (r *tracedRepo) StoreRetentionPolicy(ctx context.Context, policy api.RetentionPolicy) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreRetentionPolicy")
+	defer r.end(span, &err)
+	return r.repo.StoreRetentionPolicy(ctx, policy)
+}
+func (r *tracedRepo) CountReadItemsBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.CountReadItemsBefore")
+	defer r.end(span, &err)
+	return r.repo.CountReadItemsBefore(ctx, userID, before)
+}
+func (r *tracedRepo) DeleteReadItemsBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteReadItemsBefore")
+	defer r.end(span, &err)
+	return r.repo.DeleteReadItemsBefore(ctx, userID, before)
+}
+func (r *tracedRepo) CountClickHistoryBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.CountClickHistoryBefore")
+	defer r.end(span, &err)
+	return r.repo.CountClickHistoryBefore(ctx, userID, before)
+}
+func (r *tracedRepo) DeleteClickHistoryBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteClickHistoryBefore")
+	defer r.end(span, &err)
+	return r.repo.DeleteClickHistoryBefore(ctx, userID, before)
+}
+func (r *tracedRepo) CountEmailItemsBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.CountEmailItemsBefore")
+	defer r.end(span, &err)
+	return r.repo.CountEmailItemsBefore(ctx, userID, before)
+}
+func (r *tracedRepo) DeleteEmailItemsBefore(ctx context.Context, userID string, before time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteEmailItemsBefore")
+	defer r.end(span, &err)
+	return r.repo.DeleteEmailItemsBefore(ctx, userID, before)
+}
+func (r *tracedRepo) GetLastSnapshot(ctx context.Context, userID string) (_ api.StoredSnapshot, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetLastSnapshot")
+	defer r.end(span, &err)
+	return r.repo.GetLastSnapshot(ctx, userID)
+}
+func (r *tracedRepo) StoreLastSnapshot(ctx context.Context, snapshot api.StoredSnapshot) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreLastSnapshot")
+	defer r.end(span, &err)
+	return r.repo.StoreLastSnapshot(ctx, snapshot)
+}
+func (r *tracedRepo) GetActivities(ctx context.Context, userID string, limit int) (_ []api.Activity, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetActivities")
+	defer r.end(span, &err)
+	return r.repo.GetActivities(ctx, userID, limit)
+}
+func (r *tracedRepo) StoreActivity(ctx context.Context, activity *api.Activity) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreActivity")
+	defer r.end(span, &err)
+	return r.repo.StoreActivity(ctx, activity)
+}
+func (r *tracedRepo) DeleteOldActivities(ctx context.Context, userID string, keep int) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteOldActivities")
+	defer r.end(span, &err)
+	return r.repo.DeleteOldActivities(ctx, userID, keep)
+}
+func (r *tracedRepo) GetApprovalRequests(ctx context.Context, userID string) (_ []api.ApprovalRequest, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetApprovalRequests")
+	defer r.end(span, &err)
+	return r.repo.GetApprovalRequests(ctx, userID)
+}
+func (r *tracedRepo) StoreApprovalRequest(ctx context.Context, request *api.ApprovalRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreApprovalRequest")
+	defer r.end(span, &err)
+	return r.repo.StoreApprovalRequest(ctx, request)
+}
+func (r *tracedRepo) GetStarredItems(ctx context.Context, userID string) (_ []api.StarredItem, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetStarredItems")
+	defer r.end(span, &err)
+	return r.repo.GetStarredItems(ctx, userID)
+}
+func (r *tracedRepo) StoreStarredItem(ctx context.Context, item api.StarredItem) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreStarredItem")
+	defer r.end(span, &err)
+	return r.repo.StoreStarredItem(ctx, item)
+}
+func (r *tracedRepo) DeleteStarredItem(ctx context.Context, userID string, feedID int64, guid string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteStarredItem")
+	defer r.end(span, &err)
+	return r.repo.DeleteStarredItem(ctx, userID, feedID, guid)
+}
+func (r *tracedRepo) GetUserWebhook(ctx context.Context, userID string) (_ api.UserWebhook, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetUserWebhook")
+	defer r.end(span, &err)
+	return r.repo.GetUserWebhook(ctx, userID)
+}
+func (r *tracedRepo) StoreUserWebhook(ctx context.Context, webhook api.UserWebhook) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreUserWebhook")
+	defer r.end(span, &err)
+	return r.repo.StoreUserWebhook(ctx, webhook)
+}
+func (r *tracedRepo) DeleteUserWebhook(ctx context.Context, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteUserWebhook")
+	defer r.end(span, &err)
+	return r.repo.DeleteUserWebhook(ctx, userID)
+}
+func (r *tracedRepo) GetNotificationSettings(ctx context.Context, userID string) (_ api.NotificationSettings, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetNotificationSettings")
+	defer r.end(span, &err)
+	return r.repo.GetNotificationSettings(ctx, userID)
+}
+func (r *tracedRepo) StoreNotificationSettings(ctx context.Context, settings api.NotificationSettings) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreNotificationSettings")
+	defer r.end(span, &err)
+	return r.repo.StoreNotificationSettings(ctx, settings)
+}
+func (r *tracedRepo) DeleteNotificationSettings(ctx context.Context, userID string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteNotificationSettings")
+	defer r.end(span, &err)
+	return r.repo.DeleteNotificationSettings(ctx, userID)
+}
+func (r *tracedRepo) GetTags(ctx context.Context, userID string) (_ []api.Tag, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetTags")
+	defer r.end(span, &err)
+	return r.repo.GetTags(ctx, userID)
+}
+func (r *tracedRepo) StoreTag(ctx context.Context, tag api.Tag) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreTag")
+	defer r.end(span, &err)
+	return r.repo.StoreTag(ctx, tag)
+}
+func (r *tracedRepo) DeleteTag(ctx context.Context, userID string, name string) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteTag")
+	defer r.end(span, &err)
+	return r.repo.DeleteTag(ctx, userID, name)
+}
+func (r *tracedRepo) GetWidgetViews(ctx context.Context, userID string) (_ []api.WidgetView, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetWidgetViews")
+	defer r.end(span, &err)
+	return r.repo.GetWidgetViews(ctx, userID)
+}
+func (r *tracedRepo) RecordWidgetView(ctx context.Context, view api.WidgetView) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.RecordWidgetView")
+	defer r.end(span, &err)
+	return r.repo.RecordWidgetView(ctx, view)
+}
+func (r *tracedRepo) GetViewedWidgets(ctx context.Context, since time.Time) (_ []api.Widget, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetViewedWidgets")
+	defer r.end(span, &err)
+	return r.repo.GetViewedWidgets(ctx, since)
+}
+func (r *tracedRepo) StoreAuditEvent(ctx context.Context, event *api.AuditEvent) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.StoreAuditEvent")
+	defer r.end(span, &err)
+	return r.repo.StoreAuditEvent(ctx, event)
+}
+func (r *tracedRepo) GetAuditEventsPage(ctx context.Context, filter api.AuditFilter, page api.PageRequest) (_ []api.AuditEvent, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetAuditEventsPage")
+	defer r.end(span, &err)
+	return r.repo.GetAuditEventsPage(ctx, filter, page)
+}
+func (r *tracedRepo) AddUsage(ctx context.Context, userID string, day time.Time, usage api.Usage) (err error) {
+	ctx, span := tracing.Start(ctx, "Repository.AddUsage")
+	defer r.end(span, &err)
+	return r.repo.AddUsage(ctx, userID, day, usage)
+}
+func (r *tracedRepo) GetUsage(ctx context.Context, userID string, since time.Time) (_ []api.DailyUsage, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.GetUsage")
+	defer r.end(span, &err)
+	return r.repo.GetUsage(ctx, userID, since)
+}
+func (r *tracedRepo) DeleteUsageBefore(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, span := tracing.Start(ctx, "Repository.DeleteUsageBefore")
+	defer r.end(span, &err)
+	return r.repo.DeleteUsageBefore(ctx, before)
+}
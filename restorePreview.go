@@ -0,0 +1,139 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//PreviewRestore lists the tabs, widgets, feeds and items a restore of the snapshot would add or change,
+//and the conflicts that would make it fail. Nothing is restored.
+func (app App) PreviewRestore(ctx context.Context, userID string, s api.Snapshot, selection api.RestoreSelection) (api.RestorePreview, error) {
+	ctx, span := tracing.Start(ctx, "App.PreviewRestore")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "PreviewRestore")
+	if err != nil {
+		return api.RestorePreview{}, err
+	}
+
+	if userID != s.User.UserID {
+		return api.RestorePreview{}, invalidArgument(fmt.Sprintf("User IDs do not match: '%s' '%s'", userID, s.User.UserID))
+	}
+	if err := s.Validate(); err != nil {
+		return api.RestorePreview{}, invalidArgument("invalid snapshot: " + err.Error())
+	}
+
+	current, err := app.snapshot(ctx, userID)
+	if err != nil {
+		return api.RestorePreview{}, err
+	}
+
+	preview := api.RestorePreview{
+		Changes: []api.SnapshotChange{},
+	}
+
+	//Same checks as the restore
+	for _, id := range selection.TabIDs {
+		if !snapshotHasTab(s, func(t api.Tab) bool { return t.ID == id }) {
+			preview.Conflicts = append(preview.Conflicts, fmt.Sprintf("Tab %d not found in snapshot", id))
+		}
+	}
+	for _, title := range selection.Titles {
+		if !snapshotHasTab(s, func(t api.Tab) bool { return t.Title == title }) {
+			preview.Conflicts = append(preview.Conflicts, "Tab not found in snapshot: "+title)
+		}
+	}
+	if selection.IsEmpty() && len(current.Tabs) > 0 {
+		preview.Conflicts = append(preview.Conflicts, fmt.Sprintf("Restore not possible due %d to existing tabs", len(current.Tabs)))
+	}
+
+	accounts, err := app.repository.GetAccounts(ctx, userID)
+	if err != nil {
+		return api.RestorePreview{}, errors.Wrap(err, "retrieving accounts from datastore failed")
+	}
+	existingAccounts := make(map[string]bool)
+	for _, a := range accounts {
+		existingAccounts[a.Key()] = true
+	}
+	missingAccounts := make(map[int64]bool)
+	for _, a := range s.Accounts {
+		if existingAccounts[a.Key()] {
+			continue
+		}
+		missingAccounts[a.ID] = true
+		if selection.IsEmpty() {
+			preview.Conflicts = append(preview.Conflicts, "Restore not possible due to missing account: "+a.Key())
+		}
+	}
+
+	existingTitles := make(map[string]bool)
+	for _, t := range current.Tabs {
+		existingTitles[t.Title] = true
+	}
+	followedFeeds := make(map[string]bool)
+	for _, f := range current.Feeds {
+		followedFeeds[f.URL] = true
+	}
+	feeds := make(map[int64]api.Feed)
+	for _, f := range s.Feeds {
+		feeds[f.ID] = f
+	}
+
+	for _, t := range s.Tabs {
+		if !selection.Includes(t.TabSummary) {
+			continue
+		}
+
+		change := api.SnapshotChange{Kind: api.ChangeAdded, Object: "tab", Name: t.Title}
+		if existingTitles[t.Title] {
+			change.Details = "a tab has the same title"
+		}
+		preview.Changes = append(preview.Changes, change)
+
+		missingAccount := false
+		for _, col := range t.Widgets {
+			for _, w := range col {
+				switch cfg := w.Config.(type) {
+				case api.ConfigFeed:
+					f := feeds[cfg.FeedID]
+					if !followedFeeds[f.URL] {
+						preview.Changes = append(preview.Changes, api.SnapshotChange{Kind: api.ChangeAdded, Object: "feed", Name: f.URL})
+						followedFeeds[f.URL] = true
+					}
+				case api.ConfigEmail:
+					if missingAccounts[cfg.AccountID] && !selection.IsEmpty() && !missingAccount {
+						preview.Conflicts = append(preview.Conflicts, "Unknown or missing account ID in tab: "+t.Title)
+						missingAccount = true
+					}
+				}
+				preview.Changes = append(preview.Changes, api.SnapshotChange{Kind: api.ChangeAdded, Object: "widget", Name: api.WidgetTitle(w), Tab: t.Title})
+			}
+		}
+	}
+
+	if selection.Items {
+		for _, f := range s.Feeds {
+			if guids, ok := s.ReadStatus[f.ID]; ok && len(guids) > 0 {
+				preview.Changes = append(preview.Changes, api.SnapshotChange{Kind: api.ChangeUpdated, Object: "feed", Name: f.URL, Details: fmt.Sprintf("%d item(s) marked as read", len(guids))})
+			}
+		}
+		for _, item := range s.Starred {
+			preview.Changes = append(preview.Changes, api.SnapshotChange{Kind: api.ChangeAdded, Object: "starred item", Name: item.Title})
+		}
+	}
+
+	for _, c := range preview.Changes {
+		preview.Summary = append(preview.Summary, c.String())
+	}
+
+	return preview, nil
+}
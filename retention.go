@@ -0,0 +1,223 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//RetentionCleanupInterval is the period of the removal of the data past their retention
+const RetentionCleanupInterval = 24 * time.Hour
+
+//SetRetentionBounds sets the retention limits of the instance.
+//By default, the users can keep their data forever.
+func (app *App) SetRetentionBounds(bounds api.RetentionBounds) {
+	app.retention = bounds
+}
+
+//retentionCutoff returns the date before which the data are removed, or a zero time if kept forever
+func retentionCutoff(now time.Time, days int) time.Time {
+	if days <= 0 {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, -days)
+}
+
+//retentionPolicy returns the policy of the user, within the instance bounds
+func (app App) retentionPolicy(ctx context.Context, userID string) (api.RetentionPolicy, error) {
+
+	policy, err := app.repository.GetRetentionPolicy(ctx, userID)
+	if err != nil {
+		if !app.repository.IsNotFound(err) {
+			return api.RetentionPolicy{}, errors.Wrap(err, "retrieving retention policy from datastore failed")
+		}
+		policy = api.RetentionPolicy{
+			UserID:           userID,
+			ReadItemsDays:    app.retention.DefaultDays,
+			EmailCacheDays:   app.retention.DefaultDays,
+			ClickHistoryDays: app.retention.DefaultDays,
+		}
+	}
+
+	//The bounds may have changed since the policy was set
+	policy.ReadItemsDays = app.retention.Clamp(policy.ReadItemsDays)
+	policy.EmailCacheDays = app.retention.Clamp(policy.EmailCacheDays)
+	policy.ClickHistoryDays = app.retention.Clamp(policy.ClickHistoryDays)
+
+	return policy, nil
+}
+
+//retentionReport computes the amount of data of the user that the next cleanup will remove
+func (app App) retentionReport(ctx context.Context, policy api.RetentionPolicy) (api.RetentionReport, error) {
+
+	report := api.RetentionReport{
+		Policy: policy,
+		Bounds: app.retention,
+	}
+
+	now := time.Now()
+	if before := retentionCutoff(now, policy.ReadItemsDays); !before.IsZero() {
+		count, err := app.repository.CountReadItemsBefore(ctx, policy.UserID, before)
+		if err != nil {
+			return report, errors.Wrap(err, "counting expired read items failed")
+		}
+		report.ReadItems = count
+	}
+	if before := retentionCutoff(now, policy.EmailCacheDays); !before.IsZero() {
+		count, err := app.repository.CountEmailItemsBefore(ctx, policy.UserID, before)
+		if err != nil {
+			return report, errors.Wrap(err, "counting expired email items failed")
+		}
+		report.EmailItems = count
+	}
+	if before := retentionCutoff(now, policy.ClickHistoryDays); !before.IsZero() {
+		count, err := app.repository.CountClickHistoryBefore(ctx, policy.UserID, before)
+		if err != nil {
+			return report, errors.Wrap(err, "counting expired click history failed")
+		}
+		report.ClickHistory = count
+	}
+
+	return report, nil
+}
+
+//RetentionPolicy returns the retention policy of the given user and what the next cleanup will remove
+func (app App) RetentionPolicy(ctx context.Context, userID string) (api.RetentionReport, error) {
+	ctx, span := tracing.Start(ctx, "App.RetentionPolicy")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "RetentionPolicy")
+	if err != nil {
+		return api.RetentionReport{}, err
+	}
+
+	policy, err := app.retentionPolicy(ctx, userID)
+	if err != nil {
+		return api.RetentionReport{}, err
+	}
+
+	return app.retentionReport(ctx, policy)
+}
+
+//SetRetentionPolicy changes the retention policy of the given user, within the instance bounds
+func (app App) SetRetentionPolicy(ctx context.Context, userID string, policy api.RetentionPolicy) (api.RetentionReport, error) {
+	ctx, span := tracing.Start(ctx, "App.SetRetentionPolicy")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "SetRetentionPolicy")
+	if err != nil {
+		return api.RetentionReport{}, err
+	}
+
+	if !app.retention.Allows(policy.ReadItemsDays) {
+		return api.RetentionReport{}, errors.Errorf("Read items retention out of bounds: %d days", policy.ReadItemsDays)
+	}
+	if !app.retention.Allows(policy.EmailCacheDays) {
+		return api.RetentionReport{}, errors.Errorf("Email cache retention out of bounds: %d days", policy.EmailCacheDays)
+	}
+	if !app.retention.Allows(policy.ClickHistoryDays) {
+		return api.RetentionReport{}, errors.Errorf("Click history retention out of bounds: %d days", policy.ClickHistoryDays)
+	}
+
+	policy.UserID = userID
+	err = app.repository.StoreRetentionPolicy(ctx, policy)
+	if err != nil {
+		return api.RetentionReport{}, errors.Wrap(err, "storing retention policy in datastore failed")
+	}
+
+	app.audit(ctx, userID, api.AuditRetentionUpdated, "")
+
+	return app.retentionReport(ctx, policy)
+}
+
+//RunRetentionCleanup removes the data past their retention at the given interval, until the context is done
+func (app App) RunRetentionCleanup(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := app.CleanupRetention(ctx); err != nil {
+			app.Error(ctx, errors.Wrap(err, "retention cleanup failed"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//CleanupRetention removes, for all the users, the read items, cached emails and click history past their retention,
+//and the usage statistics older than MaxUsageDays
+func (app App) CleanupRetention(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "App.CleanupRetention")
+	defer span.End()
+
+	var readItems, emailItems, clicks int64
+
+	page := api.PageRequest{}
+	for {
+		users, next, err := app.repository.GetUsersPage(ctx, page)
+		if err != nil {
+			return errors.Wrap(err, "retrieving users from datastore failed")
+		}
+
+		now := time.Now()
+		for _, u := range users {
+			policy, err := app.retentionPolicy(ctx, u.UserID)
+			if err != nil {
+				return err
+			}
+
+			if before := retentionCutoff(now, policy.ReadItemsDays); !before.IsZero() {
+				count, err := app.repository.DeleteReadItemsBefore(ctx, u.UserID, before)
+				if err != nil {
+					return errors.Wrap(err, "removing expired read items of user "+u.UserID+" failed")
+				}
+				readItems += count
+			}
+			if before := retentionCutoff(now, policy.EmailCacheDays); !before.IsZero() {
+				count, err := app.repository.DeleteEmailItemsBefore(ctx, u.UserID, before)
+				if err != nil {
+					return errors.Wrap(err, "removing expired email items of user "+u.UserID+" failed")
+				}
+				emailItems += count
+			}
+			if before := retentionCutoff(now, policy.ClickHistoryDays); !before.IsZero() {
+				count, err := app.repository.DeleteClickHistoryBefore(ctx, u.UserID, before)
+				if err != nil {
+					return errors.Wrap(err, "removing expired click history of user "+u.UserID+" failed")
+				}
+				clicks += count
+			}
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		page.Cursor = next
+	}
+
+	//The usage statistics are not given beyond MaxUsageDays
+	usage, err := app.repository.DeleteUsageBefore(ctx, retentionCutoff(time.Now(), MaxUsageDays))
+	if err != nil {
+		return errors.Wrap(err, "removing expired usage failed")
+	}
+
+	if readItems > 0 || emailItems > 0 || clicks > 0 || usage > 0 {
+		app.Infof(ctx, "Retention cleanup removed %d read item(s), %d email(s), %d read date(s) and %d day(s) of usage",
+			readItems, emailItems, clicks, usage)
+	}
+
+	return nil
+}
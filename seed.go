@@ -0,0 +1,195 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//SeedFeedPrefix starts the URLs of the synthetic feeds created by Seed, never retrieved
+const SeedFeedPrefix = "https://example.com/okihome-seed/"
+
+//seedRetrieval is how long the synthetic feeds are kept without being retrieved
+const seedRetrieval = 10 * 365 * 24 * time.Hour
+
+//SeedOptions describes the demo data created by Seed, the default value being used for the unset fields
+type SeedOptions struct {
+	//UserID is the user owning the demo tabs ("demo" by default), created if unknown
+	UserID string
+	//Tabs is the number of tabs (3 by default)
+	Tabs int
+	//Widgets is the number of feed widgets by tab (6 by default)
+	Widgets int
+	//Items is the number of items by feed (20 by default)
+	Items int
+	//Columns is the number of columns of the tabs (3 by default)
+	Columns int
+	//Seed makes the generated data reproducible, the current time being used if 0
+	Seed int64
+}
+
+//seedTopics are the themes of the synthetic feeds
+var seedTopics = []struct {
+	title    string
+	subjects []string
+}{
+	{"World News", []string{"Elections", "Climate summit", "Trade talks", "Border agreement", "Peace process", "Census"}},
+	{"Technology", []string{"New smartphone", "Open source release", "Chip shortage", "Browser update", "Data breach", "Cloud outage"}},
+	{"Science", []string{"Mars rover", "Gene therapy", "Ocean survey", "Particle collider", "Fossil discovery", "Vaccine trial"}},
+	{"Sports", []string{"Cup final", "Transfer window", "Marathon record", "Tennis open", "Cycling tour", "Rugby derby"}},
+	{"Cooking", []string{"Sourdough bread", "Weeknight pasta", "Vegan curry", "Summer salads", "Chocolate cake", "Street food"}},
+	{"Travel", []string{"Hidden beaches", "Night trains", "Mountain huts", "City breaks", "Island hopping", "Road trip"}},
+	{"Finance", []string{"Interest rates", "Stock markets", "Housing prices", "Pension reform", "Crypto rally", "Inflation figures"}},
+	{"Music", []string{"Festival lineup", "Album review", "World tour", "Vinyl revival", "Jazz legend", "Concert hall"}},
+	{"Gaming", []string{"Console launch", "Indie hit", "Speedrun record", "Esports finals", "Remaster", "Patch notes"}},
+	{"Photography", []string{"Street portraits", "Night sky", "Film cameras", "Wildlife", "Drone shots", "Black and white"}},
+}
+
+//seedAngles are combined with the subjects to make the titles of the synthetic items
+var seedAngles = []string{
+	"what we know so far",
+	"a closer look",
+	"the week in review",
+	"five things to remember",
+	"experts weigh in",
+	"live updates",
+	"the complete guide",
+	"why it matters",
+}
+
+//seedTabTitles are the titles of the demo tabs, numbered once exhausted
+var seedTabTitles = []string{"News", "Leisure", "Work", "Culture", "Weekend"}
+
+//Seed creates a demo user with tabs of feed widgets, whose feeds hold synthetic items, for development,
+//screenshots and load testing. The synthetic feeds are never retrieved, their items being displayed as is.
+//The tabs are added to the existing ones of the user.
+func (app App) Seed(ctx context.Context, opts SeedOptions) ([]api.Tab, error) {
+	ctx, span := tracing.Start(ctx, "App.Seed")
+	defer span.End()
+
+	if len(opts.UserID) == 0 {
+		opts.UserID = "demo"
+	}
+	if opts.Tabs <= 0 {
+		opts.Tabs = 3
+	}
+	if opts.Widgets <= 0 {
+		opts.Widgets = 6
+	}
+	if opts.Items <= 0 {
+		opts.Items = 20
+	}
+	if opts.Columns <= 0 {
+		opts.Columns = 3
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(opts.Seed))
+
+	user, err := app.repository.GetUser(ctx, opts.UserID)
+	if err != nil {
+		if !app.repository.IsNotFound(err) {
+			return nil, errors.Wrap(err, "retrieving user from datastore failed")
+		}
+		user = api.User{UserID: opts.UserID, DisplayName: "Demo user", Email: opts.UserID + "@example.com"}
+		err = app.repository.StoreUser(ctx, &user)
+		if err != nil {
+			return nil, errors.Wrap(err, "saving user failed")
+		}
+	}
+	ctx = api.ContextWithUser(ctx, tokenUser{user: user})
+
+	now := time.Now()
+	var tabs []api.Tab
+	for t := 0; t < opts.Tabs; t++ {
+
+		title := seedTabTitles[t%len(seedTabTitles)]
+		if t >= len(seedTabTitles) {
+			title = fmt.Sprintf("%s %d", title, t/len(seedTabTitles)+1)
+		}
+
+		export := api.TabExport{Tab: api.Tab{Widgets: make([][]api.Widget, opts.Columns)}}
+		for w := 0; w < opts.Widgets; w++ {
+			topic := seedTopics[(t*opts.Widgets+w)%len(seedTopics)]
+			feed := api.Feed{
+				ID:    int64(w + 1),
+				URL:   fmt.Sprintf("%s%s/%d.xml", SeedFeedPrefix, strings.Replace(strings.ToLower(topic.title), " ", "-", -1), t*opts.Widgets+w),
+				Title: topic.title,
+			}
+			export.Feeds = append(export.Feeds, feed)
+
+			cfg := api.ConfigFeed{
+				WidgetConfig: api.WidgetConfig{Title: topic.title, DisplayCount: 5 + r.Intn(6)},
+				FeedID:       feed.ID,
+				URL:          feed.URL,
+			}
+			export.Tab.Widgets[w%opts.Columns] = append(export.Tab.Widgets[w%opts.Columns], api.NewWidgetFeed(0, cfg))
+		}
+
+		//The items are stored before the tab is displayed, so the feeds are not retrieved
+		for _, f := range export.Feeds {
+			if err := app.seedFeed(ctx, f, opts.Items, now, r); err != nil {
+				return tabs, err
+			}
+		}
+
+		tab, err := app.ImportTab(ctx, opts.UserID, export, title)
+		if err != nil {
+			return tabs, errors.Wrap(err, "creating tab "+title+" failed")
+		}
+		tabs = append(tabs, tab)
+	}
+
+	return tabs, nil
+}
+
+//seedFeed stores a feed with synthetic items, published over the last days
+func (app App) seedFeed(ctx context.Context, f api.Feed, count int, now time.Time, r *rand.Rand) error {
+
+	var subjects []string
+	for _, topic := range seedTopics {
+		if topic.title == f.Title {
+			subjects = topic.subjects
+		}
+	}
+
+	id, err := app.repository.GetOrCreateFeedID(ctx, f.URL)
+	if err != nil {
+		return errors.Wrap(err, "retrieving feed id from datastore failed")
+	}
+	feed := api.Feed{ID: id, URL: f.URL, Title: f.Title, NextRetrieval: now.Add(seedRetrieval)}
+
+	items := make([]api.FeedItem, 0, count)
+	published := now
+	for i := 0; i < count; i++ {
+		published = published.Add(-time.Duration(10+r.Intn(240)) * time.Minute)
+		subject := subjects[r.Intn(len(subjects))]
+		angle := seedAngles[r.Intn(len(seedAngles))]
+		link := fmt.Sprintf("%s/%d", strings.TrimSuffix(f.URL, ".xml"), count-i)
+		items = append(items, api.FeedItem{
+			GUID:      link,
+			Title:     subject + ": " + angle,
+			Published: published,
+			Link:      link,
+		})
+	}
+
+	err = app.repository.StoreFeed(ctx, &feed, items)
+	if err != nil {
+		return errors.Wrap(err, "storage of feed failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//redactedParameters are the query parameters whose values are not logged, as they hold secrets:
+//the OAuth2 codes and states, and the tokens
+var redactedParameters = map[string]bool{
+	"code":          true,
+	"state":         true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"client_secret": true,
+	"key":           true,
+	"password":      true,
+}
+
+//accessEntry is the access log line of a request, completed by the handlers
+type accessEntry struct {
+	user string
+}
+
+type accessEntryKey struct{}
+
+//withAccessLog logs a line for each request at Info level, with its method, path, status, duration and user.
+//The secrets of the query strings are redacted.
+func (wa webApp) withAccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{}
+		aw := &accessWriter{ResponseWriter: w, status: http.StatusOK}
+
+		h.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+
+		user := entry.user
+		if len(user) == 0 {
+			user = "-"
+		}
+		msg := "access method=%s path=%q status=%d duration=%s bytes=%d user=%s"
+		args := []interface{}{r.Method, redactURL(r.URL), aw.status, time.Since(start), aw.written, user}
+		if location := aw.Header().Get("Location"); len(location) > 0 && aw.status >= 300 && aw.status < 400 {
+			msg += " location=%q"
+			args = append(args, redactLocation(location))
+		}
+		wa.app.Infof(r.Context(), msg, args...)
+	})
+}
+
+//withAccessUser records the authenticated user in the access log of the request
+func (wa webApp) withAccessUser(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if entry, ok := r.Context().Value(accessEntryKey{}).(*accessEntry); ok {
+			entry.user, _ = wa.app.CurrentUserID(r.Context())
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+//redactURL returns the path and the query of the URL, the values of the secret parameters being replaced
+func redactURL(u *url.URL) string {
+	if len(u.RawQuery) == 0 {
+		return u.Path
+	}
+	return u.Path + "?" + redactQuery(u.RawQuery)
+}
+
+//redactLocation redacts the query of a redirection target, which may be an absolute URL
+func redactLocation(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "-"
+	}
+	u.RawQuery = redactQuery(u.RawQuery)
+	u.Fragment = ""
+	return u.String()
+}
+
+//redactQuery replaces the values of the secret parameters, keeping the order of the parameters
+func redactQuery(rawQuery string) string {
+	if len(rawQuery) == 0 {
+		return rawQuery
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		kv := strings.SplitN(param, "=", 2)
+		name, err := url.QueryUnescape(kv[0])
+		if err != nil || redactedParameters[strings.ToLower(name)] {
+			params[i] = kv[0] + "=REDACTED"
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+//accessWriter keeps the status and the size of the response
+type accessWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+//Flush lets the streamed responses be flushed through the writer
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//Hijack gives the connection to the handler, for WebSockets
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Hijacking not supported")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//Default CORS settings
+var (
+	DefaultCORSMethods = []string{"GET", "POST", "PATCH", "DELETE"}
+	DefaultCORSHeaders = []string{"Authorization", "Content-Type", "If-None-Match", RequestIDHeader, SnapshotPassphraseHeader}
+)
+
+//DefaultCORSMaxAge is how long the browsers keep the preflight responses
+const DefaultCORSMaxAge = "10m"
+
+//corsExposedHeaders are the response headers readable by the frontends
+var corsExposedHeaders = []string{"ETag", "Retry-After", RequestIDHeader, DegradedHeader}
+
+//CORS allows a frontend hosted on another origin to call the API.
+//The permissive CORS support of the base server is used if no origin is given.
+type CORS struct {
+	//AllowedOrigins are the origins of the frontends (such as "https://app.example.com"), "*" allowing any origin
+	AllowedOrigins []string
+	//AllowedMethods defaults to DefaultCORSMethods
+	AllowedMethods []string
+	//AllowedHeaders are the request headers the frontends may send, DefaultCORSHeaders if empty
+	AllowedHeaders []string
+	//AllowCredentials lets the browsers send the cookies and the authorization headers.
+	//It cannot be used with the "*" origin.
+	AllowCredentials bool
+	//MaxAge is how long the browsers keep the preflight responses (such as "1h"), DefaultCORSMaxAge if empty
+	MaxAge string
+}
+
+type corsFilter struct {
+	origins     map[string]bool
+	anyOrigin   bool
+	methods     string
+	headers     string
+	credentials bool
+	maxAge      string
+}
+
+func newCORSFilter(cfg CORS) (*corsFilter, error) {
+
+	f := &corsFilter{
+		origins:     make(map[string]bool),
+		methods:     strings.Join(DefaultCORSMethods, ", "),
+		headers:     strings.Join(DefaultCORSHeaders, ", "),
+		credentials: cfg.AllowCredentials,
+	}
+	for _, o := range cfg.AllowedOrigins {
+		if o == "*" {
+			f.anyOrigin = true
+			continue
+		}
+		f.origins[strings.TrimSuffix(o, "/")] = true
+	}
+	//Any website could otherwise call the API with the cookies of the user
+	if f.anyOrigin && f.credentials {
+		return nil, errors.New("the CORS credentials cannot be allowed for any origin")
+	}
+	if len(cfg.AllowedMethods) > 0 {
+		f.methods = strings.ToUpper(strings.Join(cfg.AllowedMethods, ", "))
+	}
+	if len(cfg.AllowedHeaders) > 0 {
+		f.headers = strings.Join(cfg.AllowedHeaders, ", ")
+	}
+
+	maxAge := cfg.MaxAge
+	if len(maxAge) == 0 {
+		maxAge = DefaultCORSMaxAge
+	}
+	d, err := parseDuration(maxAge, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid CORS max age")
+	}
+	f.maxAge = strconv.Itoa(int(d.Seconds()))
+
+	return f, nil
+}
+
+//allowOrigin sets the headers allowing the origin of the request, and returns false if it is not allowed
+func (f *corsFilter) allowOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if !varies(w.Header(), "Origin") {
+		w.Header().Add("Vary", "Origin")
+	}
+	if len(origin) == 0 || !(f.anyOrigin || f.origins[origin]) {
+		return false
+	}
+
+	//The credentials are never allowed with the wildcard
+	if f.anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if f.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+//filter adds the CORS headers to the responses to the allowed origins
+func (f *corsFilter) filter(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if f.allowOrigin(w, r) {
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+//preflight answers the OPTIONS requests sent by the browsers before the cross-origin calls
+func (f *corsFilter) preflight(w http.ResponseWriter, r *http.Request) {
+	if f.allowOrigin(w, r) && len(r.Header.Get("Access-Control-Request-Method")) > 0 {
+		w.Header().Set("Access-Control-Allow-Methods", f.methods)
+		w.Header().Set("Access-Control-Allow-Headers", f.headers)
+		w.Header().Set("Access-Control-Max-Age", f.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//varies returns true if the response already varies with the given request header
+func varies(h http.Header, header string) bool {
+	for _, v := range h["Vary"] {
+		for _, e := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(e), header) {
+				return true
+			}
+		}
+	}
+	return false
+}
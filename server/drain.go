@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/i18n"
+)
+
+//Drain stops accepting requests during the shutdown of the server and waits for the requests in progress.
+//The event streams are closed as soon as the shutdown starts.
+type Drain struct {
+	mu       sync.Mutex
+	inFlight sync.WaitGroup
+	draining bool
+	stopping chan struct{}
+}
+
+//NewDrain creates a drain to be given to New, and shut down before stopping the process
+func NewDrain() *Drain {
+	return &Drain{
+		stopping: make(chan struct{}),
+	}
+}
+
+//begin registers a new request, or returns false if the shutdown started
+func (d *Drain) begin() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inFlight.Add(1)
+	return true
+}
+
+//filter rejects the requests received during the shutdown
+func (d *Drain) filter(h http.Handler) http.Handler {
+	if d == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.begin() {
+			requestID, _ := api.RequestIDFromContext(r.Context())
+			w.Header().Set("Connection", "close")
+			w.Header().Set("Retry-After", "10")
+			writeAPIError(w, http.StatusServiceUnavailable, APIError{Code: ErrorUnavailable, Message: i18n.Translate(i18n.FromContext(r.Context()), "Server shutting down"), RequestID: requestID})
+			return
+		}
+		defer d.inFlight.Done()
+		h.ServeHTTP(w, r)
+	})
+}
+
+//stream cancels the long-lived requests, such as the event streams, when the shutdown starts
+func (d *Drain) stream(h http.Handler) http.Handler {
+	if d == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-d.stopping:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//Stop rejects the new requests and closes the event streams, without waiting for the requests in progress.
+//It lets the HTTP server be shut down without waiting for the streams.
+func (d *Drain) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.draining {
+		d.draining = true
+		close(d.stopping)
+	}
+}
+
+//Shutdown rejects the new requests and waits for the ones in progress, or for the context to be done
+func (d *Drain) Shutdown(ctx context.Context) error {
+
+	d.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "waiting for requests in progress failed")
+	}
+}
@@ -0,0 +1,255 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/i18n"
+)
+
+//ErrorCode identifies the kind of error of a failed request
+type ErrorCode string
+
+//Error codes of the API, with their HTTP status
+const (
+	ErrorBadRequest            ErrorCode = "bad_request"            //400
+	ErrorUnauthenticated       ErrorCode = "unauthenticated"        //401
+	ErrorForbidden             ErrorCode = "forbidden"              //403
+	ErrorAuthorizationRequired ErrorCode = "authorization_required" //403, details: {authorization_url}
+	ErrorReauthRequired        ErrorCode = "reauth_required"        //403, details: {account_id, reauthorize_url}
+	ErrorNotFound              ErrorCode = "not_found"              //404
+	ErrorConflict              ErrorCode = "conflict"               //409
+	ErrorInternal              ErrorCode = "internal"               //500
+	ErrorNotImplemented        ErrorCode = "not_implemented"        //501
+	ErrorProviderUnavailable   ErrorCode = "provider_unavailable"   //502
+	ErrorUnavailable           ErrorCode = "unavailable"            //503
+	ErrorTimeout               ErrorCode = "timeout"                //504
+)
+
+//APIError is the body of the responses of the API to the failed requests
+type APIError struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+	//RequestID identifies the request in the logs
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type notAuthorized interface {
+	IsNotAuthorized() bool
+}
+
+type invalid interface {
+	IsInvalid() bool
+}
+
+type notFound interface {
+	IsNotFound() bool
+}
+
+type conflict interface {
+	IsConflict() bool
+}
+
+type unsupported interface {
+	IsUnsupported() bool
+}
+
+type unavailable interface {
+	IsUnavailable() bool
+}
+
+//errorDetails is implemented by the errors giving the client more information than their message
+type errorDetails interface {
+	ErrorDetails() interface{}
+}
+
+//newAPIError classifies an error returned by the application
+func (wa webApp) newAPIError(r *http.Request, err error) (int, APIError) {
+
+	cause := errors.Cause(err)
+	apiErr := APIError{Message: err.Error()}
+	if d, ok := cause.(errorDetails); ok {
+		apiErr.Details = d.ErrorDetails()
+	}
+
+	if e, ok := cause.(invalidEntry); ok {
+		apiErr.Code = ErrorBadRequest
+		apiErr.Message = e.Error()
+		return http.StatusBadRequest, apiErr
+	}
+	if e, ok := cause.(invalid); ok && e.IsInvalid() {
+		apiErr.Code = ErrorBadRequest
+		return http.StatusBadRequest, apiErr
+	}
+	if e, ok := cause.(notAuthorized); ok && e.IsNotAuthorized() {
+		//The message may describe the resources of other users
+		apiErr.Code = ErrorForbidden
+		apiErr.Message = "Access denied"
+		apiErr.Details = nil
+		return http.StatusForbidden, apiErr
+	}
+	if e, ok := cause.(authorizationRequired); ok {
+		apiErr.Code = ErrorAuthorizationRequired
+		apiErr.Details = struct {
+			AuthorizationURL string `json:"authorization_url"`
+		}{e.AuthorizationURL()}
+		return http.StatusForbidden, apiErr
+	}
+	if e, ok := cause.(reauthRequired); ok {
+		userID, _ := wa.app.CurrentUserID(r.Context())
+		apiErr.Code = ErrorReauthRequired
+		apiErr.Details = newReauthPayload(userID, e)
+		return http.StatusForbidden, apiErr
+	}
+	if e, ok := cause.(notFound); (ok && e.IsNotFound()) || wa.app.IsNotFound(err) {
+		apiErr.Code = ErrorNotFound
+		apiErr.Message = "Not found"
+		apiErr.Details = nil
+		return http.StatusNotFound, apiErr
+	}
+	if e, ok := cause.(conflict); ok && e.IsConflict() {
+		apiErr.Code = ErrorConflict
+		return http.StatusConflict, apiErr
+	}
+	if e, ok := cause.(unsupported); ok && e.IsUnsupported() {
+		apiErr.Code = ErrorNotImplemented
+		apiErr.Message = "Not supported by the storage backend"
+		apiErr.Details = nil
+		return http.StatusNotImplemented, apiErr
+	}
+	if e, ok := cause.(unavailable); ok && e.IsUnavailable() {
+		//The message may describe the internal calls
+		apiErr.Code = ErrorUnavailable
+		apiErr.Message = "Service temporarily unavailable"
+		apiErr.Details = nil
+		return http.StatusServiceUnavailable, apiErr
+	}
+	if cause == context.DeadlineExceeded {
+		apiErr.Code = ErrorTimeout
+		apiErr.Message = "Request timeout"
+		apiErr.Details = nil
+		return http.StatusGatewayTimeout, apiErr
+	}
+	if _, ok := cause.(net.Error); ok {
+		//The services of the providers are the only remote ones
+		apiErr.Code = ErrorProviderUnavailable
+		apiErr.Message = "Provider unavailable"
+		apiErr.Details = nil
+		return http.StatusBadGateway, apiErr
+	}
+
+	//The internal errors are only detailed in the logs
+	apiErr.Code = ErrorInternal
+	apiErr.Message = "Internal server error"
+	apiErr.Details = nil
+	return http.StatusInternalServerError, apiErr
+}
+
+//writeError answers with the error envelope of the given error
+func (wa webApp) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, apiErr := wa.newAPIError(r, err)
+	apiErr.Message = i18n.TranslateError(wa.app.Locale(r.Context()), apiErr.Message)
+	apiErr.RequestID, _ = api.RequestIDFromContext(r.Context())
+	writeAPIError(w, status, apiErr)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, apiErr APIError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErr)
+}
+
+//jsonHandler answers with the JSON encoding of the data returned by the handler, or with the error envelope
+func (wa webApp) jsonHandler(h func(r *http.Request) (interface{}, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := h(r)
+		if err != nil {
+			wa.writeError(w, r, err)
+			return
+		}
+
+		b, err := json.Marshal(data)
+		if err != nil {
+			e := errors.Wrap(err, "Encoding response failed")
+			wa.app.Error(r.Context(), e)
+			wa.writeError(w, r, e)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(b)
+	})
+}
+
+//unauthenticatedContextKey gives the handlers let through by the authentication filter its writer
+type unauthenticatedContextKey struct{}
+
+//withUnauthenticatedEnvelope replaces the 401 answered by the authentication filter by the error envelope
+func withUnauthenticatedEnvelope(filter func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		filtered := filter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if uw, ok := r.Context().Value(unauthenticatedContextKey{}).(*unauthenticatedWriter); ok {
+				uw.authenticated = true
+			}
+			h.ServeHTTP(w, r)
+		}))
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			uw := &unauthenticatedWriter{ResponseWriter: w, r: r}
+			filtered.ServeHTTP(uw, r.WithContext(context.WithValue(r.Context(), unauthenticatedContextKey{}, uw)))
+		})
+	}
+}
+
+//unauthenticatedWriter answers with the error envelope when the request is rejected before reaching the handler
+type unauthenticatedWriter struct {
+	http.ResponseWriter
+	r             *http.Request
+	authenticated bool
+	rejected      bool
+}
+
+func (w *unauthenticatedWriter) WriteHeader(status int) {
+	if w.authenticated || status != http.StatusUnauthorized {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.rejected = true
+	ctx := w.r.Context()
+	apiErr := APIError{Code: ErrorUnauthenticated, Message: i18n.Translate(i18n.FromContext(ctx), "Authentication required")}
+	apiErr.RequestID, _ = api.RequestIDFromContext(ctx)
+	w.ResponseWriter.Header().Del("Content-Length")
+	writeAPIError(w.ResponseWriter, status, apiErr)
+}
+
+func (w *unauthenticatedWriter) Write(b []byte) (int, error) {
+	if w.rejected {
+		//The body of the filter is replaced by the envelope
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+//Flush lets the streamed responses be flushed through the writer
+func (w *unauthenticatedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//Hijack gives the connection to the handler, for WebSockets
+func (w *unauthenticatedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Hijacking not supported")
+	}
+	return h.Hijack()
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome"
+	"github.com/oki-apps/okihome/i18n"
+	"github.com/oki-apps/okihome/metrics"
+)
+
+//Default load shedding thresholds
+const (
+	DefaultSheddingLatency  = time.Second
+	DefaultSheddingMinCalls = 20
+)
+
+//DegradedHeader marks the responses served from the last responses while the service is degraded
+const DegradedHeader = "X-Okihome-Degraded"
+
+//maxCachedResponses is the number of tab contents kept to be served in degraded mode
+const maxCachedResponses = 1000
+
+//LoadShedding degrades the service while the repository is under pressure:
+//the tab contents are served from the last responses, the previews are disabled
+//and the non-essential endpoints answer with a 503.
+//The service is restored as soon as the repository is back below the thresholds.
+type LoadShedding struct {
+	//Latency is the mean duration of the recent repository calls above which the service is degraded (such as "500ms")
+	Latency string
+	//ErrorRate is the ratio of failed recent repository calls above which the service is degraded, ignored if zero
+	ErrorRate float64
+	//MinCalls is the number of recent repository calls below which the service is never degraded
+	MinCalls int
+}
+
+type loadShedder struct {
+	app       *okihome.App
+	window    *metrics.Window
+	latency   time.Duration
+	errorRate float64
+	minCalls  int
+
+	mu        sync.Mutex
+	degraded  bool
+	responses map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+}
+
+//newLoadShedder returns nil, disabling the load shedding, if either the configuration or the metrics are missing
+func newLoadShedder(app *okihome.App, cfg *LoadShedding, window *metrics.Window) (*loadShedder, error) {
+	if cfg == nil || window == nil {
+		return nil, nil
+	}
+
+	latency, err := parseDuration(cfg.Latency, DefaultSheddingLatency)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid load shedding latency")
+	}
+	minCalls := cfg.MinCalls
+	if minCalls <= 0 {
+		minCalls = DefaultSheddingMinCalls
+	}
+
+	return &loadShedder{
+		app:       app,
+		window:    window,
+		latency:   latency,
+		errorRate: cfg.ErrorRate,
+		minCalls:  minCalls,
+		responses: make(map[string]cachedResponse),
+	}, nil
+}
+
+//isDegraded checks the recent repository calls against the thresholds, logging the changes of mode
+func (l *loadShedder) isDegraded(r *http.Request) bool {
+	if l == nil {
+		return false
+	}
+
+	stats := l.window.Stats()
+	degraded := stats.Count >= l.minCalls &&
+		(stats.MeanLatency > l.latency || (l.errorRate > 0 && stats.ErrorRate() > l.errorRate))
+
+	l.mu.Lock()
+	changed := degraded != l.degraded
+	l.degraded = degraded
+	l.mu.Unlock()
+
+	if changed {
+		if degraded {
+			l.app.Infof(r.Context(), "Repository under pressure (%d calls, mean latency %s, error rate %.2f), entering degraded mode", stats.Count, stats.MeanLatency, stats.ErrorRate())
+		} else {
+			l.app.Infof(r.Context(), "Repository recovered, leaving degraded mode")
+		}
+	}
+
+	return degraded
+}
+
+//reject answers with a 503 while the service is degraded
+func (l *loadShedder) reject(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.isDegraded(r) {
+			w.Header().Set("Retry-After", "30")
+			writeAPIError(w, http.StatusServiceUnavailable, APIError{Code: ErrorUnavailable, Message: i18n.Translate(i18n.FromContext(r.Context()), "Service temporarily degraded")})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+//fallback keeps the last successful response of each user and serves it while the service is degraded.
+//The handler must be called with an authenticated request.
+func (l *loadShedder) fallback(h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := l.app.CurrentUserID(r.Context())
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		key := userID + " " + r.URL.String()
+
+		if l.isDegraded(r) {
+			l.mu.Lock()
+			cached, ok := l.responses[key]
+			l.mu.Unlock()
+			if ok {
+				for k, v := range cached.header {
+					w.Header()[k] = v
+				}
+				w.Header().Set(DegradedHeader, "1")
+				w.WriteHeader(http.StatusOK)
+				w.Write(cached.body)
+				return
+			}
+		}
+
+		b := &bufferedResponse{header: w.Header()}
+		h.ServeHTTP(b, r)
+		if b.status == 0 {
+			b.status = http.StatusOK
+		}
+
+		if b.status == http.StatusOK {
+			l.mu.Lock()
+			if _, ok := l.responses[key]; !ok && len(l.responses) >= maxCachedResponses {
+				//Make room by forgetting any other response
+				for k := range l.responses {
+					delete(l.responses, k)
+					break
+				}
+			}
+			header := make(http.Header)
+			for k, v := range w.Header() {
+				header[k] = v
+			}
+			l.responses[key] = cachedResponse{header: header, body: b.body.Bytes()}
+			l.mu.Unlock()
+		}
+
+		w.WriteHeader(b.status)
+		w.Write(b.body.Bytes())
+	})
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/oki-apps/okihome/i18n"
+)
+
+//withLocale puts the language preferred by the client, given by the Accept-Language header, in the context.
+//The preference of the logged in user takes precedence for the messages of the API.
+func withLocale(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locale := i18n.Match(r.Header.Get("Accept-Language"))
+		w.Header().Add("Vary", "Accept-Language")
+		h.ServeHTTP(w, r.WithContext(i18n.WithLocale(r.Context(), locale)))
+	})
+}
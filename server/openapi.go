@@ -0,0 +1,413 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/oki-apps/okihome"
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/metrics"
+)
+
+//A routeDoc describes an API route in the OpenAPI specification.
+//Request and Response are values of the types of the JSON bodies, nil if none.
+type routeDoc struct {
+	Summary  string
+	Query    []string
+	Request  interface{}
+	Response interface{}
+	//ContentType is the type of a response that is not JSON
+	ContentType string
+}
+
+//routeDocs describes the API routes, by method and path template.
+//The routes missing here are still listed in the specification, without their types.
+//The unversioned aliases of the routes are not listed.
+var routeDocs = map[string]routeDoc{
+	"GET /api/v1/version": {Summary: "Version of the server", Response: struct {
+		Version string `json:"version"`
+	}{}},
+	"GET /api/v1/openapi.json":                 {Summary: "This specification"},
+	"POST /api/v1/services/{serviceName}/push": {Summary: "Push notification of a service", Query: []string{"token"}, Response: true},
+
+	"GET /api/v1/demo":                      {Summary: "Read-only demo dashboard, shown without login", Response: okihome.UserData{}},
+	"GET /api/v1/demo/tabs/{tabID}":         {Summary: "Tab of the demo dashboard", Response: api.Tab{}},
+	"GET /api/v1/demo/feeds/{feedID}/items": {Summary: "Items of a feed of the demo dashboard, given with its widget", Query: []string{"tab", "widget", "cursor", "limit"}, Response: []api.ItemForUser{}},
+
+	"GET /api/v1/users/{userID}": {Summary: "User, its tabs and the tab to open first", Response: okihome.UserData{}},
+	"PATCH /api/v1/users/{userID}": {Summary: "Set the time zone of the item dates, by IANA name, and the language of the messages", Request: struct {
+		TimeZone string `json:"time_zone,omitempty"`
+		Locale   string `json:"locale,omitempty"`
+	}{}, Response: api.User{}},
+	"DELETE /api/v1/users/{userID}":     {Summary: "Delete the user and all its data", Response: true},
+	"GET /api/v1/users/{userID}/events": {Summary: "Updates of the dashboard, as Server-Sent Events", Response: api.Event{}, ContentType: "text/event-stream"},
+	"GET /api/v1/users/{userID}/ws":     {Summary: "Updates of the dashboard and commands, as a WebSocket", Request: api.Command{}, Response: api.Event{}},
+
+	"GET /api/v1/users/{userID}/backup":                 {Summary: "Snapshot of the configuration of the user, with its read and starred items, as a JSON file to download. The snapshot is encrypted with the passphrase of the X-Okihome-Passphrase header, if any", Response: api.Snapshot{}},
+	"POST /api/v1/users/{userID}/restore":               {Summary: "Restore a snapshot of at most 32 MiB, or the selected tabs of it, with its read and starred items if items is true. An encrypted snapshot is decrypted with the passphrase of the X-Okihome-Passphrase header", Query: []string{"tab", "title", "items"}, Request: api.Snapshot{}},
+	"GET /api/v1/tabs/{tabID}/export":                   {Summary: "Tab with the feeds of its widgets, as a JSON file to download", Response: api.TabExport{}},
+	"POST /api/v1/users/{userID}/tabs/import":           {Summary: "Create a tab from an exported one, with the given title or the exported one", Query: []string{"title"}, Request: api.TabExport{}, Response: api.Tab{}},
+	"GET /api/v1/tabs/{tabID}/template":                 {Summary: "Tab without personal data, to be published as a template, as a JSON file to download", Response: api.TabTemplate{}},
+	"POST /api/v1/users/{userID}/templates":             {Summary: "Create a tab from a template, or list the account slots of its email widgets left to fill", Request: api.TemplateImport{}, Response: api.TemplateImportResult{}},
+	"POST /api/v1/users/{userID}/restore/preview":       {Summary: "Changes and conflicts of the restore of a snapshot, nothing being restored", Query: []string{"tab", "title", "items"}, Request: api.Snapshot{}, Response: api.RestorePreview{}},
+	"POST /api/v1/users/{userID}/tabs/import/startpage": {Summary: "Create the tabs of a Netvibes (OPML) or iGoogle (GadgetTabML) export, the format being netvibes, igoogle or detected if empty", Query: []string{"format"}, Response: api.StartPageImport{}},
+	"POST /api/v1/users/{userID}/backup":                {Summary: "Former route of the restore of a snapshot", Query: []string{"tab", "title", "items"}, Request: api.Snapshot{}},
+
+	"GET /api/v1/users/{userID}/policy":        {Summary: "Managed policy of the user", Response: api.ManagedPolicy{}},
+	"POST /api/v1/users/{userID}/policy":       {Summary: "Set the managed policy of the user", Request: api.ManagedPolicy{}, Response: api.ManagedPolicy{}},
+	"DELETE /api/v1/users/{userID}/policy":     {Summary: "Remove the managed policy of the user", Response: true},
+	"GET /api/v1/users/{userID}/linkpolicies":  {Summary: "Link policies of the user", Response: []api.LinkPolicy{}},
+	"POST /api/v1/users/{userID}/linkpolicies": {Summary: "Set the link policies of the user", Request: []api.LinkPolicy{}, Response: []api.LinkPolicy{}},
+	"GET /api/v1/users/{userID}/retention":     {Summary: "Retention policy of the user", Response: api.RetentionReport{}},
+	"POST /api/v1/users/{userID}/retention":    {Summary: "Set the retention policy of the user", Request: api.RetentionPolicy{}, Response: api.RetentionReport{}},
+	"GET /api/v1/users/{userID}/activity":      {Summary: "Latest change reports of the configuration", Response: []api.Activity{}},
+	"GET /api/v1/users/{userID}/stats":         {Summary: "Reading activity of the user by day, over the last 30 days by default", Query: []string{"days"}, Response: api.UsageStats{}},
+
+	"GET /api/v1/users/{userID}/tokens": {Summary: "Personal API tokens", Response: []api.APIToken{}},
+	"POST /api/v1/users/{userID}/tokens": {Summary: "Create a personal API token", Request: struct {
+		Name string `json:"name"`
+	}{}, Response: api.NewAPIToken{}},
+	"DELETE /api/v1/users/{userID}/tokens/{tokenID}": {Summary: "Revoke a personal API token"},
+
+	"GET /api/v1/users/{userID}/approvals": {Summary: "Approval requests of the user", Response: []api.ApprovalRequest{}},
+	"POST /api/v1/users/{userID}/approvals": {Summary: "Request the approval of a feed or a service", Request: struct {
+		Kind  api.ApprovalKind `json:"kind"`
+		Value string           `json:"value"`
+	}{}, Response: api.ApprovalRequest{}},
+	"POST /api/v1/users/{userID}/approvals/{requestID}": {Summary: "Review an approval request", Request: struct {
+		Approved bool `json:"approved"`
+	}{}, Response: api.ApprovalRequest{}},
+
+	"GET /api/v1/services": {Summary: "Available services", Response: []api.ProviderDescription{}},
+
+	"POST /api/v1/tabs":                      {Summary: "Create a tab", Request: api.TabSummary{}, Response: api.Tab{}},
+	"POST /api/v1/users/{userID}/tabs/bulk":  {Summary: "Create, delete and order tabs at once", Request: api.TabBulkRequest{}, Response: api.TabBulkResult{}},
+	"POST /api/v1/users/{userID}/tabs/order": {Summary: "Order the tabs of the user as in the list of tab IDs, the tabs not listed being put after", Request: []int64{}, Response: []api.TabSummary{}},
+	"GET /api/v1/tabs/{tabID}":               {Summary: "Tab and its widgets", Response: api.Tab{}},
+	"GET /api/v1/tabs/{tabID}/content":       {Summary: "Tab with the first page of the content of each widget, the failed widgets holding their error", Response: okihome.TabContent{}},
+	"GET /api/v1/tabs/slugs/{slug}":          {Summary: "Tab with the given slug, former slugs being redirected", Response: api.Tab{}},
+	"POST /api/v1/tabs/{tabID}":              {Summary: "Edit a tab", Request: api.TabSummary{}, Response: api.Tab{}},
+	"PATCH /api/v1/tabs/{tabID}": {Summary: "Make the tab the one opened first by the user, or unset it, the default tab being unchanged if omitted", Request: struct {
+		Default *bool `json:"default,omitempty"`
+	}{}, Response: api.Tab{}},
+	"DELETE /api/v1/tabs/{tabID}":         {Summary: "Delete a tab", Response: true},
+	"POST /api/v1/tabs/{tabID}/duplicate": {Summary: "Copy a tab and its widgets into a new tab of the user, titled as given or after the tab", Request: api.TabSummary{}, Response: api.Tab{}},
+
+	"POST /api/v1/tabs/{tabID}/widgets": {Summary: "Add a widget, at the end of the first column unless a column and a position are given", Request: struct {
+		api.Widget
+		api.WidgetPosition
+	}{}, Response: api.Widget{}},
+	"POST /api/v1/tabs/{tabID}/widgets/bulk":         {Summary: "Add a feed widget for each URL, spread over the columns", Request: api.WidgetBulkRequest{}, Response: api.WidgetBulkResult{}},
+	"POST /api/v1/tabs/{tabID}/widgets/{widgetID}":   {Summary: "Edit the common config of a widget, and the mode of a feed widget", Request: api.EditedWidgetConfig{}, Response: api.Widget{}},
+	"DELETE /api/v1/tabs/{tabID}/widgets/{widgetID}": {Summary: "Delete a widget", Response: true},
+	"POST /api/v1/tabs/{tabID}/layout":               {Summary: "Move the widgets, as columns of widget IDs", Request: [][]int64{}, Response: [][]int64{}},
+	"GET /api/v1/tabs/{tabID}/suggestions":           {Summary: "Widgets suggested for the tab", Response: []api.WidgetSuggestion{}},
+	"POST /api/v1/tabs/{tabID}/views": {Summary: "Beacon recording the widgets displayed", Request: struct {
+		Widgets []int64 `json:"widgets"`
+	}{}, Response: true},
+
+	"GET /api/v1/users/{userID}/feeds/{feedID}/items":  {Summary: "Items of a feed with their reading status, older ones with the publication time and the GUID of the oldest item received, separated by a space, as cursor", Query: []string{"tab", "widget", "cursor", "limit"}, Response: []api.ItemForUser{}},
+	"GET /api/v1/users/{userID}/feeds/{feedID}/digest": {Summary: "Digest of the recent items of a feed", Query: []string{"tab", "widget"}, Response: api.FeedDigest{}},
+	"POST /api/v1/users/{userID}/feeds/{feedID}": {Summary: "Mark feed items as read", Request: struct {
+		GUIDs []string `json:"guids"`
+	}{}},
+
+	"POST /api/v1/users/{userID}/feeds/{feedID}/starred": {Summary: "Star or unstar a feed item, calling the webhook of the user when starred", Request: struct {
+		GUID    string   `json:"guid"`
+		Starred bool     `json:"starred"`
+		Tags    []string `json:"tags"`
+	}{}, Response: api.StarredItem{}},
+	"GET /api/v1/users/{userID}/starred":                {Summary: "Starred items, most recent first", Response: []api.StarredItem{}},
+	"GET /api/v1/users/{userID}/webhook":                {Summary: "Webhook called when the user stars an item", Response: api.UserWebhook{}},
+	"POST /api/v1/users/{userID}/webhook":               {Summary: "Set the webhook called when the user stars an item", Request: api.UserWebhook{}, Response: api.UserWebhook{}},
+	"DELETE /api/v1/users/{userID}/webhook":             {Summary: "Remove the webhook of the user", Response: true},
+	"GET /api/v1/users/{userID}/notifications":          {Summary: "Notification settings of the user", Response: api.NotificationSettings{}},
+	"POST /api/v1/users/{userID}/notifications":         {Summary: "Set the widgets and feeds notifying the user, the channels and the quiet hours", Request: api.NotificationSettings{}, Response: api.NotificationSettings{}},
+	"DELETE /api/v1/users/{userID}/notifications":       {Summary: "Remove the notification settings of the user, no longer notified", Response: true},
+	"GET /api/v1/users/{userID}/tags":                   {Summary: "Tags of the user, ordered by name", Response: []api.Tag{}},
+	"POST /api/v1/users/{userID}/tags":                  {Summary: "Create a tag or update its color", Request: api.Tag{}, Response: api.Tag{}},
+	"POST /api/v1/users/{userID}/tags/{name}":           {Summary: "Rename a tag or update its color, updating the tagged items and widgets", Request: api.Tag{}, Response: api.Tag{}},
+	"DELETE /api/v1/users/{userID}/tags/{name}":         {Summary: "Remove a tag from the items and widgets, and delete it", Response: true},
+	"GET /api/v1/users/{userID}/tags/{name}/collection": {Summary: "Starred items and widgets with the tag", Response: api.TagCollection{}},
+	"GET /api/v1/users/{userID}/widgets/stale":          {Summary: "Widgets not viewed for a number of days, 90 by default, suggested for removal", Query: []string{"days"}, Response: []api.StaleWidget{}},
+
+	"GET /api/v1/users/{userID}/accounts":                {Summary: "Associated accounts", Response: []api.ExternalAccount{}},
+	"POST /api/v1/users/{userID}/accounts/merge":         {Summary: "Merge the accounts linked several times to the same address", Response: []okihome.AccountMerge{}},
+	"DELETE /api/v1/users/{userID}/accounts/{accountID}": {Summary: "Revoke an account", Query: []string{"force"}, Response: true},
+	"PATCH /api/v1/users/{userID}/accounts/{accountID}": {Summary: "Relabel an account", Request: struct {
+		Label string `json:"label"`
+	}{}, Response: api.ExternalAccount{}},
+	"GET /api/v1/users/{userID}/accounts/{accountID}/usage": {Summary: "Widgets using an account", Response: []okihome.WidgetUsage{}},
+	"POST /api/v1/users/{userID}/accounts/{accountID}/watch": {Summary: "Subscribe to the push notifications of an account", Response: struct {
+		Expiration time.Time `json:"expiration"`
+	}{}},
+
+	"GET /api/v1/users/{userID}/accounts/{accountID}/emails": {Summary: "Emails of an account", Query: []string{"category", "q", "tab", "widget"}, Response: api.EmailPage{}},
+	"POST /api/v1/users/{userID}/accounts/{accountID}/emails/{guid}/actions": {Summary: "Apply an action on an email", Request: struct {
+		Action api.EmailAction `json:"action"`
+	}{}},
+
+	"POST /api/v1/preview": {Summary: "Preview of a feed", Query: []string{"url"}, Request: struct {
+		URL string `json:"url"`
+	}{}, Response: okihome.PreviewResult{}},
+
+	"POST /api/v1/admin/tokens/rotate":       {Summary: "Re-encrypt the account tokens with the newest key", Response: okihome.TokenRotationReport{}},
+	"POST /api/v1/admin/widgets/upgrade":     {Summary: "Upgrade the widget configs to their current version", Response: okihome.WidgetConfigUpgradeReport{}},
+	"GET /api/v1/admin/users":                {Summary: "Users with their statistics", Query: []string{"cursor", "limit"}, Response: api.UserStatsPage{}},
+	"GET /api/v1/admin/users/{userID}/stats": {Summary: "Statistics of a user", Response: api.UserStats{}},
+	"GET /api/v1/admin/locks":                {Summary: "Calls holding or waiting for the repository lock and wait times", Response: api.LockStats{}},
+	"GET /api/v1/admin/usage":                {Summary: "Reading activity of all the users by day, over the last 30 days by default", Query: []string{"days"}, Response: api.UsageStats{}},
+	"GET /api/v1/admin/feeds/stats":          {Summary: "Statistics of the feeds", Response: api.FeedStats{}},
+	"GET /api/v1/admin/audit":                {Summary: "Audit log of the security relevant actions, most recent first", Query: []string{"user", "actor", "action", "since", "until", "cursor", "limit"}, Response: api.AuditEventPage{}},
+	"GET /api/v1/admin/metrics/apis":         {Summary: "Calls, latencies, error rates and throttling of the external APIs, by provider and feed host", Response: map[string]metrics.Series{}},
+	"GET /api/v1/admin/metrics/requests":     {Summary: "Count and latency of the slow API requests, by route", Response: map[string]metrics.Series{}},
+	"GET /api/v1/admin/metrics/queries":      {Summary: "Count and latency of the slow repository calls, by method", Response: map[string]metrics.Series{}},
+}
+
+//interfaceSchemas lists the types a field declared as interface{} may hold, by struct and field name
+var interfaceSchemas = map[string][]interface{}{
+	"Widget.Config": {api.ConfigFeed{}, api.ConfigEmail{}, api.ConfigCollection{}},
+}
+
+var pathParam = regexp.MustCompile(`\{([^}:]+)(:[^}]+)?\}`)
+
+//openAPISpec builds the OpenAPI specification of the routes of a router once they are all registered
+type openAPISpec struct {
+	router *mux.Router
+	once   sync.Once
+	spec   map[string]interface{}
+	err    error
+}
+
+func (o *openAPISpec) get(req *http.Request) (interface{}, error) {
+	o.once.Do(func() {
+		o.spec, o.err = buildOpenAPISpec(o.router)
+	})
+	return o.spec, o.err
+}
+
+func buildOpenAPISpec(router *mux.Router) (map[string]interface{}, error) {
+
+	schemas := schemaGenerator{components: make(map[string]interface{})}
+	paths := make(map[string]map[string]interface{})
+
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tpl, APIPrefix+"/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		path := pathParam.ReplaceAllString(tpl, "{$1}")
+		if paths[path] == nil {
+			paths[path] = make(map[string]interface{})
+		}
+
+		for _, method := range methods {
+			doc := routeDocs[method+" "+path]
+
+			var parameters []interface{}
+			for _, m := range pathParam.FindAllStringSubmatch(tpl, -1) {
+				parameters = append(parameters, map[string]interface{}{
+					"name":     m[1],
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+			}
+			for _, q := range doc.Query {
+				parameters = append(parameters, map[string]interface{}{
+					"name":   q,
+					"in":     "query",
+					"schema": map[string]interface{}{"type": "string"},
+				})
+			}
+
+			operation := map[string]interface{}{
+				"summary": doc.Summary,
+			}
+			if len(parameters) > 0 {
+				operation["parameters"] = parameters
+			}
+			if doc.Request != nil {
+				operation["requestBody"] = map[string]interface{}{
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": schemas.schema(reflect.TypeOf(doc.Request)),
+						},
+					},
+				}
+			}
+
+			response := map[string]interface{}{
+				"description": "Success",
+			}
+			if doc.Response != nil {
+				contentType := doc.ContentType
+				if len(contentType) == 0 {
+					contentType = "application/json"
+				}
+				response["content"] = map[string]interface{}{
+					contentType: map[string]interface{}{
+						"schema": schemas.schema(reflect.TypeOf(doc.Response)),
+					},
+				}
+			}
+			operation["responses"] = map[string]interface{}{
+				"200": response,
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": schemas.schema(reflect.TypeOf(APIError{})),
+						},
+					},
+				},
+			}
+
+			paths[path][strings.ToLower(method)] = operation
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Okihome API",
+			"version": version,
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas.components,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "Personal API token",
+				},
+			},
+		},
+	}, nil
+}
+
+//schemaGenerator describes Go types as JSON schemas, the named structs being shared as components
+type schemaGenerator struct {
+	components map[string]interface{}
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	numberType     = reflect.TypeOf(json.Number(""))
+)
+
+func (g schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	case numberType:
+		return map[string]interface{}{"type": "number"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schema(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return g.object(t)
+		}
+		ref := map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := g.components[t.Name()]; !ok {
+			//Registered before being described, for the recursive types
+			g.components[t.Name()] = ref
+			g.components[t.Name()] = g.object(t)
+		}
+		return ref
+	}
+
+	//Interfaces may hold anything
+	return map[string]interface{}{}
+}
+
+func (g schemaGenerator) object(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	g.addProperties(t, properties)
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func (g schemaGenerator) addProperties(t reflect.Type, properties map[string]interface{}) {
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		//Embedded structs are flattened
+		if f.Anonymous && len(name) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addProperties(ft, properties)
+				continue
+			}
+		}
+		if len(f.PkgPath) > 0 {
+			//Unexported
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+
+		if types, ok := interfaceSchemas[t.Name()+"."+f.Name]; ok {
+			var oneOf []interface{}
+			for _, v := range types {
+				oneOf = append(oneOf, g.schema(reflect.TypeOf(v)))
+			}
+			properties[name] = map[string]interface{}{"oneOf": oneOf}
+			continue
+		}
+		if strings.Contains(tag, ",string") {
+			properties[name] = map[string]interface{}{"type": "string"}
+			continue
+		}
+		properties[name] = g.schema(f.Type)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//RequestIDHeader is the HTTP header identifying a request, given by the client or the proxy, or generated
+const RequestIDHeader = "X-Request-ID"
+
+//maxRequestIDLength is the length above which the given identifiers are replaced
+const maxRequestIDLength = 128
+
+//withRequestID identifies each request, the identifier being put in the context for the logs
+//and sent back in the response
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set(RequestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(api.ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+//requestID returns the identifier given to the request by the client, the load balancer or the proxy,
+//or a new one if none is valid
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(RequestIDHeader); validRequestID(id) {
+		return id
+	}
+	if id := strings.SplitN(r.Header.Get("X-Cloud-Trace-Context"), "/", 2)[0]; validRequestID(id) {
+		return id
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "none"
+	}
+	return hex.EncodeToString(b)
+}
+
+//validRequestID checks that an identifier can safely be logged and sent back
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
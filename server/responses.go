@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//Default response settings
+const (
+	DefaultCompressionMinSize = 1024
+	DefaultStaticMaxAge       = time.Hour
+)
+
+//Responses configures the compression and the caching of the responses, API and static content alike.
+//The defaults are used for the zero values.
+type Responses struct {
+	//DisableCompression sends the responses as is, even to the clients accepting gzip or deflate
+	DisableCompression bool
+	//CompressionMinSize is the size in bytes below which the responses are not compressed
+	CompressionMinSize int
+	//StaticMaxAge is how long the browsers may keep the static content without checking it (such as "24h")
+	StaticMaxAge string
+}
+
+//newResponseFilter returns a middleware setting the default Cache-Control header of the responses
+//and compressing them for the clients accepting it
+func newResponseFilter(cfg Responses) (func(http.Handler) http.Handler, error) {
+
+	staticMaxAge, err := parseDuration(cfg.StaticMaxAge, DefaultStaticMaxAge)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid static max age")
+	}
+	staticCacheControl := "public, max-age=" + strconv.Itoa(int(staticMaxAge.Seconds()))
+
+	minSize := cfg.CompressionMinSize
+	if minSize <= 0 {
+		minSize = DefaultCompressionMinSize
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+			//The handlers may override the default
+			switch {
+			case strings.HasPrefix(r.URL.Path, "/api/"):
+				//The clients check the responses again using their ETag
+				w.Header().Set("Cache-Control", "private, no-cache")
+			case strings.HasPrefix(r.URL.Path, "/pages/"):
+				w.Header().Set("Cache-Control", "no-store")
+			default:
+				w.Header().Set("Cache-Control", staticCacheControl)
+			}
+
+			if len(r.Header.Get("Upgrade")) > 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			var encoding string
+			if !cfg.DisableCompression {
+				w.Header().Add("Vary", "Accept-Encoding")
+				encoding = acceptedEncoding(r)
+			}
+			c := &compressedResponse{
+				ResponseWriter: w,
+				encoding:       encoding,
+				minSize:        minSize,
+			}
+			defer c.Close()
+			h.ServeHTTP(c, r)
+		})
+	}, nil
+}
+
+//acceptedEncoding returns the compression to use for the response, or an empty string if none
+func acceptedEncoding(r *http.Request) string {
+	var deflate bool
+	for _, e := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		e = strings.TrimSpace(e)
+		if i := strings.Index(e, ";"); i >= 0 {
+			if strings.TrimSpace(e[i+1:]) == "q=0" {
+				continue
+			}
+			e = strings.TrimSpace(e[:i])
+		}
+		switch e {
+		case "gzip":
+			return "gzip"
+		case "deflate":
+			deflate = true
+		}
+	}
+	if deflate {
+		return "deflate"
+	}
+	return ""
+}
+
+//compressedResponse holds the start of the response until it is large enough to be worth compressing
+type compressedResponse struct {
+	http.ResponseWriter
+	//encoding is empty if the response must not be compressed
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	started bool
+	writer  io.WriteCloser
+}
+
+func (c *compressedResponse) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressedResponse) Write(p []byte) (int, error) {
+	if c.started {
+		if c.writer != nil {
+			return c.writer.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.minSize {
+		if err := c.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+//start sends the header and the held content, compressed if asked and relevant for the response
+func (c *compressedResponse) start(compress bool) error {
+	c.started = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	h := c.Header()
+	if c.status >= 400 {
+		//The errors are never kept
+		h.Set("Cache-Control", "no-store")
+	}
+
+	switch {
+	case len(c.encoding) == 0:
+		compress = false
+	case c.status < 200, c.status == http.StatusNoContent, c.status == http.StatusNotModified:
+		compress = false
+	case len(h.Get("Content-Encoding")) > 0:
+		compress = false
+	case strings.HasPrefix(h.Get("Content-Type"), "text/event-stream"):
+		compress = false
+	case strings.HasPrefix(h.Get("Content-Type"), "image/") && !strings.HasPrefix(h.Get("Content-Type"), "image/svg"):
+		//Already compressed
+		compress = false
+	}
+
+	if compress {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		if c.encoding == "gzip" {
+			c.writer = gzip.NewWriter(c.ResponseWriter)
+		} else {
+			c.writer = zlib.NewWriter(c.ResponseWriter)
+		}
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := c.Write(buf)
+	return err
+}
+
+//Close sends what is left of the response
+func (c *compressedResponse) Close() error {
+	if !c.started {
+		if err := c.start(false); err != nil {
+			return err
+		}
+	}
+	if c.writer != nil {
+		return c.writer.Close()
+	}
+	return nil
+}
+
+//Flush sends the response as it is, the streamed responses being too small to be compressed
+func (c *compressedResponse) Flush() {
+	if !c.started {
+		if err := c.start(false); err != nil {
+			return
+		}
+	}
+	if f, ok := c.writer.(interface {
+		Flush() error
+	}); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//Hijack gives the connection to the handler, for WebSockets
+func (c *compressedResponse) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := c.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("Hijacking not supported")
+	}
+	c.started = true
+	return h.Hijack()
+}
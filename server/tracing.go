@@ -0,0 +1,28 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//withTracing records a span for each request, named after its route
+func withTracing(h http.Handler) http.Handler {
+	return tracing.Handler(h, spanName)
+}
+
+//spanName returns the method and the path template of the route, the paths holding identifiers
+func spanName(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if path, err := route.GetPathTemplate(); err == nil {
+			return r.Method + " " + path
+		}
+	}
+	return r.Method
+}
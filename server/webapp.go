@@ -1,80 +1,285 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/oki-apps/okihome"
 	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/i18n"
+	"github.com/oki-apps/okihome/metrics"
 	"github.com/oki-apps/server"
 	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
 )
 
+//Default request durations
+const (
+	DefaultRequestTimeout = 30 * time.Second
+	DefaultPreviewTimeout = 60 * time.Second
+	DefaultSlowRequest    = 5 * time.Second
+)
+
+//Timeouts limits the duration of the API requests (such as "10s").
+//The defaults are used for the empty values.
+type Timeouts struct {
+	//Request applies to the API calls, except the previews
+	Request string
+	//Preview applies to the previews of external pages
+	Preview string
+	//SlowRequest is the duration above which a request is logged and recorded
+	SlowRequest string
+}
+
+func parseDuration(s string, defaultValue time.Duration) (time.Duration, error) {
+	if len(s) == 0 {
+		return defaultValue, nil
+	}
+	return time.ParseDuration(s)
+}
+
+//Options configures the API on top of the base server
+type Options struct {
+	Timeouts Timeouts
+	//LoadShedding is enabled when both its configuration and the metrics of the repository are given
+	LoadShedding      *LoadShedding
+	RepositoryMetrics *metrics.Window
+	Responses         Responses
+	CORS              CORS
+	//Drain rejects the requests during the shutdown of the server, the requests are always accepted if nil
+	Drain *Drain
+	//Tracing records a span for each request, continuing the trace of the client
+	Tracing bool
+	//AccessLog logs each request, the secrets of the query strings being redacted
+	AccessLog bool
+}
+
 //New creates a new Server with all the required endpoints registered
-func New(app *okihome.App, cfg server.Config) (*server.Server, error) {
+func New(app *okihome.App, cfg server.Config, opts Options) (*server.Server, error) {
 
 	webApp := webApp{app: app}
 
+	timeouts := opts.Timeouts
+	requestTimeout, err := parseDuration(timeouts.Request, DefaultRequestTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid request timeout")
+	}
+	previewTimeout, err := parseDuration(timeouts.Preview, DefaultPreviewTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid preview timeout")
+	}
+	webApp.slowRequest, err = parseDuration(timeouts.SlowRequest, DefaultSlowRequest)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid slow request duration")
+	}
+
+	shedder, err := newLoadShedder(app, opts.LoadShedding, opts.RepositoryMetrics)
+	if err != nil {
+		return nil, err
+	}
+
+	responses, err := newResponseFilter(opts.Responses)
+	if err != nil {
+		return nil, err
+	}
+
+	cors, err := newCORSFilter(opts.CORS)
+	if err != nil {
+		return nil, err
+	}
+
 	//Server
 	s, err := server.New(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	private, err := server.AuthenticatedFilter(cfg.OpenIDConnectIssuer)
+	if opts.Tracing {
+		s.Router().Use(withTracing)
+	}
+	s.Router().Use(withRequestID)
+	if opts.AccessLog {
+		s.Router().Use(webApp.withAccessLog)
+	}
+	s.Router().Use(withLocale, opts.Drain.filter, responses)
+	if len(opts.CORS.AllowedOrigins) > 0 {
+		s.Router().Use(cors.filter)
+	}
+
+	authenticated, err := server.AuthenticatedFilter(cfg.OpenIDConnectIssuer)
 	if err != nil {
 		return nil, err
 	}
+	private := func(h http.Handler) http.Handler {
+		return authenticated(webApp.withAccessUser(h))
+	}
+	//privateAPI rejects the unauthenticated API requests with the error envelope
+	authenticatedAPI := withUnauthenticatedEnvelope(authenticated)
+	privateAPI := func(h http.Handler) http.Handler {
+		return authenticatedAPI(webApp.withAccessUser(h))
+	}
 	privateJSON := func(f func(r *http.Request) (interface{}, error)) http.Handler {
-		return private(server.JSONHandler(f))
+		return webApp.apiTokenFilter(privateAPI)(webApp.jsonHandler(f))
+	}
+	//handleAPI registers a route of the API along with its unversioned alias
+	handleAPI := func(method, path string, h http.Handler) {
+		s.Router().Handle(path, h).Methods(method)
+		s.Router().Handle(legacyAPIPath(path), h).Methods(method)
 	}
 	registerPublicAPI := func(method, path string, h func(r *http.Request) (interface{}, error)) {
-		s.Router().Handle(path, server.JSONHandler(h)).Methods(method)
+		handleAPI(method, path, webApp.withTimeout(path, requestTimeout, webApp.jsonHandler(h)))
 	}
 	registerPrivateAPI := func(method, path string, h func(r *http.Request) (interface{}, error)) {
-		s.Router().Handle(path, privateJSON(h)).Methods(method)
+		handleAPI(method, path, webApp.withTimeout(path, requestTimeout, privateJSON(h)))
+	}
+	//registerCachedPrivateAPI answers with a 304 when the response did not change since the client's version,
+	//and with the last response when the service is degraded
+	registerCachedPrivateAPI := func(method, path string, h func(r *http.Request) (interface{}, error)) {
+		cachedJSON := webApp.apiTokenFilter(privateAPI)(shedder.fallback(webApp.jsonHandler(h)))
+		handleAPI(method, path, withETag(webApp.withTimeout(path, requestTimeout, cachedJSON)))
+	}
+	//registerNonEssentialAPI is disabled when the service is degraded
+	registerNonEssentialAPI := func(method, path string, h func(r *http.Request) (interface{}, error)) {
+		handleAPI(method, path, shedder.reject(webApp.withTimeout(path, requestTimeout, privateJSON(h))))
 	}
 	registerPrivatePage := func(method, path string, h func(w http.ResponseWriter, r *http.Request)) {
 		s.Router().Handle(path, private(http.HandlerFunc(h))).Methods(method)
 	}
 
-	registerPublicAPI("GET", "/api/version", webApp.GetVersion)
-
-	registerPrivateAPI("GET", "/api/users/{userID}", webApp.GetUser)
-
-	registerPrivateAPI("GET", "/api/users/{userID}/backup", webApp.BackupUser)
-	registerPrivateAPI("POST", "/api/users/{userID}/backup", webApp.RestoreUser)
+	registerPublicAPI("GET", "/api/v1/version", webApp.GetVersion)
+	registerPublicAPI("POST", "/api/v1/services/{serviceName}/push", webApp.HandlePush)
+
+	//The demo dashboard is read-only, and shown without login
+	registerPublicAPI("GET", "/api/v1/demo", webApp.GetDemo)
+	registerPublicAPI("GET", "/api/v1/demo/tabs/{tabID}", webApp.GetDemoTab)
+	registerPublicAPI("GET", "/api/v1/demo/feeds/{feedID}/items", webApp.GetDemoFeedItems)
+
+	registerPrivateAPI("GET", "/api/v1/users/{userID}", webApp.GetUser)
+	registerPrivateAPI("PATCH", "/api/v1/users/{userID}", webApp.UpdateUser)
+	registerPrivateAPI("DELETE", "/api/v1/users/{userID}", webApp.DeleteUser)
+	handleAPI("GET", "/api/v1/users/{userID}/events", opts.Drain.stream(webApp.apiTokenFilter(privateAPI)(http.HandlerFunc(webApp.Events))))
+	handleAPI("GET", "/api/v1/users/{userID}/ws", opts.Drain.stream(webApp.apiTokenFilter(privateAPI)(http.HandlerFunc(webApp.WebSocket))))
+
+	handleAPI("GET", "/api/v1/users/{userID}/backup", shedder.reject(webApp.withTimeout("/api/v1/users/{userID}/backup", requestTimeout, webApp.apiTokenFilter(privateAPI)(http.HandlerFunc(webApp.BackupUser)))))
+	registerNonEssentialAPI("POST", "/api/v1/users/{userID}/restore", webApp.RestoreUser)
+	registerNonEssentialAPI("POST", "/api/v1/users/{userID}/restore/preview", webApp.PreviewRestore)
+	//Former restore route
+	registerNonEssentialAPI("POST", "/api/v1/users/{userID}/backup", webApp.RestoreUser)
+
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/policy", webApp.GetManagedPolicy)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/policy", webApp.SetManagedPolicy)
+	registerPrivateAPI("DELETE", "/api/v1/users/{userID}/policy", webApp.RemoveManagedPolicy)
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/linkpolicies", webApp.GetLinkPolicies)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/linkpolicies", webApp.SetLinkPolicies)
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/retention", webApp.GetRetentionPolicy)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/retention", webApp.SetRetentionPolicy)
+	registerNonEssentialAPI("GET", "/api/v1/users/{userID}/activity", webApp.GetActivity)
+	registerNonEssentialAPI("GET", "/api/v1/users/{userID}/stats", webApp.GetUsageStats)
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/tokens", webApp.GetAPITokens)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/tokens", webApp.CreateAPIToken)
+	registerPrivateAPI("DELETE", "/api/v1/users/{userID}/tokens/{tokenID}", webApp.RevokeAPIToken)
+	registerNonEssentialAPI("GET", "/api/v1/users/{userID}/approvals", webApp.GetApprovalRequests)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/approvals", webApp.RequestApproval)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/approvals/{requestID}", webApp.ReviewApprovalRequest)
 
 	registerPrivatePage("GET", "/pages/services/{serviceName}/callback", webApp.ServiceCallback)
 	registerPrivatePage("GET", "/pages/services/{serviceName}/register", webApp.ServiceRegister)
 	registerPrivatePage("GET", "/pages/users/{userID}/accounts/{accountID}", webApp.AccountStatus)
-
-	registerPrivateAPI("GET", "/api/services", webApp.GetServices)
-
-	registerPrivateAPI("POST", "/api/tabs", webApp.NewTab)
-	registerPrivateAPI("GET", "/api/tabs/{tabID}", webApp.GetTab)
-	registerPrivateAPI("POST", "/api/tabs/{tabID}", webApp.EditTab)
-	registerPrivateAPI("DELETE", "/api/tabs/{tabID}", webApp.DeleteTab)
-
-	registerPrivateAPI("POST", "/api/tabs/{tabID}/widgets", webApp.NewWidget)
-	registerPrivateAPI("POST", "/api/tabs/{tabID}/widgets/{widgetID}", webApp.EditWidget)
-	registerPrivateAPI("DELETE", "/api/tabs/{tabID}/widgets/{widgetID}", webApp.DeleteWidget)
-	registerPrivateAPI("POST", "/api/tabs/{tabID}/layout", webApp.UpdateLayout)
-
-	registerPrivateAPI("GET", "/api/users/{userID}/feeds/{feedID}/items", webApp.GetFeedItems)
-	registerPrivateAPI("POST", "/api/users/{userID}/feeds/{feedID}", webApp.MarkAsRead)
-
-	registerPrivateAPI("GET", "/api/users/{userID}/accounts", webApp.GetAssociatedAccounts)
-	registerPrivateAPI("DELETE", "/api/users/{userID}/accounts/{accountID}", webApp.RevokeAccount)
-
-	registerPrivateAPI("GET", "/api/users/{userID}/accounts/{accountID}/emails", webApp.GetEmails)
-
-	registerPrivateAPI("POST", "/api/preview", webApp.Preview)
-
-	s.AllowCORS()
+	registerPrivatePage("GET", "/pages/users/{userID}/accounts/{accountID}/authorize", webApp.AuthorizeFeature)
+	registerPrivatePage("GET", "/pages/users/{userID}/accounts/{accountID}/reauthorize", webApp.ReauthorizeAccount)
+
+	registerPrivateAPI("GET", "/api/v1/services", webApp.GetServices)
+
+	registerPrivateAPI("POST", "/api/v1/tabs", webApp.NewTab)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/tabs/bulk", webApp.BulkTabs)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/tabs/order", webApp.ReorderTabs)
+	registerCachedPrivateAPI("GET", "/api/v1/tabs/{tabID}", webApp.GetTab)
+	registerCachedPrivateAPI("GET", "/api/v1/tabs/{tabID}/content", webApp.GetTabContent)
+	handleAPI("GET", "/api/v1/tabs/slugs/{slug}", webApp.apiTokenFilter(privateAPI)(http.HandlerFunc(webApp.GetTabBySlug)))
+	registerPrivateAPI("POST", "/api/v1/tabs/{tabID}", webApp.EditTab)
+	registerPrivateAPI("PATCH", "/api/v1/tabs/{tabID}", webApp.SetDefaultTab)
+	registerPrivateAPI("DELETE", "/api/v1/tabs/{tabID}", webApp.DeleteTab)
+
+	registerPrivateAPI("POST", "/api/v1/tabs/{tabID}/duplicate", webApp.DuplicateTab)
+	handleAPI("GET", "/api/v1/tabs/{tabID}/export", shedder.reject(webApp.withTimeout("/api/v1/tabs/{tabID}/export", requestTimeout, webApp.apiTokenFilter(privateAPI)(http.HandlerFunc(webApp.ExportTab)))))
+	registerNonEssentialAPI("POST", "/api/v1/users/{userID}/tabs/import", webApp.ImportTab)
+	registerNonEssentialAPI("POST", "/api/v1/users/{userID}/tabs/import/startpage", webApp.ImportStartPage)
+	handleAPI("GET", "/api/v1/tabs/{tabID}/template", shedder.reject(webApp.withTimeout("/api/v1/tabs/{tabID}/template", requestTimeout, webApp.apiTokenFilter(privateAPI)(http.HandlerFunc(webApp.ExportTabTemplate)))))
+	registerNonEssentialAPI("POST", "/api/v1/users/{userID}/templates", webApp.ImportTemplate)
+	registerPrivateAPI("POST", "/api/v1/tabs/{tabID}/widgets", webApp.NewWidget)
+	registerPrivateAPI("POST", "/api/v1/tabs/{tabID}/widgets/bulk", webApp.NewFeedWidgets)
+	registerPrivateAPI("POST", "/api/v1/tabs/{tabID}/widgets/{widgetID}", webApp.EditWidget)
+	registerPrivateAPI("DELETE", "/api/v1/tabs/{tabID}/widgets/{widgetID}", webApp.DeleteWidget)
+	registerPrivateAPI("POST", "/api/v1/tabs/{tabID}/layout", webApp.UpdateLayout)
+	registerNonEssentialAPI("GET", "/api/v1/tabs/{tabID}/suggestions", webApp.GetSuggestions)
+	registerNonEssentialAPI("POST", "/api/v1/tabs/{tabID}/views", webApp.RecordWidgetViews)
+
+	registerCachedPrivateAPI("GET", "/api/v1/users/{userID}/feeds/{feedID}/items", webApp.GetFeedItems)
+	registerNonEssentialAPI("GET", "/api/v1/users/{userID}/feeds/{feedID}/digest", webApp.GetFeedDigest)
+	registerPrivatePage("GET", "/pages/feeds/{feedID}/favicon", webApp.FeedFavicon)
+	registerPrivatePage("GET", "/pages/feeds/{feedID}/thumbnail", webApp.ItemThumbnail)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/feeds/{feedID}", webApp.MarkAsRead)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/feeds/{feedID}/starred", webApp.StarItem)
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/starred", webApp.GetStarredItems)
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/webhook", webApp.GetUserWebhook)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/webhook", webApp.SetUserWebhook)
+	registerPrivateAPI("DELETE", "/api/v1/users/{userID}/webhook", webApp.RemoveUserWebhook)
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/notifications", webApp.GetNotificationSettings)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/notifications", webApp.SetNotificationSettings)
+	registerPrivateAPI("DELETE", "/api/v1/users/{userID}/notifications", webApp.DeleteNotificationSettings)
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/tags", webApp.GetTags)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/tags", webApp.SetTag)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/tags/{name}", webApp.UpdateTag)
+	registerPrivateAPI("DELETE", "/api/v1/users/{userID}/tags/{name}", webApp.DeleteTag)
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/tags/{name}/collection", webApp.GetTagCollection)
+	registerNonEssentialAPI("GET", "/api/v1/users/{userID}/widgets/stale", webApp.GetStaleWidgets)
+
+	registerPrivateAPI("GET", "/api/v1/users/{userID}/accounts", webApp.GetAssociatedAccounts)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/accounts/merge", webApp.MergeDuplicateAccounts)
+	registerPrivateAPI("DELETE", "/api/v1/users/{userID}/accounts/{accountID}", webApp.RevokeAccount)
+	registerPrivateAPI("PATCH", "/api/v1/users/{userID}/accounts/{accountID}", webApp.RelabelAccount)
+	registerNonEssentialAPI("GET", "/api/v1/users/{userID}/accounts/{accountID}/usage", webApp.GetAccountUsage)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/accounts/{accountID}/watch", webApp.WatchAccount)
+
+	registerCachedPrivateAPI("GET", "/api/v1/users/{userID}/accounts/{accountID}/emails", webApp.GetEmails)
+	registerPrivateAPI("POST", "/api/v1/users/{userID}/accounts/{accountID}/emails/{guid}/actions", webApp.EmailAction)
+
+	handleAPI("POST", "/api/v1/preview", shedder.reject(webApp.withTimeout("/api/v1/preview", previewTimeout, privateJSON(webApp.Preview))))
+
+	registerPrivateAPI("POST", "/api/v1/admin/tokens/rotate", webApp.RotateTokenKeys)
+	registerPrivateAPI("POST", "/api/v1/admin/widgets/upgrade", webApp.UpgradeWidgetConfigs)
+	registerNonEssentialAPI("GET", "/api/v1/admin/users", webApp.GetUsers)
+	registerNonEssentialAPI("GET", "/api/v1/admin/users/{userID}/stats", webApp.GetUserStats)
+	registerNonEssentialAPI("GET", "/api/v1/admin/feeds/stats", webApp.GetFeedStats)
+	registerNonEssentialAPI("GET", "/api/v1/admin/usage", webApp.GetAggregateUsage)
+	registerPrivateAPI("GET", "/api/v1/admin/locks", webApp.GetLockStats)
+	registerNonEssentialAPI("GET", "/api/v1/admin/audit", webApp.GetAuditEvents)
+	registerNonEssentialAPI("GET", "/api/v1/admin/metrics/apis", webApp.GetAPIMetrics)
+	registerNonEssentialAPI("GET", "/api/v1/admin/metrics/requests", webApp.GetSlowRequestMetrics)
+	registerNonEssentialAPI("GET", "/api/v1/admin/metrics/queries", webApp.GetSlowQueryMetrics)
+
+	//Described once all the routes are registered
+	spec := &openAPISpec{router: s.Router()}
+	registerPublicAPI("GET", "/api/v1/openapi.json", spec.get)
+
+	if len(opts.CORS.AllowedOrigins) > 0 {
+		//Matches the preflight requests of all the routes
+		s.Router().Methods("OPTIONS").HandlerFunc(cors.preflight)
+	} else {
+		s.AllowCORS()
+	}
 
 	return s, nil
 }
@@ -90,8 +295,180 @@ func (e invalidEntry) IsNotFound() bool {
 	return true
 }
 
+type authorizationRequired interface {
+	AuthorizationURL() string
+}
+
+type reauthRequired interface {
+	ReauthAccountID() int64
+}
+
+//reauthPayload details the reauth_required errors, for the widget to prompt the user to authorize the account again
+type reauthPayload struct {
+	ReauthRequired bool   `json:"reauth_required"`
+	AccountID      int64  `json:"account_id"`
+	ReauthorizeURL string `json:"reauthorize_url"`
+}
+
+func newReauthPayload(userID string, err reauthRequired) reauthPayload {
+	return reauthPayload{
+		ReauthRequired: true,
+		AccountID:      err.ReauthAccountID(),
+		ReauthorizeURL: fmt.Sprintf("/pages/users/%s/accounts/%d/reauthorize", userID, err.ReauthAccountID()),
+	}
+}
+
+//APIPrefix is the prefix of the routes of the current version of the API.
+//The unversioned /api/ routes remain as aliases of the version 1, for the existing clients.
+const APIPrefix = "/api/v1"
+
+//legacyAPIPath returns the unversioned alias of a route of the version 1 of the API
+func legacyAPIPath(path string) string {
+	return "/api" + strings.TrimPrefix(path, APIPrefix)
+}
+
+//version is the version of the server and its API
+const version = "0.10-beta"
+
 type webApp struct {
 	app *okihome.App
+	//slowRequest is the duration above which a request is logged and recorded
+	slowRequest time.Duration
+}
+
+//apiTokenFilter authenticates the requests carrying a personal API token as bearer.
+//The other requests are handled by the given filter.
+func (wa webApp) apiTokenFilter(filter func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		filtered := filter(h)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer "+api.APITokenPrefix) {
+				filtered.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			user, err := wa.app.AuthenticateAPIToken(ctx, strings.TrimPrefix(auth, "Bearer "))
+			if err != nil {
+				wa.app.Error(ctx, errors.Wrap(err, "API token authentication failed"))
+				writeAPIError(w, http.StatusUnauthorized, APIError{Code: ErrorUnauthenticated, Message: i18n.Translate(i18n.FromContext(ctx), "Invalid API token")})
+				return
+			}
+
+			wa.withAccessUser(h).ServeHTTP(w, r.WithContext(api.ContextWithUser(ctx, user)))
+		})
+	}
+}
+
+//withTimeout cancels the requests lasting more than the timeout, and logs and records the slow ones
+func (wa webApp) withTimeout(route string, timeout time.Duration, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		wa.serveWithTimeout(w, r, timeout, h)
+
+		if d := time.Since(start); d > wa.slowRequest {
+			wa.app.Infof(r.Context(), "Slow request %s %s (%s) took %s", r.Method, route, r.URL.Path, d)
+			wa.app.RecordSlowRequest(r.Method+" "+route, d)
+		}
+	})
+}
+
+//serveWithTimeout keeps the response of the handler in memory, to replace it by the timeout error envelope
+//if the handler does not return in time
+func (wa webApp) serveWithTimeout(w http.ResponseWriter, r *http.Request, timeout time.Duration, h http.Handler) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	//The handler has its own headers, as it may still be running once the timeout answer is sent
+	b := &bufferedResponse{header: make(http.Header)}
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicked <- p
+			}
+		}()
+		h.ServeHTTP(b, r.WithContext(ctx))
+		close(done)
+	}()
+
+	select {
+	case p := <-panicked:
+		panic(p)
+	case <-done:
+		for k, v := range b.header {
+			w.Header()[k] = v
+		}
+		if b.status == 0 {
+			b.status = http.StatusOK
+		}
+		w.WriteHeader(b.status)
+		w.Write(b.body.Bytes())
+	case <-ctx.Done():
+		wa.writeError(w, r, ctx.Err())
+	}
+}
+
+//bufferedResponse keeps a response in memory until it is known whether it must be sent
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
+
+//withETag adds a weak ETag to the successful responses, computed from their content,
+//and replaces them by a 304 when it matches the If-None-Match header of the request
+func withETag(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := &bufferedResponse{header: w.Header()}
+		h.ServeHTTP(b, r)
+		if b.status == 0 {
+			b.status = http.StatusOK
+		}
+
+		if b.status == http.StatusOK {
+			sum := sha256.Sum256(b.body.Bytes())
+			etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+			w.Header().Set("ETag", etag)
+
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				w.Header().Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+
+		w.WriteHeader(b.status)
+		w.Write(b.body.Bytes())
+	})
+}
+
+//etagMatches checks if the If-None-Match header lists the given ETag, using the weak comparison
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
 }
 
 func (wa webApp) ServiceCallback(w http.ResponseWriter, r *http.Request) {
@@ -101,9 +478,8 @@ func (wa webApp) ServiceCallback(w http.ResponseWriter, r *http.Request) {
 
 	state := r.FormValue("state")
 	code := r.FormValue("code")
-	wa.app.Infof(ctx, "Callback received: %s", state)
 
-	err := wa.app.HandleOauth2Callback(ctx, serviceName, state, code)
+	result, err := wa.app.HandleOauth2Callback(ctx, serviceName, state, code)
 	if err != nil {
 		e := errors.Wrap(err, "Unable to handle callback")
 		wa.app.Error(ctx, e)
@@ -111,8 +487,8 @@ func (wa webApp) ServiceCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//Get userID from context
-	userInfo, err := server.GetUserInfo(ctx)
+	//Get userID from context, as mapped by the user interactor
+	userID, err := wa.app.CurrentUserID(ctx)
 	if err != nil {
 		e := errors.Wrap(err, "Unable to retrieve userID")
 		wa.app.Error(ctx, e)
@@ -120,22 +496,16 @@ func (wa webApp) ServiceCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accounts, err := wa.app.AssociatedServiceAccounts(ctx, userInfo.ID(), serviceName)
-	if err != nil {
-		e := errors.Wrap(err, "AssociatedServiceAccounts failed")
-		wa.app.Error(ctx, e)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	if len(accounts) > 0 {
-		//Redirect to the status page
-		url := fmt.Sprintf("/pages/users/%s/accounts/%d", userInfo.ID(), accounts[len(accounts)-1].ID)
-		wa.app.Infof(ctx, "Redirecting to %s", url)
+	if result.AccountID > 0 {
+		//Redirect to the status page of the authorized account
+		url := fmt.Sprintf("/pages/users/%s/accounts/%d", userID, result.AccountID)
+		if result.WidgetID > 0 {
+			url += fmt.Sprintf("?tab=%d&widget=%d", result.TabID, result.WidgetID)
+		}
 		http.Redirect(w, r, url, http.StatusFound)
 	} else {
 		//Redirect to the register page
 		url := "/pages/services/" + serviceName + "/register"
-		wa.app.Infof(ctx, "Redirecting to %s", url)
 		http.Redirect(w, r, url, http.StatusFound)
 	}
 
@@ -146,8 +516,21 @@ func (wa webApp) ServiceRegister(w http.ResponseWriter, r *http.Request) {
 
 	serviceName := server.Param(r, "serviceName")
 
-	//Get userID from context
-	userInfo, err := server.GetUserInfo(ctx)
+	//Optional tab on which an email widget of the new account is created
+	var widgetTabID int64
+	if tab := r.FormValue("tab"); len(tab) > 0 {
+		var err error
+		widgetTabID, err = strconv.ParseInt(tab, 10, 64)
+		if err != nil {
+			e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+			wa.app.Error(ctx, e)
+			http.Error(w, "Invalid tab ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	//Get userID from context, as mapped by the user interactor
+	userID, err := wa.app.CurrentUserID(ctx)
 	if err != nil {
 		e := errors.Wrap(err, "Unable to retrieve userID")
 		wa.app.Error(ctx, e)
@@ -155,7 +538,7 @@ func (wa webApp) ServiceRegister(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	accounts, err := wa.app.AssociatedServiceAccounts(ctx, userInfo.ID(), serviceName)
+	accounts, err := wa.app.AssociatedServiceAccounts(ctx, userID, serviceName)
 	if err != nil {
 		e := errors.Wrap(err, "GetServiceToken failed")
 		wa.app.Error(ctx, e)
@@ -164,7 +547,7 @@ func (wa webApp) ServiceRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if len(accounts) == 0 {
-		authURL, err := wa.app.ServiceRegister(ctx, serviceName)
+		authURL, err := wa.app.ServiceRegister(ctx, serviceName, widgetTabID)
 		if err != nil {
 			e := errors.Wrap(err, "ServiceRegister failed")
 			wa.app.Error(ctx, e)
@@ -172,17 +555,65 @@ func (wa webApp) ServiceRegister(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		wa.app.Infof(ctx, "Redirect: %s", authURL)
 		http.Redirect(w, r, authURL, http.StatusFound)
 		return
 	}
 
 	//Redirect to the status page
-	url := fmt.Sprintf("/pages/users/%s/accounts/%d", userInfo.ID(), accounts[0].ID)
-	wa.app.Infof(ctx, "Redirecting to %s", url)
+	url := fmt.Sprintf("/pages/users/%s/accounts/%d", userID, accounts[0].ID)
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
+func (wa webApp) FeedFavicon(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	feedIDstr := server.Param(r, "feedID")
+	feedID, err := strconv.ParseInt(feedIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Feed ID error")
+		wa.app.Error(ctx, e)
+		http.Error(w, "Invalid feed ID", http.StatusBadRequest)
+		return
+	}
+
+	favicon, err := wa.app.FeedFavicon(ctx, feedID)
+	if err != nil {
+		e := errors.Wrap(err, "Getting favicon failed")
+		wa.app.Error(ctx, e)
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", favicon.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Write(favicon.Data)
+}
+
+func (wa webApp) ItemThumbnail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	feedIDstr := server.Param(r, "feedID")
+	feedID, err := strconv.ParseInt(feedIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Feed ID error")
+		wa.app.Error(ctx, e)
+		http.Error(w, "Invalid feed ID", http.StatusBadRequest)
+		return
+	}
+
+	thumbnail, err := wa.app.ItemThumbnail(ctx, feedID, r.URL.Query().Get("guid"))
+	if err != nil {
+		e := errors.Wrap(err, "Getting thumbnail failed")
+		wa.app.Error(ctx, e)
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", thumbnail.ContentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Write(thumbnail.Data)
+}
+
 func (wa webApp) AccountStatus(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -227,10 +658,67 @@ func (wa webApp) AccountStatus(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (wa webApp) AuthorizeFeature(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := server.Param(r, "userID")
+	accountIDstr := server.Param(r, "accountID")
+	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account ID error")
+		wa.app.Error(ctx, e)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	feature := api.Feature(r.FormValue("feature"))
+
+	authURL, err := wa.app.AuthorizeFeature(ctx, userID, accountID, feature)
+	if err != nil {
+		e := errors.Wrap(err, "AuthorizeFeature failed")
+		wa.app.Error(ctx, e)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if len(authURL) > 0 {
+		http.Redirect(w, r, authURL, http.StatusFound)
+		return
+	}
+
+	//Already authorized, redirect to the status page
+	url := fmt.Sprintf("/pages/users/%s/accounts/%d", userID, accountID)
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func (wa webApp) ReauthorizeAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := server.Param(r, "userID")
+	accountIDstr := server.Param(r, "accountID")
+	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account ID error")
+		wa.app.Error(ctx, e)
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+
+	authURL, err := wa.app.ReauthorizeAccount(ctx, userID, accountID)
+	if err != nil {
+		e := errors.Wrap(err, "ReauthorizeAccount failed")
+		wa.app.Error(ctx, e)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
 func (wa webApp) GetVersion(req *http.Request) (interface{}, error) {
 	return struct {
 		Version string `json:"version"`
-	}{Version: "0.10-beta"}, nil
+	}{Version: version}, nil
 }
 
 func (wa webApp) GetServices(req *http.Request) (interface{}, error) {
@@ -261,59 +749,1999 @@ func (wa webApp) GetUser(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) BackupUser(req *http.Request) (interface{}, error) {
+//UpdateUser updates the settings of the user given in the body, the other ones being kept
+func (wa webApp) UpdateUser(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
 	userID := server.Param(req, "userID")
 
-	data, err := wa.app.BackupUser(ctx, userID)
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
 	if err != nil {
-		e := errors.Wrap(err, "Unable to retrieve user backup")
+		e := errors.Wrap(invalidEntry{err}, "Settings are missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var jsonItem struct {
+		TimeZone *string `json:"time_zone"`
+		Locale   *string `json:"locale"`
+	}
+	if err := json.Unmarshal(body, &jsonItem); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Settings decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	if jsonItem.TimeZone == nil && jsonItem.Locale == nil {
+		e := invalidEntry{errors.New("Settings are missing")}
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
+	var data api.User
+	if jsonItem.TimeZone != nil {
+		data, err = wa.app.SetTimeZone(ctx, userID, *jsonItem.TimeZone)
+		if err != nil {
+			e := errors.Wrap(err, "Unable to set time zone")
+			wa.app.Error(ctx, e)
+			return nil, e
+		}
+	}
+	if jsonItem.Locale != nil {
+		data, err = wa.app.SetLocale(ctx, userID, *jsonItem.Locale)
+		if err != nil {
+			e := errors.Wrap(err, "Unable to set locale")
+			wa.app.Error(ctx, e)
+			return nil, e
+		}
+	}
+
 	return data, nil
 }
 
-func (wa webApp) RestoreUser(req *http.Request) (interface{}, error) {
-	ctx := req.Context()
+//eventsKeepAlive is the period of the comments sent on idle event streams, to keep the connections open
+const eventsKeepAlive = 30 * time.Second
 
-	userID := server.Param(req, "userID")
+//Events streams the dashboard updates of the user as Server-Sent Events
+func (wa webApp) Events(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 
-	body, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
+	userID := server.Param(r, "userID")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, unsubscribe, err := wa.app.SubscribeEvents(ctx, userID)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Snapshot is missing")
+		e := errors.Wrap(err, "Unable to subscribe to events")
 		wa.app.Error(ctx, e)
-		return nil, e
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				wa.app.Error(ctx, errors.Wrap(err, "Event encoding failed"))
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+//WebSocket sends the same updates as Events and executes the commands of the client
+func (wa webApp) WebSocket(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := server.Param(r, "userID")
+
+	events, unsubscribe, err := wa.app.SubscribeEvents(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to subscribe to events")
+		wa.app.Error(ctx, e)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	defer unsubscribe()
+
+	ws := websocket.Server{
+		//Reject the connections opened by the pages of other sites with the session of the user
+		Handshake: func(cfg *websocket.Config, req *http.Request) error {
+			if cfg.Origin != nil && cfg.Origin.Host != req.Host {
+				return errors.New("Cross-origin WebSocket refused: " + cfg.Origin.String())
+			}
+			return nil
+		},
+		Handler: func(conn *websocket.Conn) {
+			wa.serveWebSocket(ctx, conn, userID, events)
+		},
+	}
+	ws.ServeHTTP(w, r)
+}
+
+func (wa webApp) serveWebSocket(ctx context.Context, conn *websocket.Conn, userID string, events <-chan api.Event) {
+
+	//The commands are read in background, their failures being sent back with the events
+	failures := make(chan api.Event, 1)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(closed)
+		for {
+			var cmd api.Command
+			if err := websocket.JSON.Receive(conn, &cmd); err != nil {
+				return
+			}
+			if err := wa.executeCommand(ctx, userID, cmd); err != nil {
+				e := errors.Wrap(err, "Command "+string(cmd.Type)+" failed")
+				wa.app.Error(ctx, e)
+				select {
+				case failures <- api.Event{Type: api.EventError, TabID: cmd.TabID, FeedID: cmd.FeedID, Error: e.Error()}:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var event api.Event
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case event = <-events:
+		case event = <-failures:
+		}
+		if err := websocket.JSON.Send(conn, event); err != nil {
+			return
+		}
+	}
+}
+
+func (wa webApp) executeCommand(ctx context.Context, userID string, cmd api.Command) error {
+	switch cmd.Type {
+	case api.CommandMarkRead:
+		return wa.app.MarkAsRead(ctx, userID, cmd.FeedID, cmd.GUIDs)
+	case api.CommandRefreshWidget:
+		return wa.app.RefreshWidget(ctx, userID, cmd.TabID, cmd.WidgetID)
+	}
+	return invalidEntry{errors.New("Unknown command: " + string(cmd.Type))}
+}
+
+func (wa webApp) DeleteUser(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.DeleteUser(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to delete user")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+//SnapshotPassphraseHeader is the header of the passphrase encrypting the downloaded snapshot,
+//or decrypting the restored one
+const SnapshotPassphraseHeader = "X-Okihome-Passphrase"
+
+//BackupUser answers with the snapshot of the user, as a JSON file to be downloaded.
+//The snapshot is encrypted if a passphrase is given.
+func (wa webApp) BackupUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	userID := server.Param(r, "userID")
+
+	var data interface{}
+	s, err := wa.app.BackupUser(ctx, userID)
+	data = s
+	if passphrase := r.Header.Get(SnapshotPassphraseHeader); err == nil && len(passphrase) > 0 {
+		data, err = okihome.EncryptSnapshot(s, passphrase)
+	}
+	if err == nil {
+		attachment(w, fmt.Sprintf("okihome-%s-%s.json", url.PathEscape(userID), time.Now().UTC().Format("20060102")))
+	}
+
+	wa.jsonHandler(func(req *http.Request) (interface{}, error) {
+		if err != nil {
+			e := errors.Wrap(err, "Unable to retrieve user backup")
+			wa.app.Error(ctx, e)
+			return nil, e
+		}
+		return data, nil
+	}).ServeHTTP(w, r)
+}
+
+//attachment asks the browser to download the response as a file with the given name
+func attachment(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+}
+
+//ExportTab answers with the tab and the feeds of its widgets, as a JSON file to be downloaded
+func (wa webApp) ExportTab(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tabID, err := strconv.ParseInt(server.Param(r, "tabID"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(invalidEntry{err}, "Tab ID error")
+	}
+
+	var data api.TabExport
+	if err == nil {
+		data, err = wa.app.ExportTab(ctx, tabID)
+		if err != nil {
+			err = errors.Wrap(err, "Unable to export tab")
+		}
+	}
+	if err == nil {
+		attachment(w, fmt.Sprintf("okihome-tab-%d.json", tabID))
+	}
+
+	wa.jsonHandler(func(req *http.Request) (interface{}, error) {
+		if err != nil {
+			wa.app.Error(ctx, err)
+			return nil, err
+		}
+		return data, nil
+	}).ServeHTTP(w, r)
+}
+
+//ExportTabTemplate answers with the tab without personal data, as a JSON file to be downloaded
+func (wa webApp) ExportTabTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	tabID, err := strconv.ParseInt(server.Param(r, "tabID"), 10, 64)
+	if err != nil {
+		err = errors.Wrap(invalidEntry{err}, "Tab ID error")
+	}
+
+	var data api.TabTemplate
+	if err == nil {
+		data, err = wa.app.TabTemplate(ctx, tabID)
+		if err != nil {
+			err = errors.Wrap(err, "Unable to export tab template")
+		}
+	}
+	if err == nil {
+		attachment(w, fmt.Sprintf("okihome-template-%d.json", tabID))
+	}
+
+	wa.jsonHandler(func(req *http.Request) (interface{}, error) {
+		if err != nil {
+			wa.app.Error(ctx, err)
+			return nil, err
+		}
+		return data, nil
+	}).ServeHTTP(w, r)
+}
+
+func (wa webApp) ImportTemplate(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, MaxSnapshotSize+1))
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Template is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	if len(body) > MaxSnapshotSize {
+		e := errors.Wrap(invalidEntry{errors.Errorf("at most %d bytes", MaxSnapshotSize)}, "Template is too large")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var request api.TemplateImport
+	if err := json.Unmarshal(body, &request); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Template is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.ImportTemplate(ctx, userID, request)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to import template")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) ImportStartPage(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, MaxSnapshotSize+1))
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Start page export is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	if len(body) > MaxSnapshotSize {
+		e := errors.Wrap(invalidEntry{errors.Errorf("at most %d bytes", MaxSnapshotSize)}, "Start page export is too large")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.ImportStartPage(ctx, userID, req.URL.Query().Get("format"), body)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to import start page")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) ImportTab(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, MaxSnapshotSize+1))
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab export is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	if len(body) > MaxSnapshotSize {
+		e := errors.Wrap(invalidEntry{errors.Errorf("at most %d bytes", MaxSnapshotSize)}, "Tab export is too large")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var export api.TabExport
+	if err := json.Unmarshal(body, &export); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab export is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.ImportTab(ctx, userID, export, req.URL.Query().Get("title"))
+	if err != nil {
+		e := errors.Wrap(err, "Unable to import tab")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+//MaxSnapshotSize is the size of the largest snapshot accepted by a restore, in bytes
+const MaxSnapshotSize = 32 << 20
+
+//readSnapshot reads the snapshot given in the body of the request, decrypting it with the passphrase of the request
+//if encrypted, and the selection of the tabs to restore given in the query
+func (wa webApp) readSnapshot(req *http.Request) (api.Snapshot, api.RestoreSelection, error) {
+	ctx := req.Context()
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, MaxSnapshotSize+1))
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Snapshot is missing")
+		wa.app.Error(ctx, e)
+		return api.Snapshot{}, api.RestoreSelection{}, e
+	}
+	if len(body) > MaxSnapshotSize {
+		e := errors.Wrap(invalidEntry{errors.Errorf("at most %d bytes", MaxSnapshotSize)}, "Snapshot is too large")
+		wa.app.Error(ctx, e)
+		return api.Snapshot{}, api.RestoreSelection{}, e
+	}
+
+	var s api.Snapshot
+	var encrypted api.EncryptedSnapshot
+	if err := json.Unmarshal(body, &encrypted); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Snapshot is invalid")
+		wa.app.Error(ctx, e)
+		return api.Snapshot{}, api.RestoreSelection{}, e
+	}
+	if encrypted.Version != 0 {
+		passphrase := req.Header.Get(SnapshotPassphraseHeader)
+		if len(passphrase) == 0 {
+			e := invalidEntry{errors.New("Snapshot passphrase is missing")}
+			wa.app.Error(ctx, e)
+			return api.Snapshot{}, api.RestoreSelection{}, e
+		}
+		s, err = okihome.DecryptSnapshot(encrypted, passphrase)
+		if err != nil {
+			e := errors.Wrap(err, "Unable to decrypt snapshot")
+			wa.app.Error(ctx, e)
+			return api.Snapshot{}, api.RestoreSelection{}, e
+		}
+	} else if err := json.Unmarshal(body, &s); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Snapshot is invalid")
+		wa.app.Error(ctx, e)
+		return api.Snapshot{}, api.RestoreSelection{}, e
+	}
+
+	//Optional selection of the tabs to restore
+	var selection api.RestoreSelection
+	for _, idStr := range req.URL.Query()["tab"] {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+			wa.app.Error(ctx, e)
+			return api.Snapshot{}, api.RestoreSelection{}, e
+		}
+		selection.TabIDs = append(selection.TabIDs, id)
+	}
+	selection.Titles = req.URL.Query()["title"]
+	selection.Items = req.URL.Query().Get("items") == "true"
+
+	return s, selection, nil
+}
+
+func (wa webApp) RestoreUser(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	s, selection, err := wa.readSnapshot(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = wa.app.RestoreUser(ctx, userID, s, selection)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to restore user")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return nil, nil
+}
+
+func (wa webApp) PreviewRestore(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	s, selection, err := wa.readSnapshot(req)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := wa.app.PreviewRestore(ctx, userID, s, selection)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to preview restore")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetAssociatedAccounts(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.AssociatedAccounts(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve associated accounts")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) RevokeAccount(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+	accountIDstr := server.Param(req, "accountID")
+	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account ID error")
+		wa.app.Error(ctx, e)
+	}
+
+	force := req.FormValue("force") == "true"
+
+	data, err := wa.app.RevokeAccount(ctx, userID, accountID, force)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to revoke account")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) MergeDuplicateAccounts(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.MergeDuplicateAccounts(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to merge duplicate accounts")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) WatchAccount(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+	accountIDstr := server.Param(req, "accountID")
+	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	expiration, err := wa.app.WatchAccount(ctx, userID, accountID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to watch account")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return struct {
+		Expiration time.Time `json:"expiration"`
+	}{expiration}, nil
+}
+
+func (wa webApp) HandlePush(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	serviceName := server.Param(req, "serviceName")
+	token := req.URL.Query().Get("token")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Notification is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	err = wa.app.HandlePush(ctx, serviceName, token, body)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to handle push notification")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return true, nil
+}
+
+func (wa webApp) RelabelAccount(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	accountIDstr := server.Param(req, "accountID")
+	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account label is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var jsonItem struct {
+		Label string `json:"label"`
+	}
+	if err := json.Unmarshal(body, &jsonItem); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account label decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.RelabelAccount(ctx, userID, accountID, jsonItem.Label)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to relabel account")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetAccountUsage(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+	accountIDstr := server.Param(req, "accountID")
+	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.AccountUsage(ctx, userID, accountID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve account usage")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+//GetTabBySlug returns the tab with the given slug, redirecting the former slugs to the current one
+func (wa webApp) GetTabBySlug(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	slug := server.Param(r, "slug")
+
+	tab, err := wa.app.TabBySlug(ctx, slug)
+	if err == nil && len(tab.Slug) > 0 && tab.Slug != slug {
+		//Same version of the API as the request
+		location := path.Dir(r.URL.Path) + "/" + url.PathEscape(tab.Slug)
+		http.Redirect(w, r, location, http.StatusMovedPermanently)
+		return
+	}
+
+	wa.jsonHandler(func(req *http.Request) (interface{}, error) {
+		if err != nil {
+			e := errors.Wrap(err, "Unable to retrieve tab")
+			wa.app.Error(ctx, e)
+			return nil, e
+		}
+		return tab, nil
+	}).ServeHTTP(w, r)
+}
+
+func (wa webApp) GetTab(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.Tab(ctx, tabID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve tab")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetTabContent(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.TabContent(ctx, tabID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve tab")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) DeleteTab(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.DeleteTab(ctx, tabID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to delete tab")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) EditTab(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab edited items are missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var newSummary api.TabSummary
+	if err := json.Unmarshal(body, &newSummary); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab edited items are invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.EditTab(ctx, tabID, newSummary)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to edit tab")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) SetDefaultTab(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Default tab is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var jsonItem struct {
+		Default *bool `json:"default"`
+	}
+	if err := json.Unmarshal(body, &jsonItem); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Default tab decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.SetDefaultTab(ctx, tabID, jsonItem.Default)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to set default tab")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) DuplicateTab(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	//The title of the copy is optional
+	var summary api.TabSummary
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab copy error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &summary); err != nil {
+			e := errors.Wrap(invalidEntry{err}, "Tab copy is invalid")
+			wa.app.Error(ctx, e)
+			return nil, e
+		}
+	}
+
+	data, err := wa.app.DuplicateTab(ctx, tabID, summary.Title)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to duplicate tab")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) NewTab(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab description is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var tab api.TabSummary
+	if err := json.Unmarshal(body, &tab); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab description is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.NewTab(ctx, tab)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to add tab")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) ReorderTabs(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab order is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var order []int64
+	if err := json.Unmarshal(body, &order); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab order is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.ReorderTabs(ctx, userID, order)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to order tabs")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) BulkTabs(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab operations are missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var request api.TabBulkRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab operations are invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.BulkTabs(ctx, userID, request)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to apply tab operations")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) NewWidget(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget description is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var widget api.Widget
+	if err := json.Unmarshal(body, &widget); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget description is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	//The optional position is given along the widget, where the user dropped it
+	var jsonPosition struct {
+		Column   *int `json:"column"`
+		Position *int `json:"position"`
+	}
+	if err := json.Unmarshal(body, &jsonPosition); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget position is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var position *api.WidgetPosition
+	if jsonPosition.Column != nil || jsonPosition.Position != nil {
+		position = &api.WidgetPosition{Position: -1}
+		if jsonPosition.Column != nil {
+			position.Column = *jsonPosition.Column
+		}
+		if jsonPosition.Position != nil {
+			position.Position = *jsonPosition.Position
+		}
+	}
+
+	data, err := wa.app.NewWidget(ctx, tabID, widget, position)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to add widget")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) NewFeedWidgets(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Feed list is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var request api.WidgetBulkRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Feed list is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.NewFeedWidgets(ctx, tabID, request)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to add widgets")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) EditWidget(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	widgetIDstr := server.Param(req, "widgetID")
+	widgetID, err := strconv.ParseInt(widgetIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget config is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var editedConfig api.EditedWidgetConfig
+	if err := json.Unmarshal(body, &editedConfig); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget config is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.EditWidget(ctx, tabID, widgetID, editedConfig)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to edit widget")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) DeleteWidget(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	widgetIDstr := server.Param(req, "widgetID")
+	widgetID, err := strconv.ParseInt(widgetIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.DeleteWidget(ctx, tabID, widgetID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to delete widget")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) UpdateLayout(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widgets layout is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var layout [][]int64
+	if err := json.Unmarshal(body, &layout); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widgets layout is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.UpdateLayout(ctx, tabID, layout)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to update layout")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetSuggestions(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.WidgetSuggestions(ctx, tabID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve suggestions")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+//RecordWidgetViews is the beacon sent by the clients when widgets are displayed.
+//The body is read whatever its content type, as navigator.sendBeacon sends it as text.
+func (wa webApp) RecordWidgetViews(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget views error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var jsonViews struct {
+		Widgets []int64 `json:"widgets"`
+	}
+	if err := json.Unmarshal(body, &jsonViews); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget views decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	err = wa.app.RecordWidgetViews(ctx, tabID, jsonViews.Widgets)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to record widget views")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return true, nil
+}
+
+func (wa webApp) GetStaleWidgets(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	var olderThan time.Duration
+	if daysStr := req.URL.Query().Get("days"); len(daysStr) > 0 {
+		days, err := strconv.Atoi(daysStr)
+		if err != nil {
+			e := errors.Wrap(invalidEntry{err}, "Days error")
+			wa.app.Error(ctx, e)
+			return nil, e
+		}
+		olderThan = time.Duration(days) * 24 * time.Hour
+	}
+
+	data, err := wa.app.StaleWidgets(ctx, userID, olderThan)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve stale widgets")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) Preview(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	url := req.FormValue("url")
+	if len(url) == 0 && req.Body != nil {
+		if body, err := ioutil.ReadAll(req.Body); err == nil {
+			defer req.Body.Close()
+			var jsonItem struct {
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal(body, &jsonItem); err == nil {
+				url = jsonItem.URL
+			}
+		}
+	}
+
+	data, err := wa.app.Preview(ctx, url)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve items for preview")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetFeedItems(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	feedIDstr := server.Param(req, "feedID")
+	feedID, err := strconv.ParseInt(feedIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Feed ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	tabID, widgetID, err := widgetRef(req)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget reference error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	page, err := pageRequest(req)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Page error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.FeedItems(ctx, userID, feedID, tabID, widgetID, page)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve items")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+//GetDemo returns the demo dashboard, to the visitors who are not logged in
+func (wa webApp) GetDemo(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	data, err := wa.app.Demo(ctx)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve demo")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetDemoTab(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	tabIDstr := server.Param(req, "tabID")
+	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.DemoTab(ctx, tabID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve tab")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetDemoFeedItems(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	feedIDstr := server.Param(req, "feedID")
+	feedID, err := strconv.ParseInt(feedIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Feed ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	tabID, widgetID, err := widgetRef(req)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget reference error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	page, err := pageRequest(req)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Page error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.DemoFeedItems(ctx, feedID, tabID, widgetID, page)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve items")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetFeedDigest(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	feedIDstr := server.Param(req, "feedID")
+	feedID, err := strconv.ParseInt(feedIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Feed ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	tabID, widgetID, err := widgetRef(req)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget reference error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.FeedDigest(ctx, userID, feedID, tabID, widgetID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve digest")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) MarkAsRead(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	feedIDstr := server.Param(req, "feedID")
+	feedID, err := strconv.ParseInt(feedIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Feed ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "GUIDs error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var jsonItem struct {
+		GUIDs []string `json:"guids"`
+	}
+	if err := json.Unmarshal(body, &jsonItem); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "GUIDs decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	err = wa.app.MarkAsRead(ctx, userID, feedID, jsonItem.GUIDs)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve items")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return nil, nil
+}
+
+//StarItem stars or unstars a feed item
+func (wa webApp) StarItem(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	feedIDstr := server.Param(req, "feedID")
+	feedID, err := strconv.ParseInt(feedIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Feed ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Starred item error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var jsonItem struct {
+		GUID    string   `json:"guid"`
+		Starred bool     `json:"starred"`
+		Tags    []string `json:"tags"`
+	}
+	if err := json.Unmarshal(body, &jsonItem); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Starred item decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	if !jsonItem.Starred {
+		err = wa.app.UnstarItem(ctx, userID, feedID, jsonItem.GUID)
+		if err != nil {
+			e := errors.Wrap(err, "Unable to unstar item")
+			wa.app.Error(ctx, e)
+			return nil, e
+		}
+		return nil, nil
+	}
+
+	data, err := wa.app.StarItem(ctx, userID, feedID, jsonItem.GUID, jsonItem.Tags)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to star item")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetStarredItems(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.StarredItems(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve starred items")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetUserWebhook(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.UserWebhook(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve webhook")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) SetUserWebhook(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Webhook is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var webhook api.UserWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Webhook decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.SetUserWebhook(ctx, userID, webhook)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to set webhook")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) RemoveUserWebhook(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	err := wa.app.RemoveUserWebhook(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to remove webhook")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return true, nil
+}
+
+func (wa webApp) GetNotificationSettings(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.NotificationSettings(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve notification settings")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) SetNotificationSettings(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Notification settings are missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var settings api.NotificationSettings
+	if err := json.Unmarshal(body, &settings); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Notification settings decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.SetNotificationSettings(ctx, userID, settings)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to set notification settings")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) DeleteNotificationSettings(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	err := wa.app.DeleteNotificationSettings(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to remove notification settings")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return true, nil
+}
+
+func (wa webApp) GetTags(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.Tags(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve tags")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) SetTag(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tag is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var tag api.Tag
+	if err := json.Unmarshal(body, &tag); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tag decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.SetTag(ctx, userID, tag)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to set tag")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) UpdateTag(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+	name := server.Param(req, "name")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tag is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var tag api.Tag
+	if err := json.Unmarshal(body, &tag); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Tag decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.UpdateTag(ctx, userID, name, tag)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to update tag")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) DeleteTag(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+	name := server.Param(req, "name")
+
+	err := wa.app.DeleteTag(ctx, userID, name)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to delete tag")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return true, nil
+}
+
+func (wa webApp) GetTagCollection(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+	name := server.Param(req, "name")
+
+	data, err := wa.app.TagCollection(ctx, userID, name)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve tag collection")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetEmails(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	accountIDstr := server.Param(req, "accountID")
+	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	categories := req.URL.Query()["category"]
+	query := req.URL.Query().Get("q")
+
+	tabID, widgetID, err := widgetRef(req)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Widget reference error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.GetEmails(ctx, userID, accountID, categories, query, tabID, widgetID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve items")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) EmailAction(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+	guid := server.Param(req, "guid")
+
+	accountIDstr := server.Param(req, "accountID")
+	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Account ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Email action is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var jsonItem struct {
+		Action api.EmailAction `json:"action"`
+	}
+	if err := json.Unmarshal(body, &jsonItem); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Email action decoding failed")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	err = wa.app.EmailAction(ctx, userID, accountID, guid, jsonItem.Action)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to apply email action")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return nil, nil
+}
+
+func (wa webApp) GetManagedPolicy(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.ManagedPolicy(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve managed policy")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) SetManagedPolicy(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Managed policy is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var policy api.ManagedPolicy
+	if err := json.Unmarshal(body, &policy); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Managed policy is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.SetManagedPolicy(ctx, userID, policy)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to update managed policy")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) RemoveManagedPolicy(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.RemoveManagedPolicy(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to remove managed policy")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetLinkPolicies(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.LinkPolicies(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve link policies")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) SetLinkPolicies(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Link policies are missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var policies []api.LinkPolicy
+	if err := json.Unmarshal(body, &policies); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Link policies are invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.SetLinkPolicies(ctx, userID, policies)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to update link policies")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) GetApprovalRequests(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.ApprovalRequests(ctx, userID)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to retrieve approval requests")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) RequestApproval(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Approval request is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var jsonItem struct {
+		Kind  api.ApprovalKind `json:"kind"`
+		Value string           `json:"value"`
+	}
+	if err := json.Unmarshal(body, &jsonItem); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Approval request is invalid")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	data, err := wa.app.RequestApproval(ctx, userID, jsonItem.Kind, jsonItem.Value)
+	if err != nil {
+		e := errors.Wrap(err, "Unable to request approval")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	return data, nil
+}
+
+func (wa webApp) ReviewApprovalRequest(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	requestIDstr := server.Param(req, "requestID")
+	requestID, err := strconv.ParseInt(requestIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Approval request ID error")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Approval decision is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+
+	var jsonItem struct {
+		Approved bool `json:"approved"`
 	}
-
-	var s api.Snapshot
-	if err := json.Unmarshal(body, &s); err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Snapshot is invalid")
+	if err := json.Unmarshal(body, &jsonItem); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Approval decision is invalid")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	err = wa.app.RestoreUser(ctx, userID, s)
+	data, err := wa.app.ReviewApprovalRequest(ctx, userID, requestID, jsonItem.Approved)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to restore user")
+		e := errors.Wrap(err, "Unable to review approval request")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	return nil, nil
+	return data, nil
 }
 
-func (wa webApp) GetAssociatedAccounts(req *http.Request) (interface{}, error) {
+func (wa webApp) RotateTokenKeys(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	userID := server.Param(req, "userID")
-
-	data, err := wa.app.AssociatedAccounts(ctx, userID)
+	data, err := wa.app.RotateTokenKeys(ctx)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to retrieve associated accounts")
+		e := errors.Wrap(err, "Unable to rotate token keys")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -321,20 +2749,12 @@ func (wa webApp) GetAssociatedAccounts(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) RevokeAccount(req *http.Request) (interface{}, error) {
+func (wa webApp) UpgradeWidgetConfigs(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	userID := server.Param(req, "userID")
-	accountIDstr := server.Param(req, "accountID")
-	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
-	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Account ID error")
-		wa.app.Error(ctx, e)
-	}
-
-	data, err := wa.app.RevokeAccount(ctx, userID, accountID)
+	data, err := wa.app.UpgradeWidgetConfigs(ctx)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to revoke account")
+		e := errors.Wrap(err, "Unable to upgrade widget configs")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -342,77 +2762,85 @@ func (wa webApp) RevokeAccount(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) GetTab(req *http.Request) (interface{}, error) {
-	ctx := req.Context()
+//pageRequest reads the optional cursor and limit query parameters of a listing
+func pageRequest(req *http.Request) (api.PageRequest, error) {
 
-	tabIDstr := server.Param(req, "tabID")
-	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
-	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
-		wa.app.Error(ctx, e)
-		return nil, e
+	page := api.PageRequest{
+		Cursor: req.URL.Query().Get("cursor"),
 	}
 
-	data, err := wa.app.Tab(ctx, tabID)
-	if err != nil {
-		e := errors.Wrap(err, "Unable to retrieve tab")
-		wa.app.Error(ctx, e)
-		return nil, e
+	limitStr := req.URL.Query().Get("limit")
+	if len(limitStr) > 0 {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return page, errors.Wrap(err, "Limit error")
+		}
+		page.Limit = limit
 	}
 
-	return data, nil
+	return page, nil
 }
 
-func (wa webApp) DeleteTab(req *http.Request) (interface{}, error) {
-	ctx := req.Context()
+//widgetRef returns the optional widget given by the tab and widget query parameters
+func widgetRef(req *http.Request) (int64, int64, error) {
+
+	tabIDstr := req.URL.Query().Get("tab")
+	widgetIDstr := req.URL.Query().Get("widget")
+	if len(tabIDstr) == 0 || len(widgetIDstr) == 0 {
+		return 0, 0, nil
+	}
 
-	tabIDstr := server.Param(req, "tabID")
 	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
-		wa.app.Error(ctx, e)
-		return nil, e
+		return 0, 0, errors.Wrap(err, "Tab ID error")
 	}
-
-	data, err := wa.app.DeleteTab(ctx, tabID)
+	widgetID, err := strconv.ParseInt(widgetIDstr, 10, 64)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to delete tab")
-		wa.app.Error(ctx, e)
-		return nil, e
+		return 0, 0, errors.Wrap(err, "Widget ID error")
 	}
 
-	return data, nil
+	return tabID, widgetID, nil
 }
 
-func (wa webApp) EditTab(req *http.Request) (interface{}, error) {
+func (wa webApp) GetAPITokens(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	tabIDstr := server.Param(req, "tabID")
-	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.APITokens(ctx, userID)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		e := errors.Wrap(err, "Unable to retrieve API tokens")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
+	return data, nil
+}
+
+func (wa webApp) CreateAPIToken(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
 	body, err := ioutil.ReadAll(req.Body)
 	defer req.Body.Close()
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab edited items are missing")
+		e := errors.Wrap(invalidEntry{err}, "API token description is missing")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
-
-	var newSummary api.TabSummary
-	if err := json.Unmarshal(body, &newSummary); err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab edited items are invalid")
+	var jsonItem struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(body, &jsonItem); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "API token description decoding failed")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	data, err := wa.app.EditTab(ctx, tabID, newSummary)
+	data, err := wa.app.CreateAPIToken(ctx, userID, jsonItem.Name)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to edit tab")
+		e := errors.Wrap(err, "Unable to create API token")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -420,108 +2848,79 @@ func (wa webApp) EditTab(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) NewTab(req *http.Request) (interface{}, error) {
+func (wa webApp) RevokeAPIToken(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	body, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
-	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab description is missing")
-		wa.app.Error(ctx, e)
-		return nil, e
-	}
+	userID := server.Param(req, "userID")
 
-	var tab api.TabSummary
-	if err := json.Unmarshal(body, &tab); err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab description is invalid")
+	tokenIDstr := server.Param(req, "tokenID")
+	tokenID, err := strconv.ParseInt(tokenIDstr, 10, 64)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "API token ID error")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	data, err := wa.app.NewTab(ctx, tab)
+	err = wa.app.RevokeAPIToken(ctx, userID, tokenID)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to add tab")
+		e := errors.Wrap(err, "Unable to revoke API token")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	return data, nil
+	return nil, nil
 }
 
-func (wa webApp) NewWidget(req *http.Request) (interface{}, error) {
+func (wa webApp) GetUsers(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	tabIDstr := server.Param(req, "tabID")
-	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	page, err := pageRequest(req)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		e := errors.Wrap(invalidEntry{err}, "Page error")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	body, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
+	data, err := wa.app.Users(ctx, page)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Widget description is missing")
+		e := errors.Wrap(err, "Unable to retrieve users")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	var widget api.Widget
-	if err := json.Unmarshal(body, &widget); err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Widget description is invalid")
-		wa.app.Error(ctx, e)
-		return nil, e
-	}
+	return data, nil
+}
 
-	//Convert widget.Config from map to the correct config
-	options, ok := widget.Config.(map[string]interface{})
-	if !ok {
-		e := errors.New("Widget configuration is invalid")
+func (wa webApp) GetAuditEvents(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	page, err := pageRequest(req)
+	if err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Page error")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
-	switch widget.Type {
-	case api.WidgetFeedType:
-		cfg := api.ConfigFeed{}
-		cfg.URL = options["url"].(string)
 
-		widget.Config = cfg
-	case api.WidgetEmailType:
-		cfg := api.ConfigEmail{}
-		var accountIDvalue int64
-		switch accountID := options["account_id"].(type) {
-		case string:
-			accountIDvalue, err = strconv.ParseInt(accountID, 10, 64)
+	query := req.URL.Query()
+	filter := api.AuditFilter{
+		UserID:  query.Get("user"),
+		ActorID: query.Get("actor"),
+		Action:  api.AuditAction(query.Get("action")),
+	}
+	for name, bound := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if s := query.Get(name); len(s) > 0 {
+			*bound, err = time.Parse(time.RFC3339, s)
 			if err != nil {
-				e := errors.Wrap(invalidEntry{err}, "Account ID error")
+				e := errors.Wrap(invalidEntry{err}, "Invalid "+name+" time")
 				wa.app.Error(ctx, e)
 				return nil, e
 			}
-		case int64:
-			accountIDvalue = accountID
-		case int32:
-			accountIDvalue = int64(accountID)
-		case int:
-			accountIDvalue = int64(accountID)
-		case float64:
-			accountIDvalue = int64(accountID)
-		default:
-			e := errors.New("Account ID is invalid")
-			wa.app.Infof(ctx, "Options %#v", options)
-			wa.app.Infof(ctx, "accountID %#v", accountID)
-			wa.app.Error(ctx, e)
-			return nil, e
 		}
-
-		cfg.AccountID = accountIDvalue
-
-		widget.Config = cfg
 	}
 
-	data, err := wa.app.NewWidget(ctx, tabID, widget)
+	data, err := wa.app.AuditEvents(ctx, filter, page)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to add widget")
+		e := errors.Wrap(err, "Unable to retrieve audit events")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -529,42 +2928,25 @@ func (wa webApp) NewWidget(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) EditWidget(req *http.Request) (interface{}, error) {
+func (wa webApp) GetAPIMetrics(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	tabIDstr := server.Param(req, "tabID")
-	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
-	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
-		wa.app.Error(ctx, e)
-		return nil, e
-	}
-	widgetIDstr := server.Param(req, "widgetID")
-	widgetID, err := strconv.ParseInt(widgetIDstr, 10, 64)
+	data, err := wa.app.APIMetrics(ctx)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Widget ID error")
+		e := errors.Wrap(err, "Unable to retrieve API metrics")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	body, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
-	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Widget config is missing")
-		wa.app.Error(ctx, e)
-		return nil, e
-	}
+	return data, nil
+}
 
-	var editedConfig api.WidgetConfig
-	if err := json.Unmarshal(body, &editedConfig); err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Widget config is invalid")
-		wa.app.Error(ctx, e)
-		return nil, e
-	}
+func (wa webApp) GetSlowRequestMetrics(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
 
-	data, err := wa.app.EditWidget(ctx, tabID, widgetID, editedConfig)
+	data, err := wa.app.SlowRequestMetrics(ctx)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to edit widget")
+		e := errors.Wrap(err, "Unable to retrieve slow request metrics")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -572,27 +2954,27 @@ func (wa webApp) EditWidget(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) DeleteWidget(req *http.Request) (interface{}, error) {
+func (wa webApp) GetSlowQueryMetrics(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	tabIDstr := server.Param(req, "tabID")
-	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
-	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
-		wa.app.Error(ctx, e)
-		return nil, e
-	}
-	widgetIDstr := server.Param(req, "widgetID")
-	widgetID, err := strconv.ParseInt(widgetIDstr, 10, 64)
+	data, err := wa.app.SlowQueryMetrics(ctx)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Widget ID error")
+		e := errors.Wrap(err, "Unable to retrieve slow query metrics")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	data, err := wa.app.DeleteWidget(ctx, tabID, widgetID)
+	return data, nil
+}
+
+func (wa webApp) GetUserStats(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	data, err := wa.app.UserStats(ctx, userID)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to delete widget")
+		e := errors.Wrap(err, "Unable to retrieve user statistics")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -600,35 +2982,25 @@ func (wa webApp) DeleteWidget(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) UpdateLayout(req *http.Request) (interface{}, error) {
+func (wa webApp) GetFeedStats(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	tabIDstr := server.Param(req, "tabID")
-	tabID, err := strconv.ParseInt(tabIDstr, 10, 64)
+	data, err := wa.app.FeedStats(ctx)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Tab ID error")
+		e := errors.Wrap(err, "Unable to retrieve feed statistics")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	body, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
-	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Widgets layout is missing")
-		wa.app.Error(ctx, e)
-		return nil, e
-	}
+	return data, nil
+}
 
-	var layout [][]int64
-	if err := json.Unmarshal(body, &layout); err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Widgets layout is invalid")
-		wa.app.Error(ctx, e)
-		return nil, e
-	}
+func (wa webApp) GetLockStats(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
 
-	data, err := wa.app.UpdateLayout(ctx, tabID, layout)
+	data, err := wa.app.LockStats(ctx)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to update layout")
+		e := errors.Wrap(err, "Unable to retrieve lock statistics")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -636,25 +3008,14 @@ func (wa webApp) UpdateLayout(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) Preview(req *http.Request) (interface{}, error) {
+func (wa webApp) GetRetentionPolicy(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	url := req.FormValue("url")
-	if len(url) == 0 && req.Body != nil {
-		if body, err := ioutil.ReadAll(req.Body); err == nil {
-			defer req.Body.Close()
-			var jsonItem struct {
-				URL string `json:"url"`
-			}
-			if err := json.Unmarshal(body, &jsonItem); err == nil {
-				url = jsonItem.URL
-			}
-		}
-	}
+	userID := server.Param(req, "userID")
 
-	data, err := wa.app.Preview(ctx, url)
+	data, err := wa.app.RetentionPolicy(ctx, userID)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to retrieve items for preview")
+		e := errors.Wrap(err, "Unable to retrieve retention policy")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -662,22 +3023,28 @@ func (wa webApp) Preview(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) GetFeedItems(req *http.Request) (interface{}, error) {
+func (wa webApp) SetRetentionPolicy(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
 	userID := server.Param(req, "userID")
 
-	feedIDstr := server.Param(req, "feedID")
-	feedID, err := strconv.ParseInt(feedIDstr, 10, 64)
+	body, err := ioutil.ReadAll(req.Body)
+	defer req.Body.Close()
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Feed ID error")
+		e := errors.Wrap(invalidEntry{err}, "Retention policy is missing")
+		wa.app.Error(ctx, e)
+		return nil, e
+	}
+	var policy api.RetentionPolicy
+	if err := json.Unmarshal(body, &policy); err != nil {
+		e := errors.Wrap(invalidEntry{err}, "Retention policy decoding failed")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	data, err := wa.app.FeedItems(ctx, userID, feedID)
+	data, err := wa.app.SetRetentionPolicy(ctx, userID, policy)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to retrieve items")
+		e := errors.Wrap(err, "Unable to set retention policy")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -685,61 +3052,67 @@ func (wa webApp) GetFeedItems(req *http.Request) (interface{}, error) {
 	return data, nil
 }
 
-func (wa webApp) MarkAsRead(req *http.Request) (interface{}, error) {
+func (wa webApp) GetActivity(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
 	userID := server.Param(req, "userID")
 
-	feedIDstr := server.Param(req, "feedID")
-	feedID, err := strconv.ParseInt(feedIDstr, 10, 64)
+	data, err := wa.app.Activity(ctx, userID)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Feed ID error")
+		e := errors.Wrap(err, "Unable to retrieve activity")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	body, err := ioutil.ReadAll(req.Body)
-	defer req.Body.Close()
-	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "GUIDs error")
-		wa.app.Error(ctx, e)
-		return nil, e
+	return data, nil
+}
+
+//usageDays returns the number of days of the usage statistics requested, 0 for the default
+func usageDays(req *http.Request) (int, error) {
+	daysStr := req.URL.Query().Get("days")
+	if len(daysStr) == 0 {
+		return 0, nil
 	}
-	var jsonItem struct {
-		GUIDs []string `json:"guids"`
+	days, err := strconv.Atoi(daysStr)
+	if err != nil {
+		return 0, errors.Wrap(invalidEntry{err}, "Days error")
 	}
-	if err := json.Unmarshal(body, &jsonItem); err != nil {
-		e := errors.Wrap(invalidEntry{err}, "GUIDs decoding failed")
-		wa.app.Error(ctx, e)
-		return nil, e
+	return days, nil
+}
+
+func (wa webApp) GetUsageStats(req *http.Request) (interface{}, error) {
+	ctx := req.Context()
+
+	userID := server.Param(req, "userID")
+
+	days, err := usageDays(req)
+	if err != nil {
+		wa.app.Error(ctx, err)
+		return nil, err
 	}
 
-	err = wa.app.MarkAsRead(ctx, userID, feedID, jsonItem.GUIDs)
+	data, err := wa.app.UsageStats(ctx, userID, days)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to retrieve items")
+		e := errors.Wrap(err, "Unable to retrieve usage statistics")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
 
-	return nil, nil
+	return data, nil
 }
 
-func (wa webApp) GetEmails(req *http.Request) (interface{}, error) {
+func (wa webApp) GetAggregateUsage(req *http.Request) (interface{}, error) {
 	ctx := req.Context()
 
-	userID := server.Param(req, "userID")
-
-	accountIDstr := server.Param(req, "accountID")
-	accountID, err := strconv.ParseInt(accountIDstr, 10, 64)
+	days, err := usageDays(req)
 	if err != nil {
-		e := errors.Wrap(invalidEntry{err}, "Account ID error")
-		wa.app.Error(ctx, e)
-		return nil, e
+		wa.app.Error(ctx, err)
+		return nil, err
 	}
 
-	data, err := wa.app.GetEmails(ctx, userID, accountID)
+	data, err := wa.app.AggregateUsage(ctx, days)
 	if err != nil {
-		e := errors.Wrap(err, "Unable to retrieve items")
+		e := errors.Wrap(err, "Unable to retrieve usage statistics")
 		wa.app.Error(ctx, e)
 		return nil, e
 	}
@@ -0,0 +1,69 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//backgroundTasks tracks the work done after the requests are answered, such as storing the fetched feeds
+type backgroundTasks struct {
+	mu       sync.Mutex
+	running  sync.WaitGroup
+	stopping bool
+}
+
+//goBackground runs f in background, the shutdown waiting for it.
+//Once the shutdown started, f is run before returning.
+func (app App) goBackground(f func()) {
+	app.tasks.mu.Lock()
+	if app.tasks.stopping {
+		app.tasks.mu.Unlock()
+		f()
+		return
+	}
+	app.tasks.running.Add(1)
+	app.tasks.mu.Unlock()
+
+	go func() {
+		defer app.tasks.running.Done()
+		f()
+	}()
+}
+
+//Shutdown waits for the background tasks in progress, then closes the repository.
+//The repository is not closed if the tasks are not done before the context.
+func (app App) Shutdown(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "App.Shutdown")
+	defer span.End()
+
+	app.tasks.mu.Lock()
+	app.tasks.stopping = true
+	app.tasks.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		app.tasks.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "waiting for background tasks failed")
+	}
+
+	err := app.repository.Close()
+	if err != nil {
+		return errors.Wrap(err, "closing repository failed")
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//maxSlugLength is the maximum number of characters of a generated slug, without its uniqueness suffix
+const maxSlugLength = 48
+
+//slugify returns a lower case version of the given title, its letters and digits being separated by dashes.
+//The letters are kept in any script, the slug being percent-encoded in the URLs.
+func slugify(title string) string {
+	var b strings.Builder
+	n := 0
+	dash := false
+	for _, c := range strings.ToLower(title) {
+		if n >= maxSlugLength {
+			break
+		}
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			b.WriteRune(c)
+			n++
+			dash = false
+		case n > 0 && !dash:
+			b.WriteRune('-')
+			n++
+			dash = true
+		}
+	}
+
+	slug := strings.Trim(b.String(), "-")
+	if len(slug) == 0 {
+		return "tab"
+	}
+	return slug
+}
+
+//assignTabSlug makes a slug generated from the given name the current one of the tab for the user.
+//A numeric suffix is added if the slug is already used by another tab of the user,
+//the next suffix being tried when another tab takes it at the same time.
+//The previous slug of the tab is kept to redirect to the new one.
+func assignTabSlug(ctx context.Context, repo api.Repository, userID string, tabID int64, name string) (string, error) {
+
+	base := slugify(name)
+
+	for i := 1; ; i++ {
+		slug := base
+		if i > 1 {
+			slug = fmt.Sprintf("%s-%d", base, i)
+		}
+
+		existing, err := repo.GetTabSlug(ctx, userID, slug)
+		if err != nil && !repo.IsNotFound(err) {
+			return "", errors.Wrap(err, "retrieving tab slug from datastore failed")
+		}
+		if err == nil {
+			if existing.TabID == tabID && existing.Current {
+				return slug, nil
+			}
+			if existing.TabID != tabID && existing.Current {
+				//Used by another tab
+				continue
+			}
+		}
+
+		//Free, former slug of another tab, or former slug of this tab
+		err = repo.StoreTabSlug(ctx, api.TabSlug{UserID: userID, Slug: slug, TabID: tabID, Current: true})
+		if _, ok := errors.Cause(err).(api.SlugTaken); ok {
+			//Taken by another tab since it was read
+			continue
+		}
+		if err != nil {
+			return "", errors.Wrap(err, "storing tab slug in datastore failed")
+		}
+		return slug, nil
+	}
+}
+
+//tabSlug returns the current slug of the tab for the user, empty if the tab has none yet.
+//Slugs are only assigned when the tabs are written, the tabs created before them getting one when edited.
+func (app App) tabSlug(ctx context.Context, userID string, tabID int64) (string, error) {
+
+	slug, err := app.repository.GetCurrentTabSlug(ctx, userID, tabID)
+	if err != nil {
+		if app.repository.IsNotFound(err) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "retrieving tab slug from datastore failed")
+	}
+
+	return slug, nil
+}
+
+//TabBySlug returns the tab of the current user with the given slug.
+//For a former slug, the returned tab holds its current slug so that clients can redirect to it.
+func (app App) TabBySlug(ctx context.Context, slug string) (api.Tab, error) {
+	ctx, span := tracing.Start(ctx, "App.TabBySlug")
+	defer span.End()
+
+	//Check that a user is logged
+	userID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.Tab{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	tabSlug, err := app.repository.GetTabSlug(ctx, userID, slug)
+	if err != nil {
+		return api.Tab{}, errors.Wrap(err, "retrieving tab slug from datastore failed")
+	}
+
+	return app.Tab(ctx, tabSlug.TabID)
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//MinPassphraseLength is the minimum length of the passphrase of an encrypted snapshot
+const MinPassphraseLength = 8
+
+//Parameters of the encrypted snapshots
+const (
+	encryptedSnapshotVersion = 2
+	//legacySnapshotVersion is the version of the snapshots encrypted without their header as additional data
+	legacySnapshotVersion = 1
+	//snapshotIterations is also the maximum accepted on decryption, bounding the work required by a snapshot given by a user
+	snapshotIterations = 200000
+	snapshotSaltSize   = 16
+	snapshotKeySize    = 32
+)
+
+//snapshotAEAD returns the cipher of the snapshots encrypted with the passphrase and the salt
+func snapshotAEAD(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, iterations, snapshotKeySize, sha256.New))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cipher failed")
+	}
+	return cipher.NewGCM(block)
+}
+
+//snapshotAdditionalData returns the header authenticated with the data (version, iterations and salt),
+//so that a modified header is detected as a corrupted snapshot
+func snapshotAdditionalData(e api.EncryptedSnapshot) []byte {
+
+	if e.Version == legacySnapshotVersion {
+		return nil
+	}
+
+	data := make([]byte, 8, 8+len(e.Salt))
+	binary.BigEndian.PutUint32(data, uint32(e.Version))
+	binary.BigEndian.PutUint32(data[4:], uint32(e.Iterations))
+	return append(data, e.Salt...)
+}
+
+//EncryptSnapshot encrypts a snapshot with the given passphrase
+func EncryptSnapshot(s api.Snapshot, passphrase string) (api.EncryptedSnapshot, error) {
+
+	if len(passphrase) < MinPassphraseLength {
+		return api.EncryptedSnapshot{}, invalidArgument("passphrase too short")
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return api.EncryptedSnapshot{}, errors.Wrap(err, "encoding snapshot failed")
+	}
+
+	e := api.EncryptedSnapshot{
+		Version:    encryptedSnapshotVersion,
+		Iterations: snapshotIterations,
+		Salt:       make([]byte, snapshotSaltSize),
+	}
+	if _, err := io.ReadFull(rand.Reader, e.Salt); err != nil {
+		return api.EncryptedSnapshot{}, errors.Wrap(err, "generating salt failed")
+	}
+
+	aead, err := snapshotAEAD(passphrase, e.Salt, e.Iterations)
+	if err != nil {
+		return api.EncryptedSnapshot{}, err
+	}
+	e.Nonce = make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, e.Nonce); err != nil {
+		return api.EncryptedSnapshot{}, errors.Wrap(err, "generating nonce failed")
+	}
+	e.Data = aead.Seal(nil, e.Nonce, data, snapshotAdditionalData(e))
+
+	return e, nil
+}
+
+//DecryptSnapshot decrypts a snapshot encrypted with the given passphrase.
+//The snapshots of the legacy version, without authenticated header, are still accepted.
+func DecryptSnapshot(e api.EncryptedSnapshot, passphrase string) (api.Snapshot, error) {
+
+	if e.Version != encryptedSnapshotVersion && e.Version != legacySnapshotVersion {
+		return api.Snapshot{}, invalidArgument("unknown encrypted snapshot version")
+	}
+	if e.Iterations <= 0 || e.Iterations > snapshotIterations {
+		return api.Snapshot{}, invalidArgument("invalid encrypted snapshot iterations")
+	}
+
+	aead, err := snapshotAEAD(passphrase, e.Salt, e.Iterations)
+	if err != nil {
+		return api.Snapshot{}, err
+	}
+	if len(e.Nonce) != aead.NonceSize() {
+		return api.Snapshot{}, invalidArgument("invalid encrypted snapshot nonce")
+	}
+
+	data, err := aead.Open(nil, e.Nonce, e.Data, snapshotAdditionalData(e))
+	if err != nil {
+		return api.Snapshot{}, invalidArgument("wrong passphrase or corrupted snapshot")
+	}
+
+	var s api.Snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return api.Snapshot{}, errors.Wrap(err, "decoding snapshot failed")
+	}
+
+	return s, nil
+}
@@ -0,0 +1,310 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//UserWebhookTimeout is the maximum duration of a call to a user webhook
+const UserWebhookTimeout = 10 * time.Second
+
+//userWebhookMaxRedirects is the number of redirections followed when calling a user webhook
+const userWebhookMaxRedirects = 3
+
+//newUserWebhookClient creates the HTTP client calling the user webhooks.
+//As the users choose the URLs, it only connects to public addresses, checked once the host is resolved,
+//so that neither a DNS record nor a redirection lets a webhook reach the internal services.
+func newUserWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return errors.Wrap(err, "invalid webhook address")
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return errors.Errorf("webhook address %s is not public", host)
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: UserWebhookTimeout,
+		//No proxy, it would be the address checked by the dialer
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: UserWebhookTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > userWebhookMaxRedirects {
+				return errors.Errorf("stopped after %d redirections", userWebhookMaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+//nonPublicNetworks are the networks a webhook must not reach: the loopback, link-local (such as the cloud metadata services),
+//private, shared (CGNAT), benchmarking, documentation, multicast and reserved ones, and the tunnels embedding an IPv4 address
+var nonPublicNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.0.2.0/24", "192.168.0.0/16", "198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24",
+	"224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b:1::/48", "100::/64", "2001::/32", "2001:db8::/32", "2002::/16",
+	"fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+//nat64Network is the well-known NAT64 prefix, followed by the translated IPv4 address
+var nat64Network = parseNetworks("64:ff9b::/96")[0]
+
+//parseNetworks parses the given CIDR notations, panicking if one is invalid
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+//isPublicIP checks that the address is not part of a non public network.
+//The IPv4-mapped and NAT64 addresses are checked as the IPv4 address they embed.
+func isPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else if len(ip) == net.IPv6len && nat64Network.Contains(ip) {
+		ip = ip[net.IPv6len-net.IPv4len:]
+	}
+
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() || ip.IsLinkLocalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//UserWebhookSignatureHeader is the HTTP header holding the signature of the payloads sent to the user webhooks
+const UserWebhookSignatureHeader = "X-Okihome-Signature"
+
+//StarredItems returns the items starred by the user, most recent first
+func (app App) StarredItems(ctx context.Context, userID string) ([]api.StarredItem, error) {
+	ctx, span := tracing.Start(ctx, "App.StarredItems")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "StarredItems")
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := app.repository.GetStarredItems(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving starred items failed")
+	}
+
+	return items, nil
+}
+
+//StarItem stars a feed item for the user, or updates its tags if already starred.
+//The webhook of the user, if any, is called in the background.
+func (app App) StarItem(ctx context.Context, userID string, feedID int64, guid string, tags []string) (api.StarredItem, error) {
+	ctx, span := tracing.Start(ctx, "App.StarItem")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, fmt.Sprintf("StarItem feed:%d item:%s", feedID, guid))
+	if err != nil {
+		return api.StarredItem{}, err
+	}
+
+	feedItems, err := app.repository.GetFeedItems(ctx, feedID)
+	if err != nil {
+		return api.StarredItem{}, errors.Wrap(err, "retrieving feed items failed")
+	}
+
+	item := api.StarredItem{
+		UserID:  userID,
+		FeedID:  feedID,
+		GUID:    guid,
+		Starred: time.Now(),
+	}
+	found := false
+	for _, fi := range feedItems {
+		if fi.GUID == guid {
+			item.Title = fi.Title
+			item.Link = fi.Link
+			found = true
+			break
+		}
+	}
+	if !found {
+		return api.StarredItem{}, invalidArgument("unknown item: " + guid)
+	}
+
+	item.Tags, err = applyTags(ctx, app.repository, userID, tags)
+	if err != nil {
+		return api.StarredItem{}, err
+	}
+
+	err = app.repository.StoreStarredItem(ctx, item)
+	if err != nil {
+		return api.StarredItem{}, errors.Wrap(err, "saving starred item failed")
+	}
+
+	webhook, err := app.repository.GetUserWebhook(ctx, userID)
+	if err == nil {
+		//The request of the user does not wait for the webhook
+		payload := api.UserWebhookPayload{Event: api.StarredEvent, Item: item}
+		app.goBackground(func() { app.callUserWebhook(context.Background(), webhook, payload) })
+	} else if !app.repository.IsNotFound(err) {
+		app.Error(ctx, errors.Wrap(err, "retrieving user webhook failed"))
+	}
+
+	return item, nil
+}
+
+//UnstarItem removes the star of a feed item
+func (app App) UnstarItem(ctx context.Context, userID string, feedID int64, guid string) error {
+	ctx, span := tracing.Start(ctx, "App.UnstarItem")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, fmt.Sprintf("UnstarItem feed:%d item:%s", feedID, guid))
+	if err != nil {
+		return err
+	}
+
+	err = app.repository.DeleteStarredItem(ctx, userID, feedID, guid)
+	if err != nil {
+		return errors.Wrap(err, "deleting starred item failed")
+	}
+
+	return nil
+}
+
+//UserWebhook returns the webhook of the user, without its secret
+func (app App) UserWebhook(ctx context.Context, userID string) (api.UserWebhook, error) {
+	ctx, span := tracing.Start(ctx, "App.UserWebhook")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "UserWebhook")
+	if err != nil {
+		return api.UserWebhook{}, err
+	}
+
+	webhook, err := app.repository.GetUserWebhook(ctx, userID)
+	if err != nil {
+		return api.UserWebhook{}, errors.Wrap(err, "retrieving user webhook failed")
+	}
+	webhook.Secret = ""
+
+	return webhook, nil
+}
+
+//SetUserWebhook sets the endpoint called each time the user stars an item
+func (app App) SetUserWebhook(ctx context.Context, userID string, webhook api.UserWebhook) (api.UserWebhook, error) {
+	ctx, span := tracing.Start(ctx, "App.SetUserWebhook")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "SetUserWebhook")
+	if err != nil {
+		return api.UserWebhook{}, err
+	}
+
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return api.UserWebhook{}, invalidArgument("invalid webhook URL: " + webhook.URL)
+	}
+
+	webhook.UserID = userID
+	err = app.repository.StoreUserWebhook(ctx, webhook)
+	if err != nil {
+		return api.UserWebhook{}, errors.Wrap(err, "saving user webhook failed")
+	}
+
+	app.audit(ctx, userID, api.AuditWebhookChanged, u.Host)
+
+	webhook.Secret = ""
+	return webhook, nil
+}
+
+//RemoveUserWebhook removes the webhook of the user
+func (app App) RemoveUserWebhook(ctx context.Context, userID string) error {
+	ctx, span := tracing.Start(ctx, "App.RemoveUserWebhook")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "RemoveUserWebhook")
+	if err != nil {
+		return err
+	}
+
+	err = app.repository.DeleteUserWebhook(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "deleting user webhook failed")
+	}
+
+	app.audit(ctx, userID, api.AuditWebhookChanged, "")
+
+	return nil
+}
+
+//callUserWebhook posts the payload to the webhook, signed with HMAC-SHA256 if the webhook has a secret.
+//The failures are only logged.
+func (app App) callUserWebhook(ctx context.Context, webhook api.UserWebhook, payload api.UserWebhookPayload) {
+
+	ctx, cancel := context.WithTimeout(ctx, UserWebhookTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		app.Error(ctx, errors.Wrap(err, "marshaling webhook payload failed"))
+		return
+	}
+
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		app.Error(ctx, errors.Wrap(err, "creating webhook request failed"))
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(webhook.Secret) > 0 {
+		req.Header.Set(UserWebhookSignatureHeader, "sha256="+api.SignUserWebhookPayload(body, webhook.Secret))
+	}
+
+	r, err := app.webhookHTTP.Do(req.WithContext(ctx))
+	if err != nil {
+		app.Error(ctx, errors.Wrap(err, "call to webhook of "+webhook.UserID+" failed"))
+		return
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		app.Errorf(ctx, "Webhook of %s returned status %d", webhook.UserID, r.StatusCode)
+	}
+}
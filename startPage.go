@@ -0,0 +1,238 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//startPageColumns is the number of columns of the tabs imported without layout
+const startPageColumns = 3
+
+//defaultStartPageTab is the title of the tab of the imported feeds that are not in a tab
+const defaultStartPageTab = "Imported"
+
+//opml is the OPML export of Netvibes
+type opml struct {
+	Outlines []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Title    string        `xml:"title,attr"`
+	Text     string        `xml:"text,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	HTMLURL  string        `xml:"htmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+func (o opmlOutline) title() string {
+	if len(o.Title) > 0 {
+		return o.Title
+	}
+	return o.Text
+}
+
+//gadgetTabML is the GadgetTabML export of iGoogle
+type gadgetTabML struct {
+	Tabs []struct {
+		Title    string `xml:"title,attr"`
+		Sections []struct {
+			Modules []struct {
+				Type  string `xml:"type,attr"`
+				Prefs struct {
+					Title    string `xml:"title,attr"`
+					XMLURL   string `xml:"xmlUrl,attr"`
+					NumItems int    `xml:"num_items,attr"`
+				} `xml:"ModulePrefs"`
+			} `xml:"Module"`
+		} `xml:"Section"`
+	} `xml:"Tab"`
+}
+
+//startPageTabs builds the tabs of the export of another start page
+type startPageTabs struct {
+	exports []api.TabExport
+	skipped []string
+	feedID  int64
+}
+
+//newTab adds an empty tab, returning its index
+func (b *startPageTabs) newTab(title string, columns int) int {
+	title = strings.TrimSpace(title)
+	if len(title) == 0 {
+		title = defaultStartPageTab
+	}
+	if columns < 1 {
+		columns = 1
+	}
+
+	b.exports = append(b.exports, api.TabExport{
+		Tab:   api.Tab{TabSummary: api.TabSummary{Title: title}, Widgets: make([][]api.Widget, columns)},
+		Feeds: []api.Feed{},
+	})
+	return len(b.exports) - 1
+}
+
+//addFeed adds a feed widget to the given column of the tab, or spreads the widgets over the columns if negative.
+//The module is skipped if the feed URL is invalid.
+func (b *startPageTabs) addFeed(tab int, column int, title string, feedURL string, link string, count int) {
+
+	u, err := url.Parse(strings.TrimSpace(feedURL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		if len(title) == 0 {
+			title = feedURL
+		}
+		b.skipped = append(b.skipped, title)
+		return
+	}
+
+	export := &b.exports[tab]
+	b.feedID++
+	export.Feeds = append(export.Feeds, api.Feed{ID: b.feedID, URL: u.String(), Title: title})
+
+	cfg := api.ConfigFeed{
+		WidgetConfig: api.WidgetConfig{Title: title, Link: link, DisplayCount: count},
+		FeedID:       b.feedID,
+		URL:          u.String(),
+	}
+	if column < 0 {
+		for _, col := range export.Tab.Widgets {
+			column += len(col)
+		}
+		column++
+	}
+	column = column % len(export.Tab.Widgets)
+	export.Tab.Widgets[column] = append(export.Tab.Widgets[column], api.NewWidgetFeed(0, cfg))
+}
+
+//parseNetvibes reads the tabs of a Netvibes export, the feeds being spread over the columns
+func parseNetvibes(data []byte) (startPageTabs, error) {
+
+	var b startPageTabs
+	var doc opml
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return b, invalidArgument("invalid Netvibes export: " + err.Error())
+	}
+
+	loose := -1
+	for _, o := range doc.Outlines {
+		if len(o.Outlines) == 0 {
+			if loose < 0 {
+				loose = b.newTab(defaultStartPageTab, startPageColumns)
+			}
+			b.addFeed(loose, -1, o.title(), o.XMLURL, o.HTMLURL, 0)
+			continue
+		}
+
+		tab := b.newTab(o.title(), startPageColumns)
+		for _, f := range o.Outlines {
+			b.addFeed(tab, -1, f.title(), f.XMLURL, f.HTMLURL, 0)
+		}
+	}
+
+	return b, nil
+}
+
+//parseIGoogle reads the tabs of an iGoogle export, the RSS modules being imported and the gadgets skipped
+func parseIGoogle(data []byte) (startPageTabs, error) {
+
+	var b startPageTabs
+	var doc gadgetTabML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return b, invalidArgument("invalid iGoogle export: " + err.Error())
+	}
+
+	for _, t := range doc.Tabs {
+		tab := b.newTab(t.Title, len(t.Sections))
+		for i, s := range t.Sections {
+			for _, m := range s.Modules {
+				if !strings.EqualFold(m.Type, "RSS") {
+					title := m.Prefs.Title
+					if len(title) == 0 {
+						title = m.Prefs.XMLURL
+					}
+					b.skipped = append(b.skipped, title)
+					continue
+				}
+				b.addFeed(tab, i, m.Prefs.Title, m.Prefs.XMLURL, "", m.Prefs.NumItems)
+			}
+		}
+	}
+
+	return b, nil
+}
+
+//startPageFormat detects the format of an export from its root element
+func startPageFormat(data []byte) string {
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			switch start.Name.Local {
+			case "opml":
+				return api.StartPageNetvibes
+			case "GadgetTabML":
+				return api.StartPageIGoogle
+			}
+			return ""
+		}
+	}
+}
+
+//ImportStartPage creates the tabs of the export of another start page for the given user, the format being
+//detected if empty. The feed modules are imported as feed widgets, the other modules are skipped.
+func (app App) ImportStartPage(ctx context.Context, userID string, format string, data []byte) (api.StartPageImport, error) {
+	ctx, span := tracing.Start(ctx, "App.ImportStartPage")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "ImportStartPage")
+	if err != nil {
+		return api.StartPageImport{}, err
+	}
+
+	if len(format) == 0 {
+		format = startPageFormat(data)
+	}
+
+	var tabs startPageTabs
+	switch format {
+	case api.StartPageNetvibes:
+		tabs, err = parseNetvibes(data)
+	case api.StartPageIGoogle:
+		tabs, err = parseIGoogle(data)
+	default:
+		return api.StartPageImport{}, invalidArgument("unknown start page format: " + format)
+	}
+	if err != nil {
+		return api.StartPageImport{}, err
+	}
+
+	result := api.StartPageImport{
+		Tabs:    []api.Tab{},
+		Skipped: tabs.skipped,
+	}
+	for _, export := range tabs.exports {
+		tab, err := app.ImportTab(ctx, userID, export, "")
+		if err != nil {
+			return result, errors.Wrap(err, "importing tab "+export.Tab.Title+" failed")
+		}
+		result.Tabs = append(result.Tabs, tab)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//maxSuggestionsPerReason is the number of widgets suggested for each reason
+const maxSuggestionsPerReason = 5
+
+//WidgetSuggestions returns widgets to add to an empty tab: the feeds most read by the user,
+//the associated accounts displayed in no widget, and the feeds most read on the instance.
+//No suggestion is returned if the tab already contains widgets.
+func (app App) WidgetSuggestions(ctx context.Context, tabID int64) ([]api.WidgetSuggestion, error) {
+	ctx, span := tracing.Start(ctx, "App.WidgetSuggestions")
+	defer span.End()
+
+	tab, err := app.Tab(ctx, tabID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving tab failed")
+	}
+
+	suggestions := []api.WidgetSuggestion{}
+	for _, col := range tab.Widgets {
+		if len(col) > 0 {
+			return suggestions, nil
+		}
+	}
+
+	userID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	suggestedFeeds := make(map[int64]bool)
+	addFeeds := func(feedIDs []int64, reason api.SuggestionReason) error {
+		for _, feedID := range feedIDs {
+			if suggestedFeeds[feedID] {
+				continue
+			}
+			feed, err := app.repository.GetFeed(ctx, feedID)
+			if err != nil {
+				return errors.Wrap(err, "retrieving feed from datastore failed")
+			}
+			if app.checkManagedAccess(ctx, userID, api.ApprovalFeed, feed.URL) != nil {
+				continue
+			}
+			suggestedFeeds[feedID] = true
+
+			cfg := api.ConfigFeed{
+				FeedID: feed.ID,
+				URL:    feed.URL,
+			}
+			cfg.Title = feed.Title
+			suggestions = append(suggestions, api.WidgetSuggestion{
+				Reason: reason,
+				Widget: api.NewWidgetFeed(0, cfg),
+			})
+		}
+		return nil
+	}
+
+	//Feeds often read by the user
+	feedIDs, err := app.repository.GetMostReadFeedIDs(ctx, userID, maxSuggestionsPerReason)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving most read feeds from datastore failed")
+	}
+	if err := addFeeds(feedIDs, api.SuggestionMostRead); err != nil {
+		return nil, err
+	}
+
+	//Accounts without widget
+	accounts, err := app.repository.GetAccounts(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving accounts from datastore failed")
+	}
+	unused := 0
+	for _, account := range accounts {
+		if unused == maxSuggestionsPerReason {
+			break
+		}
+		provider, ok := app.providers[account.ProviderName]
+		if !ok {
+			continue
+		}
+		usage, err := app.accountUsage(ctx, userID, account.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(usage) > 0 {
+			continue
+		}
+		unused++
+
+		cfg := api.ConfigEmail{
+			AccountID: account.ID,
+		}
+		cfg.Title = app.translate(ctx, provider.Description().Title) + " - " + account.DisplayName()
+		cfg.Link = provider.Description().Link
+		suggestions = append(suggestions, api.WidgetSuggestion{
+			Reason: api.SuggestionUnusedAccount,
+			Widget: api.NewWidgetEmail(0, cfg),
+		})
+	}
+
+	//Feeds popular on the instance
+	feedIDs, err = app.repository.GetMostReadFeedIDs(ctx, "", maxSuggestionsPerReason)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving popular feeds from datastore failed")
+	}
+	if err := addFeeds(feedIDs, api.SuggestionPopular); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package extractive
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//minSentenceLength is the length under which a sentence is considered as noise (captions, bylines...)
+const minSentenceLength = 40
+
+//maxAbstractLength is the maximum length of an abstract, in runes
+const maxAbstractLength = 320
+
+type summarizer struct{}
+
+//New creates a Summarizer keeping the leading sentences of the article.
+//It runs locally and is used as fallback when no other summarizer is available.
+func New() api.Summarizer {
+	return summarizer{}
+}
+
+func (s summarizer) Summarize(ctx context.Context, title string, text string) (string, error) {
+
+	var abstract []string
+	for _, sentence := range sentences(text) {
+		if len([]rune(sentence)) < minSentenceLength || sentence == title {
+			continue
+		}
+		abstract = append(abstract, sentence)
+		if len(abstract) == api.AbstractSentences {
+			break
+		}
+	}
+
+	if len(abstract) == 0 {
+		return "", errors.New("No sentence found in text")
+	}
+
+	res := []rune(strings.Join(abstract, " "))
+	if len(res) > maxAbstractLength {
+		return strings.TrimSpace(string(res[:maxAbstractLength-1])) + "…", nil
+	}
+
+	return string(res), nil
+}
+
+//sentences splits the text on the sentence terminators followed by a space
+func sentences(text string) []string {
+
+	var res []string
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+
+	start := 0
+	for i, r := range runes {
+		if r != '.' && r != '!' && r != '?' {
+			continue
+		}
+		if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			continue
+		}
+		res = append(res, strings.TrimSpace(string(runes[start:i+1])))
+		start = i + 1
+	}
+	if last := strings.TrimSpace(string(runes[start:])); len(last) > 0 {
+		res = append(res, last)
+	}
+
+	return res
+}
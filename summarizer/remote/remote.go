@@ -0,0 +1,105 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+//maxTextLength is the maximum length of the text sent to the summarization service, in runes
+const maxTextLength = 20000
+
+//Config is the configuration of a summarization web service.
+//
+//The service receives a POST request with a JSON body {"title": "...", "text": "...", "sentences": 2}
+//and answers with a JSON body {"summary": "..."}.
+type Config struct {
+	URL string
+	//APIKey is sent as a bearer token, if not empty
+	APIKey string
+}
+
+type summarizer struct {
+	url    string
+	apiKey string
+}
+
+type summarizeRequest struct {
+	Title     string `json:"title"`
+	Text      string `json:"text"`
+	Sentences int    `json:"sentences"`
+}
+
+type summarizeResponse struct {
+	Summary string `json:"summary"`
+}
+
+//New creates a Summarizer delegating to a summarization web service
+func New(cfg Config) (api.Summarizer, error) {
+
+	if len(cfg.URL) == 0 {
+		return nil, errors.New("Summarization service URL is missing")
+	}
+
+	return summarizer{
+		url:    cfg.URL,
+		apiKey: cfg.APIKey,
+	}, nil
+}
+
+func (s summarizer) Summarize(ctx context.Context, title string, text string) (string, error) {
+
+	if runes := []rune(text); len(runes) > maxTextLength {
+		text = string(runes[:maxTextLength])
+	}
+
+	body, err := json.Marshal(summarizeRequest{
+		Title:     title,
+		Text:      text,
+		Sentences: api.AbstractSentences,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "encoding request failed")
+	}
+
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrap(err, "creating request failed")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(s.apiKey) > 0 {
+		req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	}
+
+	r, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", errors.Wrap(err, "call to summarization service failed")
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return "", errors.Errorf("summarization service returned %s", r.Status)
+	}
+
+	var res summarizeResponse
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		return "", errors.Wrap(err, "decoding response failed")
+	}
+
+	summary := strings.TrimSpace(res.Summary)
+	if len(summary) == 0 {
+		return "", errors.New("Empty summary received")
+	}
+
+	return summary, nil
+}
@@ -0,0 +1,245 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/summarizer/extractive"
+)
+
+//maxArticleSize is the maximum size of a downloaded article
+const maxArticleSize = 2 << 20
+
+//defaultSummarizedItems is the number of items summarized when the widget has no display count
+const defaultSummarizedItems = 5
+
+//SummaryTimeout bounds the download and the summarization of an article
+const SummaryTimeout = 30 * time.Second
+
+//SummaryRetryDelay is how long an item whose summarization failed is not summarized again
+const SummaryRetryDelay = time.Hour
+
+//maxSummarizing is the number of articles summarized at the same time
+const maxSummarizing = 4
+
+//summaryFailuresSize is the number of failed items remembered
+const summaryFailuresSize = 1024
+
+type summaryKey struct {
+	feedID int64
+	guid   string
+}
+
+//summaryQueue tracks the items being summarized in background and the ones whose summarization recently failed,
+//so that an article is neither downloaded by several requests at once nor again and again when it cannot be summarized
+type summaryQueue struct {
+	slots chan struct{}
+
+	mu      sync.Mutex
+	pending map[summaryKey]bool
+	failed  map[summaryKey]time.Time
+}
+
+func newSummaryQueue() *summaryQueue {
+	return &summaryQueue{
+		slots:   make(chan struct{}, maxSummarizing),
+		pending: make(map[summaryKey]bool),
+		failed:  make(map[summaryKey]time.Time),
+	}
+}
+
+//start returns true if the item must be summarized, marking it as pending
+func (q *summaryQueue) start(key summaryKey) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.pending[key] {
+		return false
+	}
+	if retry, ok := q.failed[key]; ok {
+		if time.Now().Before(retry) {
+			return false
+		}
+		delete(q.failed, key)
+	}
+	q.pending[key] = true
+	return true
+}
+
+//done ends the summarization of the item, remembering it if it failed
+func (q *summaryQueue) done(key summaryKey, failed bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.pending, key)
+	if !failed {
+		return
+	}
+
+	now := time.Now()
+	if len(q.failed) >= summaryFailuresSize {
+		for k, retry := range q.failed {
+			if now.After(retry) {
+				delete(q.failed, k)
+			}
+		}
+	}
+	//Still full of recent failures, an arbitrary one is forgotten
+	for k := range q.failed {
+		if len(q.failed) < summaryFailuresSize {
+			break
+		}
+		delete(q.failed, k)
+	}
+	q.failed[key] = now.Add(SummaryRetryDelay)
+}
+
+//addAbstracts sets the abstract of the items displayed by the given widget, if it has summaries enabled.
+//The stored abstracts are looked up at once, the missing ones of the displayed items being generated in background
+//for the next display, so the request does not wait for the articles to be downloaded.
+//A failure on an item does not prevent the summarization of the others.
+func (app App) addAbstracts(ctx context.Context, feedID int64, widget api.Widget, items []api.ItemForUser) error {
+
+	cfg, ok := widget.Config.(api.ConfigFeed)
+	if !ok || !cfg.Summarize || len(items) == 0 {
+		return nil
+	}
+
+	guids := make([]string, len(items))
+	for i, item := range items {
+		guids[i] = item.GUID
+	}
+	abstracts, err := app.repository.GetItemAbstracts(ctx, feedID, guids)
+	if err != nil {
+		return errors.Wrap(err, "retrieving abstracts from datastore failed")
+	}
+
+	displayed := cfg.DisplayCount
+	if displayed <= 0 {
+		displayed = defaultSummarizedItems
+	}
+
+	for i := range items {
+		if i < len(abstracts) && len(abstracts[i]) > 0 {
+			items[i].Abstract = abstracts[i]
+			continue
+		}
+		if i >= displayed {
+			continue
+		}
+
+		key := summaryKey{feedID: feedID, guid: items[i].GUID}
+		if !app.summaries.start(key) {
+			continue
+		}
+		item := items[i].FeedItem
+		app.goBackground(func() { app.storeAbstract(context.Background(), key, item) })
+	}
+
+	return nil
+}
+
+//storeAbstract summarizes the given item and stores its abstract
+func (app App) storeAbstract(ctx context.Context, key summaryKey, item api.FeedItem) {
+
+	app.summaries.slots <- struct{}{}
+	defer func() { <-app.summaries.slots }()
+
+	ctx, cancel := context.WithTimeout(ctx, SummaryTimeout)
+	defer cancel()
+
+	abstract, err := app.summarize(ctx, item)
+	if err != nil {
+		app.Error(ctx, errors.Wrap(err, "summarizing "+item.Link+" failed"))
+		app.summaries.done(key, true)
+		return
+	}
+
+	err = app.repository.StoreItemAbstract(ctx, key.feedID, key.guid, abstract)
+	if err != nil {
+		app.Error(ctx, errors.Wrap(err, "saving abstract in datastore failed"))
+	}
+	app.summaries.done(key, false)
+}
+
+//summarize generates the abstract of the article linked by the given item.
+//The configured summarizer is used if any, falling back to a local extraction.
+func (app App) summarize(ctx context.Context, item api.FeedItem) (string, error) {
+
+	text, err := app.fetchArticleText(ctx, item.Link)
+	if err != nil {
+		return "", errors.Wrap(err, "fetching article failed")
+	}
+
+	if app.summarizer != nil {
+		abstract, err := app.summarizer.Summarize(ctx, item.Title, text)
+		if err == nil {
+			return abstract, nil
+		}
+		app.Error(ctx, errors.Wrap(err, "summarization service failed, using local extraction"))
+	}
+
+	return extractive.New().Summarize(ctx, item.Title, text)
+}
+
+//fetchArticleText downloads the given page with the feed client and returns the text of its paragraphs
+func (app App) fetchArticleText(ctx context.Context, pageURL string) (string, error) {
+
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "creating request failed")
+	}
+
+	r, err := app.feedClient(pageURL).Do(req.WithContext(ctx))
+	if err != nil {
+		return "", errors.Wrap(err, "call to website failed")
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return "", errors.Errorf("website returned %s", r.Status)
+	}
+
+	var paragraphs []string
+	var current []string
+	depth := 0
+
+	z := html.NewTokenizer(io.LimitReader(r.Body, maxArticleSize))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return "", errors.Wrap(z.Err(), "parsing article failed")
+			}
+			return strings.Join(paragraphs, " "), nil
+		case html.StartTagToken:
+			if name, _ := z.TagName(); string(name) == "p" {
+				depth++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "p" && depth > 0 {
+				depth--
+				if depth == 0 && len(current) > 0 {
+					paragraphs = append(paragraphs, strings.Join(current, ""))
+					current = nil
+				}
+			}
+		case html.TextToken:
+			if depth > 0 {
+				current = append(current, string(z.Text()))
+			}
+		}
+	}
+}
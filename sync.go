@@ -0,0 +1,195 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//EmailCacheMaxAge is the age after which a synchronized inbox is considered stale,
+//and emails are retrieved from the provider again.
+const EmailCacheMaxAge = 15 * time.Minute
+
+//RunEmailSync prefetches the inbox of all the associated accounts at the given interval, until the context is done.
+//The interval should be lower than EmailCacheMaxAge for the cache to be used.
+func (app App) RunEmailSync(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := app.SyncEmails(ctx); err != nil {
+			app.Error(ctx, errors.Wrap(err, "email synchronization failed"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//SetSyncIdleAfter pauses the background synchronization of the accounts whose widgets were not viewed
+//since the given duration, the synchronization resuming once one of them is viewed again.
+//By default, all the accounts are synchronized. The feeds are paused by SetFeedRefreshIdleAfter.
+func (app *App) SetSyncIdleAfter(idleAfter time.Duration) {
+	app.syncIdleAfter = idleAfter
+}
+
+//SyncEmails prefetches and caches the inbox of all the associated accounts, except the idle ones.
+//A failure on an account does not prevent the synchronization of the others.
+func (app App) SyncEmails(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "App.SyncEmails")
+	defer span.End()
+
+	active, err := app.activeAccounts(ctx)
+	if err != nil {
+		return errors.Wrap(err, "retrieving active accounts failed")
+	}
+	paused := 0
+
+	page := api.PageRequest{}
+	for {
+		accounts, next, err := app.repository.GetAccountsPage(ctx, "", page)
+		if err != nil {
+			return errors.Wrap(err, "retrieving accounts from datastore failed")
+		}
+
+		for _, account := range accounts {
+			if active != nil && !active[account.ID] {
+				paused++
+				continue
+			}
+			if err := app.syncAccount(ctx, account); err != nil {
+				app.Error(ctx, errors.Wrap(err, "synchronizing account "+account.Key()+" failed"))
+			}
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		page.Cursor = next
+	}
+
+	if paused > 0 {
+		app.Infof(ctx, "Synchronization paused for %d idle accounts", paused)
+	}
+
+	return nil
+}
+
+//activeAccounts returns the IDs of the accounts with a widget viewed during the idle duration,
+//or nil if all the accounts are synchronized
+func (app App) activeAccounts(ctx context.Context) (map[int64]bool, error) {
+
+	if app.syncIdleAfter <= 0 {
+		return nil, nil
+	}
+
+	_, accounts, err := app.viewedSources(ctx, app.syncIdleAfter)
+	return accounts, err
+}
+
+func (app App) syncAccount(ctx context.Context, account api.ExternalAccount) error {
+
+	//The token was revoked, wait for the user to authorize the account again
+	if account.NeedsReauth {
+		return nil
+	}
+
+	//Get the provider
+	emailProvider, err := app.getEmailProvider(account.ProviderName)
+	if err != nil {
+		return errors.Wrap(err, "Email provider not found")
+	}
+
+	page, err := emailProvider.GetItems(ctx, account, api.EmailQuery{}, nil)
+	if err != nil {
+		return errors.Wrap(app.checkReauth(ctx, account, err), "retrieving emails failed")
+	}
+
+	sync := api.EmailSync{
+		AccountID:          account.ID,
+		Synced:             time.Now(),
+		GUIDs:              make([]string, 0, len(page.Items)),
+		NextPageToken:      page.NextPageToken,
+		ResultSizeEstimate: page.ResultSizeEstimate,
+	}
+
+	previous, err := app.repository.GetEmailSync(ctx, account)
+	if err != nil && !app.repository.IsNotFound(err) {
+		return errors.Wrap(err, "retrieving email sync from datastore failed")
+	}
+	changed := err != nil || len(previous.GUIDs) != len(page.Items)
+
+	for i, item := range page.Items {
+		if !changed && previous.GUIDs[i] != item.GUID {
+			changed = true
+		}
+		err = app.repository.StoreEmailItem(ctx, account, item.Version, item)
+		if err != nil {
+			return errors.Wrap(err, "saving email item failed")
+		}
+		sync.GUIDs = append(sync.GUIDs, item.GUID)
+	}
+
+	err = app.repository.StoreEmailSync(ctx, sync)
+	if err != nil {
+		return errors.Wrap(err, "saving email sync failed")
+	}
+
+	if changed {
+		app.events.publish("", account.Key(), api.Event{Type: api.EventEmails})
+	}
+
+	return nil
+}
+
+//cachedEmails returns the inbox prefetched by the sync worker.
+//It returns false if the inbox was not synchronized recently or if some items were invalidated since.
+func (app App) cachedEmails(ctx context.Context, account api.ExternalAccount) (*api.EmailPage, bool, error) {
+
+	sync, err := app.repository.GetEmailSync(ctx, account)
+	if err != nil {
+		if app.repository.IsNotFound(err) {
+			return nil, false, nil
+		}
+		return nil, false, errors.Wrap(err, "retrieving email sync from datastore failed")
+	}
+
+	if time.Since(sync.Synced) > EmailCacheMaxAge {
+		return nil, false, nil
+	}
+
+	page := api.EmailPage{
+		Items:              make([]api.EmailItem, 0, len(sync.GUIDs)),
+		NextPageToken:      sync.NextPageToken,
+		ResultSizeEstimate: sync.ResultSizeEstimate,
+	}
+
+	for _, guid := range sync.GUIDs {
+		//Invalidated items have a null version
+		item, err := app.repository.GetEmailItem(ctx, account, guid, 1)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "retrieving email item from datastore failed")
+		}
+		if item.GUID == "" {
+			return nil, false, nil
+		}
+		if provider, ok := app.providers[account.ProviderName]; ok {
+			item.Links = provider.Description().EmailLinks(item.GUID)
+		}
+		page.Items = append(page.Items, item)
+	}
+
+	return &page, true, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//TabContentConcurrency is the number of widgets of a tab whose content is retrieved at the same time
+const TabContentConcurrency = 8
+
+//FeedContentTimeout bounds the retrieval of the content of a feed widget, so that a slow host does not delay the whole tab
+const FeedContentTimeout = 10 * time.Second
+
+//EmailContentTimeout bounds the retrieval of the content of an email widget
+const EmailContentTimeout = 20 * time.Second
+
+//TabContent is a tab with the first page of the content of each of its widgets
+type TabContent struct {
+	Tab api.Tab `json:"tab"`
+	//Widgets are in the order of the layout, column by column
+	Widgets []WidgetContent `json:"widgets"`
+}
+
+//WidgetContent is the first page of the content of a widget, or the error preventing its retrieval
+type WidgetContent struct {
+	WidgetID int64 `json:"widget_id"`
+	//Content is a list of api.ItemForUser for the feed widgets, an api.FeedDigest for the feed widgets in digest mode,
+	//an api.EmailPage for the email widgets and an api.TagCollection for the collection widgets
+	Content interface{} `json:"content,omitempty"`
+	//Error is a generic message, in the language of the user, if the content was not retrieved
+	Error string `json:"error,omitempty"`
+	//ReauthAccountID is the account of an email widget to authorize again, if its token was rejected
+	ReauthAccountID int64 `json:"reauth_account_id,omitempty"`
+}
+
+//TabContent returns the tab and the content of its widgets for the current user, retrieved concurrently.
+//The failure of a widget does not prevent the others from being returned, its error being reported in its content.
+func (app App) TabContent(ctx context.Context, tabID int64) (TabContent, error) {
+	ctx, span := tracing.Start(ctx, "App.TabContent")
+	defer span.End()
+
+	tab, err := app.Tab(ctx, tabID)
+	if err != nil {
+		return TabContent{}, err
+	}
+
+	userID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return TabContent{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	var widgets []api.Widget
+	for _, col := range tab.Widgets {
+		widgets = append(widgets, col...)
+	}
+
+	//The time zone of the user is looked up once for all the widgets
+	loc := app.userLocation(ctx, userID)
+
+	res := TabContent{Tab: tab, Widgets: make([]WidgetContent, len(widgets))}
+	slots := make(chan struct{}, TabContentConcurrency)
+	var wg sync.WaitGroup
+	for i, widget := range widgets {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, widget api.Widget) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			content := WidgetContent{WidgetID: widget.ID}
+			data, err := app.widgetContent(ctx, userID, tabID, widget, loc)
+			if err == nil {
+				content.Content = data
+			} else {
+				//The details may hold internal addresses or queries, so they are only logged
+				app.Error(ctx, errors.Wrap(err, fmt.Sprintf("retrieving content of widget %d failed", widget.ID)))
+				content.Error = app.translate(ctx, "content not retrieved")
+				if reauth, ok := errors.Cause(err).(reauthRequired); ok {
+					content.Error = app.translate(ctx, "account to authorize again")
+					content.ReauthAccountID = reauth.ReauthAccountID()
+				}
+			}
+			res.Widgets[i] = content
+		}(i, widget)
+	}
+	wg.Wait()
+
+	return res, nil
+}
+
+//widgetContent returns the first page of the content of the widget, bounded by the timeout of its source,
+//its dates being given in loc
+func (app App) widgetContent(ctx context.Context, userID string, tabID int64, widget api.Widget, loc *time.Location) (interface{}, error) {
+
+	timeout := FeedContentTimeout
+	if _, ok := widget.Config.(api.ConfigEmail); ok {
+		timeout = EmailContentTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var content interface{}
+	var err error
+	switch cfg := widget.Config.(type) {
+	case api.ConfigFeed:
+		if cfg.Mode == api.FeedModeDigest {
+			content, err = app.feedDigest(ctx, userID, cfg.FeedID, tabID, widget.ID, loc)
+		} else {
+			content, err = app.feedItems(ctx, userID, cfg.FeedID, tabID, widget.ID, api.PageRequest{}, loc)
+		}
+	case api.ConfigEmail:
+		content, err = app.getEmails(ctx, userID, cfg.AccountID, cfg.Categories, cfg.Query, tabID, widget.ID, loc)
+	case api.ConfigCollection:
+		content, err = app.TagCollection(ctx, userID, cfg.Tag)
+	default:
+		return nil, errors.New("unsupported widget type " + widget.Type)
+	}
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return nil, errors.Wrap(err, fmt.Sprintf("content not retrieved within %s", timeout))
+	}
+
+	return content, err
+}
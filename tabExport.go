@@ -0,0 +1,211 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//ExportTab returns a tab readable by the current user with the feeds of its widgets.
+//The email widgets of accounts of other users are not exported.
+func (app App) ExportTab(ctx context.Context, tabID int64) (api.TabExport, error) {
+	ctx, span := tracing.Start(ctx, "App.ExportTab")
+	defer span.End()
+
+	tab, err := app.Tab(ctx, tabID)
+	if err != nil {
+		return api.TabExport{}, err
+	}
+
+	userID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.TabExport{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	export := api.TabExport{
+		Feeds:    []api.Feed{},
+		Accounts: make(map[int64]string),
+	}
+	feeds := make(map[int64]bool)
+
+	widgets := make([][]api.Widget, 0, len(tab.Widgets))
+	for _, col := range tab.Widgets {
+		exported := []api.Widget{}
+		for _, w := range col {
+			switch cfg := w.Config.(type) {
+			case api.ConfigFeed:
+				if !feeds[cfg.FeedID] {
+					feed, err := app.repository.GetFeed(ctx, cfg.FeedID)
+					if err != nil {
+						return api.TabExport{}, errors.Wrap(err, "retrieving feed from datastore failed")
+					}
+					export.Feeds = append(export.Feeds, feed)
+					feeds[cfg.FeedID] = true
+				}
+
+			case api.ConfigEmail:
+				account, err := app.repository.GetAccount(ctx, userID, cfg.AccountID)
+				if app.repository.IsNotFound(err) {
+					continue
+				}
+				if err != nil {
+					return api.TabExport{}, errors.Wrap(err, "retrieving account from datastore failed")
+				}
+				export.Accounts[account.ID] = account.Key()
+			}
+			exported = append(exported, w)
+		}
+		widgets = append(widgets, exported)
+	}
+	tab.Widgets = widgets
+	export.Tab = tab
+
+	app.audit(ctx, userID, api.AuditTabShared, fmt.Sprintf("tab:%d", tabID))
+
+	return export, nil
+}
+
+//ImportTab creates a tab owned by the given user from an exported tab, with the given title or the exported one if empty.
+//The feeds are added if unknown, and the email widgets display the account of the user with the same key.
+//The email widgets of accounts the user did not link are not imported.
+//For a managed user, no tab is created if one of the feeds is not allowed.
+func (app App) ImportTab(ctx context.Context, userID string, export api.TabExport, title string) (api.Tab, error) {
+	ctx, span := tracing.Start(ctx, "App.ImportTab")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "ImportTab")
+	if err != nil {
+		return api.Tab{}, err
+	}
+
+	if err := export.Validate(); err != nil {
+		return api.Tab{}, invalidArgument("invalid tab export: " + err.Error())
+	}
+
+	//Check managed policy for the displayed feeds, before creating anything
+	urls := make(map[int64]string)
+	for _, f := range export.Feeds {
+		urls[f.ID] = f.URL
+	}
+	for _, col := range export.Tab.Widgets {
+		for _, w := range col {
+			if cfg, ok := w.Config.(api.ConfigFeed); ok {
+				err = app.checkManagedAccess(ctx, userID, api.ApprovalFeed, urls[cfg.FeedID])
+				if err != nil {
+					return api.Tab{}, errors.Wrap(err, "feed not allowed")
+				}
+			}
+		}
+	}
+
+	title = strings.TrimSpace(title)
+	if len(title) == 0 {
+		title = export.Tab.Title
+	}
+
+	var tab api.Tab
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+
+		accounts, err := repo.GetAccounts(ctx, userID)
+		if err != nil {
+			return errors.Wrap(err, "retrieving accounts from datastore failed")
+		}
+		existingAccounts := make(map[string]int64)
+		for _, a := range accounts {
+			existingAccounts[a.Key()] = a.ID
+		}
+
+		allFeeds := make(map[int64]int64)
+		for _, f := range export.Feeds {
+			id, err := repo.GetOrCreateFeedID(ctx, f.URL)
+			if err != nil {
+				return errors.Wrap(err, "retrieving feed id from datastore failed")
+			}
+			allFeeds[f.ID] = id
+		}
+
+		tab, err = createTab(ctx, repo, userID, title)
+		if err != nil {
+			return err
+		}
+
+		tab.Widgets = make([][]api.Widget, 0, len(export.Tab.Widgets))
+		for _, col := range export.Tab.Widgets {
+			imported := []api.Widget{}
+			for _, w := range col {
+
+				//Map account id/feed id in widget configs
+				switch cfg := w.Config.(type) {
+				case api.ConfigFeed:
+					cfg.FeedID = allFeeds[cfg.FeedID]
+					w.Config = cfg
+
+				case api.ConfigEmail:
+					id, ok := existingAccounts[export.Accounts[cfg.AccountID]]
+					if !ok {
+						continue
+					}
+					cfg.AccountID = id
+					w.Config = cfg
+				}
+
+				//The tags are the ones of the user importing the tab
+				if common, ok := widgetConfig(w); ok {
+					common.Tags, err = applyTags(ctx, repo, userID, common.Tags)
+					if err != nil {
+						return err
+					}
+					setWidgetConfig(&w, common)
+				}
+				if cfg, ok := w.Config.(api.ConfigCollection); ok {
+					if _, err := applyTags(ctx, repo, userID, []string{cfg.Tag}); err != nil {
+						return err
+					}
+				}
+
+				w.ID = 0
+				err = repo.StoreWidget(ctx, tab.ID, &w)
+				if err != nil {
+					return errors.Wrap(err, "saving widget in datastore failed")
+				}
+				imported = append(imported, w)
+			}
+			tab.Widgets = append(tab.Widgets, imported)
+		}
+
+		err = repo.StoreTab(ctx, &tab)
+		if err != nil {
+			return errors.Wrap(err, "saving tab in datastore failed")
+		}
+
+		return nil
+	})
+	if err != nil {
+		return api.Tab{}, err
+	}
+
+	now := time.Now()
+	for _, col := range tab.Widgets {
+		for _, w := range col {
+			err = app.startWidgetTracking(ctx, userID, tab.ID, w.ID, now)
+			if err != nil {
+				app.Error(ctx, errors.Wrap(err, "recording widget view failed"))
+			}
+		}
+	}
+
+	app.audit(ctx, userID, api.AuditTabCreated, fmt.Sprintf("tab:%d", tab.ID))
+	app.publishLayout(userID, tab.ID)
+
+	return tab, nil
+}
@@ -0,0 +1,420 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//MaxTagLength is the maximum length of the name of a tag
+const MaxTagLength = 64
+
+//tagName returns the normalized name of a tag, or an error if it cannot be used
+func tagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if len(name) == 0 {
+		return "", invalidArgument("empty tag name")
+	}
+	if len(name) > MaxTagLength {
+		return "", invalidArgument("tag name too long: " + name)
+	}
+	if strings.Contains(name, "/") {
+		return "", invalidArgument("invalid tag name: " + name)
+	}
+	return name, nil
+}
+
+//applyTags normalizes the names of the tags applied on an item or a widget.
+//The unknown tags are created for the user.
+func applyTags(ctx context.Context, repo api.Repository, userID string, names []string) ([]string, error) {
+
+	tags, err := repo.GetTags(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving tags failed")
+	}
+	known := make(map[string]bool)
+	for _, t := range tags {
+		known[t.Name] = true
+	}
+
+	applied := []string{}
+	seen := make(map[string]bool)
+	for _, n := range names {
+		name, err := tagName(n)
+		if err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		applied = append(applied, name)
+
+		if !known[name] {
+			err = repo.StoreTag(ctx, api.Tag{UserID: userID, Name: name})
+			if err != nil {
+				return nil, errors.Wrap(err, "creating tag failed")
+			}
+		}
+	}
+
+	return applied, nil
+}
+
+//widgetConfig returns the common configuration of a widget
+func widgetConfig(widget api.Widget) (api.WidgetConfig, bool) {
+	switch cfg := widget.Config.(type) {
+	case api.ConfigFeed:
+		return cfg.WidgetConfig, true
+	case api.ConfigEmail:
+		return cfg.WidgetConfig, true
+	case api.ConfigCollection:
+		return cfg.WidgetConfig, true
+	}
+	return api.WidgetConfig{}, false
+}
+
+//setWidgetConfig replaces the common configuration of a widget
+func setWidgetConfig(widget *api.Widget, common api.WidgetConfig) {
+	switch cfg := widget.Config.(type) {
+	case api.ConfigFeed:
+		cfg.WidgetConfig = common
+		widget.Config = cfg
+	case api.ConfigEmail:
+		cfg.WidgetConfig = common
+		widget.Config = cfg
+	case api.ConfigCollection:
+		cfg.WidgetConfig = common
+		widget.Config = cfg
+	}
+}
+
+//hasTag returns true if the tag is in the list
+func hasTag(tags []string, name string) bool {
+	for _, t := range tags {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+//replaceTag replaces a tag in a list, removing it if newName is empty.
+//It returns false if the tag is not in the list.
+func replaceTag(tags []string, name string, newName string) ([]string, bool) {
+	found := false
+	replaced := []string{}
+	for _, t := range tags {
+		if t == name {
+			found = true
+			t = newName
+		}
+		if len(t) == 0 {
+			continue
+		}
+		if !hasTag(replaced, t) {
+			replaced = append(replaced, t)
+		}
+	}
+	return replaced, found
+}
+
+//Tags returns the tags of the user, ordered by name
+func (app App) Tags(ctx context.Context, userID string) ([]api.Tag, error) {
+	ctx, span := tracing.Start(ctx, "App.Tags")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "Tags")
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := app.repository.GetTags(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving tags failed")
+	}
+
+	return tags, nil
+}
+
+//SetTag creates a tag, or updates its color if the user already has it
+func (app App) SetTag(ctx context.Context, userID string, tag api.Tag) (api.Tag, error) {
+	ctx, span := tracing.Start(ctx, "App.SetTag")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "SetTag tag:"+tag.Name)
+	if err != nil {
+		return api.Tag{}, err
+	}
+
+	tag.Name, err = tagName(tag.Name)
+	if err != nil {
+		return api.Tag{}, err
+	}
+	tag.UserID = userID
+
+	err = app.repository.StoreTag(ctx, tag)
+	if err != nil {
+		return api.Tag{}, errors.Wrap(err, "saving tag failed")
+	}
+
+	return tag, nil
+}
+
+//UpdateTag renames a tag and updates its color. The starred items and the widgets with the tag are updated,
+//the tag is merged with the tag of the new name if the user already has it.
+func (app App) UpdateTag(ctx context.Context, userID string, name string, tag api.Tag) (api.Tag, error) {
+	ctx, span := tracing.Start(ctx, "App.UpdateTag")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "UpdateTag tag:"+name)
+	if err != nil {
+		return api.Tag{}, err
+	}
+
+	if len(strings.TrimSpace(tag.Name)) == 0 {
+		tag.Name = name
+	}
+	tag.Name, err = tagName(tag.Name)
+	if err != nil {
+		return api.Tag{}, err
+	}
+	tag.UserID = userID
+
+	var tabIDs []int64
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+
+		if _, err := findTag(ctx, repo, userID, name); err != nil {
+			return err
+		}
+
+		err := repo.StoreTag(ctx, tag)
+		if err != nil {
+			return errors.Wrap(err, "saving tag failed")
+		}
+		if tag.Name == name {
+			return nil
+		}
+
+		tabIDs, err = retag(ctx, repo, userID, name, tag.Name)
+		if err != nil {
+			return err
+		}
+
+		return repo.DeleteTag(ctx, userID, name)
+	})
+	if err != nil {
+		return api.Tag{}, errors.Wrap(err, "updating tag failed")
+	}
+
+	for _, tabID := range tabIDs {
+		app.publishLayout(userID, tabID)
+	}
+
+	return tag, nil
+}
+
+//DeleteTag removes a tag from the starred items and the widgets of the user, and deletes it.
+//The collection widgets of the tag are kept, and display no items.
+func (app App) DeleteTag(ctx context.Context, userID string, name string) error {
+	ctx, span := tracing.Start(ctx, "App.DeleteTag")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "DeleteTag tag:"+name)
+	if err != nil {
+		return err
+	}
+
+	var tabIDs []int64
+	err = app.repository.RunInTransaction(ctx, func(repo api.Repository) error {
+
+		if _, err := findTag(ctx, repo, userID, name); err != nil {
+			return err
+		}
+
+		tabIDs, err = retag(ctx, repo, userID, name, "")
+		if err != nil {
+			return err
+		}
+
+		return repo.DeleteTag(ctx, userID, name)
+	})
+	if err != nil {
+		return errors.Wrap(err, "deleting tag failed")
+	}
+
+	for _, tabID := range tabIDs {
+		app.publishLayout(userID, tabID)
+	}
+
+	return nil
+}
+
+//TagCollection returns the starred items and the widgets of the user with the given tag.
+//The collection of an unknown tag is empty.
+func (app App) TagCollection(ctx context.Context, userID string, name string) (api.TagCollection, error) {
+	ctx, span := tracing.Start(ctx, "App.TagCollection")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "TagCollection tag:"+name)
+	if err != nil {
+		return api.TagCollection{}, err
+	}
+
+	collection := api.TagCollection{
+		Tag:     api.Tag{UserID: userID, Name: name},
+		Items:   []api.StarredItem{},
+		Widgets: []api.TaggedWidget{},
+	}
+
+	tag, err := findTag(ctx, app.repository, userID, name)
+	if err == nil {
+		collection.Tag = tag
+	} else if _, ok := errors.Cause(err).(tagNotFound); !ok {
+		return api.TagCollection{}, err
+	}
+
+	items, err := app.repository.GetStarredItems(ctx, userID)
+	if err != nil {
+		return api.TagCollection{}, errors.Wrap(err, "retrieving starred items failed")
+	}
+	for _, item := range items {
+		if hasTag(item.Tags, name) {
+			collection.Items = append(collection.Items, item)
+		}
+	}
+
+	//The tags of the widgets of the tabs shared with the user are the ones of the other users
+	tabIDs, err := app.repository.GetOwnedTabIDs(ctx, userID)
+	if err != nil {
+		return api.TagCollection{}, errors.Wrap(err, "retrieving tab ids from datastore failed")
+	}
+	for _, tabID := range tabIDs {
+		tab, err := app.repository.GetTab(ctx, tabID)
+		if err != nil {
+			return api.TagCollection{}, errors.Wrap(err, "retrieving tab from datastore failed")
+		}
+
+		for _, col := range tab.Widgets {
+			for _, w := range col {
+				cfg, ok := widgetConfig(w)
+				if !ok {
+					continue
+				}
+				if hasTag(cfg.Tags, name) {
+					collection.Widgets = append(collection.Widgets, api.TaggedWidget{
+						TabID:    tab.ID,
+						TabTitle: tab.Title,
+						Widget:   w,
+					})
+				}
+			}
+		}
+	}
+
+	return collection, nil
+}
+
+//tagNotFound is returned when the user has no tag with the given name
+type tagNotFound string
+
+func (err tagNotFound) IsNotFound() bool {
+	return true
+}
+func (err tagNotFound) Error() string {
+	return "unknown tag: " + string(err)
+}
+
+//findTag returns the tag of the user with the given name
+func findTag(ctx context.Context, repo api.Repository, userID string, name string) (api.Tag, error) {
+
+	tags, err := repo.GetTags(ctx, userID)
+	if err != nil {
+		return api.Tag{}, errors.Wrap(err, "retrieving tags failed")
+	}
+	for _, t := range tags {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+
+	return api.Tag{}, tagNotFound(name)
+}
+
+//retag replaces a tag in the starred items and the widgets of the user, removing it if newName is empty.
+//The collection widgets of the tag display the renamed tag. It returns the tabs whose widgets were updated.
+func retag(ctx context.Context, repo api.Repository, userID string, name string, newName string) ([]int64, error) {
+
+	items, err := repo.GetStarredItems(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving starred items failed")
+	}
+	for _, item := range items {
+		var found bool
+		item.Tags, found = replaceTag(item.Tags, name, newName)
+		if !found {
+			continue
+		}
+		err = repo.StoreStarredItem(ctx, item)
+		if err != nil {
+			return nil, errors.Wrap(err, "saving starred item failed")
+		}
+	}
+
+	//The widgets of the tabs shared with the user are not changed, their tags being the ones of the other users
+	ownedTabIDs, err := repo.GetOwnedTabIDs(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving tab ids from datastore failed")
+	}
+
+	var tabIDs []int64
+	for _, tabID := range ownedTabIDs {
+		tab, err := repo.GetTab(ctx, tabID)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving tab from datastore failed")
+		}
+
+		updated := false
+		for _, col := range tab.Widgets {
+			for _, w := range col {
+				cfg, ok := widgetConfig(w)
+				if !ok {
+					continue
+				}
+
+				var found bool
+				cfg.Tags, found = replaceTag(cfg.Tags, name, newName)
+				if found {
+					setWidgetConfig(&w, cfg)
+				}
+				if collection, ok := w.Config.(api.ConfigCollection); ok && collection.Tag == name && len(newName) > 0 {
+					collection.Tag = newName
+					w.Config = collection
+					found = true
+				}
+				if !found {
+					continue
+				}
+
+				err = repo.StoreWidget(ctx, tab.ID, &w)
+				if err != nil {
+					return nil, errors.Wrap(err, "saving widget failed")
+				}
+				updated = true
+			}
+		}
+		if updated {
+			tabIDs = append(tabIDs, tab.ID)
+		}
+	}
+
+	return tabIDs, nil
+}
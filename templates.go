@@ -0,0 +1,180 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//TabTemplate returns a tab readable by the current user as a template without personal data:
+//the email widgets display account slots instead of the accounts, and the tags are removed.
+//The widgets listing starred items, and the email widgets of accounts of other users, are not part of the template.
+func (app App) TabTemplate(ctx context.Context, tabID int64) (api.TabTemplate, error) {
+	ctx, span := tracing.Start(ctx, "App.TabTemplate")
+	defer span.End()
+
+	tab, err := app.Tab(ctx, tabID)
+	if err != nil {
+		return api.TabTemplate{}, err
+	}
+
+	userID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.TabTemplate{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	template := api.TabTemplate{
+		Title:    tab.Title,
+		Widgets:  make([][]api.Widget, 0, len(tab.Widgets)),
+		Feeds:    []api.Feed{},
+		Accounts: make(map[int64]string),
+	}
+	feeds := make(map[int64]bool)
+	slots := make(map[int64]int64)
+
+	for _, col := range tab.Widgets {
+		widgets := []api.Widget{}
+		for _, w := range col {
+			switch cfg := w.Config.(type) {
+			case api.ConfigFeed:
+				if !feeds[cfg.FeedID] {
+					feed, err := app.repository.GetFeed(ctx, cfg.FeedID)
+					if err != nil {
+						return api.TabTemplate{}, errors.Wrap(err, "retrieving feed from datastore failed")
+					}
+					template.Feeds = append(template.Feeds, api.Feed{ID: feed.ID, URL: feed.URL, Title: feed.Title})
+					feeds[cfg.FeedID] = true
+				}
+
+			case api.ConfigEmail:
+				slot, ok := slots[cfg.AccountID]
+				if !ok {
+					account, err := app.repository.GetAccount(ctx, userID, cfg.AccountID)
+					if app.repository.IsNotFound(err) {
+						continue
+					}
+					if err != nil {
+						return api.TabTemplate{}, errors.Wrap(err, "retrieving account from datastore failed")
+					}
+					slot = int64(len(slots) + 1)
+					slots[cfg.AccountID] = slot
+					template.Accounts[slot] = account.ProviderName
+				}
+				cfg.AccountID = slot
+				//The title may be the label of the account and the query may name correspondents,
+				//the widget is given the default title of its provider and the whole inbox
+				cfg.Title = ""
+				if provider, ok := app.providers[template.Accounts[slot]]; ok {
+					cfg.Title = provider.Description().Title
+				}
+				cfg.Query = ""
+				cfg.Categories = nil
+				w.Config = cfg
+
+			case api.ConfigCollection:
+				continue
+			}
+
+			if common, ok := widgetConfig(w); ok {
+				common.Tags = nil
+				setWidgetConfig(&w, common)
+			}
+			w.ID = 0
+			widgets = append(widgets, w)
+		}
+		template.Widgets = append(template.Widgets, widgets)
+	}
+
+	return template, nil
+}
+
+//ImportTemplate creates a tab owned by the given user from a template.
+//If an account slot of the template is not given an account, no tab is created and the slots to fill are returned
+//with the accounts of the user able to fill them.
+//For a managed user, no tab is created if one of the feeds is not allowed.
+func (app App) ImportTemplate(ctx context.Context, userID string, request api.TemplateImport) (api.TemplateImportResult, error) {
+	ctx, span := tracing.Start(ctx, "App.ImportTemplate")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "ImportTemplate")
+	if err != nil {
+		return api.TemplateImportResult{}, err
+	}
+
+	if err := request.Template.Validate(); err != nil {
+		return api.TemplateImportResult{}, invalidArgument("invalid template: " + err.Error())
+	}
+
+	accounts, err := app.repository.GetAccounts(ctx, userID)
+	if err != nil {
+		return api.TemplateImportResult{}, errors.Wrap(err, "retrieving accounts from datastore failed")
+	}
+
+	//The exported accounts are the keys of the chosen accounts, by slot
+	export := api.TabExport{
+		Tab:      api.Tab{TabSummary: api.TabSummary{Title: request.Template.Title}},
+		Feeds:    request.Template.Feeds,
+		Accounts: make(map[int64]string),
+	}
+	result := api.TemplateImportResult{}
+	for slot, providerName := range request.Template.Accounts {
+		accountID, ok := request.Accounts[slot]
+		if !ok {
+			missing := api.TemplateAccountSlot{Slot: slot, ProviderName: providerName, Candidates: []api.ExternalAccount{}}
+			for _, a := range accounts {
+				if a.ProviderName == providerName {
+					missing.Candidates = append(missing.Candidates, a)
+				}
+			}
+			result.Slots = append(result.Slots, missing)
+			continue
+		}
+		if accountID == 0 {
+			continue
+		}
+
+		found := false
+		for _, a := range accounts {
+			if a.ID == accountID && a.ProviderName == providerName {
+				export.Accounts[slot] = a.Key()
+				found = true
+			}
+		}
+		if !found {
+			return api.TemplateImportResult{}, invalidArgument(fmt.Sprintf("no %s account %d for slot %d", providerName, accountID, slot))
+		}
+	}
+	if len(result.Slots) > 0 {
+		sort.Slice(result.Slots, func(i, j int) bool { return result.Slots[i].Slot < result.Slots[j].Slot })
+		return result, nil
+	}
+
+	//The email widgets of the skipped slots are not created
+	for _, col := range request.Template.Widgets {
+		widgets := []api.Widget{}
+		for _, w := range col {
+			if cfg, ok := w.Config.(api.ConfigEmail); ok && len(export.Accounts[cfg.AccountID]) == 0 {
+				continue
+			}
+			widgets = append(widgets, w)
+		}
+		export.Tab.Widgets = append(export.Tab.Widgets, widgets)
+	}
+
+	tab, err := app.ImportTab(ctx, userID, export, request.Title)
+	if err != nil {
+		return api.TemplateImportResult{}, err
+	}
+	result.Tab = &tab
+
+	return result, nil
+}
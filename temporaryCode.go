@@ -0,0 +1,68 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//TemporaryCodeMaxAge is the age after which an OAuth2 state is rejected
+const TemporaryCodeMaxAge = 10 * time.Minute
+
+//temporaryCodeSize is the number of random bytes of an OAuth2 state
+const temporaryCodeSize = 32
+
+//newTemporaryCode generates a random OAuth2 state
+func newTemporaryCode() (string, error) {
+	b := make([]byte, temporaryCodeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//RunTemporaryCodeCleanup removes the expired OAuth2 states at the given interval, until the context is done
+func (app App) RunTemporaryCodeCleanup(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := app.CleanupTemporaryCodes(ctx); err != nil {
+			app.Error(ctx, errors.Wrap(err, "temporary code cleanup failed"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//CleanupTemporaryCodes removes the OAuth2 states older than TemporaryCodeMaxAge,
+//and returns the number of removed states.
+func (app App) CleanupTemporaryCodes(ctx context.Context) (int64, error) {
+	ctx, span := tracing.Start(ctx, "App.CleanupTemporaryCodes")
+	defer span.End()
+
+	count, err := app.repository.DeleteTemporaryCodesBefore(ctx, time.Now().Add(-TemporaryCodeMaxAge))
+	if err != nil {
+		return 0, errors.Wrap(err, "removing expired temporary codes from datastore failed")
+	}
+
+	if count > 0 {
+		app.Infof(ctx, "Removed %d expired temporary codes", count)
+	}
+
+	return count, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//maxThumbnailSize is the maximum size of a downloaded item image
+const maxThumbnailSize = 2 << 20
+
+//ItemThumbnail returns the image illustrating the given item of a feed.
+//Thumbnails are cached in the blob store, if any, so the image is downloaded once for all the users.
+func (app App) ItemThumbnail(ctx context.Context, feedID int64, guid string) (api.Blob, error) {
+	ctx, span := tracing.Start(ctx, "App.ItemThumbnail")
+	defer span.End()
+
+	//Check that a user is logged
+	_, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	sum := sha256.Sum256([]byte(guid))
+	key := fmt.Sprintf("thumbnails/%d/%s", feedID, hex.EncodeToString(sum[:]))
+
+	if app.blobStore != nil {
+		blob, err := app.blobStore.Get(ctx, key)
+		if err == nil {
+			return blob, nil
+		}
+		if !app.blobStore.IsNotFound(err) {
+			return api.Blob{}, errors.Wrap(err, "retrieving thumbnail from blob store failed")
+		}
+	}
+
+	//Only the images found in the stored feeds are downloaded
+	items, err := app.repository.GetFeedItems(ctx, feedID)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "retrieving feed items from datastore failed")
+	}
+	imageURL := ""
+	for _, item := range items {
+		if item.GUID == guid {
+			imageURL = item.ImageURL
+			break
+		}
+	}
+	if len(imageURL) == 0 {
+		return api.Blob{}, thumbnailNotFound(guid)
+	}
+
+	blob, err := app.fetchImage(ctx, imageURL, maxThumbnailSize)
+	if err != nil {
+		return api.Blob{}, errors.Wrap(err, "fetching thumbnail failed")
+	}
+
+	if app.blobStore != nil {
+		if err := app.blobStore.Put(ctx, key, blob); err != nil {
+			app.Error(ctx, errors.Wrap(err, "saving thumbnail in blob store failed"))
+		}
+	}
+
+	return blob, nil
+}
+
+//thumbnailNotFound is returned when the feed has no item with the given GUID or the item has no image
+type thumbnailNotFound string
+
+func (err thumbnailNotFound) IsNotFound() bool {
+	return true
+}
+func (err thumbnailNotFound) Error() string {
+	return "no image for item: " + string(err)
+}
+
+//itemImage returns the URL of the image illustrating a feed item, its image or its first image enclosure
+func itemImage(item *gofeed.Item) string {
+
+	candidates := []string{}
+	if item.Image != nil {
+		candidates = append(candidates, item.Image.URL)
+	}
+	for _, e := range item.Enclosures {
+		if e != nil && strings.HasPrefix(e.Type, "image/") {
+			candidates = append(candidates, e.URL)
+		}
+	}
+
+	for _, c := range candidates {
+		if u, err := url.Parse(c); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			return c
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//SetTimeZone sets the time zone the dates are given in to the user, given by its IANA name.
+//The dates are given in UTC if the name is empty.
+func (app App) SetTimeZone(ctx context.Context, userID string, timeZone string) (api.User, error) {
+	ctx, span := tracing.Start(ctx, "App.SetTimeZone")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "SetTimeZone")
+	if err != nil {
+		return api.User{}, err
+	}
+
+	timeZone = strings.TrimSpace(timeZone)
+	if len(timeZone) > 0 {
+		if _, err := time.LoadLocation(timeZone); err != nil {
+			return api.User{}, invalidArgument("unknown time zone: " + timeZone)
+		}
+	}
+
+	err = app.repository.SetUserTimeZone(ctx, userID, timeZone)
+	if err != nil {
+		return api.User{}, errors.Wrap(err, "saving time zone failed")
+	}
+
+	user, err := app.repository.GetUser(ctx, userID)
+	if err != nil {
+		return api.User{}, errors.Wrap(err, "retrieving user from datastore failed")
+	}
+
+	return user, nil
+}
+
+//userLocation returns the time zone of the user, UTC if not set or unknown
+func (app App) userLocation(ctx context.Context, userID string) *time.Location {
+
+	user, err := app.repository.GetUser(ctx, userID)
+	if err != nil || len(user.TimeZone) == 0 {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(user.TimeZone)
+	if err != nil {
+		app.Error(ctx, errors.Wrap(err, "loading time zone of user "+userID+" failed"))
+		return time.UTC
+	}
+
+	return loc
+}
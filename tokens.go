@@ -0,0 +1,57 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//TokenRotationReport summarizes the re-encryption of the stored tokens
+type TokenRotationReport struct {
+	Pages       int `json:"pages"`
+	Reencrypted int `json:"reencrypted"`
+}
+
+//RotateTokenKeys re-encrypts all the stored account tokens with the newest key.
+//It is run after adding a key to the configuration, and before removing an old one.
+func (app App) RotateTokenKeys(ctx context.Context) (TokenRotationReport, error) {
+	ctx, span := tracing.Start(ctx, "App.RotateTokenKeys")
+	defer span.End()
+
+	//Check that a user is logged
+	loggedInUserID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return TokenRotationReport{}, errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	if !app.userInteractor.CurrentUserIsAdmin(ctx) {
+		return TokenRotationReport{}, errors.Wrap(notAuthorized("admin access required"), "access by "+loggedInUserID)
+	}
+
+	report := TokenRotationReport{}
+	page := api.PageRequest{}
+	for {
+		count, next, err := app.repository.ReencryptTokens(ctx, page)
+		report.Reencrypted += count
+		if err != nil {
+			return report, errors.Wrap(err, "re-encrypting tokens failed")
+		}
+		report.Pages++
+
+		app.Infof(ctx, "Token rotation: %d page(s) processed, %d token(s) re-encrypted", report.Pages, report.Reencrypted)
+
+		if len(next) == 0 {
+			app.audit(ctx, "", api.AuditKeysRotated, "tokens")
+			return report, nil
+		}
+		page.Cursor = next
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Package tracing records OpenTelemetry spans of the application, the repository and the outgoing HTTP calls.
+//The spans are discarded until Setup is called.
+package tracing
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//instrumentationName identifies the tracer of okihome
+const instrumentationName = "github.com/oki-apps/okihome"
+
+//Config is the configuration of the export of the spans with the OTLP/HTTP protocol,
+//accepted by the OpenTelemetry collector and by Jaeger
+type Config struct {
+	//Endpoint is the host and port of the collector, such as "localhost:4318"
+	Endpoint string
+	//URLPath is the path receiving the spans, "/v1/traces" if empty
+	URLPath string
+	//Insecure sends the spans over HTTP instead of HTTPS
+	Insecure bool
+	//Headers are added to the export requests, such as an authentication header
+	Headers map[string]string
+	//ServiceName identifies the spans of this instance, "okihome" if empty
+	ServiceName string
+	//SampleRatio is the fraction of the traces started by this instance which are recorded, all of them if 0.
+	//The traces started by a caller follow its decision.
+	SampleRatio float64
+}
+
+//Setup exports the spans to the configured collector, and propagates the trace context in the HTTP headers.
+//The returned function flushes the pending spans, it must be called before exiting.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+
+	if len(cfg.Endpoint) == 0 {
+		return nil, errors.New("missing tracing endpoint")
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, errors.Errorf("invalid tracing sample ratio %v", cfg.SampleRatio)
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+	if len(cfg.URLPath) > 0 {
+		opts = append(opts, otlptracehttp.WithURLPath(cfg.URLPath))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "Unable to create span exporter")
+	}
+
+	serviceName := cfg.ServiceName
+	if len(serviceName) == 0 {
+		serviceName = "okihome"
+	}
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+//Start starts a span as a child of the span of the context, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+//End ends the span, flagging it as failed if err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
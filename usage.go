@@ -0,0 +1,80 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//DefaultUsageDays is the number of days of the usage statistics, if not given
+const DefaultUsageDays = 30
+
+//MaxUsageDays is the longest period of the usage statistics
+const MaxUsageDays = 366
+
+//recordUsage adds the counts to the usage of the user for today.
+//The failures are only logged, the statistics not being worth failing the request.
+func (app App) recordUsage(ctx context.Context, userID string, usage api.Usage) {
+	if err := app.repository.AddUsage(ctx, userID, time.Now(), usage); err != nil {
+		app.Error(ctx, errors.Wrap(err, "recording usage failed"))
+	}
+}
+
+//UsageStats returns the reading activity of the user by day, over the given number of days including today
+func (app App) UsageStats(ctx context.Context, userID string, days int) (api.UsageStats, error) {
+	ctx, span := tracing.Start(ctx, "App.UsageStats")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "UsageStats")
+	if err != nil {
+		return api.UsageStats{}, err
+	}
+
+	return app.usageStats(ctx, userID, days)
+}
+
+//AggregateUsage returns the reading activity of all the users by day, over the given number of days including today.
+//Only an admin can retrieve it.
+func (app App) AggregateUsage(ctx context.Context, days int) (api.UsageStats, error) {
+	ctx, span := tracing.Start(ctx, "App.AggregateUsage")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return api.UsageStats{}, err
+	}
+
+	return app.usageStats(ctx, "", days)
+}
+
+func (app App) usageStats(ctx context.Context, userID string, days int) (api.UsageStats, error) {
+
+	if days <= 0 {
+		days = DefaultUsageDays
+	}
+	if days > MaxUsageDays {
+		return api.UsageStats{}, invalidArgument("usage statistics are limited to the last 366 days")
+	}
+
+	now := time.Now().UTC()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1-days)
+	daily, err := app.repository.GetUsage(ctx, userID, since)
+	if err != nil {
+		return api.UsageStats{}, errors.Wrap(err, "retrieving usage from datastore failed")
+	}
+
+	stats := api.UsageStats{Since: since, Days: daily}
+	for _, d := range daily {
+		stats.Total = stats.Total.Add(d.Usage)
+	}
+
+	return stats, nil
+}
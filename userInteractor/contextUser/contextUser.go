@@ -6,27 +6,74 @@ package contextUser
 
 import (
 	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
 
 	"github.com/oki-apps/okihome/api"
 	"github.com/oki-apps/server"
 )
 
+//Config maps the claims of the OpenID Connect provider to the user information.
+//The zero value keeps the user information given by the server.
+type Config struct {
+	//IDClaim, DisplayNameClaim and EmailClaim are the claims holding the user information (such as "preferred_username").
+	//Nested claims are separated by dots. The information given by the server is used if empty.
+	IDClaim          string
+	DisplayNameClaim string
+	EmailClaim       string
+
+	//GroupsClaim is the claim listing the groups of the user (such as "groups" or "realm_access.roles")
+	GroupsClaim string
+	//AdminGroups are the groups whose members are administrators
+	AdminGroups []string
+	//Admins are the IDs of the administrators, "admin" if empty
+	Admins []string
+}
+
+//claimsHolder is implemented by the user information giving access to all the claims of the ID token
+type claimsHolder interface {
+	Claims(v interface{}) error
+}
+
 type interactor struct {
+	cfg Config
 }
 
 //New creates a new user interactor compatible with server storing the current user in the context
-func New() api.UserInteractor {
-	return &interactor{}
+func New(cfg Config) api.UserInteractor {
+	if len(cfg.Admins) == 0 {
+		cfg.Admins = []string{"admin"}
+	}
+	return &interactor{cfg: cfg}
 }
 
 //CurrentUserIsAdmin returns true if the current user is an administrator
 func (i *interactor) CurrentUserIsAdmin(ctx context.Context) bool {
-	userID, err := i.CurrentUserID(ctx)
+	u, err := i.CurrentUser(ctx)
 	if err != nil {
 		return false
 	}
 
-	return userID == "admin"
+	for _, admin := range i.cfg.Admins {
+		if u.ID() == admin {
+			return true
+		}
+	}
+
+	if mu, ok := u.(mappedUser); ok {
+		for _, group := range mu.groups {
+			for _, admin := range i.cfg.AdminGroups {
+				if group == admin {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
 }
 
 //CurrentUserID returns the info of the current user.
@@ -42,5 +89,153 @@ func (i *interactor) CurrentUserID(ctx context.Context) (string, error) {
 //CurrentUserID returns the info of the current user.
 //Returns an nil value if not logged in.
 func (i *interactor) CurrentUser(ctx context.Context) (api.UserInfo, error) {
-	return server.GetUserInfo(ctx)
+	if user, ok := api.UserFromContext(ctx); ok {
+		return user, nil
+	}
+	u, err := server.GetUserInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	mu, err := i.mapClaims(u)
+	if err != nil {
+		return nil, errors.Wrap(err, "mapping the claims of "+u.ID()+" failed")
+	}
+	return mu, nil
+}
+
+//mapClaims returns the user information read from the configured claims, when the server gives access to them
+func (i *interactor) mapClaims(u api.UserInfo) (api.UserInfo, error) {
+	if len(i.cfg.IDClaim) == 0 && len(i.cfg.DisplayNameClaim) == 0 && len(i.cfg.EmailClaim) == 0 && len(i.cfg.GroupsClaim) == 0 {
+		return u, nil
+	}
+
+	holder, ok := u.(claimsHolder)
+	if !ok {
+		return nil, errors.Errorf("the user information %T gives no access to the claims", u)
+	}
+
+	var claims map[string]interface{}
+	if err := holder.Claims(&claims); err != nil {
+		return nil, errors.Wrap(err, "reading the claims failed")
+	}
+
+	mu := mappedUser{
+		id:          u.ID(),
+		displayName: u.DisplayName(),
+		email:       u.Email(),
+	}
+	for _, c := range []struct {
+		name  string
+		value *string
+	}{
+		{i.cfg.IDClaim, &mu.id},
+		{i.cfg.DisplayNameClaim, &mu.displayName},
+		{i.cfg.EmailClaim, &mu.email},
+	} {
+		v, ok, err := claimString(claims, c.name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			*c.value = v
+		}
+	}
+
+	groups, err := claimList(claims, i.cfg.GroupsClaim)
+	if err != nil {
+		return nil, err
+	}
+	mu.groups = groups
+
+	return mu, nil
+}
+
+//claim returns the value of a claim, the names of the nested claims being separated by dots
+func claim(claims map[string]interface{}, name string) (interface{}, bool) {
+	if len(name) == 0 {
+		return nil, false
+	}
+
+	var value interface{} = claims
+	for _, part := range strings.Split(name, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+//claimString returns the value of a claim holding a string, a number or a boolean
+func claimString(claims map[string]interface{}, name string) (string, bool, error) {
+	value, ok := claim(claims, name)
+	if !ok || value == nil {
+		return "", false, nil
+	}
+	s, err := scalarString(value)
+	if err != nil {
+		return "", false, errors.Wrap(err, "unsupported claim "+name)
+	}
+	return s, len(s) > 0, nil
+}
+
+//claimList returns the values of a claim holding either a list or space-separated values
+func claimList(claims map[string]interface{}, name string) ([]string, error) {
+	value, ok := claim(claims, name)
+	if !ok || value == nil {
+		return nil, nil
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, e := range v {
+			s, err := scalarString(e)
+			if err != nil {
+				return nil, errors.Wrap(err, "unsupported claim "+name)
+			}
+			list = append(list, s)
+		}
+		return list, nil
+	case string:
+		return strings.Fields(v), nil
+	}
+	return nil, errors.Errorf("unsupported claim %s: %T is not a list", name, value)
+}
+
+//scalarString formats the value of a claim, the numbers without exponent as they are often identifiers
+func scalarString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", errors.Errorf("%T is not a string, a number or a boolean", value)
+}
+
+//mappedUser is the user information read from the configured claims
+type mappedUser struct {
+	id          string
+	displayName string
+	email       string
+	groups      []string
+}
+
+func (u mappedUser) ID() string {
+	return u.id
+}
+func (u mappedUser) DisplayName() string {
+	return u.displayName
+}
+func (u mappedUser) Email() string {
+	return u.email
 }
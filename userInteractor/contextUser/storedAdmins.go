@@ -0,0 +1,40 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package contextUser
+
+import (
+	"context"
+
+	"github.com/oki-apps/okihome/api"
+)
+
+type storedAdmins struct {
+	api.UserInteractor
+	repo api.Repository
+}
+
+//WithStoredAdmins returns a user interactor for which the users flagged as administrators in the repository
+//(such as by okihome-admin) are administrators, along with the administrators of the given interactor
+func WithStoredAdmins(i api.UserInteractor, repo api.Repository) api.UserInteractor {
+	return storedAdmins{UserInteractor: i, repo: repo}
+}
+
+//CurrentUserIsAdmin returns true if the current user is an administrator
+func (i storedAdmins) CurrentUserIsAdmin(ctx context.Context) bool {
+	if i.UserInteractor.CurrentUserIsAdmin(ctx) {
+		return true
+	}
+
+	userID, err := i.CurrentUserID(ctx)
+	if err != nil {
+		return false
+	}
+	user, err := i.repo.GetUser(ctx, userID)
+	if err != nil {
+		return false
+	}
+
+	return user.IsAdmin
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//WatchRenewalInterval is the interval at which the change notifications of the accounts are renewed.
+//Gmail stops the notifications 7 days after the last watch, and recommends renewing it daily.
+const WatchRenewalInterval = 24 * time.Hour
+
+//RunWatchRenewal renews the change notifications of all the accounts at the given interval, until the context is done
+func (app App) RunWatchRenewal(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := app.RenewWatches(ctx); err != nil {
+			app.Error(ctx, errors.Wrap(err, "renewal of change notifications failed"))
+		}
+	}
+}
+
+//RenewWatches renews the change notifications of the accounts whose provider sends them.
+//A failure on an account does not prevent the renewal of the others.
+func (app App) RenewWatches(ctx context.Context) error {
+	ctx, span := tracing.Start(ctx, "App.RenewWatches")
+	defer span.End()
+
+	var renewed, failures int
+
+	page := api.PageRequest{}
+	for {
+		accounts, next, err := app.repository.GetAccountsPage(ctx, "", page)
+		if err != nil {
+			return errors.Wrap(err, "retrieving accounts from datastore failed")
+		}
+
+		for _, account := range accounts {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			//The token was revoked, the account is watched again once authorized
+			if account.NeedsReauth {
+				continue
+			}
+			pushProvider, err := app.getPushProvider(account.ProviderName)
+			if err != nil {
+				continue
+			}
+
+			expiration, err := pushProvider.Watch(ctx, account)
+			if err != nil {
+				app.Error(ctx, errors.Wrap(err, "watching account "+account.Key()+" failed"))
+				failures++
+				continue
+			}
+			if !expiration.IsZero() {
+				renewed++
+			}
+		}
+
+		if len(next) == 0 {
+			break
+		}
+		page.Cursor = next
+	}
+
+	app.Infof(ctx, "Change notifications of %d account(s) renewed", renewed)
+	if failures > 0 {
+		return errors.Errorf("change notifications of %d account(s) not renewed", failures)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//WidgetConfigUpgradeReport summarizes the migration of the stored widget configs
+type WidgetConfigUpgradeReport struct {
+	Pages    int `json:"pages"`
+	Upgraded int `json:"upgraded"`
+}
+
+//UpgradeWidgetConfigs migrates all the stored widget configs to the current version of their type.
+//Configs are also migrated lazily when read, this is used before removing support of an old version.
+func (app App) UpgradeWidgetConfigs(ctx context.Context) (WidgetConfigUpgradeReport, error) {
+	ctx, span := tracing.Start(ctx, "App.UpgradeWidgetConfigs")
+	defer span.End()
+
+	err := app.checkAdmin(ctx)
+	if err != nil {
+		return WidgetConfigUpgradeReport{}, err
+	}
+
+	report := WidgetConfigUpgradeReport{}
+	page := api.PageRequest{}
+	for {
+		count, next, err := app.repository.UpgradeWidgetConfigs(ctx, page)
+		report.Upgraded += count
+		if err != nil {
+			return report, errors.Wrap(err, "upgrading widget configs failed")
+		}
+		report.Pages++
+
+		app.Infof(ctx, "Widget config upgrade: %d page(s) processed, %d config(s) upgraded", report.Pages, report.Upgraded)
+
+		if len(next) == 0 {
+			return report, nil
+		}
+		page.Cursor = next
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2017 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okihome
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/oki-apps/okihome/api"
+	"github.com/oki-apps/okihome/tracing"
+)
+
+//StaleWidgetAge is the default duration after which a widget not viewed is suggested for removal
+const StaleWidgetAge = 90 * 24 * time.Hour
+
+//RecordWidgetViews records that the current user displayed the given widgets of a tab.
+//The unknown widgets are ignored, as the beacon may be sent after their deletion.
+func (app App) RecordWidgetViews(ctx context.Context, tabID int64, widgetIDs []int64) error {
+	ctx, span := tracing.Start(ctx, "App.RecordWidgetViews")
+	defer span.End()
+
+	//Check that a user is logged
+	userID, err := app.userInteractor.CurrentUserID(ctx)
+	if err != nil {
+		return errors.Wrap(err, "retrieving current user failed")
+	}
+
+	//Check authorization
+	err = app.repository.IsTabAccessAllowed(ctx, userID, tabID)
+	if err != nil {
+		return errors.Wrap(err, "access by "+userID)
+	}
+
+	tab, err := app.repository.GetTab(ctx, tabID)
+	if err != nil {
+		return errors.Wrap(err, "retrieving tab from datastore failed")
+	}
+	known := make(map[int64]bool)
+	for _, col := range tab.Widgets {
+		for _, w := range col {
+			known[w.ID] = true
+		}
+	}
+
+	now := time.Now()
+	viewed := int64(0)
+	for _, widgetID := range widgetIDs {
+		if !known[widgetID] {
+			continue
+		}
+		viewed++
+		err = app.repository.RecordWidgetView(ctx, api.WidgetView{
+			UserID:     userID,
+			TabID:      tabID,
+			WidgetID:   widgetID,
+			Renders:    1,
+			LastViewed: now,
+		})
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("recording view of widget %d failed", widgetID))
+		}
+	}
+	if viewed > 0 {
+		app.recordUsage(ctx, userID, api.Usage{WidgetViews: viewed})
+	}
+
+	return nil
+}
+
+//startWidgetTracking records a view without render for a widget of the user, so that it is not
+//reported as stale before having been displayed for a while
+func (app App) startWidgetTracking(ctx context.Context, userID string, tabID int64, widgetID int64, now time.Time) error {
+	return app.repository.RecordWidgetView(ctx, api.WidgetView{
+		UserID:     userID,
+		TabID:      tabID,
+		WidgetID:   widgetID,
+		LastViewed: now,
+	})
+}
+
+//StaleWidgets returns the widgets of the user not viewed since the given duration, least recently viewed first.
+//The widgets never tracked, such as the restored ones, start being tracked and are not reported.
+func (app App) StaleWidgets(ctx context.Context, userID string, olderThan time.Duration) ([]api.StaleWidget, error) {
+	ctx, span := tracing.Start(ctx, "App.StaleWidgets")
+	defer span.End()
+
+	err := app.checkUserAccess(ctx, userID, "StaleWidgets")
+	if err != nil {
+		return nil, err
+	}
+	if olderThan <= 0 {
+		olderThan = StaleWidgetAge
+	}
+
+	views, err := app.repository.GetWidgetViews(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving widget views failed")
+	}
+	type ref struct{ tabID, widgetID int64 }
+	viewed := make(map[ref]api.WidgetView)
+	for _, v := range views {
+		viewed[ref{v.TabID, v.WidgetID}] = v
+	}
+
+	tabs, err := app.repository.GetTabs(ctx, userID)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving tab ids from datastore failed")
+	}
+
+	now := time.Now()
+	stale := []api.StaleWidget{}
+	for _, t := range tabs {
+		tab, err := app.repository.GetTab(ctx, t.ID)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving tab from datastore failed")
+		}
+
+		for _, col := range tab.Widgets {
+			for _, w := range col {
+				v, ok := viewed[ref{tab.ID, w.ID}]
+				if !ok {
+					if err := app.startWidgetTracking(ctx, userID, tab.ID, w.ID, now); err != nil {
+						return nil, errors.Wrap(err, "recording widget view failed")
+					}
+					continue
+				}
+				if now.Sub(v.LastViewed) < olderThan {
+					continue
+				}
+
+				stale = append(stale, api.StaleWidget{
+					TabID:      tab.ID,
+					TabTitle:   tab.Title,
+					Widget:     w,
+					Renders:    v.Renders,
+					LastViewed: v.LastViewed,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		return stale[i].LastViewed.Before(stale[j].LastViewed)
+	})
+
+	return stale, nil
+}
+
+//viewedSources returns the IDs of the feeds and of the accounts displayed in a widget viewed during the given duration.
+//The widgets not tracked yet are considered as just viewed.
+func (app App) viewedSources(ctx context.Context, idleAfter time.Duration) (map[int64]bool, map[int64]bool, error) {
+
+	widgets, err := app.repository.GetViewedWidgets(ctx, time.Now().Add(-idleAfter))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "retrieving viewed widgets failed")
+	}
+
+	feeds := make(map[int64]bool)
+	accounts := make(map[int64]bool)
+	for _, w := range widgets {
+		if feedID := w.FeedID(); feedID > 0 {
+			feeds[feedID] = true
+		}
+		if cfg, ok := w.Config.(api.ConfigEmail); ok {
+			accounts[cfg.AccountID] = true
+		}
+	}
+
+	return feeds, accounts, nil
+}